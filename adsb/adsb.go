@@ -0,0 +1,297 @@
+// Package adsb implements a bridge that feeds air traffic, received by an
+// ADS-B receiver, to autopilots through ADSB_VEHICLE messages, in order to
+// allow them to avoid manned aircraft.
+//
+// Traffic can be read from SBS-1 (BaseStation) feeds, that are provided by
+// most receivers on TCP port 30003, and from the JSON files of dump1090 and
+// its forks (aircraft.json). Partial updates of each aircraft are merged,
+// and the state of every aircraft with a known position is written
+// periodically to all channels of the node.
+//
+// The node used by the package must use a dialect that contains the common
+// messages.
+package adsb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	feetToMeters = 0.3048
+	knotsToMps   = 0.514444
+	fpmToMps     = feetToMeters / 60
+)
+
+// Aircraft is the state of an aircraft.
+type Aircraft struct {
+	// the ICAO address.
+	ICAO uint32
+
+	// the callsign.
+	Callsign string
+
+	// the latitude, in degrees.
+	Latitude float64
+
+	// the longitude, in degrees.
+	Longitude float64
+
+	// the altitude, in meters.
+	Altitude float64
+
+	// the altitude type.
+	AltitudeType common.ADSB_ALTITUDE_TYPE
+
+	// the course over ground, in degrees.
+	Heading float64
+
+	// the horizontal speed, in meters per second.
+	Groundspeed float64
+
+	// the vertical speed, in meters per second. Positive is up.
+	VerticalSpeed float64
+
+	// the squawk code, i.e. 7700.
+	Squawk uint16
+
+	// the emitter type.
+	EmitterType common.ADSB_EMITTER_TYPE
+
+	// the fields that are valid.
+	Valid common.ADSB_FLAGS
+
+	// the time at which the aircraft has been seen the last time.
+	LastSeen time.Time
+}
+
+// merge copies the valid fields of an update into the aircraft.
+func (a *Aircraft) merge(u *Aircraft) {
+	if (u.Valid & common.ADSB_FLAGS_VALID_CALLSIGN) != 0 {
+		a.Callsign = u.Callsign
+	}
+	if (u.Valid & common.ADSB_FLAGS_VALID_COORDS) != 0 {
+		a.Latitude = u.Latitude
+		a.Longitude = u.Longitude
+	}
+	if (u.Valid & common.ADSB_FLAGS_VALID_ALTITUDE) != 0 {
+		a.Altitude = u.Altitude
+		a.AltitudeType = u.AltitudeType
+		a.Valid &^= common.ADSB_FLAGS_BARO_VALID
+	}
+	if (u.Valid & common.ADSB_FLAGS_VALID_HEADING) != 0 {
+		a.Heading = u.Heading
+	}
+	if (u.Valid & common.ADSB_FLAGS_VALID_VELOCITY) != 0 {
+		a.Groundspeed = u.Groundspeed
+	}
+	if (u.Valid & common.ADSB_FLAGS_VERTICAL_VELOCITY_VALID) != 0 {
+		a.VerticalSpeed = u.VerticalSpeed
+	}
+	if (u.Valid & common.ADSB_FLAGS_VALID_SQUAWK) != 0 {
+		a.Squawk = u.Squawk
+	}
+	if u.EmitterType != common.ADSB_EMITTER_TYPE_NO_INFO {
+		a.EmitterType = u.EmitterType
+	}
+
+	a.Valid |= u.Valid
+
+	if u.LastSeen.After(a.LastSeen) {
+		a.LastSeen = u.LastSeen
+	}
+}
+
+func clamp(v float64, min float64, max float64) float64 {
+	return math.Max(min, math.Min(max, math.Round(v)))
+}
+
+// Encode converts the aircraft into an ADSB_VEHICLE message. The time since
+// the last contact is computed with the given time.
+func (a Aircraft) Encode(now time.Time) *common.MessageAdsbVehicle {
+	heading := math.Mod(a.Heading, 360)
+	if heading < 0 {
+		heading += 360
+	}
+
+	callsign := strings.TrimSpace(a.Callsign)
+	if len(callsign) > 8 {
+		callsign = callsign[:8]
+	}
+
+	return &common.MessageAdsbVehicle{
+		IcaoAddress:  a.ICAO,
+		Lat:          int32(clamp(a.Latitude*1e7, math.MinInt32, math.MaxInt32)),
+		Lon:          int32(clamp(a.Longitude*1e7, math.MinInt32, math.MaxInt32)),
+		AltitudeType: a.AltitudeType,
+		Altitude:     int32(clamp(a.Altitude*1000, math.MinInt32, math.MaxInt32)),
+		Heading:      uint16(clamp(heading*100, 0, 35999)),
+		HorVelocity:  uint16(clamp(a.Groundspeed*100, 0, math.MaxUint16)),
+		VerVelocity:  int16(clamp(a.VerticalSpeed*100, math.MinInt16, math.MaxInt16)),
+		Callsign:     callsign,
+		EmitterType:  a.EmitterType,
+		Tslc:         uint8(clamp(now.Sub(a.LastSeen).Seconds(), 0, math.MaxUint8)),
+		Flags:        a.Valid,
+		Squawk:       a.Squawk,
+	}
+}
+
+// Conf allows to configure a Bridge.
+type Conf struct {
+	// the node used to write messages.
+	Node *gomavlib.Node
+
+	// (optional) the rate at which the state of each aircraft is written.
+	// It defaults to 1Hz.
+	Rate float64
+
+	// (optional) the time after which aircraft that have not been seen are
+	// removed. It defaults to 20 seconds.
+	Timeout time.Duration
+
+	// (optional) a function that decides whether an aircraft is written,
+	// i.e. to discard aircraft that are too far from the vehicle.
+	Filter func(a Aircraft) bool
+}
+
+// Bridge writes the state of aircraft as ADSB_VEHICLE messages.
+type Bridge struct {
+	conf Conf
+
+	mutex    sync.Mutex
+	aircraft map[uint32]*Aircraft
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Bridge. See Conf for the options.
+func New(conf Conf) (*Bridge, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageAdsbVehicle{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Rate < 0 {
+		return nil, fmt.Errorf("Rate must be >= 0")
+	}
+	if conf.Rate == 0 {
+		conf.Rate = 1
+	}
+
+	if conf.Timeout < 0 {
+		return nil, fmt.Errorf("Timeout must be >= 0")
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 20 * time.Second
+	}
+
+	b := &Bridge{
+		conf:      conf,
+		aircraft:  make(map[uint32]*Aircraft),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go b.run()
+
+	return b, nil
+}
+
+// Close stops the bridge. It must be called before closing the node.
+func (b *Bridge) Close() {
+	close(b.terminate)
+	<-b.done
+}
+
+// Update merges an update of an aircraft into its state. Only the fields
+// that are marked as valid are copied. If LastSeen is zero, it is filled
+// with the current time.
+func (b *Bridge) Update(u Aircraft) {
+	if u.LastSeen.IsZero() {
+		u.LastSeen = time.Now()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	a, ok := b.aircraft[u.ICAO]
+	if !ok {
+		a = &Aircraft{ICAO: u.ICAO}
+		b.aircraft[u.ICAO] = a
+	}
+	a.merge(&u)
+}
+
+// Aircraft returns the state of the known aircraft, sorted by ICAO address.
+func (b *Bridge) Aircraft() []Aircraft {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ret := make([]Aircraft, 0, len(b.aircraft))
+	for _, a := range b.aircraft {
+		ret = append(ret, *a)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ICAO < ret[j].ICAO
+	})
+
+	return ret
+}
+
+func (b *Bridge) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / b.conf.Rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			b.emit(now)
+
+		case <-b.terminate:
+			return
+		}
+	}
+}
+
+func (b *Bridge) emit(now time.Time) {
+	b.mutex.Lock()
+	for icao, a := range b.aircraft {
+		if now.Sub(a.LastSeen) >= b.conf.Timeout {
+			delete(b.aircraft, icao)
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, a := range b.Aircraft() {
+		// autopilots can't use aircraft without a position
+		if (a.Valid & common.ADSB_FLAGS_VALID_COORDS) == 0 {
+			continue
+		}
+
+		if b.conf.Filter != nil && !b.conf.Filter(a) {
+			continue
+		}
+
+		b.conf.Node.WriteMessageAll(a.Encode(now))
+	}
+}
@@ -0,0 +1,154 @@
+package adsb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestAircraftEncode(t *testing.T) {
+	now := time.Now()
+
+	a := Aircraft{
+		ICAO:          0x4CA2D6,
+		Callsign:      "RYR1AB  ",
+		Latitude:      45.4642,
+		Longitude:     -9.19,
+		Altitude:      1000,
+		AltitudeType:  common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH,
+		Heading:       -90,
+		Groundspeed:   120.5,
+		VerticalSpeed: -5.08,
+		Squawk:        7700,
+		EmitterType:   common.ADSB_EMITTER_TYPE_LARGE,
+		Valid:         common.ADSB_FLAGS_VALID_COORDS | common.ADSB_FLAGS_VALID_ALTITUDE,
+		LastSeen:      now.Add(-3 * time.Second),
+	}
+
+	require.Equal(t, &common.MessageAdsbVehicle{
+		IcaoAddress:  0x4CA2D6,
+		Lat:          454642000,
+		Lon:          -91900000,
+		AltitudeType: common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH,
+		Altitude:     1000000,
+		Heading:      27000,
+		HorVelocity:  12050,
+		VerVelocity:  -508,
+		Callsign:     "RYR1AB",
+		EmitterType:  common.ADSB_EMITTER_TYPE_LARGE,
+		Tslc:         3,
+		Flags:        common.ADSB_FLAGS_VALID_COORDS | common.ADSB_FLAGS_VALID_ALTITUDE,
+		Squawk:       7700,
+	}, a.Encode(now))
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{})
+	require.EqualError(t, err, "Node not provided")
+}
+
+func TestBridge(t *testing.T) {
+	connA, connB := net.Pipe()
+
+	// the autopilot uses a different dialect than the bridge
+	autopilot, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          ardupilotmega.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      1,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: connA}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer autopilot.Close()
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      2,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: connB}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	b, err := New(Conf{
+		Node:    node,
+		Rate:    20,
+		Timeout: 500 * time.Millisecond,
+		Filter: func(a Aircraft) bool {
+			return a.ICAO != 0x333333
+		},
+	})
+	require.NoError(t, err)
+	defer b.Close()
+
+	// position and velocity are received in separate updates
+	b.Update(Aircraft{
+		ICAO:      0x111111,
+		Latitude:  45,
+		Longitude: 9,
+		Valid:     common.ADSB_FLAGS_VALID_COORDS,
+	})
+	b.Update(Aircraft{
+		ICAO:        0x111111,
+		Heading:     180,
+		Groundspeed: 100,
+		Valid:       common.ADSB_FLAGS_VALID_VELOCITY | common.ADSB_FLAGS_VALID_HEADING,
+	})
+
+	// aircraft without a position are not written
+	b.Update(Aircraft{
+		ICAO:     0x222222,
+		Callsign: "NOPOS",
+		Valid:    common.ADSB_FLAGS_VALID_CALLSIGN,
+	})
+
+	// aircraft discarded by the filter are not written
+	b.Update(Aircraft{
+		ICAO:      0x333333,
+		Latitude:  46,
+		Longitude: 10,
+		Valid:     common.ADSB_FLAGS_VALID_COORDS,
+	})
+
+	require.Equal(t, 3, len(b.Aircraft()))
+	require.Equal(t, uint32(0x111111), b.Aircraft()[0].ICAO)
+
+	for evt := range autopilot.Events() {
+		fr, ok := evt.(*gomavlib.EventFrame)
+		if !ok {
+			continue
+		}
+
+		msg, ok := fr.Message().(*ardupilotmega.MessageAdsbVehicle)
+		require.Equal(t, true, ok)
+		require.Equal(t, uint32(0x111111), msg.IcaoAddress)
+		require.Equal(t, int32(450000000), msg.Lat)
+		require.Equal(t, uint16(18000), msg.Heading)
+		require.Equal(t, uint16(10000), msg.HorVelocity)
+		require.Equal(t, ardupilotmega.ADSB_FLAGS_VALID_COORDS|
+			ardupilotmega.ADSB_FLAGS_VALID_VELOCITY|
+			ardupilotmega.ADSB_FLAGS_VALID_HEADING, msg.Flags)
+		break
+	}
+
+	go func() {
+		for range autopilot.Events() {
+		}
+	}()
+
+	// aircraft that are not seen anymore are removed
+	time.Sleep(700 * time.Millisecond)
+	require.Equal(t, 0, len(b.Aircraft()))
+}
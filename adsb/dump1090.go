@@ -0,0 +1,229 @@
+package adsb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+var emitterTypes = map[string]common.ADSB_EMITTER_TYPE{
+	"A1": common.ADSB_EMITTER_TYPE_LIGHT,
+	"A2": common.ADSB_EMITTER_TYPE_SMALL,
+	"A3": common.ADSB_EMITTER_TYPE_LARGE,
+	"A4": common.ADSB_EMITTER_TYPE_HIGH_VORTEX_LARGE,
+	"A5": common.ADSB_EMITTER_TYPE_HEAVY,
+	"A6": common.ADSB_EMITTER_TYPE_HIGHLY_MANUV,
+	"A7": common.ADSB_EMITTER_TYPE_ROTOCRAFT,
+	"B1": common.ADSB_EMITTER_TYPE_GLIDER,
+	"B2": common.ADSB_EMITTER_TYPE_LIGHTER_AIR,
+	"B3": common.ADSB_EMITTER_TYPE_PARACHUTE,
+	"B4": common.ADSB_EMITTER_TYPE_ULTRA_LIGHT,
+	"B6": common.ADSB_EMITTER_TYPE_UAV,
+	"B7": common.ADSB_EMITTER_TYPE_SPACE,
+	"C1": common.ADSB_EMITTER_TYPE_EMERGENCY_SURFACE,
+	"C2": common.ADSB_EMITTER_TYPE_SERVICE_SURFACE,
+	"C3": common.ADSB_EMITTER_TYPE_POINT_OBSTACLE,
+	"C4": common.ADSB_EMITTER_TYPE_POINT_OBSTACLE,
+	"C5": common.ADSB_EMITTER_TYPE_POINT_OBSTACLE,
+}
+
+// dump1090Aircraft is an entry of aircraft.json. Fields of both recent
+// versions (readsb, dump1090-fa) and legacy versions are listed.
+type dump1090Aircraft struct {
+	Hex      string      `json:"hex"`
+	Flight   *string     `json:"flight"`
+	AltBaro  interface{} `json:"alt_baro"`
+	AltGeom  *float64    `json:"alt_geom"`
+	Altitude interface{} `json:"altitude"`
+	Gs       *float64    `json:"gs"`
+	Speed    *float64    `json:"speed"`
+	Track    *float64    `json:"track"`
+	Lat      *float64    `json:"lat"`
+	Lon      *float64    `json:"lon"`
+	BaroRate *float64    `json:"baro_rate"`
+	GeomRate *float64    `json:"geom_rate"`
+	VertRate *float64    `json:"vert_rate"`
+	Squawk   *string     `json:"squawk"`
+	Category *string     `json:"category"`
+	Seen     *float64    `json:"seen"`
+}
+
+type dump1090File struct {
+	Aircraft []dump1090Aircraft `json:"aircraft"`
+}
+
+// altitude values can be numbers or "ground".
+func dump1090Altitude(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+// ParseDump1090 parses an aircraft.json file produced by dump1090 or by one
+// of its forks. Entries without an ICAO address (i.e. TIS-B tracks) are
+// skipped. LastSeen is computed from the age of each entry and from the
+// given time.
+func ParseDump1090(byts []byte, now time.Time) ([]Aircraft, error) {
+	var f dump1090File
+	err := json.Unmarshal(byts, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Aircraft
+
+	for _, e := range f.Aircraft {
+		// addresses that start with ~ are not ICAO addresses
+		if strings.HasPrefix(e.Hex, "~") {
+			continue
+		}
+
+		icao, err := strconv.ParseUint(strings.TrimSpace(e.Hex), 16, 24)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ICAO address '%s'", e.Hex)
+		}
+
+		a := Aircraft{
+			ICAO:     uint32(icao),
+			LastSeen: now,
+		}
+
+		if e.Seen != nil {
+			a.LastSeen = now.Add(-time.Duration(*e.Seen * float64(time.Second)))
+		}
+
+		if e.Flight != nil && strings.TrimSpace(*e.Flight) != "" {
+			a.Callsign = strings.TrimSpace(*e.Flight)
+			a.Valid |= common.ADSB_FLAGS_VALID_CALLSIGN
+		}
+
+		if v, ok := dump1090Altitude(e.AltBaro); ok {
+			a.Altitude = v * feetToMeters
+			a.AltitudeType = common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH
+			a.Valid |= common.ADSB_FLAGS_VALID_ALTITUDE | common.ADSB_FLAGS_BARO_VALID
+		} else if v, ok := dump1090Altitude(e.Altitude); ok {
+			a.Altitude = v * feetToMeters
+			a.AltitudeType = common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH
+			a.Valid |= common.ADSB_FLAGS_VALID_ALTITUDE | common.ADSB_FLAGS_BARO_VALID
+		} else if e.AltGeom != nil {
+			a.Altitude = *e.AltGeom * feetToMeters
+			a.AltitudeType = common.ADSB_ALTITUDE_TYPE_GEOMETRIC
+			a.Valid |= common.ADSB_FLAGS_VALID_ALTITUDE
+		}
+
+		gs := e.Gs
+		if gs == nil {
+			gs = e.Speed
+		}
+		if gs != nil && e.Track != nil {
+			a.Groundspeed = *gs * knotsToMps
+			a.Heading = *e.Track
+			a.Valid |= common.ADSB_FLAGS_VALID_VELOCITY | common.ADSB_FLAGS_VALID_HEADING
+		}
+
+		if e.Lat != nil && e.Lon != nil {
+			a.Latitude = *e.Lat
+			a.Longitude = *e.Lon
+			a.Valid |= common.ADSB_FLAGS_VALID_COORDS
+		}
+
+		rate := e.BaroRate
+		if rate == nil {
+			rate = e.GeomRate
+		}
+		if rate == nil {
+			rate = e.VertRate
+		}
+		if rate != nil {
+			a.VerticalSpeed = *rate * fpmToMps
+			a.Valid |= common.ADSB_FLAGS_VERTICAL_VELOCITY_VALID
+		}
+
+		if e.Squawk != nil {
+			v, err := strconv.ParseUint(*e.Squawk, 10, 16)
+			if err == nil {
+				a.Squawk = uint16(v)
+				a.Valid |= common.ADSB_FLAGS_VALID_SQUAWK
+			}
+		}
+
+		if e.Category != nil {
+			a.EmitterType = emitterTypes[*e.Category]
+		}
+
+		ret = append(ret, a)
+	}
+
+	return ret, nil
+}
+
+// ReadDump1090 reads an aircraft.json file produced by dump1090 or by one of
+// its forks, and updates the aircraft.
+func (b *Bridge) ReadDump1090(r io.Reader) error {
+	byts, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	aircraft, err := ParseDump1090(byts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, a := range aircraft {
+		b.Update(a)
+	}
+
+	return nil
+}
+
+// PollDump1090 downloads periodically an aircraft.json file from the web
+// server of dump1090 (i.e. http://receiver/dump1090/data/aircraft.json), and
+// updates the aircraft. It returns when the context is canceled or when a
+// download fails.
+func (b *Bridge) PollDump1090(ctx context.Context, url string, period time.Duration) error {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		err := b.pollDump1090(ctx, url)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *Bridge) pollDump1090(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return b.ReadDump1090(res.Body)
+}
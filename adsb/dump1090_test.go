@@ -0,0 +1,112 @@
+package adsb
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+var testAircraftJSON = `{
+	"now": 1600000000.0,
+	"messages": 1000,
+	"aircraft": [
+		{"hex": "4ca2d6", "flight": "RYR1AB  ", "alt_baro": 10000, "alt_geom": 10200,
+			"gs": 100.0, "track": 270.0, "baro_rate": -1000, "squawk": "7700",
+			"category": "A3", "lat": 45.4642, "lon": 9.19, "seen": 2.0},
+		{"hex": "3c6444", "alt_baro": "ground", "alt_geom": 500, "seen": 0.5},
+		{"hex": "400ae7", "altitude": 5000, "speed": 80, "track": 90, "vert_rate": 500,
+			"lat": 46.0, "lon": 10.0},
+		{"hex": "~2b0c4d", "lat": 47.0, "lon": 11.0}
+	]
+}`
+
+func TestParseDump1090(t *testing.T) {
+	now := time.Now()
+
+	aircraft, err := ParseDump1090([]byte(testAircraftJSON), now)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(aircraft))
+
+	a := aircraft[0]
+	require.Equal(t, uint32(0x4CA2D6), a.ICAO)
+	require.Equal(t, "RYR1AB", a.Callsign)
+	require.InDelta(t, 3048, a.Altitude, 0.001)
+	require.Equal(t, common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH, a.AltitudeType)
+	require.InDelta(t, 51.4444, a.Groundspeed, 0.001)
+	require.Equal(t, float64(270), a.Heading)
+	require.InDelta(t, -5.08, a.VerticalSpeed, 0.001)
+	require.Equal(t, uint16(7700), a.Squawk)
+	require.Equal(t, common.ADSB_EMITTER_TYPE_LARGE, a.EmitterType)
+	require.Equal(t, 45.4642, a.Latitude)
+	require.Equal(t, now.Add(-2*time.Second), a.LastSeen)
+	require.Equal(t, common.ADSB_FLAGS_VALID_CALLSIGN|common.ADSB_FLAGS_VALID_ALTITUDE|
+		common.ADSB_FLAGS_BARO_VALID|common.ADSB_FLAGS_VALID_VELOCITY|
+		common.ADSB_FLAGS_VALID_HEADING|common.ADSB_FLAGS_VERTICAL_VELOCITY_VALID|
+		common.ADSB_FLAGS_VALID_SQUAWK|common.ADSB_FLAGS_VALID_COORDS, a.Valid)
+
+	// aircraft on ground use the geometric altitude
+	a = aircraft[1]
+	require.Equal(t, uint32(0x3C6444), a.ICAO)
+	require.InDelta(t, 152.4, a.Altitude, 0.001)
+	require.Equal(t, common.ADSB_ALTITUDE_TYPE_GEOMETRIC, a.AltitudeType)
+	require.Equal(t, common.ADSB_FLAGS_VALID_ALTITUDE, a.Valid)
+
+	// legacy fields
+	a = aircraft[2]
+	require.Equal(t, uint32(0x400AE7), a.ICAO)
+	require.InDelta(t, 1524, a.Altitude, 0.001)
+	require.InDelta(t, 41.1555, a.Groundspeed, 0.001)
+	require.InDelta(t, 2.54, a.VerticalSpeed, 0.001)
+	require.Equal(t, now, a.LastSeen)
+}
+
+func TestPollDump1090(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/aircraft.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(testAircraftJSON))
+	}))
+	defer server.Close()
+
+	connA, connB := net.Pipe()
+	defer connB.Close()
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      2,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: connA}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	b, err := New(Conf{Node: node})
+	require.NoError(t, err)
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = b.PollDump1090(ctx, server.URL+"/data/aircraft.json", 50*time.Millisecond)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 3, len(b.Aircraft()))
+
+	err = b.PollDump1090(context.Background(), server.URL+"/missing.json", time.Second)
+	require.EqualError(t, err, "bad status code: 404")
+}
@@ -0,0 +1,122 @@
+package adsb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// ParseSBS parses a line of a SBS-1 (BaseStation) feed. It returns nil, with
+// no error, when the line does not contain aircraft data (i.e. it is not a
+// MSG line). LastSeen is left empty.
+func ParseSBS(line string) (*Aircraft, error) {
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), ",")
+
+	if fields[0] != "MSG" {
+		return nil, nil
+	}
+
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid field count (%d)", len(fields))
+	}
+
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	icao, err := strconv.ParseUint(fields[4], 16, 24)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICAO address '%s'", fields[4])
+	}
+
+	a := &Aircraft{
+		ICAO: uint32(icao),
+	}
+
+	if fields[10] != "" {
+		a.Callsign = fields[10]
+		a.Valid |= common.ADSB_FLAGS_VALID_CALLSIGN
+	}
+
+	if fields[11] != "" {
+		v, err := strconv.ParseFloat(fields[11], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid altitude '%s'", fields[11])
+		}
+		a.Altitude = v * feetToMeters
+		a.AltitudeType = common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH
+		a.Valid |= common.ADSB_FLAGS_VALID_ALTITUDE | common.ADSB_FLAGS_BARO_VALID
+	}
+
+	if fields[12] != "" && fields[13] != "" {
+		gs, err := strconv.ParseFloat(fields[12], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ground speed '%s'", fields[12])
+		}
+		track, err := strconv.ParseFloat(fields[13], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid track '%s'", fields[13])
+		}
+		a.Groundspeed = gs * knotsToMps
+		a.Heading = track
+		a.Valid |= common.ADSB_FLAGS_VALID_VELOCITY | common.ADSB_FLAGS_VALID_HEADING
+	}
+
+	if fields[14] != "" && fields[15] != "" {
+		lat, err := strconv.ParseFloat(fields[14], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latitude '%s'", fields[14])
+		}
+		lon, err := strconv.ParseFloat(fields[15], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid longitude '%s'", fields[15])
+		}
+		a.Latitude = lat
+		a.Longitude = lon
+		a.Valid |= common.ADSB_FLAGS_VALID_COORDS
+	}
+
+	if fields[16] != "" {
+		v, err := strconv.ParseFloat(fields[16], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vertical rate '%s'", fields[16])
+		}
+		a.VerticalSpeed = v * fpmToMps
+		a.Valid |= common.ADSB_FLAGS_VERTICAL_VELOCITY_VALID
+	}
+
+	if fields[17] != "" {
+		v, err := strconv.ParseUint(fields[17], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid squawk '%s'", fields[17])
+		}
+		a.Squawk = uint16(v)
+		a.Valid |= common.ADSB_FLAGS_VALID_SQUAWK
+	}
+
+	return a, nil
+}
+
+// ReadSBS reads a SBS-1 (BaseStation) feed, i.e. a connection to port 30003
+// of a receiver, and updates the aircraft. Invalid lines are skipped. It
+// returns when the reader returns an error; io.EOF is not reported.
+func (b *Bridge) ReadSBS(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		a, err := ParseSBS(scanner.Text())
+		if err != nil || a == nil {
+			continue
+		}
+
+		a.LastSeen = time.Now()
+		b.Update(*a)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,111 @@
+package adsb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestParseSBS(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		line string
+		a    *Aircraft
+	}{
+		{
+			"identification",
+			"MSG,1,111,11111,4CA2D6,111111,2020/01/01,10:00:00.000,2020/01/01,10:00:00.000,RYR1AB  ,,,,,,,,,,,0\r\n",
+			&Aircraft{
+				ICAO:     0x4CA2D6,
+				Callsign: "RYR1AB",
+				Valid:    common.ADSB_FLAGS_VALID_CALLSIGN,
+			},
+		},
+		{
+			"position",
+			"MSG,3,111,11111,4CA2D6,111111,2020/01/01,10:00:00.000,2020/01/01,10:00:00.000,,10000,,,45.46420,9.19000,,,0,0,0,0",
+			&Aircraft{
+				ICAO:         0x4CA2D6,
+				Latitude:     45.4642,
+				Longitude:    9.19,
+				Altitude:     3048,
+				AltitudeType: common.ADSB_ALTITUDE_TYPE_PRESSURE_QNH,
+				Valid: common.ADSB_FLAGS_VALID_COORDS | common.ADSB_FLAGS_VALID_ALTITUDE |
+					common.ADSB_FLAGS_BARO_VALID,
+			},
+		},
+		{
+			"velocity",
+			"MSG,4,111,11111,4CA2D6,111111,2020/01/01,10:00:00.000,2020/01/01,10:00:00.000,,,100,270,,,-1000,,,,,0",
+			&Aircraft{
+				ICAO:          0x4CA2D6,
+				Heading:       270,
+				Groundspeed:   51.4444,
+				VerticalSpeed: -5.08,
+				Valid: common.ADSB_FLAGS_VALID_VELOCITY | common.ADSB_FLAGS_VALID_HEADING |
+					common.ADSB_FLAGS_VERTICAL_VELOCITY_VALID,
+			},
+		},
+		{
+			"squawk",
+			"MSG,6,111,11111,4CA2D6,111111,2020/01/01,10:00:00.000,2020/01/01,10:00:00.000,,,,,,,,7700,0,1,0,0",
+			&Aircraft{
+				ICAO:   0x4CA2D6,
+				Squawk: 7700,
+				Valid:  common.ADSB_FLAGS_VALID_SQUAWK,
+			},
+		},
+		{
+			"other",
+			"STA,,5,179,400AE7,10103,2008/11/28,14:58:51.153,2008/11/28,14:58:51.153,RM",
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			a, err := ParseSBS(ca.line)
+			require.NoError(t, err)
+			if ca.a == nil {
+				require.Nil(t, a)
+				return
+			}
+			require.InDelta(t, ca.a.Groundspeed, a.Groundspeed, 0.001)
+			require.InDelta(t, ca.a.VerticalSpeed, a.VerticalSpeed, 0.001)
+			require.InDelta(t, ca.a.Altitude, a.Altitude, 0.001)
+			a.Groundspeed = ca.a.Groundspeed
+			a.VerticalSpeed = ca.a.VerticalSpeed
+			a.Altitude = ca.a.Altitude
+			require.Equal(t, ca.a, a)
+		})
+	}
+}
+
+func TestParseSBSErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		line string
+		err  string
+	}{
+		{
+			"field count",
+			"MSG,3,111,11111,4CA2D6",
+			"invalid field count (5)",
+		},
+		{
+			"icao",
+			"MSG,3,111,11111,ZZZZZZ,111111,,,,,,,,,,,,,,,,0",
+			"invalid ICAO address 'ZZZZZZ'",
+		},
+		{
+			"latitude",
+			"MSG,3,111,11111,4CA2D6,111111,,,,,,,,,abc,9,,,,,,0",
+			"invalid latitude 'abc'",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := ParseSBS(ca.line)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
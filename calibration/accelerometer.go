@@ -0,0 +1,122 @@
+package calibration
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const accelPositionCount = 6
+
+// Accelerometer calibrates the accelerometers of the vehicle, and returns
+// when the calibration is completed.
+func (c *Calibrator) Accelerometer() error {
+	autopilot, err := c.start()
+	if err != nil {
+		return err
+	}
+	defer c.stop()
+
+	cmd := &common.MessageCommandLong{
+		Command: common.MAV_CMD_PREFLIGHT_CALIBRATION,
+		Param5:  1,
+	}
+
+	if autopilot == common.MAV_AUTOPILOT_PX4 {
+		return c.runPx4(CalibrationAccelerometer, cmd)
+	}
+
+	return c.accelerometerArdupilot(cmd)
+}
+
+// accelPosition returns the position requested by a frame that contains a
+// MAV_CMD_ACCELCAL_VEHICLE_POS, or zero.
+func accelPosition(fr *gomavlib.EventFrame) ardupilotmega.ACCELCAL_VEHICLE_POS {
+	var cmd common.MessageCommandLong
+	if fr.Message().GetId() != cmd.GetId() || msg.Convert(&cmd, fr.Message()) != nil {
+		return 0
+	}
+
+	if cmd.Command != common.MAV_CMD(ardupilotmega.MAV_CMD_ACCELCAL_VEHICLE_POS) {
+		return 0
+	}
+
+	return ardupilotmega.ACCELCAL_VEHICLE_POS(cmd.Param1)
+}
+
+func (c *Calibrator) accelerometerArdupilot(cmd *common.MessageCommandLong) error {
+	err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	var requested ardupilotmega.ACCELCAL_VEHICLE_POS
+
+	// the vehicle fails the calibration when it doesn't receive positions in time
+	onWaitFrame := func(fr *gomavlib.EventFrame) error {
+		c.statusText(CalibrationAccelerometer, fr)
+		if accelPosition(fr) == ardupilotmega.ACCELCAL_VEHICLE_POS_FAILED {
+			return fmt.Errorf("calibration failed")
+		}
+		return nil
+	}
+
+	for {
+		fr, err := c.next()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := c.statusText(CalibrationAccelerometer, fr); ok {
+			continue
+		}
+
+		pos := accelPosition(fr)
+		switch {
+		case pos == ardupilotmega.ACCELCAL_VEHICLE_POS_SUCCESS:
+			c.emit(Event{
+				Type:        EventProgress,
+				Calibration: CalibrationAccelerometer,
+				Progress:    100,
+			})
+			return nil
+
+		case pos == ardupilotmega.ACCELCAL_VEHICLE_POS_FAILED:
+			return fmt.Errorf("calibration failed")
+
+		case pos < ardupilotmega.ACCELCAL_VEHICLE_POS_LEVEL,
+			pos > ardupilotmega.ACCELCAL_VEHICLE_POS_BACK,
+			pos == requested: // requests can be repeated
+			continue
+		}
+
+		requested = pos
+
+		c.emit(Event{
+			Type:        EventProgress,
+			Calibration: CalibrationAccelerometer,
+			Progress:    int(pos-1) * 100 / accelPositionCount,
+		})
+		c.emit(Event{
+			Type:        EventPosition,
+			Calibration: CalibrationAccelerometer,
+			Positions:   []Position{Position(pos)},
+		})
+
+		err = c.waitConfirm(onWaitFrame)
+		if err != nil {
+			return err
+		}
+
+		err = c.sendCommand(&common.MessageCommandLong{
+			Command: common.MAV_CMD(ardupilotmega.MAV_CMD_ACCELCAL_VEHICLE_POS),
+			Param1:  float32(pos),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
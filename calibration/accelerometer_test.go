@@ -0,0 +1,187 @@
+package calibration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func TestAccelerometerArdupilot(t *testing.T) {
+	requestPosition := func(vehicle *gomavlib.Node, pos ardupilotmega.ACCELCAL_VEHICLE_POS) {
+		vehicle.WriteMessageAll(&ardupilotmega.MessageCommandLong{
+			TargetSystem: 255,
+			Command:      ardupilotmega.MAV_CMD_ACCELCAL_VEHICLE_POS,
+			Param1:       float32(pos),
+		})
+	}
+
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		func(vehicle *gomavlib.Node, m msg.Message) {
+			cmd := ackCommand(vehicle, m)
+			if cmd == nil {
+				return
+			}
+
+			switch cmd.Command {
+			case ardupilotmega.MAV_CMD_PREFLIGHT_CALIBRATION:
+				vehicle.WriteMessageAll(statusText("Place vehicle level and press any key."))
+				requestPosition(vehicle, ardupilotmega.ACCELCAL_VEHICLE_POS_LEVEL)
+
+			case ardupilotmega.MAV_CMD_ACCELCAL_VEHICLE_POS:
+				pos := ardupilotmega.ACCELCAL_VEHICLE_POS(cmd.Param1)
+				if pos == ardupilotmega.ACCELCAL_VEHICLE_POS_BACK {
+					requestPosition(vehicle, ardupilotmega.ACCELCAL_VEHICLE_POS_SUCCESS)
+				} else {
+					requestPosition(vehicle, pos+1)
+				}
+			}
+		})
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	var c *Calibrator
+	var events []Event
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(evt Event) {
+			events = append(events, evt)
+			if evt.Type == EventPosition {
+				c.Confirm()
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Accelerometer()
+	require.NoError(t, err)
+
+	expected := []Event{{
+		Type:        EventText,
+		Calibration: CalibrationAccelerometer,
+		Text:        "Place vehicle level and press any key.",
+	}}
+	for i, pos := range []Position{PositionLevel, PositionLeft, PositionRight,
+		PositionNoseDown, PositionNoseUp, PositionBack} {
+		expected = append(expected, Event{
+			Type:        EventProgress,
+			Calibration: CalibrationAccelerometer,
+			Progress:    i * 100 / 6,
+		}, Event{
+			Type:        EventPosition,
+			Calibration: CalibrationAccelerometer,
+			Positions:   []Position{pos},
+		})
+	}
+	expected = append(expected, Event{
+		Type:        EventProgress,
+		Calibration: CalibrationAccelerometer,
+		Progress:    100,
+	})
+
+	require.Equal(t, expected, events)
+}
+
+func TestAccelerometerArdupilotFailed(t *testing.T) {
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		func(vehicle *gomavlib.Node, m msg.Message) {
+			cmd := ackCommand(vehicle, m)
+			if cmd == nil || cmd.Command != ardupilotmega.MAV_CMD_PREFLIGHT_CALIBRATION {
+				return
+			}
+
+			vehicle.WriteMessageAll(&ardupilotmega.MessageCommandLong{
+				TargetSystem: 255,
+				Command:      ardupilotmega.MAV_CMD_ACCELCAL_VEHICLE_POS,
+				Param1:       float32(ardupilotmega.ACCELCAL_VEHICLE_POS_LEVEL),
+			})
+
+			// the position is not confirmed in time
+			vehicle.WriteMessageAll(&ardupilotmega.MessageCommandLong{
+				TargetSystem: 255,
+				Command:      ardupilotmega.MAV_CMD_ACCELCAL_VEHICLE_POS,
+				Param1:       float32(ardupilotmega.ACCELCAL_VEHICLE_POS_FAILED),
+			})
+		})
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	c, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Accelerometer()
+	require.EqualError(t, err, "calibration failed")
+}
+
+func TestAccelerometerPx4(t *testing.T) {
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_PX4,
+		func(vehicle *gomavlib.Node, m msg.Message) {
+			cmd := ackCommand(vehicle, m)
+			if cmd == nil || cmd.Command != ardupilotmega.MAV_CMD_PREFLIGHT_CALIBRATION || cmd.Param5 != 1 {
+				return
+			}
+
+			for _, text := range []string{
+				"[cal] calibration started: 2 accel",
+				"[cal] pending: back front left right up down",
+				"[cal] down orientation detected",
+				"[cal] progress <17>",
+				"[cal] pending: back front left right up",
+				"[cal] calibration done: accel",
+			} {
+				vehicle.WriteMessageAll(statusText(text))
+			}
+		})
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	var events []Event
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(evt Event) {
+			if evt.Type != EventText {
+				events = append(events, evt)
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Accelerometer()
+	require.NoError(t, err)
+
+	require.Equal(t, []Event{
+		{
+			Type:        EventPosition,
+			Calibration: CalibrationAccelerometer,
+			Positions: []Position{PositionNoseUp, PositionNoseDown, PositionLeft,
+				PositionRight, PositionBack, PositionLevel},
+		},
+		{
+			Type:        EventProgress,
+			Calibration: CalibrationAccelerometer,
+			Progress:    17,
+		},
+		{
+			Type:        EventPosition,
+			Calibration: CalibrationAccelerometer,
+			Positions: []Position{PositionNoseUp, PositionNoseDown, PositionLeft,
+				PositionRight, PositionBack},
+		},
+		{
+			Type:        EventProgress,
+			Calibration: CalibrationAccelerometer,
+			Progress:    100,
+		},
+	}, events)
+}
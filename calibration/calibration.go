@@ -0,0 +1,467 @@
+// Package calibration implements guided workflows that calibrate the
+// accelerometers, the compasses and the RC inputs of a vehicle running
+// ArduPilot or PX4.
+//
+// Each workflow is a state machine driven by the messages of the vehicle, that
+// reports its progress as events, i.e. to be shown by a provisioning tool:
+//   - accelerometers are calibrated with MAV_CMD_PREFLIGHT_CALIBRATION; the
+//     vehicle must be placed in a series of positions, that are requested
+//     by ArduPilot one at a time with MAV_CMD_ACCELCAL_VEHICLE_POS, and that
+//     are detected automatically by PX4
+//   - compasses are calibrated with MAV_CMD_DO_START_MAG_CAL on ArduPilot,
+//     whose progress is reported by MAG_CAL_PROGRESS and MAG_CAL_REPORT,
+//     and with MAV_CMD_PREFLIGHT_CALIBRATION on PX4, whose progress is
+//     reported by STATUSTEXT
+//   - RC inputs are calibrated by recording the range of RC_CHANNELS while
+//     the sticks are moved, and by writing it into the RCn_MIN, RCn_MAX and
+//     RCn_TRIM parameters.
+//
+// The node to which the calibrator is attached must use a dialect that
+// contains the common messages. The ardupilotmega messages are used when
+// available, in order to report the progress of ArduPilot compass
+// calibrations.
+package calibration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	frameQueueSize = 64
+)
+
+// ErrTimeout is returned when the vehicle stops reporting the progress of a
+// calibration.
+var ErrTimeout = fmt.Errorf("calibration timed out")
+
+// Calibration is a kind of calibration.
+type Calibration int
+
+const (
+	// CalibrationAccelerometer is the calibration of accelerometers.
+	CalibrationAccelerometer Calibration = iota
+
+	// CalibrationCompass is the calibration of compasses.
+	CalibrationCompass
+
+	// CalibrationRC is the calibration of RC inputs.
+	CalibrationRC
+)
+
+// String implements fmt.Stringer.
+func (c Calibration) String() string {
+	switch c {
+	case CalibrationAccelerometer:
+		return "accelerometer"
+	case CalibrationCompass:
+		return "compass"
+	case CalibrationRC:
+		return "rc"
+	}
+	return "unknown"
+}
+
+// Position is a position in which the vehicle must be placed during the
+// calibration of accelerometers.
+type Position int
+
+const (
+	// PositionLevel means that the vehicle is level.
+	PositionLevel Position = iota + 1
+
+	// PositionLeft means that the vehicle lies on its left side.
+	PositionLeft
+
+	// PositionRight means that the vehicle lies on its right side.
+	PositionRight
+
+	// PositionNoseDown means that the nose of the vehicle points down.
+	PositionNoseDown
+
+	// PositionNoseUp means that the nose of the vehicle points up.
+	PositionNoseUp
+
+	// PositionBack means that the vehicle lies on its back.
+	PositionBack
+)
+
+// String implements fmt.Stringer.
+func (p Position) String() string {
+	switch p {
+	case PositionLevel:
+		return "level"
+	case PositionLeft:
+		return "left"
+	case PositionRight:
+		return "right"
+	case PositionNoseDown:
+		return "nose down"
+	case PositionNoseUp:
+		return "nose up"
+	case PositionBack:
+		return "back"
+	}
+	return "unknown"
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventProgress means that the progress of the calibration has changed.
+	EventProgress EventType = iota
+
+	// EventPosition means that the vehicle must be placed in one of
+	// Positions. On ArduPilot, Confirm() must be called when the vehicle is
+	// in position, while PX4 detects the position automatically.
+	EventPosition
+
+	// EventMoveSticks means that all the sticks and switches of the RC
+	// transmitter must be moved to their limits. Confirm() must be called
+	// when done.
+	EventMoveSticks
+
+	// EventCenterSticks means that the sticks of the RC transmitter must be
+	// centered, with the throttle at its minimum. Confirm() must be called
+	// when done.
+	EventCenterSticks
+
+	// EventText means that the vehicle has sent a STATUSTEXT during the
+	// calibration.
+	EventText
+)
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	switch t {
+	case EventProgress:
+		return "progress"
+	case EventPosition:
+		return "position"
+	case EventMoveSticks:
+		return "move sticks"
+	case EventCenterSticks:
+		return "center sticks"
+	case EventText:
+		return "text"
+	}
+	return "unknown"
+}
+
+// Event is a step of a calibration.
+type Event struct {
+	// the type of the event.
+	Type EventType
+
+	// the calibration that generated the event.
+	Calibration Calibration
+
+	// the progress of the calibration, in percent, with EventProgress.
+	Progress int
+
+	// the positions in which the vehicle can be placed, with EventPosition.
+	Positions []Position
+
+	// the text sent by the vehicle, with EventText.
+	Text string
+}
+
+// Conf allows to configure a Calibrator.
+type Conf struct {
+	// the node with which the vehicle is calibrated.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle. It defaults to 1.
+	ComponentId byte
+
+	// (optional) the autopilot of the vehicle, MAV_AUTOPILOT_ARDUPILOTMEGA or
+	// MAV_AUTOPILOT_PX4. It defaults to the one advertised by the heartbeat of
+	// the vehicle.
+	Autopilot common.MAV_AUTOPILOT
+
+	// (optional) called when a calibration reports an event.
+	// It is called by the routine that is performing the calibration.
+	OnEvent func(Event)
+
+	// (optional) the maximum time without news from the vehicle, excluding
+	// the time spent waiting for Confirm().
+	// It defaults to 30s.
+	Timeout time.Duration
+
+	// (optional) the retry policy of the commands that start calibrations.
+	Retry command.RetryPolicy
+}
+
+// Calibrator calibrates a vehicle.
+type Calibrator struct {
+	conf           Conf
+	sender         *command.Sender
+	magCalProgress bool
+	removeHandler  func()
+
+	mutex     sync.Mutex
+	autopilot common.MAV_AUTOPILOT
+	running   bool
+
+	frames    chan *gomavlib.EventFrame
+	heartbeat chan struct{}
+	confirm   chan struct{}
+	terminate chan struct{}
+}
+
+// New allocates a Calibrator. See Conf for the options.
+func New(conf Conf) (*Calibrator, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Autopilot != 0 && conf.Autopilot != common.MAV_AUTOPILOT_ARDUPILOTMEGA &&
+		conf.Autopilot != common.MAV_AUTOPILOT_PX4 {
+		return nil, fmt.Errorf("autopilot %s is not supported", conf.Autopilot)
+	}
+
+	dialect := conf.Node.Conf().Dialect
+	if dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := dialect.CheckMessages(
+		&common.MessageHeartbeat{},
+		&common.MessageStatustext{},
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{},
+		&common.MessageMagCalReport{},
+		&common.MessageRcChannels{},
+		&common.MessageParamSet{},
+		&common.MessageParamValue{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	if conf.Timeout == 0 {
+		conf.Timeout = 30 * time.Second
+	}
+
+	sender, err := command.New(command.Conf{
+		Node:        conf.Node,
+		SystemId:    conf.SystemId,
+		ComponentId: conf.ComponentId,
+		Retry:       conf.Retry,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Calibrator{
+		conf:           conf,
+		sender:         sender,
+		magCalProgress: dialect.CheckMessages(&ardupilotmega.MessageMagCalProgress{}) == nil,
+		autopilot:      conf.Autopilot,
+		frames:         make(chan *gomavlib.EventFrame, frameQueueSize),
+		heartbeat:      make(chan struct{}),
+		confirm:        make(chan struct{}, 1),
+		terminate:      make(chan struct{}),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	return c, nil
+}
+
+// Close stops the calibrator. Calibrations in progress return an error.
+// It must be called before closing the node.
+func (c *Calibrator) Close() {
+	c.removeHandler()
+	close(c.terminate)
+	c.sender.Close()
+}
+
+// Confirm notifies the running calibration that the vehicle has been placed
+// in the requested position, or that the requested stick movements have been
+// performed.
+func (c *Calibrator) Confirm() {
+	select {
+	case c.confirm <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Calibrator) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != c.conf.SystemId || evt.ComponentId() != c.conf.ComponentId {
+		return
+	}
+
+	if _, ok := evt.Message().(*msg.MessageRaw); ok {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageHeartbeat{}).GetId():
+		var hb common.MessageHeartbeat
+		if msg.Convert(&hb, evt.Message()) != nil {
+			return
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.autopilot == 0 && hb.Autopilot != 0 {
+			c.autopilot = hb.Autopilot
+			close(c.heartbeat)
+		}
+		return
+
+	case (&common.MessageStatustext{}).GetId(),
+		(&common.MessageCommandLong{}).GetId(),
+		(&common.MessageMagCalReport{}).GetId(),
+		(&ardupilotmega.MessageMagCalProgress{}).GetId(),
+		(&common.MessageRcChannels{}).GetId(),
+		(&common.MessageParamValue{}).GetId():
+
+	default:
+		return
+	}
+
+	c.mutex.Lock()
+	running := c.running
+	c.mutex.Unlock()
+
+	if !running {
+		return
+	}
+
+	// frame handlers must not block; frames are dropped when the queue is full
+	select {
+	case c.frames <- evt:
+	default:
+	}
+}
+
+// start marks a calibration as running and returns the autopilot of the
+// vehicle, waiting for its heartbeat if necessary.
+func (c *Calibrator) start() (common.MAV_AUTOPILOT, error) {
+	c.mutex.Lock()
+	if c.running {
+		c.mutex.Unlock()
+		return 0, fmt.Errorf("a calibration is already running")
+	}
+	c.running = true
+	autopilot := c.autopilot
+	c.mutex.Unlock()
+
+	// discard stale frames and confirmations
+	for len(c.frames) > 0 {
+		<-c.frames
+	}
+	select {
+	case <-c.confirm:
+	default:
+	}
+
+	if autopilot == 0 {
+		select {
+		case <-c.heartbeat:
+		case <-time.After(c.conf.Timeout):
+			c.stop()
+			return 0, fmt.Errorf("autopilot not detected")
+		case <-c.terminate:
+			c.stop()
+			return 0, fmt.Errorf("terminated")
+		}
+
+		c.mutex.Lock()
+		autopilot = c.autopilot
+		c.mutex.Unlock()
+	}
+
+	if autopilot != common.MAV_AUTOPILOT_ARDUPILOTMEGA && autopilot != common.MAV_AUTOPILOT_PX4 {
+		c.stop()
+		return 0, fmt.Errorf("autopilot %s is not supported", autopilot)
+	}
+
+	return autopilot, nil
+}
+
+func (c *Calibrator) stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.running = false
+}
+
+func (c *Calibrator) emit(evt Event) {
+	if c.conf.OnEvent != nil {
+		c.conf.OnEvent(evt)
+	}
+}
+
+// sendCommand sends a command to the vehicle and waits for its acceptance.
+func (c *Calibrator) sendCommand(cmd *common.MessageCommandLong) error {
+	_, err := c.sender.Send(cmd, nil)
+	return err
+}
+
+// next returns the next frame received from the vehicle during a
+// calibration. The timeout is restarted at every call.
+func (c *Calibrator) next() (*gomavlib.EventFrame, error) {
+	select {
+	case fr := <-c.frames:
+		return fr, nil
+	case <-time.After(c.conf.Timeout):
+		return nil, ErrTimeout
+	case <-c.terminate:
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// waitConfirm waits until Confirm() is called, while forwarding the frames
+// received in the meantime to a function.
+func (c *Calibrator) waitConfirm(onFrame func(*gomavlib.EventFrame) error) error {
+	for {
+		select {
+		case <-c.confirm:
+			return nil
+		case fr := <-c.frames:
+			err := onFrame(fr)
+			if err != nil {
+				return err
+			}
+		case <-c.terminate:
+			return fmt.Errorf("terminated")
+		}
+	}
+}
+
+// statusText returns the text of a STATUSTEXT, and emits it as an event.
+func (c *Calibrator) statusText(cal Calibration, fr *gomavlib.EventFrame) (string, bool) {
+	var st common.MessageStatustext
+	if fr.Message().GetId() != st.GetId() || msg.Convert(&st, fr.Message()) != nil {
+		return "", false
+	}
+
+	c.emit(Event{
+		Type:        EventText,
+		Calibration: cal,
+		Text:        st.Text,
+	})
+
+	return st.Text, true
+}
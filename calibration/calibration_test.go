@@ -0,0 +1,123 @@
+package calibration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// newTestVehicle returns a node to which calibrators are attached, and a vehicle
+// with system id 1 that advertises the given autopilot and answers the
+// received messages with a function.
+func newTestVehicle(t *testing.T, autopilot common.MAV_AUTOPILOT,
+	onMessage func(vehicle *gomavlib.Node, m msg.Message)) (*gomavlib.Node, *gomavlib.Node) {
+	e1, e2 := testnode.Pipe()
+	gcsConf := testnode.Conf(255, e1)
+	gcsConf.Dialect = ardupilotmega.Dialect
+	vehicleConf := testnode.Conf(1, e2)
+	vehicleConf.Dialect = ardupilotmega.Dialect
+	vehicleConf.HeartbeatDisable = false
+	vehicleConf.HeartbeatPeriod = 50 * time.Millisecond
+	vehicleConf.HeartbeatAutopilotType = int(autopilot)
+
+	gcs, vehicle := testnode.New(t, gcsConf, vehicleConf)
+	testnode.DiscardEvents(gcs)
+
+	testnode.OnFrame(vehicle, func(fr *gomavlib.EventFrame) {
+		if onMessage != nil {
+			onMessage(vehicle, fr.Message())
+		}
+	})
+
+	return gcs, vehicle
+}
+
+// ackCommand answers a COMMAND_LONG and returns it, or returns nil.
+func ackCommand(vehicle *gomavlib.Node, m msg.Message) *ardupilotmega.MessageCommandLong {
+	cmd, ok := m.(*ardupilotmega.MessageCommandLong)
+	if !ok {
+		return nil
+	}
+
+	vehicle.WriteMessageAll(&ardupilotmega.MessageCommandAck{
+		Command:      cmd.Command,
+		Result:       ardupilotmega.MAV_RESULT_ACCEPTED,
+		TargetSystem: 255,
+	})
+	return cmd
+}
+
+func statusText(text string) *ardupilotmega.MessageStatustext {
+	return &ardupilotmega.MessageStatustext{
+		Severity: ardupilotmega.MAV_SEVERITY_INFO,
+		Text:     text,
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_PX4, nil)
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	_, err = New(Conf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+
+	_, err = New(Conf{Node: gcs, SystemId: 1, Autopilot: common.MAV_AUTOPILOT_GENERIC_MISSION_FULL})
+	require.EqualError(t, err, "autopilot MAV_AUTOPILOT_GENERIC_MISSION_FULL is not supported")
+}
+
+func TestAutopilotDetection(t *testing.T) {
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_INVALID, nil)
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	c, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Accelerometer()
+	require.EqualError(t, err, "autopilot MAV_AUTOPILOT_INVALID is not supported")
+}
+
+func TestAlreadyRunning(t *testing.T) {
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA, nil)
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	moveSticks := make(chan struct{})
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(evt Event) {
+			if evt.Type == EventMoveSticks {
+				close(moveSticks)
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- c.RC()
+	}()
+
+	<-moveSticks
+
+	err = c.Compass()
+	require.EqualError(t, err, "a calibration is already running")
+
+	// running calibrations are interrupted by Close()
+	c.Close()
+	require.EqualError(t, <-done, "terminated")
+}
@@ -0,0 +1,140 @@
+package calibration
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// Compass calibrates the compasses of the vehicle, that must be rotated
+// around all its axes, and returns when the calibration is completed.
+func (c *Calibrator) Compass() error {
+	autopilot, err := c.start()
+	if err != nil {
+		return err
+	}
+	defer c.stop()
+
+	if autopilot == common.MAV_AUTOPILOT_PX4 {
+		return c.runPx4(CalibrationCompass, &common.MessageCommandLong{
+			Command: common.MAV_CMD_PREFLIGHT_CALIBRATION,
+			Param2:  1,
+		})
+	}
+
+	return c.compassArdupilot()
+}
+
+func (c *Calibrator) compassArdupilot() error {
+	err := c.sendCommand(&common.MessageCommandLong{
+		Command: common.MAV_CMD(ardupilotmega.MAV_CMD_DO_START_MAG_CAL),
+		Param1:  0, // all compasses
+		Param2:  0, // don't retry on failure
+		Param3:  1, // save automatically
+	})
+	if err != nil {
+		return err
+	}
+
+	var mask uint8
+	completion := make(map[uint8]int)
+	reports := make(map[uint8]*common.MessageMagCalReport)
+	progress := -1
+
+	for {
+		fr, err := c.next()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := c.statusText(CalibrationCompass, fr); ok {
+			continue
+		}
+
+		switch fr.Message().GetId() {
+		case (&ardupilotmega.MessageMagCalProgress{}).GetId():
+			if !c.magCalProgress {
+				continue
+			}
+
+			var p ardupilotmega.MessageMagCalProgress
+			if msg.Convert(&p, fr.Message()) != nil {
+				continue
+			}
+
+			mask |= p.CalMask
+			completion[p.CompassId] = int(p.CompletionPct)
+
+		case (&common.MessageMagCalReport{}).GetId():
+			var r common.MessageMagCalReport
+			if msg.Convert(&r, fr.Message()) != nil {
+				continue
+			}
+
+			mask |= r.CalMask
+			reports[r.CompassId] = &r
+			completion[r.CompassId] = 100
+
+		default:
+			continue
+		}
+
+		// the progress is the one of the slowest compass, and the calibration
+		// is completed when all compasses have sent a report
+		cur := 100
+		done := true
+		for id := uint8(0); id < 8; id++ {
+			if mask&(1<<id) == 0 {
+				continue
+			}
+			if completion[id] < cur {
+				cur = completion[id]
+			}
+			if _, ok := reports[id]; !ok {
+				done = false
+			}
+		}
+
+		if cur != progress {
+			progress = cur
+			c.emit(Event{
+				Type:        EventProgress,
+				Calibration: CalibrationCompass,
+				Progress:    progress,
+			})
+		}
+
+		if done {
+			return c.compassArdupilotResult(reports)
+		}
+	}
+}
+
+func (c *Calibrator) compassArdupilotResult(reports map[uint8]*common.MessageMagCalReport) error {
+	accept := false
+
+	for id := uint8(0); id < 8; id++ {
+		r, ok := reports[id]
+		if !ok {
+			continue
+		}
+
+		if r.CalStatus != common.MAG_CAL_SUCCESS {
+			return fmt.Errorf("calibration of compass %d failed: %s", id, r.CalStatus)
+		}
+
+		if r.Autosaved == 0 {
+			accept = true
+		}
+	}
+
+	if accept {
+		return c.sendCommand(&common.MessageCommandLong{
+			Command: common.MAV_CMD(ardupilotmega.MAV_CMD_DO_ACCEPT_MAG_CAL),
+		})
+	}
+
+	return nil
+}
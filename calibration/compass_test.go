@@ -0,0 +1,128 @@
+package calibration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func TestCompassArdupilot(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		status ardupilotmega.MAG_CAL_STATUS
+		err    string
+	}{
+		{"success", ardupilotmega.MAG_CAL_SUCCESS, ""},
+		{"failed", ardupilotmega.MAG_CAL_BAD_RADIUS, "calibration of compass 1 failed: MAG_CAL_BAD_RADIUS"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+				func(vehicle *gomavlib.Node, m msg.Message) {
+					cmd := ackCommand(vehicle, m)
+					if cmd == nil || cmd.Command != ardupilotmega.MAV_CMD_DO_START_MAG_CAL {
+						return
+					}
+
+					progress := func(id uint8, pct uint8) {
+						vehicle.WriteMessageAll(&ardupilotmega.MessageMagCalProgress{
+							CompassId:     id,
+							CalMask:       0x03,
+							CalStatus:     ardupilotmega.MAG_CAL_RUNNING_STEP_ONE,
+							CompletionPct: pct,
+						})
+					}
+
+					report := func(id uint8, status ardupilotmega.MAG_CAL_STATUS) {
+						vehicle.WriteMessageAll(&ardupilotmega.MessageMagCalReport{
+							CompassId: id,
+							CalMask:   0x03,
+							CalStatus: status,
+							Autosaved: 1,
+						})
+					}
+
+					progress(0, 50)
+					progress(1, 20)
+					progress(0, 100)
+					report(0, ardupilotmega.MAG_CAL_SUCCESS)
+					progress(1, 100)
+					report(1, ca.status)
+				})
+			defer gcs.Close()
+			defer vehicle.Close()
+
+			var progress []int
+
+			c, err := New(Conf{
+				Node:     gcs,
+				SystemId: 1,
+				OnEvent: func(evt Event) {
+					require.Equal(t, EventProgress, evt.Type)
+					require.Equal(t, CalibrationCompass, evt.Calibration)
+					progress = append(progress, evt.Progress)
+				},
+			})
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Compass()
+			if ca.err != "" {
+				require.EqualError(t, err, ca.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, []int{0, 20, 100}, progress)
+		})
+	}
+}
+
+func TestCompassPx4(t *testing.T) {
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_PX4,
+		func(vehicle *gomavlib.Node, m msg.Message) {
+			cmd := ackCommand(vehicle, m)
+			if cmd == nil || cmd.Command != ardupilotmega.MAV_CMD_PREFLIGHT_CALIBRATION || cmd.Param2 != 1 {
+				return
+			}
+
+			for _, text := range []string{
+				"[cal] calibration started: 2 mag",
+				"[cal] progress <10>",
+				"[cal] progress <10>",
+				"[cal] progress <60>",
+				"[cal] calibration failed: timeout",
+			} {
+				vehicle.WriteMessageAll(statusText(text))
+			}
+		})
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	var progress []int
+	texts := 0
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(evt Event) {
+			switch evt.Type {
+			case EventProgress:
+				progress = append(progress, evt.Progress)
+			case EventText:
+				texts++
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Compass()
+	require.EqualError(t, err, "calibration failed: timeout")
+	require.Equal(t, []int{10, 60}, progress)
+	require.Equal(t, 5, texts)
+}
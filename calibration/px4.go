@@ -0,0 +1,122 @@
+package calibration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// texts sent by PX4 during calibrations, that are the ones parsed by
+// QGroundControl.
+var (
+	rePx4Progress  = regexp.MustCompile(`^\[cal\] progress <([0-9]+)>`)
+	rePx4Pending   = regexp.MustCompile(`^\[cal\] pending:(.*)$`)
+	rePx4Done      = regexp.MustCompile(`^\[cal\] calibration done:`)
+	rePx4Failed    = regexp.MustCompile(`^\[cal\] calibration (failed|cancelled)`)
+	px4Orientation = map[string]Position{
+		"down":  PositionLevel,
+		"up":    PositionBack,
+		"left":  PositionLeft,
+		"right": PositionRight,
+		"front": PositionNoseDown,
+		"back":  PositionNoseUp,
+	}
+)
+
+// px4Status is the meaning of a STATUSTEXT sent by PX4 during a calibration.
+type px4Status struct {
+	// the progress in percent, or -1 when the text doesn't contain it
+	progress  int
+	positions []Position
+	done      bool
+	err       error
+}
+
+// parsePx4Status parses a STATUSTEXT sent by PX4. It returns false if the
+// text is not related to calibrations.
+func parsePx4Status(text string) (px4Status, bool) {
+	if m := rePx4Progress.FindStringSubmatch(text); m != nil {
+		v, _ := strconv.Atoi(m[1])
+		return px4Status{progress: v}, true
+	}
+
+	if m := rePx4Pending.FindStringSubmatch(text); m != nil {
+		var positions []Position
+		for _, f := range strings.Fields(m[1]) {
+			if p, ok := px4Orientation[f]; ok {
+				positions = append(positions, p)
+			}
+		}
+		return px4Status{progress: -1, positions: positions}, true
+	}
+
+	if rePx4Done.MatchString(text) {
+		return px4Status{progress: -1, done: true}, true
+	}
+
+	if rePx4Failed.MatchString(text) {
+		return px4Status{progress: -1, err: fmt.Errorf("%s", strings.TrimPrefix(text, "[cal] "))}, true
+	}
+
+	return px4Status{}, false
+}
+
+// runPx4 starts a calibration with MAV_CMD_PREFLIGHT_CALIBRATION and follows
+// it through the texts sent by the vehicle, until it is completed.
+func (c *Calibrator) runPx4(cal Calibration, cmd *common.MessageCommandLong) error {
+	err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	progress := -1
+
+	for {
+		fr, err := c.next()
+		if err != nil {
+			return err
+		}
+
+		text, ok := c.statusText(cal, fr)
+		if !ok {
+			continue
+		}
+
+		st, ok := parsePx4Status(text)
+		if !ok {
+			continue
+		}
+
+		if st.err != nil {
+			return st.err
+		}
+
+		if st.positions != nil {
+			c.emit(Event{
+				Type:        EventPosition,
+				Calibration: cal,
+				Positions:   st.positions,
+			})
+		}
+
+		if st.done {
+			st.progress = 100
+		}
+
+		if st.progress >= 0 && st.progress != progress {
+			progress = st.progress
+			c.emit(Event{
+				Type:        EventProgress,
+				Calibration: cal,
+				Progress:    progress,
+			})
+		}
+
+		if st.done {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package calibration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePx4Status(t *testing.T) {
+	for _, ca := range []struct {
+		text string
+		ok   bool
+		st   px4Status
+	}{
+		{
+			"[cal] progress <42>",
+			true,
+			px4Status{progress: 42},
+		},
+		{
+			"[cal] pending: back front left right up down",
+			true,
+			px4Status{progress: -1, positions: []Position{PositionNoseUp, PositionNoseDown,
+				PositionLeft, PositionRight, PositionBack, PositionLevel}},
+		},
+		{
+			"[cal] calibration done: mag",
+			true,
+			px4Status{progress: -1, done: true},
+		},
+		{
+			"[cal] calibration failed: timeout",
+			true,
+			px4Status{progress: -1, err: fmt.Errorf("calibration failed: timeout")},
+		},
+		{
+			"[cal] calibration cancelled",
+			true,
+			px4Status{progress: -1, err: fmt.Errorf("calibration cancelled")},
+		},
+		{
+			"[cal] down orientation detected",
+			false,
+			px4Status{},
+		},
+		{
+			"Preflight Fail: Accel Sensor 0 missing",
+			false,
+			px4Status{},
+		},
+	} {
+		t.Run(ca.text, func(t *testing.T) {
+			st, ok := parsePx4Status(ca.text)
+			require.Equal(t, ca.ok, ok)
+			require.Equal(t, ca.st, st)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package calibration
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// the minimum range of a channel that has been moved, in microseconds
+	rcMinRange = 100
+
+	// timeout and retries of parameter writes
+	paramTimeout = 1 * time.Second
+	paramRetries = 3
+)
+
+// rcValues returns the values of the channels in a RC_CHANNELS.
+func rcValues(m *common.MessageRcChannels) []uint16 {
+	return []uint16{
+		m.Chan1Raw, m.Chan2Raw, m.Chan3Raw, m.Chan4Raw, m.Chan5Raw, m.Chan6Raw,
+		m.Chan7Raw, m.Chan8Raw, m.Chan9Raw, m.Chan10Raw, m.Chan11Raw, m.Chan12Raw,
+		m.Chan13Raw, m.Chan14Raw, m.Chan15Raw, m.Chan16Raw, m.Chan17Raw, m.Chan18Raw,
+	}
+}
+
+// rcChannels returns the channel values contained in a frame, or nil.
+// Unused channels are set to zero.
+func rcChannels(fr *gomavlib.EventFrame) []uint16 {
+	var m common.MessageRcChannels
+	if fr.Message().GetId() != m.GetId() || msg.Convert(&m, fr.Message()) != nil {
+		return nil
+	}
+
+	values := rcValues(&m)
+	for i, v := range values {
+		if i >= int(m.Chancount) || v == math.MaxUint16 {
+			values[i] = 0
+		}
+	}
+	return values
+}
+
+// RC calibrates the RC inputs of the vehicle, by recording the range of the
+// channels while the sticks are moved, and the trims while they are centered.
+// Channels that are not moved are not calibrated. It returns when the
+// parameters of the vehicle have been written.
+func (c *Calibrator) RC() error {
+	autopilot, err := c.start()
+	if err != nil {
+		return err
+	}
+	defer c.stop()
+
+	var min, max, trim []uint16
+
+	c.emit(Event{
+		Type:        EventMoveSticks,
+		Calibration: CalibrationRC,
+	})
+
+	err = c.waitConfirm(func(fr *gomavlib.EventFrame) error {
+		c.statusText(CalibrationRC, fr)
+
+		values := rcChannels(fr)
+		if values == nil {
+			return nil
+		}
+
+		if min == nil {
+			min = make([]uint16, len(values))
+			max = make([]uint16, len(values))
+		}
+
+		for i, v := range values {
+			if v == 0 {
+				continue
+			}
+			if min[i] == 0 || v < min[i] {
+				min[i] = v
+			}
+			if v > max[i] {
+				max[i] = v
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.emit(Event{
+		Type:        EventCenterSticks,
+		Calibration: CalibrationRC,
+	})
+
+	err = c.waitConfirm(func(fr *gomavlib.EventFrame) error {
+		c.statusText(CalibrationRC, fr)
+
+		if values := rcChannels(fr); values != nil {
+			trim = values
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if min == nil || trim == nil {
+		return fmt.Errorf("RC_CHANNELS not received")
+	}
+
+	var channels []int
+	for i := range min {
+		if min[i] != 0 && max[i]-min[i] >= rcMinRange {
+			channels = append(channels, i)
+		}
+	}
+
+	if len(channels) == 0 {
+		return fmt.Errorf("no RC channel has been moved")
+	}
+
+	// ArduPilot stores RC ranges as integers, PX4 as floats
+	ptype := common.MAV_PARAM_TYPE_REAL32
+	if autopilot == common.MAV_AUTOPILOT_ARDUPILOTMEGA {
+		ptype = common.MAV_PARAM_TYPE_INT16
+	}
+
+	for n, i := range channels {
+		t := trim[i]
+		if t < min[i] {
+			t = min[i]
+		} else if t > max[i] {
+			t = max[i]
+		}
+
+		for _, p := range []struct {
+			suffix string
+			value  uint16
+		}{
+			{"MIN", min[i]},
+			{"MAX", max[i]},
+			{"TRIM", t},
+		} {
+			err := c.setParam(fmt.Sprintf("RC%d_%s", i+1, p.suffix), float32(p.value), ptype)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(Event{
+			Type:        EventProgress,
+			Calibration: CalibrationRC,
+			Progress:    (n + 1) * 100 / len(channels),
+		})
+	}
+
+	return nil
+}
+
+// setParam writes a parameter of the vehicle and waits for its confirmation.
+func (c *Calibrator) setParam(name string, value float32, ptype common.MAV_PARAM_TYPE) error {
+	for i := 0; i < paramRetries; i++ {
+		c.conf.Node.WriteMessageAll(&common.MessageParamSet{
+			TargetSystem:    c.conf.SystemId,
+			TargetComponent: c.conf.ComponentId,
+			ParamId:         name,
+			ParamValue:      value,
+			ParamType:       ptype,
+		})
+
+		timeout := time.NewTimer(paramTimeout)
+
+	outer:
+		for {
+			select {
+			case fr := <-c.frames:
+				var pv common.MessageParamValue
+				if fr.Message().GetId() != pv.GetId() || msg.Convert(&pv, fr.Message()) != nil ||
+					pv.ParamId != name {
+					c.statusText(CalibrationRC, fr)
+					continue
+				}
+
+				timeout.Stop()
+
+				if pv.ParamValue != value {
+					return fmt.Errorf("parameter %s has been set to %v instead of %v",
+						name, pv.ParamValue, value)
+				}
+				return nil
+
+			case <-timeout.C:
+				break outer
+
+			case <-c.terminate:
+				timeout.Stop()
+				return fmt.Errorf("terminated")
+			}
+		}
+	}
+
+	return fmt.Errorf("parameter %s has not been confirmed", name)
+}
@@ -0,0 +1,144 @@
+package calibration
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func TestRC(t *testing.T) {
+	var mutex sync.Mutex
+	params := make(map[string]float32)
+	var paramTypes []ardupilotmega.MAV_PARAM_TYPE
+
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		func(vehicle *gomavlib.Node, m msg.Message) {
+			ps, ok := m.(*ardupilotmega.MessageParamSet)
+			if !ok {
+				return
+			}
+
+			mutex.Lock()
+			params[ps.ParamId] = ps.ParamValue
+			paramTypes = append(paramTypes, ps.ParamType)
+			mutex.Unlock()
+
+			vehicle.WriteMessageAll(&ardupilotmega.MessageParamValue{
+				ParamId:    ps.ParamId,
+				ParamValue: ps.ParamValue,
+				ParamType:  ps.ParamType,
+			})
+		})
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	sendChannels := func(ch1 uint16, ch2 uint16, ch3 uint16) {
+		vehicle.WriteMessageAll(&ardupilotmega.MessageRcChannels{
+			Chancount: 3,
+			Chan1Raw:  ch1,
+			Chan2Raw:  ch2,
+			Chan3Raw:  ch3,
+			// not received
+			Chan4Raw: 1500,
+			Chan5Raw: 0xFFFF,
+		})
+	}
+
+	var c *Calibrator
+	var events []EventType
+	var progress []int
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(evt Event) {
+			require.Equal(t, CalibrationRC, evt.Calibration)
+			events = append(events, evt.Type)
+
+			switch evt.Type {
+			case EventMoveSticks:
+				// channel 3 is not moved
+				go func() {
+					for _, v := range [][3]uint16{
+						{1500, 1500, 1100},
+						{1010, 1990, 1100},
+						{1995, 1005, 1120},
+					} {
+						sendChannels(v[0], v[1], v[2])
+						time.Sleep(20 * time.Millisecond)
+					}
+					c.Confirm()
+				}()
+
+			case EventCenterSticks:
+				go func() {
+					sendChannels(1502, 1496, 1100)
+					time.Sleep(20 * time.Millisecond)
+					c.Confirm()
+				}()
+
+			case EventProgress:
+				progress = append(progress, evt.Progress)
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.RC()
+	require.NoError(t, err)
+
+	require.Equal(t, []EventType{EventMoveSticks, EventCenterSticks, EventProgress, EventProgress}, events)
+	require.Equal(t, []int{50, 100}, progress)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	require.Equal(t, map[string]float32{
+		"RC1_MIN":  1010,
+		"RC1_MAX":  1995,
+		"RC1_TRIM": 1502,
+		"RC2_MIN":  1005,
+		"RC2_MAX":  1990,
+		"RC2_TRIM": 1496,
+	}, params)
+
+	for _, pt := range paramTypes {
+		require.Equal(t, ardupilotmega.MAV_PARAM_TYPE_INT16, pt)
+	}
+}
+
+func TestRCNotMoved(t *testing.T) {
+	gcs, vehicle := newTestVehicle(t, common.MAV_AUTOPILOT_PX4, nil)
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	var c *Calibrator
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(evt Event) {
+			go func() {
+				vehicle.WriteMessageAll(&ardupilotmega.MessageRcChannels{
+					Chancount: 1,
+					Chan1Raw:  1500,
+				})
+				time.Sleep(20 * time.Millisecond)
+				c.Confirm()
+			}()
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.RC()
+	require.EqualError(t, err, "no RC channel has been moved")
+}
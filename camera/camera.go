@@ -0,0 +1,612 @@
+// Package camera implements a Mavlink camera component, that allows a program
+// to be recognized as a camera by ground stations, as described in
+// https://mavlink.io/en/services/camera.html
+//
+// Camera information, settings and storage are advertised automatically, while
+// captures are delegated to user-provided callbacks.
+//
+// The node to which the camera is attached must use a dialect that contains
+// the common messages, and should be configured with OutComponentId equal to
+// MAV_COMP_ID_CAMERA (100) and HeartbeatSystemType equal to MAV_TYPE_CAMERA (30).
+package camera
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	commandQueueSize = 16
+)
+
+// Capture contains the result of an image capture.
+type Capture struct {
+	// URL of the image file.
+	FileURL string
+	// (optional) latitude where the image was taken, in degE7.
+	Lat int32
+	// (optional) longitude where the image was taken, in degE7.
+	Lon int32
+	// (optional) altitude (MSL) where the image was taken, in mm.
+	Alt int32
+	// (optional) altitude above ground, in mm.
+	RelativeAlt int32
+	// (optional) camera attitude, as quaternion (w, x, y, z order).
+	Q [4]float32
+}
+
+// Storage describes a storage device.
+type Storage struct {
+	// status of the storage.
+	Status common.STORAGE_STATUS
+	// total capacity, in MiB.
+	TotalCapacity float32
+	// used capacity, in MiB.
+	UsedCapacity float32
+	// available capacity, in MiB.
+	AvailableCapacity float32
+	// read speed, in MiB/s.
+	ReadSpeed float32
+	// write speed, in MiB/s.
+	WriteSpeed float32
+}
+
+// Conf allows to configure a Camera.
+type Conf struct {
+	// the node to which the camera is attached.
+	Node *gomavlib.Node
+
+	// name of the camera vendor.
+	VendorName string
+	// name of the camera model.
+	ModelName string
+	// (optional) version of the camera firmware.
+	FirmwareVersion uint32
+	// (optional) focal length, in mm.
+	FocalLength float32
+	// (optional) horizontal size of the image sensor, in mm.
+	SensorSizeH float32
+	// (optional) vertical size of the image sensor, in mm.
+	SensorSizeV float32
+	// (optional) horizontal image resolution, in pixels.
+	ResolutionH uint16
+	// (optional) vertical image resolution, in pixels.
+	ResolutionV uint16
+	// (optional) reserved for a lens ID.
+	LensId uint8
+	// (optional) additional capability flags. Flags related to the provided
+	// callbacks are set automatically.
+	Flags common.CAMERA_CAP_FLAGS
+	// (optional) version of the camera definition file.
+	DefinitionVersion uint16
+	// (optional) URI of the camera definition file.
+	DefinitionURI string
+
+	// (optional) returns the status of the storage devices.
+	Storage func() []Storage
+
+	// (optional) called when an image must be captured.
+	// index is the zero-based index of the image.
+	OnImageCapture func(index int) (*Capture, error)
+	// (optional) called when a video recording must be started.
+	OnVideoStart func(streamId int) error
+	// (optional) called when a video recording must be stopped.
+	OnVideoStop func(streamId int) error
+	// (optional) called when the camera mode must be changed.
+	OnModeChange func(mode common.CAMERA_MODE) error
+	// (optional) called when a storage must be formatted.
+	// storageId is one-based.
+	OnStorageFormat func(storageId int) error
+}
+
+// Camera is a Mavlink camera component.
+type Camera struct {
+	conf          Conf
+	systemId      byte
+	componentId   byte
+	start         time.Time
+	removeHandler func()
+
+	// state
+	mutex           sync.Mutex
+	mode            common.CAMERA_MODE
+	imageCount      int32
+	imageInterval   float32
+	imageCapturing  bool
+	captureStop     chan struct{}
+	captureDone     chan struct{}
+	videoRecording  bool
+	videoStreamId   int
+	videoStart      time.Time
+	statusFrequency float32
+
+	frames    chan *gomavlib.EventFrame
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Camera. See Conf for the options.
+func New(conf Conf) (*Camera, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	nconf := conf.Node.Conf()
+	if nconf.Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := nconf.Dialect.CheckMessages(
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{},
+		&common.MessageCameraInformation{},
+		&common.MessageCameraSettings{},
+		&common.MessageStorageInformation{},
+		&common.MessageCameraCaptureStatus{},
+		&common.MessageCameraImageCaptured{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Camera{
+		conf:        conf,
+		systemId:    nconf.OutSystemId,
+		componentId: nconf.OutComponentId,
+		start:       time.Now(),
+		frames:      make(chan *gomavlib.EventFrame, commandQueueSize),
+		terminate:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	go c.run()
+
+	return c, nil
+}
+
+// Close stops the camera. It must be called before closing the node.
+func (c *Camera) Close() {
+	c.removeHandler()
+	close(c.terminate)
+	<-c.done
+}
+
+func (c *Camera) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.Message().GetId() != (&common.MessageCommandLong{}).GetId() {
+		return
+	}
+
+	// frame handlers must not block; commands are dropped when the queue is full
+	select {
+	case c.frames <- evt:
+	default:
+	}
+}
+
+func (c *Camera) run() {
+	defer close(c.done)
+
+	// status is sent periodically only during video recordings
+	var statusTicker *time.Ticker
+	var statusTick <-chan time.Time
+	defer func() {
+		if statusTicker != nil {
+			statusTicker.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case evt := <-c.frames:
+			var cmd common.MessageCommandLong
+			if msg.Convert(&cmd, evt.Message()) != nil {
+				continue
+			}
+
+			if cmd.TargetSystem != c.systemId ||
+				(cmd.TargetComponent != c.componentId && cmd.TargetComponent != 0) {
+				continue
+			}
+
+			res, reply, ok := c.handleCommand(&cmd)
+			if !ok {
+				// do not reply to broadcasted commands that are not supported,
+				// since they may be handled by other components
+				if cmd.TargetComponent == 0 {
+					continue
+				}
+				res = common.MAV_RESULT_UNSUPPORTED
+			}
+
+			c.conf.Node.WriteMessageTo(evt.Channel, &common.MessageCommandAck{
+				Command:         cmd.Command,
+				Result:          res,
+				TargetSystem:    evt.SystemId(),
+				TargetComponent: evt.ComponentId(),
+			})
+
+			for _, m := range reply {
+				c.conf.Node.WriteMessageTo(evt.Channel, m)
+			}
+
+			// start or stop periodic status
+			c.mutex.Lock()
+			freq := c.statusFrequency
+			recording := c.videoRecording
+			c.mutex.Unlock()
+
+			if statusTicker != nil && (!recording || freq <= 0) {
+				statusTicker.Stop()
+				statusTicker = nil
+				statusTick = nil
+			} else if statusTicker == nil && recording && freq > 0 {
+				statusTicker = time.NewTicker(time.Duration(float32(time.Second) / freq))
+				statusTick = statusTicker.C
+			}
+
+		case <-statusTick:
+			c.conf.Node.WriteMessageAll(c.captureStatus())
+
+		case <-c.terminate:
+			c.mutex.Lock()
+			captureStop := c.captureStop
+			captureDone := c.captureDone
+			c.mutex.Unlock()
+
+			if captureStop != nil {
+				close(captureStop)
+				<-captureDone
+			}
+			return
+		}
+	}
+}
+
+func (c *Camera) timeBootMs() uint32 {
+	return uint32(time.Since(c.start) / time.Millisecond)
+}
+
+func (c *Camera) information() msg.Message {
+	m := &common.MessageCameraInformation{
+		TimeBootMs:           c.timeBootMs(),
+		FirmwareVersion:      c.conf.FirmwareVersion,
+		FocalLength:          c.conf.FocalLength,
+		SensorSizeH:          c.conf.SensorSizeH,
+		SensorSizeV:          c.conf.SensorSizeV,
+		ResolutionH:          c.conf.ResolutionH,
+		ResolutionV:          c.conf.ResolutionV,
+		LensId:               c.conf.LensId,
+		Flags:                c.conf.Flags,
+		CamDefinitionVersion: c.conf.DefinitionVersion,
+		CamDefinitionUri:     c.conf.DefinitionURI,
+	}
+	copy(m.VendorName[:], c.conf.VendorName)
+	copy(m.ModelName[:], c.conf.ModelName)
+
+	if c.conf.OnImageCapture != nil {
+		m.Flags |= common.CAMERA_CAP_FLAGS_CAPTURE_IMAGE
+	}
+	if c.conf.OnVideoStart != nil {
+		m.Flags |= common.CAMERA_CAP_FLAGS_CAPTURE_VIDEO
+	}
+	if c.conf.OnModeChange != nil {
+		m.Flags |= common.CAMERA_CAP_FLAGS_HAS_MODES
+	}
+
+	return m
+}
+
+func (c *Camera) settings() msg.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &common.MessageCameraSettings{
+		TimeBootMs: c.timeBootMs(),
+		ModeId:     c.mode,
+	}
+}
+
+func (c *Camera) storages() []Storage {
+	if c.conf.Storage == nil {
+		return nil
+	}
+	return c.conf.Storage()
+}
+
+func (c *Camera) storageInformation(storageId int) []msg.Message {
+	storages := c.storages()
+
+	var ret []msg.Message
+	for i, s := range storages {
+		if storageId != 0 && storageId != (i+1) {
+			continue
+		}
+
+		ret = append(ret, &common.MessageStorageInformation{
+			TimeBootMs:        c.timeBootMs(),
+			StorageId:         uint8(i + 1),
+			StorageCount:      uint8(len(storages)),
+			Status:            s.Status,
+			TotalCapacity:     s.TotalCapacity,
+			UsedCapacity:      s.UsedCapacity,
+			AvailableCapacity: s.AvailableCapacity,
+			ReadSpeed:         s.ReadSpeed,
+			WriteSpeed:        s.WriteSpeed,
+		})
+	}
+	return ret
+}
+
+func (c *Camera) captureStatus() msg.Message {
+	available := float32(0)
+	for _, s := range c.storages() {
+		available += s.AvailableCapacity
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m := &common.MessageCameraCaptureStatus{
+		TimeBootMs:        c.timeBootMs(),
+		ImageInterval:     c.imageInterval,
+		AvailableCapacity: available,
+		ImageCount:        c.imageCount,
+	}
+
+	switch {
+	case c.imageCapturing && c.imageInterval > 0:
+		m.ImageStatus = 3
+	case c.imageCapturing:
+		m.ImageStatus = 1
+	}
+
+	if c.videoRecording {
+		m.VideoStatus = 1
+		m.RecordingTimeMs = uint32(time.Since(c.videoStart) / time.Millisecond)
+	}
+
+	return m
+}
+
+// handleCommand processes a command and returns the result, the messages that must be
+// sent after the acknowledgement and whether the command is supported.
+func (c *Camera) handleCommand(cmd *common.MessageCommandLong) (common.MAV_RESULT, []msg.Message, bool) {
+	switch cmd.Command {
+	case common.MAV_CMD_REQUEST_MESSAGE:
+		switch uint32(cmd.Param1) {
+		case (&common.MessageCameraInformation{}).GetId():
+			return common.MAV_RESULT_ACCEPTED, []msg.Message{c.information()}, true
+
+		case (&common.MessageCameraSettings{}).GetId():
+			return common.MAV_RESULT_ACCEPTED, []msg.Message{c.settings()}, true
+
+		case (&common.MessageStorageInformation{}).GetId():
+			return c.resultStorage(int(cmd.Param2))
+
+		case (&common.MessageCameraCaptureStatus{}).GetId():
+			return common.MAV_RESULT_ACCEPTED, []msg.Message{c.captureStatus()}, true
+		}
+		return 0, nil, false
+
+	case common.MAV_CMD_REQUEST_CAMERA_INFORMATION:
+		return common.MAV_RESULT_ACCEPTED, []msg.Message{c.information()}, true
+
+	case common.MAV_CMD_REQUEST_CAMERA_SETTINGS:
+		return common.MAV_RESULT_ACCEPTED, []msg.Message{c.settings()}, true
+
+	case common.MAV_CMD_REQUEST_STORAGE_INFORMATION:
+		return c.resultStorage(int(cmd.Param1))
+
+	case common.MAV_CMD_REQUEST_CAMERA_CAPTURE_STATUS:
+		return common.MAV_RESULT_ACCEPTED, []msg.Message{c.captureStatus()}, true
+
+	case common.MAV_CMD_SET_CAMERA_MODE:
+		if c.conf.OnModeChange == nil {
+			return 0, nil, false
+		}
+		mode := common.CAMERA_MODE(cmd.Param2)
+		if err := c.conf.OnModeChange(mode); err != nil {
+			return common.MAV_RESULT_FAILED, nil, true
+		}
+
+		c.mutex.Lock()
+		c.mode = mode
+		c.mutex.Unlock()
+
+		// notify everybody about the new settings
+		c.conf.Node.WriteMessageAll(c.settings())
+		return common.MAV_RESULT_ACCEPTED, nil, true
+
+	case common.MAV_CMD_STORAGE_FORMAT:
+		if c.conf.OnStorageFormat == nil {
+			return 0, nil, false
+		}
+		if err := c.conf.OnStorageFormat(int(cmd.Param1)); err != nil {
+			return common.MAV_RESULT_FAILED, nil, true
+		}
+		return common.MAV_RESULT_ACCEPTED, c.storageInformation(int(cmd.Param1)), true
+
+	case common.MAV_CMD_IMAGE_START_CAPTURE:
+		if c.conf.OnImageCapture == nil {
+			return 0, nil, false
+		}
+		return c.imageStart(cmd.Param2, int(cmd.Param3)), nil, true
+
+	case common.MAV_CMD_IMAGE_STOP_CAPTURE:
+		if c.conf.OnImageCapture == nil {
+			return 0, nil, false
+		}
+		c.imageStop()
+		return common.MAV_RESULT_ACCEPTED, nil, true
+
+	case common.MAV_CMD_VIDEO_START_CAPTURE:
+		if c.conf.OnVideoStart == nil {
+			return 0, nil, false
+		}
+		return c.videoStartCapture(int(cmd.Param1), cmd.Param2), nil, true
+
+	case common.MAV_CMD_VIDEO_STOP_CAPTURE:
+		if c.conf.OnVideoStart == nil {
+			return 0, nil, false
+		}
+		return c.videoStopCapture(), nil, true
+	}
+
+	return 0, nil, false
+}
+
+func (c *Camera) resultStorage(storageId int) (common.MAV_RESULT, []msg.Message, bool) {
+	if c.conf.Storage == nil {
+		return 0, nil, false
+	}
+
+	msgs := c.storageInformation(storageId)
+	if len(msgs) == 0 {
+		return common.MAV_RESULT_DENIED, nil, true
+	}
+	return common.MAV_RESULT_ACCEPTED, msgs, true
+}
+
+func (c *Camera) imageStart(interval float32, total int) common.MAV_RESULT {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.imageCapturing {
+		return common.MAV_RESULT_TEMPORARILY_REJECTED
+	}
+
+	// a single capture has no interval
+	if total == 1 {
+		interval = 0
+	}
+
+	c.imageCapturing = true
+	c.imageInterval = interval
+	c.captureStop = make(chan struct{})
+	c.captureDone = make(chan struct{})
+
+	go c.runCapture(interval, total, c.captureStop, c.captureDone)
+
+	return common.MAV_RESULT_ACCEPTED
+}
+
+func (c *Camera) imageStop() {
+	c.mutex.Lock()
+	captureStop := c.captureStop
+	captureDone := c.captureDone
+	c.captureStop = nil
+	c.captureDone = nil
+	c.mutex.Unlock()
+
+	if captureStop != nil {
+		close(captureStop)
+		<-captureDone
+	}
+}
+
+func (c *Camera) runCapture(interval float32, total int, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	defer func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.imageCapturing = false
+		c.imageInterval = 0
+	}()
+
+	for i := 0; total == 0 || i < total; i++ {
+		c.mutex.Lock()
+		index := c.imageCount
+		c.imageCount++
+		c.mutex.Unlock()
+
+		m := &common.MessageCameraImageCaptured{
+			TimeBootMs: c.timeBootMs(),
+			TimeUtc:    uint64(time.Now().UnixNano() / 1000),
+			ImageIndex: index,
+		}
+
+		capt, err := c.conf.OnImageCapture(int(index))
+		if err == nil && capt != nil {
+			m.Lat = capt.Lat
+			m.Lon = capt.Lon
+			m.Alt = capt.Alt
+			m.RelativeAlt = capt.RelativeAlt
+			m.Q = capt.Q
+			m.FileUrl = capt.FileURL
+			m.CaptureResult = 1
+		}
+
+		c.conf.Node.WriteMessageAll(m)
+
+		if total != 0 && i == (total-1) {
+			break
+		}
+
+		if interval > 0 {
+			timer := time.NewTimer(time.Duration(interval * float32(time.Second)))
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		} else {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+func (c *Camera) videoStartCapture(streamId int, statusFrequency float32) common.MAV_RESULT {
+	c.mutex.Lock()
+	recording := c.videoRecording
+	c.mutex.Unlock()
+
+	if recording {
+		return common.MAV_RESULT_TEMPORARILY_REJECTED
+	}
+
+	if err := c.conf.OnVideoStart(streamId); err != nil {
+		return common.MAV_RESULT_FAILED
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.videoRecording = true
+	c.videoStreamId = streamId
+	c.videoStart = time.Now()
+	c.statusFrequency = statusFrequency
+	return common.MAV_RESULT_ACCEPTED
+}
+
+func (c *Camera) videoStopCapture() common.MAV_RESULT {
+	c.mutex.Lock()
+	recording := c.videoRecording
+	streamId := c.videoStreamId
+	c.mutex.Unlock()
+
+	if !recording {
+		return common.MAV_RESULT_ACCEPTED
+	}
+
+	if c.conf.OnVideoStop != nil {
+		if err := c.conf.OnVideoStop(streamId); err != nil {
+			return common.MAV_RESULT_FAILED
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.videoRecording = false
+	return common.MAV_RESULT_ACCEPTED
+}
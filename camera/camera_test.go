@@ -0,0 +1,117 @@
+package camera
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func waitMessage(t *testing.T, node *gomavlib.Node, id uint32) msg.Message {
+	timeout := time.NewTimer(2 * time.Second)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case evt := <-node.Events():
+			if ee, ok := evt.(*gomavlib.EventFrame); ok && ee.Message().GetId() == id {
+				return ee.Message()
+			}
+		case <-timeout.C:
+			t.Fatalf("message %d not received", id)
+		}
+	}
+}
+
+func TestCameraError(t *testing.T) {
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&common.MessageHeartbeat{}}},
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5610"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	_, err = New(Conf{Node: node})
+	require.EqualError(t, err, "dialect does not contain message *common.MessageCommandLong")
+}
+
+func TestCamera(t *testing.T) {
+	e1, e2 := testnode.Pipe()
+	conf1 := testnode.Conf(1, e1)
+	conf1.OutComponentId = 100
+	conf2 := testnode.Conf(255, e2)
+	conf2.OutComponentId = 190
+	node1, node2 := testnode.New(t, conf1, conf2)
+	defer node1.Close()
+	defer node2.Close()
+	testnode.DiscardEvents(node1)
+
+	cam, err := New(Conf{
+		Node:       node1,
+		VendorName: "vendor",
+		ModelName:  "model",
+		OnImageCapture: func(index int) (*Capture, error) {
+			return &Capture{FileURL: "file.jpg"}, nil
+		},
+	})
+	require.NoError(t, err)
+	defer cam.Close()
+
+	// wait for the client to be recognized by the server
+	node2.WriteMessageAll(&common.MessageHeartbeat{})
+	time.Sleep(100 * time.Millisecond)
+
+	node2.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 100,
+		Command:         common.MAV_CMD_REQUEST_MESSAGE,
+		Param1:          259,
+	})
+
+	ack := waitMessage(t, node2, 77).(*common.MessageCommandAck)
+	require.Equal(t, common.MAV_CMD_REQUEST_MESSAGE, ack.Command)
+	require.Equal(t, common.MAV_RESULT_ACCEPTED, ack.Result)
+	require.Equal(t, uint8(255), ack.TargetSystem)
+	require.Equal(t, uint8(190), ack.TargetComponent)
+
+	info := waitMessage(t, node2, 259).(*common.MessageCameraInformation)
+	require.Equal(t, "vendor", string(info.VendorName[:6]))
+	require.Equal(t, "model", string(info.ModelName[:5]))
+	require.Equal(t, common.CAMERA_CAP_FLAGS_CAPTURE_IMAGE, info.Flags)
+
+	node2.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 100,
+		Command:         common.MAV_CMD_IMAGE_START_CAPTURE,
+		Param3:          1,
+	})
+
+	ack = waitMessage(t, node2, 77).(*common.MessageCommandAck)
+	require.Equal(t, common.MAV_RESULT_ACCEPTED, ack.Result)
+
+	capt := waitMessage(t, node2, 263).(*common.MessageCameraImageCaptured)
+	require.Equal(t, int32(0), capt.ImageIndex)
+	require.Equal(t, int8(1), capt.CaptureResult)
+	require.Equal(t, "file.jpg", capt.FileUrl)
+
+	node2.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 100,
+		Command:         common.MAV_CMD_VIDEO_START_CAPTURE,
+	})
+
+	ack = waitMessage(t, node2, 77).(*common.MessageCommandAck)
+	require.Equal(t, common.MAV_CMD_VIDEO_START_CAPTURE, ack.Command)
+	require.Equal(t, common.MAV_RESULT_UNSUPPORTED, ack.Result)
+}
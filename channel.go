@@ -2,6 +2,10 @@ package gomavlib
 
 import (
 	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/aler9/gomavlib/frame"
 	"github.com/aler9/gomavlib/msg"
@@ -23,39 +27,307 @@ type Channel struct {
 	writec    chan interface{}
 	terminate chan struct{}
 	done      chan struct{}
+
+	versionMutex   sync.Mutex
+	remoteVersions map[byte]Version
+	lastVersions   map[byte]Version
+	remoteV2       bool
+	versionStats   VersionStats
+
+	trafficMutex sync.Mutex
+	trafficStats TrafficStats
+
+	rttMutex sync.Mutex
+	rtt      time.Duration
+
+	// nil if NodeConf.LatencyStatsEnable is false
+	latency *latencyRecorder
+
+	// whether received frames are discarded
+	mirror bool
+
+	// set by NodeConf.ChannelAccept before the channel is opened
+	value interface{}
+
+	// NodeConf.SequenceStore, or the one of the signing domain
+	sequenceStore SequenceStore
+
+	// the error that stopped the reader, written before readerDone is closed
+	readErr error
+}
+
+// VersionStats contains the number of frames received through a channel,
+// grouped by Mavlink version.
+type VersionStats struct {
+	V1Frames uint64
+	V2Frames uint64
+}
+
+// TrafficStats contains the number of bytes read from and written to a channel,
+// the number of received frames that have been discarded because the
+// channel belongs to an EndpointMirror, and the number of messages and frames
+// that couldn't be written, e.g. because they can't be encoded.
+type TrafficStats struct {
+	BytesIn       uint64
+	BytesOut      uint64
+	DroppedFrames uint64
+	WriteErrors   uint64
 }
 
 func newChannel(n *Node, e Endpoint, label string, rwc io.ReadWriteCloser) (*Channel, error) {
+	ch := &Channel{
+		Endpoint:  e,
+		label:     label,
+		rwc:       rwc,
+		n:         n,
+		writec:    make(chan interface{}, n.conf.WriteQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+
+		remoteVersions: make(map[byte]Version),
+		lastVersions:   make(map[byte]Version),
+	}
+
+	inKey := n.conf.InKey
+	outKey := n.conf.OutKey
+	linkId := randomByte()
+	ch.sequenceStore = n.conf.SequenceStore
+
+	if d := endpointSigningDomain(e); d != nil {
+		inKey = d.InKey
+		outKey = d.OutKey
+		linkId = d.allocLinkId()
+		if d.SequenceStore != nil {
+			ch.sequenceStore = d.SequenceStore
+		}
+	}
+
+	ch.mirror = endpointIsMirror(e)
+
+	var onDecode func(time.Duration)
+	if n.conf.LatencyStatsEnable {
+		ch.latency = &latencyRecorder{}
+		onDecode = ch.latency.onDecode
+	}
+
+	tap := &channelTap{ch}
+	seq := ch.loadSequence()
+
 	transceiver, err := transceiver.New(transceiver.TransceiverConf{
-		Reader:      rwc,
-		Writer:      rwc,
-		DialectDE:   n.dialectDE,
-		InKey:       n.conf.InKey,
-		OutSystemId: n.conf.OutSystemId,
-		OutVersion: func() transceiver.Version {
-			if n.conf.OutVersion == V2 {
-				return transceiver.V2
-			}
-			return transceiver.V1
-		}(),
-		OutComponentId:     n.conf.OutComponentId,
-		OutSignatureLinkId: randomByte(),
-		OutKey:             n.conf.OutKey,
+		Reader:                 tap,
+		Writer:                 tap,
+		DialectDE:              n.dialectDE,
+		InKey:                  inKey,
+		OutSystemId:            n.conf.OutSystemId,
+		OutVersion:             transceiverVersion(n.conf.OutVersion),
+		OutComponentId:         n.conf.OutComponentId,
+		OutSignatureLinkId:     linkId,
+		OutKey:                 outKey,
+		OutTruncationDisable:   n.conf.OutTruncationDisable,
+		OutTruncationMinLength: n.conf.OutTruncationMinLength,
+		OutSequenceId:          seq.SequenceId,
+		OutSignatureTimestamp:  seq.SignatureTimestamp,
+		OnDecode:               onDecode,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &Channel{
-		Endpoint:    e,
-		label:       label,
-		rwc:         rwc,
-		n:           n,
-		transceiver: transceiver,
-		writec:      make(chan interface{}),
-		terminate:   make(chan struct{}),
-		done:        make(chan struct{}),
-	}, nil
+	ch.transceiver = transceiver
+	return ch, nil
+}
+
+func transceiverVersion(v Version) transceiver.Version {
+	if v == V2 {
+		return transceiver.V2
+	}
+	// VAuto starts with V1
+	return transceiver.V1
+}
+
+// RemoteVersion returns the Mavlink version used by a remote system
+// that is communicating through the channel. A system is considered using V2
+// as soon as it emits a V2 frame.
+func (ch *Channel) RemoteVersion(systemId byte) (Version, bool) {
+	ch.versionMutex.Lock()
+	defer ch.versionMutex.Unlock()
+
+	v, ok := ch.remoteVersions[systemId]
+	return v, ok
+}
+
+// VersionStats returns the number of frames received through the channel,
+// grouped by Mavlink version.
+func (ch *Channel) VersionStats() VersionStats {
+	ch.versionMutex.Lock()
+	defer ch.versionMutex.Unlock()
+	return ch.versionStats
+}
+
+// TrafficStats returns the number of bytes read from and written to the
+// channel since its creation.
+func (ch *Channel) TrafficStats() TrafficStats {
+	ch.trafficMutex.Lock()
+	defer ch.trafficMutex.Unlock()
+	return ch.trafficStats
+}
+
+// ReportRTT adds a measurement of the round trip time of the link of the
+// channel, i.e. obtained from PING or TIMESYNC exchanges. Measurements are
+// smoothed into the estimate returned by RTT.
+func (ch *Channel) ReportRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+
+	ch.rttMutex.Lock()
+	defer ch.rttMutex.Unlock()
+
+	// exponentially weighted moving average, as in TCP (RFC 6298)
+	if ch.rtt == 0 {
+		ch.rtt = rtt
+	} else {
+		ch.rtt += (rtt - ch.rtt) / 8
+	}
+}
+
+// RTT returns the estimated round trip time of the link of the channel,
+// or zero if no measurements have been reported with ReportRTT.
+func (ch *Channel) RTT() time.Duration {
+	ch.rttMutex.Lock()
+	defer ch.rttMutex.Unlock()
+	return ch.rtt
+}
+
+// adaptiveTimeout returns the longest between timeout and factor times the
+// estimated round trip time of the channel.
+func (ch *Channel) adaptiveTimeout(timeout time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return timeout
+	}
+
+	if t := time.Duration(float64(ch.RTT()) * factor); t > timeout {
+		return t
+	}
+	return timeout
+}
+
+// onFrameVersion updates the versions of the remote systems, and returns
+// true when a system that was sending V2 frames sends a V1 frame.
+func (ch *Channel) onFrameVersion(f frame.Frame) bool {
+	v := V1
+	if _, ok := f.(*frame.V2Frame); ok {
+		v = V2
+	}
+
+	ch.versionMutex.Lock()
+	defer ch.versionMutex.Unlock()
+
+	if v == V2 {
+		ch.versionStats.V2Frames++
+	} else {
+		ch.versionStats.V1Frames++
+	}
+
+	// versions are only upgraded
+	if cur, ok := ch.remoteVersions[f.GetSystemId()]; !ok || cur == V1 {
+		ch.remoteVersions[f.GetSystemId()] = v
+	}
+	if v == V2 {
+		ch.remoteV2 = true
+	}
+
+	last, ok := ch.lastVersions[f.GetSystemId()]
+	ch.lastVersions[f.GetSystemId()] = v
+	return ok && last == V2 && v == V1
+}
+
+// outVersion returns the version used to encode a message.
+func (ch *Channel) outVersion(m msg.Message) Version {
+	if ch.n.conf.OutVersion != VAuto {
+		return ch.n.conf.OutVersion
+	}
+
+	// messages with id > 255 can't be sent inside V1 frames
+	if msg.IsV2Only(m) {
+		return V2
+	}
+
+	ch.versionMutex.Lock()
+	defer ch.versionMutex.Unlock()
+
+	if target, ok := messageTargetSystem(m); ok {
+		if v, ok := ch.remoteVersions[target]; ok {
+			return v
+		}
+	}
+
+	if ch.remoteV2 {
+		return V2
+	}
+	return V1
+}
+
+// messageTargetSystem returns the TargetSystem field of a message, if present.
+func messageTargetSystem(m msg.Message) (byte, bool) {
+	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	f := rv.Elem().FieldByName("TargetSystem")
+	if !f.IsValid() || f.Kind() != reflect.Uint8 {
+		return 0, false
+	}
+
+	// 0 means broadcast
+	if f.Uint() == 0 {
+		return 0, false
+	}
+
+	return byte(f.Uint()), true
+}
+
+const mavTypeGcs = 6 // MAV_TYPE_GCS
+
+// isGcsHeartbeat checks whether a frame contains a heartbeat emitted by a
+// ground control station.
+func isGcsHeartbeat(f frame.Frame) bool {
+	m := f.GetMessage()
+	if m.GetId() != 0 {
+		return false
+	}
+
+	// frames of routers are usually not decoded. The type is the fifth byte of
+	// the payload, and is zero when truncated.
+	if raw, ok := m.(*msg.MessageRaw); ok {
+		return len(raw.Content) > 4 && raw.Content[4] == mavTypeGcs
+	}
+
+	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return false
+	}
+
+	t := rv.Elem().FieldByName("Type")
+	return t.IsValid() && t.Kind() == reflect.Int && t.Int() == mavTypeGcs
+}
+
+// RemoteAddr returns the address of the remote peer of the channel, if
+// available. In case of multicast endpoints, it is the address of the sender
+// of the last received datagram.
+func (ch *Channel) RemoteAddr() net.Addr {
+	if ra, ok := ch.rwc.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+// Value returns the value attached to the channel by NodeConf.ChannelAccept,
+// or nil. It can be used to retrieve per-client state from events, i.e.
+// evt.Channel.Value() inside EventFrame handlers.
+func (ch *Channel) Value() interface{} {
+	return ch.value
 }
 
 // String implements fmt.Stringer and returns the channel label.
@@ -75,23 +347,79 @@ func (ch *Channel) run() {
 		ch.n.eventsOut <- &EventChannelOpen{ch}
 
 		for {
+			var readStart time.Time
+			if ch.latency != nil {
+				readStart = time.Now()
+			}
+
 			frame, err := ch.transceiver.Read()
+
+			// stamp the frame before any further processing.
+			// time.Now() contains both a wall clock and a monotonic clock reading.
+			receiveTime := time.Now()
+
+			if ch.latency != nil {
+				ch.latency.onFrame(receiveTime.Sub(readStart))
+			}
+
 			if err != nil {
 				// continue in case of parse errors
 				if _, ok := err.(*transceiver.TransceiverError); ok {
 					ch.n.eventsOut <- &EventParseError{err, ch}
 					continue
 				}
+
+				if ge, ok := err.(*endpointGiveUpError); ok {
+					ch.n.eventsOut <- &EventEndpointGiveUp{
+						Channel:  ch,
+						Attempts: ge.attempts,
+						Error:    ge.err,
+					}
+				}
+				ch.readErr = err
 				return
 			}
 
-			evt := &EventFrame{frame, ch}
+			if ch.mirror {
+				ch.trafficMutex.Lock()
+				ch.trafficStats.DroppedFrames++
+				ch.trafficMutex.Unlock()
+				continue
+			}
+
+			if ch.onFrameVersion(frame) {
+				ch.n.eventsOut <- &EventVersionDowngrade{
+					Channel:     ch,
+					SystemId:    frame.GetSystemId(),
+					ComponentId: frame.GetComponentId(),
+				}
+			}
+
+			evt := &EventFrame{
+				Frame:       frame,
+				Channel:     ch,
+				ReceiveTime: receiveTime,
+			}
 
 			if ch.n.nodeStreamRequest != nil {
 				ch.n.nodeStreamRequest.onEventFrame(evt)
 			}
 
+			if ch.n.nodePresence != nil {
+				ch.n.nodePresence.onEventFrame(evt)
+			}
+
+			if ch.n.nodeTimesync != nil {
+				ch.n.nodeTimesync.onEventFrame(evt)
+			}
+
+			ch.n.callFrameHandlers(evt)
+
 			ch.n.eventsOut <- evt
+
+			if ch.latency != nil {
+				ch.latency.add(&ch.latency.stats.Route, time.Since(receiveTime))
+			}
 		}
 	}()
 
@@ -99,20 +427,55 @@ func (ch *Channel) run() {
 	go func() {
 		defer close(writerDone)
 
+		store := ch.sequenceStore
+		lastSave := time.Now()
+
 		for what := range ch.writec {
+			var writeStart time.Time
+			if ch.latency != nil {
+				writeStart = time.Now()
+			}
+
+			var err error
+
 			switch wh := what.(type) {
 			case msg.Message:
-				ch.transceiver.WriteMessage(wh)
+				err = ch.transceiver.WriteMessageVersion(wh, transceiverVersion(ch.outVersion(wh)))
 
 			case frame.Frame:
-				ch.transceiver.WriteFrame(wh)
+				if ch.n.conf.GcsHeartbeatSuppress != nil && isGcsHeartbeat(wh) &&
+					ch.n.conf.GcsHeartbeatSuppress(ch) {
+					continue
+				}
+				err = ch.transceiver.WriteFrame(wh)
 			}
+
+			// events can't be emitted by the writer, since the routine that
+			// reads them may be waiting for a write
+			if err != nil {
+				ch.trafficMutex.Lock()
+				ch.trafficStats.WriteErrors++
+				ch.trafficMutex.Unlock()
+			}
+
+			if ch.latency != nil {
+				ch.latency.add(&ch.latency.stats.Write, time.Since(writeStart))
+			}
+
+			if store != nil && time.Since(lastSave) >= ch.n.conf.SequenceStorePeriod {
+				ch.saveSequence()
+				lastSave = time.Now()
+			}
+		}
+
+		if store != nil {
+			ch.saveSequence()
 		}
 	}()
 
 	select {
 	case <-readerDone:
-		ch.n.eventsOut <- &EventChannelClose{ch}
+		ch.n.eventsOut <- &EventChannelClose{Channel: ch, Error: ch.readErr}
 
 		ch.n.channelClose <- ch
 		<-ch.terminate
@@ -123,7 +486,7 @@ func (ch *Channel) run() {
 		ch.rwc.Close()
 
 	case <-ch.terminate:
-		ch.n.eventsOut <- &EventChannelClose{ch}
+		ch.n.eventsOut <- &EventChannelClose{Channel: ch}
 
 		close(ch.writec)
 		<-writerDone
@@ -41,6 +41,15 @@ func (ca *channelAccepter) run() {
 			panic(fmt.Errorf("newChannel unexpected error: %s", err))
 		}
 
+		if ca.n.conf.ChannelAccept != nil {
+			value, ok := ca.n.conf.ChannelAccept(ch)
+			if !ok {
+				rwc.Close()
+				continue
+			}
+			ch.value = value
+		}
+
 		ca.n.channelNew <- ch
 	}
 }
@@ -0,0 +1,443 @@
+// Package command implements a sender of commands, that waits for their
+// acknowledgement and retries them according to the received MAV_RESULT.
+//
+// A command is sent with COMMAND_LONG or COMMAND_INT, it is correlated with
+// the COMMAND_ACK messages of the target that refer to the same command, and
+// it is:
+//   - retransmitted, with an increasing confirmation field in case of
+//     COMMAND_LONG, when it is not acknowledged within a timeout
+//   - sent again after a delay, with an optional backoff, when it is
+//     acknowledged with MAV_RESULT_TEMPORARILY_REJECTED
+//   - considered running when it is acknowledged with MAV_RESULT_IN_PROGRESS,
+//     until a final acknowledgement is received; progress updates are
+//     forwarded to a callback, in order to follow long-running commands like
+//     calibrations
+//   - completed by any other result; results other than MAV_RESULT_ACCEPTED
+//     are returned as a ResultError.
+//
+// The sender also requests single messages and message intervals, with
+// MAV_CMD_REQUEST_MESSAGE and MAV_CMD_SET_MESSAGE_INTERVAL, falling back to
+// REQUEST_DATA_STREAM for ArduPilot autopilots that do not support them.
+//
+// The node to which the sender is attached must use a dialect that contains
+// the common messages.
+package command
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const ackQueueSize = 16
+
+// ErrTimeout is returned when a command is not acknowledged, or when a
+// command in progress doesn't send updates.
+var ErrTimeout = fmt.Errorf("command timed out")
+
+// ResultError is returned when a command is not accepted.
+type ResultError struct {
+	// the last acknowledgement of the command
+	Ack *common.MessageCommandAck
+}
+
+// Error implements the error interface.
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("command %s failed: %s", e.Ack.Command, e.Ack.Result)
+}
+
+// RetryPolicy allows to configure how a command is retried.
+type RetryPolicy struct {
+	// (optional) the time after which a command that has not been
+	// acknowledged is retransmitted.
+	// It defaults to 1.5s.
+	AckTimeout time.Duration
+
+	// (optional) the maximum number of retransmissions of a command that has
+	// not been acknowledged.
+	// It defaults to 3.
+	MaxRetransmissions int
+
+	// (optional) the delay before sending again a command that has been
+	// temporarily rejected.
+	// It defaults to 1s.
+	RejectDelay time.Duration
+
+	// (optional) the maximum delay before sending again a command that has
+	// been temporarily rejected.
+	// It defaults to RejectDelay.
+	MaxRejectDelay time.Duration
+
+	// (optional) the factor by which the delay is multiplied after every
+	// rejection, i.e. 2 for an exponential backoff.
+	// It defaults to 1, i.e. a fixed delay.
+	Multiplier float64
+
+	// (optional) the maximum number of times a command can be temporarily
+	// rejected, after which the rejection is returned.
+	// It defaults to 5.
+	MaxRejections int
+
+	// (optional) the maximum time between two acknowledgements of a command
+	// in progress.
+	// It defaults to 5s.
+	ProgressTimeout time.Duration
+}
+
+func (p RetryPolicy) fill() (RetryPolicy, error) {
+	if p.AckTimeout < 0 || p.MaxRetransmissions < 0 || p.RejectDelay < 0 ||
+		p.MaxRejectDelay < 0 || p.Multiplier < 0 || p.MaxRejections < 0 ||
+		p.ProgressTimeout < 0 {
+		return p, fmt.Errorf("retry policy parameters must be >= 0")
+	}
+
+	if p.AckTimeout == 0 {
+		p.AckTimeout = 1500 * time.Millisecond
+	}
+	if p.MaxRetransmissions == 0 {
+		p.MaxRetransmissions = 3
+	}
+	if p.RejectDelay == 0 {
+		p.RejectDelay = 1 * time.Second
+	}
+	if p.MaxRejectDelay == 0 {
+		p.MaxRejectDelay = p.RejectDelay
+	}
+	if p.MaxRejectDelay < p.RejectDelay {
+		return p, fmt.Errorf("retry policy MaxRejectDelay must be >= RejectDelay")
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = 1
+	}
+	if p.Multiplier < 1 {
+		return p, fmt.Errorf("retry policy Multiplier must be >= 1")
+	}
+	if p.MaxRejections == 0 {
+		p.MaxRejections = 5
+	}
+	if p.ProgressTimeout == 0 {
+		p.ProgressTimeout = 5 * time.Second
+	}
+
+	return p, nil
+}
+
+// Conf allows to configure a Sender.
+type Conf struct {
+	// the node with which commands are sent.
+	Node *gomavlib.Node
+
+	// the system id of the target of commands.
+	SystemId byte
+
+	// (optional) the component id of the target of commands.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the retry policy of commands.
+	Retry RetryPolicy
+
+	// (optional) retry policies of specific commands, that override Retry,
+	// i.e. to allow a longer ProgressTimeout to calibrations.
+	CommandRetry map[common.MAV_CMD]RetryPolicy
+}
+
+// Sender sends commands to a system.
+type Sender struct {
+	conf          Conf
+	policies      map[common.MAV_CMD]RetryPolicy
+	removeHandler func()
+
+	mutex   sync.Mutex
+	pending map[common.MAV_CMD]chan *common.MessageCommandAck
+
+	terminate chan struct{}
+}
+
+// New allocates a Sender. See Conf for the options.
+func New(conf Conf) (*Sender, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	conf.Retry, err = conf.Retry.fill()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(map[common.MAV_CMD]RetryPolicy)
+	for cmd, p := range conf.CommandRetry {
+		policies[cmd], err = p.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Sender{
+		conf:      conf,
+		policies:  policies,
+		pending:   make(map[common.MAV_CMD]chan *common.MessageCommandAck),
+		terminate: make(chan struct{}),
+	}
+
+	s.removeHandler = conf.Node.AddFrameHandler(s.onEventFrame)
+
+	return s, nil
+}
+
+// Close stops the sender. Pending commands return an error.
+// It must be called before closing the node.
+func (s *Sender) Close() {
+	s.removeHandler()
+	close(s.terminate)
+}
+
+func (s *Sender) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != s.conf.SystemId || evt.ComponentId() != s.conf.ComponentId {
+		return
+	}
+
+	if evt.Message().GetId() != (&common.MessageCommandAck{}).GetId() {
+		return
+	}
+
+	var ack common.MessageCommandAck
+	if msg.Convert(&ack, evt.Message()) != nil {
+		return
+	}
+
+	nconf := s.conf.Node.Conf()
+	if (ack.TargetSystem != 0 && ack.TargetSystem != nconf.OutSystemId) ||
+		(ack.TargetComponent != 0 && ack.TargetComponent != nconf.OutComponentId) {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch, ok := s.pending[ack.Command]
+	if !ok {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case ch <- &ack:
+	default:
+	}
+}
+
+// Send sends a command with COMMAND_LONG, filling its target and
+// confirmation fields, and waits until it is completed. The optional progress
+// callback is called with the progress of the command, in percent, when it is
+// in progress; the value is 255 when the progress is unknown.
+// It returns the final acknowledgement, or an error if the command has not
+// been accepted. Only a command with a given id can be sent at once.
+func (s *Sender) Send(cmd *common.MessageCommandLong,
+	progress func(uint8)) (*common.MessageCommandAck, error) {
+	return s.SendLong(context.Background(), cmd, progress)
+}
+
+// SendLong is like Send, but it stops waiting when the context is done.
+func (s *Sender) SendLong(ctx context.Context, cmd *common.MessageCommandLong,
+	progress func(uint8)) (*common.MessageCommandAck, error) {
+	out := *cmd
+	out.TargetSystem = s.conf.SystemId
+	out.TargetComponent = s.conf.ComponentId
+
+	// messages are encoded asynchronously, therefore a copy is written
+	write := func(confirmation uint8) error {
+		m := out
+		m.Confirmation = confirmation
+		return s.conf.Node.WriteMessageAllChecked(&m)
+	}
+
+	return s.send(ctx, cmd.Command, write, progress)
+}
+
+// SendInt is like SendLong, but the command is sent with COMMAND_INT, that
+// allows to send positions without loss of precision. Since COMMAND_INT
+// has no confirmation field, retransmissions are identical to the first
+// transmission. The dialect of the node must contain COMMAND_INT.
+func (s *Sender) SendInt(ctx context.Context, cmd *common.MessageCommandInt,
+	progress func(uint8)) (*common.MessageCommandAck, error) {
+	err := s.conf.Node.Conf().Dialect.CheckMessages(&common.MessageCommandInt{})
+	if err != nil {
+		return nil, err
+	}
+
+	out := *cmd
+	out.TargetSystem = s.conf.SystemId
+	out.TargetComponent = s.conf.ComponentId
+
+	write := func(uint8) error {
+		m := out
+		return s.conf.Node.WriteMessageAllChecked(&m)
+	}
+
+	return s.send(ctx, cmd.Command, write, progress)
+}
+
+func (s *Sender) send(ctx context.Context, cmd common.MAV_CMD, write func(confirmation uint8) error,
+	progress func(uint8)) (*common.MessageCommandAck, error) {
+	policy := s.policy(cmd)
+
+	acks, err := s.addPending(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer s.removePending(cmd)
+
+	confirmation := uint8(0)
+	retransmissions := 0
+	rejections := 0
+	rejectDelay := policy.RejectDelay
+	inProgress := false
+
+	// a command that is discarded by the node is never sent, therefore
+	// it is not retried
+	err = write(confirmation)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(policy.AckTimeout)
+	defer timer.Stop()
+
+	resetTimer := func(d time.Duration) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+	}
+
+	for {
+		select {
+		case ack := <-acks:
+			switch ack.Result {
+			case common.MAV_RESULT_ACCEPTED:
+				return ack, nil
+
+			case common.MAV_RESULT_IN_PROGRESS:
+				inProgress = true
+				if progress != nil {
+					progress(ack.Progress)
+				}
+				resetTimer(policy.ProgressTimeout)
+
+			case common.MAV_RESULT_TEMPORARILY_REJECTED:
+				// a rejection of a command in progress is final
+				if inProgress {
+					return ack, &ResultError{ack}
+				}
+
+				rejections++
+				if rejections > policy.MaxRejections {
+					return ack, &ResultError{ack}
+				}
+
+				select {
+				case <-time.After(rejectDelay):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-s.terminate:
+					return nil, fmt.Errorf("terminated")
+				}
+
+				rejectDelay = time.Duration(float64(rejectDelay) * policy.Multiplier)
+				if rejectDelay > policy.MaxRejectDelay {
+					rejectDelay = policy.MaxRejectDelay
+				}
+
+				// discard the acknowledgements received while waiting
+				for len(acks) > 0 {
+					<-acks
+				}
+
+				retransmissions = 0
+				confirmation = 0
+				err := write(confirmation)
+				if err != nil {
+					return nil, err
+				}
+				resetTimer(policy.AckTimeout)
+
+			default:
+				return ack, &ResultError{ack}
+			}
+
+		case <-timer.C:
+			// commands in progress must not be retransmitted
+			if inProgress || retransmissions >= policy.MaxRetransmissions {
+				return nil, ErrTimeout
+			}
+
+			retransmissions++
+			confirmation++
+			err := write(confirmation)
+			if err != nil {
+				return nil, err
+			}
+			timer.Reset(policy.AckTimeout)
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-s.terminate:
+			return nil, fmt.Errorf("terminated")
+		}
+	}
+}
+
+func (s *Sender) policy(cmd common.MAV_CMD) RetryPolicy {
+	if policy, ok := s.policies[cmd]; ok {
+		return policy
+	}
+	return s.conf.Retry
+}
+
+func (s *Sender) addPending(cmd common.MAV_CMD) (chan *common.MessageCommandAck, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.pending[cmd]; ok {
+		return nil, fmt.Errorf("command %s is already pending", cmd)
+	}
+
+	ch := make(chan *common.MessageCommandAck, ackQueueSize)
+	s.pending[cmd] = ch
+	return ch, nil
+}
+
+func (s *Sender) removePending(cmd common.MAV_CMD) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.pending, cmd)
+}
@@ -0,0 +1,406 @@
+package command
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// testVehicle answers commands with the acknowledgements returned by a
+// function, and records the received commands.
+type testVehicle struct {
+	node *gomavlib.Node
+
+	mutex       sync.Mutex
+	received    []common.MessageCommandLong
+	receivedInt []common.MessageCommandInt
+}
+
+func newTestVehicle(t *testing.T,
+	answer func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck) (*gomavlib.Node, *testVehicle) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{node: node}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		var cmd *common.MessageCommandLong
+		var n int
+
+		switch tm := fr.Message().(type) {
+		case *common.MessageCommandLong:
+			cmd = tm
+			v.mutex.Lock()
+			v.received = append(v.received, *cmd)
+			n = len(v.received)
+			v.mutex.Unlock()
+
+		case *common.MessageCommandInt:
+			// COMMAND_INT is answered like the equivalent COMMAND_LONG
+			cmd = &common.MessageCommandLong{Command: tm.Command}
+			v.mutex.Lock()
+			v.receivedInt = append(v.receivedInt, *tm)
+			n = len(v.receivedInt)
+			v.mutex.Unlock()
+
+		default:
+			return
+		}
+
+		for _, a := range answer(n, cmd) {
+			ack := a
+			ack.Command = cmd.Command
+			ack.TargetSystem = 255
+			node.WriteMessageAll(&ack)
+		}
+	})
+
+	return gcs, v
+}
+
+func (v *testVehicle) confirmations() []uint8 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	var ret []uint8
+	for _, cmd := range v.received {
+		ret = append(ret, cmd.Confirmation)
+	}
+	return ret
+}
+
+var testPolicy = RetryPolicy{
+	AckTimeout:      100 * time.Millisecond,
+	RejectDelay:     20 * time.Millisecond,
+	ProgressTimeout: 200 * time.Millisecond,
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, v := newTestVehicle(t, func(int, *common.MessageCommandLong) []common.MessageCommandAck {
+		return nil
+	})
+	defer gcs.Close()
+	defer v.node.Close()
+
+	_, err = New(Conf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+
+	_, err = New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Retry:    RetryPolicy{RejectDelay: 2 * time.Second, MaxRejectDelay: 1 * time.Second},
+	})
+	require.EqualError(t, err, "retry policy MaxRejectDelay must be >= RejectDelay")
+
+	_, err = New(Conf{
+		Node:         gcs,
+		SystemId:     1,
+		CommandRetry: map[common.MAV_CMD]RetryPolicy{common.MAV_CMD_PREFLIGHT_CALIBRATION: {Multiplier: 0.5}},
+	})
+	require.EqualError(t, err, "retry policy Multiplier must be >= 1")
+}
+
+func TestSend(t *testing.T) {
+	for _, ca := range []struct {
+		name          string
+		answer        func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck
+		confirmations []uint8
+		progress      []uint8
+		result        common.MAV_RESULT
+		err           string
+	}{
+		{
+			"accepted",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				return []common.MessageCommandAck{{Result: common.MAV_RESULT_ACCEPTED}}
+			},
+			[]uint8{0},
+			nil,
+			common.MAV_RESULT_ACCEPTED,
+			"",
+		},
+		{
+			"retransmitted",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				if n < 3 {
+					return nil
+				}
+				return []common.MessageCommandAck{{Result: common.MAV_RESULT_ACCEPTED}}
+			},
+			[]uint8{0, 1, 2},
+			nil,
+			common.MAV_RESULT_ACCEPTED,
+			"",
+		},
+		{
+			"timeout",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				return nil
+			},
+			[]uint8{0, 1, 2, 3},
+			nil,
+			0,
+			"command timed out",
+		},
+		{
+			"temporarily rejected",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				if n < 3 {
+					return []common.MessageCommandAck{{Result: common.MAV_RESULT_TEMPORARILY_REJECTED}}
+				}
+				return []common.MessageCommandAck{{Result: common.MAV_RESULT_ACCEPTED}}
+			},
+			[]uint8{0, 0, 0},
+			nil,
+			common.MAV_RESULT_ACCEPTED,
+			"",
+		},
+		{
+			"too many rejections",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				return []common.MessageCommandAck{{Result: common.MAV_RESULT_TEMPORARILY_REJECTED}}
+			},
+			[]uint8{0, 0, 0, 0, 0, 0},
+			nil,
+			common.MAV_RESULT_TEMPORARILY_REJECTED,
+			"command MAV_CMD_PREFLIGHT_CALIBRATION failed: MAV_RESULT_TEMPORARILY_REJECTED",
+		},
+		{
+			"denied",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				return []common.MessageCommandAck{{Result: common.MAV_RESULT_DENIED}}
+			},
+			[]uint8{0},
+			nil,
+			common.MAV_RESULT_DENIED,
+			"command MAV_CMD_PREFLIGHT_CALIBRATION failed: MAV_RESULT_DENIED",
+		},
+		{
+			"in progress",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				return []common.MessageCommandAck{
+					{Result: common.MAV_RESULT_IN_PROGRESS, Progress: 0},
+					{Result: common.MAV_RESULT_IN_PROGRESS, Progress: 50},
+					{Result: common.MAV_RESULT_ACCEPTED},
+				}
+			},
+			[]uint8{0},
+			[]uint8{0, 50},
+			common.MAV_RESULT_ACCEPTED,
+			"",
+		},
+		{
+			"in progress timeout",
+			func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+				return []common.MessageCommandAck{
+					{Result: common.MAV_RESULT_IN_PROGRESS, Progress: 255},
+				}
+			},
+			[]uint8{0},
+			[]uint8{255},
+			0,
+			"command timed out",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			gcs, v := newTestVehicle(t, ca.answer)
+			defer gcs.Close()
+			defer v.node.Close()
+
+			s, err := New(Conf{
+				Node:     gcs,
+				SystemId: 1,
+				Retry:    testPolicy,
+			})
+			require.NoError(t, err)
+			defer s.Close()
+
+			var progress []uint8
+			ack, err := s.Send(&common.MessageCommandLong{
+				Command: common.MAV_CMD_PREFLIGHT_CALIBRATION,
+				Param1:  1,
+			}, func(p uint8) {
+				progress = append(progress, p)
+			})
+
+			if ca.err != "" {
+				require.EqualError(t, err, ca.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if ca.err == "command timed out" {
+				require.Nil(t, ack)
+			} else {
+				require.Equal(t, ca.result, ack.Result)
+			}
+
+			require.Equal(t, ca.confirmations, v.confirmations())
+			require.Equal(t, ca.progress, progress)
+
+			v.mutex.Lock()
+			defer v.mutex.Unlock()
+			for _, cmd := range v.received {
+				require.Equal(t, uint8(1), cmd.TargetSystem)
+				require.Equal(t, uint8(1), cmd.TargetComponent)
+				require.Equal(t, float32(1), cmd.Param1)
+			}
+		})
+	}
+}
+
+func TestSendPending(t *testing.T) {
+	gcs, v := newTestVehicle(t, func(int, *common.MessageCommandLong) []common.MessageCommandAck {
+		return nil
+	})
+	defer gcs.Close()
+	defer v.node.Close()
+
+	s, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Retry:    testPolicy,
+	})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.Send(&common.MessageCommandLong{Command: common.MAV_CMD_PREFLIGHT_CALIBRATION}, nil)
+		done <- err
+	}()
+
+	for len(v.confirmations()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = s.Send(&common.MessageCommandLong{Command: common.MAV_CMD_PREFLIGHT_CALIBRATION}, nil)
+	require.EqualError(t, err, "command MAV_CMD_PREFLIGHT_CALIBRATION is already pending")
+
+	// pending commands are interrupted by Close()
+	s.Close()
+	require.EqualError(t, <-done, "terminated")
+}
+
+func TestSendInt(t *testing.T) {
+	gcs, v := newTestVehicle(t, func(n int, cmd *common.MessageCommandLong) []common.MessageCommandAck {
+		if n < 2 {
+			return nil
+		}
+		return []common.MessageCommandAck{
+			{Result: common.MAV_RESULT_IN_PROGRESS, Progress: 50},
+			{Result: common.MAV_RESULT_ACCEPTED},
+		}
+	})
+	defer gcs.Close()
+	defer v.node.Close()
+
+	s, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Retry:    testPolicy,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	var progress []uint8
+	ack, err := s.SendInt(context.Background(), &common.MessageCommandInt{
+		Command: common.MAV_CMD_DO_REPOSITION,
+		Frame:   common.MAV_FRAME_GLOBAL_INT,
+		X:       453654321,
+		Y:       91234567,
+		Z:       30,
+	}, func(p uint8) {
+		progress = append(progress, p)
+	})
+	require.NoError(t, err)
+	require.Equal(t, common.MAV_RESULT_ACCEPTED, ack.Result)
+	require.Equal(t, []uint8{50}, progress)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	require.Equal(t, 2, len(v.receivedInt))
+	for _, cmd := range v.receivedInt {
+		require.Equal(t, common.MessageCommandInt{
+			TargetSystem:    1,
+			TargetComponent: 1,
+			Command:         common.MAV_CMD_DO_REPOSITION,
+			Frame:           common.MAV_FRAME_GLOBAL_INT,
+			X:               453654321,
+			Y:               91234567,
+			Z:               30,
+		}, cmd)
+	}
+}
+
+func TestSendContext(t *testing.T) {
+	gcs, v := newTestVehicle(t, func(int, *common.MessageCommandLong) []common.MessageCommandAck {
+		return []common.MessageCommandAck{{Result: common.MAV_RESULT_IN_PROGRESS}}
+	})
+	defer gcs.Close()
+	defer v.node.Close()
+
+	s, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Retry:    RetryPolicy{ProgressTimeout: 10 * time.Second},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = s.SendLong(ctx, &common.MessageCommandLong{Command: common.MAV_CMD_PREFLIGHT_CALIBRATION}, nil)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// the command can be sent again
+	_, err = s.SendLong(ctx, &common.MessageCommandLong{Command: common.MAV_CMD_PREFLIGHT_CALIBRATION}, nil)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestSendDiscarded(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	conf := testnode.Conf(255, gomavlib.EndpointCustom{ReadWriteCloser: c1})
+	conf.OutGuard = func(msg.Message) bool {
+		return false
+	}
+
+	gcs, err := gomavlib.NewNode(conf)
+	require.NoError(t, err)
+	defer gcs.Close()
+	testnode.DiscardEvents(gcs)
+
+	s, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Retry:    RetryPolicy{AckTimeout: 10 * time.Second},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// discarded commands are not retried
+	_, err = s.SendLong(context.Background(),
+		&common.MessageCommandLong{Command: common.MAV_CMD_PREFLIGHT_CALIBRATION}, nil)
+	require.Equal(t, gomavlib.ErrMessageGuarded, err)
+
+	_, err = s.SendInt(context.Background(),
+		&common.MessageCommandInt{Command: common.MAV_CMD_DO_REPOSITION}, nil)
+	require.Equal(t, gomavlib.ErrMessageGuarded, err)
+
+	_, err = s.requestDataStream(74, 1)
+	require.Equal(t, gomavlib.ErrMessageGuarded, err)
+}
@@ -0,0 +1,223 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// streams of ArduPilot that contain each message, used by the fallback
+// to REQUEST_DATA_STREAM.
+// https://github.com/ArduPilot/ardupilot/blob/master/ArduCopter/GCS_Mavlink.cpp
+var ardupilotStreams = map[uint32]common.MAV_DATA_STREAM{
+	27:  common.MAV_DATA_STREAM_RAW_SENSORS,     // RAW_IMU
+	29:  common.MAV_DATA_STREAM_RAW_SENSORS,     // SCALED_PRESSURE
+	116: common.MAV_DATA_STREAM_RAW_SENSORS,     // SCALED_IMU2
+	129: common.MAV_DATA_STREAM_RAW_SENSORS,     // SCALED_IMU3
+	1:   common.MAV_DATA_STREAM_EXTENDED_STATUS, // SYS_STATUS
+	24:  common.MAV_DATA_STREAM_EXTENDED_STATUS, // GPS_RAW_INT
+	42:  common.MAV_DATA_STREAM_EXTENDED_STATUS, // MISSION_CURRENT
+	62:  common.MAV_DATA_STREAM_EXTENDED_STATUS, // NAV_CONTROLLER_OUTPUT
+	124: common.MAV_DATA_STREAM_EXTENDED_STATUS, // GPS2_RAW
+	125: common.MAV_DATA_STREAM_EXTENDED_STATUS, // POWER_STATUS
+	152: common.MAV_DATA_STREAM_EXTENDED_STATUS, // MEMINFO
+	162: common.MAV_DATA_STREAM_EXTENDED_STATUS, // FENCE_STATUS
+	35:  common.MAV_DATA_STREAM_RC_CHANNELS,     // RC_CHANNELS_RAW
+	36:  common.MAV_DATA_STREAM_RC_CHANNELS,     // SERVO_OUTPUT_RAW
+	65:  common.MAV_DATA_STREAM_RC_CHANNELS,     // RC_CHANNELS
+	32:  common.MAV_DATA_STREAM_POSITION,        // LOCAL_POSITION_NED
+	33:  common.MAV_DATA_STREAM_POSITION,        // GLOBAL_POSITION_INT
+	30:  common.MAV_DATA_STREAM_EXTRA1,          // ATTITUDE
+	178: common.MAV_DATA_STREAM_EXTRA1,          // AHRS2
+	194: common.MAV_DATA_STREAM_EXTRA1,          // PID_TUNING
+	74:  common.MAV_DATA_STREAM_EXTRA2,          // VFR_HUD
+	2:   common.MAV_DATA_STREAM_EXTRA3,          // SYSTEM_TIME
+	132: common.MAV_DATA_STREAM_EXTRA3,          // DISTANCE_SENSOR
+	147: common.MAV_DATA_STREAM_EXTRA3,          // BATTERY_STATUS
+	163: common.MAV_DATA_STREAM_EXTRA3,          // AHRS
+	165: common.MAV_DATA_STREAM_EXTRA3,          // HWSTATUS
+	173: common.MAV_DATA_STREAM_EXTRA3,          // RANGEFINDER
+	241: common.MAV_DATA_STREAM_EXTRA3,          // VIBRATION
+}
+
+// isUnsupported returns whether an error means that a command is not
+// supported by the target.
+func isUnsupported(err error) bool {
+	if err == ErrTimeout {
+		return true
+	}
+	if re, ok := err.(*ResultError); ok && re.Ack.Result == common.MAV_RESULT_UNSUPPORTED {
+		return true
+	}
+	return false
+}
+
+// isArdupilot returns whether the target is an ArduPilot autopilot, according
+// to its heartbeats.
+func (s *Sender) isArdupilot() bool {
+	for _, c := range s.conf.Node.Components() {
+		if c.SystemId == s.conf.SystemId && c.ComponentId == s.conf.ComponentId {
+			return c.Autopilot == int(common.MAV_AUTOPILOT_ARDUPILOTMEGA)
+		}
+	}
+	return false
+}
+
+// requestDataStream asks an ArduPilot target to send the stream that
+// contains a message, with REQUEST_DATA_STREAM. It returns false if the
+// message doesn't belong to a stream, and an error if the request has been
+// discarded by the node.
+func (s *Sender) requestDataStream(messageId uint32, rate int) (bool, error) {
+	stream, ok := ardupilotStreams[messageId]
+	if !ok {
+		return false, nil
+	}
+
+	if s.conf.Node.Conf().Dialect.CheckMessages(&common.MessageRequestDataStream{}) != nil {
+		return false, nil
+	}
+
+	startStop := uint8(1)
+	if rate == 0 {
+		startStop = 0
+	}
+
+	err := s.conf.Node.WriteMessageAllChecked(&common.MessageRequestDataStream{
+		TargetSystem:    s.conf.SystemId,
+		TargetComponent: s.conf.ComponentId,
+		ReqStreamId:     uint8(stream),
+		ReqMessageRate:  uint16(rate),
+		StartStop:       startStop,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RequestMessage asks the target to send a single instance of a message,
+// with MAV_CMD_REQUEST_MESSAGE, and returns the message.
+// The command is sent again when the message is not received within the
+// AckTimeout of the retry policy, up to MaxRetransmissions times.
+// If the target is an ArduPilot autopilot that does not support the command,
+// the stream that contains the message is requested with REQUEST_DATA_STREAM,
+// and the next instance of the message is returned. It is waited for
+// AckTimeout * (MaxRetransmissions + 1).
+func (s *Sender) RequestMessage(ctx context.Context, messageId uint32) (msg.Message, error) {
+	// the message may be received before the acknowledgement
+	messages := make(chan msg.Message, 1)
+	removeHandler := s.conf.Node.AddFrameHandler(func(evt *gomavlib.EventFrame) {
+		if evt.SystemId() == s.conf.SystemId && evt.ComponentId() == s.conf.ComponentId &&
+			evt.Message().GetId() == messageId {
+			// frame handlers must not block
+			select {
+			case messages <- evt.Message():
+			default:
+			}
+		}
+	})
+	defer removeHandler()
+
+	policy := s.policy(common.MAV_CMD_REQUEST_MESSAGE)
+
+	for i := 0; ; i++ {
+		_, err := s.SendLong(ctx, &common.MessageCommandLong{
+			Command: common.MAV_CMD_REQUEST_MESSAGE,
+			Param1:  float32(messageId),
+		}, nil)
+		if err != nil {
+			if !isUnsupported(err) || !s.isArdupilot() {
+				return nil, err
+			}
+
+			ok, err2 := s.requestDataStream(messageId, 1)
+			if err2 != nil {
+				return nil, err2
+			}
+			if !ok {
+				return nil, err
+			}
+
+			// the message is sent with the next update of the stream, that is
+			// waited as long as the command and all its retransmissions
+			return s.waitMessage(ctx, messages, policy.AckTimeout*time.Duration(policy.MaxRetransmissions+1))
+		}
+
+		m, err := s.waitMessage(ctx, messages, policy.AckTimeout)
+		if err != ErrTimeout || i >= policy.MaxRetransmissions {
+			return m, err
+		}
+	}
+}
+
+func (s *Sender) waitMessage(ctx context.Context, messages chan msg.Message,
+	timeout time.Duration) (msg.Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case m := <-messages:
+		return m, nil
+
+	case <-timer.C:
+		return nil, ErrTimeout
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case <-s.terminate:
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// SetMessageInterval asks the target to send a message periodically, with
+// MAV_CMD_SET_MESSAGE_INTERVAL, and waits for the acknowledgement.
+// If interval is zero, the default interval of the message is restored;
+// if it is negative, the message is disabled.
+// If the target is an ArduPilot autopilot that does not support the command,
+// the stream that contains the message is requested with REQUEST_DATA_STREAM,
+// whose rate applies to all the messages of the stream.
+func (s *Sender) SetMessageInterval(ctx context.Context, messageId uint32,
+	interval time.Duration) error {
+	var param2 float32
+	switch {
+	case interval < 0:
+		param2 = -1
+	case interval > 0:
+		param2 = float32(interval / time.Microsecond)
+	}
+
+	_, err := s.SendLong(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_SET_MESSAGE_INTERVAL,
+		Param1:  float32(messageId),
+		Param2:  param2,
+	}, nil)
+	if err == nil || !isUnsupported(err) || !s.isArdupilot() {
+		return err
+	}
+
+	// REQUEST_DATA_STREAM does not have an acknowledgement
+	rate := 0
+	switch {
+	case interval == 0:
+		rate = s.conf.Node.Conf().StreamRequestFrequency
+	case interval > 0:
+		rate = int(time.Second / interval)
+		if rate < 1 {
+			rate = 1
+		}
+	}
+
+	ok, err2 := s.requestDataStream(messageId, rate)
+	if err2 != nil {
+		return err2
+	}
+	if !ok {
+		return err
+	}
+	return nil
+}
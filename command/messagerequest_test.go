@@ -0,0 +1,165 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func testMessageRequest(t *testing.T, autopilot common.MAV_AUTOPILOT, result common.MAV_RESULT,
+	ackTarget byte, f func(s *Sender, streams chan *common.MessageRequestDataStream)) {
+	e1, e2 := testnode.Pipe()
+	vehicleConf := testnode.Conf(1, e2)
+	vehicleConf.HeartbeatDisable = false
+	vehicleConf.HeartbeatPeriod = 50 * time.Millisecond
+	vehicleConf.HeartbeatAutopilotType = int(autopilot)
+
+	gcs, vehicle := testnode.New(t, testnode.Conf(255, e1), vehicleConf)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+
+	streams := make(chan *common.MessageRequestDataStream, 10)
+
+	go func() {
+		for evt := range vehicle.Events() {
+			fr, ok := evt.(*gomavlib.EventFrame)
+			if !ok {
+				continue
+			}
+
+			switch m := fr.Message().(type) {
+			case *common.MessageCommandLong:
+				vehicle.WriteMessageTo(fr.Channel, &common.MessageCommandAck{
+					Command:      m.Command,
+					Result:       result,
+					TargetSystem: ackTarget,
+				})
+				if result == common.MAV_RESULT_ACCEPTED && m.Command == common.MAV_CMD_REQUEST_MESSAGE {
+					vehicle.WriteMessageTo(fr.Channel, &common.MessageVfrHud{Heading: 90})
+				}
+
+			case *common.MessageRequestDataStream:
+				streams <- m
+				vehicle.WriteMessageTo(fr.Channel, &common.MessageVfrHud{Heading: 180})
+			}
+		}
+	}()
+
+	// wait until the vehicle has been seen
+	for len(gcs.Components()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Retry:    testPolicy,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	f(s, streams)
+}
+
+func TestRequestMessage(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_PX4, common.MAV_RESULT_ACCEPTED, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				m, err := s.RequestMessage(context.Background(), 74)
+				require.NoError(t, err)
+				require.Equal(t, msg.Message(&common.MessageVfrHud{Heading: 90}), m)
+			})
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_PX4, common.MAV_RESULT_DENIED, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				_, err := s.RequestMessage(context.Background(), 74)
+				require.Equal(t, &ResultError{&common.MessageCommandAck{
+					Command:      common.MAV_CMD_REQUEST_MESSAGE,
+					Result:       common.MAV_RESULT_DENIED,
+					TargetSystem: 255,
+				}}, err)
+			})
+	})
+
+	t.Run("ardupilot fallback", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_RESULT_UNSUPPORTED, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				m, err := s.RequestMessage(context.Background(), 74)
+				require.NoError(t, err)
+				require.Equal(t, msg.Message(&common.MessageVfrHud{Heading: 180}), m)
+				require.Equal(t, &common.MessageRequestDataStream{
+					TargetSystem:    1,
+					TargetComponent: 1,
+					ReqStreamId:     uint8(common.MAV_DATA_STREAM_EXTRA2),
+					ReqMessageRate:  1,
+					StartStop:       1,
+				}, <-streams)
+			})
+	})
+
+	t.Run("other target", func(t *testing.T) {
+		// acknowledgements addressed to another ground station are ignored
+		testMessageRequest(t, common.MAV_AUTOPILOT_PX4, common.MAV_RESULT_DENIED, 254,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				_, err := s.RequestMessage(context.Background(), 74)
+				require.Equal(t, ErrTimeout, err)
+			})
+	})
+
+	t.Run("context", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_PX4, common.MAV_RESULT_IN_PROGRESS, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				_, err := s.RequestMessage(ctx, 74)
+				require.Equal(t, context.DeadlineExceeded, err)
+			})
+	})
+}
+
+func TestSetMessageInterval(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_PX4, common.MAV_RESULT_ACCEPTED, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				err := s.SetMessageInterval(context.Background(), 74, 100*time.Millisecond)
+				require.NoError(t, err)
+			})
+	})
+
+	t.Run("ardupilot fallback", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_RESULT_UNSUPPORTED, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				err := s.SetMessageInterval(context.Background(), 74, 100*time.Millisecond)
+				require.NoError(t, err)
+				require.Equal(t, &common.MessageRequestDataStream{
+					TargetSystem:    1,
+					TargetComponent: 1,
+					ReqStreamId:     uint8(common.MAV_DATA_STREAM_EXTRA2),
+					ReqMessageRate:  10,
+					StartStop:       1,
+				}, <-streams)
+			})
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		testMessageRequest(t, common.MAV_AUTOPILOT_PX4, common.MAV_RESULT_UNSUPPORTED, 255,
+			func(s *Sender, streams chan *common.MessageRequestDataStream) {
+				err := s.SetMessageInterval(context.Background(), 74, 100*time.Millisecond)
+				require.Equal(t, &ResultError{&common.MessageCommandAck{
+					Command:      common.MAV_CMD_SET_MESSAGE_INTERVAL,
+					Result:       common.MAV_RESULT_UNSUPPORTED,
+					TargetSystem: 255,
+				}}, err)
+			})
+	})
+}
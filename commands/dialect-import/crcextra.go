@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aler9/gomavlib/x25"
+)
+
+var dialectTypeSizes = map[string]int{
+	"double":   8,
+	"uint64_t": 8,
+	"int64_t":  8,
+	"float":    4,
+	"uint32_t": 4,
+	"int32_t":  4,
+	"uint16_t": 2,
+	"int16_t":  2,
+	"uint8_t":  1,
+	"int8_t":   1,
+	"char":     1,
+}
+
+type outCRCExtra struct {
+	Name     string `json:"name"`
+	Id       int    `json:"id"`
+	CRCExtra byte   `json:"crc_extra"`
+}
+
+// messageCRCExtra computes the CRC extra of a message, as described in
+// https://mavlink.io/en/guide/serialization.html#crc_extra
+func messageCRCExtra(msg *definitionMessage) (byte, error) {
+	type crcField struct {
+		typ      string
+		name     string
+		arrayLen int
+		index    int
+	}
+
+	var fields []*crcField
+	for i, f := range msg.Fields {
+		if f.Extension {
+			continue
+		}
+
+		cf := &crcField{
+			typ:   f.Type,
+			name:  f.Name,
+			index: i,
+		}
+
+		if cf.typ == "uint8_t_mavlink_version" {
+			cf.typ = "uint8_t"
+		}
+
+		if matches := reTypeIsArray.FindStringSubmatch(cf.typ); matches != nil {
+			cf.typ = matches[1]
+			cf.arrayLen, _ = strconv.Atoi(matches[2])
+		}
+
+		if _, ok := dialectTypeSizes[cf.typ]; !ok {
+			return 0, fmt.Errorf("unknown type: %s", cf.typ)
+		}
+
+		fields = append(fields, cf)
+	}
+
+	// reorder fields as described in
+	// https://mavlink.io/en/guide/serialization.html#field_reordering
+	sort.Slice(fields, func(i, j int) bool {
+		if w1, w2 := dialectTypeSizes[fields[i].typ], dialectTypeSizes[fields[j].typ]; w1 != w2 {
+			return w1 > w2
+		}
+		return fields[i].index < fields[j].index
+	})
+
+	h := x25.New()
+	h.Write([]byte(msg.Name + " "))
+
+	for _, f := range fields {
+		h.Write([]byte(f.typ + " "))
+		h.Write([]byte(f.name + " "))
+
+		if f.arrayLen > 0 {
+			h.Write([]byte{byte(f.arrayLen)})
+		}
+	}
+
+	sum := h.Sum16()
+	return byte((sum & 0xFF) ^ (sum >> 8)), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -78,6 +79,8 @@ type outMessage struct {
 	Description string
 	Id          int
 	Fields      []*outField
+	DefName     string
+	CRCExtra    byte
 }
 
 type outDefinition struct {
@@ -297,10 +300,22 @@ func messageProcess(msg *definitionMessage) (*outMessage, error) {
 		return nil, fmt.Errorf("unsupported message name: %s", msg.Name)
 	}
 
+	// ids are encoded with 24 bits inside V2 frames
+	if msg.Id < 0 || msg.Id > 0xFFFFFF {
+		return nil, fmt.Errorf("message %s has an id that does not fit into 24 bits: %d", msg.Name, msg.Id)
+	}
+
+	crcExtra, err := messageCRCExtra(msg)
+	if err != nil {
+		return nil, err
+	}
+
 	outMsg := &outMessage{
 		Name:        dialectMsgDefToGo(msg.Name),
 		Description: filterDesc(msg.Description),
 		Id:          msg.Id,
+		DefName:     msg.Name,
+		CRCExtra:    crcExtra,
 	}
 
 	for _, f := range msg.Fields {
@@ -386,6 +401,7 @@ func run() error {
 
 	argPkgName := kingpin.Flag("package", "Package name").Default("main").String()
 	argComment := kingpin.Flag("comment", "comment to add before the package name").Default("").String()
+	argCRCExtras := kingpin.Flag("crc-extras", "write the CRC extra of every message into this JSON file").Default("").String()
 	argMainDef := kingpin.Arg("xml", "Path or url pointing to a XML Mavlink dialect").Required().String()
 
 	kingpin.Parse()
@@ -397,8 +413,8 @@ func run() error {
 	version := ""
 	defsProcessed := make(map[string]struct{})
 	isRemote := func() bool {
-		_, err := url.ParseRequestURI(mainDef)
-		return err == nil
+		u, err := url.ParseRequestURI(mainDef)
+		return err == nil && u.Scheme != ""
 	}()
 
 	// parse all definitions recursively
@@ -439,6 +455,13 @@ func run() error {
 		}
 	}
 
+	if *argCRCExtras != "" {
+		err := writeCRCExtras(*argCRCExtras, outDefs)
+		if err != nil {
+			return err
+		}
+	}
+
 	// dump
 	return tplDialect.Execute(os.Stdout, map[string]interface{}{
 		"PkgName": pkgName,
@@ -452,6 +475,33 @@ func run() error {
 	})
 }
 
+func writeCRCExtras(fpath string, outDefs []*outDefinition) error {
+	var crcs []*outCRCExtra
+	for _, def := range outDefs {
+		for _, m := range def.Messages {
+			crcs = append(crcs, &outCRCExtra{
+				Name:     m.DefName,
+				Id:       m.Id,
+				CRCExtra: m.CRCExtra,
+			})
+		}
+	}
+
+	sort.Slice(crcs, func(i, j int) bool {
+		return crcs[i].Id < crcs[j].Id
+	})
+
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(crcs)
+}
+
 func main() {
 	err := run()
 	if err != nil {
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"go/format"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	// file, inside the output directory, that contains the pinned upstream commit
+	pinFile = "mavlink-commit.txt"
+
+	dialectImport = "github.com/aler9/gomavlib/commands/dialect-import"
 )
 
 var tplTest = template.Must(template.New("").Parse(
@@ -23,17 +36,18 @@ import (
 )
 
 func TestDialect(t *testing.T) {
-    _, err := dialect.NewDecEncoder(Dialect)
+    dde, err := dialect.NewDecEncoder(Dialect)
+    require.NoError(t, err)
+
+    err = dde.Validate()
     require.NoError(t, err)
 }
 `))
 
-func shellCommand(cmdstr string) error {
-	fmt.Fprintf(os.Stderr, "%s\n", cmdstr)
-	cmd := exec.Command("sh", "-c", cmdstr)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	return cmd.Run()
+type crcExtra struct {
+	Name     string `json:"name"`
+	Id       int    `json:"id"`
+	CRCExtra byte   `json:"crc_extra"`
 }
 
 func downloadJson(addr string, data interface{}) error {
@@ -48,76 +62,405 @@ func downloadJson(addr string, data interface{}) error {
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad return code: %v", res.StatusCode)
+	}
+
 	return json.NewDecoder(res.Body).Decode(data)
 }
 
-func processDialect(commit string, name string) error {
-	fmt.Fprintf(os.Stderr, "[%s]\n", name)
+func definitionURL(repo string, commit string, name string) string {
+	return "https://raw.githubusercontent.com/" + repo + "/" + commit + "/message_definitions/v1.0/" + name + ".xml"
+}
 
-	pkgName := strings.ReplaceAll(strings.ToLower(name), "_", "")
+// dialectImportRun runs dialect-import and returns the generated code and the CRC extras.
+func dialectImportRun(args ...string) ([]byte, []*crcExtra, error) {
+	tmpf, err := ioutil.TempFile("", "crcextras")
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpf.Close()
+	defer os.Remove(tmpf.Name())
 
-	os.Mkdir(filepath.Join("dialects", pkgName), 0755)
+	args = append([]string{"run", dialectImport, "--crc-extras=" + tmpf.Name()}, args...)
 
-	err := func() error {
-		f, err := os.Create(filepath.Join("dialects", pkgName, "dialect_test.go"))
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+	var out bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, nil, err
+	}
 
-		return tplTest.Execute(f, map[string]interface{}{
-			"PkgName": pkgName,
-		})
-	}()
+	byts, err := ioutil.ReadFile(tmpf.Name())
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	err = shellCommand(fmt.Sprintf("go run ./commands/dialect-import --package=%s --comment=\"%s\" %s > %s",
-		pkgName,
-		"Package "+pkgName+" contains the "+name+" dialect (autogenerated).",
-		"https://raw.githubusercontent.com/mavlink/mavlink/"+commit+"/message_definitions/v1.0/"+name+".xml",
-		filepath.Join("dialects", pkgName, "dialect.go")))
+	var crcs []*crcExtra
+	err = json.Unmarshal(byts, &crcs)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	fmt.Fprintf(os.Stderr, "\n")
-	return nil
+	return out.Bytes(), crcs, nil
 }
 
-func run() error {
-	err := shellCommand("rm -rf dialects/*/")
+// generateDialect generates the code of a dialect and returns its CRC extras.
+func generateDialect(repo string, commit string, name string) ([]byte, []*crcExtra, error) {
+	pkgName := dialectPkgName(name)
+
+	code, crcs, err := dialectImportRun(
+		"--package="+pkgName,
+		"--comment=Package "+pkgName+" contains the "+name+" dialect (autogenerated).",
+		definitionURL(repo, commit, name))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	var res struct {
-		Sha string `json:"sha"`
+	code, err = format.Source(code)
+	if err != nil {
+		return nil, nil, err
 	}
-	err = downloadJson("https://api.github.com/repos/mavlink/mavlink/commits/master", &res)
+
+	return code, crcs, nil
+}
+
+func dialectPkgName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "")
+}
+
+func processDialect(repo string, commit string, prevCommit string, outDir string, name string) ([]string, error) {
+	fmt.Fprintf(os.Stderr, "[%s]\n", name)
+
+	pkgDir := filepath.Join(outDir, dialectPkgName(name))
+
+	code, crcs, err := generateDialect(repo, commit, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.RemoveAll(pkgDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Mkdir(pkgDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(pkgDir, "dialect.go"), code, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = tplTest.Execute(&buf, map[string]interface{}{
+		"PkgName": dialectPkgName(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	test, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(pkgDir, "dialect_test.go"), test, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "\n")
+
+	if prevCommit == "" || prevCommit == commit {
+		return nil, nil
+	}
+
+	// compare CRC extras with the ones of the previous commit.
+	// the dialect may not exist in the previous commit.
+	_, prevCrcs, err := dialectImportRun(definitionURL(repo, prevCommit, name))
+	if err != nil {
+		return []string{fmt.Sprintf("%s: dialect added", name)}, nil
+	}
+
+	return crcExtrasCompare(name, prevCrcs, crcs), nil
+}
+
+// removePackages removes all dialect packages of the output directory, in
+// order to remove dialects that do not exist anymore upstream. Only
+// directories that contain a generated dialect are removed.
+func removePackages(outDir string) error {
+	entries, err := ioutil.ReadDir(outDir)
 	if err != nil {
 		return err
 	}
 
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		_, err := os.Stat(filepath.Join(outDir, e.Name(), "dialect.go"))
+		if err != nil {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(outDir, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listDialects returns the names of the dialects available at a commit.
+func listDialects(repo string, commit string) ([]string, error) {
 	var files []struct {
 		Name string `json:"name"`
 	}
-	err = downloadJson("https://api.github.com/repos/mavlink/mavlink/contents/message_definitions/v1.0?ref="+res.Sha, &files)
+	err := downloadJson("https://api.github.com/repos/"+repo+"/contents/message_definitions/v1.0?ref="+commit, &files)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var names []string
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name, ".xml") {
 			continue
 		}
-		name := f.Name[:len(f.Name)-len(".xml")]
+		names = append(names, f.Name[:len(f.Name)-len(".xml")])
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// dialectMatches checks whether the code generated from a commit is equal
+// to the one of the output directory.
+func dialectMatches(repo string, commit string, outDir string, name string) (bool, error) {
+	cur, err := ioutil.ReadFile(filepath.Join(outDir, dialectPkgName(name), "dialect.go"))
+	if err != nil {
+		// the dialect is not in the output directory
+		return false, nil
+	}
+
+	code, _, err := generateDialect(repo, commit, name)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(code, cur), nil
+}
+
+// identifyCommit returns the most recent upstream commit whose definitions
+// produce the dialects of the output directory.
+func identifyCommit(repo string, outDir string, maxCommits int) (string, error) {
+	checked := 0
+
+	for page := 1; checked < maxCommits; page++ {
+		var commits []struct {
+			Sha string `json:"sha"`
+		}
+		err := downloadJson(fmt.Sprintf("https://api.github.com/repos/%s/commits?path=message_definitions/v1.0&per_page=100&page=%d",
+			repo, page), &commits)
+		if err != nil {
+			return "", err
+		}
+
+		if len(commits) == 0 {
+			break
+		}
+
+		for _, c := range commits {
+			if checked >= maxCommits {
+				break
+			}
+			checked++
+
+			fmt.Fprintf(os.Stderr, "checking %s\n", c.Sha)
+
+			// the common dialect is checked first, since it changes with
+			// most commits
+			ok, err := dialectMatches(repo, c.Sha, outDir, "common")
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				continue
+			}
+
+			names, err := listDialects(repo, c.Sha)
+			if err != nil {
+				return "", err
+			}
+
+			all := true
+			for _, name := range names {
+				if name == "common" {
+					continue
+				}
+
+				if _, err := os.Stat(filepath.Join(outDir, dialectPkgName(name))); err != nil {
+					all = false
+					break
+				}
+
+				ok, err := dialectMatches(repo, c.Sha, outDir, name)
+				if err != nil {
+					return "", err
+				}
+				if !ok {
+					all = false
+					break
+				}
+			}
+
+			if all {
+				return c.Sha, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("none of the last %d commits produces the dialects in %s", checked, outDir)
+}
+
+// crcExtrasCompare returns the messages whose CRC extra has changed, and the
+// ones that have been added or removed.
+func crcExtrasCompare(dialect string, prev []*crcExtra, cur []*crcExtra) []string {
+	prevMap := make(map[int]*crcExtra)
+	for _, c := range prev {
+		prevMap[c.Id] = c
+	}
+	curMap := make(map[int]*crcExtra)
+	for _, c := range cur {
+		curMap[c.Id] = c
+	}
+
+	var ret []string
+
+	for _, c := range cur {
+		p, ok := prevMap[c.Id]
+		switch {
+		case !ok:
+			ret = append(ret, fmt.Sprintf("%s: %s (%d): added", dialect, c.Name, c.Id))
+
+		case p.CRCExtra != c.CRCExtra:
+			ret = append(ret, fmt.Sprintf("%s: %s (%d): CRC extra changed from %d to %d",
+				dialect, c.Name, c.Id, p.CRCExtra, c.CRCExtra))
+		}
+	}
+
+	for _, p := range prev {
+		if _, ok := curMap[p.Id]; !ok {
+			ret = append(ret, fmt.Sprintf("%s: %s (%d): removed", dialect, p.Name, p.Id))
+		}
+	}
+
+	return ret
+}
 
-		err = processDialect(res.Sha, name)
+func run() error {
+	kingpin.CommandLine.Help = "Generate the dialect packages from the upstream definitions."
+
+	argRepo := kingpin.Flag("repo", "GitHub repository that contains the definitions").Default("mavlink/mavlink").String()
+	argCommit := kingpin.Flag("commit", "commit of the definitions (defaults to the pinned commit)").Default("").String()
+	argUpdate := kingpin.Flag("update", "use the latest commit of the master branch and pin it").Bool()
+	argIdentify := kingpin.Flag("identify", "find the commit the existing dialects were generated from and pin it, without generating anything").Bool()
+	argIdentifyMax := kingpin.Flag("identify-max", "maximum number of commits checked by --identify").Default("500").Int()
+	argOut := kingpin.Flag("out", "output directory").Default("dialects").String()
+	argDialects := kingpin.Flag("dialect", "generate only this dialect (can be repeated)").Strings()
+
+	kingpin.Parse()
+
+	prevCommit := func() string {
+		byts, err := ioutil.ReadFile(filepath.Join(*argOut, pinFile))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(byts))
+	}()
+
+	if *argIdentify {
+		commit, err := identifyCommit(*argRepo, *argOut, *argIdentifyMax)
 		if err != nil {
 			return err
 		}
+
+		fmt.Fprintf(os.Stderr, "the dialects were generated from %s at commit %s\n", *argRepo, commit)
+		return ioutil.WriteFile(filepath.Join(*argOut, pinFile), []byte(commit+"\n"), 0644)
+	}
+
+	commit := *argCommit
+	switch {
+	case *argUpdate:
+		var res struct {
+			Sha string `json:"sha"`
+		}
+		err := downloadJson("https://api.github.com/repos/"+*argRepo+"/commits/master", &res)
+		if err != nil {
+			return err
+		}
+		commit = res.Sha
+
+	case commit == "":
+		if prevCommit == "" {
+			return fmt.Errorf("no pinned commit found in %s; use --commit, --update or --identify",
+				filepath.Join(*argOut, pinFile))
+		}
+		commit = prevCommit
+	}
+
+	fmt.Fprintf(os.Stderr, "using %s at commit %s\n\n", *argRepo, commit)
+
+	names := *argDialects
+	if len(names) == 0 {
+		var err error
+		names, err = listDialects(*argRepo, commit)
+		if err != nil {
+			return err
+		}
+
+		// all dialects are generated again; remove the existing ones, in
+		// order to remove dialects that do not exist anymore upstream.
+		err = removePackages(*argOut)
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(names)
+
+	var changes []string
+	for _, name := range names {
+		ch, err := processDialect(*argRepo, commit, prevCommit, *argOut, name)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, ch...)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(*argOut, pinFile), []byte(commit+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	// report changes, since messages whose CRC extra has changed are not
+	// compatible with the ones of the previous version
+	switch {
+	case prevCommit == "" || prevCommit == commit:
+
+	case len(changes) == 0:
+		fmt.Printf("no message changes between %s and %s\n", prevCommit, commit)
+
+	default:
+		fmt.Printf("message changes between %s and %s:\n", prevCommit, commit)
+		for _, c := range changes {
+			fmt.Printf("  %s\n", c)
+		}
 	}
 
 	return nil
@@ -0,0 +1,111 @@
+#!/usr/bin/env python3
+"""
+Generate conformance test vectors with pymavlink, the reference implementation.
+
+usage: main.py [--dialect=common] [--count=3] [--seed=0] > vectors.json
+
+The output can be loaded with conformance.Load() and verified with
+conformance.Verifier.Verify().
+"""
+
+import argparse
+import importlib
+import json
+import random
+import struct
+import sys
+
+INT_RANGES = {
+    'uint8_t': (0, 0xFF),
+    'int8_t': (-0x80, 0x7F),
+    'uint16_t': (0, 0xFFFF),
+    'int16_t': (-0x8000, 0x7FFF),
+    'uint32_t': (0, 0xFFFFFFFF),
+    'int32_t': (-0x80000000, 0x7FFFFFFF),
+    'uint64_t': (0, 0xFFFFFFFFFFFFFFFF),
+    'int64_t': (-0x8000000000000000, 0x7FFFFFFFFFFFFFFF),
+    'uint8_t_mavlink_version': (0, 0xFF),
+}
+
+
+def gen_scalar(rnd, typ):
+    if typ in INT_RANGES:
+        lo, hi = INT_RANGES[typ]
+        return rnd.randint(lo, hi)
+    if typ == 'float':
+        # round to float32, in order to obtain values that are represented
+        # in the same way by every implementation
+        return struct.unpack('<f', struct.pack('<f', rnd.uniform(-1e6, 1e6)))[0]
+    if typ == 'double':
+        return rnd.uniform(-1e12, 1e12)
+    raise Exception('unsupported type: %s' % typ)
+
+
+def gen_value(rnd, typ, length):
+    if typ == 'char':
+        if length == 0:
+            return chr(rnd.randint(0x21, 0x7E))
+        return ''.join(chr(rnd.randint(0x21, 0x7E)) for _ in range(rnd.randint(0, length)))
+    if length == 0:
+        return gen_scalar(rnd, typ)
+    return [gen_scalar(rnd, typ) for _ in range(length)]
+
+
+def pack(module, cls, values, sysid, compid, seq):
+    mav = module.MAVLink(None, srcSystem=sysid, srcComponent=compid)
+    mav.seq = seq
+    args = [values[f] for f in cls.fieldnames]
+    m = cls(*args)
+    buf = m.pack(mav)
+    return bytes(buf).hex()
+
+
+def main():
+    parser = argparse.ArgumentParser(description='Generate Mavlink test vectors with pymavlink.')
+    parser.add_argument('--dialect', default='common')
+    parser.add_argument('--count', type=int, default=3, help='vectors per message')
+    parser.add_argument('--seed', type=int, default=0)
+    args = parser.parse_args()
+
+    mod1 = importlib.import_module('pymavlink.dialects.v10.' + args.dialect)
+    mod2 = importlib.import_module('pymavlink.dialects.v20.' + args.dialect)
+    rnd = random.Random(args.seed)
+
+    vecs = []
+    for msgid in sorted(mod2.mavlink_map):
+        cls2 = mod2.mavlink_map[msgid]
+        cls1 = mod1.mavlink_map.get(msgid) if msgid <= 0xFF else None
+
+        for _ in range(args.count):
+            values = {}
+            for name, typ, length in zip(cls2.fieldnames, cls2.fieldtypes, cls2.array_lengths):
+                values[name] = gen_value(rnd, typ, length)
+
+            sysid = rnd.randint(1, 255)
+            compid = rnd.randint(0, 255)
+            seq = rnd.randint(0, 255)
+
+            vec = {
+                'name': cls2.msgname,
+                'id': msgid,
+                'crc_extra': cls2.crc_extra,
+                'system_id': sysid,
+                'component_id': compid,
+                'sequence': seq,
+                'fields': values,
+                'v2': pack(mod2, cls2, values, sysid, compid, seq),
+            }
+
+            # V1 frames contain only non-extension fields, that are
+            # picked by pack() from the same values
+            if cls1 is not None:
+                vec['v1'] = pack(mod1, cls1, values, sysid, compid, seq)
+
+            vecs.append(vec)
+
+    json.dump(vecs, sys.stdout, indent=2)
+    sys.stdout.write('\n')
+
+
+if __name__ == '__main__':
+    main()
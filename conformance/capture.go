@@ -0,0 +1,283 @@
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/tlog"
+)
+
+// Capture is a sequence of frames captured from a real link, that allows to
+// check that frames produced by other Mavlink implementations are decoded
+// and encoded again without alterations.
+//
+// A capture file is a JSON object in the following format:
+//
+//	{
+//	  "name": "ArduCopter 4.3, V2, signed",
+//	  "signature_key": "4f1e...",
+//	  "frames": ["fd0900000001...", "fd1c00000101..."]
+//	}
+//
+// Where "signature_key" is optional.
+type Capture struct {
+	// a description of the capture (i.e. the autopilot and its version).
+	Name string `json:"name"`
+
+	// (optional) the key of signed frames. If provided, signatures are
+	// verified too.
+	SignatureKey Bytes `json:"signature_key,omitempty"`
+
+	// the frames.
+	Frames []Bytes `json:"frames"`
+}
+
+// LoadCapture reads a capture file.
+func LoadCapture(r io.Reader) (*Capture, error) {
+	var c Capture
+	err := json.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.SignatureKey != nil && len(c.SignatureKey) != len(frame.V2Key{}) {
+		return nil, fmt.Errorf("invalid signature key length: %d", len(c.SignatureKey))
+	}
+
+	return &c, nil
+}
+
+// LoadCaptureTlog reads the frames of a .tlog file, i.e. a file recorded by
+// MAVProxy, QGroundControl or gomavlib.EndpointFileWriter, into a capture.
+func LoadCaptureTlog(name string, r io.Reader) (*Capture, error) {
+	c := &Capture{Name: name}
+	tr := tlog.NewReader(r)
+
+	for {
+		_, fr, err := tr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c.Frames = append(c.Frames, append(Bytes(nil), fr...))
+	}
+
+	return c, nil
+}
+
+// LoadCaptureDir reads all the capture files (.json) and .tlog files of a
+// directory, sorted by file name. Captures read from .tlog files are named
+// after the file.
+func LoadCaptureDir(dir string) ([]*Capture, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var ret []*Capture
+
+	for _, name := range names {
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext != ".json" && ext != ".tlog" {
+			continue
+		}
+
+		c, err := func() (*Capture, error) {
+			f, err := os.Open(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+
+			if ext == ".tlog" {
+				return LoadCaptureTlog(name, f)
+			}
+			return LoadCapture(f)
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		ret = append(ret, c)
+	}
+
+	return ret, nil
+}
+
+// SaveCapture writes a capture file.
+func SaveCapture(w io.Writer, c *Capture) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// CaptureFrameError is returned when a frame of a capture is not decoded
+// and encoded again into the same bytes.
+type CaptureFrameError struct {
+	// the name of the capture.
+	Capture string
+
+	// the position of the frame in the capture.
+	Index int
+
+	// the frame.
+	Frame Bytes
+
+	// the cause.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *CaptureFrameError) Error() string {
+	return fmt.Sprintf("%s: frame %d (%x): %s", e.Capture, e.Index, []byte(e.Frame), e.Err)
+}
+
+// CaptureResult is the result of the verification of a capture.
+type CaptureResult struct {
+	// the number of verified frames.
+	Verified int
+
+	// the number of frames whose message is not in the dialect, that are
+	// not verified.
+	Skipped int
+}
+
+// VerifyCapture decodes each frame of a capture, encodes it again and checks
+// that the result is identical to the original frame. Frames whose message
+// is not in the dialect are skipped. It returns a *CaptureFrameError for
+// the first frame that is altered.
+func (v *Verifier) VerifyCapture(c *Capture) (*CaptureResult, error) {
+	var key *frame.V2Key
+	if c.SignatureKey != nil {
+		key = frame.NewV2Key(c.SignatureKey)
+	}
+
+	res := &CaptureResult{}
+
+	for i, buf := range c.Frames {
+		ok, err := v.verifyFrame(buf, key)
+		if err != nil {
+			return res, &CaptureFrameError{
+				Capture: c.Name,
+				Index:   i,
+				Frame:   buf,
+				Err:     err,
+			}
+		}
+
+		if ok {
+			res.Verified++
+		} else {
+			res.Skipped++
+		}
+	}
+
+	return res, nil
+}
+
+// verifyFrame checks that a frame is decoded and encoded again into the same
+// bytes. It returns false when the message of the frame is not in the
+// dialect.
+func (v *Verifier) verifyFrame(buf []byte, key *frame.V2Key) (bool, error) {
+	if len(buf) == 0 {
+		return false, fmt.Errorf("empty frame")
+	}
+
+	var f frame.Frame
+	switch buf[0] {
+	case frame.V1MagicByte:
+		f = &frame.V1Frame{}
+
+	case frame.V2MagicByte:
+		f = &frame.V2Frame{}
+
+	default:
+		return false, fmt.Errorf("invalid magic byte: %x", buf[0])
+	}
+	isV2 := buf[0] == frame.V2MagicByte
+
+	br := bufio.NewReader(bytes.NewReader(buf[1:]))
+	err := f.Decode(br)
+	if err != nil {
+		return false, err
+	}
+
+	if br.Buffered() > 0 {
+		return false, fmt.Errorf("%d trailing bytes", br.Buffered())
+	}
+
+	raw := f.GetMessage().(*msg.MessageRaw)
+
+	mde, ok, err := v.dialectDE.MessageDE(raw.Id)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+
+	if sum := f.GenChecksum(mde.CRCExtra()); sum != f.GetChecksum() {
+		return false, fmt.Errorf("wrong checksum (expected %.4x, got %.4x)", sum, f.GetChecksum())
+	}
+
+	if ff, ok := f.(*frame.V2Frame); ok && ff.IsSigned() && key != nil {
+		if sig := ff.GenSignature(key); *sig != *ff.Signature {
+			return false, fmt.Errorf("wrong signature (expected %x, got %x)", sig[:], ff.Signature[:])
+		}
+	}
+
+	m, err := mde.Decode(raw.Content, isV2)
+	if err != nil {
+		return false, err
+	}
+
+	content, err := mde.Encode(m, isV2)
+	if err != nil {
+		return false, err
+	}
+
+	// the header, the checksum and the signature are kept, therefore any
+	// difference is caused by the decoding or by the encoding
+	var enc []byte
+	switch ff := f.(type) {
+	case *frame.V1Frame:
+		cf := ff.Clone().(*frame.V1Frame)
+		cf.Message = &msg.MessageRaw{Id: raw.Id, Content: content}
+		enc, err = cf.Encode(make([]byte, 0, 512), content)
+
+	case *frame.V2Frame:
+		cf := ff.Clone().(*frame.V2Frame)
+		cf.Message = &msg.MessageRaw{Id: raw.Id, Content: content}
+		enc, err = cf.Encode(make([]byte, 0, 512), content)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(enc, buf) {
+		return false, fmt.Errorf("%s: wrong re-encoding (got %x, decoded %+v)", versionName(isV2), enc, m)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,175 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/minimal"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func testFrame(t *testing.T, id uint32, crcExtra byte, content []byte, key *frame.V2Key) Bytes {
+	raw := &msg.MessageRaw{Id: id, Content: content}
+	f := &frame.V2Frame{SystemId: 1, ComponentId: 1, Message: raw}
+	if key != nil {
+		f.IncompatibilityFlag = frame.V2FlagSigned
+		f.SignatureLinkId = 2
+		f.SignatureTimestamp = 1600000000
+	}
+	f.Checksum = f.GenChecksum(crcExtra)
+	if key != nil {
+		f.Signature = f.GenSignature(key)
+	}
+
+	buf, err := f.Encode(make([]byte, 0, 512), content)
+	require.NoError(t, err)
+	return buf
+}
+
+func testHeartbeatFrame(t *testing.T, content []byte) Bytes {
+	return testFrame(t, 0, 50, content, nil)
+}
+
+var testCaptureKey = bytes.Repeat([]byte{0x4f}, 32)
+
+// testCapture returns a capture that contains signed heartbeats and a signed
+// ATTITUDE.
+func testCapture(t *testing.T) *Capture {
+	key := frame.NewV2Key(testCaptureKey)
+	heartbeat := []byte{4, 0, 0, 0, 2, 3, 217, 4, 3}
+	attitude := bytes.Repeat([]byte{0x3f}, 28)
+
+	return &Capture{
+		Name:         "test",
+		SignatureKey: testCaptureKey,
+		Frames: []Bytes{
+			testFrame(t, 0, 50, heartbeat, key),
+			testFrame(t, 30, 39, attitude, key),
+			testFrame(t, 0, 50, heartbeat, key),
+		},
+	}
+}
+
+func TestVerifyCapture(t *testing.T) {
+	v, err := NewVerifier(ardupilotmega.Dialect)
+	require.NoError(t, err)
+
+	res, err := v.VerifyCapture(testCapture(t))
+	require.NoError(t, err)
+	require.Equal(t, &CaptureResult{Verified: 3}, res)
+}
+
+func TestVerifyCaptureSkipped(t *testing.T) {
+	v, err := NewVerifier(minimal.Dialect)
+	require.NoError(t, err)
+
+	// only heartbeats are in the minimal dialect
+	res, err := v.VerifyCapture(testCapture(t))
+	require.NoError(t, err)
+	require.Equal(t, &CaptureResult{Verified: 2, Skipped: 1}, res)
+}
+
+func TestVerifyCaptureErrors(t *testing.T) {
+	v, err := NewVerifier(ardupilotmega.Dialect)
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name  string
+		frame func(c *Capture)
+		err   string
+	}{
+		{
+			"wrong checksum",
+			func(c *Capture) {
+				c.Frames[2][12]++
+			},
+			"wrong checksum",
+		},
+		{
+			"wrong signature",
+			func(c *Capture) {
+				c.SignatureKey[0]++
+			},
+			"wrong signature",
+		},
+		{
+			"untruncated",
+			func(c *Capture) {
+				// the last byte of the heartbeat is zero, and is removed by
+				// empty-byte truncation
+				c.Frames[2] = testHeartbeatFrame(t, []byte{4, 0, 0, 0, 2, 3, 217, 4, 0})
+			},
+			"V2: wrong re-encoding",
+		},
+		{
+			"trailing bytes",
+			func(c *Capture) {
+				c.Frames[2] = append(c.Frames[2], 0)
+			},
+			"1 trailing bytes",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			c := testCapture(t)
+			ca.frame(c)
+
+			_, err = v.VerifyCapture(c)
+			require.Error(t, err)
+			ferr, ok := err.(*CaptureFrameError)
+			require.True(t, ok)
+			require.Equal(t, "test", ferr.Capture)
+			require.Contains(t, ferr.Err.Error(), ca.err)
+		})
+	}
+}
+
+func TestLoadCaptureTlog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib-capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	frames := []Bytes{
+		testHeartbeatFrame(t, []byte{4, 0, 0, 0, 2, 3, 217, 4, 3}),
+		testHeartbeatFrame(t, []byte{4, 0, 0, 0, 2, 3, 217, 4, 3}),
+	}
+
+	var buf bytes.Buffer
+	for i, fr := range frames {
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], 1600000000000000+uint64(i))
+		buf.Write(ts[:])
+		buf.Write(fr)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "flight.tlog"), buf.Bytes(), 0644)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("test"), 0644)
+	require.NoError(t, err)
+
+	captures, err := LoadCaptureDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, []*Capture{{Name: "flight.tlog", Frames: frames}}, captures)
+
+	v, err := NewVerifier(ardupilotmega.Dialect)
+	require.NoError(t, err)
+
+	res, err := v.VerifyCapture(captures[0])
+	require.NoError(t, err)
+	require.Equal(t, &CaptureResult{Verified: 2}, res)
+
+	var out bytes.Buffer
+	err = SaveCapture(&out, captures[0])
+	require.NoError(t, err)
+
+	c, err := LoadCapture(&out)
+	require.NoError(t, err)
+	require.Equal(t, captures[0], c)
+}
@@ -0,0 +1,462 @@
+// Package conformance implements import and export of test vectors, that allow
+// to check that messages are encoded in the same way by gomavlib and by other
+// Mavlink implementations (i.e. pymavlink, the reference implementation).
+//
+// A test vector file is a JSON array of objects in the following format:
+//
+//	{
+//	  "name": "HEARTBEAT",
+//	  "id": 0,
+//	  "crc_extra": 50,
+//	  "system_id": 1,
+//	  "component_id": 1,
+//	  "sequence": 0,
+//	  "fields": {"type": 1, "autopilot": 3, ...},
+//	  "v1": "fe0900010100...",
+//	  "v2": "fd0900000001..."
+//	}
+//
+// Where "v1" and "v2" are the expected frames, hex-encoded, and are both optional.
+//
+// The package can also replay captures of real links, in order to check that
+// frames produced by autopilots are decoded and encoded again into the same
+// bytes. Captures can be loaded from capture files or .tlog files; the package
+// doesn't ship captures, that must be recorded from the autopilots to check.
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// Bytes is a byte slice that is encoded in JSON as an hex string.
+type Bytes []byte
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (b *Bytes) UnmarshalJSON(in []byte) error {
+	var s string
+	err := json.Unmarshal(in, &s)
+	if err != nil {
+		return err
+	}
+
+	byts, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	*b = byts
+	return nil
+}
+
+// Vector is a test vector.
+type Vector struct {
+	// the message name, as it appears in the definition file.
+	Name string `json:"name"`
+	// the message id.
+	Id uint32 `json:"id"`
+	// the message CRC extra.
+	CRCExtra byte `json:"crc_extra"`
+
+	// the system id of the frame.
+	SystemId byte `json:"system_id"`
+	// the component id of the frame.
+	ComponentId byte `json:"component_id"`
+	// the sequence id of the frame.
+	SequenceId byte `json:"sequence"`
+
+	// the message fields, indexed by their name in the definition file.
+	// Numbers are json.Number or float64, strings are string, arrays are []interface{}.
+	Fields map[string]interface{} `json:"fields"`
+
+	// (optional) the expected V1 frame.
+	V1 Bytes `json:"v1,omitempty"`
+	// (optional) the expected V2 frame.
+	V2 Bytes `json:"v2,omitempty"`
+}
+
+// Load reads a test vector file.
+func Load(r io.Reader) ([]*Vector, error) {
+	var vecs []*Vector
+	dec := json.NewDecoder(r)
+	// keep numbers as strings in order to preserve 64-bit integers
+	dec.UseNumber()
+	err := dec.Decode(&vecs)
+	if err != nil {
+		return nil, err
+	}
+	return vecs, nil
+}
+
+// Save writes a test vector file.
+func Save(w io.Writer, vecs []*Vector) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(vecs)
+}
+
+// Verifier is an object that allows to export and verify test vectors
+// against a Dialect.
+type Verifier struct {
+	dialectDE *dialect.DecEncoder
+	messages  map[uint32]msg.Message
+}
+
+// NewVerifier allocates a Verifier.
+func NewVerifier(d *dialect.Dialect) (*Verifier, error) {
+	dialectDE, err := dialect.NewDecEncoder(d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dialectDE.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verifier{
+		dialectDE: dialectDE,
+		messages:  make(map[uint32]msg.Message),
+	}
+
+	for _, m := range d.MessagesSnapshot() {
+		v.messages[m.GetId()] = m
+	}
+
+	return v, nil
+}
+
+// Export generates a test vector from a message. Frames are encoded with the given
+// system id, component id and sequence id.
+func (v *Verifier) Export(m msg.Message, systemId byte, componentId byte, sequenceId byte) (*Vector, error) {
+	mde, ok, err := v.dialectDE.MessageDE(m.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("message %d is not in the dialect", m.GetId())
+	}
+
+	vec := &Vector{
+		Name:        mde.Name(),
+		Id:          m.GetId(),
+		CRCExtra:    mde.CRCExtra(),
+		SystemId:    systemId,
+		ComponentId: componentId,
+		SequenceId:  sequenceId,
+		Fields:      fieldsFromMessage(m),
+	}
+
+	// V1 frames can't contain messages with id > 255
+	if !msg.IsV2Only(m) {
+		vec.V1, err = v.encode(vec, m, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vec.V2, err = v.encode(vec, m, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return vec, nil
+}
+
+// Verify checks that the message described by the test vector is encoded into
+// the expected frames, and that the expected frames are decoded into the message.
+func (v *Verifier) Verify(vec *Vector) error {
+	mde, ok, err := v.dialectDE.MessageDE(vec.Id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("message %d is not in the dialect", vec.Id)
+	}
+
+	if mde.CRCExtra() != vec.CRCExtra {
+		return fmt.Errorf("wrong CRC extra (expected %d, got %d)", vec.CRCExtra, mde.CRCExtra())
+	}
+
+	m, err := v.messageFromFields(vec)
+	if err != nil {
+		return err
+	}
+
+	for _, isV2 := range []bool{false, true} {
+		expected := vec.V1
+		if isV2 {
+			expected = vec.V2
+		}
+		if expected == nil {
+			continue
+		}
+
+		enc, err := v.encode(vec, m, isV2)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(enc, expected) {
+			return fmt.Errorf("%s: wrong encoding (expected %x, got %x)", versionName(isV2), []byte(expected), enc)
+		}
+
+		// decoding is checked by encoding again the decoded message, since
+		// V1 frames do not contain extensions
+		dec, err := v.decode(expected, isV2)
+		if err != nil {
+			return fmt.Errorf("%s: %s", versionName(isV2), err)
+		}
+
+		reenc, err := v.encode(vec, dec, isV2)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(reenc, expected) {
+			return fmt.Errorf("%s: wrong decoding (got %+v)", versionName(isV2), dec)
+		}
+	}
+
+	return nil
+}
+
+func versionName(isV2 bool) string {
+	if isV2 {
+		return "V2"
+	}
+	return "V1"
+}
+
+func (v *Verifier) encode(vec *Vector, m msg.Message, isV2 bool) ([]byte, error) {
+	mde, _, err := v.dialectDE.MessageDE(vec.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := mde.Encode(m, isV2)
+	if err != nil {
+		return nil, err
+	}
+	raw := &msg.MessageRaw{Id: vec.Id, Content: content}
+
+	var f frame.Frame
+	if isV2 {
+		ff := &frame.V2Frame{
+			SequenceId:  vec.SequenceId,
+			SystemId:    vec.SystemId,
+			ComponentId: vec.ComponentId,
+			Message:     raw,
+		}
+		ff.Checksum = ff.GenChecksum(mde.CRCExtra())
+		f = ff
+	} else {
+		ff := &frame.V1Frame{
+			SequenceId:  vec.SequenceId,
+			SystemId:    vec.SystemId,
+			ComponentId: vec.ComponentId,
+			Message:     raw,
+		}
+		ff.Checksum = ff.GenChecksum(mde.CRCExtra())
+		f = ff
+	}
+
+	return f.Encode(make([]byte, 0, 512), content)
+}
+
+func (v *Verifier) decode(buf []byte, isV2 bool) (msg.Message, error) {
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+
+	var f frame.Frame
+	if isV2 {
+		if buf[0] != frame.V2MagicByte {
+			return nil, fmt.Errorf("invalid magic byte: %x", buf[0])
+		}
+		f = &frame.V2Frame{}
+	} else {
+		if buf[0] != frame.V1MagicByte {
+			return nil, fmt.Errorf("invalid magic byte: %x", buf[0])
+		}
+		f = &frame.V1Frame{}
+	}
+
+	err := f.Decode(bufio.NewReader(bytes.NewReader(buf[1:])))
+	if err != nil {
+		return nil, err
+	}
+
+	mde, ok, err := v.dialectDE.MessageDE(f.GetMessage().GetId())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("message %d is not in the dialect", f.GetMessage().GetId())
+	}
+
+	if sum := f.GenChecksum(mde.CRCExtra()); sum != f.GetChecksum() {
+		return nil, fmt.Errorf("wrong checksum (expected %.4x, got %.4x)", sum, f.GetChecksum())
+	}
+
+	return mde.Decode(f.GetMessage().(*msg.MessageRaw).Content, isV2)
+}
+
+func (v *Verifier) messageFromFields(vec *Vector) (msg.Message, error) {
+	tpl, ok := v.messages[vec.Id]
+	if !ok {
+		return nil, fmt.Errorf("message %d is not in the dialect", vec.Id)
+	}
+
+	rv := reflect.New(reflect.TypeOf(tpl).Elem())
+	rt := rv.Elem().Type()
+
+	fieldsByName := make(map[string]int)
+	for i := 0; i < rt.NumField(); i++ {
+		fieldsByName[msg.FieldName(rt.Field(i))] = i
+	}
+
+	for name, val := range vec.Fields {
+		i, ok := fieldsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("message %s does not contain field %s", vec.Name, name)
+		}
+
+		err := valueSet(rv.Elem().Field(i), val)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", name, err)
+		}
+	}
+
+	return rv.Interface().(msg.Message), nil
+}
+
+func valueSet(target reflect.Value, val interface{}) error {
+	switch target.Kind() {
+	case reflect.Array:
+		vals, ok := val.([]interface{})
+		if !ok {
+			// pymavlink exports uint8 arrays as strings in some cases
+			if s, ok := val.(string); ok {
+				for i := 0; i < len(s) && i < target.Len(); i++ {
+					target.Index(i).SetUint(uint64(s[i]))
+				}
+				return nil
+			}
+			return fmt.Errorf("expected array, got %T", val)
+		}
+		if len(vals) > target.Len() {
+			return fmt.Errorf("array too long (%d vs %d)", len(vals), target.Len())
+		}
+		for i, v := range vals {
+			err := valueSet(target.Index(i), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %T", val)
+		}
+		target.SetString(s)
+		return nil
+	}
+
+	var num string
+	switch tval := val.(type) {
+	case json.Number:
+		num = string(tval)
+	case float64:
+		num = strconv.FormatFloat(tval, 'g', -1, 64)
+	default:
+		return fmt.Errorf("expected number, got %T", val)
+	}
+
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return err
+		}
+		target.SetInt(i)
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(num, 10, 64)
+		if err != nil {
+			return err
+		}
+		target.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+
+	default:
+		return fmt.Errorf("unsupported kind: %v", target.Kind())
+	}
+
+	return nil
+}
+
+func fieldsFromMessage(m msg.Message) map[string]interface{} {
+	rv := reflect.ValueOf(m).Elem()
+	rt := rv.Type()
+
+	ret := make(map[string]interface{})
+	for i := 0; i < rt.NumField(); i++ {
+		ret[msg.FieldName(rt.Field(i))] = valueGet(rv.Field(i))
+	}
+	return ret
+}
+
+func valueGet(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Array:
+		ret := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret[i] = valueGet(v.Index(i))
+		}
+		return ret
+
+	case reflect.String:
+		return v.String()
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return json.Number(strconv.FormatInt(v.Int(), 10))
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return json.Number(strconv.FormatUint(v.Uint(), 10))
+
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		// NaN and Inf can't be encoded into JSON
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return float64(0)
+		}
+		return f
+	}
+
+	return nil
+}
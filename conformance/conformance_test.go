@@ -0,0 +1,180 @@
+package conformance
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/dialects/minimal"
+	"github.com/aler9/gomavlib/dialects/standard"
+	"github.com/aler9/gomavlib/msg"
+)
+
+type MAV_TYPE int
+type MAV_AUTOPILOT int
+type MAV_MODE_FLAG int
+type MAV_STATE int
+
+type MessageHeartbeat struct {
+	Type           MAV_TYPE      `mavenum:"uint8"`
+	Autopilot      MAV_AUTOPILOT `mavenum:"uint8"`
+	BaseMode       MAV_MODE_FLAG `mavenum:"uint8"`
+	CustomMode     uint32
+	SystemStatus   MAV_STATE `mavenum:"uint8"`
+	MavlinkVersion uint8
+}
+
+func (*MessageHeartbeat) GetId() uint32 {
+	return 0
+}
+
+type MessageTestExt struct {
+	TimeUsec uint64
+	Name     string `mavlen:"8"`
+	Values   [3]int16
+	Extra    float32 `mavext:"true"`
+}
+
+func (*MessageTestExt) GetId() uint32 {
+	return 300
+}
+
+var testDialect = &dialect.Dialect{
+	Version: 3,
+	Messages: []msg.Message{
+		&MessageHeartbeat{},
+		&MessageTestExt{},
+	},
+}
+
+func TestVerify(t *testing.T) {
+	v, err := NewVerifier(testDialect)
+	require.NoError(t, err)
+
+	vecs, err := Load(bytes.NewReader([]byte(`[
+		{
+			"name": "HEARTBEAT",
+			"id": 0,
+			"crc_extra": 50,
+			"system_id": 1,
+			"component_id": 2,
+			"sequence": 3,
+			"fields": {
+				"type": 1,
+				"autopilot": 2,
+				"base_mode": 3,
+				"custom_mode": 4,
+				"system_status": 5,
+				"mavlink_version": 3
+			},
+			"v1": "fe0903010200040000000102030503b4d8",
+			"v2": "fd090000030102000000040000000102030503e106"
+		}
+	]`)))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(vecs))
+
+	err = v.Verify(vecs[0])
+	require.NoError(t, err)
+
+	vecs[0].V2[12]++
+	err = v.Verify(vecs[0])
+	require.Error(t, err)
+
+	vecs[0].CRCExtra++
+	err = v.Verify(vecs[0])
+	require.EqualError(t, err, "wrong CRC extra (expected 51, got 50)")
+}
+
+func TestExportLoad(t *testing.T) {
+	v, err := NewVerifier(testDialect)
+	require.NoError(t, err)
+
+	vec, err := v.Export(&MessageTestExt{
+		TimeUsec: 0xFFFFFFFFFFFFFFFF,
+		Name:     "test",
+		Values:   [3]int16{-1, 2, -3},
+		Extra:    1.5,
+	}, 1, 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, "TEST_EXT", vec.Name)
+	require.Nil(t, vec.V1)
+	require.NotNil(t, vec.V2)
+
+	var buf bytes.Buffer
+	err = Save(&buf, []*Vector{vec})
+	require.NoError(t, err)
+
+	vecs, err := Load(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(vecs))
+	require.Equal(t, vec.V2, vecs[0].V2)
+
+	err = v.Verify(vecs[0])
+	require.NoError(t, err)
+}
+
+func TestVerifyUnknownField(t *testing.T) {
+	v, err := NewVerifier(testDialect)
+	require.NoError(t, err)
+
+	err = v.Verify(&Vector{
+		Name:     "HEARTBEAT",
+		Id:       0,
+		CRCExtra: 50,
+		Fields: map[string]interface{}{
+			"unknown": float64(1),
+		},
+	})
+	require.EqualError(t, err, "message HEARTBEAT does not contain field unknown")
+}
+
+// vectors generated by pymavlink with "make testvectors", whose dialect is
+// taken from the file name.
+var referenceDialects = map[string]*dialect.Dialect{
+	"ardupilotmega": ardupilotmega.Dialect,
+	"common":        common.Dialect,
+	"minimal":       minimal.Dialect,
+	"standard":      standard.Dialect,
+}
+
+func TestVerifyReferenceVectors(t *testing.T) {
+	// vectors of the common dialect are required, in order to prevent the
+	// conformance check from passing silently
+	_, err := os.Stat(filepath.Join("testdata", "testvectors-common.json"))
+	require.NoError(t, err, "reference vectors are missing; generate them with make testvectors D=common")
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "testvectors-*.json"))
+	require.NoError(t, err)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "testvectors-"), ".json")
+
+		t.Run(name, func(t *testing.T) {
+			d, ok := referenceDialects[name]
+			require.True(t, ok, "unknown dialect %s", name)
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			defer f.Close()
+
+			vecs, err := Load(f)
+			require.NoError(t, err)
+			require.NotEqual(t, 0, len(vecs))
+
+			v, err := NewVerifier(d)
+			require.NoError(t, err)
+
+			for _, vec := range vecs {
+				require.NoError(t, v.Verify(vec), "message %s", vec.Name)
+			}
+		})
+	}
+}
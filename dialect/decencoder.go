@@ -2,33 +2,243 @@ package dialect
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/aler9/gomavlib/msg"
 )
 
 // DecEncoder is an object that allows to decode and encode a Dialect.
+// The DecEncoders of messages are built on first use, in order to speed up
+// the initialization of big dialects, and are returned by MessageDE().
+//
+// This replaces the MessageDEs field, that contained the DecEncoders of all
+// messages and has been removed, since it can't be filled on first use.
+// Code that used MessageDEs[id] must call MessageDE(id) instead, and call
+// Validate() if it relied on NewDecEncoder() to check all messages; see the
+// Upgrading section of the README.
 type DecEncoder struct {
-	MessageDEs map[uint32]*msg.DecEncoder
+	d          *Dialect
+	policy     CollisionPolicy
+	mutex      sync.RWMutex
+	generation uint64
+	messages   map[uint32]msg.Message
+	des        map[uint32]*msg.DecEncoder
+}
+
+type decEncoderKey struct {
+	d      *Dialect
+	policy CollisionPolicy
+}
+
+// maximum number of cached DecEncoders. When it is exceeded, the DecEncoder
+// that was cached first is discarded.
+const decEncoderCacheSize = 16
+
+// DecEncoders are cached by dialect and collision policy, in order to share
+// them between nodes that use the same dialect.
+var decEncoderCache = struct {
+	mutex   sync.Mutex
+	entries map[decEncoderKey]*DecEncoder
+	keys    []decEncoderKey
+}{
+	entries: make(map[decEncoderKey]*DecEncoder),
 }
 
 // NewDecEncoder allocates a DecEncoder.
+// If the dialect contains multiple messages with the same id,
+// a *DuplicateMessageError is returned.
+// Since messages are processed on first use, errors in their definitions are
+// reported by MessageDE(). Use Validate() to check the whole dialect in advance.
+// The last DecEncoders are cached, therefore subsequent calls with the same
+// dialect usually return the same DecEncoder, and a dialect must not be
+// modified after it has been used, except through RegisterMessage() and
+// UnregisterMessage().
 func NewDecEncoder(d *Dialect) (*DecEncoder, error) {
+	return NewDecEncoderWithPolicy(d, CollisionError)
+}
+
+// NewDecEncoderWithPolicy allocates a DecEncoder. The behavior in case the
+// dialect contains multiple messages with the same id is set by policy.
+// Use Dialect.Collisions() to list the messages that are discarded.
+func NewDecEncoderWithPolicy(d *Dialect, policy CollisionPolicy) (*DecEncoder, error) {
+	key := decEncoderKey{d, policy}
+
+	decEncoderCache.mutex.Lock()
+	defer decEncoderCache.mutex.Unlock()
+
+	if dde, ok := decEncoderCache.entries[key]; ok {
+		return dde, nil
+	}
+
+	registerMutex.RLock()
+	generation := atomic.LoadUint64(&registerGeneration)
+	messages, err := messagesById(d.Messages, policy)
+	registerMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
 	dde := &DecEncoder{
-		MessageDEs: make(map[uint32]*msg.DecEncoder),
+		d:          d,
+		policy:     policy,
+		generation: generation,
+		messages:   messages,
+		des:        make(map[uint32]*msg.DecEncoder),
+	}
+
+	for len(decEncoderCache.keys) >= decEncoderCacheSize {
+		delete(decEncoderCache.entries, decEncoderCache.keys[0])
+		decEncoderCache.keys = append(decEncoderCache.keys[:0], decEncoderCache.keys[1:]...)
 	}
+	decEncoderCache.entries[key] = dde
+	decEncoderCache.keys = append(decEncoderCache.keys, key)
+
+	return dde, nil
+}
+
+func messagesById(msgs []msg.Message, policy CollisionPolicy) (map[uint32]msg.Message, error) {
+	ret := make(map[uint32]msg.Message)
+
+	for _, m := range msgs {
+		if first, ok := ret[m.GetId()]; ok {
+			switch policy {
+			case CollisionReplace:
 
-	for _, m := range d.Messages {
-		if _, ok := dde.MessageDEs[m.GetId()]; ok {
-			return nil, fmt.Errorf("duplicate message with id %d", m.GetId())
+			case CollisionKeep:
+				continue
+
+			default:
+				return nil, &DuplicateMessageError{m.GetId(), first, m}
+			}
 		}
 
-		de, err := msg.NewDecEncoder(m)
+		ret[m.GetId()] = m
+	}
+
+	return ret, nil
+}
+
+// update applies the messages that have been registered or unregistered
+// after the DecEncoder was built.
+func (dde *DecEncoder) update() {
+	generation := atomic.LoadUint64(&registerGeneration)
+
+	dde.mutex.RLock()
+	upToDate := dde.generation == generation
+	dde.mutex.RUnlock()
+
+	if upToDate {
+		return
+	}
+
+	registerMutex.RLock()
+	generation = atomic.LoadUint64(&registerGeneration)
+	messages, err := messagesById(dde.d.Messages, dde.policy)
+	registerMutex.RUnlock()
+
+	dde.mutex.Lock()
+	defer dde.mutex.Unlock()
+
+	// another routine applied a newer state in the meanwhile
+	if dde.generation >= generation {
+		return
+	}
+	dde.generation = generation
+
+	// keep the previous messages if the dialect has been modified
+	// without RegisterMessage()
+	if err != nil {
+		return
+	}
+
+	for id, m := range dde.messages {
+		if messages[id] != m {
+			delete(dde.des, id)
+		}
+	}
+	dde.messages = messages
+}
+
+// MessageDE returns the DecEncoder of the message with the given id.
+// It returns false if the message is not in the dialect.
+func (dde *DecEncoder) MessageDE(id uint32) (*msg.DecEncoder, bool, error) {
+	dde.update()
+
+	dde.mutex.RLock()
+	de, ok := dde.des[id]
+	m, inDialect := dde.messages[id]
+	dde.mutex.RUnlock()
+
+	if ok {
+		return de, true, nil
+	}
+	if !inDialect {
+		return nil, false, nil
+	}
+
+	de, err := msg.NewDecEncoder(m)
+	if err != nil {
+		return nil, true, fmt.Errorf("message %T: %s", m, err)
+	}
+
+	dde.mutex.Lock()
+	defer dde.mutex.Unlock()
+
+	// the message may have been replaced or unregistered in the meanwhile
+	if dde.messages[id] == m {
+		dde.des[id] = de
+	}
+
+	return de, true, nil
+}
+
+func (dde *DecEncoder) ids() []uint32 {
+	dde.update()
+
+	dde.mutex.RLock()
+	defer dde.mutex.RUnlock()
+
+	ret := make([]uint32, 0, len(dde.messages))
+	for id := range dde.messages {
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+// Validate builds the DecEncoders of all messages, and returns the first error
+// in their definitions.
+func (dde *DecEncoder) Validate() error {
+	for _, id := range dde.ids() {
+		_, _, err := dde.MessageDE(id)
 		if err != nil {
-			return nil, fmt.Errorf("message %T: %s", m, err)
+			return err
 		}
+	}
+	return nil
+}
 
-		dde.MessageDEs[m.GetId()] = de
+// PayloadBounds returns the minimum and maximum payload size of the message
+// with the given id. It returns false if the message is not in the dialect.
+func (dde *DecEncoder) PayloadBounds(id uint32, isV2 bool) (int, int, bool) {
+	de, ok, err := dde.MessageDE(id)
+	if !ok || err != nil {
+		return 0, 0, false
 	}
 
-	return dde, nil
+	min, max := de.PayloadBounds(isV2)
+	return min, max, true
+}
+
+// MaxPayloadSize returns the maximum payload size of the messages of the dialect.
+// Messages with invalid definitions are ignored.
+func (dde *DecEncoder) MaxPayloadSize(isV2 bool) int {
+	ret := 0
+	for _, id := range dde.ids() {
+		_, max, ok := dde.PayloadBounds(id, isV2)
+		if ok && max > ret {
+			ret = max
+		}
+	}
+	return ret
 }
@@ -0,0 +1,171 @@
+package dialect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/msg"
+)
+
+type MessageTest1 struct {
+	A uint8
+	B uint32
+}
+
+func (*MessageTest1) GetId() uint32 {
+	return 1
+}
+
+type MessageTest2 struct {
+	A uint16
+	B uint32 `mavext:"true"`
+}
+
+func (*MessageTest2) GetId() uint32 {
+	return 2
+}
+
+func TestDecEncoderPayloadBounds(t *testing.T) {
+	dde, err := NewDecEncoder(&Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}, &MessageTest2{}},
+	})
+	require.NoError(t, err)
+
+	min, max, ok := dde.PayloadBounds(2, false)
+	require.Equal(t, true, ok)
+	require.Equal(t, 2, min)
+	require.Equal(t, 2, max)
+
+	min, max, ok = dde.PayloadBounds(2, true)
+	require.Equal(t, true, ok)
+	require.Equal(t, 1, min)
+	require.Equal(t, 6, max)
+
+	_, _, ok = dde.PayloadBounds(3, true)
+	require.Equal(t, false, ok)
+
+	require.Equal(t, 5, dde.MaxPayloadSize(false))
+	require.Equal(t, 6, dde.MaxPayloadSize(true))
+}
+
+type InvalidTest3 struct {
+	A uint8
+}
+
+func (*InvalidTest3) GetId() uint32 {
+	return 3
+}
+
+func TestDecEncoderLazy(t *testing.T) {
+	dde, err := NewDecEncoder(&Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}, &InvalidTest3{}},
+	})
+	require.NoError(t, err)
+
+	de1, ok, err := dde.MessageDE(1)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+
+	de2, ok, err := dde.MessageDE(1)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	require.True(t, de1 == de2)
+
+	_, ok, err = dde.MessageDE(2)
+	require.NoError(t, err)
+	require.Equal(t, false, ok)
+
+	_, ok, err = dde.MessageDE(3)
+	require.Error(t, err)
+	require.Equal(t, true, ok)
+
+	require.Error(t, dde.Validate())
+}
+
+func TestDecEncoderDuplicate(t *testing.T) {
+	_, err := NewDecEncoder(&Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}, &MessageTest1{}},
+	})
+	require.Error(t, err)
+}
+
+func TestDecEncoderCollisionPolicy(t *testing.T) {
+	first := &MessageTest1{}
+	second := &MessageTest1{}
+	d := &Dialect{
+		Version:  3,
+		Messages: []msg.Message{first, &MessageTest2{}, second},
+	}
+
+	_, err := NewDecEncoderWithPolicy(d, CollisionError)
+	require.Equal(t, &DuplicateMessageError{first.GetId(), first, second}, err)
+	require.EqualError(t, err, "duplicate message with id 1 (*dialect.MessageTest1 and *dialect.MessageTest1)")
+
+	dde, err := NewDecEncoderWithPolicy(d, CollisionKeep)
+	require.NoError(t, err)
+	require.True(t, dde.messages[first.GetId()] == first)
+
+	dde, err = NewDecEncoderWithPolicy(d, CollisionReplace)
+	require.NoError(t, err)
+	require.True(t, dde.messages[first.GetId()] == second)
+
+	require.Equal(t, []*DuplicateMessageError{{first.GetId(), first, second}}, d.Collisions())
+}
+
+func TestDecEncoderCache(t *testing.T) {
+	d := &Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}, &MessageTest2{}},
+	}
+
+	dde1, err := NewDecEncoder(d)
+	require.NoError(t, err)
+
+	dde2, err := NewDecEncoder(d)
+	require.NoError(t, err)
+	require.True(t, dde1 == dde2)
+
+	dde3, err := NewDecEncoder(&Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}, &MessageTest2{}},
+	})
+	require.NoError(t, err)
+	require.True(t, dde1 != dde3)
+}
+
+func TestDecEncoderCacheEviction(t *testing.T) {
+	d := &Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}},
+	}
+
+	dde1, err := NewDecEncoder(d)
+	require.NoError(t, err)
+
+	for i := 0; i < decEncoderCacheSize; i++ {
+		_, err := NewDecEncoder(&Dialect{
+			Version:  3,
+			Messages: []msg.Message{&MessageTest1{}},
+		})
+		require.NoError(t, err)
+	}
+	require.Equal(t, decEncoderCacheSize, len(decEncoderCache.entries))
+
+	dde2, err := NewDecEncoder(d)
+	require.NoError(t, err)
+	require.True(t, dde1 != dde2)
+
+	// evicted DecEncoders still follow the registrations
+	err = d.RegisterMessage(&MessageTest2{}, CollisionError)
+	require.NoError(t, err)
+
+	for _, dde := range []*DecEncoder{dde1, dde2} {
+		_, ok, err := dde.MessageDE(2)
+		require.NoError(t, err)
+		require.Equal(t, true, ok)
+	}
+}
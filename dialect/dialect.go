@@ -3,14 +3,203 @@
 package dialect
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
 	"github.com/aler9/gomavlib/msg"
 )
 
+// registerMutex protects the messages of dialects from concurrent
+// registrations.
+var registerMutex sync.RWMutex
+
+// registerGeneration is incremented when messages are registered or
+// unregistered, and allows DecEncoders to detect changes of their dialects.
+var registerGeneration uint64
+
+// CollisionPolicy is the behavior of RegisterMessage() when the dialect
+// already contains a message with the same id, and of NewDecEncoderWithPolicy()
+// when the dialect contains multiple messages with the same id.
+type CollisionPolicy int
+
+const (
+	// CollisionError makes RegisterMessage() and NewDecEncoderWithPolicy()
+	// return an error.
+	CollisionError CollisionPolicy = iota
+
+	// CollisionReplace replaces the existing message with the new one, i.e.
+	// the last message with a given id is used.
+	CollisionReplace
+
+	// CollisionKeep keeps the existing message and discards the new one, i.e.
+	// the first message with a given id is used.
+	CollisionKeep
+)
+
+// DuplicateMessageError is returned when a dialect contains multiple messages
+// with the same id.
+type DuplicateMessageError struct {
+	// the id of the messages.
+	Id uint32
+
+	// the message that comes first in the dialect.
+	First msg.Message
+
+	// the message that comes after.
+	Second msg.Message
+}
+
+// Error implements the error interface.
+func (e *DuplicateMessageError) Error() string {
+	return fmt.Sprintf("duplicate message with id %d (%T and %T)", e.Id, e.First, e.Second)
+}
+
 // Dialect is a Mavlink dialect.
 type Dialect struct {
 	// Version is the dialect version.
 	Version int
 
 	// Messages contains the messages of the dialect.
+	// When RegisterMessage() or UnregisterMessage() are used, read it with
+	// MessagesSnapshot().
 	Messages []msg.Message
 }
+
+// MessagesSnapshot returns the messages of the dialect. It can be called
+// while messages are registered or unregistered. Since these operations
+// replace the slice instead of modifying it, the returned slice does not
+// change, and it must not be modified.
+func (d *Dialect) MessagesSnapshot() []msg.Message {
+	registerMutex.RLock()
+	defer registerMutex.RUnlock()
+	return d.Messages
+}
+
+// CheckMessages checks whether the dialect contains the given messages,
+// that are usually taken from another dialect, and whether they are compatible
+// (i.e. they have the same CRC extra).
+func (d *Dialect) CheckMessages(msgs ...msg.Message) error {
+	registerMutex.RLock()
+	byId := make(map[uint32]msg.Message)
+	for _, m := range d.Messages {
+		byId[m.GetId()] = m
+	}
+	registerMutex.RUnlock()
+
+	for _, m := range msgs {
+		dm, ok := byId[m.GetId()]
+		if !ok {
+			return fmt.Errorf("dialect does not contain message %T", m)
+		}
+
+		de1, err := msg.NewDecEncoder(dm)
+		if err != nil {
+			return err
+		}
+
+		de2, err := msg.NewDecEncoder(m)
+		if err != nil {
+			return err
+		}
+
+		if de1.CRCExtra() != de2.CRCExtra() {
+			return fmt.Errorf("message %T is not compatible with the one of the dialect", m)
+		}
+	}
+
+	return nil
+}
+
+// Collisions returns the messages of the dialect that have the same id of a
+// previous message. It allows to diagnose dialects that are built by combining
+// generated and custom messages.
+func (d *Dialect) Collisions() []*DuplicateMessageError {
+	registerMutex.RLock()
+	defer registerMutex.RUnlock()
+
+	var ret []*DuplicateMessageError
+	byId := make(map[uint32]msg.Message)
+	for _, m := range d.Messages {
+		if first, ok := byId[m.GetId()]; ok {
+			ret = append(ret, &DuplicateMessageError{m.GetId(), first, m})
+			continue
+		}
+		byId[m.GetId()] = m
+	}
+	return ret
+}
+
+// RegisterMessage adds a message to the dialect, i.e. an experimental or
+// private message. It can be called while the dialect is used by nodes, that
+// start decoding and encoding the message immediately.
+// The behavior in case the dialect already contains a message with the same id
+// is set by policy.
+func (d *Dialect) RegisterMessage(m msg.Message, policy CollisionPolicy) error {
+	_, err := msg.NewDecEncoder(m)
+	if err != nil {
+		return fmt.Errorf("message %T: %s", m, err)
+	}
+
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+
+	pos := -1
+	for i, dm := range d.Messages {
+		if dm.GetId() == m.GetId() {
+			pos = i
+			break
+		}
+	}
+
+	if pos >= 0 {
+		switch policy {
+		case CollisionReplace:
+
+		case CollisionKeep:
+			return nil
+
+		default:
+			return fmt.Errorf("dialect already contains a message with id %d", m.GetId())
+		}
+	}
+
+	// do not modify the existing slice, since its array may be shared
+	// with the code that created the dialect
+	msgs := append([]msg.Message(nil), d.Messages...)
+	if pos >= 0 {
+		msgs[pos] = m
+	} else {
+		msgs = append(msgs, m)
+	}
+	d.Messages = msgs
+	atomic.AddUint64(&registerGeneration, 1)
+
+	return nil
+}
+
+// UnregisterMessage removes the message with the given id from the dialect.
+func (d *Dialect) UnregisterMessage(id uint32) error {
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+
+	pos := -1
+	for i, dm := range d.Messages {
+		if dm.GetId() == id {
+			pos = i
+			break
+		}
+	}
+
+	if pos < 0 {
+		return fmt.Errorf("dialect does not contain a message with id %d", id)
+	}
+
+	msgs := make([]msg.Message, 0, len(d.Messages)-1)
+	msgs = append(msgs, d.Messages[:pos]...)
+	msgs = append(msgs, d.Messages[pos+1:]...)
+	d.Messages = msgs
+	atomic.AddUint64(&registerGeneration, 1)
+
+	return nil
+}
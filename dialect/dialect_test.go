@@ -0,0 +1,100 @@
+package dialect
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/msg"
+)
+
+type MessageTest2Alt struct {
+	A uint32
+}
+
+func (*MessageTest2Alt) GetId() uint32 {
+	return 2
+}
+
+func TestDialectRegisterMessage(t *testing.T) {
+	d := &Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}},
+	}
+
+	dde, err := NewDecEncoder(d)
+	require.NoError(t, err)
+
+	_, ok, err := dde.MessageDE(2)
+	require.NoError(t, err)
+	require.Equal(t, false, ok)
+
+	err = d.RegisterMessage(&MessageTest2{}, CollisionError)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(d.Messages))
+
+	de, ok, err := dde.MessageDE(2)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	require.Equal(t, "TEST2", de.Name())
+
+	err = d.RegisterMessage(&MessageTest2Alt{}, CollisionError)
+	require.Error(t, err)
+
+	err = d.RegisterMessage(&MessageTest2Alt{}, CollisionKeep)
+	require.NoError(t, err)
+	de, _, _ = dde.MessageDE(2)
+	require.Equal(t, "TEST2", de.Name())
+
+	err = d.RegisterMessage(&MessageTest2Alt{}, CollisionReplace)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(d.Messages))
+	de, _, _ = dde.MessageDE(2)
+	require.Equal(t, "TEST2_ALT", de.Name())
+
+	err = d.RegisterMessage(&InvalidTest3{}, CollisionError)
+	require.Error(t, err)
+
+	err = d.UnregisterMessage(2)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(d.Messages))
+
+	_, ok, err = dde.MessageDE(2)
+	require.NoError(t, err)
+	require.Equal(t, false, ok)
+
+	err = d.UnregisterMessage(2)
+	require.Error(t, err)
+
+	// registered messages can be checked like the other ones
+	err = d.RegisterMessage(&MessageTest2{}, CollisionError)
+	require.NoError(t, err)
+	require.NoError(t, d.CheckMessages(&MessageTest2{}))
+}
+
+func TestDialectMessagesSnapshot(t *testing.T) {
+	d := &Dialect{
+		Version:  3,
+		Messages: []msg.Message{&MessageTest1{}},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			d.RegisterMessage(&MessageTest2{}, CollisionError)
+			d.UnregisterMessage(2)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		msgs := d.MessagesSnapshot()
+		require.Equal(t, true, len(msgs) == 1 || len(msgs) == 2)
+		require.Equal(t, uint32(1), msgs[0].GetId())
+	}
+
+	wg.Wait()
+	require.Equal(t, []msg.Message{&MessageTest1{}}, d.MessagesSnapshot())
+}
@@ -9,6 +9,9 @@ import (
 )
 
 func TestDialect(t *testing.T) {
-	_, err := dialect.NewDecEncoder(Dialect)
+	dde, err := dialect.NewDecEncoder(Dialect)
+	require.NoError(t, err)
+
+	err = dde.Validate()
 	require.NoError(t, err)
 }
@@ -1,2 +1,4 @@
 // Package dialects contains the official, autogenerated Mavlink dialects.
 package dialects
+
+//go:generate go run github.com/aler9/gomavlib/commands/dialects-gen --out=.
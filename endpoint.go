@@ -5,6 +5,8 @@ import (
 )
 
 // EndpointConf is the interface implemented by all endpoint configurations.
+// It can't be implemented outside this package; external transports can be
+// plugged in with RegisterTransport and EndpointTransport.
 type EndpointConf interface {
 	init() (Endpoint, error)
 }
@@ -17,6 +19,28 @@ type Endpoint interface {
 	isEndpoint()
 }
 
+// endpointWrapper is implemented by the configurations of endpoints that wrap
+// another endpoint, like EndpointSigned or EndpointTap.
+type endpointWrapper interface {
+	unwrap() EndpointConf
+}
+
+// endpointChain returns the configuration of an endpoint, followed by the
+// configurations of the endpoints it wraps, from the outermost to the
+// innermost.
+func endpointChain(conf EndpointConf) []EndpointConf {
+	var ret []EndpointConf
+	for conf != nil {
+		ret = append(ret, conf)
+		w, ok := conf.(endpointWrapper)
+		if !ok {
+			break
+		}
+		conf = w.unwrap()
+	}
+	return ret
+}
+
 // a endpoint must also implement one of the following:
 // - endpointChannelSingle
 // - endpointChannelAccepter
@@ -1,10 +1,12 @@
 package gomavlib
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"reflect"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -39,12 +41,23 @@ type EndpointUdpBroadcast struct {
 	// (optional) the listening address. if empty, it will be computed
 	// from the broadcast address.
 	LocalAddress string
+
+	// (optional) a function that is called after creating the socket and
+	// before binding it, that allows to set socket options. See net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// (optional) the sources from which frames are accepted, in IP
+	// (192.168.1.5) or CIDR (192.168.1.0/24) notation. Datagrams coming from
+	// other sources are discarded before being parsed.
+	// If empty, all sources are accepted.
+	AllowedSources []string
 }
 
 type endpointUdpBroadcast struct {
 	conf          EndpointUdpBroadcast
 	packetConn    net.PacketConn
 	broadcastAddr net.Addr
+	filter        func(net.IP) bool
 
 	terminate chan struct{}
 }
@@ -77,7 +90,15 @@ func (conf EndpointUdpBroadcast) init() (Endpoint, error) {
 		}
 	}
 
-	packetConn, err := net.ListenPacket("udp4", conf.LocalAddress)
+	filter, err := sourceFilter(conf.AllowedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := &net.ListenConfig{
+		Control: conf.Control,
+	}
+	packetConn, err := lc.ListenPacket(context.Background(), "udp4", conf.LocalAddress)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +109,7 @@ func (conf EndpointUdpBroadcast) init() (Endpoint, error) {
 		conf:          conf,
 		packetConn:    packetConn,
 		broadcastAddr: &net.UDPAddr{IP: broadcastIp, Port: iport},
+		filter:        filter,
 		terminate:     make(chan struct{}),
 	}
 	return t, nil
@@ -110,17 +132,23 @@ func (t *endpointUdpBroadcast) Close() error {
 }
 
 func (t *endpointUdpBroadcast) Read(buf []byte) (int, error) {
-	// read WITHOUT deadline. Long periods without packets are normal since
-	// we're not directly connected to someone.
-	n, _, err := t.packetConn.ReadFrom(buf)
+	for {
+		// read WITHOUT deadline. Long periods without packets are normal since
+		// we're not directly connected to someone.
+		n, addr, err := t.packetConn.ReadFrom(buf)
 
-	// wait termination, do not report errors
-	if err != nil {
-		<-t.terminate
-		return 0, errorTerminated
-	}
+		// wait termination, do not report errors
+		if err != nil {
+			<-t.terminate
+			return 0, errorTerminated
+		}
 
-	return n, nil
+		if t.filter != nil && !t.filter(addr.(*net.UDPAddr).IP) {
+			continue
+		}
+
+		return n, nil
+	}
 }
 
 func (t *endpointUdpBroadcast) Write(buf []byte) (int, error) {
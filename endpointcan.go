@@ -0,0 +1,298 @@
+package gomavlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+const (
+	// uavcan.tunnel.Broadcast
+	canTunnelDataTypeId        = 2010
+	canTunnelDataTypeSignature = 0x5aa2d4d9cf4b1e85
+
+	// uavcan.tunnel.Protocol
+	canTunnelProtocolMavlink  = 0
+	canTunnelProtocolMavlink2 = 1
+
+	// maximum size of the buffer of a tunnel message
+	canTunnelBufferSize = 60
+
+	// medium priority
+	canPriority = 16
+
+	canTailStart  = 0x80
+	canTailEnd    = 0x40
+	canTailToggle = 0x20
+)
+
+// canFrame is a CAN 2.0B frame with a 29-bit identifier.
+type canFrame struct {
+	id   uint32
+	data []byte
+}
+
+// canSocket is implemented by the platform-specific CAN interfaces.
+type canSocket interface {
+	readFrame() (canFrame, error)
+	writeFrame(canFrame) error
+	Close() error
+}
+
+// EndpointCan sets up a endpoint that works with a CAN bus, through
+// SocketCAN. Frames are tunneled with DroneCAN (UAVCAN v0) messages of type
+// uavcan.tunnel.Broadcast, that are fragmented into CAN frames and
+// reassembled. This allows to communicate with flight controllers that are
+// connected through CAN instead of serial, like ArduPilot with CAN MAVLink.
+// It is available on Linux only.
+type EndpointCan struct {
+	// the name of the CAN interface, example: can0
+	Interface string
+
+	// the DroneCAN node id used to send messages, between 1 and 127.
+	NodeId byte
+
+	// (optional) the tunnel channel, that allows to run multiple
+	// tunnels on the same bus. Messages with other channels are discarded.
+	Channel byte
+}
+
+type endpointCan struct {
+	conf   EndpointCan
+	socket canSocket
+
+	// accessed by Write() only
+	writeMutex      sync.Mutex
+	writeTransferId byte
+
+	// accessed by Read() only
+	transfers map[byte]*canTransfer
+	pending   []byte
+}
+
+func (conf EndpointCan) init() (Endpoint, error) {
+	if conf.Interface == "" {
+		return nil, fmt.Errorf("interface not provided")
+	}
+	if conf.NodeId < 1 || conf.NodeId > 127 {
+		return nil, fmt.Errorf("NodeId must be between 1 and 127")
+	}
+
+	socket, err := canOpen(conf.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEndpointCan(conf, socket), nil
+}
+
+func newEndpointCan(conf EndpointCan, socket canSocket) *endpointCan {
+	return &endpointCan{
+		conf:      conf,
+		socket:    socket,
+		transfers: make(map[byte]*canTransfer),
+	}
+}
+
+func (t *endpointCan) isEndpoint() {}
+
+func (t *endpointCan) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointCan) Label() string {
+	return fmt.Sprintf("can:%s", t.conf.Interface)
+}
+
+func (t *endpointCan) Close() error {
+	return t.socket.Close()
+}
+
+func (t *endpointCan) Read(buf []byte) (int, error) {
+	for len(t.pending) == 0 {
+		f, err := t.socket.readFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		payload := t.onFrame(f)
+
+		// protocol, channel and at least one byte
+		if len(payload) < 3 {
+			continue
+		}
+		if payload[0] != canTunnelProtocolMavlink && payload[0] != canTunnelProtocolMavlink2 {
+			continue
+		}
+		if payload[1] != t.conf.Channel {
+			continue
+		}
+
+		t.pending = payload[2:]
+	}
+
+	n := copy(buf, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// onFrame processes a CAN frame and returns the payload of a tunnel
+// message when its last frame has been received.
+func (t *endpointCan) onFrame(f canFrame) []byte {
+	// tunnel broadcasts only, no services
+	if len(f.data) < 1 ||
+		(f.id>>8)&0xFFFF != canTunnelDataTypeId ||
+		(f.id&0x80) != 0 {
+		return nil
+	}
+
+	sourceId := byte(f.id & 0x7F)
+	tail := f.data[len(f.data)-1]
+	data := f.data[:len(f.data)-1]
+	transferId := tail & 0x1F
+
+	// single-frame transfer
+	if (tail&canTailStart) != 0 && (tail&canTailEnd) != 0 {
+		delete(t.transfers, sourceId)
+		return append([]byte(nil), data...)
+	}
+
+	tr, ok := t.transfers[sourceId]
+
+	if (tail & canTailStart) != 0 {
+		if (tail & canTailToggle) != 0 {
+			delete(t.transfers, sourceId)
+			return nil
+		}
+		tr = &canTransfer{transferId: transferId}
+		t.transfers[sourceId] = tr
+
+	} else if !ok || tr.transferId != transferId || tr.toggle != ((tail&canTailToggle) != 0) {
+		delete(t.transfers, sourceId)
+		return nil
+	}
+
+	tr.buf = append(tr.buf, data...)
+	tr.toggle = !tr.toggle
+
+	if (tail & canTailEnd) == 0 {
+		if len(tr.buf) > canTunnelBufferSize+4 {
+			delete(t.transfers, sourceId)
+		}
+		return nil
+	}
+
+	delete(t.transfers, sourceId)
+
+	// multi-frame transfers start with the transfer CRC
+	if len(tr.buf) < 2 {
+		return nil
+	}
+	payload := tr.buf[2:]
+	if binary.LittleEndian.Uint16(tr.buf) != canTransferCrc(payload) {
+		return nil
+	}
+	return payload
+}
+
+func (t *endpointCan) Write(buf []byte) (int, error) {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	for i := 0; i < len(buf); i += canTunnelBufferSize {
+		end := i + canTunnelBufferSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		payload := append([]byte{canTunnelProtocolMavlink2, t.conf.Channel}, buf[i:end]...)
+
+		for _, f := range canEncodeTransfer(payload, t.conf.NodeId, t.writeTransferId) {
+			err := t.socket.writeFrame(f)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		t.writeTransferId = (t.writeTransferId + 1) & 0x1F
+	}
+
+	return len(buf), nil
+}
+
+// canTransfer is a multi-frame transfer that is being reassembled.
+type canTransfer struct {
+	transferId byte
+	toggle     bool
+	buf        []byte
+}
+
+// canEncodeTransfer splits a tunnel message into CAN frames.
+func canEncodeTransfer(payload []byte, nodeId byte, transferId byte) []canFrame {
+	id := uint32(canPriority)<<24 | uint32(canTunnelDataTypeId)<<8 | uint32(nodeId)
+
+	if len(payload) <= 7 {
+		return []canFrame{{
+			id:   id,
+			data: append(append([]byte(nil), payload...), canTailStart|canTailEnd|transferId),
+		}}
+	}
+
+	buf := make([]byte, 2+len(payload))
+	binary.LittleEndian.PutUint16(buf, canTransferCrc(payload))
+	copy(buf[2:], payload)
+
+	var frames []canFrame
+	toggle := false
+	for i := 0; i < len(buf); i += 7 {
+		end := i + 7
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		tail := transferId
+		if i == 0 {
+			tail |= canTailStart
+		}
+		if end == len(buf) {
+			tail |= canTailEnd
+		}
+		if toggle {
+			tail |= canTailToggle
+		}
+		toggle = !toggle
+
+		frames = append(frames, canFrame{
+			id:   id,
+			data: append(append([]byte(nil), buf[i:end]...), tail),
+		})
+	}
+	return frames
+}
+
+// canTransferCrc computes the CRC-16-CCITT of a multi-frame transfer,
+// that is seeded with the data type signature.
+func canTransferCrc(payload []byte) uint16 {
+	crc := uint16(0xFFFF)
+
+	add := func(b byte) {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if (crc & 0x8000) != 0 {
+				crc = (crc << 1) ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	sig := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sig, canTunnelDataTypeSignature)
+	for _, b := range sig {
+		add(b)
+	}
+	for _, b := range payload {
+		add(b)
+	}
+	return crc
+}
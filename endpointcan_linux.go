@@ -0,0 +1,103 @@
+// +build linux,amd64 linux,arm64
+
+package gomavlib
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	afCan  = 29
+	canRaw = 1
+
+	// flag of identifiers with 29 bits
+	canEffFlag = 0x80000000
+	canEffMask = 0x1FFFFFFF
+
+	// size of struct can_frame
+	canFrameSize = 16
+)
+
+// rawSockaddrCan is struct sockaddr_can.
+type rawSockaddrCan struct {
+	family  uint16
+	_       [2]byte
+	ifindex int32
+	addr    [16]byte
+}
+
+// canSocketLinux is a raw SocketCAN socket.
+type canSocketLinux struct {
+	f *os.File
+}
+
+func canOpen(intfName string) (canSocket, error) {
+	intf, err := net.InterfaceByName(intfName)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(afCan, syscall.SOCK_RAW, canRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := rawSockaddrCan{
+		family:  afCan,
+		ifindex: int32(intf.Index),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd),
+		uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr))
+	if errno != 0 {
+		syscall.Close(fd)
+		return nil, errno
+	}
+
+	// a non-blocking file uses the runtime poller, therefore Close()
+	// interrupts Read()
+	err = syscall.SetNonblock(fd, true)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &canSocketLinux{os.NewFile(uintptr(fd), intfName)}, nil
+}
+
+func (s *canSocketLinux) Close() error {
+	return s.f.Close()
+}
+
+func (s *canSocketLinux) readFrame() (canFrame, error) {
+	for {
+		var buf [canFrameSize]byte
+		n, err := s.f.Read(buf[:])
+		if err != nil {
+			return canFrame{}, err
+		}
+
+		// discard standard, remote and error frames
+		id := binary.LittleEndian.Uint32(buf[:4])
+		if n != canFrameSize || (id&canEffFlag) == 0 || (id&0x60000000) != 0 || buf[4] > 8 {
+			continue
+		}
+
+		return canFrame{
+			id:   id & canEffMask,
+			data: append([]byte(nil), buf[8:8+buf[4]]...),
+		}, nil
+	}
+}
+
+func (s *canSocketLinux) writeFrame(f canFrame) error {
+	var buf [canFrameSize]byte
+	binary.LittleEndian.PutUint32(buf[:4], f.id|canEffFlag)
+	buf[4] = byte(len(f.data))
+	copy(buf[8:], f.data)
+	_, err := s.f.Write(buf[:])
+	return err
+}
@@ -0,0 +1,11 @@
+// +build !linux !amd64,!arm64
+
+package gomavlib
+
+import (
+	"fmt"
+)
+
+func canOpen(intfName string) (canSocket, error) {
+	return nil, fmt.Errorf("CAN endpoints are supported on Linux only")
+}
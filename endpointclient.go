@@ -1,16 +1,24 @@
 package gomavlib
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"syscall"
 	"time"
 )
 
 type endpointClientConf interface {
 	isUdp() bool
 	getAddress() string
+	getControl() func(string, string, syscall.RawConn) error
+	getTLSConfig() *tls.Config
+	getReconnect() ReconnectPolicy
+	getLocalAddress() string
+	getReplyToLastSender() bool
 }
 
 // EndpointTcpClient sets up a endpoint that works with a TCP client.
@@ -20,6 +28,14 @@ type endpointClientConf interface {
 type EndpointTcpClient struct {
 	// domain name or IP of the server to connect to, example: 1.2.3.4:5600
 	Address string
+
+	// (optional) a function that is called after creating the socket and
+	// before connecting, that allows to set socket options. See net.Dialer.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
 }
 
 func (EndpointTcpClient) isUdp() bool {
@@ -30,14 +46,111 @@ func (conf EndpointTcpClient) getAddress() string {
 	return conf.Address
 }
 
+func (conf EndpointTcpClient) getControl() func(string, string, syscall.RawConn) error {
+	return conf.Control
+}
+
+func (EndpointTcpClient) getTLSConfig() *tls.Config {
+	return nil
+}
+
+func (conf EndpointTcpClient) getReconnect() ReconnectPolicy {
+	return conf.Reconnect
+}
+
+func (EndpointTcpClient) getLocalAddress() string {
+	return ""
+}
+
+func (EndpointTcpClient) getReplyToLastSender() bool {
+	return false
+}
+
 func (conf EndpointTcpClient) init() (Endpoint, error) {
 	return initEndpointClient(conf)
 }
 
+// EndpointTcpTlsClient sets up a endpoint that works with a TCP client
+// and encrypts traffic with TLS. It is fit for routing frames through the
+// internet, since the server can be authenticated with its certificate, and
+// the client can be authenticated by the server with a client certificate.
+type EndpointTcpTlsClient struct {
+	// domain name or IP of the server to connect to, example: 1.2.3.4:5600
+	Address string
+
+	// the TLS configuration. Certificates, if the server requires them,
+	// must be set in Certificates. If ServerName is empty, it is filled
+	// with the host of Address.
+	TLSConfig *tls.Config
+
+	// (optional) a function that is called after creating the socket and
+	// before connecting, that allows to set socket options. See net.Dialer.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+func (EndpointTcpTlsClient) isUdp() bool {
+	return false
+}
+
+func (conf EndpointTcpTlsClient) getAddress() string {
+	return conf.Address
+}
+
+func (conf EndpointTcpTlsClient) getControl() func(string, string, syscall.RawConn) error {
+	return conf.Control
+}
+
+func (conf EndpointTcpTlsClient) getTLSConfig() *tls.Config {
+	return conf.TLSConfig
+}
+
+func (conf EndpointTcpTlsClient) getReconnect() ReconnectPolicy {
+	return conf.Reconnect
+}
+
+func (EndpointTcpTlsClient) getLocalAddress() string {
+	return ""
+}
+
+func (EndpointTcpTlsClient) getReplyToLastSender() bool {
+	return false
+}
+
+func (conf EndpointTcpTlsClient) init() (Endpoint, error) {
+	if conf.TLSConfig == nil {
+		return nil, fmt.Errorf("TLSConfig not provided")
+	}
+	return initEndpointClient(conf)
+}
+
 // EndpointUdpClient sets up a endpoint that works with a UDP client.
 type EndpointUdpClient struct {
 	// domain name or IP of the server to connect to, example: 1.2.3.4:5600
 	Address string
+
+	// (optional) a function that is called after creating the socket and
+	// before connecting, that allows to set socket options. See net.Dialer.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+
+	// (optional) the local address to which the socket is bound, example:
+	// 0.0.0.0:14550. It allows to interoperate with devices that send frames
+	// to a fixed port. It defaults to a random port.
+	LocalAddress string
+
+	// (optional) accept frames from any source, and write frames to the
+	// source of the last received frame, instead of Address. It allows to
+	// interoperate with devices that switch source port during a session,
+	// like ESP8266 telemetry bridges, and with devices that reply from
+	// another address, i.e. when Address is a broadcast address.
+	ReplyToLastSender bool
 }
 
 func (EndpointUdpClient) isUdp() bool {
@@ -48,15 +161,99 @@ func (conf EndpointUdpClient) getAddress() string {
 	return conf.Address
 }
 
+func (conf EndpointUdpClient) getControl() func(string, string, syscall.RawConn) error {
+	return conf.Control
+}
+
+func (EndpointUdpClient) getTLSConfig() *tls.Config {
+	return nil
+}
+
+func (conf EndpointUdpClient) getReconnect() ReconnectPolicy {
+	return conf.Reconnect
+}
+
+func (conf EndpointUdpClient) getLocalAddress() string {
+	return conf.LocalAddress
+}
+
+func (conf EndpointUdpClient) getReplyToLastSender() bool {
+	return conf.ReplyToLastSender
+}
+
 func (conf EndpointUdpClient) init() (Endpoint, error) {
 	return initEndpointClient(conf)
 }
 
+// ReconnectPolicy allows to configure how a client endpoint retries when a
+// connection attempt fails. The connection is established again immediately
+// when an established connection is lost.
+type ReconnectPolicy struct {
+	// (optional) the delay after the first failed attempt.
+	// It defaults to 2s.
+	InitialDelay time.Duration
+
+	// (optional) the maximum delay between attempts.
+	// It defaults to InitialDelay.
+	MaxDelay time.Duration
+
+	// (optional) the factor by which the delay is multiplied after every
+	// failed attempt, i.e. 2 for an exponential backoff.
+	// It defaults to 1, i.e. a fixed delay.
+	Multiplier float64
+
+	// (optional) the maximum number of consecutive failed attempts, after
+	// which the endpoint gives up, its channel is closed and
+	// EventEndpointGiveUp is emitted.
+	// It defaults to zero, i.e. no limit.
+	MaxAttempts int
+}
+
+func (p ReconnectPolicy) fill() (ReconnectPolicy, error) {
+	if p.InitialDelay < 0 || p.MaxDelay < 0 || p.Multiplier < 0 || p.MaxAttempts < 0 {
+		return p, fmt.Errorf("reconnect policy parameters must be >= 0")
+	}
+
+	if p.InitialDelay == 0 {
+		p.InitialDelay = netReconnectPeriod
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = p.InitialDelay
+	}
+	if p.MaxDelay < p.InitialDelay {
+		return p, fmt.Errorf("reconnect policy MaxDelay must be >= InitialDelay")
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = 1
+	}
+	if p.Multiplier < 1 {
+		return p, fmt.Errorf("reconnect policy Multiplier must be >= 1")
+	}
+	return p, nil
+}
+
+// endpointGiveUpError is returned by client endpoints when they give up
+// connecting.
+type endpointGiveUpError struct {
+	attempts int
+	err      error
+}
+
+func (e *endpointGiveUpError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %s", e.attempts, e.err)
+}
+
 type endpointClient struct {
-	conf        endpointClientConf
+	conf        interface{}
+	label       string
+	policy      ReconnectPolicy
+	dial        func() (io.ReadWriteCloser, error)
 	writerMutex sync.Mutex
 	writer      io.Writer
 
+	// set before closing readChan
+	giveUpErr *endpointGiveUpError
+
 	terminate chan struct{}
 	readChan  chan []byte
 	readDone  chan struct{}
@@ -68,8 +265,98 @@ func initEndpointClient(conf endpointClientConf) (Endpoint, error) {
 		return nil, fmt.Errorf("invalid address")
 	}
 
+	t := &netTransport{
+		udp:               conf.isUdp(),
+		control:           conf.getControl(),
+		tlsConfig:         conf.getTLSConfig(),
+		replyToLastSender: conf.getReplyToLastSender(),
+	}
+
+	if conf.getLocalAddress() != "" {
+		t.localAddr, err = net.ResolveUDPAddr(t.network(), conf.getLocalAddress())
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address")
+		}
+	}
+
+	return newEndpointTransport(conf, t, conf.getAddress(), false, conf.getReconnect())
+}
+
+// udpReplyConn is an unconnected UDP socket that receives datagrams from any
+// source, and writes datagrams to the source of the last received datagram.
+type udpReplyConn struct {
+	net.PacketConn
+
+	remoteMutex sync.Mutex
+	remoteAddr  net.Addr
+}
+
+// dialUdpReply resolves the address of a client and binds a udpReplyConn,
+// that writes to the address until a datagram is received.
+func dialUdpReply(address string, control func(string, string, syscall.RawConn) error,
+	localAddr net.Addr) (io.ReadWriteCloser, error) {
+	remoteAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	localAddress := ":0"
+	if localAddr != nil {
+		localAddress = localAddr.String()
+	}
+
+	lc := &net.ListenConfig{
+		Control: control,
+	}
+	packetConn, err := lc.ListenPacket(context.Background(), "udp4", localAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &netTimedConn{conn: &udpReplyConn{
+		PacketConn: packetConn,
+		remoteAddr: remoteAddr,
+	}}, nil
+}
+
+// RemoteAddr returns the address to which datagrams are written.
+func (c *udpReplyConn) RemoteAddr() net.Addr {
+	c.remoteMutex.Lock()
+	defer c.remoteMutex.Unlock()
+	return c.remoteAddr
+}
+
+func (c *udpReplyConn) Read(buf []byte) (int, error) {
+	n, addr, err := c.PacketConn.ReadFrom(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	c.remoteMutex.Lock()
+	c.remoteAddr = addr
+	c.remoteMutex.Unlock()
+
+	return n, nil
+}
+
+func (c *udpReplyConn) Write(buf []byte) (int, error) {
+	return c.PacketConn.WriteTo(buf, c.RemoteAddr())
+}
+
+// newEndpointClient allocates a client that connects with the given function,
+// and reconnects when the connection is lost.
+func newEndpointClient(conf interface{}, label string, policy ReconnectPolicy,
+	dial func() (io.ReadWriteCloser, error)) (Endpoint, error) {
+	policy, err := policy.fill()
+	if err != nil {
+		return nil, err
+	}
+
 	t := &endpointClient{
 		conf:      conf,
+		label:     label,
+		policy:    policy,
+		dial:      dial,
 		terminate: make(chan struct{}),
 		readChan:  make(chan []byte),
 		readDone:  make(chan struct{}),
@@ -88,12 +375,7 @@ func (t *endpointClient) Conf() interface{} {
 }
 
 func (t *endpointClient) Label() string {
-	return fmt.Sprintf("%s:%s", func() string {
-		if t.conf.isUdp() {
-			return "udp"
-		}
-		return "tcp"
-	}(), t.conf.getAddress())
+	return t.label
 }
 
 func (t *endpointClient) Close() error {
@@ -112,26 +394,22 @@ func (t *endpointClient) do() {
 	}()
 
 	buf := make([]byte, bufferSize)
+	attempts := 0
+	delay := t.policy.InitialDelay
 
 	for {
 		// solve address and connect
 		// in UDP, the only possible error is a DNS failure
 		// in TCP, the handshake must be completed
-		var rawConn net.Conn
+		var conn io.ReadWriteCloser
+		var dialErr error
 		dialDone := make(chan struct{})
 		go func() {
 			defer close(dialDone)
 
-			var network string
-			if t.conf.isUdp() == true {
-				network = "udp4"
-			} else {
-				network = "tcp4"
-			}
-			var err error
-			rawConn, err = net.DialTimeout(network, t.conf.getAddress(), netConnectTimeout)
-			if err != nil {
-				rawConn = nil // ensure rawConn is nil in case of error
+			conn, dialErr = t.dial()
+			if dialErr != nil {
+				conn = nil // ensure conn is nil in case of error
 			}
 		}()
 
@@ -141,18 +419,32 @@ func (t *endpointClient) do() {
 			return
 		}
 
-		// wait some seconds before reconnecting
-		if rawConn == nil {
-			timer := time.NewTimer(netReconnectPeriod)
+		if conn == nil {
+			attempts++
+			if t.policy.MaxAttempts > 0 && attempts >= t.policy.MaxAttempts {
+				t.giveUpErr = &endpointGiveUpError{attempts, dialErr}
+				return
+			}
+
+			// wait before reconnecting
+			timer := time.NewTimer(delay)
 			select {
 			case <-timer.C:
-				continue
 			case <-t.terminate:
+				timer.Stop()
 				return
 			}
+
+			delay = time.Duration(float64(delay) * t.policy.Multiplier)
+			if delay > t.policy.MaxDelay {
+				delay = t.policy.MaxDelay
+			}
+			continue
 		}
 
-		conn := &netTimedConn{rawConn}
+		attempts = 0
+		delay = t.policy.InitialDelay
+
 		func() {
 			t.writerMutex.Lock()
 			defer t.writerMutex.Unlock()
@@ -196,6 +488,9 @@ func (t *endpointClient) do() {
 func (t *endpointClient) Read(buf []byte) (int, error) {
 	src, ok := <-t.readChan
 	if !ok {
+		if t.giveUpErr != nil {
+			return 0, t.giveUpErr
+		}
 		return 0, errorTerminated
 	}
 	n := copy(buf, src)
@@ -1,6 +1,7 @@
 package gomavlib
 
 import (
+	"fmt"
 	"io"
 )
 
@@ -33,3 +34,38 @@ func (t *endpointCustom) Conf() interface{} {
 func (t *endpointCustom) Label() string {
 	return "custom"
 }
+
+// EndpointCustomFactory sets up a endpoint that works with custom interfaces
+// created by a function. When an interface returns an error, it is closed and
+// a new one is created, with the same reconnection logic of client endpoints.
+// It allows to use exotic transports, like radio modems driven by proprietary
+// SDKs, with automatic reconnection. It is equivalent to an EndpointTransport
+// whose transport implements TransportDialer, without the need to implement
+// the interface.
+type EndpointCustomFactory struct {
+	// a function that creates an interface implementing Read(), Write()
+	// and Close(). If it returns an error, it is called again according
+	// to Reconnect.
+	Dial func() (io.ReadWriteCloser, error)
+
+	// (optional) the label of the channel.
+	// It defaults to "custom".
+	Label string
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+func (conf EndpointCustomFactory) init() (Endpoint, error) {
+	if conf.Dial == nil {
+		return nil, fmt.Errorf("Dial not provided")
+	}
+
+	label := conf.Label
+	if label == "" {
+		label = "custom"
+	}
+
+	return newEndpointTransport(conf, &funcTransport{label, conf.Dial}, "", false, conf.Reconnect)
+}
@@ -0,0 +1,257 @@
+package gomavlib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aler9/gomavlib/frame"
+)
+
+// UdpFanoutRateClass limits the frequency of the messages sent to a group
+// of subscribers.
+type UdpFanoutRateClass struct {
+	// the subscribers that belong to the class, in IP (192.168.1.5) or
+	// CIDR (192.168.1.0/24) notation.
+	Sources []string
+
+	// the maximum frequency of each message, in Hz. Frames that exceed it
+	// are not sent to the subscribers of the class.
+	MaxFrequency float64
+}
+
+// EndpointUdpFanout sets up a endpoint that sends frames to a large number of
+// UDP subscribers, i.e. viewers of a cloud relay.
+// Unlike EndpointUdpServer, that creates a channel for each remote address,
+// it provides a single channel: remote addresses become subscribers as soon
+// as they send a datagram, and every outgoing frame is sent to all
+// subscribers through a shared socket. On Linux, frames are sent to multiple
+// subscribers with a single system call (sendmmsg).
+// Frames received from subscribers are emitted by the single channel.
+type EndpointUdpFanout struct {
+	// listen address, example: 0.0.0.0:5600
+	Address string
+
+	// (optional) the duration after which subscribers that have not sent
+	// any datagram are removed. It defaults to 10 seconds.
+	SubscriberTimeout time.Duration
+
+	// (optional) rate classes. Subscribers are assigned to the first class
+	// that contains their address. Subscribers that do not belong to any
+	// class receive all frames.
+	RateClasses []UdpFanoutRateClass
+
+	// (optional) the sources that are allowed to subscribe, in IP
+	// (192.168.1.5) or CIDR (192.168.1.0/24) notation.
+	// If empty, all sources are allowed.
+	AllowedSources []string
+
+	// (optional) a function that is called after creating the socket and
+	// before binding it, that allows to set socket options. See net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+type fanoutRateClass struct {
+	filter      func(net.IP) bool
+	minInterval time.Duration
+}
+
+type fanoutSubscriber struct {
+	addr     *net.UDPAddr
+	class    *fanoutRateClass
+	lastSeen time.Time
+
+	// accessed by Write() only
+	lastSent map[uint32]time.Time
+}
+
+type endpointUdpFanout struct {
+	conf       EndpointUdpFanout
+	packetConn net.PacketConn
+	filter     func(net.IP) bool
+	classes    []*fanoutRateClass
+	sender     fanoutSender
+
+	mutex       sync.Mutex
+	subscribers map[string]*fanoutSubscriber
+
+	terminate chan struct{}
+}
+
+func (conf EndpointUdpFanout) init() (Endpoint, error) {
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.SubscriberTimeout == 0 {
+		conf.SubscriberTimeout = 10 * time.Second
+	}
+
+	filter, err := sourceFilter(conf.AllowedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	var classes []*fanoutRateClass
+	for _, rc := range conf.RateClasses {
+		if rc.MaxFrequency <= 0 {
+			return nil, fmt.Errorf("invalid rate class frequency")
+		}
+
+		cf, err := sourceFilter(rc.Sources)
+		if err != nil {
+			return nil, err
+		}
+		if cf == nil {
+			return nil, fmt.Errorf("rate class without sources")
+		}
+
+		classes = append(classes, &fanoutRateClass{
+			filter:      cf,
+			minInterval: time.Duration(float64(time.Second) / rc.MaxFrequency),
+		})
+	}
+
+	lc := &net.ListenConfig{
+		Control: conf.Control,
+	}
+	packetConn, err := lc.ListenPacket(context.Background(), "udp4", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := newFanoutSender(packetConn.(*net.UDPConn))
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+
+	t := &endpointUdpFanout{
+		conf:        conf,
+		packetConn:  packetConn,
+		filter:      filter,
+		classes:     classes,
+		sender:      sender,
+		subscribers: make(map[string]*fanoutSubscriber),
+		terminate:   make(chan struct{}),
+	}
+	return t, nil
+}
+
+func (t *endpointUdpFanout) isEndpoint() {}
+
+func (t *endpointUdpFanout) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointUdpFanout) Label() string {
+	return fmt.Sprintf("udpfanout:%s", t.packetConn.LocalAddr())
+}
+
+func (t *endpointUdpFanout) Close() error {
+	close(t.terminate)
+	t.packetConn.Close()
+	return nil
+}
+
+func (t *endpointUdpFanout) Read(buf []byte) (int, error) {
+	for {
+		// read WITHOUT deadline. Long periods without packets are normal since
+		// subscribers come and go.
+		n, addr, err := t.packetConn.ReadFrom(buf)
+
+		// wait termination, do not report errors
+		if err != nil {
+			<-t.terminate
+			return 0, errorTerminated
+		}
+
+		uaddr := addr.(*net.UDPAddr)
+		if t.filter != nil && !t.filter(uaddr.IP) {
+			continue
+		}
+
+		t.subscribe(uaddr)
+		return n, nil
+	}
+}
+
+func (t *endpointUdpFanout) subscribe(addr *net.UDPAddr) {
+	key := addr.String()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if s, ok := t.subscribers[key]; ok {
+		s.lastSeen = time.Now()
+		return
+	}
+
+	s := &fanoutSubscriber{
+		addr:     addr,
+		lastSeen: time.Now(),
+		lastSent: make(map[uint32]time.Time),
+	}
+	for _, c := range t.classes {
+		if c.filter(addr.IP) {
+			s.class = c
+			break
+		}
+	}
+	t.subscribers[key] = s
+}
+
+// frameMessageId returns the message id of an encoded frame.
+func frameMessageId(buf []byte) (uint32, bool) {
+	switch {
+	case len(buf) >= 6 && buf[0] == frame.V1MagicByte:
+		return uint32(buf[5]), true
+
+	case len(buf) >= 10 && buf[0] == frame.V2MagicByte:
+		return uint32(buf[7]) | uint32(buf[8])<<8 | uint32(buf[9])<<16, true
+	}
+	return 0, false
+}
+
+func (t *endpointUdpFanout) Write(buf []byte) (int, error) {
+	now := time.Now()
+	msgId, hasId := frameMessageId(buf)
+
+	var addrs []*net.UDPAddr
+
+	t.mutex.Lock()
+	for key, s := range t.subscribers {
+		if now.Sub(s.lastSeen) >= t.conf.SubscriberTimeout {
+			delete(t.subscribers, key)
+			continue
+		}
+
+		if s.class != nil && hasId {
+			if last, ok := s.lastSent[msgId]; ok && now.Sub(last) < s.class.minInterval {
+				continue
+			}
+			s.lastSent[msgId] = now
+		}
+
+		addrs = append(addrs, s.addr)
+	}
+	t.mutex.Unlock()
+
+	if len(addrs) == 0 {
+		return len(buf), nil
+	}
+
+	err := t.packetConn.SetWriteDeadline(now.Add(netWriteTimeout))
+	if err != nil {
+		return 0, err
+	}
+
+	// errors related to single subscribers are not reported, since they
+	// must not close the channel
+	t.sender.send(buf, addrs)
+	return len(buf), nil
+}
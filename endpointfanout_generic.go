@@ -0,0 +1,25 @@
+// +build !linux !amd64,!arm64
+
+package gomavlib
+
+import (
+	"net"
+)
+
+type fanoutSender interface {
+	send(buf []byte, addrs []*net.UDPAddr)
+}
+
+type fanoutSenderGeneric struct {
+	conn *net.UDPConn
+}
+
+func newFanoutSender(conn *net.UDPConn) (fanoutSender, error) {
+	return &fanoutSenderGeneric{conn}, nil
+}
+
+func (s *fanoutSenderGeneric) send(buf []byte, addrs []*net.UDPAddr) {
+	for _, addr := range addrs {
+		s.conn.WriteTo(buf, addr)
+	}
+}
@@ -0,0 +1,3 @@
+package gomavlib
+
+const sysSendmmsg = 269
@@ -0,0 +1,124 @@
+// +build linux,amd64 linux,arm64
+
+package gomavlib
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// maximum number of datagrams sent with a single system call
+	fanoutBatchSize = 256
+)
+
+type fanoutSender interface {
+	send(buf []byte, addrs []*net.UDPAddr)
+}
+
+// mmsghdr is struct mmsghdr of sendmmsg(2).
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// fanoutSenderMmsg sends a datagram to multiple addresses with sendmmsg(2).
+type fanoutSenderMmsg struct {
+	rawConn syscall.RawConn
+	msgs    []mmsghdr
+	names   []syscall.RawSockaddrInet4
+}
+
+func newFanoutSender(conn *net.UDPConn) (fanoutSender, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fanoutSenderMmsg{
+		rawConn: rawConn,
+		msgs:    make([]mmsghdr, fanoutBatchSize),
+		names:   make([]syscall.RawSockaddrInet4, fanoutBatchSize),
+	}, nil
+}
+
+func (s *fanoutSenderMmsg) send(buf []byte, addrs []*net.UDPAddr) {
+	if len(buf) == 0 {
+		return
+	}
+
+	iov := syscall.Iovec{Base: &buf[0]}
+	iov.SetLen(len(buf))
+
+	for len(addrs) > 0 {
+		n := len(addrs)
+		if n > fanoutBatchSize {
+			n = fanoutBatchSize
+		}
+
+		for i, addr := range addrs[:n] {
+			name := &s.names[i]
+			name.Family = syscall.AF_INET
+			// port is in network byte order
+			port := (*[2]byte)(unsafe.Pointer(&name.Port))
+			port[0] = byte(addr.Port >> 8)
+			port[1] = byte(addr.Port)
+			copy(name.Addr[:], addr.IP.To4())
+
+			s.msgs[i] = mmsghdr{
+				hdr: syscall.Msghdr{
+					Name:    (*byte)(unsafe.Pointer(name)),
+					Namelen: syscall.SizeofSockaddrInet4,
+					Iov:     &iov,
+					Iovlen:  1,
+				},
+			}
+		}
+
+		if !s.sendBatch(s.msgs[:n]) {
+			break
+		}
+		addrs = addrs[n:]
+	}
+
+	runtime.KeepAlive(buf)
+	runtime.KeepAlive(&iov)
+}
+
+// sendBatch sends a batch of datagrams. It returns false in case of errors
+// related to the socket.
+func (s *fanoutSenderMmsg) sendBatch(msgs []mmsghdr) bool {
+	for len(msgs) > 0 {
+		var sent int
+		var errno syscall.Errno
+
+		err := s.rawConn.Write(func(fd uintptr) bool {
+			r, _, e := syscall.Syscall6(sysSendmmsg, fd,
+				uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), 0, 0, 0)
+			if e == syscall.EAGAIN {
+				// wait until the socket is writable
+				return false
+			}
+			sent, errno = int(r), e
+			return true
+		})
+		if err != nil {
+			return false
+		}
+
+		switch {
+		case errno != 0:
+			// the first datagram failed (i.e. unreachable subscriber), skip it
+			msgs = msgs[1:]
+
+		case sent == 0:
+			return false
+
+		default:
+			msgs = msgs[sent:]
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package gomavlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib/tlog"
+)
+
+// EndpointFileReader sets up a endpoint that replays a .tlog file, i.e. a
+// file recorded by MAVProxy, QGroundControl or EndpointFileWriter, in which
+// each frame is preceded by its timestamp (microseconds since the Unix epoch,
+// big endian). Frames are read with their original timing, and the channel
+// is closed at the end of the file. Frames written to the channel are
+// discarded. It allows to feed recorded flights into a node for testing.
+type EndpointFileReader struct {
+	// the path of the file
+	Path string
+
+	// (optional) the replay speed, i.e. 2 replays the file twice as fast.
+	// It defaults to 1.
+	Speed float64
+}
+
+type endpointFileReader struct {
+	conf      EndpointFileReader
+	f         *os.File
+	tr        *tlog.Reader
+	terminate chan struct{}
+	closeOnce sync.Once
+
+	// accessed by Read() only
+	firstTs uint64
+	start   time.Time
+	started bool
+	pending []byte
+}
+
+func (conf EndpointFileReader) init() (Endpoint, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("Path not provided")
+	}
+
+	if conf.Speed < 0 {
+		return nil, fmt.Errorf("Speed must be >= 0")
+	}
+	if conf.Speed == 0 {
+		conf.Speed = 1
+	}
+
+	f, err := os.Open(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &endpointFileReader{
+		conf:      conf,
+		f:         f,
+		tr:        tlog.NewReader(f),
+		terminate: make(chan struct{}),
+	}
+	return t, nil
+}
+
+func (t *endpointFileReader) isEndpoint() {}
+
+func (t *endpointFileReader) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointFileReader) Label() string {
+	return fmt.Sprintf("file:%s", t.conf.Path)
+}
+
+func (t *endpointFileReader) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.terminate)
+	})
+	return t.f.Close()
+}
+
+func (t *endpointFileReader) Read(buf []byte) (int, error) {
+	if len(t.pending) == 0 {
+		ts, fr, err := t.tr.ReadRecord()
+		if err != nil {
+			return 0, err
+		}
+
+		if !t.started {
+			t.started = true
+			t.firstTs = ts
+			t.start = time.Now()
+		}
+
+		// honor the original timing
+		if ts > t.firstTs {
+			elapsed := time.Duration(float64(ts-t.firstTs) * float64(time.Microsecond) / t.conf.Speed)
+			wait := time.Until(t.start.Add(elapsed))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-t.terminate:
+					timer.Stop()
+					return 0, errorTerminated
+				}
+			}
+		}
+
+		t.pending = fr
+	}
+
+	n := copy(buf, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *endpointFileReader) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// EndpointFileWriter sets up a endpoint that records the frames written to
+// it into a .tlog file, each preceded by its timestamp. It can be used
+// together with WriteFrameAll() or WriteFrameExcept() to record the frames
+// received by the other endpoints of a node.
+type EndpointFileWriter struct {
+	// the path of the file. If it exists, it is overwritten.
+	Path string
+}
+
+type endpointFileWriter struct {
+	conf      EndpointFileWriter
+	f         *os.File
+	terminate chan struct{}
+	closeOnce sync.Once
+
+	// accessed by Write() only
+	recordBuf []byte
+}
+
+func (conf EndpointFileWriter) init() (Endpoint, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("Path not provided")
+	}
+
+	f, err := os.Create(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &endpointFileWriter{
+		conf:      conf,
+		f:         f,
+		terminate: make(chan struct{}),
+	}
+	return t, nil
+}
+
+func (t *endpointFileWriter) isEndpoint() {}
+
+func (t *endpointFileWriter) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointFileWriter) Label() string {
+	return fmt.Sprintf("file:%s", t.conf.Path)
+}
+
+func (t *endpointFileWriter) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.terminate)
+	})
+	return t.f.Close()
+}
+
+func (t *endpointFileWriter) Read(buf []byte) (int, error) {
+	<-t.terminate
+	return 0, errorTerminated
+}
+
+// Write writes a record. Frames are always written whole by the channel.
+func (t *endpointFileWriter) Write(buf []byte) (int, error) {
+	t.recordBuf = t.recordBuf[:0]
+	t.recordBuf = append(t.recordBuf, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(t.recordBuf, uint64(time.Now().UnixNano()/1000))
+	t.recordBuf = append(t.recordBuf, buf...)
+
+	_, err := t.f.Write(t.recordBuf)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
@@ -0,0 +1,536 @@
+package gomavlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib/websocket"
+)
+
+const (
+	// maximum time a poll request waits for data
+	longPollTimeout = 20 * time.Second
+
+	// maximum number of bytes that are queued for a session, or sent with a
+	// single request
+	longPollMaxSize = 64 * 1024
+)
+
+// EndpointHttpServer sets up a endpoint that works with a HTTP server, that
+// accepts both WebSocket connections and long-polling sessions. Long polling
+// allows to communicate through networks where only HTTP, possibly through
+// a proxy, is available.
+// Multiple clients can be connected at once, each with its own channel.
+type EndpointHttpServer struct {
+	// listen address, example: 0.0.0.0:8080
+	Address string
+
+	// (optional) the path on which connections are accepted.
+	// It defaults to "/".
+	Path string
+
+	// (optional) the TLS configuration. If provided, connections are
+	// encrypted (https:// and wss://).
+	TLSConfig *tls.Config
+
+	// (optional) the origins, other than the one of the server, of the web
+	// pages that are allowed to open WebSocket connections, example:
+	// https://example.com. "*" allows any origin. By default, WebSocket
+	// connections opened by browsers from other origins are rejected.
+	AllowedOrigins []string
+}
+
+type endpointHttpServer struct {
+	conf      EndpointHttpServer
+	server    *http.Server
+	conns     chan endpointHttpConn
+	terminate chan struct{}
+
+	sessionsMutex sync.Mutex
+	sessions      map[string]*longPollSession
+}
+
+type endpointHttpConn struct {
+	label string
+	rwc   io.ReadWriteCloser
+}
+
+func (conf EndpointHttpServer) init() (Endpoint, error) {
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+
+	listener, err := net.Listen("tcp4", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.TLSConfig != nil {
+		listener = tls.NewListener(listener, conf.TLSConfig)
+	}
+
+	t := &endpointHttpServer{
+		conf:      conf,
+		conns:     make(chan endpointHttpConn),
+		terminate: make(chan struct{}),
+		sessions:  make(map[string]*longPollSession),
+	}
+
+	t.server = &http.Server{
+		Handler:           http.HandlerFunc(t.onRequest),
+		ReadHeaderTimeout: netConnectTimeout,
+	}
+
+	go t.server.Serve(listener)
+	go t.runCleaner()
+
+	return t, nil
+}
+
+func (t *endpointHttpServer) isEndpoint() {}
+
+func (t *endpointHttpServer) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointHttpServer) Close() error {
+	close(t.terminate)
+	t.server.Close()
+	return nil
+}
+
+func (t *endpointHttpServer) Accept() (string, io.ReadWriteCloser, error) {
+	select {
+	case conn := <-t.conns:
+		return conn.label, conn.rwc, nil
+
+	case <-t.terminate:
+		return "", nil, errorTerminated
+	}
+}
+
+// runCleaner closes sessions whose client has stopped polling.
+func (t *endpointHttpServer) runCleaner() {
+	ticker := time.NewTicker(netReadTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			var expired []*longPollSession
+			t.sessionsMutex.Lock()
+			for _, s := range t.sessions {
+				if s.expired() {
+					expired = append(expired, s)
+				}
+			}
+			t.sessionsMutex.Unlock()
+
+			for _, s := range expired {
+				s.Close()
+			}
+
+		case <-t.terminate:
+			return
+		}
+	}
+}
+
+func (t *endpointHttpServer) deliver(conn endpointHttpConn) bool {
+	select {
+	case t.conns <- conn:
+		return true
+	case <-t.terminate:
+		conn.rwc.Close()
+		return false
+	}
+}
+
+func (t *endpointHttpServer) onRequest(w http.ResponseWriter, r *http.Request) {
+	base := strings.TrimSuffix(t.conf.Path, "/")
+
+	// WebSocket connection or session creation
+	if r.URL.Path == t.conf.Path || r.URL.Path == base {
+		if r.Header.Get("Upgrade") != "" {
+			conn, err := websocket.Upgrade(w, r, websocketCheckOrigin(t.conf.AllowedOrigins))
+			if err != nil {
+				return
+			}
+			t.deliver(endpointHttpConn{fmt.Sprintf("ws:%s", conn.RemoteAddr()), &netTimedConn{conn: conn}})
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s := t.newSession()
+		if !t.deliver(endpointHttpConn{fmt.Sprintf("http:%s", r.RemoteAddr), s}) {
+			http.Error(w, "terminated", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(s.id))
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, base+"/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.sessionsMutex.Lock()
+	s, ok := t.sessions[r.URL.Path[len(base)+1:]]
+	t.sessionsMutex.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.onPoll(w, r)
+
+	case http.MethodPost:
+		s.onSend(w, r)
+
+	case http.MethodDelete:
+		s.Close()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (t *endpointHttpServer) newSession() *longPollSession {
+	var rnd [16]byte
+	rand.Read(rnd[:])
+
+	s := &longPollSession{
+		id:        hex.EncodeToString(rnd[:]),
+		outSignal: make(chan struct{}, 1),
+		in:        make(chan []byte),
+		lastPoll:  time.Now(),
+		terminate: make(chan struct{}),
+	}
+
+	s.onClose = func() {
+		t.sessionsMutex.Lock()
+		delete(t.sessions, s.id)
+		t.sessionsMutex.Unlock()
+	}
+
+	t.sessionsMutex.Lock()
+	t.sessions[s.id] = s
+	t.sessionsMutex.Unlock()
+
+	return s
+}
+
+// longPollSession is the server side of a long-polling session.
+type longPollSession struct {
+	id        string
+	outSignal chan struct{}
+	in        chan []byte
+	onClose   func()
+	terminate chan struct{}
+	closeOnce sync.Once
+
+	mutex    sync.Mutex
+	out      []byte
+	polling  bool
+	lastPoll time.Time
+
+	// accessed by Read() only
+	pending []byte
+}
+
+func (s *longPollSession) expired() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return !s.polling && time.Since(s.lastPoll) >= netReadTimeout
+}
+
+func (s *longPollSession) onPoll(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.polling = true
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.polling = false
+		s.lastPoll = time.Now()
+		s.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(longPollTimeout)
+	defer timer.Stop()
+
+	for {
+		s.mutex.Lock()
+		out := s.out
+		s.out = nil
+		s.mutex.Unlock()
+
+		if len(out) > 0 {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(out)
+			return
+		}
+
+		select {
+		case <-s.outSignal:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.terminate:
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+	}
+}
+
+func (s *longPollSession) onSend(w http.ResponseWriter, r *http.Request) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r.Body, longPollMaxSize))
+	if err != nil {
+		return
+	}
+
+	if len(buf) > 0 {
+		select {
+		case s.in <- buf:
+		case <-r.Context().Done():
+			return
+		case <-s.terminate:
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *longPollSession) Read(buf []byte) (int, error) {
+	if len(s.pending) == 0 {
+		select {
+		case s.pending = <-s.in:
+		case <-s.terminate:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(buf, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *longPollSession) Write(buf []byte) (int, error) {
+	s.mutex.Lock()
+	// discard frames when the client is too slow, like with UDP
+	if len(s.out)+len(buf) <= longPollMaxSize {
+		s.out = append(s.out, buf...)
+	}
+	s.mutex.Unlock()
+
+	select {
+	case s.outSignal <- struct{}{}:
+	default:
+	}
+
+	return len(buf), nil
+}
+
+func (s *longPollSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.terminate)
+		s.onClose()
+	})
+	return nil
+}
+
+// EndpointHttpClient sets up a endpoint that works with a HTTP client,
+// connected to a EndpointHttpServer. The client connects with WebSocket when
+// possible, otherwise falls back to long polling, that works through HTTP
+// proxies (configured with the HTTP_PROXY and HTTPS_PROXY environment
+// variables). WebSocket is attempted again on every reconnection.
+// The client reconnects automatically when the connection is lost.
+type EndpointHttpClient struct {
+	// URL of the server to connect to, example: http://1.2.3.4:8080/mavlink
+	// or https://example.com/mavlink
+	Address string
+
+	// (optional) the TLS configuration used with https:// URLs.
+	TLSConfig *tls.Config
+
+	// (optional) disables WebSocket, i.e. when it is known to be blocked.
+	WebsocketDisable bool
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+func (conf EndpointHttpClient) init() (Endpoint, error) {
+	u, err := url.Parse(conf.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	wsu := *u
+	wsu.Scheme = "ws"
+	if u.Scheme == "https" {
+		wsu.Scheme = "wss"
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: conf.TLSConfig,
+		},
+	}
+
+	dial := func() (io.ReadWriteCloser, error) {
+		if !conf.WebsocketDisable {
+			conn, err := websocketDial(&wsu, conf.TLSConfig)
+			if err == nil {
+				return conn, nil
+			}
+		}
+
+		return newLongPollClient(client, strings.TrimSuffix(u.String(), "/"))
+	}
+
+	return newEndpointTransport(conf, &funcTransport{"http", dial}, u.Host, false, conf.Reconnect)
+}
+
+// longPollClient is the client side of a long-polling session.
+type longPollClient struct {
+	client *http.Client
+	url    string
+	ctx    context.Context
+	cancel func()
+
+	// accessed by Read() only
+	pending []byte
+}
+
+func newLongPollClient(client *http.Client, base string) (*longPollClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), netConnectTimeout)
+	defer cancel()
+
+	req, _ := http.NewRequest(http.MethodPost, base, nil)
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	id, err := ioutil.ReadAll(io.LimitReader(res.Body, 64))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &longPollClient{
+		client: client,
+		url:    base + "/" + string(id),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+func (c *longPollClient) Read(buf []byte) (int, error) {
+	for len(c.pending) == 0 {
+		err := c.poll()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(buf, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *longPollClient) poll() error {
+	ctx, cancel := context.WithTimeout(c.ctx, longPollTimeout+netReadTimeout)
+	defer cancel()
+
+	req, _ := http.NewRequest(http.MethodGet, c.url, nil)
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return nil
+
+	case http.StatusOK:
+		c.pending, err = ioutil.ReadAll(io.LimitReader(res.Body, longPollMaxSize))
+		return err
+	}
+
+	return fmt.Errorf("bad status code: %d", res.StatusCode)
+}
+
+func (c *longPollClient) Write(buf []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, netWriteTimeout)
+	defer cancel()
+
+	req, _ := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(buf))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return len(buf), nil
+}
+
+func (c *longPollClient) Close() error {
+	c.cancel()
+
+	// notify the server, without waiting too much
+	ctx, cancel := context.WithTimeout(context.Background(), netWriteTimeout)
+	defer cancel()
+
+	req, _ := http.NewRequest(http.MethodDelete, c.url, nil)
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err == nil {
+		res.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package gomavlib
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum number of packets waiting to be delivered, in each direction
+	impairedQueueSize = 1024
+)
+
+// LinkImpairment describes the degradation applied to one direction of a link.
+// Packets are the chunks of bytes written to or read from the endpoint, that
+// correspond to frames when writing, and to datagrams when reading from
+// UDP endpoints.
+type LinkImpairment struct {
+	// (optional) the delay added to each packet.
+	Delay time.Duration
+
+	// (optional) a random variation of the delay, between -Jitter and +Jitter.
+	// Jitter does not reorder packets.
+	Jitter time.Duration
+
+	// (optional) the probability that a packet is lost, between 0 and 1.
+	Loss float64
+
+	// (optional) the probability that a packet is sent without delay, between
+	// 0 and 1, therefore overtaking the previous packets. It requires Delay.
+	Reorder float64
+
+	// (optional) the bandwidth of the link, in bytes per second.
+	// Packets are queued until the link is free.
+	Bandwidth int
+}
+
+func (i LinkImpairment) check() error {
+	if i.Delay < 0 || i.Jitter < 0 {
+		return fmt.Errorf("Delay and Jitter must be >= 0")
+	}
+	if i.Loss < 0 || i.Loss > 1 {
+		return fmt.Errorf("Loss must be between 0 and 1")
+	}
+	if i.Reorder < 0 || i.Reorder > 1 {
+		return fmt.Errorf("Reorder must be between 0 and 1")
+	}
+	if i.Bandwidth < 0 {
+		return fmt.Errorf("Bandwidth must be >= 0")
+	}
+	return nil
+}
+
+// EndpointImpaired sets up a endpoint that wraps another endpoint and
+// introduces delay, jitter, loss, reordering and bandwidth caps, in order to
+// test how applications behave on degraded links.
+type EndpointImpaired struct {
+	// the endpoint to wrap.
+	Endpoint EndpointConf
+
+	// the impairment of bytes read from the endpoint.
+	In LinkImpairment
+
+	// the impairment of bytes written to the endpoint.
+	// Bytes that are still delayed when the endpoint is closed are discarded.
+	Out LinkImpairment
+
+	// (optional) the seed of the random number generator, that allows to
+	// repeat tests. If zero, a random seed is used.
+	Seed int64
+}
+
+func (conf EndpointImpaired) unwrap() EndpointConf {
+	return conf.Endpoint
+}
+
+func (conf EndpointImpaired) init() (Endpoint, error) {
+	if conf.Endpoint == nil {
+		return nil, fmt.Errorf("Endpoint not provided")
+	}
+
+	err := conf.In.check()
+	if err != nil {
+		return nil, err
+	}
+
+	err = conf.Out.check()
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Seed == 0 {
+		conf.Seed = time.Now().UnixNano()
+	}
+
+	inner, err := conf.Endpoint.init()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tinner := inner.(type) {
+	case endpointChannelSingle:
+		return &endpointImpairedSingle{
+			conf:         conf,
+			label:        tinner.Label(),
+			impairedConn: newImpairedConn(tinner, conf, conf.Seed),
+		}, nil
+
+	case endpointChannelAccepter:
+		return &endpointImpairedAccepter{
+			conf:  conf,
+			inner: tinner,
+			seed:  conf.Seed,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("endpoint %T can't be impaired", inner)
+}
+
+type endpointImpairedSingle struct {
+	conf  EndpointImpaired
+	label string
+	*impairedConn
+}
+
+func (t *endpointImpairedSingle) isEndpoint() {}
+
+func (t *endpointImpairedSingle) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointImpairedSingle) Label() string {
+	return t.label
+}
+
+type endpointImpairedAccepter struct {
+	conf  EndpointImpaired
+	inner endpointChannelAccepter
+
+	// accessed by Accept() only
+	seed int64
+}
+
+func (t *endpointImpairedAccepter) isEndpoint() {}
+
+func (t *endpointImpairedAccepter) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointImpairedAccepter) Close() error {
+	return t.inner.Close()
+}
+
+func (t *endpointImpairedAccepter) Accept() (string, io.ReadWriteCloser, error) {
+	label, rwc, err := t.inner.Accept()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// each connection uses different random numbers
+	t.seed += 2
+	return label, newImpairedConn(rwc, t.conf, t.seed), nil
+}
+
+// impairedConn applies impairments to the bytes read from and written to
+// a ReadWriteCloser.
+type impairedConn struct {
+	rwc       io.ReadWriteCloser
+	in        *impairedPipe
+	out       *impairedPipe
+	readc     chan []byte
+	terminate chan struct{}
+	closeOnce sync.Once
+
+	// written by the reader routine before readc is closed
+	readErr error
+
+	// accessed by Read() only
+	pending []byte
+}
+
+func newImpairedConn(rwc io.ReadWriteCloser, conf EndpointImpaired, seed int64) *impairedConn {
+	c := &impairedConn{
+		rwc:       rwc,
+		readc:     make(chan []byte),
+		terminate: make(chan struct{}),
+	}
+
+	c.out = newImpairedPipe(conf.Out, seed, func(buf []byte) {
+		// errors are ignored, like in the channel writer
+		rwc.Write(buf)
+	})
+
+	c.in = newImpairedPipe(conf.In, seed+1, func(buf []byte) {
+		select {
+		case c.readc <- buf:
+		case <-c.terminate:
+		}
+	})
+
+	go func() {
+		buf := make([]byte, bufferSize)
+		for {
+			n, err := rwc.Read(buf)
+			if err != nil {
+				c.readErr = err
+				c.in.close(false)
+				return
+			}
+			c.in.push(buf[:n])
+		}
+	}()
+
+	go func() {
+		<-c.in.done
+		close(c.readc)
+	}()
+
+	return c
+}
+
+func (c *impairedConn) Read(buf []byte) (int, error) {
+	if len(c.pending) == 0 {
+		b, ok := <-c.readc
+		if !ok {
+			return 0, c.readErr
+		}
+		c.pending = b
+	}
+
+	n := copy(buf, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *impairedConn) Write(buf []byte) (int, error) {
+	c.out.push(buf)
+	return len(buf), nil
+}
+
+func (c *impairedConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.terminate)
+		c.out.close(true)
+		<-c.out.done
+		err = c.rwc.Close()
+	})
+	return err
+}
+
+type impairedPacket struct {
+	buf []byte
+	at  time.Time
+	seq uint64
+}
+
+// impairedPackets is a heap of packets, sorted by delivery time.
+type impairedPackets []*impairedPacket
+
+func (h impairedPackets) Len() int {
+	return len(h)
+}
+
+func (h impairedPackets) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h impairedPackets) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *impairedPackets) Push(x interface{}) {
+	*h = append(*h, x.(*impairedPacket))
+}
+
+func (h *impairedPackets) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// impairedPipe applies an impairment to packets and delivers them, in order
+// of delivery time, with a dedicated routine.
+type impairedPipe struct {
+	imp     LinkImpairment
+	deliver func([]byte)
+	wake    chan struct{}
+	done    chan struct{}
+
+	mutex    sync.Mutex
+	rnd      *rand.Rand
+	queue    impairedPackets
+	seq      uint64
+	linkFree time.Time
+	lastAt   time.Time
+	closed   bool
+}
+
+func newImpairedPipe(imp LinkImpairment, seed int64, deliver func([]byte)) *impairedPipe {
+	p := &impairedPipe{
+		imp:     imp,
+		deliver: deliver,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		rnd:     rand.New(rand.NewSource(seed)),
+	}
+	go p.run()
+	return p
+}
+
+func (p *impairedPipe) push(buf []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed || len(p.queue) >= impairedQueueSize {
+		return
+	}
+
+	if p.imp.Loss > 0 && p.rnd.Float64() < p.imp.Loss {
+		return
+	}
+
+	now := time.Now()
+
+	// packets are transmitted one after the other
+	at := now
+	if p.imp.Bandwidth > 0 {
+		if p.linkFree.After(at) {
+			at = p.linkFree
+		}
+		at = at.Add(time.Duration(len(buf)) * time.Second / time.Duration(p.imp.Bandwidth))
+		p.linkFree = at
+	}
+
+	if p.imp.Reorder == 0 || p.rnd.Float64() >= p.imp.Reorder {
+		at = at.Add(p.imp.Delay)
+		if p.imp.Jitter > 0 {
+			at = at.Add(time.Duration(p.rnd.Int63n(int64(2*p.imp.Jitter+1))) - p.imp.Jitter)
+		}
+
+		// jitter does not reorder packets
+		if at.Before(p.lastAt) {
+			at = p.lastAt
+		}
+		p.lastAt = at
+	}
+
+	heap.Push(&p.queue, &impairedPacket{
+		buf: append([]byte(nil), buf...),
+		at:  at,
+		seq: p.seq,
+	})
+	p.seq++
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the pipe. Pending packets are delivered, unless discard is
+// true; in that case, only packets whose delivery time has passed are delivered.
+func (p *impairedPipe) close(discard bool) {
+	p.mutex.Lock()
+	p.closed = true
+	if discard {
+		now := time.Now()
+		var due impairedPackets
+		for _, pkt := range p.queue {
+			if !pkt.at.After(now) {
+				due = append(due, pkt)
+			}
+		}
+		heap.Init(&due)
+		p.queue = due
+	}
+	p.mutex.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *impairedPipe) run() {
+	defer close(p.done)
+
+	for {
+		p.mutex.Lock()
+
+		if len(p.queue) == 0 {
+			closed := p.closed
+			p.mutex.Unlock()
+
+			if closed {
+				return
+			}
+			<-p.wake
+			continue
+		}
+
+		wait := time.Until(p.queue[0].at)
+		if wait <= 0 {
+			pkt := heap.Pop(&p.queue).(*impairedPacket)
+			p.mutex.Unlock()
+
+			p.deliver(pkt.buf)
+			continue
+		}
+
+		p.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-p.wake:
+			timer.Stop()
+		}
+	}
+}
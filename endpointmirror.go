@@ -0,0 +1,100 @@
+package gomavlib
+
+import (
+	"fmt"
+	"net"
+)
+
+// EndpointMirror sets up a endpoint that wraps another endpoint and makes it
+// read-only: frames and messages are written to it as usual, in order to
+// monitor the network, but frames received from it are discarded, and are
+// counted in the DroppedFrames field of Channel.TrafficStats. It prevents a
+// misconfigured observer from injecting commands into the network.
+// It can be wrapped by other endpoints, like EndpointTap or EndpointImpaired,
+// and channels are still read-only. It can't be combined with EndpointSigned
+// at any level.
+type EndpointMirror struct {
+	// the endpoint to wrap.
+	Endpoint EndpointConf
+}
+
+// endpointIsMirror returns whether the channels of an endpoint discard
+// received frames, that happens when an EndpointMirror is present at any
+// level of the chain of wrapped endpoints.
+func endpointIsMirror(e Endpoint) bool {
+	conf, ok := e.Conf().(EndpointConf)
+	if !ok {
+		return false
+	}
+
+	for _, c := range endpointChain(conf) {
+		if _, ok := c.(EndpointMirror); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (conf EndpointMirror) unwrap() EndpointConf {
+	return conf.Endpoint
+}
+
+func (conf EndpointMirror) init() (Endpoint, error) {
+	if conf.Endpoint == nil {
+		return nil, fmt.Errorf("Endpoint not provided")
+	}
+
+	for _, c := range endpointChain(conf.Endpoint) {
+		if _, ok := c.(EndpointSigned); ok {
+			return nil, fmt.Errorf("signed endpoints can't be mirrored")
+		}
+	}
+
+	inner, err := conf.Endpoint.init()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tinner := inner.(type) {
+	case endpointChannelSingle:
+		return &endpointMirrorSingle{
+			conf:                  conf,
+			endpointChannelSingle: tinner,
+		}, nil
+
+	case endpointChannelAccepter:
+		return &endpointMirrorAccepter{
+			conf:                    conf,
+			endpointChannelAccepter: tinner,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("endpoint %T can't be mirrored", inner)
+}
+
+type endpointMirrorSingle struct {
+	conf EndpointMirror
+	endpointChannelSingle
+}
+
+func (t *endpointMirrorSingle) Conf() interface{} {
+	return t.conf
+}
+
+// RemoteAddr returns the address of the remote peer of the wrapped
+// endpoint, if available.
+func (t *endpointMirrorSingle) RemoteAddr() net.Addr {
+	if ra, ok := t.endpointChannelSingle.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+type endpointMirrorAccepter struct {
+	conf EndpointMirror
+	endpointChannelAccepter
+}
+
+func (t *endpointMirrorAccepter) Conf() interface{} {
+	return t.conf
+}
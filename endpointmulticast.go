@@ -0,0 +1,196 @@
+package gomavlib
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// EndpointUdpMulticast sets up a endpoint that works with UDP multicast
+// packets, like the "mcast:" transport of pymavlink. It allows multiple
+// processes to share frames without a router.
+// Frames are sent to the group from a dedicated socket, and frames sent
+// by the endpoint itself are not received back.
+type EndpointUdpMulticast struct {
+	// the multicast group address, example: 239.255.145.50:14550
+	Address string
+
+	// (optional) the name of the network interface used to join the group
+	// and to send frames, example: eth0. If empty, the system default is used.
+	Interface string
+
+	// (optional) the sources from which frames are accepted, in IP
+	// (192.168.1.5) or CIDR (192.168.1.0/24) notation. Datagrams coming from
+	// other sources are discarded before being parsed.
+	// If empty, all sources are accepted.
+	AllowedSources []string
+}
+
+type endpointUdpMulticast struct {
+	conf       EndpointUdpMulticast
+	groupAddr  *net.UDPAddr
+	listenConn *net.UDPConn
+	sendConn   *net.UDPConn
+	sendPort   int
+	localIps   []net.IP
+	filter     func(net.IP) bool
+
+	remoteMutex sync.Mutex
+	remoteAddr  net.Addr
+
+	terminate chan struct{}
+}
+
+func (conf EndpointUdpMulticast) init() (Endpoint, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+	if !groupAddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("address is not a multicast address")
+	}
+
+	filter, err := sourceFilter(conf.AllowedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	var intf *net.Interface
+	sendIp := net.IPv4zero
+	if conf.Interface != "" {
+		intf, err = net.InterfaceByName(conf.Interface)
+		if err != nil {
+			return nil, err
+		}
+
+		sendIp = interfaceIp(intf)
+		if sendIp == nil {
+			return nil, fmt.Errorf("interface %s has no IPv4 address", conf.Interface)
+		}
+	}
+
+	// the listening socket is shared with other processes
+	listenConn, err := net.ListenMulticastUDP("udp4", intf, groupAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sending socket has its own port, that allows to recognize
+	// the frames sent by this endpoint. Binding it to the address of the
+	// interface selects the interface of outgoing frames.
+	sendConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: sendIp})
+	if err != nil {
+		listenConn.Close()
+		return nil, err
+	}
+
+	var localIps []net.IP
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipn, ok := addr.(*net.IPNet); ok {
+				localIps = append(localIps, ipn.IP)
+			}
+		}
+	}
+
+	t := &endpointUdpMulticast{
+		conf:       conf,
+		groupAddr:  groupAddr,
+		listenConn: listenConn,
+		sendConn:   sendConn,
+		sendPort:   sendConn.LocalAddr().(*net.UDPAddr).Port,
+		localIps:   localIps,
+		filter:     filter,
+		terminate:  make(chan struct{}),
+	}
+	return t, nil
+}
+
+// interfaceIp returns the first IPv4 address of an interface.
+func interfaceIp(intf *net.Interface) net.IP {
+	addrs, err := intf.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipn, ok := addr.(*net.IPNet); ok {
+			if ip := ipn.IP.To4(); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+func (t *endpointUdpMulticast) isEndpoint() {}
+
+func (t *endpointUdpMulticast) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointUdpMulticast) Label() string {
+	return fmt.Sprintf("mcast:%s", t.groupAddr)
+}
+
+func (t *endpointUdpMulticast) Close() error {
+	close(t.terminate)
+	t.listenConn.Close()
+	t.sendConn.Close()
+	return nil
+}
+
+// RemoteAddr returns the address of the sender of the last received frame.
+func (t *endpointUdpMulticast) RemoteAddr() net.Addr {
+	t.remoteMutex.Lock()
+	defer t.remoteMutex.Unlock()
+	return t.remoteAddr
+}
+
+func (t *endpointUdpMulticast) isOwn(addr *net.UDPAddr) bool {
+	if addr.Port != t.sendPort {
+		return false
+	}
+	for _, ip := range t.localIps {
+		if ip.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *endpointUdpMulticast) Read(buf []byte) (int, error) {
+	for {
+		// read WITHOUT deadline. Long periods without packets are normal since
+		// we're not directly connected to someone.
+		n, addr, err := t.listenConn.ReadFromUDP(buf)
+
+		// wait termination, do not report errors
+		if err != nil {
+			<-t.terminate
+			return 0, errorTerminated
+		}
+
+		if t.isOwn(addr) {
+			continue
+		}
+
+		if t.filter != nil && !t.filter(addr.IP) {
+			continue
+		}
+
+		t.remoteMutex.Lock()
+		t.remoteAddr = addr
+		t.remoteMutex.Unlock()
+
+		return n, nil
+	}
+}
+
+func (t *endpointUdpMulticast) Write(buf []byte) (int, error) {
+	err := t.sendConn.SetWriteDeadline(time.Now().Add(netWriteTimeout))
+	if err != nil {
+		return 0, err
+	}
+	return t.sendConn.WriteTo(buf, t.groupAddr)
+}
@@ -0,0 +1,94 @@
+package gomavlib
+
+import (
+	"fmt"
+	"io"
+)
+
+// EndpointPipeServer sets up a endpoint that works with a local pipe server.
+// On Windows, it uses a named pipe. On other systems, it uses a Unix socket,
+// that provides the same functionalities. Pipes allow to communicate with
+// simulators and local GCS components without opening network ports.
+type EndpointPipeServer struct {
+	// on Windows, the named pipe path, example: \\.\pipe\mavlink
+	// on other systems, the Unix socket path, example: /tmp/mavlink.sock
+	Address string
+}
+
+// EndpointPipeClient sets up a endpoint that works with a local pipe client.
+// On Windows, it uses a named pipe. On other systems, it uses a Unix socket.
+type EndpointPipeClient struct {
+	// on Windows, the named pipe path, example: \\.\pipe\mavlink
+	// on other systems, the Unix socket path, example: /tmp/mavlink.sock
+	Address string
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+// pipeListener is implemented by the platform-specific listeners.
+type pipeListener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+type endpointPipeServer struct {
+	conf      EndpointPipeServer
+	listener  pipeListener
+	terminate chan struct{}
+}
+
+func (conf EndpointPipeServer) init() (Endpoint, error) {
+	if conf.Address == "" {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	listener, err := pipeListen(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &endpointPipeServer{
+		conf:      conf,
+		listener:  listener,
+		terminate: make(chan struct{}),
+	}
+	return t, nil
+}
+
+func (t *endpointPipeServer) isEndpoint() {}
+
+func (t *endpointPipeServer) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointPipeServer) Close() error {
+	close(t.terminate)
+	t.listener.Close()
+	return nil
+}
+
+func (t *endpointPipeServer) Accept() (string, io.ReadWriteCloser, error) {
+	conn, err := t.listener.Accept()
+
+	// wait termination, do not report errors
+	if err != nil {
+		<-t.terminate
+		return "", nil, errorTerminated
+	}
+
+	return "pipe:" + t.conf.Address, conn, nil
+}
+
+func (conf EndpointPipeClient) init() (Endpoint, error) {
+	if conf.Address == "" {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	dial := func() (io.ReadWriteCloser, error) {
+		return pipeDial(conf.Address)
+	}
+
+	return newEndpointTransport(conf, &funcTransport{"pipe", dial}, conf.Address, false, conf.Reconnect)
+}
@@ -0,0 +1,36 @@
+// +build !windows
+
+package gomavlib
+
+import (
+	"io"
+	"net"
+)
+
+type pipeUnixListener struct {
+	net.Listener
+}
+
+func (l *pipeUnixListener) Accept() (io.ReadWriteCloser, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &netTimedConn{conn: conn}, nil
+}
+
+func pipeListen(address string) (pipeListener, error) {
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeUnixListener{listener}, nil
+}
+
+func pipeDial(address string) (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("unix", address, netConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &netTimedConn{conn: conn}, nil
+}
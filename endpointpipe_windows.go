@@ -0,0 +1,277 @@
+// +build windows
+
+package gomavlib
+
+import (
+	"io"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex        = 0x00000003
+	pipeFirstPipeInstance   = 0x00080000
+	pipeTypeByte            = 0x00000000
+	pipeRejectRemoteClients = 0x00000008
+	pipeUnlimitedInstances  = 255
+
+	errorPipeConnected = syscall.Errno(535)
+)
+
+func pipeCreate(address string, first bool) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(address)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+
+	mode := uint32(pipeAccessDuplex | syscall.FILE_FLAG_OVERLAPPED)
+	if first {
+		// fail if the pipe already exists
+		mode |= pipeFirstPipeInstance
+	}
+
+	r, _, e := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		uintptr(mode),
+		uintptr(pipeTypeByte|pipeRejectRemoteClients),
+		uintptr(pipeUnlimitedInstances),
+		uintptr(bufferSize),
+		uintptr(bufferSize),
+		0,
+		0)
+	h := syscall.Handle(r)
+	if h == syscall.InvalidHandle {
+		return h, e
+	}
+	return h, nil
+}
+
+func pipeCreateEvent() (syscall.Handle, error) {
+	// manual reset, initially not signaled
+	r, _, e := procCreateEventW.Call(0, 1, 0, 0)
+	if r == 0 {
+		return 0, e
+	}
+	return syscall.Handle(r), nil
+}
+
+// pipeWait waits for the completion of an overlapped operation.
+func pipeWait(h syscall.Handle, ov *syscall.Overlapped, err error) (int, error) {
+	if err != nil && err != syscall.ERROR_IO_PENDING {
+		return 0, err
+	}
+
+	var n uint32
+	r, _, e := procGetOverlappedResult.Call(
+		uintptr(h),
+		uintptr(unsafe.Pointer(ov)),
+		uintptr(unsafe.Pointer(&n)),
+		1)
+	if r == 0 {
+		return int(n), e
+	}
+	return int(n), nil
+}
+
+// pipeWindowsConn is a named pipe connection that uses overlapped I/O,
+// in order to allow Close() to interrupt pending reads and writes.
+type pipeWindowsConn struct {
+	h          syscall.Handle
+	readEvent  syscall.Handle
+	writeEvent syscall.Handle
+
+	mutex  sync.Mutex
+	closed bool
+	ops    sync.WaitGroup
+}
+
+func newPipeWindowsConn(h syscall.Handle) (*pipeWindowsConn, error) {
+	readEvent, err := pipeCreateEvent()
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+
+	writeEvent, err := pipeCreateEvent()
+	if err != nil {
+		syscall.CloseHandle(readEvent)
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+
+	return &pipeWindowsConn{
+		h:          h,
+		readEvent:  readEvent,
+		writeEvent: writeEvent,
+	}, nil
+}
+
+func (c *pipeWindowsConn) begin() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.ops.Add(1)
+	return true
+}
+
+func (c *pipeWindowsConn) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mutex.Unlock()
+
+	// interrupt pending operations
+	syscall.CancelIoEx(c.h, nil)
+	syscall.CloseHandle(c.h)
+
+	c.ops.Wait()
+	syscall.CloseHandle(c.readEvent)
+	syscall.CloseHandle(c.writeEvent)
+	return nil
+}
+
+func (c *pipeWindowsConn) Read(buf []byte) (int, error) {
+	if !c.begin() {
+		return 0, io.EOF
+	}
+	defer c.ops.Done()
+
+	ov := &syscall.Overlapped{HEvent: c.readEvent}
+	n, err := pipeWait(c.h, ov, syscall.ReadFile(c.h, buf, nil, ov))
+	if err == syscall.ERROR_BROKEN_PIPE {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (c *pipeWindowsConn) Write(buf []byte) (int, error) {
+	if !c.begin() {
+		return 0, io.ErrClosedPipe
+	}
+	defer c.ops.Done()
+
+	ov := &syscall.Overlapped{HEvent: c.writeEvent}
+	return pipeWait(c.h, ov, syscall.WriteFile(c.h, buf, nil, ov))
+}
+
+type pipeWindowsListener struct {
+	address string
+
+	mutex  sync.Mutex
+	next   syscall.Handle
+	closed bool
+}
+
+func pipeListen(address string) (pipeListener, error) {
+	// create the first instance immediately, in order to report errors
+	// and to allow clients to connect before Accept() is called
+	h, err := pipeCreate(address, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeWindowsListener{
+		address: address,
+		next:    h,
+	}, nil
+}
+
+func (l *pipeWindowsListener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	// interrupt a pending Accept()
+	syscall.CancelIoEx(l.next, nil)
+	syscall.CloseHandle(l.next)
+	return nil
+}
+
+func (l *pipeWindowsListener) Accept() (io.ReadWriteCloser, error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, errorTerminated
+	}
+	h := l.next
+	l.mutex.Unlock()
+
+	event, err := pipeCreateEvent()
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(event)
+
+	ov := &syscall.Overlapped{HEvent: event}
+	r, _, e := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+	if r == 0 {
+		err = e
+	}
+
+	// the client connected before ConnectNamedPipe() was called
+	if err == errorPipeConnected {
+		err = nil
+	} else {
+		_, err = pipeWait(h, ov, err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return nil, errorTerminated
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// create the instance that will be used by the next client
+	l.next, err = pipeCreate(l.address, false)
+	if err != nil {
+		syscall.CloseHandle(h)
+		l.closed = true
+		return nil, err
+	}
+
+	return newPipeWindowsConn(h)
+}
+
+func pipeDial(address string) (io.ReadWriteCloser, error) {
+	name, err := syscall.UTF16PtrFromString(address)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := syscall.CreateFile(name,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_OVERLAPPED,
+		0)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPipeWindowsConn(h)
+}
@@ -0,0 +1,320 @@
+package gomavlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// telnet commands and options, described in RFC854, RFC856, RFC858 and RFC2217
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptBinary  = 0
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+
+	rfc2217SetBaudrate = 1
+	rfc2217SetDatasize = 2
+	rfc2217SetParity   = 3
+	rfc2217SetStopsize = 4
+)
+
+// Parity is the parity of a serial port.
+type Parity int
+
+const (
+	// ParityNone means no parity bit.
+	ParityNone Parity = iota
+	// ParityOdd means odd parity.
+	ParityOdd
+	// ParityEven means even parity.
+	ParityEven
+	// ParityMark means that the parity bit is always 1.
+	ParityMark
+	// ParitySpace means that the parity bit is always 0.
+	ParitySpace
+)
+
+// EndpointRfc2217 sets up a endpoint that works with a remote serial port,
+// exposed through the network by a RFC2217 server (i.e. ser2net or a terminal
+// server). Unlike EndpointTcpClient, it allows to set the baud rate and the
+// other parameters of the remote port.
+type EndpointRfc2217 struct {
+	// domain name or IP of the server to connect to, example: 1.2.3.4:2217
+	Address string
+
+	// baud rate of the remote serial port, example: 57600
+	Baud int
+
+	// (optional) number of data bits. It defaults to 8.
+	DataBits int
+
+	// (optional) parity. It defaults to ParityNone.
+	Parity Parity
+
+	// (optional) number of stop bits, 1 or 2. It defaults to 1.
+	StopBits int
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+func (conf EndpointRfc2217) init() (Endpoint, error) {
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.Baud <= 0 {
+		return nil, fmt.Errorf("invalid baud rate")
+	}
+
+	if conf.DataBits == 0 {
+		conf.DataBits = 8
+	}
+	if conf.DataBits < 5 || conf.DataBits > 8 {
+		return nil, fmt.Errorf("invalid data bits")
+	}
+
+	if conf.Parity < ParityNone || conf.Parity > ParitySpace {
+		return nil, fmt.Errorf("invalid parity")
+	}
+
+	if conf.StopBits == 0 {
+		conf.StopBits = 1
+	}
+	if conf.StopBits != 1 && conf.StopBits != 2 {
+		return nil, fmt.Errorf("invalid stop bits")
+	}
+
+	dial := func() (io.ReadWriteCloser, error) {
+		rawConn, err := net.DialTimeout("tcp4", conf.Address, netConnectTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		conn := newTelnetConn(&netTimedConn{conn: rawConn}, nil)
+
+		err = conn.negotiateComPort(conf)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	return newEndpointTransport(conf, &funcTransport{"rfc2217", dial}, conf.Address, false, conf.Reconnect)
+}
+
+type telnetState int
+
+const (
+	telnetStateData telnetState = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// telnetConn transfers data through a telnet connection, escaping and
+// unescaping the IAC byte and handling option negotiation.
+type telnetConn struct {
+	conn             io.ReadWriteCloser
+	onSubnegotiation func(opt byte, data []byte)
+
+	writeMutex sync.Mutex
+
+	// read state
+	readBuf []byte
+	state   telnetState
+	command byte
+	sbBuf   []byte
+}
+
+func newTelnetConn(conn io.ReadWriteCloser, onSubneg func(opt byte, data []byte)) *telnetConn {
+	return &telnetConn{
+		conn:             conn,
+		onSubnegotiation: onSubneg,
+		readBuf:          make([]byte, bufferSize),
+	}
+}
+
+func (c *telnetConn) writeRaw(buf []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *telnetConn) negotiateComPort(conf EndpointRfc2217) error {
+	buf := []byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptComPort,
+	}
+
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(conf.Baud))
+	buf = append(buf, telnetSubnegotiation(telnetOptComPort,
+		append([]byte{rfc2217SetBaudrate}, baud...))...)
+
+	buf = append(buf, telnetSubnegotiation(telnetOptComPort,
+		[]byte{rfc2217SetDatasize, byte(conf.DataBits)})...)
+
+	// RFC2217 values are 1 (none), 2 (odd), 3 (even), 4 (mark), 5 (space)
+	buf = append(buf, telnetSubnegotiation(telnetOptComPort,
+		[]byte{rfc2217SetParity, byte(conf.Parity) + 1})...)
+
+	buf = append(buf, telnetSubnegotiation(telnetOptComPort,
+		[]byte{rfc2217SetStopsize, byte(conf.StopBits)})...)
+
+	return c.writeRaw(buf)
+}
+
+func telnetEscape(buf []byte) []byte {
+	ret := make([]byte, 0, len(buf))
+	for _, b := range buf {
+		if b == telnetIAC {
+			ret = append(ret, telnetIAC)
+		}
+		ret = append(ret, b)
+	}
+	return ret
+}
+
+func telnetSubnegotiation(opt byte, data []byte) []byte {
+	buf := []byte{telnetIAC, telnetSB, opt}
+	buf = append(buf, telnetEscape(data)...)
+	return append(buf, telnetIAC, telnetSE)
+}
+
+func (c *telnetConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *telnetConn) Write(buf []byte) (int, error) {
+	err := c.writeRaw(telnetEscape(buf))
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (c *telnetConn) Read(buf []byte) (int, error) {
+	for {
+		size := len(buf)
+		if size > len(c.readBuf) {
+			size = len(c.readBuf)
+		}
+
+		n, err := c.conn.Read(c.readBuf[:size])
+		if err != nil {
+			return 0, err
+		}
+
+		// data is never longer than the input
+		out, err := c.process(c.readBuf[:n], buf[:0])
+		if err != nil {
+			return 0, err
+		}
+
+		if len(out) > 0 {
+			return len(out), nil
+		}
+	}
+}
+
+func (c *telnetConn) process(in []byte, out []byte) ([]byte, error) {
+	for _, b := range in {
+		switch c.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				c.state = telnetStateIAC
+			} else {
+				out = append(out, b)
+			}
+
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC:
+				out = append(out, b)
+				c.state = telnetStateData
+
+			case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+				c.command = b
+				c.state = telnetStateOption
+
+			case telnetSB:
+				c.sbBuf = c.sbBuf[:0]
+				c.state = telnetStateSB
+
+			default:
+				// other commands have no arguments
+				c.state = telnetStateData
+			}
+
+		case telnetStateOption:
+			err := c.handleOption(c.command, b)
+			if err != nil {
+				return nil, err
+			}
+			c.state = telnetStateData
+
+		case telnetStateSB:
+			if b == telnetIAC {
+				c.state = telnetStateSBIAC
+			} else {
+				c.sbBuf = append(c.sbBuf, b)
+			}
+
+		case telnetStateSBIAC:
+			switch b {
+			case telnetSE:
+				if len(c.sbBuf) > 0 && c.onSubnegotiation != nil {
+					c.onSubnegotiation(c.sbBuf[0], c.sbBuf[1:])
+				}
+				c.state = telnetStateData
+
+			case telnetIAC:
+				c.sbBuf = append(c.sbBuf, b)
+				c.state = telnetStateSB
+
+			default:
+				c.state = telnetStateSB
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// handleOption refuses options that are not supported. Supported options have
+// already been requested and do not need an answer.
+func (c *telnetConn) handleOption(command byte, opt byte) error {
+	switch opt {
+	case telnetOptBinary, telnetOptSGA, telnetOptComPort:
+		return nil
+	}
+
+	switch command {
+	case telnetDO:
+		return c.writeRaw([]byte{telnetIAC, telnetWONT, opt})
+
+	case telnetWILL:
+		return c.writeRaw([]byte{telnetIAC, telnetDONT, opt})
+	}
+	return nil
+}
@@ -1,57 +1,334 @@
 package gomavlib
 
 import (
+	"encoding/binary"
 	"fmt"
 	"github.com/tarm/serial"
 	"io"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib/x25"
+)
+
+const (
+	// period between attempts to reopen a serial port that has disappeared
+	serialReopenPeriod = 1 * time.Second
+
+	// time spent listening to each baud rate during detection, that must
+	// include at least one heartbeat
+	serialAutoBaudWindow = 2500 * time.Millisecond
+
+	// timeout of reads during detection
+	serialAutoBaudReadTimeout = 100 * time.Millisecond
 )
 
-var reSerial = regexp.MustCompile("^(.+?):([0-9]+)$")
+// baud rates tried during detection, in order of likelihood
+var serialAutoBaudRates = []int{57600, 115200, 921600, 460800, 230400, 500000, 1500000, 38400, 19200, 9600}
+
+var reSerial = regexp.MustCompile("^(.+?):([0-9]+|auto)$")
+
+// prefix of the names of Windows named pipes
+const serialPipePrefix = `\\.\pipe\`
+
+// serialOpen opens a serial port. It is replaced in tests.
+var serialOpen = func(conf serial.Config, rtsCts bool) (io.ReadWriteCloser, error) {
+	port, err := serial.OpenPort(&conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if rtsCts {
+		err := serialSetRtsCts(conf.Name)
+		if err != nil {
+			port.Close()
+			return nil, err
+		}
+	}
+
+	return port, nil
+}
 
 // EndpointSerial sets up a endpoint that works with a serial port.
+// When the port disappears, i.e. a USB adapter is unplugged, its channel is
+// closed and the port is reopened as soon as it reappears, with a new channel.
 type EndpointSerial struct {
 	// the address of the serial port in format name:baudrate
 	// example: /dev/ttyUSB0:57600
+	// If the baud rate is "auto", example: /dev/ttyUSB0:auto, common baud
+	// rates are tried until a valid heartbeat is received, then the rate
+	// is kept.
+	// On Windows, COM ports above COM9 are supported (example: COM12:57600),
+	// and a named pipe can be used in place of a port, without baud rate
+	// (example: \\.\pipe\sitl), i.e. to communicate with a simulator.
 	Address string
+
+	// (optional) number of data bits. It defaults to 8.
+	DataBits int
+
+	// (optional) parity. It defaults to ParityNone.
+	Parity Parity
+
+	// (optional) number of stop bits, 1 or 2. It defaults to 1.
+	StopBits int
+
+	// (optional) enables RTS/CTS hardware flow control.
+	// It is supported on Linux only.
+	RtsCts bool
 }
 
 type endpointSerial struct {
 	conf EndpointSerial
-	io.ReadWriteCloser
+
+	// the parameters of the port. When the baud rate is zero, the port is
+	// a named pipe.
+	port serial.Config
+
+	// the port opened by init(), that is returned by the first Accept()
+	first io.ReadWriteCloser
+
+	// closed when the current port is closed
+	portClosed chan struct{}
+
+	terminate chan struct{}
 }
 
 func (conf EndpointSerial) init() (Endpoint, error) {
-	matches := reSerial.FindStringSubmatch(conf.Address)
-	if matches == nil {
-		return nil, fmt.Errorf("invalid address")
+	if conf.DataBits == 0 {
+		conf.DataBits = 8
+	}
+	if conf.DataBits < 5 || conf.DataBits > 8 {
+		return nil, fmt.Errorf("invalid data bits")
 	}
 
-	name := matches[1]
-	baud, _ := strconv.Atoi(matches[2])
+	if conf.Parity < ParityNone || conf.Parity > ParitySpace {
+		return nil, fmt.Errorf("invalid parity")
+	}
 
-	port, err := serial.OpenPort(&serial.Config{
-		Name: name,
-		Baud: baud,
-	})
-	if err != nil {
-		return nil, err
+	if conf.StopBits == 0 {
+		conf.StopBits = 1
+	}
+	if conf.StopBits != 1 && conf.StopBits != 2 {
+		return nil, fmt.Errorf("invalid stop bits")
 	}
 
 	t := &endpointSerial{
-		conf:            conf,
-		ReadWriteCloser: port,
+		conf: conf,
+		port: serial.Config{
+			Size:     byte(conf.DataBits),
+			Parity:   serialParities[conf.Parity],
+			StopBits: serial.StopBits(conf.StopBits),
+		},
+		terminate: make(chan struct{}),
 	}
+
+	if strings.HasPrefix(strings.ToLower(conf.Address), serialPipePrefix) {
+		t.port.Name = conf.Address
+	} else {
+		matches := reSerial.FindStringSubmatch(conf.Address)
+		if matches == nil {
+			return nil, fmt.Errorf("invalid address")
+		}
+
+		t.port.Name = matches[1]
+
+		if matches[2] == "auto" {
+			var err error
+			t.port.Baud, err = serialDetectBaud(t.port, conf.RtsCts)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			t.port.Baud, _ = strconv.Atoi(matches[2])
+		}
+	}
+
+	// the port must exist when the node is created
+	var err error
+	t.first, err = t.open()
+	if err != nil {
+		return nil, err
+	}
+
 	return t, nil
 }
 
+// serial parities, indexed by Parity
+var serialParities = []serial.Parity{
+	serial.ParityNone,
+	serial.ParityOdd,
+	serial.ParityEven,
+	serial.ParityMark,
+	serial.ParitySpace,
+}
+
+func (t *endpointSerial) open() (io.ReadWriteCloser, error) {
+	if t.port.Baud == 0 {
+		return pipeDial(t.port.Name)
+	}
+	return serialOpen(t.port, t.conf.RtsCts)
+}
+
 func (t *endpointSerial) isEndpoint() {}
 
 func (t *endpointSerial) Conf() interface{} {
 	return t.conf
 }
 
-func (t *endpointSerial) Label() string {
-	return fmt.Sprintf("serial")
+func (t *endpointSerial) Close() error {
+	close(t.terminate)
+	return nil
+}
+
+func (t *endpointSerial) Accept() (string, io.ReadWriteCloser, error) {
+	port := t.first
+	t.first = nil
+
+	if port == nil {
+		// wait until the channel of the previous port is closed
+		select {
+		case <-t.portClosed:
+		case <-t.terminate:
+			return "", nil, errorTerminated
+		}
+
+		// reopen the port as soon as it reappears
+		for {
+			var err error
+			port, err = t.open()
+			if err == nil {
+				break
+			}
+
+			select {
+			case <-time.After(serialReopenPeriod):
+			case <-t.terminate:
+				return "", nil, errorTerminated
+			}
+		}
+	}
+
+	t.portClosed = make(chan struct{})
+	return "serial", &serialPort{
+		ReadWriteCloser: port,
+		closed:          t.portClosed,
+	}, nil
+}
+
+// serialPort notifies the endpoint when it is closed, i.e. when the port has
+// disappeared and its channel has been closed.
+type serialPort struct {
+	io.ReadWriteCloser
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (p *serialPort) Close() error {
+	err := p.ReadWriteCloser.Close()
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return err
+}
+
+// serialDetectBaud finds the baud rate of a port, by trying common rates
+// until a valid heartbeat is received.
+func serialDetectBaud(conf serial.Config, rtsCts bool) (int, error) {
+	conf.ReadTimeout = serialAutoBaudReadTimeout
+
+	for _, baud := range serialAutoBaudRates {
+		conf.Baud = baud
+		port, err := serialOpen(conf, rtsCts)
+		if err != nil {
+			// the rate may be unsupported by the system
+			continue
+		}
+
+		ok, err := serialListenHeartbeat(port)
+		port.Close()
+
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return baud, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unable to detect the baud rate")
+}
+
+// serialListenHeartbeat reads from a port until a valid heartbeat is found
+// or the detection window elapses.
+func serialListenHeartbeat(port io.Reader) (bool, error) {
+	var buf []byte
+	tmp := make([]byte, bufferSize)
+	deadline := time.Now().Add(serialAutoBaudWindow)
+
+	for time.Now().Before(deadline) {
+		n, err := port.Read(tmp)
+		if err != nil && err != io.EOF { // io.EOF is returned on timeout
+			return false, err
+		}
+
+		buf = append(buf, tmp[:n]...)
+		if containsHeartbeat(buf) {
+			return true, nil
+		}
+
+		// keep the bytes that may contain the beginning of a frame
+		if len(buf) > 2*bufferSize {
+			buf = buf[len(buf)-bufferSize:]
+		}
+	}
+
+	return false, nil
+}
+
+// containsHeartbeat checks whether a buffer contains a v1 or v2 HEARTBEAT
+// frame with a valid checksum. The heartbeat is used since it is sent
+// periodically by all systems and its CRC extra does not depend on the dialect.
+func containsHeartbeat(buf []byte) bool {
+	const heartbeatCrcExtra = 50
+
+	for i := range buf {
+		var headerLen int
+		var msgId uint32
+
+		switch buf[i] {
+		case 0xFE: // v1
+			headerLen = 6
+			if len(buf)-i < headerLen {
+				continue
+			}
+			msgId = uint32(buf[i+5])
+
+		case 0xFD: // v2
+			headerLen = 10
+			if len(buf)-i < headerLen {
+				continue
+			}
+			msgId = uint32(buf[i+7]) | uint32(buf[i+8])<<8 | uint32(buf[i+9])<<16
+
+		default:
+			continue
+		}
+
+		payloadLen := int(buf[i+1])
+		if msgId != 0 || payloadLen > 9 || len(buf)-i < headerLen+payloadLen+2 {
+			continue
+		}
+
+		h := x25.New()
+		h.Write(buf[i+1 : i+headerLen+payloadLen])
+		h.Write([]byte{heartbeatCrcExtra})
+
+		if h.Sum16() == binary.LittleEndian.Uint16(buf[i+headerLen+payloadLen:]) {
+			return true
+		}
+	}
+	return false
 }
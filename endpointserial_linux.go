@@ -0,0 +1,39 @@
+// +build linux
+
+package gomavlib
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// flag of RTS/CTS flow control, the same on all Linux architectures
+const serialCrtscts = 0x80000000
+
+// serialSetRtsCts enables RTS/CTS flow control on a port that is already
+// open. Terminal settings belong to the device, therefore it is opened again
+// in order to obtain a file descriptor.
+func serialSetRtsCts(name string) error {
+	fd, err := syscall.Open(name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd),
+		uintptr(syscall.TCGETS), uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return errno
+	}
+
+	t.Cflag |= serialCrtscts
+
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd),
+		uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package gomavlib
+
+import (
+	"fmt"
+)
+
+func serialSetRtsCts(name string) error {
+	return fmt.Errorf("RTS/CTS flow control is supported on Linux only")
+}
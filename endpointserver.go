@@ -1,16 +1,21 @@
 package gomavlib
 
 import (
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
-
-	"github.com/aler9/gomavlib/udplistener"
+	"syscall"
+	"time"
 )
 
 type endpointServerConf interface {
 	isUdp() bool
 	getAddress() string
+	getControl() func(string, string, syscall.RawConn) error
+	getAllowedSources() []string
+	getTLSConfig() *tls.Config
+	getIdleTimeout() time.Duration
+	getMaxClients() int
 }
 
 // EndpointTcpServer sets up a endpoint that works with a TCP server.
@@ -20,6 +25,10 @@ type endpointServerConf interface {
 type EndpointTcpServer struct {
 	// listen address, example: 0.0.0.0:5600
 	Address string
+
+	// (optional) a function that is called after creating the socket and
+	// before binding it, that allows to set socket options. See net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
 }
 
 func (EndpointTcpServer) isUdp() bool {
@@ -30,12 +39,98 @@ func (conf EndpointTcpServer) getAddress() string {
 	return conf.Address
 }
 
+func (conf EndpointTcpServer) getControl() func(string, string, syscall.RawConn) error {
+	return conf.Control
+}
+
+func (EndpointTcpServer) getAllowedSources() []string {
+	return nil
+}
+
+func (EndpointTcpServer) getIdleTimeout() time.Duration {
+	return 0
+}
+
+func (EndpointTcpServer) getMaxClients() int {
+	return 0
+}
+
+func (EndpointTcpServer) getTLSConfig() *tls.Config {
+	return nil
+}
+
+// EndpointTcpTlsServer sets up a endpoint that works with a TCP server
+// and encrypts traffic with TLS. It is fit for routing frames through the
+// internet. Clients can be authenticated with certificates by setting
+// ClientAuth and ClientCAs in the TLS configuration.
+type EndpointTcpTlsServer struct {
+	// listen address, example: 0.0.0.0:5600
+	Address string
+
+	// the TLS configuration. It must contain at least one certificate
+	// in Certificates, or GetCertificate.
+	TLSConfig *tls.Config
+
+	// (optional) a function that is called after creating the socket and
+	// before binding it, that allows to set socket options. See net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+func (EndpointTcpTlsServer) isUdp() bool {
+	return false
+}
+
+func (conf EndpointTcpTlsServer) getAddress() string {
+	return conf.Address
+}
+
+func (conf EndpointTcpTlsServer) getControl() func(string, string, syscall.RawConn) error {
+	return conf.Control
+}
+
+func (EndpointTcpTlsServer) getAllowedSources() []string {
+	return nil
+}
+
+func (EndpointTcpTlsServer) getIdleTimeout() time.Duration {
+	return 0
+}
+
+func (EndpointTcpTlsServer) getMaxClients() int {
+	return 0
+}
+
+func (conf EndpointTcpTlsServer) getTLSConfig() *tls.Config {
+	return conf.TLSConfig
+}
+
 // EndpointUdpServer sets up a endpoint that works with an UDP server.
 // This is the most appropriate way for transferring frames from a UAV to a GCS
 // if they are connected to the same network.
 type EndpointUdpServer struct {
 	// listen address, example: 0.0.0.0:5600
 	Address string
+
+	// (optional) a function that is called after creating the socket and
+	// before binding it, that allows to set socket options. See net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// (optional) the sources from which frames are accepted, in IP
+	// (192.168.1.5) or CIDR (192.168.1.0/24) notation. Datagrams coming from
+	// other sources are discarded before being parsed.
+	// If empty, all sources are accepted.
+	AllowedSources []string
+
+	// (optional) the time after which a client that doesn't send datagrams
+	// is removed, and its channel is closed.
+	// It defaults to 60 seconds.
+	IdleTimeout time.Duration
+
+	// (optional) the maximum number of simultaneous clients. Datagrams coming
+	// from new clients beyond this limit are discarded, in order to protect
+	// the node from floods of datagrams with spoofed source addresses.
+	// If zero, clients are not limited.
+	MaxClients int
 }
 
 func (EndpointUdpServer) isUdp() bool {
@@ -46,10 +141,24 @@ func (conf EndpointUdpServer) getAddress() string {
 	return conf.Address
 }
 
-type endpointServer struct {
-	conf      endpointServerConf
-	listener  net.Listener
-	terminate chan struct{}
+func (conf EndpointUdpServer) getControl() func(string, string, syscall.RawConn) error {
+	return conf.Control
+}
+
+func (conf EndpointUdpServer) getAllowedSources() []string {
+	return conf.AllowedSources
+}
+
+func (EndpointUdpServer) getTLSConfig() *tls.Config {
+	return nil
+}
+
+func (conf EndpointUdpServer) getIdleTimeout() time.Duration {
+	return conf.IdleTimeout
+}
+
+func (conf EndpointUdpServer) getMaxClients() int {
+	return conf.MaxClients
 }
 
 func (conf EndpointTcpServer) init() (Endpoint, error) {
@@ -60,59 +169,43 @@ func (conf EndpointUdpServer) init() (Endpoint, error) {
 	return initEndpointServer(conf)
 }
 
+func (conf EndpointTcpTlsServer) init() (Endpoint, error) {
+	if conf.TLSConfig == nil {
+		return nil, fmt.Errorf("TLSConfig not provided")
+	}
+	if len(conf.TLSConfig.Certificates) == 0 && conf.TLSConfig.GetCertificate == nil {
+		return nil, fmt.Errorf("TLSConfig does not contain any certificate")
+	}
+	return initEndpointServer(conf)
+}
+
 func initEndpointServer(conf endpointServerConf) (Endpoint, error) {
 	_, _, err := net.SplitHostPort(conf.getAddress())
 	if err != nil {
 		return nil, fmt.Errorf("invalid address")
 	}
 
-	var listener net.Listener
-	if conf.isUdp() == true {
-		listener, err = udplistener.New("udp4", conf.getAddress())
-	} else {
-		listener, err = net.Listen("tcp4", conf.getAddress())
-	}
-	if err != nil {
-		return nil, err
+	if conf.getIdleTimeout() < 0 {
+		return nil, fmt.Errorf("IdleTimeout must be >= 0")
 	}
 
-	t := &endpointServer{
-		conf:      conf,
-		listener:  listener,
-		terminate: make(chan struct{}),
+	if conf.getMaxClients() < 0 {
+		return nil, fmt.Errorf("MaxClients must be >= 0")
 	}
-	return t, nil
-}
-
-func (t *endpointServer) isEndpoint() {}
-
-func (t *endpointServer) Conf() interface{} {
-	return t.conf
-}
-
-func (t *endpointServer) Close() error {
-	close(t.terminate)
-	t.listener.Close()
-	return nil
-}
 
-func (t *endpointServer) Accept() (string, io.ReadWriteCloser, error) {
-	rawConn, err := t.listener.Accept()
-
-	// wait termination, do not report errors
+	filter, err := sourceFilter(conf.getAllowedSources())
 	if err != nil {
-		<-t.terminate
-		return "", nil, errorTerminated
+		return nil, err
 	}
 
-	label := fmt.Sprintf("%s:%s", func() string {
-		if t.conf.isUdp() {
-			return "udp"
-		}
-		return "tcp"
-	}(), rawConn.RemoteAddr())
-
-	conn := &netTimedConn{rawConn}
+	t := &netTransport{
+		udp:         conf.isUdp(),
+		control:     conf.getControl(),
+		tlsConfig:   conf.getTLSConfig(),
+		filter:      filter,
+		idleTimeout: conf.getIdleTimeout(),
+		maxClients:  conf.getMaxClients(),
+	}
 
-	return label, conn, nil
+	return newEndpointTransport(conf, t, conf.getAddress(), true, ReconnectPolicy{})
 }
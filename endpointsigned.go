@@ -0,0 +1,165 @@
+package gomavlib
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/aler9/gomavlib/frame"
+)
+
+// SigningDomain is a signing context, with its own keys, link ids and
+// timestamps, that is shared by a group of endpoints. It allows a node to
+// sign and validate frames with different keys on each side, i.e. when
+// bridging internal links and the network of a partner.
+// Endpoints are added to a domain with EndpointSigned.
+type SigningDomain struct {
+	// (optional) the secret key used to validate incoming frames.
+	// Non signed frames are discarded, as well as frames with a version < 2.0.
+	// If nil, incoming frames are not validated, regardless of NodeConf.InKey.
+	InKey *frame.V2Key
+
+	// (optional) the secret key used to sign outgoing frames.
+	// This feature requires a version >= 2.0.
+	// If nil, outgoing frames are not signed, regardless of NodeConf.OutKey.
+	OutKey *frame.V2Key
+
+	// (optional) the link id of the first channel of the domain. Link ids are
+	// assigned to channels sequentially, therefore each channel of the
+	// domain signs frames with a distinct link id.
+	FirstSignatureLinkId byte
+
+	// (optional) a store that persists the sequence ids and the signature
+	// timestamps of the channels of the domain. It overrides
+	// NodeConf.SequenceStore.
+	SequenceStore SequenceStore
+
+	mutex      sync.Mutex
+	linkIdUsed bool
+	nextLinkId byte
+}
+
+func (d *SigningDomain) allocLinkId() byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.linkIdUsed {
+		d.linkIdUsed = true
+		d.nextLinkId = d.FirstSignatureLinkId
+	}
+
+	id := d.nextLinkId
+	d.nextLinkId++
+	return id
+}
+
+// EndpointSigned sets up a endpoint that wraps another endpoint and signs and
+// validates its frames with the keys of a SigningDomain, instead of the ones
+// of the node. Endpoints that share a domain must point to the same
+// SigningDomain. It can be wrapped by other endpoints, like EndpointTap or
+// EndpointImpaired, and the domain is still used, but it can't wrap another
+// EndpointSigned.
+type EndpointSigned struct {
+	// the endpoint to wrap.
+	Endpoint EndpointConf
+
+	// the signing domain of the endpoint.
+	Domain *SigningDomain
+}
+
+func (conf EndpointSigned) unwrap() EndpointConf {
+	return conf.Endpoint
+}
+
+// endpointSigningDomain returns the signing domain of an endpoint, that is
+// set by an EndpointSigned at any level of the chain of wrapped endpoints.
+func endpointSigningDomain(e Endpoint) *SigningDomain {
+	conf, ok := e.Conf().(EndpointConf)
+	if !ok {
+		return nil
+	}
+
+	for _, c := range endpointChain(conf) {
+		if sc, ok := c.(EndpointSigned); ok {
+			return sc.Domain
+		}
+	}
+	return nil
+}
+
+func (conf EndpointSigned) init() (Endpoint, error) {
+	if conf.Endpoint == nil {
+		return nil, fmt.Errorf("Endpoint not provided")
+	}
+
+	if conf.Domain == nil {
+		return nil, fmt.Errorf("Domain not provided")
+	}
+
+	for _, c := range endpointChain(conf.Endpoint) {
+		switch c.(type) {
+		case EndpointMirror:
+			return nil, fmt.Errorf("mirror endpoints can't be signed")
+
+		case EndpointSigned:
+			return nil, fmt.Errorf("signed endpoints can't be signed again")
+		}
+	}
+
+	inner, err := conf.Endpoint.init()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tinner := inner.(type) {
+	case endpointChannelSingle:
+		return &endpointSignedSingle{
+			conf:                  conf,
+			endpointChannelSingle: tinner,
+		}, nil
+
+	case endpointChannelAccepter:
+		return &endpointSignedAccepter{
+			conf:                    conf,
+			endpointChannelAccepter: tinner,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("endpoint %T can't be signed", inner)
+}
+
+type endpointSignedSingle struct {
+	conf EndpointSigned
+	endpointChannelSingle
+}
+
+func (t *endpointSignedSingle) Conf() interface{} {
+	return t.conf
+}
+
+// RemoteAddr returns the address of the remote peer of the wrapped
+// endpoint, if available.
+func (t *endpointSignedSingle) RemoteAddr() net.Addr {
+	if ra, ok := t.endpointChannelSingle.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+type endpointSignedAccepter struct {
+	conf EndpointSigned
+	endpointChannelAccepter
+}
+
+func (t *endpointSignedAccepter) Conf() interface{} {
+	return t.conf
+}
+
+// checkSigningDomain checks whether the signing domain of an endpoint, if
+// any, can be used with the node configuration.
+func checkSigningDomain(conf NodeConf, e Endpoint) error {
+	if d := endpointSigningDomain(e); d != nil && d.OutKey != nil && conf.OutVersion != V2 {
+		return fmt.Errorf("OutKey requires V2 frames")
+	}
+	return nil
+}
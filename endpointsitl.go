@@ -0,0 +1,152 @@
+package gomavlib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// EndpointSitl sets up a endpoint that spawns a SITL (software in the loop)
+// autopilot, i.e. ArduPilot or PX4 compiled for the host, and connects to its
+// TCP port. The process is restarted when it exits, and is killed when the
+// node is closed. It allows to run integration tests against real autopilot
+// firmware.
+type EndpointSitl struct {
+	// the path of the SITL binary, example: ./build/sitl/bin/arducopter
+	Path string
+
+	// (optional) the arguments of the binary, example: --model quad
+	Args []string
+
+	// (optional) the working directory of the process.
+	// It defaults to the current directory.
+	Dir string
+
+	// (optional) environment variables of the process, in the form
+	// key=value, that are added to the ones of the current process.
+	Env []string
+
+	// (optional) a writer to which the output of the process is written.
+	// If not provided, the output is discarded.
+	Output io.Writer
+
+	// (optional) the address of the TCP port of the SITL.
+	// It defaults to 127.0.0.1:5760, the serial port 0 of ArduPilot SITL.
+	Address string
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established, i.e. while the SITL is starting. It defaults to a retry
+	// every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+type endpointSitl struct {
+	endpointChannelSingle
+	conf EndpointSitl
+
+	mutex  sync.Mutex
+	closed bool
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+func (conf EndpointSitl) init() (Endpoint, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("Path not provided")
+	}
+
+	if conf.Address == "" {
+		conf.Address = "127.0.0.1:5760"
+	}
+
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	t := &endpointSitl{
+		conf: conf,
+	}
+
+	dial := func() (io.ReadWriteCloser, error) {
+		err := t.start()
+		if err != nil {
+			return nil, err
+		}
+
+		rawConn, err := net.DialTimeout("tcp4", conf.Address, netConnectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &netTimedConn{conn: rawConn}, nil
+	}
+
+	e, err := newEndpointTransport(conf, &funcTransport{"sitl", dial}, conf.Address, false, conf.Reconnect)
+	if err != nil {
+		return nil, err
+	}
+
+	t.endpointChannelSingle = e.(endpointChannelSingle)
+	return t, nil
+}
+
+// start starts the process, if it is not running.
+func (t *endpointSitl) start() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return errorTerminated
+	}
+
+	if t.cmd != nil {
+		select {
+		case <-t.exited:
+		default:
+			// process is running
+			return nil
+		}
+	}
+
+	cmd := exec.Command(t.conf.Path, t.conf.Args...)
+	cmd.Dir = t.conf.Dir
+	if t.conf.Env != nil {
+		cmd.Env = append(os.Environ(), t.conf.Env...)
+	}
+	cmd.Stdout = t.conf.Output
+	cmd.Stderr = t.conf.Output
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		cmd.Wait()
+	}()
+
+	t.cmd = cmd
+	t.exited = exited
+	return nil
+}
+
+func (t *endpointSitl) Close() error {
+	t.endpointChannelSingle.Close()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.closed = true
+
+	if t.cmd != nil {
+		t.cmd.Process.Kill()
+		<-t.exited
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package gomavlib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// EndpointTap sets up a endpoint that wraps another endpoint and copies the
+// raw bytes read from and written to its channels into writers, before they
+// are parsed, in order to capture the exact traffic of a link (i.e. of a
+// radio) without writing a custom endpoint. Bytes that can't be decoded are
+// captured too. It is equivalent to a tap added with Node.AddTap and
+// TapWriter, but it can be set up in the node configuration. Bytes are
+// captured as they are read and written by the channels, whatever the
+// position of the EndpointTap among wrapped endpoints.
+type EndpointTap struct {
+	// the endpoint to wrap.
+	Endpoint EndpointConf
+
+	// (optional) receives the bytes read from the endpoint.
+	In io.Writer
+
+	// (optional) receives the bytes written to the endpoint.
+	// It can be equal to In, in order to capture both directions into the
+	// same writer.
+	Out io.Writer
+}
+
+func (conf EndpointTap) unwrap() EndpointConf {
+	return conf.Endpoint
+}
+
+func (conf EndpointTap) init() (Endpoint, error) {
+	if conf.Endpoint == nil {
+		return nil, fmt.Errorf("Endpoint not provided")
+	}
+
+	if conf.In == nil && conf.Out == nil {
+		return nil, fmt.Errorf("In or Out must be provided")
+	}
+
+	inner, err := conf.Endpoint.init()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tinner := inner.(type) {
+	case endpointChannelSingle:
+		return &endpointTapSingle{
+			conf:                  conf,
+			endpointChannelSingle: tinner,
+		}, nil
+
+	case endpointChannelAccepter:
+		return &endpointTapAccepter{
+			conf:                    conf,
+			endpointChannelAccepter: tinner,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("endpoint %T can't be tapped", inner)
+}
+
+// tapFunc returns a TapFunc that writes the bytes of each direction into
+// the corresponding writer.
+func (conf EndpointTap) tapFunc() TapFunc {
+	// channels of the same endpoint are read and written in parallel,
+	// and In can be equal to Out
+	var mutex sync.Mutex
+
+	return func(ch *Channel, dir TapDirection, buf []byte) {
+		w := conf.In
+		if dir == TapOut {
+			w = conf.Out
+		}
+		if w == nil {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write(buf)
+	}
+}
+
+// addEndpointTaps adds the taps of the EndpointTaps in the chain of wrapped
+// endpoints of an endpoint.
+func (n *Node) addEndpointTaps(e Endpoint) {
+	conf, ok := e.Conf().(EndpointConf)
+	if !ok {
+		return
+	}
+
+	for _, c := range endpointChain(conf) {
+		if tc, ok := c.(EndpointTap); ok {
+			n.AddTap(e, tc.tapFunc())
+		}
+	}
+}
+
+type endpointTapSingle struct {
+	conf EndpointTap
+	endpointChannelSingle
+}
+
+func (t *endpointTapSingle) Conf() interface{} {
+	return t.conf
+}
+
+// RemoteAddr returns the address of the remote peer of the wrapped
+// endpoint, if available.
+func (t *endpointTapSingle) RemoteAddr() net.Addr {
+	if ra, ok := t.endpointChannelSingle.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+type endpointTapAccepter struct {
+	conf EndpointTap
+	endpointChannelAccepter
+}
+
+func (t *endpointTapAccepter) Conf() interface{} {
+	return t.conf
+}
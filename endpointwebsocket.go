@@ -0,0 +1,204 @@
+package gomavlib
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aler9/gomavlib/websocket"
+)
+
+// EndpointWebsocketServer sets up a endpoint that works with a WebSocket
+// server. It allows to communicate with web-based ground stations.
+// Each frame is sent in a single binary message, and each incoming
+// binary message must contain whole frames.
+// Multiple clients can be connected at once, each with its own channel.
+type EndpointWebsocketServer struct {
+	// listen address, example: 0.0.0.0:8080
+	Address string
+
+	// (optional) the path on which connections are accepted.
+	// It defaults to "/".
+	Path string
+
+	// (optional) the TLS configuration. If provided, connections are
+	// encrypted (wss://).
+	TLSConfig *tls.Config
+
+	// (optional) the origins, other than the one of the server, of the web
+	// pages that are allowed to connect, example: https://example.com.
+	// "*" allows any origin. By default, connections opened by browsers
+	// from other origins are rejected.
+	AllowedOrigins []string
+}
+
+type endpointWebsocketServer struct {
+	conf      EndpointWebsocketServer
+	listener  net.Listener
+	server    *http.Server
+	conns     chan *websocket.Conn
+	terminate chan struct{}
+}
+
+func (conf EndpointWebsocketServer) init() (Endpoint, error) {
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+
+	listener, err := net.Listen("tcp4", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.TLSConfig != nil {
+		listener = tls.NewListener(listener, conf.TLSConfig)
+	}
+
+	t := &endpointWebsocketServer{
+		conf:      conf,
+		listener:  listener,
+		conns:     make(chan *websocket.Conn),
+		terminate: make(chan struct{}),
+	}
+
+	t.server = &http.Server{
+		Handler:           http.HandlerFunc(t.onRequest),
+		ReadHeaderTimeout: netConnectTimeout,
+	}
+
+	go t.server.Serve(listener)
+
+	return t, nil
+}
+
+func (t *endpointWebsocketServer) isEndpoint() {}
+
+func (t *endpointWebsocketServer) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointWebsocketServer) Close() error {
+	close(t.terminate)
+	t.server.Close()
+	return nil
+}
+
+func (t *endpointWebsocketServer) onRequest(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != t.conf.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r, websocketCheckOrigin(t.conf.AllowedOrigins))
+	if err != nil {
+		return
+	}
+
+	select {
+	case t.conns <- conn:
+	case <-t.terminate:
+		conn.Close()
+	}
+}
+
+func (t *endpointWebsocketServer) Accept() (string, io.ReadWriteCloser, error) {
+	select {
+	case conn := <-t.conns:
+		label := fmt.Sprintf("ws:%s", conn.RemoteAddr())
+		return label, &netTimedConn{conn: conn}, nil
+
+	case <-t.terminate:
+		return "", nil, errorTerminated
+	}
+}
+
+// websocketCheckOrigin returns a function that allows connections from the
+// origin of the server and from the allowed origins.
+func websocketCheckOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		if websocket.SameOrigin(r) {
+			return true
+		}
+
+		origin := r.Header.Get("Origin")
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(a, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// EndpointWebsocketClient sets up a endpoint that works with a WebSocket
+// client. Each frame is sent in a single binary message.
+// The client reconnects automatically when the connection is lost.
+type EndpointWebsocketClient struct {
+	// URL of the server to connect to, example: ws://1.2.3.4:8080/mavlink
+	// or wss://example.com/mavlink
+	Address string
+
+	// (optional) the TLS configuration used with wss:// URLs.
+	TLSConfig *tls.Config
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+func (conf EndpointWebsocketClient) init() (Endpoint, error) {
+	u, err := url.Parse(conf.Address)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	dial := func() (io.ReadWriteCloser, error) {
+		return websocketDial(u, conf.TLSConfig)
+	}
+
+	return newEndpointTransport(conf, &funcTransport{u.Scheme, dial}, u.Host, false, conf.Reconnect)
+}
+
+// websocketDial connects to a WebSocket server with a ws:// or wss:// URL.
+func websocketDial(u *url.URL, tlsConf *tls.Config) (io.ReadWriteCloser, error) {
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{
+		Timeout: netConnectTimeout,
+	}
+
+	var nconn net.Conn
+	var err error
+	if u.Scheme == "wss" {
+		nconn, err = tls.DialWithDialer(dialer, "tcp4", host, tlsConf)
+	} else {
+		nconn, err = dialer.Dial("tcp4", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := websocket.Client(nconn, u, netConnectTimeout)
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+
+	return &netTimedConn{conn: conn}, nil
+}
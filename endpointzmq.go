@@ -0,0 +1,447 @@
+package gomavlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib/zmq"
+)
+
+// ZmqMode is the messaging pattern of a ZeroMQ endpoint.
+type ZmqMode int
+
+const (
+	// ZmqPubSub publishes frames with a PUB socket bound to Address, and
+	// receives frames with a SUB socket connected to SubAddresses.
+	// The endpoint provides a single channel.
+	ZmqPubSub ZmqMode = iota
+
+	// ZmqReq sends frames as requests with a REQ socket connected to Address,
+	// and receives frames from replies. Frames written while waiting for a
+	// reply are discarded. The endpoint provides a single channel.
+	ZmqReq
+
+	// ZmqRep receives frames from requests with a REP socket bound to Address,
+	// and sends frames as replies. Frames written when there are no requests
+	// to reply to are discarded. Each connected REQ socket has its own channel.
+	ZmqRep
+)
+
+// EndpointZmq sets up a endpoint that works with ZeroMQ sockets (ZMTP 3.0,
+// without security), in order to integrate with ZeroMQ-based middleware.
+// Each frame is sent in a single-part message, and each incoming single-part
+// message must contain whole frames. Only the tcp:// transport is supported.
+type EndpointZmq struct {
+	// the messaging pattern. See ZmqMode for the available options.
+	Mode ZmqMode
+
+	// in ZmqPubSub and ZmqRep modes, the address to which the socket is
+	// bound, example: tcp://*:5600.
+	// In ZmqReq mode, the address of the REP socket, example: tcp://1.2.3.4:5600.
+	// In ZmqPubSub mode, it can be empty, and frames are not published.
+	Address string
+
+	// (optional) in ZmqPubSub mode, the addresses of the PUB sockets to which
+	// the SUB socket connects, example: tcp://1.2.3.4:5601. The SUB socket
+	// reconnects automatically when a connection is lost.
+	SubAddresses []string
+
+	// (optional) in ZmqReq mode, how the endpoint reconnects when the
+	// connection can't be established. It defaults to a retry every 2
+	// seconds, forever.
+	Reconnect ReconnectPolicy
+}
+
+// zmqAddress converts a ZeroMQ address into a TCP address.
+func zmqAddress(address string) (string, error) {
+	if !strings.HasPrefix(address, "tcp://") {
+		return "", fmt.Errorf("invalid address")
+	}
+	address = strings.TrimPrefix(address, "tcp://")
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address")
+	}
+
+	// wildcard of ZeroMQ
+	if host == "*" {
+		host = ""
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// zmqDial connects to a ZeroMQ socket. The connection is interrupted when
+// terminate is closed.
+func zmqDial(address string, socketType string, terminate chan struct{}) (*zmq.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-terminate:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	dialer := &net.Dialer{
+		Timeout: netConnectTimeout,
+	}
+
+	nconn, err := dialer.DialContext(ctx, "tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return zmqHandshake(nconn, socketType, terminate)
+}
+
+// zmqHandshake performs the handshake of a connection, that is interrupted
+// when terminate is closed.
+func zmqHandshake(nconn net.Conn, socketType string, terminate chan struct{}) (*zmq.Conn, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-terminate:
+			nconn.Close()
+		case <-done:
+		}
+	}()
+
+	conn, err := zmq.Handshake(nconn, socketType, netConnectTimeout)
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (conf EndpointZmq) init() (Endpoint, error) {
+	switch conf.Mode {
+	case ZmqPubSub:
+		return initEndpointZmqPubSub(conf)
+
+	case ZmqReq:
+		address, err := zmqAddress(conf.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		dial := func() (io.ReadWriteCloser, error) {
+			// the dial is abandoned by the client when it is closed
+			conn, err := zmqDial(address, zmq.TypeReq, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &netTimedConn{conn: conn}, nil
+		}
+
+		return newEndpointTransport(conf, &funcTransport{"zmq", dial}, conf.Address, false, conf.Reconnect)
+
+	case ZmqRep:
+		return initEndpointZmqRep(conf)
+	}
+
+	return nil, fmt.Errorf("invalid mode")
+}
+
+type endpointZmqPubSub struct {
+	conf      EndpointZmq
+	listener  net.Listener
+	terminate chan struct{}
+	readChan  chan []byte
+	wg        sync.WaitGroup
+
+	mutex sync.Mutex
+	pubs  map[*zmq.Conn]struct{}
+	subs  map[*zmq.Conn]struct{}
+
+	// accessed by Read() only
+	pending []byte
+}
+
+func initEndpointZmqPubSub(conf EndpointZmq) (Endpoint, error) {
+	if conf.Address == "" && len(conf.SubAddresses) == 0 {
+		return nil, fmt.Errorf("Address and SubAddresses not provided")
+	}
+
+	var subAddresses []string
+	for _, a := range conf.SubAddresses {
+		address, err := zmqAddress(a)
+		if err != nil {
+			return nil, err
+		}
+		subAddresses = append(subAddresses, address)
+	}
+
+	t := &endpointZmqPubSub{
+		conf:      conf,
+		terminate: make(chan struct{}),
+		readChan:  make(chan []byte),
+		pubs:      make(map[*zmq.Conn]struct{}),
+		subs:      make(map[*zmq.Conn]struct{}),
+	}
+
+	if conf.Address != "" {
+		address, err := zmqAddress(conf.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		t.listener, err = net.Listen("tcp4", address)
+		if err != nil {
+			return nil, err
+		}
+
+		t.wg.Add(1)
+		go t.runPub()
+	}
+
+	for _, address := range subAddresses {
+		t.wg.Add(1)
+		go t.runSub(address)
+	}
+
+	return t, nil
+}
+
+func (t *endpointZmqPubSub) isEndpoint() {}
+
+func (t *endpointZmqPubSub) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointZmqPubSub) Label() string {
+	if t.conf.Address != "" {
+		return "zmq:" + t.conf.Address
+	}
+	return "zmq:" + strings.Join(t.conf.SubAddresses, ",")
+}
+
+func (t *endpointZmqPubSub) Close() error {
+	close(t.terminate)
+
+	if t.listener != nil {
+		t.listener.Close()
+	}
+
+	func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		for conn := range t.pubs {
+			conn.Close()
+		}
+		for conn := range t.subs {
+			conn.Close()
+		}
+	}()
+
+	t.wg.Wait()
+	return nil
+}
+
+// runPub accepts the connections of SUB sockets.
+func (t *endpointZmqPubSub) runPub() {
+	defer t.wg.Done()
+
+	for {
+		nconn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+
+			conn, err := zmqHandshake(nconn, zmq.TypePub, t.terminate)
+			if err != nil {
+				return
+			}
+
+			if !t.addConn(t.pubs, conn) {
+				return
+			}
+			defer t.removeConn(t.pubs, conn)
+
+			// process subscriptions until the connection is closed
+			conn.Read(make([]byte, bufferSize))
+		}()
+	}
+}
+
+// runSub connects to a PUB socket, and reconnects when the connection is lost.
+func (t *endpointZmqPubSub) runSub(address string) {
+	defer t.wg.Done()
+
+	for {
+		conn, err := zmqDial(address, zmq.TypeSub, t.terminate)
+		if err == nil && t.addConn(t.subs, conn) {
+			buf := make([]byte, bufferSize)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					break
+				}
+
+				select {
+				case t.readChan <- append([]byte(nil), buf[:n]...):
+				case <-t.terminate:
+				}
+			}
+
+			t.removeConn(t.subs, conn)
+		}
+
+		// wait before reconnecting
+		select {
+		case <-time.After(netReconnectPeriod):
+		case <-t.terminate:
+			return
+		}
+	}
+}
+
+// addConn adds a connection, or closes it if the endpoint is closed.
+func (t *endpointZmqPubSub) addConn(conns map[*zmq.Conn]struct{}, conn *zmq.Conn) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	select {
+	case <-t.terminate:
+		conn.Close()
+		return false
+	default:
+	}
+
+	conns[conn] = struct{}{}
+	return true
+}
+
+func (t *endpointZmqPubSub) removeConn(conns map[*zmq.Conn]struct{}, conn *zmq.Conn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	conn.Close()
+	delete(conns, conn)
+}
+
+func (t *endpointZmqPubSub) Read(buf []byte) (int, error) {
+	if len(t.pending) == 0 {
+		select {
+		case t.pending = <-t.readChan:
+		case <-t.terminate:
+			return 0, errorTerminated
+		}
+	}
+
+	n := copy(buf, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// Write publishes a frame to all subscribed connections. Frames are always
+// written whole by the channel.
+func (t *endpointZmqPubSub) Write(buf []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for conn := range t.pubs {
+		conn.SetWriteDeadline(time.Now().Add(netWriteTimeout))
+		_, err := conn.Write(buf)
+		if err != nil {
+			// the connection is removed by its routine
+			conn.Close()
+		}
+	}
+
+	return len(buf), nil
+}
+
+type endpointZmqRep struct {
+	conf      EndpointZmq
+	listener  net.Listener
+	conns     chan *zmq.Conn
+	terminate chan struct{}
+}
+
+func initEndpointZmqRep(conf EndpointZmq) (Endpoint, error) {
+	address, err := zmqAddress(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &endpointZmqRep{
+		conf:      conf,
+		listener:  listener,
+		conns:     make(chan *zmq.Conn),
+		terminate: make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t, nil
+}
+
+func (t *endpointZmqRep) isEndpoint() {}
+
+func (t *endpointZmqRep) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointZmqRep) Close() error {
+	close(t.terminate)
+	t.listener.Close()
+	return nil
+}
+
+// run accepts connections, and performs handshakes in parallel, in order not
+// to be blocked by slow clients.
+func (t *endpointZmqRep) run() {
+	for {
+		nconn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			conn, err := zmqHandshake(nconn, zmq.TypeRep, t.terminate)
+			if err != nil {
+				return
+			}
+
+			select {
+			case t.conns <- conn:
+			case <-t.terminate:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (t *endpointZmqRep) Accept() (string, io.ReadWriteCloser, error) {
+	select {
+	case conn := <-t.conns:
+		label := fmt.Sprintf("zmq:%s", conn.RemoteAddr())
+		return label, &netTimedConn{conn: conn}, nil
+
+	case <-t.terminate:
+		return "", nil, errorTerminated
+	}
+}
@@ -0,0 +1,285 @@
+// Package esc implements a monitor that aggregates ESC and servo telemetry
+// (ESC_STATUS, ESC_INFO and SERVO_OUTPUT_RAW) of a vehicle into per-motor and
+// per-output structures, keeping track of when each value was last updated.
+//
+// The node to which the monitor is attached must use a dialect that contains
+// the common messages.
+package esc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// ESC messages contain data of 4 ESCs, starting from Index
+	escsPerMessage = 4
+
+	temperatureUnavailable = 0xFF
+)
+
+// Motor contains the telemetry of a motor and its ESC.
+type Motor struct {
+	// zero-based index of the ESC.
+	Index int
+
+	// whether ESC_STATUS has not been received in the last StaleTimeout.
+	StatusStale bool
+	// time of the last ESC_STATUS.
+	StatusTime time.Time
+	// rotation speed, negative in case of reverse rotation, in rpm.
+	RPM int32
+	// voltage, in V.
+	Voltage float32
+	// current, in A.
+	Current float32
+
+	// whether ESC_INFO has not been received in the last StaleTimeout.
+	InfoStale bool
+	// time of the last ESC_INFO.
+	InfoTime time.Time
+	// whether the ESC is online.
+	Online bool
+	// connection type of the ESC.
+	ConnectionType common.ESC_CONNECTION_TYPE
+	// failure flags.
+	FailureFlags common.ESC_FAILURE_FLAGS
+	// number of errors since boot.
+	ErrorCount uint32
+	// whether Temperature is provided by the ESC.
+	TemperatureValid bool
+	// temperature, in degC.
+	Temperature uint8
+}
+
+// Servo contains the value of a servo output.
+type Servo struct {
+	// output port (0 = MAIN, 1 = AUX on Pixhawk).
+	Port uint8
+	// one-based index of the output inside the port.
+	Channel int
+
+	// whether the output has not been updated in the last StaleTimeout.
+	Stale bool
+	// time of the last update.
+	Time time.Time
+	// value of the output, usually in microseconds.
+	Value uint16
+}
+
+type servoKey struct {
+	port    uint8
+	channel int
+}
+
+// Conf allows to configure a Monitor.
+type Conf struct {
+	// the node from which telemetry is read.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the duration after which a value that has not been updated
+	// is considered stale.
+	// It defaults to 1s.
+	StaleTimeout time.Duration
+}
+
+// Monitor aggregates ESC and servo telemetry.
+type Monitor struct {
+	conf          Conf
+	removeHandler func()
+
+	mutex    sync.Mutex
+	escCount int
+	motors   map[int]*Motor
+	servos   map[servoKey]*Servo
+}
+
+// New allocates a Monitor. See Conf for the options.
+func New(conf Conf) (*Monitor, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageEscStatus{},
+		&common.MessageEscInfo{},
+		&common.MessageServoOutputRaw{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.StaleTimeout == 0 {
+		conf.StaleTimeout = 1 * time.Second
+	}
+
+	m := &Monitor{
+		conf:   conf,
+		motors: make(map[int]*Motor),
+		servos: make(map[servoKey]*Servo),
+	}
+
+	m.removeHandler = conf.Node.AddFrameHandler(m.onEventFrame)
+
+	return m, nil
+}
+
+// Close detaches the monitor from the node.
+func (m *Monitor) Close() {
+	m.removeHandler()
+}
+
+// Motors returns the telemetry of all motors, sorted by index.
+func (m *Monitor) Motors() []Motor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+
+	ret := make([]Motor, 0, len(m.motors))
+	for _, mo := range m.motors {
+		// ESCs with an index higher than the count contain invalid data
+		if m.escCount != 0 && mo.Index >= m.escCount {
+			continue
+		}
+
+		c := *mo
+		c.StatusStale = now.Sub(c.StatusTime) >= m.conf.StaleTimeout
+		c.InfoStale = now.Sub(c.InfoTime) >= m.conf.StaleTimeout
+		ret = append(ret, c)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Index < ret[j].Index
+	})
+	return ret
+}
+
+// Servos returns the value of all servo outputs, sorted by port and channel.
+func (m *Monitor) Servos() []Servo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+
+	ret := make([]Servo, 0, len(m.servos))
+	for _, s := range m.servos {
+		c := *s
+		c.Stale = now.Sub(c.Time) >= m.conf.StaleTimeout
+		ret = append(ret, c)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Port != ret[j].Port {
+			return ret[i].Port < ret[j].Port
+		}
+		return ret[i].Channel < ret[j].Channel
+	})
+	return ret
+}
+
+func (m *Monitor) motor(index int) *Motor {
+	mo, ok := m.motors[index]
+	if !ok {
+		mo = &Motor{Index: index}
+		m.motors[index] = mo
+	}
+	return mo
+}
+
+func (m *Monitor) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != m.conf.SystemId {
+		return
+	}
+
+	now := time.Now()
+
+	switch evt.Message().GetId() {
+	case (&common.MessageEscStatus{}).GetId():
+		var st common.MessageEscStatus
+		if msg.Convert(&st, evt.Message()) != nil {
+			return
+		}
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		for i := 0; i < escsPerMessage; i++ {
+			mo := m.motor(int(st.Index) + i)
+			mo.StatusTime = now
+			mo.RPM = st.Rpm[i]
+			mo.Voltage = st.Voltage[i]
+			mo.Current = st.Current[i]
+		}
+
+	case (&common.MessageEscInfo{}).GetId():
+		var info common.MessageEscInfo
+		if msg.Convert(&info, evt.Message()) != nil {
+			return
+		}
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.escCount = int(info.Count)
+
+		for i := 0; i < escsPerMessage; i++ {
+			mo := m.motor(int(info.Index) + i)
+			mo.InfoTime = now
+			mo.Online = (info.Info & (1 << uint(i))) != 0
+			mo.ConnectionType = info.ConnectionType
+			mo.FailureFlags = info.FailureFlags[i]
+			mo.ErrorCount = info.ErrorCount[i]
+			mo.TemperatureValid = (info.Temperature[i] != temperatureUnavailable)
+			mo.Temperature = info.Temperature[i]
+		}
+
+	case (&common.MessageServoOutputRaw{}).GetId():
+		var raw common.MessageServoOutputRaw
+		if msg.Convert(&raw, evt.Message()) != nil {
+			return
+		}
+
+		values := []uint16{
+			raw.Servo1Raw, raw.Servo2Raw, raw.Servo3Raw, raw.Servo4Raw,
+			raw.Servo5Raw, raw.Servo6Raw, raw.Servo7Raw, raw.Servo8Raw,
+			raw.Servo9Raw, raw.Servo10Raw, raw.Servo11Raw, raw.Servo12Raw,
+			raw.Servo13Raw, raw.Servo14Raw, raw.Servo15Raw, raw.Servo16Raw,
+		}
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		for i, v := range values {
+			// outputs 9-16 are extensions, that are zero when not provided
+			if i >= 8 && v == 0 {
+				continue
+			}
+
+			key := servoKey{raw.Port, i + 1}
+			s, ok := m.servos[key]
+			if !ok {
+				s = &Servo{Port: raw.Port, Channel: i + 1}
+				m.servos[key] = s
+			}
+			s.Time = now
+			s.Value = v
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package esc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestMonitor(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5660"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5660"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	m, err := New(Conf{
+		Node:         node1,
+		SystemId:     1,
+		StaleTimeout: 300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	var motors []Motor
+	var servos []Servo
+	for i := 0; i < 100; i++ {
+		node2.WriteMessageAll(&common.MessageEscInfo{
+			Index:          0,
+			Count:          2,
+			ConnectionType: common.ESC_CONNECTION_TYPE_SERIAL,
+			Info:           0x01,
+			ErrorCount:     [4]uint32{3, 0, 0, 0},
+			Temperature:    [4]uint8{45, 0xFF, 0, 0},
+		})
+		node2.WriteMessageAll(&common.MessageEscStatus{
+			Index:   0,
+			Rpm:     [4]int32{1000, -2000, 0, 0},
+			Voltage: [4]float32{12.5, 12.4, 0, 0},
+			Current: [4]float32{3, 4, 0, 0},
+		})
+		node2.WriteMessageAll(&common.MessageServoOutputRaw{
+			Port:      1,
+			Servo1Raw: 1100,
+			Servo2Raw: 1200,
+		})
+
+		time.Sleep(10 * time.Millisecond)
+
+		motors = m.Motors()
+		servos = m.Servos()
+		if len(motors) == 2 && !motors[0].StatusStale && len(servos) == 8 {
+			break
+		}
+	}
+
+	require.Equal(t, 2, len(motors))
+
+	require.Equal(t, 0, motors[0].Index)
+	require.Equal(t, int32(1000), motors[0].RPM)
+	require.Equal(t, float32(12.5), motors[0].Voltage)
+	require.Equal(t, float32(3), motors[0].Current)
+	require.Equal(t, true, motors[0].Online)
+	require.Equal(t, common.ESC_CONNECTION_TYPE_SERIAL, motors[0].ConnectionType)
+	require.Equal(t, uint32(3), motors[0].ErrorCount)
+	require.Equal(t, true, motors[0].TemperatureValid)
+	require.Equal(t, uint8(45), motors[0].Temperature)
+
+	require.Equal(t, 1, motors[1].Index)
+	require.Equal(t, int32(-2000), motors[1].RPM)
+	require.Equal(t, false, motors[1].Online)
+	require.Equal(t, false, motors[1].TemperatureValid)
+
+	require.Equal(t, 8, len(servos))
+	require.Equal(t, Servo{Port: 1, Channel: 1, Time: servos[0].Time, Value: 1100}, servos[0])
+	require.Equal(t, uint16(1200), servos[1].Value)
+
+	time.Sleep(300 * time.Millisecond)
+
+	motors = m.Motors()
+	require.Equal(t, true, motors[0].StatusStale)
+	require.Equal(t, true, motors[0].InfoStale)
+	require.Equal(t, true, m.Servos()[0].Stale)
+}
@@ -1,6 +1,8 @@
 package gomavlib
 
 import (
+	"time"
+
 	"github.com/aler9/gomavlib/frame"
 	"github.com/aler9/gomavlib/msg"
 )
@@ -20,10 +22,47 @@ func (*EventChannelOpen) isEventOut() {}
 // EventChannelClose is the event fired when a channel gets closed.
 type EventChannelClose struct {
 	Channel *Channel
+
+	// the error that caused the closure, i.e. a disconnection, or nil if the
+	// channel was closed deliberately, by Close() or RemoveEndpoint().
+	Error error
 }
 
 func (*EventChannelClose) isEventOut() {}
 
+// EventEndpointStop is the event fired when an endpoint is stopped by
+// Close(), after the closure of its channels. It is emitted when
+// NodeConf.ShutdownEventsEnable is true.
+type EventEndpointStop struct {
+	// the endpoint
+	Endpoint Endpoint
+}
+
+func (*EventEndpointStop) isEventOut() {}
+
+// EventNodeTerminated is the event fired when the node has been closed by
+// Close(). It is the last event before the closure of Events(), and it is
+// emitted when NodeConf.ShutdownEventsEnable is true.
+type EventNodeTerminated struct{}
+
+func (*EventNodeTerminated) isEventOut() {}
+
+// EventEndpointGiveUp is the event fired when a client endpoint gives up
+// connecting, after the maximum number of attempts of its ReconnectPolicy.
+// It is followed by the closure of the channel of the endpoint.
+type EventEndpointGiveUp struct {
+	// the channel of the endpoint
+	Channel *Channel
+
+	// the number of failed attempts
+	Attempts int
+
+	// the error of the last attempt
+	Error error
+}
+
+func (*EventEndpointGiveUp) isEventOut() {}
+
 // EventFrame is the event fired when a frame is received.
 type EventFrame struct {
 	// the frame
@@ -31,6 +70,11 @@ type EventFrame struct {
 
 	// the channel from which the frame was received
 	Channel *Channel
+
+	// the time at which the frame was received. It contains both a wall clock
+	// reading and a monotonic clock reading, therefore it can be used both to
+	// timestamp the frame and to measure durations.
+	ReceiveTime time.Time
 }
 
 func (*EventFrame) isEventOut() {}
@@ -61,6 +105,21 @@ type EventParseError struct {
 
 func (*EventParseError) isEventOut() {}
 
+// EventVersionDowngrade is the event fired when a system that was sending V2
+// frames through a channel starts sending V1 frames, therefore losing message
+// extensions and signatures. It is usually caused by misconfigured radios or
+// firmware downgrades.
+type EventVersionDowngrade struct {
+	// the channel from which the V1 frame was received
+	Channel *Channel
+	// the system id of the frame
+	SystemId byte
+	// the component id of the frame
+	ComponentId byte
+}
+
+func (*EventVersionDowngrade) isEventOut() {}
+
 // EventStreamRequested is the event fired when an automatic stream request is sent.
 type EventStreamRequested struct {
 	// the channel to which the stream request is addressed
@@ -72,3 +131,60 @@ type EventStreamRequested struct {
 }
 
 func (*EventStreamRequested) isEventOut() {}
+
+// EventComponentAppear is the event fired when a component sends its first
+// heartbeat through any channel. It is emitted before the frame that
+// contains the heartbeat, when NodeConf.ComponentEventsEnable is true.
+type EventComponentAppear struct {
+	// the channel from which the heartbeat was received
+	Channel *Channel
+	// the system id of the component
+	SystemId byte
+	// the component id of the component
+	ComponentId byte
+	// the type of the component (MAV_TYPE)
+	Type int
+	// the autopilot type (MAV_AUTOPILOT)
+	Autopilot int
+}
+
+func (*EventComponentAppear) isEventOut() {}
+
+// EventComponentDisappear is the event fired when a component is not seen
+// anymore on any channel, since its heartbeats have timed out or its channels
+// have been closed. It is emitted when NodeConf.ComponentEventsEnable is true.
+type EventComponentDisappear struct {
+	// the system id of the component
+	SystemId byte
+	// the component id of the component
+	ComponentId byte
+}
+
+func (*EventComponentDisappear) isEventOut() {}
+
+// EventSerialDevicePlugged is the event fired when a serial device is
+// plugged, or when it is found at startup. It is emitted when
+// NodeConf.SerialHotplugEnable is true.
+type EventSerialDevicePlugged struct {
+	// the device
+	Device SerialDevice
+	// the endpoint attached to the device, when NodeConf.SerialHotplugAttach
+	// is true and the attachment succeeded
+	Endpoint Endpoint
+	// the error that prevented the attachment, if any
+	AttachError error
+}
+
+func (*EventSerialDevicePlugged) isEventOut() {}
+
+// EventSerialDeviceUnplugged is the event fired when a serial device is
+// unplugged. It is emitted when NodeConf.SerialHotplugEnable is true.
+type EventSerialDeviceUnplugged struct {
+	// the device
+	Device SerialDevice
+	// the endpoint that was attached to the device, that has been removed
+	// from the node
+	Endpoint Endpoint
+}
+
+func (*EventSerialDeviceUnplugged) isEventOut() {}
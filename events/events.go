@@ -0,0 +1,404 @@
+// Package events implements a receiver of the events interface, the protocol
+// that PX4 uses to report events to ground stations in place of STATUSTEXT.
+//
+// The receiver tracks the sequence numbers of EVENT messages of each
+// component, detects lost events with CURRENT_EVENT_SEQUENCE and sequence gaps,
+// and requests them again with REQUEST_EVENT. Messages of events can be
+// rendered by providing the event metadata of the vehicle.
+//
+// The node to which the receiver is attached must use a dialect that contains
+// the messages of this package, that can be added with RegisterMessages().
+package events
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	frameQueueSize = 64
+
+	// the maximum number of lost events that are requested again
+	maxMissing = 64
+)
+
+// LogLevel is the log level of an event.
+type LogLevel int
+
+// log levels.
+const (
+	LogLevelEmergency LogLevel = iota
+	LogLevelAlert
+	LogLevelCritical
+	LogLevelError
+	LogLevelWarning
+	LogLevelNotice
+	LogLevelInfo
+	LogLevelDebug
+	LogLevelProtocol
+	LogLevelDisabled
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelEmergency: "emergency",
+	LogLevelAlert:     "alert",
+	LogLevelCritical:  "critical",
+	LogLevelError:     "error",
+	LogLevelWarning:   "warning",
+	LogLevelNotice:    "notice",
+	LogLevelInfo:      "info",
+	LogLevelDebug:     "debug",
+	LogLevelProtocol:  "protocol",
+	LogLevelDisabled:  "disabled",
+}
+
+// String implements fmt.Stringer.
+func (l LogLevel) String() string {
+	if n, ok := logLevelNames[l]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// Event is an event received from a component.
+type Event struct {
+	// the system id of the component that emitted the event.
+	SystemId byte
+
+	// the component id of the component that emitted the event.
+	ComponentId byte
+
+	// the event id.
+	Id uint32
+
+	// the sequence number.
+	Sequence uint16
+
+	// the time since boot at which the event happened.
+	TimeBoot time.Duration
+
+	// the log level of the event, that is used to decide whether to show it.
+	LogLevel LogLevel
+
+	// the log level used for logging purposes.
+	InternalLogLevel LogLevel
+
+	// the arguments, whose format depends on the event id.
+	Arguments [40]byte
+
+	// the message of the event. It is filled only if the event is contained
+	// in the metadata.
+	Message string
+
+	// the description of the event. It is filled only if the event is
+	// contained in the metadata.
+	Description string
+}
+
+// Conf allows to configure a Receiver.
+type Conf struct {
+	// the node from which events are read.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// called when an event is received.
+	// It is called by a dedicated routine, one event at a time.
+	// Events requested again after a loss are received after newer events.
+	OnEvent func(Event)
+
+	// (optional) the metadata used to render the messages of events.
+	Metadata *Metadata
+}
+
+type sequenceState struct {
+	ch       *gomavlib.Channel
+	started  bool
+	expected uint16
+	missing  map[uint16]struct{}
+}
+
+// Receiver receives events from the components of a vehicle.
+type Receiver struct {
+	conf          Conf
+	removeHandler func()
+
+	// accessed by run() only
+	states map[byte]*sequenceState
+
+	frames    chan *gomavlib.EventFrame
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Receiver. See Conf for the options.
+func New(conf Conf) (*Receiver, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.OnEvent == nil {
+		return nil, fmt.Errorf("OnEvent not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&MessageEvent{},
+		&MessageCurrentEventSequence{},
+		&MessageRequestEvent{},
+		&MessageResponseEventError{})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Receiver{
+		conf:      conf,
+		states:    make(map[byte]*sequenceState),
+		frames:    make(chan *gomavlib.EventFrame, frameQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	r.removeHandler = conf.Node.AddFrameHandler(r.onEventFrame)
+
+	go r.run()
+
+	return r, nil
+}
+
+// Close stops the receiver. It must be called before closing the node.
+func (r *Receiver) Close() {
+	r.removeHandler()
+	close(r.terminate)
+	<-r.done
+}
+
+func (r *Receiver) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != r.conf.SystemId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&MessageEvent{}).GetId(),
+		(&MessageCurrentEventSequence{}).GetId(),
+		(&MessageResponseEventError{}).GetId():
+
+	default:
+		return
+	}
+
+	// frame handlers must not block; frames are dropped when the queue is
+	// full, and lost events are requested again
+	select {
+	case r.frames <- evt:
+	default:
+	}
+}
+
+func (r *Receiver) run() {
+	defer close(r.done)
+
+	for {
+		select {
+		case evt := <-r.frames:
+			r.process(evt)
+
+		case <-r.terminate:
+			return
+		}
+	}
+}
+
+func (r *Receiver) isTarget(systemId uint8) bool {
+	return systemId == 0 || systemId == r.conf.Node.Conf().OutSystemId
+}
+
+func (r *Receiver) state(evt *gomavlib.EventFrame) *sequenceState {
+	st, ok := r.states[evt.ComponentId()]
+	if !ok {
+		st = &sequenceState{
+			missing: make(map[uint16]struct{}),
+		}
+		r.states[evt.ComponentId()] = st
+	}
+	st.ch = evt.Channel
+	return st
+}
+
+func (r *Receiver) process(evt *gomavlib.EventFrame) {
+	switch evt.Message().GetId() {
+	case (&MessageEvent{}).GetId():
+		var m MessageEvent
+		if msg.Convert(&m, evt.Message()) != nil || !r.isTarget(m.DestinationSystem) {
+			return
+		}
+		r.processEvent(evt, &m)
+
+	case (&MessageCurrentEventSequence{}).GetId():
+		var m MessageCurrentEventSequence
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		r.processCurrentSequence(evt, &m)
+
+	case (&MessageResponseEventError{}).GetId():
+		var m MessageResponseEventError
+		if msg.Convert(&m, evt.Message()) != nil || !r.isTarget(m.TargetSystem) {
+			return
+		}
+		r.processError(evt, &m)
+	}
+}
+
+func (r *Receiver) processEvent(evt *gomavlib.EventFrame, m *MessageEvent) {
+	st := r.state(evt)
+
+	if !st.started {
+		st.started = true
+		st.expected = m.Sequence + 1
+		r.emit(evt, m)
+		return
+	}
+
+	// sequence numbers wrap around
+	diff := int16(m.Sequence - st.expected)
+
+	switch {
+	case diff == 0:
+		st.expected++
+		r.emit(evt, m)
+
+	case diff > 0:
+		r.addMissing(st, st.expected, m.Sequence-1)
+		st.expected = m.Sequence + 1
+		r.request(evt, st)
+		r.emit(evt, m)
+
+	default:
+		if _, ok := st.missing[m.Sequence]; ok {
+			delete(st.missing, m.Sequence)
+			r.emit(evt, m)
+		}
+	}
+}
+
+func (r *Receiver) processCurrentSequence(evt *gomavlib.EventFrame, m *MessageCurrentEventSequence) {
+	st := r.state(evt)
+
+	// do not request events that precede the start of the receiver or a reset
+	if !st.started || (m.Flags&CurrentSequenceFlagReset) != 0 {
+		st.started = true
+		st.expected = m.Sequence + 1
+		st.missing = make(map[uint16]struct{})
+		return
+	}
+
+	if int16(m.Sequence-st.expected) >= 0 {
+		r.addMissing(st, st.expected, m.Sequence)
+		st.expected = m.Sequence + 1
+	}
+
+	// requests are repeated until events are received
+	r.request(evt, st)
+}
+
+func (r *Receiver) processError(evt *gomavlib.EventFrame, m *MessageResponseEventError) {
+	st := r.state(evt)
+
+	// events older than the oldest available one are lost
+	for seq := range st.missing {
+		if int16(seq-m.SequenceOldestAvailable) < 0 {
+			delete(st.missing, seq)
+		}
+	}
+}
+
+func (r *Receiver) addMissing(st *sequenceState, first uint16, last uint16) {
+	count := int(last-first) + 1
+	if count > maxMissing {
+		first = last - maxMissing + 1
+	}
+
+	for seq := first; ; seq++ {
+		st.missing[seq] = struct{}{}
+		if seq == last {
+			break
+		}
+	}
+
+	// remove the oldest missing events
+	for len(st.missing) > maxMissing {
+		oldest := first
+		for seq := range st.missing {
+			if int16(seq-oldest) < 0 {
+				oldest = seq
+			}
+		}
+		delete(st.missing, oldest)
+	}
+}
+
+// request sends a REQUEST_EVENT for each range of missing events.
+func (r *Receiver) request(evt *gomavlib.EventFrame, st *sequenceState) {
+	if len(st.missing) == 0 {
+		return
+	}
+
+	// sort sequences relatively to the expected one, to handle wrap around
+	seqs := make([]uint16, 0, len(st.missing))
+	for seq := range st.missing {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i]-st.expected < seqs[j]-st.expected
+	})
+
+	first := seqs[0]
+	for i := 1; i <= len(seqs); i++ {
+		if i < len(seqs) && seqs[i] == seqs[i-1]+1 {
+			continue
+		}
+
+		r.conf.Node.WriteMessageTo(st.ch, &MessageRequestEvent{
+			TargetSystem:    evt.SystemId(),
+			TargetComponent: evt.ComponentId(),
+			FirstSequence:   first,
+			LastSequence:    seqs[i-1],
+		})
+
+		if i < len(seqs) {
+			first = seqs[i]
+		}
+	}
+}
+
+func (r *Receiver) emit(evt *gomavlib.EventFrame, m *MessageEvent) {
+	e := Event{
+		SystemId:         evt.SystemId(),
+		ComponentId:      evt.ComponentId(),
+		Id:               m.Id,
+		Sequence:         m.Sequence,
+		TimeBoot:         time.Duration(m.EventTimeBootMs) * time.Millisecond,
+		LogLevel:         LogLevel(m.LogLevels & 0x0F),
+		InternalLogLevel: LogLevel(m.LogLevels >> 4),
+		Arguments:        m.Arguments,
+	}
+
+	if r.conf.Metadata != nil {
+		e.Message, e.Description, _ = r.conf.Metadata.Render(m.Id, m.Arguments[:])
+	}
+
+	r.conf.OnEvent(e)
+}
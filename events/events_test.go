@@ -0,0 +1,179 @@
+package events
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func TestRegisterMessages(t *testing.T) {
+	d := &dialect.Dialect{
+		Version:  3,
+		Messages: []msg.Message{&common.MessageHeartbeat{}},
+	}
+
+	err := RegisterMessages(d)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(d.Messages))
+
+	// registering twice is allowed
+	err = RegisterMessages(d)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(d.Messages))
+}
+
+func TestReceiver(t *testing.T) {
+	d := &dialect.Dialect{
+		Version:  3,
+		Messages: append([]msg.Message(nil), common.Dialect.Messages...),
+	}
+	require.NoError(t, RegisterMessages(d))
+
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     d,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5720"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	md, err := ParseMetadata(strings.NewReader(testMetadata))
+	require.NoError(t, err)
+
+	events := make(chan Event, 10)
+	r, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(e Event) {
+			events <- e
+		},
+		Metadata: md,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	vehicle, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     d,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5720"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer vehicle.Close()
+
+	// the vehicle must send a datagram before the GCS can reach it
+	for len(gcs.Channels()) == 0 {
+		vehicle.WriteMessageAll(&common.MessageHeartbeat{})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	sendEvent := func(seq uint16) {
+		vehicle.WriteMessageAll(&MessageEvent{
+			Id:              1<<24 | 1000,
+			EventTimeBootMs: 1000 + uint32(seq),
+			Sequence:        seq,
+			LogLevels:       0x64,
+			Arguments:       [40]uint8{1},
+		})
+	}
+
+	waitEvent := func() Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(1 * time.Second):
+			t.Fatal("event not received")
+		}
+		return Event{}
+	}
+
+	waitRequest := func() *MessageRequestEvent {
+		timeout := time.After(1 * time.Second)
+		for {
+			select {
+			case evt := <-vehicle.Events():
+				if fr, ok := evt.(*gomavlib.EventFrame); ok {
+					if m, ok := fr.Message().(*MessageRequestEvent); ok {
+						return m
+					}
+				}
+			case <-timeout:
+				return nil
+			}
+		}
+	}
+
+	sendEvent(10)
+	e := waitEvent()
+	require.Equal(t, byte(1), e.SystemId)
+	require.Equal(t, uint16(10), e.Sequence)
+	require.Equal(t, 1010*time.Millisecond, e.TimeBoot)
+	require.Equal(t, LogLevelWarning, e.LogLevel)
+	require.Equal(t, LogLevelInfo, e.InternalLogLevel)
+	require.Equal(t, "Arming denied in state Standby", e.Message)
+
+	// events 11 and 12 are lost
+	sendEvent(13)
+	require.Equal(t, uint16(13), waitEvent().Sequence)
+
+	req := waitRequest()
+	require.NotNil(t, req)
+	require.Equal(t, uint8(1), req.TargetSystem)
+	require.Equal(t, uint16(11), req.FirstSequence)
+	require.Equal(t, uint16(12), req.LastSequence)
+
+	sendEvent(11)
+	sendEvent(12)
+	require.Equal(t, uint16(11), waitEvent().Sequence)
+	require.Equal(t, uint16(12), waitEvent().Sequence)
+
+	// duplicates are discarded
+	sendEvent(12)
+
+	// events 14 and 15 are lost and not available anymore
+	vehicle.WriteMessageAll(&MessageCurrentEventSequence{Sequence: 15})
+
+	req = waitRequest()
+	require.NotNil(t, req)
+	require.Equal(t, uint16(14), req.FirstSequence)
+	require.Equal(t, uint16(15), req.LastSequence)
+
+	vehicle.WriteMessageAll(&MessageResponseEventError{
+		TargetSystem:            255,
+		Sequence:                14,
+		SequenceOldestAvailable: 16,
+		Reason:                  ErrorReasonUnavailable,
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	vehicle.WriteMessageAll(&MessageCurrentEventSequence{Sequence: 15})
+	require.Nil(t, waitRequest())
+
+	sendEvent(16)
+	require.Equal(t, uint16(16), waitEvent().Sequence)
+
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event: %v", e)
+	default:
+	}
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// CurrentSequenceFlagReset is set in CURRENT_EVENT_SEQUENCE when the
+	// sequence has been reset, i.e. after a reboot.
+	CurrentSequenceFlagReset = 1
+
+	// ErrorReasonUnavailable is the reason of RESPONSE_EVENT_ERROR when the
+	// requested event is not available anymore.
+	ErrorReasonUnavailable = 0
+)
+
+// Messages of the events interface, that are not part of the generated dialects.
+// They can be added to a dialect with RegisterMessages().
+
+// MessageEvent is an event message.
+type MessageEvent struct {
+	// Component ID
+	DestinationComponent uint8
+	// System ID
+	DestinationSystem uint8
+	// Event ID (as defined in the component metadata)
+	Id uint32
+	// Timestamp (time since system boot when the event happened).
+	EventTimeBootMs uint32
+	// Sequence number.
+	Sequence uint16
+	// Log levels: 4 bits MSB: internal (for logging purposes), 4 bits LSB: external.
+	LogLevels uint8
+	// Arguments (depend on event ID).
+	Arguments [40]uint8
+}
+
+// GetId implements the msg.Message interface.
+func (*MessageEvent) GetId() uint32 {
+	return 410
+}
+
+// MessageCurrentEventSequence contains the latest sequence number of events.
+// It is sent regularly, in order to allow the detection of lost events.
+type MessageCurrentEventSequence struct {
+	// Sequence number.
+	Sequence uint16
+	// Flag bitset.
+	Flags uint8
+}
+
+// GetId implements the msg.Message interface.
+func (*MessageCurrentEventSequence) GetId() uint32 {
+	return 411
+}
+
+// MessageRequestEvent requests one or more events to be (re-)sent.
+type MessageRequestEvent struct {
+	// System ID
+	TargetSystem uint8
+	// Component ID
+	TargetComponent uint8
+	// First sequence number of the requested event.
+	FirstSequence uint16
+	// Last sequence number of the requested event.
+	LastSequence uint16
+}
+
+// GetId implements the msg.Message interface.
+func (*MessageRequestEvent) GetId() uint32 {
+	return 412
+}
+
+// MessageResponseEventError is the response to REQUEST_EVENT in case of
+// an error.
+type MessageResponseEventError struct {
+	// System ID
+	TargetSystem uint8
+	// Component ID
+	TargetComponent uint8
+	// Sequence number.
+	Sequence uint16
+	// Oldest Sequence number that is still available after the sequence set
+	// in REQUEST_EVENT.
+	SequenceOldestAvailable uint16
+	// Error reason.
+	Reason uint8
+}
+
+// GetId implements the msg.Message interface.
+func (*MessageResponseEventError) GetId() uint32 {
+	return 413
+}
+
+// RegisterMessages adds the messages of the events interface to a dialect.
+// Messages that are already in the dialect are kept.
+func RegisterMessages(d *dialect.Dialect) error {
+	for _, m := range []msg.Message{
+		&MessageEvent{},
+		&MessageCurrentEventSequence{},
+		&MessageRequestEvent{},
+		&MessageResponseEventError{},
+	} {
+		err := d.RegisterMessage(m, dialect.CollisionKeep)
+		if err != nil {
+			return err
+		}
+	}
+
+	// check that the messages kept are compatible
+	return d.CheckMessages(
+		&MessageEvent{},
+		&MessageCurrentEventSequence{},
+		&MessageRequestEvent{},
+		&MessageResponseEventError{})
+}
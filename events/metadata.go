@@ -0,0 +1,226 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var argumentTypeSizes = map[string]int{
+	"uint8_t":  1,
+	"int8_t":   1,
+	"uint16_t": 2,
+	"int16_t":  2,
+	"uint32_t": 4,
+	"int32_t":  4,
+	"uint64_t": 8,
+	"int64_t":  8,
+	"float":    4,
+}
+
+var (
+	// placeholders, i.e. {1}, {2:.1} or {3:.1m}
+	rePlaceholder = regexp.MustCompile(`\{(\d+)(?::\.(\d+))?([^}]*)\}`)
+
+	// tags, i.e. <param>NAME</param> or <profile name="dev">
+	reTag = regexp.MustCompile(`</?[a-z]+[^>]*>`)
+)
+
+type metadataEnumEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type metadataEnum struct {
+	Type       string                       `json:"type"`
+	Entries    map[string]metadataEnumEntry `json:"entries"`
+	IsBitfield bool                         `json:"is_bitfield"`
+}
+
+type metadataArgument struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type metadataEvent struct {
+	Name        string             `json:"name"`
+	Message     string             `json:"message"`
+	Description string             `json:"description"`
+	Arguments   []metadataArgument `json:"arguments"`
+}
+
+type metadataComponent struct {
+	Namespace   string                  `json:"namespace"`
+	Enums       map[string]metadataEnum `json:"enums"`
+	EventGroups map[string]struct {
+		Events map[string]metadataEvent `json:"events"`
+	} `json:"event_groups"`
+}
+
+type metadataFile struct {
+	Version    int                          `json:"version"`
+	Components map[string]metadataComponent `json:"components"`
+}
+
+// Metadata contains the definitions of events, that allow to render their
+// messages. It is read from the JSON file generated by libevents (events.json),
+// that is produced when building the firmware of the vehicle.
+type Metadata struct {
+	events map[uint32]*metadataEvent
+	enums  map[string]*metadataEnum
+}
+
+// ParseMetadata reads Metadata from a JSON file.
+func ParseMetadata(r io.Reader) (*Metadata, error) {
+	var f metadataFile
+	err := json.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{
+		events: make(map[uint32]*metadataEvent),
+		enums:  make(map[string]*metadataEnum),
+	}
+
+	for compId, comp := range f.Components {
+		cid, err := strconv.ParseUint(compId, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component id: %s", compId)
+		}
+
+		for name, enum := range comp.Enums {
+			enum := enum
+			md.enums[name] = &enum
+		}
+
+		for _, group := range comp.EventGroups {
+			for subId, evt := range group.Events {
+				sid, err := strconv.ParseUint(subId, 10, 24)
+				if err != nil {
+					return nil, fmt.Errorf("invalid event id: %s", subId)
+				}
+
+				evt := evt
+				md.events[uint32(cid)<<24|uint32(sid)] = &evt
+			}
+		}
+	}
+
+	return md, nil
+}
+
+// Render returns the message and the description of an event.
+// It returns false if the event is not in the metadata.
+func (md *Metadata) Render(id uint32, arguments []byte) (string, string, bool) {
+	evt, ok := md.events[id]
+	if !ok {
+		return "", "", false
+	}
+
+	// decode arguments
+	var values []string
+	offset := 0
+	for _, arg := range evt.Arguments {
+		typ := arg.Type
+		enum, isEnum := md.enums[typ]
+		if isEnum {
+			typ = enum.Type
+		}
+
+		size, ok := argumentTypeSizes[typ]
+		if !ok || offset+size > len(arguments) {
+			break
+		}
+		buf := arguments[offset : offset+size]
+		offset += size
+
+		if isEnum {
+			values = append(values, md.renderEnum(enum, decodeUnsigned(buf)))
+		} else {
+			values = append(values, decodeArgument(typ, buf))
+		}
+	}
+
+	render := func(text string) string {
+		text = rePlaceholder.ReplaceAllStringFunc(text, func(p string) string {
+			parts := rePlaceholder.FindStringSubmatch(p)
+
+			i, _ := strconv.Atoi(parts[1])
+			if i < 1 || i > len(values) {
+				return p
+			}
+			v := values[i-1]
+
+			if parts[2] != "" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					prec, _ := strconv.Atoi(parts[2])
+					v = strconv.FormatFloat(f, 'f', prec, 64)
+				}
+			}
+
+			// unit
+			return v + parts[3]
+		})
+		return reTag.ReplaceAllString(text, "")
+	}
+
+	return render(evt.Message), render(evt.Description), true
+}
+
+func (md *Metadata) renderEnum(enum *metadataEnum, v uint64) string {
+	entry := func(v uint64) string {
+		if e, ok := enum.Entries[strconv.FormatUint(v, 10)]; ok {
+			if e.Description != "" {
+				return e.Description
+			}
+			return e.Name
+		}
+		return strconv.FormatUint(v, 10)
+	}
+
+	if !enum.IsBitfield {
+		return entry(v)
+	}
+
+	var ret []string
+	for bit := uint(0); bit < 64; bit++ {
+		if v&(1<<bit) != 0 {
+			ret = append(ret, entry(1<<bit))
+		}
+	}
+	return strings.Join(ret, ", ")
+}
+
+func decodeUnsigned(buf []byte) uint64 {
+	switch len(buf) {
+	case 1:
+		return uint64(buf[0])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(buf))
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(buf))
+	}
+	return binary.LittleEndian.Uint64(buf)
+}
+
+func decodeArgument(typ string, buf []byte) string {
+	switch typ {
+	case "int8_t":
+		return strconv.FormatInt(int64(int8(buf[0])), 10)
+	case "int16_t":
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf))), 10)
+	case "int32_t":
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf))), 10)
+	case "int64_t":
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(buf)), 10)
+	case "float":
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))), 'g', -1, 32)
+	}
+	return strconv.FormatUint(decodeUnsigned(buf), 10)
+}
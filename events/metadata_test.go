@@ -0,0 +1,84 @@
+package events
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testMetadata = `{
+  "version": 2,
+  "components": {
+    "1": {
+      "namespace": "px4",
+      "enums": {
+        "px4::enums::arming_state_t": {
+          "type": "uint8_t",
+          "is_bitfield": false,
+          "entries": {
+            "0": {"name": "init", "description": "Init"},
+            "1": {"name": "standby", "description": "Standby"}
+          }
+        },
+        "px4::enums::sensor_t": {
+          "type": "uint16_t",
+          "is_bitfield": true,
+          "entries": {
+            "1": {"name": "gyro", "description": "Gyro"},
+            "4": {"name": "mag", "description": "Magnetometer"}
+          }
+        }
+      },
+      "event_groups": {
+        "default": {
+          "events": {
+            "1000": {
+              "name": "arming_denied",
+              "message": "Arming denied in state {1}",
+              "description": "Set <param>COM_ARM_CHK</param> to disable checks",
+              "arguments": [{"type": "px4::enums::arming_state_t", "name": "state"}]
+            },
+            "1001": {
+              "name": "battery_low",
+              "message": "Battery voltage {1:.1V} below {2}%, sensors: {3}",
+              "arguments": [
+                {"type": "float", "name": "voltage"},
+                {"type": "int8_t", "name": "percent"},
+                {"type": "px4::enums::sensor_t", "name": "sensors"}
+              ]
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestMetadataRender(t *testing.T) {
+	md, err := ParseMetadata(strings.NewReader(testMetadata))
+	require.NoError(t, err)
+
+	message, description, ok := md.Render(1<<24|1000, []byte{1})
+	require.Equal(t, true, ok)
+	require.Equal(t, "Arming denied in state Standby", message)
+	require.Equal(t, "Set COM_ARM_CHK to disable checks", description)
+
+	v := math.Float32bits(10.56)
+	args := []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), 0xFB, 5, 0}
+	message, _, ok = md.Render(1<<24|1001, args)
+	require.Equal(t, true, ok)
+	require.Equal(t, "Battery voltage 10.6V below -5%, sensors: Gyro, Magnetometer", message)
+
+	_, _, ok = md.Render(1000, nil)
+	require.Equal(t, false, ok)
+}
+
+func TestMetadataInvalid(t *testing.T) {
+	_, err := ParseMetadata(strings.NewReader(`{"components": {"abc": {}}}`))
+	require.Error(t, err)
+
+	_, err = ParseMetadata(strings.NewReader(`{`))
+	require.Error(t, err)
+}
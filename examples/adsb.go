@@ -0,0 +1,51 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/adsb"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a vehicle through a serial port
+	// - understands common dialect
+	// - writes messages with the system id of a ground station
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// write the state of aircraft to all channels, twice per second
+	bridge, err := adsb.New(adsb.Conf{
+		Node: node,
+		Rate: 2,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer bridge.Close()
+
+	// read aircraft from the SBS-1 feed of a receiver
+	conn, err := net.Dial("tcp", "192.168.1.10:30003")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	err = bridge.ReadSBS(conn)
+	fmt.Printf("feed closed: %v\n", err)
+}
@@ -0,0 +1,90 @@
+// +build ignore
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/calibration"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial port
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// user confirmations are read from the standard input
+	confirm := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			confirm <- struct{}{}
+		}
+	}()
+
+	// create a calibrator which
+	// - calibrates the vehicle with system id 1, whose autopilot is detected
+	//   from its heartbeat
+	// - prints the steps of calibrations
+	var cal *calibration.Calibrator
+	cal, err = calibration.New(calibration.Conf{
+		Node:     node,
+		SystemId: 1,
+		OnEvent: func(evt calibration.Event) {
+			switch evt.Type {
+			case calibration.EventProgress:
+				fmt.Printf("%s calibration: %d%%\n", evt.Calibration, evt.Progress)
+
+			case calibration.EventPosition:
+				fmt.Printf("place the vehicle in one of these positions: %v, then press enter\n", evt.Positions)
+				go func() {
+					<-confirm
+					cal.Confirm()
+				}()
+
+			case calibration.EventText:
+				fmt.Printf("vehicle: %s\n", evt.Text)
+			}
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cal.Close()
+
+	err = cal.Accelerometer()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("rotate the vehicle around all its axes")
+
+	err = cal.Compass()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("calibration completed")
+}
@@ -0,0 +1,52 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/camera"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial port
+	// - understands common dialect
+	// - presents itself as a camera
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:             common.Dialect,
+		OutVersion:          gomavlib.V2,
+		OutSystemId:         1,
+		OutComponentId:      100, // MAV_COMP_ID_CAMERA
+		HeartbeatSystemType: 30,  // MAV_TYPE_CAMERA
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// attach a camera to the node
+	cam, err := camera.New(camera.Conf{
+		Node:       node,
+		VendorName: "myvendor",
+		ModelName:  "mycamera",
+		OnImageCapture: func(index int) (*camera.Capture, error) {
+			fmt.Printf("capturing image %d\n", index)
+			return &camera.Capture{
+				FileURL: fmt.Sprintf("http://192.168.1.10/image%d.jpg", index),
+			}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cam.Close()
+
+	for range node.Events() {
+	}
+}
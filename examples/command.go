@@ -0,0 +1,91 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a sender which
+	// - sends commands to the vehicle with system id 1
+	// - retries temporarily rejected commands with an exponential backoff
+	// - allows calibrations to report progress every 30 seconds
+	sender, err := command.New(command.Conf{
+		Node:     node,
+		SystemId: 1,
+		Retry: command.RetryPolicy{
+			RejectDelay:    500 * time.Millisecond,
+			MaxRejectDelay: 4 * time.Second,
+			Multiplier:     2,
+		},
+		CommandRetry: map[common.MAV_CMD]command.RetryPolicy{
+			common.MAV_CMD_PREFLIGHT_CALIBRATION: {ProgressTimeout: 30 * time.Second},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer sender.Close()
+
+	// start a gyroscope calibration and print its progress
+	_, err = sender.Send(&common.MessageCommandLong{
+		Command: common.MAV_CMD_PREFLIGHT_CALIBRATION,
+		Param1:  1,
+	}, func(progress uint8) {
+		if progress == 255 {
+			fmt.Println("calibration in progress")
+			return
+		}
+		fmt.Printf("calibration progress: %d%%\n", progress)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("calibration completed")
+
+	// move the vehicle to a position, with COMMAND_INT, waiting at most 10 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = sender.SendInt(ctx, &common.MessageCommandInt{
+		Command: common.MAV_CMD_DO_REPOSITION,
+		Frame:   common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+		Param1:  -1, // default speed
+		X:       453654321,
+		Y:       91234567,
+		Z:       30,
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+}
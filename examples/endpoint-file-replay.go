@@ -0,0 +1,43 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+)
+
+func main() {
+	// create a node which
+	// - replays a .tlog file four times faster than it was recorded
+	// - forwards the recorded frames to a UDP client, i.e. a ground station
+	// - understands ardupilotmega dialect
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointFileReader{Path: "flight.tlog", Speed: 4},
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:14550"},
+		},
+		Dialect:          ardupilotmega.Dialect,
+		OutVersion:       gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId:      10,
+		HeartbeatDisable: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	for evt := range node.Events() {
+		switch ee := evt.(type) {
+		case *gomavlib.EventFrame:
+			fmt.Printf("replayed: id=%d, %+v\n", ee.Message().GetId(), ee.Message())
+			node.WriteFrameExcept(ee.Channel, ee.Frame)
+
+		case *gomavlib.EventChannelClose:
+			// the end of the file has been reached
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a HTTP server through WebSocket or, when WebSocket is
+	//   blocked, through long polling. Proxies are read from the HTTP_PROXY and
+	//   HTTPS_PROXY environment variables.
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointHttpClient{Address: "http://example.com/mavlink"},
+		},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print every message we receive
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			fmt.Printf("received: id=%d, %+v\n", frm.Message().GetId(), frm.Message())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode, through a simulated
+	//   degraded link, that delays, loses, reorders and rate-limits frames
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointImpaired{
+				Endpoint: gomavlib.EndpointUdpServer{Address: ":5600"},
+				In: gomavlib.LinkImpairment{
+					Delay:  200 * time.Millisecond,
+					Jitter: 50 * time.Millisecond,
+					Loss:   0.05,
+				},
+				Out: gomavlib.LinkImpairment{
+					Delay:     200 * time.Millisecond,
+					Reorder:   0.01,
+					Bandwidth: 5760, // 57600 baud
+				},
+			},
+		},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print every message we receive
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			fmt.Printf("received: id=%d, %+v\n", frm.Message().GetId(), frm.Message())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+)
+
+func main() {
+	// create a node which
+	// - routes frames between a serial port and a UDP client endpoint
+	// - mirrors frames to observers connected to a UDP server endpoint,
+	//   discarding any frame received from them
+	// - is dialect agnostic, does not attempt to decode messages (in a router it is preferable)
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:5900"},
+			gomavlib.EndpointMirror{
+				Endpoint: gomavlib.EndpointUdpServer{Address: ":5600"},
+			},
+		},
+		Dialect:     nil,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	for evt := range node.Events() {
+		switch tevt := evt.(type) {
+		case *gomavlib.EventFrame:
+			// route frame to every other channel, including observers
+			node.WriteFrameExcept(tevt.Channel, tevt.Frame)
+
+		case *gomavlib.EventChannelClose:
+			if _, ok := tevt.Channel.Endpoint.Conf().(gomavlib.EndpointMirror); ok {
+				fmt.Printf("observer %s disconnected, %d frames discarded\n",
+					tevt.Channel, tevt.Channel.TrafficStats().DroppedFrames)
+			}
+		}
+	}
+}
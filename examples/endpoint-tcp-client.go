@@ -16,7 +16,7 @@ func main() {
 	// - writes messages with given system id
 	node, err := gomavlib.NewNode(gomavlib.NodeConf{
 		Endpoints: []gomavlib.EndpointConf{
-			gomavlib.EndpointTcpClient{"1.2.3.4:5600"},
+			gomavlib.EndpointTcpClient{Address: "1.2.3.4:5600"},
 		},
 		Dialect:     ardupilotmega.Dialect,
 		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
@@ -0,0 +1,85 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+)
+
+// this is an example of a frame-oriented transport, where each packet
+// contains exactly one frame. It sends packets through UDP, but the same
+// structure applies to radios that carry packets, i.e. LoRa modems.
+// Transports are usually provided by external packages, that register
+// them in their init() function.
+type packetTransport struct{}
+
+func (packetTransport) Label() string {
+	return "packet"
+}
+
+func (packetTransport) Dial(address string) (gomavlib.TransportConn, error) {
+	conn, err := net.Dial("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	return &packetConn{conn}, nil
+}
+
+type packetConn struct {
+	conn net.Conn
+}
+
+func (c *packetConn) ReadFrame() ([]byte, error) {
+	buf := make([]byte, 300)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (c *packetConn) WriteFrame(buf []byte) error {
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *packetConn) Close() error {
+	return c.conn.Close()
+}
+
+func init() {
+	gomavlib.RegisterTransport("packet", packetTransport{})
+}
+
+func main() {
+	// create a node which
+	// - communicates through the transport
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointTransport{
+				Transport: "packet",
+				Address:   "127.0.0.1:14550",
+			},
+		},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print every message we receive
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			fmt.Printf("received: id=%d, %+v\n", frm.Message().GetId(), frm.Message())
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+)
+
+func main() {
+	// create a node which
+	// - reads telemetry from a vehicle connected through a serial port
+	// - sends telemetry to every viewer that sends a datagram to port 5600,
+	//   limiting each viewer to 2 frames per second for each message
+	// - is dialect agnostic, does not attempt to decode messages
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+			gomavlib.EndpointUdpFanout{
+				Address: ":5600",
+				RateClasses: []gomavlib.UdpFanoutRateClass{
+					{Sources: []string{"0.0.0.0/0"}, MaxFrequency: 2},
+				},
+			},
+		},
+		Dialect:     nil,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			// route frame to every other channel
+			node.WriteFrameExcept(frm.Channel, frm.Frame)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/esc"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":5600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// aggregate ESC and servo telemetry of the vehicle with system id 1
+	monitor, err := esc.New(esc.Conf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer monitor.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// print motors periodically
+	for {
+		time.Sleep(1 * time.Second)
+
+		for _, m := range monitor.Motors() {
+			if m.StatusStale {
+				fmt.Printf("motor %d: no data\n", m.Index)
+				continue
+			}
+			fmt.Printf("motor %d: %d rpm, %.1fV, %.1fA\n", m.Index, m.RPM, m.Voltage, m.Current)
+		}
+	}
+}
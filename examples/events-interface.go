@@ -0,0 +1,69 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/events"
+)
+
+func main() {
+	// add the messages of the events interface to the dialect
+	err := events.RegisterMessages(common.Dialect)
+	if err != nil {
+		panic(err)
+	}
+
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// load the event metadata, generated when building the firmware
+	f, err := os.Open("events.json")
+	if err != nil {
+		panic(err)
+	}
+	md, err := events.ParseMetadata(f)
+	f.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	// print the events of the vehicle with system id 1
+	r, err := events.New(events.Conf{
+		Node:     node,
+		SystemId: 1,
+		OnEvent: func(e events.Event) {
+			if e.Message == "" {
+				fmt.Printf("[%s] unknown event %d\n", e.LogLevel, e.Id)
+				return
+			}
+			fmt.Printf("[%s] %s\n", e.LogLevel, e.Message)
+		},
+		Metadata: md,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+
+	for range node.Events() {
+	}
+}
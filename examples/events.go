@@ -16,7 +16,7 @@ func main() {
 	// - writes messages with given system id
 	node, err := gomavlib.NewNode(gomavlib.NodeConf{
 		Endpoints: []gomavlib.EndpointConf{
-			gomavlib.EndpointSerial{"/dev/ttyUSB0:57600"},
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
 		},
 		Dialect:     ardupilotmega.Dialect,
 		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
@@ -40,7 +40,11 @@ func main() {
 			fmt.Printf("channel opened: %v\n", ee)
 
 		case *gomavlib.EventChannelClose:
-			fmt.Printf("channel closed: %v\n", ee)
+			if ee.Error != nil {
+				fmt.Printf("channel closed: %v (%v)\n", ee.Channel, ee.Error)
+			} else {
+				fmt.Printf("channel closed: %v\n", ee.Channel)
+			}
 		}
 	}
 }
@@ -0,0 +1,73 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/failsafe"
+)
+
+func main() {
+	// create a node which, on a companion computer,
+	// - communicates with the autopilot through a serial port
+	// - communicates with the ground station through a primary (UDP)
+	//   and a backup (TCP) link
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyAMA0:57600"},
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:14550"},
+			gomavlib.EndpointTcpClient{Address: "1.2.3.4:5760"},
+		},
+		Dialect:        common.Dialect,
+		OutVersion:     gomavlib.V2,
+		OutSystemId:    1,
+		OutComponentId: 191, // MAV_COMP_ID_ONBOARD_COMPUTER
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	autopilot := node.Endpoints()[0]
+	primary := node.Endpoints()[1]
+	backup := node.Endpoints()[2]
+
+	print := failsafe.ActionFunc(func(e failsafe.Event) {
+		fmt.Printf("link %+v: %s\n", e.Endpoint.Conf(), e.Type)
+	})
+
+	// - when the primary link is lost, switch to the backup link
+	// - when both links are lost, ask the vehicle to return to launch
+	s, err := failsafe.New(failsafe.Conf{
+		Node: node,
+		Links: []failsafe.Link{
+			{
+				Endpoint:   primary,
+				OnLoss:     []failsafe.Action{print, failsafe.ActionSwitchEndpoint{}},
+				OnRecovery: []failsafe.Action{print, failsafe.ActionSwitchEndpoint{}},
+			},
+			{
+				Endpoint: backup,
+				OnLoss: []failsafe.Action{
+					print,
+					failsafe.ActionSwitchEndpoint{},
+					failsafe.ActionReturnToLaunch(autopilot, 1, 1),
+				},
+				OnRecovery: []failsafe.Action{print, failsafe.ActionSwitchEndpoint{}},
+			},
+		},
+		SystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	for range node.Events() {
+	}
+}
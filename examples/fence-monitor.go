@@ -0,0 +1,46 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/fence"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":5600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print breaches and recoveries of the vehicle with system id 1
+	m, err := fence.New(fence.Conf{
+		Node:     node,
+		SystemId: 1,
+		OnEvent: func(e fence.Event) {
+			fmt.Printf("fence %s: type %s, count %d\n", e.Type, e.BreachType, e.BreachCount)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	for range node.Events() {
+	}
+}
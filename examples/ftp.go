@@ -0,0 +1,87 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/ftp"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial endpoint
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a client which
+	// - transfers files of the vehicle with system id 1
+	// - reads files in bursts
+	client, err := ftp.NewClient(ftp.ClientConf{
+		Node:      node,
+		SystemId:  1,
+		BurstRead: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// list the logs of the vehicle
+	entries, err := client.ListDirectory(ctx, "/APM/LOGS")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir {
+			continue
+		}
+		fmt.Printf("%s (%d bytes)\n", e.Name, e.Size)
+	}
+
+	// download the first log
+	if len(entries) > 0 && !entries[0].IsDir {
+		content, err := client.ReadFile(ctx, "/APM/LOGS/"+entries[0].Name)
+		if err != nil {
+			panic(err)
+		}
+
+		err = ioutil.WriteFile(entries[0].Name, content, 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// upload a Lua script
+	err = client.WriteFile(ctx, "/APM/scripts/hello.lua",
+		[]byte("function update()\n  gcs:send_text(6, \"hello\")\n  return update, 1000\nend\nreturn update()\n"))
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,84 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/gimbal"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a gimbal client which
+	// - controls the gimbals of the vehicle with system id 1
+	// - sends setpoints at most at 10Hz
+	c, err := gimbal.New(gimbal.Conf{
+		Node:     node,
+		SystemId: 1,
+		MaxRate:  10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// discover gimbal managers
+	managers, err := c.Discover(ctx)
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range managers {
+		fmt.Printf("gimbal %d of component %d, capabilities %v\n",
+			m.Information.GimbalDeviceId, m.ComponentId, m.Information.CapFlags)
+	}
+
+	// take control of the gimbal
+	err = c.TakeControl(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer c.ReleaseControl(context.Background())
+
+	// point the gimbal down
+	err = c.PitchYaw(ctx, -90, 0, gimbal.NaN(), gimbal.NaN(), 0)
+	if err != nil {
+		panic(err)
+	}
+
+	// sweep the yaw with a stream of setpoints
+	for i := 0; i < 100; i++ {
+		yaw := float32(math.Sin(float64(i)/10)) * math.Pi / 4
+		c.SetPitchYaw(0, -math.Pi/4, yaw, gimbal.NaN(), gimbal.NaN())
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,63 @@
+// +build ignore
+
+package main
+
+import (
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/governor"
+)
+
+func main() {
+	// create a node which
+	// - routes frames between a telemetry radio and a UDP endpoint
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:5900"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a governor which
+	// - limits the rates of position and attitude messages forwarded to the radio
+	// - lowers them when the radio reports a full transmit buffer or pings are lost
+	g, err := governor.New(governor.Conf{
+		Node: node,
+		Rates: map[uint32]float64{
+			(&common.MessageAttitude{}).GetId():            10,
+			(&common.MessageGlobalPositionInt{}).GetId():   5,
+			(&common.MessageVfrHud{}).GetId():              2,
+			(&common.MessageServoOutputRaw{}).GetId():      1,
+			(&common.MessageLocalPositionNed{}).GetId():    5,
+			(&common.MessageNavControllerOutput{}).GetId(): 1,
+		},
+		Endpoints:  node.Endpoints()[:1],
+		PingPeriod: 1 * time.Second,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer g.Close()
+
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			// route frame to every other channel, within the rates allowed by the links
+			for _, ch := range node.Channels() {
+				if ch != frm.Channel && g.Allowed(ch, frm.Frame) {
+					node.WriteFrameTo(ch, frm.Frame)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/highlatency"
+)
+
+func main() {
+	// create a node which
+	// - runs on a companion computer
+	// - communicates with the autopilot through a serial port
+	// - communicates with the ground station through a satellite modem
+	// - understands common dialect
+	// - writes messages with the system id of the vehicle
+	satellite := gomavlib.EndpointSerial{Address: "/dev/ttyUSB1:19200"}
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyACM0:57600"},
+			satellite,
+		},
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      1,
+		OutComponentId:   191,
+		HeartbeatDisable: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// find the endpoint of the satellite modem
+	var satelliteEndpoint gomavlib.Endpoint
+	for _, e := range node.Endpoints() {
+		if e.Conf() == satellite {
+			satelliteEndpoint = e
+		}
+	}
+
+	// create an emitter that aggregates the telemetry of the autopilot and
+	// writes a HIGH_LATENCY2 message every 10 seconds through the modem.
+	// The ground station can disable the high-latency mode with
+	// MAV_CMD_CONTROL_HIGH_LATENCY.
+	e, err := highlatency.NewEmitter(highlatency.EmitterConf{
+		Node:     node,
+		SystemId: 1,
+		Endpoint: satelliteEndpoint,
+		Interval: 10 * time.Second,
+		Enabled:  true,
+		OnChange: func(enabled bool) {
+			fmt.Printf("high-latency mode enabled: %v\n", enabled)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer e.Close()
+
+	for range node.Events() {
+	}
+}
@@ -0,0 +1,56 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/identity"
+)
+
+func main() {
+	// create a node which
+	// - receives the traffic of a fleet through UDP
+	// - understands common dialect
+	// - writes messages with the system id of a ground station
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// track the unique ids of the vehicles
+	r, err := identity.New(identity.Conf{
+		Node: node,
+		OnEvent: func(e identity.Event) {
+			switch e.Type {
+			case identity.EventNew:
+				fmt.Printf("vehicle %s has system id %d\n", e.Identity.UID, e.Identity.SystemId)
+
+			case identity.EventSystemIdChanged:
+				fmt.Printf("vehicle %s moved from system id %d to %d\n",
+					e.Identity.UID, e.PreviousSystemId, e.Identity.SystemId)
+
+			case identity.EventSystemIdReassigned:
+				fmt.Printf("system id %d moved from vehicle %s to %s\n",
+					e.Identity.SystemId, e.PreviousUID, e.Identity.UID)
+			}
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+
+	for range node.Events() {
+	}
+}
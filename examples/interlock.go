@@ -0,0 +1,93 @@
+// +build ignore
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/interlock"
+)
+
+func main() {
+	// create an interlock that asks the operator to confirm critical commands
+	il, err := interlock.New(interlock.Conf{
+		OnBlock: func(r interlock.Request) {
+			fmt.Printf("command %s to system %d blocked, type %s to confirm it\n",
+				r.Command.Command, r.Command.TargetSystem, r.Token)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	// - passes outgoing messages to the interlock
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		OutGuard:    il.Guard,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// track the landed state of vehicles
+	node.AddFrameHandler(il.HandleFrame)
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// read confirmations from the standard input
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			err := il.Confirm(strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	// create a sender that retransmits commands for 20 seconds, in order to
+	// leave the time to confirm them
+	sender, err := command.New(command.Conf{
+		Node:     node,
+		SystemId: 1,
+		Retry: command.RetryPolicy{
+			AckTimeout:         2 * time.Second,
+			MaxRetransmissions: 10,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer sender.Close()
+
+	// arm the vehicle. The command is written once it has been confirmed.
+	_, err = sender.Send(&common.MessageCommandLong{
+		Command: common.MAV_CMD_COMPONENT_ARM_DISARM,
+		Param1:  1,
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("vehicle armed")
+}
@@ -0,0 +1,52 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/intervalbroker"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a vehicle through a serial port and with ground
+	//   stations through a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+			gomavlib.EndpointUdpServer{Address: ":5600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// answer message rate requests addressed to the vehicle with system id 1
+	broker, err := intervalbroker.New(intervalbroker.Conf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer broker.Close()
+
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			// rate requests are answered by the broker and are not forwarded
+			if broker.Handles(frm) {
+				continue
+			}
+
+			// route frame to every other channel
+			node.WriteFrameExcept(frm.Channel, frm.Frame)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/logdownload"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial endpoint
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a client which downloads the logs of the vehicle with system id 1
+	client, err := logdownload.NewClient(logdownload.ClientConf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	entries, err := client.List(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	// download all logs into files
+	for _, e := range entries {
+		f, err := os.Create(fmt.Sprintf("%d.bin", e.Id))
+		if err != nil {
+			panic(err)
+		}
+
+		err = client.Download(context.Background(), e, f, func(written uint32, size uint32) {
+			fmt.Printf("log %d: %d/%d bytes\n", e.Id, written, size)
+		})
+		f.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+}
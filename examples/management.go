@@ -0,0 +1,68 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/management"
+)
+
+func main() {
+	// create a node which, on a headless gateway,
+	// - communicates with the vehicle through a serial port
+	// - communicates with the ground station through UDP
+	// - validates signed frames only
+	// - understands common dialect
+	// - writes messages with given system id
+	key := frame.NewV2Key([]byte("abcdefg"))
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyAMA0:57600"},
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:        common.Dialect,
+		InKey:          key,
+		OutVersion:     gomavlib.V2,
+		OutSystemId:    10,
+		OutComponentId: 1,
+		OutKey:         key,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// allow the ground station with system id 255 to add and remove
+	// endpoints, i.e. with
+	//   client.Add("video", "udpc:192.168.1.20:14560")
+	//   client.Block("video", 0)
+	m, err := management.New(management.Conf{
+		Node: node,
+		Authorize: func(evt *gomavlib.EventFrame) bool {
+			return evt.SystemId() == 255
+		},
+		// a payload type that is not registered, shared with clients
+		PayloadType: 32900,
+		Endpoints: map[string]gomavlib.Endpoint{
+			"vehicle": node.Endpoints()[0],
+			"gcs":     node.Endpoints()[1],
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	// route frames, applying the filters of the ground station
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			for _, ch := range node.Channels() {
+				if ch != frm.Channel && m.Allowed(ch, frm.Frame) {
+					node.WriteFrameTo(ch, frm.Frame)
+				}
+			}
+		}
+	}
+}
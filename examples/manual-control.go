@@ -0,0 +1,52 @@
+// +build ignore
+
+package main
+
+import (
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/manualcontrol"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in client mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// stream MANUAL_CONTROL to the vehicle with system id 1
+	sender, err := manualcontrol.New(manualcontrol.Conf{
+		Node:         node,
+		TargetSystem: 1,
+		OnStop: func() {
+			println("joystick input stopped")
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer sender.Close()
+
+	// update the joystick state periodically.
+	// when updates stop, neutral values are sent and then streaming stops.
+	for i := 0; i < 50; i++ {
+		sender.Update(manualcontrol.State{X: 200, Z: 500})
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	time.Sleep(2 * time.Second)
+}
@@ -0,0 +1,50 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/mission"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in client mode
+	// - understands common dialect
+	// - writes messages with the system id of the vehicle
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a server that accepts missions uploaded by ground stations,
+	// rejecting the invalid ones
+	server, err := mission.NewServer(mission.ServerConf{
+		Node:     node,
+		Validate: mission.Validate,
+		OnMission: func(items []*common.MessageMissionItemInt) {
+			fmt.Printf("received a mission with %d items\n", len(items))
+			for _, item := range items {
+				fmt.Printf("%d: %s\n", item.Seq, item.Command)
+			}
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	for range node.Events() {
+	}
+}
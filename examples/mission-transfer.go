@@ -0,0 +1,97 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/mission"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a client that transfers missions to and from the vehicle
+	// with system id 1
+	client, err := mission.NewClient(mission.ClientConf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	// a mission that takes off, reaches a waypoint and returns to launch
+	items := []*common.MessageMissionItemInt{
+		{
+			Frame:   common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			Command: common.MAV_CMD_NAV_TAKEOFF,
+			X:       450000000,
+			Y:       90000000,
+			Z:       20,
+		},
+		{
+			Seq:          1,
+			Frame:        common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			Command:      common.MAV_CMD_NAV_WAYPOINT,
+			Autocontinue: 1,
+			X:            450009000,
+			Y:            90000000,
+			Z:            20,
+		},
+		{
+			Seq:     2,
+			Frame:   common.MAV_FRAME_MISSION,
+			Command: common.MAV_CMD_NAV_RETURN_TO_LAUNCH,
+		},
+	}
+
+	err = mission.Validate(items)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// upload the mission
+	err = client.UploadMission(ctx, items)
+	if err != nil {
+		panic(err)
+	}
+
+	// download it again
+	downloaded, err := client.DownloadMission(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, item := range downloaded {
+		fmt.Printf("%d: %s\n", item.Seq, item.Command)
+	}
+}
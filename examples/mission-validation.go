@@ -0,0 +1,65 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/mission"
+)
+
+func waypoint(seq uint16, lat int32, lon int32, alt float32) *common.MessageMissionItemInt {
+	return &common.MessageMissionItemInt{
+		Seq:          seq,
+		Frame:        common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+		Command:      common.MAV_CMD_NAV_WAYPOINT,
+		Autocontinue: 1,
+		X:            lat,
+		Y:            lon,
+		Z:            alt,
+		MissionType:  common.MAV_MISSION_TYPE_MISSION,
+	}
+}
+
+func main() {
+	// a mission that flies a square twice and returns to launch
+	items := []*common.MessageMissionItemInt{
+		waypoint(0, 450000000, 90000000, 20),
+		waypoint(1, 450009000, 90000000, 20),
+		waypoint(2, 450009000, 90012700, 20),
+		waypoint(3, 450000000, 90012700, 20),
+		{
+			Seq:         4,
+			Frame:       common.MAV_FRAME_MISSION,
+			Command:     common.MAV_CMD_DO_JUMP,
+			Param1:      0, // target item
+			Param2:      1, // repeat count
+			MissionType: common.MAV_MISSION_TYPE_MISSION,
+		},
+		{
+			Seq:         5,
+			Frame:       common.MAV_FRAME_MISSION,
+			Command:     common.MAV_CMD_NAV_RETURN_TO_LAUNCH,
+			MissionType: common.MAV_MISSION_TYPE_MISSION,
+		},
+	}
+
+	// reject the mission if it is invalid
+	err := mission.Validate(items)
+	if err != nil {
+		panic(err)
+	}
+
+	// estimate its duration and energy
+	est, err := mission.Estimate(items, mission.EstimateConf{
+		CruiseSpeed: 8,
+		Power:       400,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("distance: %.0fm, duration: %s, energy: %.1fWh\n",
+		est.Distance, est.Duration, est.Energy)
+}
@@ -0,0 +1,55 @@
+// +build ignore
+
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/namedvalue"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in client mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// publish values at most every 100ms
+	pub, err := namedvalue.NewPublisher(namedvalue.PublisherConf{
+		Node: node,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// values can be plotted by ground stations
+	start := time.Now()
+	for {
+		t := time.Since(start).Seconds()
+		pub.Float("sine", float32(math.Sin(t)))
+		pub.Int("elapsed", int32(t))
+		pub.Vect("circle", float32(math.Cos(t)), float32(math.Sin(t)), 0)
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/positiontarget"
+	"github.com/aler9/gomavlib/vehicle"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a PX4 vehicle through UDP
+	// - understands common dialect
+	// - writes messages with the system id of a companion computer
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14540"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 254,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	v, err := vehicle.New(vehicle.Conf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer v.Close()
+
+	// stream setpoints that describe a circle with a radius of 5 meters,
+	// 10 meters above home
+	start := time.Now()
+
+	s, err := positiontarget.NewCallbackStreamer(positiontarget.CallbackConf{
+		Node:         node,
+		TargetSystem: 1,
+		Callback: func() msg.Message {
+			a := time.Since(start).Seconds() * 0.5
+			return &common.MessageSetPositionTargetLocalNed{
+				CoordinateFrame: common.MAV_FRAME_LOCAL_NED,
+				TypeMask:        positiontarget.IgnoreAll.WithPosition().Value(),
+				X:               float32(5 * math.Cos(a)),
+				Y:               float32(5 * math.Sin(a)),
+				Z:               -10,
+			}
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// PX4 switches to offboard mode only when setpoints are already streamed
+	err = s.WaitReady(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	err = v.SetMode(ctx, vehicle.PX4CustomMode(6, 0))
+	if err != nil {
+		panic(err)
+	}
+
+	err = v.Arm(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	time.Sleep(60 * time.Second)
+
+	err = v.Land(context.Background())
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,44 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/onboardcomputer"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial port connected to the autopilot
+	// - understands common dialect
+	// - writes messages with the system id of the vehicle and the
+	//   component id of onboard computers
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyAMA0:921600"},
+		},
+		Dialect:                common.Dialect,
+		OutVersion:             gomavlib.V2,
+		OutSystemId:            1,
+		OutComponentId:         byte(common.MAV_COMP_ID_ONBOARD_COMPUTER),
+		HeartbeatAutopilotType: int(common.MAV_AUTOPILOT_INVALID),
+		HeartbeatSystemType:    int(common.MAV_TYPE_ONBOARD_CONTROLLER),
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// publish CPU, RAM, temperatures and link traffic every second
+	publisher, err := onboardcomputer.New(onboardcomputer.Conf{
+		Node: node,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer publisher.Close()
+
+	for range node.Events() {
+	}
+}
@@ -0,0 +1,78 @@
+// +build ignore
+
+package main
+
+import (
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/opendroneid"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial endpoint
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// broadcast the identity of the aircraft and of the operator
+	b, err := opendroneid.New(opendroneid.Conf{
+		Node: node,
+		BasicId: opendroneid.BasicId{
+			IdType: common.MAV_ODID_ID_TYPE_SERIAL_NUMBER,
+			UaType: common.MAV_ODID_UA_TYPE_HELICOPTER_OR_MULTIROTOR,
+			UasId:  "1596F123456789",
+		},
+		System: opendroneid.System{
+			OperatorLocationType: common.MAV_ODID_OPERATOR_LOCATION_TYPE_TAKEOFF,
+			OperatorLatitude:     45.0703,
+			OperatorLongitude:    7.6869,
+		},
+		OperatorId: &opendroneid.OperatorId{
+			OperatorId: "FIN87astrdge12k8",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer b.Close()
+
+	// update the location of the aircraft
+	for {
+		err := b.SetLocation(opendroneid.Location{
+			Status:             common.MAV_ODID_STATUS_AIRBORNE,
+			Direction:          90,
+			SpeedHorizontal:    5,
+			SpeedVertical:      0,
+			Latitude:           45.0705,
+			Longitude:          7.6872,
+			AltitudeBarometric: 300,
+			AltitudeGeodetic:   350,
+			HeightReference:    common.MAV_ODID_HEIGHT_REF_OVER_TAKEOFF,
+			Height:             50,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
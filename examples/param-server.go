@@ -0,0 +1,64 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/param"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in client mode
+	// - understands common dialect
+	// - writes messages with the system id of the vehicle and the component
+	//   id of an onboard computer
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:14550"},
+		},
+		Dialect:        common.Dialect,
+		OutVersion:     gomavlib.V2,
+		OutSystemId:    1,
+		OutComponentId: 191, // MAV_COMP_ID_ONBOARD_COMPUTER
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a server that exposes the parameters of the onboard computer
+	// to ground stations
+	server, err := param.NewServer(param.ServerConf{
+		Node: node,
+		Params: []param.ServerParam{
+			{
+				Name:  "TRK_ENABLE",
+				Type:  common.MAV_PARAM_TYPE_UINT8,
+				Value: 1,
+			},
+			{
+				Name:  "TRK_THRESHOLD",
+				Type:  common.MAV_PARAM_TYPE_REAL32,
+				Value: 0.5,
+				OnSet: func(v float64) error {
+					if v < 0 || v > 1 {
+						return fmt.Errorf("threshold must be between 0 and 1")
+					}
+					fmt.Printf("threshold set to %v\n", v)
+					return nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	for range node.Events() {
+	}
+}
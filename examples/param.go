@@ -0,0 +1,68 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/param"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a client that reads and writes the parameters of the vehicle
+	// with system id 1. Use param.EncodingCast with ArduPilot.
+	client, err := param.NewClient(param.ClientConf{
+		Node:     node,
+		SystemId: 1,
+		Encoding: param.EncodingBytewise,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	// read all parameters
+	params, err := client.GetAll(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, p := range params {
+		fmt.Printf("%s = %v (%s)\n", p.Name, p.Value, p.Type)
+	}
+
+	// write a parameter
+	_, err = client.Set(ctx, "MPC_XY_VEL_MAX", 10)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,48 @@
+// +build ignore
+
+package main
+
+import (
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/positiontarget"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in client mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// stream position targets to the vehicle with system id 1
+	streamer, err := positiontarget.New(positiontarget.Conf{
+		Node:         node,
+		TargetSystem: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer streamer.Close()
+
+	// follow a target that moves north
+	lat := 45.0
+	for i := 0; i < 100; i++ {
+		streamer.GotoMoving(lat, 9.0, 10)
+		lat += 0.00001
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/rcoverride"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in client mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// overrides are released automatically when the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	overrider, err := rcoverride.New(rcoverride.Conf{
+		Node:         node,
+		TargetSystem: 1,
+		Context:      ctx,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer overrider.Close()
+
+	// override channel 7
+	err = overrider.Set(7, 1900)
+	if err != nil {
+		panic(err)
+	}
+
+	<-ctx.Done()
+}
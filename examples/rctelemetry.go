@@ -0,0 +1,44 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/rctelemetry"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a serial port
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	// - automatically requests streams to ardupilot devices
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:             ardupilotmega.Dialect,
+		OutVersion:          gomavlib.V1, // Ardupilot uses V1
+		OutSystemId:         10,
+		StreamRequestEnable: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// write the vehicle state to a FrSky receiver connected to another serial port
+	bridge, err := rctelemetry.New(rctelemetry.Conf{
+		Node:     node,
+		Protocol: rctelemetry.ProtocolSPort,
+		Device:   "/dev/ttyUSB1:57600",
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer bridge.Close()
+
+	for range node.Events() {
+	}
+}
@@ -0,0 +1,66 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/redact"
+)
+
+func main() {
+	// create a router which
+	// - communicates with a vehicle and with a public demo feed
+	// - does not decode messages
+	vehicle := gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"}
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			vehicle,
+			gomavlib.EndpointUdpClient{Address: "demo.example.com:14550"},
+		},
+		Dialect:     nil,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a redactor that fuzzes the position of the vehicle by about
+	// 100 meters and removes the operator id
+	rd, err := redact.New(redact.Conf{
+		Rules: []redact.Rule{
+			{
+				Message: &common.MessageGlobalPositionInt{},
+				Fuzz:    map[string]float64{"Lat": 1000, "Lon": 1000},
+			},
+			{
+				Message: &common.MessageOpenDroneIdOperatorId{},
+				Zero:    []string{"OperatorId"},
+			},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			// redact frames forwarded from the vehicle to the public feed
+			if frm.Channel.Endpoint.Conf() == vehicle {
+				f, err := rd.RedactFrame(frm.Frame)
+				if err != nil {
+					continue
+				}
+
+				for _, ch := range node.Channels() {
+					if ch != frm.Channel {
+						node.WriteFrameTo(ch, f)
+					}
+				}
+			}
+		}
+	}
+}
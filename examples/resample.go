@@ -0,0 +1,55 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/resample"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":5600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// resample position and attitude of the vehicle with system id 1 at 50Hz,
+	// with a delay that allows to interpolate between messages
+	resampler, err := resample.New(resample.Conf{
+		Node:     node,
+		SystemId: 1,
+		Period:   20 * time.Millisecond,
+		Delay:    100 * time.Millisecond,
+		OnSample: func(s resample.Sample) {
+			if !s.PositionValid || !s.AttitudeValid {
+				fmt.Printf("%s: no data\n", s.Time.Format(time.StampMilli))
+				return
+			}
+			fmt.Printf("%s: lat=%.7f lon=%.7f alt=%.2f yaw=%.3f\n",
+				s.Time.Format(time.StampMilli), s.Lat, s.Lon, s.Alt, s.Yaw)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer resampler.Close()
+
+	for range node.Events() {
+	}
+}
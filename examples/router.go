@@ -15,8 +15,8 @@ func main() {
 	// - writes messages with given system id
 	node, err := gomavlib.NewNode(gomavlib.NodeConf{
 		Endpoints: []gomavlib.EndpointConf{
-			gomavlib.EndpointSerial{"/dev/ttyUSB0:57600"},
-			gomavlib.EndpointUdpClient{"1.2.3.4:5900"},
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+			gomavlib.EndpointUdpClient{Address: "1.2.3.4:5900"},
 		},
 		Dialect:     nil,
 		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
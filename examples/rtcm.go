@@ -0,0 +1,58 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/rtcm"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a vehicle through a serial port
+	// - understands common dialect
+	// - writes messages with the system id of a ground station
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// inject corrections into all channels
+	inj, err := rtcm.New(rtcm.Conf{
+		Node: node,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// read corrections from an NTRIP caster
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := rtcm.DialNTRIP(ctx, rtcm.NTRIPConf{
+		Address:    "caster.example.com:2101",
+		Mountpoint: "BASE",
+		User:       "user",
+		Password:   "password",
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer stream.Close()
+
+	// copy corrections until the stream ends
+	io.Copy(inj, stream)
+}
@@ -0,0 +1,44 @@
+// +build ignore
+
+package main
+
+import (
+	"io/ioutil"
+
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/schema"
+)
+
+func main() {
+	// export the messages of the ardupilotmega dialect, as they are encoded
+	// by encoding/json, into JSON Schema, Avro and protobuf definitions
+	byts, err := schema.JSONSchema(ardupilotmega.Dialect, "ardupilotmega")
+	if err != nil {
+		panic(err)
+	}
+
+	err = ioutil.WriteFile("ardupilotmega.schema.json", byts, 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	byts, err = schema.Avro(ardupilotmega.Dialect, "org.mavlink.ardupilotmega")
+	if err != nil {
+		panic(err)
+	}
+
+	err = ioutil.WriteFile("ardupilotmega.avsc", byts, 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	byts, err = schema.Protobuf(ardupilotmega.Dialect, "mavlink.ardupilotmega")
+	if err != nil {
+		panic(err)
+	}
+
+	err = ioutil.WriteFile("ardupilotmega.proto", byts, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
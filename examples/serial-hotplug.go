@@ -0,0 +1,46 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+)
+
+func main() {
+	// create a node which
+	// - communicates with every USB serial device that is plugged
+	// - detects the baud rate of devices
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		SerialHotplugEnable: true,
+		SerialHotplugAttach: true,
+		Dialect:             ardupilotmega.Dialect,
+		OutVersion:          gomavlib.V2,
+		OutSystemId:         10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	for evt := range node.Events() {
+		switch ee := evt.(type) {
+		case *gomavlib.EventSerialDevicePlugged:
+			if ee.AttachError != nil {
+				fmt.Printf("device %s plugged, unable to attach: %s\n", ee.Device.Path, ee.AttachError)
+			} else {
+				fmt.Printf("device %s plugged (%s)\n", ee.Device.Path, ee.Device.Description)
+			}
+
+		case *gomavlib.EventSerialDeviceUnplugged:
+			fmt.Printf("device %s unplugged\n", ee.Device.Path)
+
+		case *gomavlib.EventFrame:
+			fmt.Printf("received: id=%d, %+v\n", ee.Message().GetId(), ee.Message())
+		}
+	}
+}
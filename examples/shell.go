@@ -0,0 +1,63 @@
+// +build ignore
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/shell"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a PX4 autopilot through a serial port
+	// - understands common dialect
+	// - writes messages with the system id of a ground station
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyACM0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// open a session with the shell of the autopilot
+	s, err := shell.New(shell.Conf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	// run a command and print its output
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := s.Exec(ctx, "ver all")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print(out)
+
+	// then, forward the terminal to the shell
+	go io.Copy(os.Stdout, s)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fmt.Fprintf(s, "%s\n", scanner.Text())
+	}
+}
@@ -0,0 +1,62 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/sink"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// write received messages into the flight/ folder, with a Parquet file
+	// per message type, that can be read with pandas:
+	// pandas.read_parquet("flight/attitude.parquet")
+	// A SQL database can be used instead with sink.NewSQL().
+	s, err := sink.NewParquet(sink.ParquetConf{
+		Node: node,
+		Dir:  "flight",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// stop writing when CTRL-C is pressed
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+
+	// files can be read after Close()
+	err = s.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("written %d messages, dropped %d\n", s.MessagesWritten(), s.MessagesDropped())
+}
@@ -0,0 +1,52 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/spoof"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode, open to any host
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a detector that prints anomalies and suspect systems
+	d, err := spoof.New(spoof.Conf{
+		Node: node,
+		OnEvent: func(e spoof.Event) {
+			switch e.Type {
+			case spoof.EventAnomaly:
+				fmt.Printf("system %d: %s anomaly from %s (score %.2f)\n",
+					e.SystemId, e.Anomaly, e.Channel, e.Score)
+
+			default:
+				fmt.Printf("system %d: %s (score %.2f)\n", e.SystemId, e.Type, e.Score)
+			}
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer d.Close()
+
+	for range node.Events() {
+	}
+}
@@ -0,0 +1,83 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/swarm"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// create a swarm which
+	// - addresses the vehicles with system ids from 1 to 5
+	// - starts transmissions 50ms apart from each other
+	s, err := swarm.New(swarm.Conf{
+		Node:      node,
+		SystemIds: []byte{1, 2, 3, 4, 5},
+		Stagger:   50 * time.Millisecond,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// switch all vehicles to guided mode, retrying once on the ones that failed
+	cmd := &common.MessageCommandLong{
+		Command: common.MAV_CMD_DO_SET_MODE,
+		Param1:  float32(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED),
+		Param2:  4, // GUIDED on ArduCopter
+	}
+
+	_, err = s.SendLong(ctx, cmd)
+	if perr, ok := err.(*swarm.PartialError); ok {
+		fmt.Printf("retrying on %v\n", perr.SystemIds())
+
+		retry, err := swarm.New(swarm.Conf{
+			Node:      node,
+			SystemIds: perr.SystemIds(),
+		})
+		if err != nil {
+			panic(err)
+		}
+		defer retry.Close()
+
+		_, err = retry.SendLong(ctx, cmd)
+		if err != nil {
+			panic(err)
+		}
+	} else if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("all vehicles are in guided mode")
+}
@@ -0,0 +1,83 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/terrain"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a vehicle through a UDP endpoint
+	// - understands common dialect
+	// - writes messages with the system id of a ground station
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a tracker that collects the home position and the terrain
+	// heights of the vehicle
+	tr, err := terrain.NewTracker(terrain.TrackerConf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer tr.Close()
+
+	// a mission planned with altitudes relative to home
+	items := []*common.MessageMissionItemInt{
+		{
+			Seq:     0,
+			Frame:   common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			Command: common.MAV_CMD_NAV_TAKEOFF,
+			X:       -353632621,
+			Y:       1491652374,
+			Z:       20,
+		},
+		{
+			Seq:     1,
+			Frame:   common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			Command: common.MAV_CMD_NAV_WAYPOINT,
+			X:       -353621474,
+			Y:       1491651746,
+			Z:       40,
+		},
+	}
+
+	// request the terrain heights of the waypoints to the vehicle
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = tr.CheckMission(ctx, items)
+	if err != nil {
+		panic(err)
+	}
+
+	// convert the mission into altitudes above terrain; the conversion fails
+	// if the home position has not been received yet
+	err = terrain.ConvertMission(tr, items, terrain.FrameTerrain)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, item := range items {
+		fmt.Printf("item %d: %.1fm above terrain\n", item.Seq, item.Z)
+	}
+}
@@ -0,0 +1,50 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func main() {
+	// create a node which
+	// - runs on a companion computer
+	// - communicates with the autopilot through a serial port
+	// - understands common dialect
+	// - measures the clock offset and the round trip time of the autopilot
+	// - answers TIMESYNC requests, allowing the autopilot to synchronize
+	//   with the companion computer
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyAMA0:921600"},
+		},
+		Dialect:        common.Dialect,
+		OutVersion:     gomavlib.V2,
+		OutSystemId:    1,
+		OutComponentId: 191,
+		TimesyncEnable: true,
+		TimesyncAnswer: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// consume events
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// print the synchronization every second
+	for range time.NewTicker(1 * time.Second).C {
+		for _, ts := range node.Timesync() {
+			fmt.Printf("system %d component %d: offset %v, rtt %v\n",
+				ts.SystemId, ts.ComponentId, ts.Offset, ts.RTT)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/tlog"
+)
+
+func main() {
+	// create a reader which
+	// - merges the .tlog files recorded on two links into a single stream
+	// - annotates frames with the name of their link
+	// - understands ardupilotmega dialect
+	r, err := tlog.NewMergeReader(tlog.MergeConf{
+		Sources: []tlog.Source{
+			{Path: "telemetry-radio.tlog", Label: "radio"},
+			{Path: "lte-modem.tlog", Label: "lte"},
+		},
+		Dialect: ardupilotmega.Dialect,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+
+	// print frames in the order in which they were recorded
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("%s [%s] sys=%d: id=%d, %+v\n", e.Time.Format("15:04:05.000000"), e.Source,
+			e.Frame.GetSystemId(), e.Frame.GetMessage().GetId(), e.Frame.GetMessage())
+	}
+}
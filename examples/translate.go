@@ -0,0 +1,83 @@
+// +build ignore
+
+package main
+
+import (
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/translate"
+)
+
+// a message of a private dialect, as defined by the old firmware
+type MessageBatteryOld struct {
+	Voltage     uint16
+	Temperature int16
+}
+
+func (*MessageBatteryOld) GetId() uint32 {
+	return 180
+}
+
+// the same message, as defined by the new firmware
+type MessageBatteryNew struct {
+	VoltageMv   uint16
+	Temperature int16
+	Current     uint16 `mavext:"true"`
+}
+
+func (*MessageBatteryNew) GetId() uint32 {
+	return 180
+}
+
+func main() {
+	// create a router which
+	// - communicates with a vehicle with the old firmware and with a GCS that
+	//   uses the new dialect
+	// - does not decode messages
+	oldVehicle := gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"}
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			oldVehicle,
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     nil,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// create a translator from the old dialect to the new one
+	tr, err := translate.New([]translate.Rule{{
+		From:   &MessageBatteryOld{},
+		To:     &MessageBatteryNew{},
+		Fields: map[string]string{"VoltageMv": "Voltage"},
+		Func: func(to msg.Message, from msg.Message) {
+			// the current is unknown
+			to.(*MessageBatteryNew).Current = 0xFFFF
+		},
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			f := frm.Frame
+
+			// translate frames coming from the old vehicle
+			if frm.Channel.Endpoint.Conf() == oldVehicle {
+				f, err = tr.TranslateFrame(f)
+				if err != nil {
+					continue
+				}
+			}
+
+			// route frame to every other channel
+			node.WriteFrameExcept(frm.Channel, f)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/ulog"
+)
+
+func main() {
+	// create a node which
+	// - communicates with a UDP endpoint in server mode
+	// - understands common dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: ":14550"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	f, err := os.Create("log.ulg")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	// write the log of the vehicle with system id 1 into the file
+	recv, err := ulog.New(ulog.Conf{
+		Node:     node,
+		SystemId: 1,
+		Writer:   f,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	recv.Start()
+
+	// stop streaming when CTRL-C is pressed
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+
+	recv.Stop()
+
+	err = recv.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("written %d bytes, lost %d packets\n", recv.BytesWritten(), recv.PacketsLost())
+}
@@ -0,0 +1,68 @@
+// +build ignore
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/vehicle"
+)
+
+func main() {
+	// create a node which
+	// - communicates with an ArduCopter vehicle through a serial port
+	// - understands common dialect
+	// - writes messages with the system id of a ground station
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
+		},
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// control the vehicle with system id 1
+	v, err := vehicle.New(vehicle.Conf{
+		Node:     node,
+		SystemId: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer v.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// switch to guided mode, arm and take off up to 10 meters
+	err = v.SetMode(ctx, 4)
+	if err != nil {
+		panic(err)
+	}
+
+	err = v.Arm(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	err = v.Takeoff(ctx, 10)
+	if err != nil {
+		panic(err)
+	}
+
+	time.Sleep(20 * time.Second)
+
+	// then, return to launch
+	err = v.ReturnToLaunch(ctx)
+	if err != nil {
+		panic(err)
+	}
+}
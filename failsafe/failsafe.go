@@ -0,0 +1,429 @@
+// Package failsafe implements a supervisor that runs on the vehicle side
+// (i.e. on a companion computer), watches the health of the links with ground
+// stations and executes actions when a link is lost or recovered.
+//
+// A link is lost when no heartbeats are received from its endpoint for a
+// given time, and is recovered only after a given number of consecutive
+// heartbeats, in order to avoid triggering actions repeatedly when the link
+// is unstable.
+//
+// On high-latency links, i.e. satellite links, the timeout can be extended
+// according to the round trip time of the link, estimated by other components
+// (see gomavlib.Channel.RTT), in order to avoid false losses.
+//
+// The node to which the supervisor is attached must use a dialect that
+// contains the common messages.
+package failsafe
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	frameQueueSize = 16
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventLoss means that a link has been lost.
+	EventLoss EventType = iota
+
+	// EventRecovery means that a link has been recovered.
+	EventRecovery
+)
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	switch t {
+	case EventLoss:
+		return "loss"
+	case EventRecovery:
+		return "recovery"
+	}
+	return "unknown"
+}
+
+// Event is the loss or the recovery of a link.
+type Event struct {
+	// the type of the event.
+	Type EventType
+
+	// the endpoint of the link.
+	Endpoint gomavlib.Endpoint
+
+	// the time at which the event has been detected.
+	Time time.Time
+}
+
+// Action is an action that is executed when a link is lost or recovered.
+// Available actions are ActionCommand, ActionSwitchEndpoint and ActionFunc.
+type Action interface {
+	execute(s *Supervisor, e Event)
+}
+
+// ActionCommand sends a command with a COMMAND_LONG message.
+type ActionCommand struct {
+	// (optional) the endpoint to which the command is sent, i.e. the one
+	// of the autopilot. The command is sent to the channel of the endpoint
+	// from which the last frame has been received.
+	// If nil, the command is sent to all channels.
+	Endpoint gomavlib.Endpoint
+
+	// the command.
+	Command *common.MessageCommandLong
+}
+
+func (a ActionCommand) execute(s *Supervisor, e Event) {
+	if a.Endpoint == nil {
+		s.conf.Node.WriteMessageAll(a.Command)
+		return
+	}
+
+	if ch := s.channel(a.Endpoint); ch != nil {
+		s.conf.Node.WriteMessageTo(ch, a.Command)
+	}
+}
+
+// ActionReturnToLaunch returns an ActionCommand that asks a vehicle to return
+// to launch.
+func ActionReturnToLaunch(endpoint gomavlib.Endpoint, targetSystem byte, targetComponent byte) ActionCommand {
+	return ActionCommand{
+		Endpoint: endpoint,
+		Command: &common.MessageCommandLong{
+			TargetSystem:    targetSystem,
+			TargetComponent: targetComponent,
+			Command:         common.MAV_CMD_NAV_RETURN_TO_LAUNCH,
+		},
+	}
+}
+
+// ActionSwitchEndpoint sets as active link the first healthy link, in the
+// order of Conf.Links. Messages written with Supervisor.WriteMessage are
+// sent to the active link.
+type ActionSwitchEndpoint struct{}
+
+func (ActionSwitchEndpoint) execute(s *Supervisor, e Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, l := range s.links {
+		if !l.lost {
+			s.active = l
+			return
+		}
+	}
+}
+
+// ActionFunc calls a function.
+type ActionFunc func(Event)
+
+func (a ActionFunc) execute(s *Supervisor, e Event) {
+	a(e)
+}
+
+// Link is a link with one or more ground stations.
+type Link struct {
+	// the endpoint from which heartbeats are received.
+	Endpoint gomavlib.Endpoint
+
+	// actions executed, in order, when the link is lost.
+	OnLoss []Action
+
+	// actions executed, in order, when the link is recovered.
+	OnRecovery []Action
+}
+
+// Conf allows to configure a Supervisor.
+type Conf struct {
+	// the node from which heartbeats are read.
+	Node *gomavlib.Node
+
+	// the links to watch, in order of preference. The first link is the
+	// initial active link.
+	Links []Link
+
+	// (optional) the system id of the ground station. If zero, heartbeats
+	// of any system are accepted.
+	SystemId byte
+
+	// (optional) the time after which a link without heartbeats is lost.
+	// It defaults to 3 seconds.
+	Timeout time.Duration
+
+	// (optional) when greater than zero, the timeout of a link is extended to
+	// this factor times the estimated round trip time of its channel
+	// (see gomavlib.Channel.RTT), when longer.
+	RTTFactor float64
+
+	// (optional) the number of consecutive heartbeats, each one received
+	// within Timeout from the previous one, that are needed to recover
+	// a lost link. It defaults to 3.
+	RecoveryHeartbeats int
+}
+
+type link struct {
+	conf Link
+
+	// protected by Supervisor.mutex
+	lost bool
+
+	// accessed by run() only
+	lastHeartbeat time.Time
+	consecutive   int
+}
+
+type heartbeat struct {
+	ch   *gomavlib.Channel
+	time time.Time
+}
+
+// Supervisor watches links and executes actions when they are lost or recovered.
+type Supervisor struct {
+	conf          Conf
+	links         []*link
+	removeHandler func()
+
+	mutex    sync.Mutex
+	active   *link
+	channels map[gomavlib.Endpoint]*gomavlib.Channel
+
+	heartbeats chan heartbeat
+	terminate  chan struct{}
+	done       chan struct{}
+}
+
+// New allocates a Supervisor. See Conf for the options.
+func New(conf Conf) (*Supervisor, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if len(conf.Links) == 0 {
+		return nil, fmt.Errorf("Links not provided")
+	}
+
+	for _, l := range conf.Links {
+		if l.Endpoint == nil {
+			return nil, fmt.Errorf("Endpoint not provided")
+		}
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHeartbeat{},
+		&common.MessageCommandLong{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Timeout == 0 {
+		conf.Timeout = 3 * time.Second
+	}
+	if conf.RTTFactor < 0 {
+		return nil, fmt.Errorf("RTTFactor must be >= 0")
+	}
+	if conf.RecoveryHeartbeats == 0 {
+		conf.RecoveryHeartbeats = 3
+	}
+
+	s := &Supervisor{
+		conf:       conf,
+		channels:   make(map[gomavlib.Endpoint]*gomavlib.Channel),
+		heartbeats: make(chan heartbeat, frameQueueSize),
+		terminate:  make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	// links are healthy at startup, and are lost if no heartbeat
+	// is received within Timeout
+	now := time.Now()
+	for _, lc := range conf.Links {
+		s.links = append(s.links, &link{
+			conf:          lc,
+			lastHeartbeat: now,
+		})
+	}
+	s.active = s.links[0]
+
+	s.removeHandler = conf.Node.AddFrameHandler(s.onEventFrame)
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the supervisor. It must be called before closing the node.
+func (s *Supervisor) Close() {
+	s.removeHandler()
+	close(s.terminate)
+	<-s.done
+}
+
+// Active returns the endpoint of the active link.
+func (s *Supervisor) Active() gomavlib.Endpoint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.active.conf.Endpoint
+}
+
+// Lost returns whether the link of an endpoint is lost.
+func (s *Supervisor) Lost(e gomavlib.Endpoint) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, l := range s.links {
+		if l.conf.Endpoint == e {
+			return l.lost
+		}
+	}
+	return false
+}
+
+// WriteMessage writes a message to the channel of the active link from which
+// the last frame has been received. The message is discarded if no
+// frame has been received yet.
+func (s *Supervisor) WriteMessage(m msg.Message) {
+	if ch := s.channel(s.Active()); ch != nil {
+		s.conf.Node.WriteMessageTo(ch, m)
+	}
+}
+
+func (s *Supervisor) channel(e gomavlib.Endpoint) *gomavlib.Channel {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.channels[e]
+}
+
+func (s *Supervisor) onEventFrame(evt *gomavlib.EventFrame) {
+	// an endpoint can have multiple channels; use the most recent one
+	s.mutex.Lock()
+	s.channels[evt.Channel.Endpoint] = evt.Channel
+	s.mutex.Unlock()
+
+	if _, ok := evt.Message().(*common.MessageHeartbeat); !ok {
+		return
+	}
+
+	if s.conf.SystemId != 0 && evt.SystemId() != s.conf.SystemId {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case s.heartbeats <- heartbeat{evt.Channel, evt.ReceiveTime}:
+	default:
+	}
+}
+
+func (s *Supervisor) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.conf.Timeout / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case hb := <-s.heartbeats:
+			s.processHeartbeat(hb)
+
+		case <-ticker.C:
+			s.checkTimeouts(time.Now())
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+func (s *Supervisor) link(e gomavlib.Endpoint) *link {
+	for _, l := range s.links {
+		if l.conf.Endpoint == e {
+			return l
+		}
+	}
+	return nil
+}
+
+// timeout returns the timeout of the link of a channel.
+func (s *Supervisor) timeout(ch *gomavlib.Channel) time.Duration {
+	if ch == nil || s.conf.RTTFactor <= 0 {
+		return s.conf.Timeout
+	}
+
+	if t := time.Duration(float64(ch.RTT()) * s.conf.RTTFactor); t > s.conf.Timeout {
+		return t
+	}
+	return s.conf.Timeout
+}
+
+func (s *Supervisor) processHeartbeat(hb heartbeat) {
+	l := s.link(hb.ch.Endpoint)
+	if l == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	lost := l.lost
+	s.mutex.Unlock()
+
+	if lost {
+		if hb.time.Sub(l.lastHeartbeat) <= s.timeout(hb.ch) {
+			l.consecutive++
+		} else {
+			l.consecutive = 1
+		}
+	}
+	l.lastHeartbeat = hb.time
+
+	if lost && l.consecutive >= s.conf.RecoveryHeartbeats {
+		s.mutex.Lock()
+		l.lost = false
+		s.mutex.Unlock()
+
+		s.execute(l.conf.OnRecovery, Event{
+			Type:     EventRecovery,
+			Endpoint: l.conf.Endpoint,
+			Time:     hb.time,
+		})
+	}
+}
+
+func (s *Supervisor) checkTimeouts(now time.Time) {
+	for _, l := range s.links {
+		s.mutex.Lock()
+		lost := l.lost
+		s.mutex.Unlock()
+
+		if lost || now.Sub(l.lastHeartbeat) <= s.timeout(s.channel(l.conf.Endpoint)) {
+			continue
+		}
+
+		s.mutex.Lock()
+		l.lost = true
+		s.mutex.Unlock()
+		l.consecutive = 0
+
+		s.execute(l.conf.OnLoss, Event{
+			Type:     EventLoss,
+			Endpoint: l.conf.Endpoint,
+			Time:     now,
+		})
+	}
+}
+
+func (s *Supervisor) execute(actions []Action, e Event) {
+	for _, a := range actions {
+		a.execute(s, e)
+	}
+}
@@ -0,0 +1,194 @@
+package failsafe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func testGcs(t *testing.T, address string) *gomavlib.Node {
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: address},
+		},
+		HeartbeatPeriod: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	return gcs
+}
+
+func waitEvent(t *testing.T, events chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("event not received")
+	}
+	return Event{}
+}
+
+func TestSupervisor(t *testing.T) {
+	companion, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5730"},
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5731"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer companion.Close()
+
+	go func() {
+		for range companion.Events() {
+		}
+	}()
+
+	primary := companion.Endpoints()[0]
+	backup := companion.Endpoints()[1]
+
+	gcs1 := testGcs(t, "127.0.0.1:5730")
+	gcs2 := testGcs(t, "127.0.0.1:5731")
+	defer gcs2.Close()
+
+	commands := make(chan *common.MessageCommandLong, 10)
+	go func() {
+		for evt := range gcs2.Events() {
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if cmd, ok := fr.Message().(*common.MessageCommandLong); ok {
+					commands <- cmd
+				}
+			}
+		}
+	}()
+	go func() {
+		for range gcs1.Events() {
+		}
+	}()
+
+	events := make(chan Event, 10)
+	s, err := New(Conf{
+		Node: companion,
+		Links: []Link{
+			{
+				Endpoint: primary,
+				OnLoss: []Action{
+					ActionSwitchEndpoint{},
+					ActionReturnToLaunch(backup, 255, 0),
+					ActionFunc(func(e Event) { events <- e }),
+				},
+				OnRecovery: []Action{
+					ActionSwitchEndpoint{},
+					ActionFunc(func(e Event) { events <- e }),
+				},
+			},
+			{
+				Endpoint: backup,
+			},
+		},
+		SystemId:           255,
+		Timeout:            300 * time.Millisecond,
+		RecoveryHeartbeats: 3,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, primary, s.Active())
+	require.Equal(t, false, s.Lost(primary))
+
+	// the primary link is lost
+	gcs1.Close()
+
+	e := waitEvent(t, events)
+	require.Equal(t, EventLoss, e.Type)
+	require.Equal(t, primary, e.Endpoint)
+	require.Equal(t, true, s.Lost(primary))
+	require.Equal(t, backup, s.Active())
+
+	select {
+	case cmd := <-commands:
+		require.Equal(t, common.MAV_CMD_NAV_RETURN_TO_LAUNCH, cmd.Command)
+	case <-time.After(1 * time.Second):
+		t.Fatal("command not received")
+	}
+
+	// the primary link is recovered
+	gcs1 = testGcs(t, "127.0.0.1:5730")
+	defer gcs1.Close()
+	go func() {
+		for range gcs1.Events() {
+		}
+	}()
+
+	e = waitEvent(t, events)
+	require.Equal(t, EventRecovery, e.Type)
+	require.Equal(t, primary, e.Endpoint)
+	require.Equal(t, false, s.Lost(primary))
+	require.Equal(t, primary, s.Active())
+}
+
+func TestSupervisorRTT(t *testing.T) {
+	companion, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5732"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer companion.Close()
+
+	channels := make(chan *gomavlib.Channel, 1)
+	go func() {
+		for evt := range companion.Events() {
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				select {
+				case channels <- fr.Channel:
+				default:
+				}
+			}
+		}
+	}()
+
+	gcs := testGcs(t, "127.0.0.1:5732")
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	events := make(chan Event, 10)
+	s, err := New(Conf{
+		Node: companion,
+		Links: []Link{{
+			Endpoint: companion.Endpoints()[0],
+			OnLoss:   []Action{ActionFunc(func(e Event) { events <- e })},
+		}},
+		Timeout:   200 * time.Millisecond,
+		RTTFactor: 4,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the link has a round trip time of 250ms, therefore the timeout is 1s
+	(<-channels).ReportRTT(250 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	gcs.Close()
+
+	e := waitEvent(t, events)
+	require.Equal(t, EventLoss, e.Type)
+	require.True(t, time.Since(start) >= 800*time.Millisecond)
+}
@@ -0,0 +1,324 @@
+// Package fence implements a monitor that detects geofence breaches and
+// recoveries of a vehicle, and reports them as structured events.
+//
+// Breaches are detected with FENCE_STATUS. Vehicles that do not send
+// FENCE_STATUS are supported by matching the text of STATUSTEXT messages,
+// that is ignored as soon as a FENCE_STATUS is received.
+//
+// The node to which the monitor is attached must use a dialect that contains
+// the common messages.
+package fence
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	frameQueueSize = 16
+)
+
+var (
+	defaultBreachTexts = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)fence.*(breach|violat)`),
+	}
+	defaultRecoveryTexts = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)fence.*(clear|resolved|recovered|returned)`),
+	}
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventBreach means that the vehicle has breached the fence.
+	EventBreach EventType = iota
+
+	// EventRecovery means that the vehicle is inside the fence again.
+	EventRecovery
+)
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	switch t {
+	case EventBreach:
+		return "breach"
+	case EventRecovery:
+		return "recovery"
+	}
+	return "unknown"
+}
+
+// Event is a breach or a recovery.
+type Event struct {
+	// the type of the event.
+	Type EventType
+
+	// the type of the last breach. It is FENCE_BREACH_NONE when the event has
+	// been generated from a STATUSTEXT.
+	BreachType common.FENCE_BREACH
+
+	// the number of breaches since the vehicle has booted, or since the
+	// monitor has been created when events are generated from STATUSTEXT.
+	BreachCount int
+
+	// the action taken by the vehicle to prevent the breach.
+	Mitigation common.FENCE_MITIGATE
+
+	// the time at which the message that generated the event has been received.
+	Time time.Time
+
+	// the text of the STATUSTEXT that generated the event, if any.
+	Text string
+}
+
+// Conf allows to configure a Monitor.
+type Conf struct {
+	// the node from which messages are read.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle. It defaults to 1.
+	ComponentId byte
+
+	// called when a breach or a recovery is detected.
+	// It is called by a dedicated routine, one event at a time.
+	OnEvent func(Event)
+
+	// (optional) expressions that detect breaches in STATUSTEXT messages.
+	// They default to an expression that matches texts like "Fence breached"
+	// and "Geofence violated".
+	BreachTexts []*regexp.Regexp
+
+	// (optional) expressions that detect recoveries in STATUSTEXT messages.
+	// They are evaluated before BreachTexts.
+	// They default to an expression that matches texts like "Fence breach cleared".
+	RecoveryTexts []*regexp.Regexp
+}
+
+// Monitor detects geofence breaches and recoveries.
+type Monitor struct {
+	conf          Conf
+	removeHandler func()
+
+	mutex       sync.Mutex
+	breached    bool
+	breachCount int
+
+	// accessed by run() only
+	statusReceived bool
+
+	frames    chan *gomavlib.EventFrame
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Monitor. See Conf for the options.
+func New(conf Conf) (*Monitor, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.OnEvent == nil {
+		return nil, fmt.Errorf("OnEvent not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageFenceStatus{},
+		&common.MessageStatustext{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.BreachTexts == nil {
+		conf.BreachTexts = defaultBreachTexts
+	}
+	if conf.RecoveryTexts == nil {
+		conf.RecoveryTexts = defaultRecoveryTexts
+	}
+
+	m := &Monitor{
+		conf:      conf,
+		frames:    make(chan *gomavlib.EventFrame, frameQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	m.removeHandler = conf.Node.AddFrameHandler(m.onEventFrame)
+
+	go m.run()
+
+	return m, nil
+}
+
+// Close stops the monitor. It must be called before closing the node.
+func (m *Monitor) Close() {
+	m.removeHandler()
+	close(m.terminate)
+	<-m.done
+}
+
+// Breached returns whether the vehicle is currently outside the fence.
+func (m *Monitor) Breached() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.breached
+}
+
+// BreachCount returns the number of breaches.
+func (m *Monitor) BreachCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.breachCount
+}
+
+func (m *Monitor) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != m.conf.SystemId || evt.ComponentId() != m.conf.ComponentId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageFenceStatus{}).GetId(),
+		(&common.MessageStatustext{}).GetId():
+
+	default:
+		return
+	}
+
+	// frame handlers must not block; frames are dropped when the queue is full
+	select {
+	case m.frames <- evt:
+	default:
+	}
+}
+
+func (m *Monitor) run() {
+	defer close(m.done)
+
+	for {
+		select {
+		case evt := <-m.frames:
+			e, ok := m.process(evt)
+			if ok {
+				m.conf.OnEvent(e)
+			}
+
+		case <-m.terminate:
+			return
+		}
+	}
+}
+
+func (m *Monitor) process(evt *gomavlib.EventFrame) (Event, bool) {
+	switch evt.Message().GetId() {
+	case (&common.MessageFenceStatus{}).GetId():
+		var fs common.MessageFenceStatus
+		if msg.Convert(&fs, evt.Message()) != nil {
+			return Event{}, false
+		}
+		m.statusReceived = true
+		return m.processStatus(evt, &fs)
+
+	case (&common.MessageStatustext{}).GetId():
+		if m.statusReceived {
+			return Event{}, false
+		}
+
+		var st common.MessageStatustext
+		if msg.Convert(&st, evt.Message()) != nil {
+			return Event{}, false
+		}
+		return m.processText(evt, st.Text)
+	}
+
+	return Event{}, false
+}
+
+func (m *Monitor) processStatus(evt *gomavlib.EventFrame, fs *common.MessageFenceStatus) (Event, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e := Event{
+		BreachType:  fs.BreachType,
+		BreachCount: int(fs.BreachCount),
+		Mitigation:  fs.BreachMitigation,
+		Time:        evt.ReceiveTime,
+	}
+
+	breached := fs.BreachStatus != 0
+	newBreach := breached && (!m.breached || int(fs.BreachCount) > m.breachCount)
+	recovery := !breached && m.breached
+
+	m.breached = breached
+	m.breachCount = int(fs.BreachCount)
+
+	switch {
+	case newBreach:
+		e.Type = EventBreach
+		return e, true
+
+	case recovery:
+		e.Type = EventRecovery
+		return e, true
+	}
+
+	return Event{}, false
+}
+
+func (m *Monitor) processText(evt *gomavlib.EventFrame, text string) (Event, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e := Event{
+		Time: evt.ReceiveTime,
+		Text: text,
+	}
+
+	for _, re := range m.conf.RecoveryTexts {
+		if re.MatchString(text) {
+			if !m.breached {
+				return Event{}, false
+			}
+
+			m.breached = false
+			e.Type = EventRecovery
+			e.BreachCount = m.breachCount
+			return e, true
+		}
+	}
+
+	for _, re := range m.conf.BreachTexts {
+		if re.MatchString(text) {
+			if m.breached {
+				return Event{}, false
+			}
+
+			m.breached = true
+			m.breachCount++
+			e.Type = EventBreach
+			e.BreachCount = m.breachCount
+			return e, true
+		}
+	}
+
+	return Event{}, false
+}
@@ -0,0 +1,166 @@
+package fence
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func testNodes(t *testing.T) (*gomavlib.Node, *gomavlib.Node) {
+	c1, c2 := net.Pipe()
+
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      255,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: c1}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	vehicle, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      1,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: c2}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range vehicle.Events() {
+		}
+	}()
+
+	return gcs, vehicle
+}
+
+func waitEvent(t *testing.T, events chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(1 * time.Second):
+		t.Fatal("event not received")
+	}
+	return Event{}
+}
+
+func TestMonitorFenceStatus(t *testing.T) {
+	gcs, vehicle := testNodes(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	events := make(chan Event, 10)
+	m, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(e Event) {
+			events <- e
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	status := func(breached bool, count uint16, typ common.FENCE_BREACH) {
+		fs := &common.MessageFenceStatus{
+			BreachCount:      count,
+			BreachType:       typ,
+			BreachMitigation: common.FENCE_MITIGATE_NONE,
+		}
+		if breached {
+			fs.BreachStatus = 1
+		}
+		vehicle.WriteMessageAll(fs)
+	}
+
+	status(false, 0, common.FENCE_BREACH_NONE)
+	status(true, 1, common.FENCE_BREACH_MAXALT)
+
+	e := waitEvent(t, events)
+	require.Equal(t, EventBreach, e.Type)
+	require.Equal(t, common.FENCE_BREACH_MAXALT, e.BreachType)
+	require.Equal(t, 1, e.BreachCount)
+	require.Equal(t, common.FENCE_MITIGATE_NONE, e.Mitigation)
+	require.False(t, e.Time.IsZero())
+	require.Equal(t, true, m.Breached())
+
+	// repeated status does not generate events
+	status(true, 1, common.FENCE_BREACH_MAXALT)
+
+	// status text is ignored after FENCE_STATUS has been received
+	vehicle.WriteMessageAll(&common.MessageStatustext{
+		Severity: common.MAV_SEVERITY_WARNING,
+		Text:     "Fence breach cleared",
+	})
+
+	// a new breach while still breached generates an event
+	status(true, 2, common.FENCE_BREACH_BOUNDARY)
+
+	e = waitEvent(t, events)
+	require.Equal(t, EventBreach, e.Type)
+	require.Equal(t, common.FENCE_BREACH_BOUNDARY, e.BreachType)
+	require.Equal(t, 2, e.BreachCount)
+
+	status(false, 2, common.FENCE_BREACH_BOUNDARY)
+
+	e = waitEvent(t, events)
+	require.Equal(t, EventRecovery, e.Type)
+	require.Equal(t, 2, e.BreachCount)
+	require.Equal(t, false, m.Breached())
+	require.Equal(t, 2, m.BreachCount())
+}
+
+func TestMonitorStatusText(t *testing.T) {
+	gcs, vehicle := testNodes(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+
+	events := make(chan Event, 10)
+	m, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		OnEvent: func(e Event) {
+			events <- e
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	for _, text := range []string{
+		"Mode changed",
+		"Geofence violated",
+		"Geofence violated",
+		"Fence breach cleared",
+	} {
+		vehicle.WriteMessageAll(&common.MessageStatustext{
+			Severity: common.MAV_SEVERITY_CRITICAL,
+			Text:     text,
+		})
+	}
+
+	e := waitEvent(t, events)
+	require.Equal(t, EventBreach, e.Type)
+	require.Equal(t, common.FENCE_BREACH_NONE, e.BreachType)
+	require.Equal(t, 1, e.BreachCount)
+	require.Equal(t, "Geofence violated", e.Text)
+
+	e = waitEvent(t, events)
+	require.Equal(t, EventRecovery, e.Type)
+	require.Equal(t, "Fence breach cleared", e.Text)
+
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
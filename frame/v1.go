@@ -15,6 +15,10 @@ const (
 	V1MagicByte = 0xFE
 )
 
+// ErrV2OnlyMessage is returned when a message with an id > 0xFF is encoded
+// inside a V1 frame, that can't contain it.
+var ErrV2OnlyMessage = fmt.Errorf("cannot send a message with an id > 0xFF inside a V1 frame")
+
 // V1Frame is a Mavlink V1 frame.
 type V1Frame struct {
 	SequenceId  byte
@@ -96,8 +100,8 @@ func (f *V1Frame) Decode(br *bufio.Reader) error {
 
 // Encode implements the Frame interface.
 func (f *V1Frame) Encode(buf []byte, msgEncoded []byte) ([]byte, error) {
-	if f.Message.GetId() > 0xFF {
-		return nil, fmt.Errorf("cannot send a message with an id > 0xFF inside a V1 frame")
+	if msg.IsV2Only(f.Message) {
+		return nil, ErrV2OnlyMessage
 	}
 
 	msgLen := len(msgEncoded)
@@ -19,6 +19,10 @@ const (
 	V2FlagSigned = 0x01
 )
 
+// ErrMessageIdTooLarge is returned when a message with an id that does not fit
+// into 24 bits is encoded, instead of truncating the id.
+var ErrMessageIdTooLarge = fmt.Errorf("cannot send a message with an id > 0xFFFFFF")
+
 func uint24Decode(in []byte) uint32 {
 	return uint32(in[2])<<16 | uint32(in[1])<<8 | uint32(in[0])
 }
@@ -174,6 +178,10 @@ func (f *V2Frame) Decode(br *bufio.Reader) error {
 
 // Encode implements the Frame interface.
 func (f *V2Frame) Encode(buf []byte, msgEncoded []byte) ([]byte, error) {
+	if f.Message.GetId() > msg.MaxId {
+		return nil, ErrMessageIdTooLarge
+	}
+
 	msgLen := len(msgEncoded)
 	bufLen := 10 + msgLen + 2
 	if f.IsSigned() {
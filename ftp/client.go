@@ -0,0 +1,590 @@
+package ftp
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const payloadQueueSize = 64
+
+// ErrTimeout is returned when the vehicle stops answering.
+var ErrTimeout = fmt.Errorf("ftp request timed out")
+
+// ErrChecksum is returned when the CRC32 of a transferred file differs from
+// the one computed by the vehicle.
+var ErrChecksum = fmt.Errorf("file checksum mismatch")
+
+// Entry is an entry of a directory.
+type Entry struct {
+	// the name of the entry.
+	Name string
+
+	// whether the entry is a directory.
+	IsDir bool
+
+	// the size of the entry, if it is a file.
+	Size uint32
+}
+
+// ClientConf allows to configure a Client.
+type ClientConf struct {
+	// the node with which files are transferred.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the time after which a request that has not been answered
+	// is sent again. It defaults to 1 second.
+	Timeout time.Duration
+
+	// (optional) the maximum number of retransmissions of a request.
+	// It defaults to 3.
+	MaxRetransmissions int
+
+	// (optional) reads files in bursts, in which the vehicle sends multiple
+	// chunks per request. Lost chunks are requested again.
+	BurstRead bool
+
+	// (optional) disables the verification of transferred files through
+	// their CRC32. Verification is skipped anyway when the vehicle does
+	// not support it.
+	DisableChecksum bool
+}
+
+// Client transfers files to and from a vehicle.
+type Client struct {
+	conf          ClientConf
+	removeHandler func()
+
+	// serializes requests
+	requestMutex sync.Mutex
+	seq          uint16
+
+	mutex    sync.Mutex
+	active   bool
+	payloads chan *payload
+
+	terminate chan struct{}
+}
+
+// NewClient allocates a Client. See ClientConf for the options.
+func NewClient(conf ClientConf) (*Client, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(&common.MessageFileTransferProtocol{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 1 * time.Second
+	}
+	if conf.MaxRetransmissions == 0 {
+		conf.MaxRetransmissions = 3
+	}
+
+	c := &Client{
+		conf:      conf,
+		payloads:  make(chan *payload, payloadQueueSize),
+		terminate: make(chan struct{}),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	return c, nil
+}
+
+// Close stops the client. Pending requests return an error.
+// It must be called before closing the node.
+func (c *Client) Close() {
+	c.removeHandler()
+	close(c.terminate)
+}
+
+func (c *Client) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != c.conf.SystemId || evt.ComponentId() != c.conf.ComponentId {
+		return
+	}
+
+	if evt.Message().GetId() != (&common.MessageFileTransferProtocol{}).GetId() {
+		return
+	}
+
+	var m common.MessageFileTransferProtocol
+	if msg.Convert(&m, evt.Message()) != nil {
+		return
+	}
+
+	nconf := c.conf.Node.Conf()
+	if (m.TargetSystem != 0 && m.TargetSystem != nconf.OutSystemId) ||
+		(m.TargetComponent != 0 && m.TargetComponent != nconf.OutComponentId) {
+		return
+	}
+
+	p, err := decodePayload(m.Payload)
+	if err != nil || (p.opcode != opAck && p.opcode != opNak) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.active {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case c.payloads <- p:
+	default:
+	}
+}
+
+func (c *Client) startRequest() {
+	c.requestMutex.Lock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.active = true
+
+	// discard the replies of previous requests
+	for len(c.payloads) > 0 {
+		<-c.payloads
+	}
+}
+
+func (c *Client) stopRequest() {
+	c.mutex.Lock()
+	c.active = false
+	c.mutex.Unlock()
+
+	c.requestMutex.Unlock()
+}
+
+func (c *Client) write(p *payload) {
+	c.conf.Node.WriteMessageAll(&common.MessageFileTransferProtocol{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		Payload:         p.encode(),
+	})
+}
+
+// request writes a request and waits for its reply. The request is sent
+// again, with the same sequence number, when the reply does not arrive
+// within the timeout, allowing the vehicle to detect duplicates.
+// A NAK is returned as a *NakError.
+func (c *Client) request(ctx context.Context, req *payload) (*payload, error) {
+	c.seq++
+	req.seq = c.seq
+	c.write(req)
+
+	retransmissions := 0
+
+	timer := time.NewTimer(c.conf.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case p := <-c.payloads:
+			// replies of previous requests may still be queued
+			if p.seq != req.seq+1 || p.reqOpcode != req.opcode {
+				continue
+			}
+
+			c.seq = p.seq
+
+			if p.opcode == opNak {
+				return nil, p.nakError()
+			}
+			return p, nil
+
+		case <-timer.C:
+			if retransmissions >= c.conf.MaxRetransmissions {
+				return nil, ErrTimeout
+			}
+
+			retransmissions++
+			c.write(req)
+			timer.Reset(c.conf.Timeout)
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-c.terminate:
+			return nil, fmt.Errorf("terminated")
+		}
+	}
+}
+
+func pathData(path string) ([]byte, error) {
+	if path == "" || len(path) > maxDataLength {
+		return nil, fmt.Errorf("invalid path: '%s'", path)
+	}
+	return []byte(path), nil
+}
+
+// requestPath performs a request whose data is a path.
+func (c *Client) requestPath(ctx context.Context, op opcode, path string) (*payload, error) {
+	data, err := pathData(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.request(ctx, &payload{opcode: op, data: data})
+}
+
+// terminateSession closes a session. Since sessions are limited, they are
+// closed even when the request that opened them has been canceled.
+func (c *Client) terminateSession(session uint8) {
+	c.request(context.Background(), &payload{
+		opcode:  opTerminateSession,
+		session: session,
+	})
+}
+
+// ListDirectory lists the entries of a directory of the vehicle.
+func (c *Client) ListDirectory(ctx context.Context, path string) ([]Entry, error) {
+	data, err := pathData(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.startRequest()
+	defer c.stopRequest()
+
+	var entries []Entry
+	offset := uint32(0)
+
+	for {
+		res, err := c.request(ctx, &payload{
+			opcode: opListDirectory,
+			offset: offset,
+			data:   data,
+		})
+		if err != nil {
+			if isNak(err, NakEOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		count := 0
+
+		for _, raw := range strings.Split(string(res.data), "\x00") {
+			if raw == "" {
+				continue
+			}
+			count++
+
+			switch raw[0] {
+			case 'F':
+				parts := strings.SplitN(raw[1:], "\t", 2)
+				e := Entry{Name: parts[0]}
+				if len(parts) == 2 {
+					size, err := strconv.ParseUint(parts[1], 10, 32)
+					if err != nil {
+						return nil, fmt.Errorf("invalid directory entry: '%s'", raw)
+					}
+					e.Size = uint32(size)
+				}
+				entries = append(entries, e)
+
+			case 'D':
+				name := raw[1:]
+				if name != "." && name != ".." {
+					entries = append(entries, Entry{Name: name, IsDir: true})
+				}
+
+			case 'S':
+				// entry skipped by the vehicle
+
+			default:
+				return nil, fmt.Errorf("invalid directory entry: '%s'", raw)
+			}
+		}
+
+		if count == 0 {
+			return entries, nil
+		}
+		offset += uint32(count)
+	}
+}
+
+// ReadFile reads a file of the vehicle.
+// Unless DisableChecksum is set, its content is verified with the CRC32
+// computed by the vehicle, and ErrChecksum is returned in case of mismatch.
+func (c *Client) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	data, err := pathData(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.startRequest()
+	defer c.stopRequest()
+
+	res, err := c.request(ctx, &payload{opcode: opOpenFileRO, data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	session := res.session
+
+	var size uint32
+	if len(res.data) >= 4 {
+		size = binary.LittleEndian.Uint32(res.data)
+	}
+
+	content, err := func() ([]byte, error) {
+		defer c.terminateSession(session)
+
+		if c.conf.BurstRead {
+			return c.readBurst(ctx, session, size)
+		}
+		return c.read(ctx, session, size)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.verify(ctx, path, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+func (c *Client) read(ctx context.Context, session uint8, size uint32) ([]byte, error) {
+	content := make([]byte, 0, size)
+
+	for {
+		res, err := c.request(ctx, &payload{
+			opcode:  opReadFile,
+			session: session,
+			offset:  uint32(len(content)),
+			data:    make([]byte, maxDataLength),
+		})
+		if err != nil {
+			if isNak(err, NakEOF) {
+				return content, nil
+			}
+			return nil, err
+		}
+
+		if len(res.data) == 0 {
+			return content, nil
+		}
+
+		content = append(content, res.data...)
+	}
+}
+
+// readBurst reads a file in bursts. Chunks are accepted only when they are
+// contiguous to the received ones; when a burst ends or stops, a new one is
+// requested from the first missing offset.
+func (c *Client) readBurst(ctx context.Context, session uint8, size uint32) ([]byte, error) {
+	content := make([]byte, 0, size)
+
+	// the data of the request contains only the size of the chunks
+	burst := func() {
+		c.seq++
+		c.write(&payload{
+			seq:     c.seq,
+			opcode:  opBurstReadFile,
+			session: session,
+			offset:  uint32(len(content)),
+			data:    make([]byte, maxDataLength),
+		})
+	}
+	burst()
+
+	retransmissions := 0
+
+	timer := time.NewTimer(c.conf.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case p := <-c.payloads:
+			if p.reqOpcode != opBurstReadFile || p.session != session {
+				continue
+			}
+
+			if p.opcode == opNak {
+				err := p.nakError()
+				if err.Code == NakEOF {
+					return content, nil
+				}
+				return nil, err
+			}
+
+			if p.offset == uint32(len(content)) {
+				if len(p.data) == 0 {
+					return content, nil
+				}
+
+				content = append(content, p.data...)
+				retransmissions = 0
+			}
+
+			if p.burstComplete {
+				burst()
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(c.conf.Timeout)
+
+		case <-timer.C:
+			if retransmissions >= c.conf.MaxRetransmissions {
+				return nil, ErrTimeout
+			}
+
+			retransmissions++
+			burst()
+			timer.Reset(c.conf.Timeout)
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-c.terminate:
+			return nil, fmt.Errorf("terminated")
+		}
+	}
+}
+
+// WriteFile writes a file of the vehicle, replacing it if it exists.
+// Unless DisableChecksum is set, the written file is verified with the CRC32
+// computed by the vehicle, and ErrChecksum is returned in case of mismatch.
+func (c *Client) WriteFile(ctx context.Context, path string, content []byte) error {
+	data, err := pathData(path)
+	if err != nil {
+		return err
+	}
+
+	c.startRequest()
+	defer c.stopRequest()
+
+	res, err := c.request(ctx, &payload{opcode: opCreateFile, data: data})
+	if err != nil {
+		return err
+	}
+
+	session := res.session
+
+	err = func() error {
+		defer c.terminateSession(session)
+
+		for offset := 0; offset < len(content); offset += maxDataLength {
+			end := offset + maxDataLength
+			if end > len(content) {
+				end = len(content)
+			}
+
+			_, err := c.request(ctx, &payload{
+				opcode:  opWriteFile,
+				session: session,
+				offset:  uint32(offset),
+				data:    content[offset:end],
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	return c.verify(ctx, path, content)
+}
+
+// verify compares the CRC32 of a file with the one computed by the vehicle.
+func (c *Client) verify(ctx context.Context, path string, content []byte) error {
+	if c.conf.DisableChecksum {
+		return nil
+	}
+
+	crc, err := c.checksum(ctx, path)
+	if err != nil {
+		if isNak(err, NakUnknownCommand) {
+			return nil
+		}
+		return err
+	}
+
+	if crc != checksum(0, content) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func (c *Client) checksum(ctx context.Context, path string) (uint32, error) {
+	res, err := c.requestPath(ctx, opCalcFileCRC32, path)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(res.data) < 4 {
+		return 0, fmt.Errorf("invalid checksum size: %d", len(res.data))
+	}
+
+	return binary.LittleEndian.Uint32(res.data), nil
+}
+
+// Checksum returns the CRC32 of a file of the vehicle, computed by the
+// vehicle itself.
+func (c *Client) Checksum(ctx context.Context, path string) (uint32, error) {
+	c.startRequest()
+	defer c.stopRequest()
+
+	return c.checksum(ctx, path)
+}
+
+// Remove removes a file of the vehicle.
+func (c *Client) Remove(ctx context.Context, path string) error {
+	c.startRequest()
+	defer c.stopRequest()
+
+	_, err := c.requestPath(ctx, opRemoveFile, path)
+	return err
+}
+
+// RemoveDirectory removes an empty directory of the vehicle.
+func (c *Client) RemoveDirectory(ctx context.Context, path string) error {
+	c.startRequest()
+	defer c.stopRequest()
+
+	_, err := c.requestPath(ctx, opRemoveDirectory, path)
+	return err
+}
@@ -0,0 +1,390 @@
+package ftp
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+// testVehicle implements the vehicle side of the file transfer protocol,
+// with an in-memory file system.
+type testVehicle struct {
+	node *gomavlib.Node
+
+	mutex sync.Mutex
+	// directories with their raw entries
+	dirs     map[string][]string
+	files    map[string][]byte
+	sessions map[uint8]string
+	// the number of chunks sent per burst
+	burstSize int
+	// offset of a chunk that is not sent, to simulate losses
+	drop int
+	// disables CRC32 computation
+	noCRC bool
+	// alters the computed CRC32
+	badCRC bool
+	// disables answers
+	mute bool
+}
+
+func newTestVehicle(t *testing.T) (*gomavlib.Node, *testVehicle) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{
+		node: node,
+		dirs: map[string][]string{
+			"/APM": {"D.", "D..", "FLOG.BIN\t1000", "Sskipped", "Dscripts", "Fparams.txt\t12"},
+		},
+		files: map[string][]byte{
+			"/APM/LOG.BIN": testContent(1000),
+		},
+		sessions:  make(map[uint8]string),
+		burstSize: 2,
+		drop:      -1,
+	}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		if m, ok := fr.Message().(*common.MessageFileTransferProtocol); ok {
+			v.onMessage(m)
+		}
+	})
+
+	return gcs, v
+}
+
+func testContent(n int) []byte {
+	ret := make([]byte, n)
+	for i := range ret {
+		ret[i] = byte(i * 7)
+	}
+	return ret
+}
+
+func (v *testVehicle) write(p *payload) {
+	v.node.WriteMessageAll(&common.MessageFileTransferProtocol{
+		TargetSystem:    255,
+		TargetComponent: 1,
+		Payload:         p.encode(),
+	})
+}
+
+func (v *testVehicle) ack(req *payload, session uint8, data []byte) {
+	v.write(&payload{
+		seq:       req.seq + 1,
+		session:   session,
+		opcode:    opAck,
+		reqOpcode: req.opcode,
+		offset:    req.offset,
+		data:      data,
+	})
+}
+
+func (v *testVehicle) nak(req *payload, code NakCode) {
+	v.write(&payload{
+		seq:       req.seq + 1,
+		session:   req.session,
+		opcode:    opNak,
+		reqOpcode: req.opcode,
+		data:      []byte{uint8(code)},
+	})
+}
+
+func (v *testVehicle) onMessage(m *common.MessageFileTransferProtocol) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.mute {
+		return
+	}
+
+	req, err := decodePayload(m.Payload)
+	if err != nil {
+		return
+	}
+
+	path := string(req.data)
+
+	switch req.opcode {
+	case opListDirectory:
+		entries, ok := v.dirs[path]
+		if !ok {
+			v.nak(req, NakFileNotFound)
+			return
+		}
+
+		if int(req.offset) >= len(entries) {
+			v.nak(req, NakEOF)
+			return
+		}
+
+		// at most two entries per reply
+		end := int(req.offset) + 2
+		if end > len(entries) {
+			end = len(entries)
+		}
+		v.ack(req, 0, []byte(strings.Join(entries[req.offset:end], "\x00")+"\x00"))
+
+	case opOpenFileRO:
+		content, ok := v.files[path]
+		if !ok {
+			v.nak(req, NakFileNotFound)
+			return
+		}
+
+		v.sessions[1] = path
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(content)))
+		v.ack(req, 1, size)
+
+	case opReadFile:
+		content := v.files[v.sessions[req.session]]
+		if int(req.offset) >= len(content) {
+			v.nak(req, NakEOF)
+			return
+		}
+
+		end := int(req.offset) + len(req.data)
+		if end > len(content) {
+			end = len(content)
+		}
+		v.ack(req, req.session, content[req.offset:end])
+
+	case opBurstReadFile:
+		content := v.files[v.sessions[req.session]]
+		offset := int(req.offset)
+		seq := req.seq
+
+		for i := 0; i < v.burstSize; i++ {
+			seq++
+
+			if offset >= len(content) {
+				v.write(&payload{
+					seq:       seq,
+					session:   req.session,
+					opcode:    opNak,
+					reqOpcode: opBurstReadFile,
+					data:      []byte{uint8(NakEOF)},
+				})
+				return
+			}
+
+			end := offset + len(req.data)
+			if end > len(content) {
+				end = len(content)
+			}
+
+			if offset == v.drop {
+				v.drop = -1
+			} else {
+				v.write(&payload{
+					seq:           seq,
+					session:       req.session,
+					opcode:        opAck,
+					reqOpcode:     opBurstReadFile,
+					burstComplete: i == v.burstSize-1,
+					offset:        uint32(offset),
+					data:          content[offset:end],
+				})
+			}
+
+			offset = end
+		}
+
+	case opCreateFile:
+		v.files[path] = nil
+		v.sessions[2] = path
+		v.ack(req, 2, nil)
+
+	case opWriteFile:
+		path := v.sessions[req.session]
+		content := v.files[path]
+		end := int(req.offset) + len(req.data)
+		if end > len(content) {
+			content = append(content, make([]byte, end-len(content))...)
+		}
+		copy(content[req.offset:], req.data)
+		v.files[path] = content
+		v.ack(req, req.session, nil)
+
+	case opTerminateSession:
+		delete(v.sessions, req.session)
+		v.ack(req, req.session, nil)
+
+	case opRemoveFile:
+		if _, ok := v.files[path]; !ok {
+			v.nak(req, NakFileNotFound)
+			return
+		}
+		delete(v.files, path)
+		v.ack(req, 0, nil)
+
+	case opCalcFileCRC32:
+		if v.noCRC {
+			v.nak(req, NakUnknownCommand)
+			return
+		}
+
+		crc := checksum(0, v.files[path])
+		if v.badCRC {
+			crc++
+		}
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, crc)
+		v.ack(req, 0, buf)
+
+	default:
+		v.nak(req, NakUnknownCommand)
+	}
+}
+
+func newTestClient(t *testing.T, gcs *gomavlib.Node, burst bool) *Client {
+	c, err := NewClient(ClientConf{
+		Node:               gcs,
+		SystemId:           1,
+		Timeout:            100 * time.Millisecond,
+		MaxRetransmissions: 2,
+		BurstRead:          burst,
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func TestNewClientErrors(t *testing.T) {
+	_, err := NewClient(ClientConf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	_, err = NewClient(ClientConf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+}
+
+func TestClientListDirectory(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs, false)
+	defer c.Close()
+
+	entries, err := c.ListDirectory(context.Background(), "/APM")
+	require.NoError(t, err)
+	require.Equal(t, []Entry{
+		{Name: "LOG.BIN", Size: 1000},
+		{Name: "scripts", IsDir: true},
+		{Name: "params.txt", Size: 12},
+	}, entries)
+
+	_, err = c.ListDirectory(context.Background(), "/missing")
+	require.Equal(t, &NakError{Code: NakFileNotFound}, err)
+}
+
+func TestClientReadFile(t *testing.T) {
+	for _, ca := range []string{"standard", "burst", "burst with losses"} {
+		t.Run(ca, func(t *testing.T) {
+			gcs, v := newTestVehicle(t)
+			defer gcs.Close()
+			defer v.node.Close()
+
+			if ca == "burst with losses" {
+				v.drop = maxDataLength * 2
+			}
+
+			c := newTestClient(t, gcs, ca != "standard")
+			defer c.Close()
+
+			content, err := c.ReadFile(context.Background(), "/APM/LOG.BIN")
+			require.NoError(t, err)
+			require.Equal(t, testContent(1000), content)
+
+			// sessions are closed
+			v.mutex.Lock()
+			require.Equal(t, 0, len(v.sessions))
+			v.mutex.Unlock()
+
+			_, err = c.ReadFile(context.Background(), "/APM/missing")
+			require.Equal(t, &NakError{Code: NakFileNotFound}, err)
+		})
+	}
+}
+
+func TestClientWriteFileRemove(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs, false)
+	defer c.Close()
+
+	err := c.WriteFile(context.Background(), "/APM/scripts/test.lua", testContent(500))
+	require.NoError(t, err)
+
+	v.mutex.Lock()
+	require.Equal(t, testContent(500), v.files["/APM/scripts/test.lua"])
+	require.Equal(t, 0, len(v.sessions))
+	v.mutex.Unlock()
+
+	err = c.Remove(context.Background(), "/APM/scripts/test.lua")
+	require.NoError(t, err)
+
+	err = c.Remove(context.Background(), "/APM/scripts/test.lua")
+	require.Equal(t, &NakError{Code: NakFileNotFound}, err)
+}
+
+func TestClientChecksum(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs, false)
+	defer c.Close()
+
+	crc, err := c.Checksum(context.Background(), "/APM/LOG.BIN")
+	require.NoError(t, err)
+	require.Equal(t, checksum(0, testContent(1000)), crc)
+
+	v.mutex.Lock()
+	v.badCRC = true
+	v.mutex.Unlock()
+	_, err = c.ReadFile(context.Background(), "/APM/LOG.BIN")
+	require.Equal(t, ErrChecksum, err)
+
+	// verification is skipped when not supported
+	v.mutex.Lock()
+	v.noCRC = true
+	v.mutex.Unlock()
+	_, err = c.ReadFile(context.Background(), "/APM/LOG.BIN")
+	require.NoError(t, err)
+}
+
+func TestClientTimeout(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs, false)
+	defer c.Close()
+
+	v.mute = true
+
+	_, err := c.ListDirectory(context.Background(), "/APM")
+	require.Equal(t, ErrTimeout, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.ReadFile(ctx, "/APM/LOG.BIN")
+	require.Equal(t, context.DeadlineExceeded, err)
+}
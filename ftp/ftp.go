@@ -0,0 +1,170 @@
+// Package ftp implements the client side of the MAVLink file transfer
+// protocol, that allows to list, read, write and remove the files of a
+// vehicle, i.e. to download logs or to upload Lua scripts.
+//
+// Requests and replies are carried by the payload of FILE_TRANSFER_PROTOCOL
+// messages. Files are read and written in chunks; reads can be performed in
+// bursts, in which the vehicle sends multiple chunks per request.
+//
+// The node with which files are transferred must use a dialect that contains
+// the common messages.
+package ftp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	// size of the header of a payload.
+	headerLength = 12
+
+	// maximum size of the data of a payload.
+	maxDataLength = 251 - headerLength
+)
+
+type opcode uint8
+
+const (
+	opNone             opcode = 0
+	opTerminateSession opcode = 1
+	opResetSessions    opcode = 2
+	opListDirectory    opcode = 3
+	opOpenFileRO       opcode = 4
+	opReadFile         opcode = 5
+	opCreateFile       opcode = 6
+	opWriteFile        opcode = 7
+	opRemoveFile       opcode = 8
+	opCreateDirectory  opcode = 9
+	opRemoveDirectory  opcode = 10
+	opOpenFileWO       opcode = 11
+	opTruncateFile     opcode = 12
+	opRename           opcode = 13
+	opCalcFileCRC32    opcode = 14
+	opBurstReadFile    opcode = 15
+	opAck              opcode = 128
+	opNak              opcode = 129
+)
+
+// NakCode is the error code of a rejected request.
+type NakCode uint8
+
+const (
+	NakNone                NakCode = 0
+	NakFail                NakCode = 1
+	NakFailErrno           NakCode = 2
+	NakInvalidDataSize     NakCode = 3
+	NakInvalidSession      NakCode = 4
+	NakNoSessionsAvailable NakCode = 5
+	NakEOF                 NakCode = 6
+	NakUnknownCommand      NakCode = 7
+	NakFileExists          NakCode = 8
+	NakFileProtected       NakCode = 9
+	NakFileNotFound        NakCode = 10
+)
+
+var nakCodeLabels = map[NakCode]string{
+	NakNone:                "None",
+	NakFail:                "Fail",
+	NakFailErrno:           "FailErrno",
+	NakInvalidDataSize:     "InvalidDataSize",
+	NakInvalidSession:      "InvalidSession",
+	NakNoSessionsAvailable: "NoSessionsAvailable",
+	NakEOF:                 "EOF",
+	NakUnknownCommand:      "UnknownCommand",
+	NakFileExists:          "FileExists",
+	NakFileProtected:       "FileProtected",
+	NakFileNotFound:        "FileNotFound",
+}
+
+// String implements the fmt.Stringer interface.
+func (c NakCode) String() string {
+	if l, ok := nakCodeLabels[c]; ok {
+		return l
+	}
+	return fmt.Sprintf("NakCode(%d)", uint8(c))
+}
+
+// NakError is returned when a request is rejected by the vehicle.
+type NakError struct {
+	// the error code sent by the vehicle.
+	Code NakCode
+
+	// the errno of the vehicle, filled when Code is NakFailErrno.
+	Errno uint8
+}
+
+// Error implements the error interface.
+func (e *NakError) Error() string {
+	if e.Code == NakFailErrno {
+		return fmt.Sprintf("ftp request failed: %s (errno %d)", e.Code, e.Errno)
+	}
+	return fmt.Sprintf("ftp request failed: %s", e.Code)
+}
+
+func isNak(err error, code NakCode) bool {
+	nerr, ok := err.(*NakError)
+	return ok && nerr.Code == code
+}
+
+// payload is the content of a FILE_TRANSFER_PROTOCOL message.
+type payload struct {
+	seq           uint16
+	session       uint8
+	opcode        opcode
+	reqOpcode     opcode
+	burstComplete bool
+	offset        uint32
+	data          []byte
+}
+
+func (p *payload) encode() [251]uint8 {
+	var buf [251]uint8
+	binary.LittleEndian.PutUint16(buf[0:], p.seq)
+	buf[2] = p.session
+	buf[3] = uint8(p.opcode)
+	buf[4] = uint8(len(p.data))
+	buf[5] = uint8(p.reqOpcode)
+	if p.burstComplete {
+		buf[6] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[8:], p.offset)
+	copy(buf[headerLength:], p.data)
+	return buf
+}
+
+func decodePayload(buf [251]uint8) (*payload, error) {
+	size := int(buf[4])
+	if size > maxDataLength {
+		return nil, fmt.Errorf("invalid data size: %d", size)
+	}
+
+	return &payload{
+		seq:           binary.LittleEndian.Uint16(buf[0:]),
+		session:       buf[2],
+		opcode:        opcode(buf[3]),
+		reqOpcode:     opcode(buf[5]),
+		burstComplete: buf[6] != 0,
+		offset:        binary.LittleEndian.Uint32(buf[8:]),
+		data:          append([]byte(nil), buf[headerLength:headerLength+size]...),
+	}, nil
+}
+
+// nakError converts the payload of a NAK into an error.
+func (p *payload) nakError() *NakError {
+	e := &NakError{Code: NakFail}
+	if len(p.data) >= 1 {
+		e.Code = NakCode(p.data[0])
+	}
+	if e.Code == NakFailErrno && len(p.data) >= 2 {
+		e.Errno = p.data[1]
+	}
+	return e
+}
+
+// checksum computes the CRC32 of a file in the same way vehicles do, i.e.
+// without the initial and final inversions of the IEEE variant.
+func checksum(crc uint32, p []byte) uint32 {
+	return ^crc32.Update(^crc, crc32.IEEETable, p)
+}
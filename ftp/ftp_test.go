@@ -0,0 +1,62 @@
+package ftp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayload(t *testing.T) {
+	p := &payload{
+		seq:           1234,
+		session:       2,
+		opcode:        opAck,
+		reqOpcode:     opBurstReadFile,
+		burstComplete: true,
+		offset:        0x01020304,
+		data:          []byte{5, 6, 7},
+	}
+
+	buf := p.encode()
+	require.Equal(t, []uint8{0xD2, 0x04, 2, 128, 3, 15, 1, 0, 4, 3, 2, 1, 5, 6, 7, 0}, buf[:16])
+
+	dec, err := decodePayload(buf)
+	require.NoError(t, err)
+	require.Equal(t, p, dec)
+
+	buf[4] = 240
+	_, err = decodePayload(buf)
+	require.EqualError(t, err, "invalid data size: 240")
+}
+
+func TestNakError(t *testing.T) {
+	p := &payload{opcode: opNak, data: []byte{uint8(NakFailErrno), 2}}
+	require.Equal(t, &NakError{Code: NakFailErrno, Errno: 2}, p.nakError())
+	require.EqualError(t, p.nakError(), "ftp request failed: FailErrno (errno 2)")
+
+	p = &payload{opcode: opNak, data: []byte{uint8(NakFileNotFound)}}
+	require.EqualError(t, p.nakError(), "ftp request failed: FileNotFound")
+
+	require.Equal(t, "NakCode(50)", NakCode(50).String())
+}
+
+func TestChecksum(t *testing.T) {
+	// bitwise implementation used by autopilots
+	reference := func(crc uint32, p []byte) uint32 {
+		for _, b := range p {
+			crc ^= uint32(b)
+			for i := 0; i < 8; i++ {
+				if crc&1 != 0 {
+					crc = (crc >> 1) ^ 0xEDB88320
+				} else {
+					crc >>= 1
+				}
+			}
+		}
+		return crc
+	}
+
+	data := []byte("-- lua script\nreturn update, 1000\n")
+	require.Equal(t, reference(0, data), checksum(0, data))
+	require.Equal(t, reference(0, data), checksum(checksum(0, data[:10]), data[10:]))
+}
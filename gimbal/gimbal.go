@@ -0,0 +1,426 @@
+// Package gimbal implements a client of the gimbal manager protocol (v2),
+// that allows to discover the gimbals of a vehicle and to control them.
+//
+// Gimbal managers are discovered through their GIMBAL_MANAGER_INFORMATION
+// messages, that are requested with MAV_CMD_REQUEST_MESSAGE. A gimbal is
+// controlled by:
+//   - taking control of it with MAV_CMD_DO_GIMBAL_MANAGER_CONFIGURE
+//   - sending commands (MAV_CMD_DO_GIMBAL_MANAGER_PITCHYAW), that are
+//     acknowledged and retried
+//   - streaming setpoints (GIMBAL_MANAGER_SET_ATTITUDE,
+//     GIMBAL_MANAGER_SET_PITCHYAW), that are not acknowledged and whose
+//     rate is limited: setpoints that are set faster than the maximum rate
+//     replace the pending one, and the last one is always sent.
+//
+// The node to which the client is attached must use a dialect that contains
+// the common messages.
+package gimbal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// values of the system and component ids of MAV_CMD_DO_GIMBAL_MANAGER_CONFIGURE
+// that have a special meaning.
+const (
+	// no one is in control.
+	ControlNone = 0
+
+	// the control is left unchanged.
+	ControlUnchanged = -1
+
+	// the sender of the command takes control, i.e. for missions.
+	ControlSelf = -2
+
+	// the sender of the command releases control, if it is in control.
+	ControlRelease = -3
+)
+
+// Manager is a gimbal manager that has been discovered.
+type Manager struct {
+	// the component id of the manager.
+	ComponentId byte
+
+	// the information advertised by the manager.
+	Information *common.MessageGimbalManagerInformation
+}
+
+type managerKey struct {
+	componentId byte
+	deviceId    uint8
+}
+
+// Conf allows to configure a Client.
+type Conf struct {
+	// the node with which the gimbal is controlled.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the gimbal manager, that is usually
+	// implemented by the autopilot.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the id of the gimbal device to control. If zero, all the
+	// gimbals of the manager are controlled.
+	GimbalDeviceId uint8
+
+	// (optional) the maximum rate of setpoints, in Hz.
+	// It defaults to 20Hz.
+	MaxRate float64
+
+	// (optional) the time spent waiting for GIMBAL_MANAGER_INFORMATION
+	// messages during a discovery.
+	// It defaults to 1 second.
+	DiscoveryTimeout time.Duration
+
+	// (optional) the retry policy of commands.
+	Retry command.RetryPolicy
+}
+
+// Client discovers and controls the gimbals of a vehicle.
+type Client struct {
+	conf          Conf
+	sender        *command.Sender
+	removeHandler func()
+
+	mutex    sync.Mutex
+	managers map[managerKey]*common.MessageGimbalManagerInformation
+	status   *common.MessageGimbalManagerStatus
+	setpoint msg.Message
+
+	wake      chan struct{}
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Client. See Conf for the options.
+func New(conf Conf) (*Client, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{},
+		&common.MessageGimbalManagerInformation{},
+		&common.MessageGimbalManagerStatus{},
+		&common.MessageGimbalManagerSetAttitude{},
+		&common.MessageGimbalManagerSetPitchyaw{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.MaxRate < 0 {
+		return nil, fmt.Errorf("MaxRate must be >= 0")
+	}
+	if conf.MaxRate == 0 {
+		conf.MaxRate = 20
+	}
+	if conf.DiscoveryTimeout == 0 {
+		conf.DiscoveryTimeout = 1 * time.Second
+	}
+
+	sender, err := command.New(command.Conf{
+		Node:        conf.Node,
+		SystemId:    conf.SystemId,
+		ComponentId: conf.ComponentId,
+		Retry:       conf.Retry,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conf:      conf,
+		sender:    sender,
+		managers:  make(map[managerKey]*common.MessageGimbalManagerInformation),
+		wake:      make(chan struct{}, 1),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	go c.run()
+
+	return c, nil
+}
+
+// Close stops the client. Pending commands and discoveries return an error.
+// It must be called before closing the node.
+func (c *Client) Close() {
+	c.removeHandler()
+	close(c.terminate)
+	<-c.done
+	c.sender.Close()
+}
+
+func (c *Client) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != c.conf.SystemId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageGimbalManagerInformation{}).GetId():
+		var info common.MessageGimbalManagerInformation
+		if msg.Convert(&info, evt.Message()) != nil {
+			return
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.managers[managerKey{evt.ComponentId(), info.GimbalDeviceId}] = &info
+
+	case (&common.MessageGimbalManagerStatus{}).GetId():
+		if evt.ComponentId() != c.conf.ComponentId {
+			return
+		}
+
+		var status common.MessageGimbalManagerStatus
+		if msg.Convert(&status, evt.Message()) != nil {
+			return
+		}
+
+		if c.conf.GimbalDeviceId != 0 && status.GimbalDeviceId != c.conf.GimbalDeviceId {
+			return
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.status = &status
+	}
+}
+
+// Managers returns the gimbal managers that have been discovered, sorted by
+// component id and gimbal device id. A manager that is responsible for
+// multiple gimbals is returned once for each gimbal.
+func (c *Client) Managers() []Manager {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ret := make([]Manager, 0, len(c.managers))
+	for key, info := range c.managers {
+		ret = append(ret, Manager{
+			ComponentId: key.componentId,
+			Information: info,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ComponentId != ret[j].ComponentId {
+			return ret[i].ComponentId < ret[j].ComponentId
+		}
+		return ret[i].Information.GimbalDeviceId < ret[j].Information.GimbalDeviceId
+	})
+
+	return ret
+}
+
+// Discover requests GIMBAL_MANAGER_INFORMATION to all the components of the
+// vehicle, waits for Conf.DiscoveryTimeout and returns the gimbal managers
+// that have been discovered, like Managers.
+func (c *Client) Discover(ctx context.Context) ([]Manager, error) {
+	c.conf.Node.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: 0, // MAV_COMP_ID_ALL
+		Command:         common.MAV_CMD_REQUEST_MESSAGE,
+		Param1:          float32((&common.MessageGimbalManagerInformation{}).GetId()),
+	})
+
+	timer := time.NewTimer(c.conf.DiscoveryTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return c.Managers(), nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case <-c.terminate:
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// Status returns the last GIMBAL_MANAGER_STATUS received from the gimbal
+// manager, or nil if no status has been received yet.
+func (c *Client) Status() *common.MessageGimbalManagerStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status
+}
+
+// Configure sets the components in primary and secondary control of the
+// gimbal, with MAV_CMD_DO_GIMBAL_MANAGER_CONFIGURE. Ids can be set to
+// ControlNone, ControlUnchanged, ControlSelf or ControlRelease.
+func (c *Client) Configure(ctx context.Context, primarySystemId int, primaryComponentId int,
+	secondarySystemId int, secondaryComponentId int) error {
+	_, err := c.sender.SendLong(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_DO_GIMBAL_MANAGER_CONFIGURE,
+		Param1:  float32(primarySystemId),
+		Param2:  float32(primaryComponentId),
+		Param3:  float32(secondarySystemId),
+		Param4:  float32(secondaryComponentId),
+		Param7:  float32(c.conf.GimbalDeviceId),
+	}, nil)
+	return err
+}
+
+// TakeControl sets the node in primary control of the gimbal, leaving
+// the secondary control unchanged.
+func (c *Client) TakeControl(ctx context.Context) error {
+	nconf := c.conf.Node.Conf()
+	return c.Configure(ctx, int(nconf.OutSystemId), int(nconf.OutComponentId),
+		ControlUnchanged, ControlUnchanged)
+}
+
+// ReleaseControl releases the primary control of the gimbal.
+func (c *Client) ReleaseControl(ctx context.Context) error {
+	return c.Configure(ctx, ControlRelease, ControlRelease,
+		ControlUnchanged, ControlUnchanged)
+}
+
+// PitchYaw points the gimbal with MAV_CMD_DO_GIMBAL_MANAGER_PITCHYAW.
+// Angles are in degrees and rates are in degrees per second; NaN values are
+// ignored.
+func (c *Client) PitchYaw(ctx context.Context, pitch float32, yaw float32,
+	pitchRate float32, yawRate float32, flags common.GIMBAL_MANAGER_FLAGS) error {
+	_, err := c.sender.SendLong(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_DO_GIMBAL_MANAGER_PITCHYAW,
+		Param1:  pitch,
+		Param2:  yaw,
+		Param3:  pitchRate,
+		Param4:  yawRate,
+		Param5:  float32(flags),
+		Param7:  float32(c.conf.GimbalDeviceId),
+	}, nil)
+	return err
+}
+
+// SetAttitude sets an attitude setpoint with GIMBAL_MANAGER_SET_ATTITUDE.
+// q is a quaternion (w, x, y, z) and angular velocities are in radians per
+// second; NaN velocities are ignored.
+// The setpoint is sent within the rate limit.
+func (c *Client) SetAttitude(flags common.GIMBAL_MANAGER_FLAGS, q [4]float32,
+	angularVelocityX float32, angularVelocityY float32, angularVelocityZ float32) {
+	c.setSetpoint(&common.MessageGimbalManagerSetAttitude{
+		TargetSystem:     c.conf.SystemId,
+		TargetComponent:  c.conf.ComponentId,
+		Flags:            flags,
+		GimbalDeviceId:   c.conf.GimbalDeviceId,
+		Q:                q,
+		AngularVelocityX: angularVelocityX,
+		AngularVelocityY: angularVelocityY,
+		AngularVelocityZ: angularVelocityZ,
+	})
+}
+
+// SetPitchYaw sets a pitch and yaw setpoint with GIMBAL_MANAGER_SET_PITCHYAW.
+// Angles are in radians and rates are in radians per second; NaN values
+// (see NaN) are ignored, i.e. to set only angles or only rates.
+// The setpoint is sent within the rate limit.
+func (c *Client) SetPitchYaw(flags common.GIMBAL_MANAGER_FLAGS, pitch float32, yaw float32,
+	pitchRate float32, yawRate float32) {
+	c.setSetpoint(&common.MessageGimbalManagerSetPitchyaw{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		Flags:           flags,
+		GimbalDeviceId:  c.conf.GimbalDeviceId,
+		Pitch:           pitch,
+		Yaw:             yaw,
+		PitchRate:       pitchRate,
+		YawRate:         yawRate,
+	})
+}
+
+// NaN returns a NaN float32, that is used to ignore setpoint fields.
+func NaN() float32 {
+	return float32(math.NaN())
+}
+
+func (c *Client) setSetpoint(m msg.Message) {
+	c.mutex.Lock()
+	c.setpoint = m
+	c.mutex.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	period := time.Duration(float64(time.Second) / c.conf.MaxRate)
+	var next time.Time
+
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-c.wake:
+			// a setpoint is already waiting for the rate limit
+			if timerC != nil {
+				continue
+			}
+
+		case <-timerC:
+			timerC = nil
+
+		case <-c.terminate:
+			return
+		}
+
+		now := time.Now()
+		if now.Before(next) {
+			timer = time.NewTimer(next.Sub(now))
+			timerC = timer.C
+			continue
+		}
+
+		c.mutex.Lock()
+		m := c.setpoint
+		c.setpoint = nil
+		c.mutex.Unlock()
+
+		if m == nil {
+			continue
+		}
+
+		c.conf.Node.WriteMessageAll(m)
+		next = now.Add(period)
+	}
+}
@@ -0,0 +1,195 @@
+package gimbal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// testVehicle implements a gimbal manager.
+type testVehicle struct {
+	node *gomavlib.Node
+
+	mutex     sync.Mutex
+	commands  []*common.MessageCommandLong
+	setpoints []msg.Message
+}
+
+func newTestVehicle(t *testing.T) (*gomavlib.Node, *testVehicle) {
+	e1, e2 := testnode.Pipe()
+	gcsConf := testnode.Conf(255, e1)
+	gcsConf.OutComponentId = 190
+	vehicleConf := testnode.Conf(1, e2)
+	vehicleConf.OutComponentId = 1
+
+	gcs, node := testnode.New(t, gcsConf, vehicleConf)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{node: node}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		v.onMessage(fr.Message())
+	})
+
+	return gcs, v
+}
+
+func (v *testVehicle) onMessage(m msg.Message) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	switch tm := m.(type) {
+	case *common.MessageCommandLong:
+		if tm.Command == common.MAV_CMD_REQUEST_MESSAGE {
+			v.node.WriteMessageAll(&common.MessageGimbalManagerInformation{
+				CapFlags:       common.GIMBAL_MANAGER_CAP_FLAGS_HAS_YAW_LOCK,
+				GimbalDeviceId: 154,
+				PitchMin:       -1.5,
+				PitchMax:       0.5,
+			})
+			return
+		}
+
+		v.commands = append(v.commands, tm)
+		v.node.WriteMessageAll(&common.MessageCommandAck{
+			Command:         tm.Command,
+			Result:          common.MAV_RESULT_ACCEPTED,
+			TargetSystem:    255,
+			TargetComponent: 190,
+		})
+
+	case *common.MessageGimbalManagerSetAttitude, *common.MessageGimbalManagerSetPitchyaw:
+		v.setpoints = append(v.setpoints, m)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	_, err = New(Conf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+
+	_, err = New(Conf{Node: gcs, SystemId: 1, MaxRate: -1})
+	require.EqualError(t, err, "MaxRate must be >= 0")
+}
+
+func TestDiscover(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c, err := New(Conf{
+		Node:             gcs,
+		SystemId:         1,
+		DiscoveryTimeout: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	managers, err := c.Discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Manager{{
+		ComponentId: 1,
+		Information: &common.MessageGimbalManagerInformation{
+			CapFlags:       common.GIMBAL_MANAGER_CAP_FLAGS_HAS_YAW_LOCK,
+			GimbalDeviceId: 154,
+			PitchMin:       -1.5,
+			PitchMax:       0.5,
+		},
+	}}, managers)
+	require.Equal(t, managers, c.Managers())
+}
+
+func TestCommands(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c, err := New(Conf{
+		Node:           gcs,
+		SystemId:       1,
+		GimbalDeviceId: 154,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.TakeControl(context.Background())
+	require.NoError(t, err)
+
+	err = c.PitchYaw(context.Background(), -45, 10, NaN(), NaN(),
+		common.GIMBAL_MANAGER_FLAGS_YAW_LOCK)
+	require.NoError(t, err)
+
+	err = c.ReleaseControl(context.Background())
+	require.NoError(t, err)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	require.Equal(t, 3, len(v.commands))
+
+	require.Equal(t, common.MAV_CMD_DO_GIMBAL_MANAGER_CONFIGURE, v.commands[0].Command)
+	require.Equal(t, [4]float32{255, 190, -1, -1}, [4]float32{
+		v.commands[0].Param1, v.commands[0].Param2, v.commands[0].Param3, v.commands[0].Param4,
+	})
+	require.Equal(t, float32(154), v.commands[0].Param7)
+
+	require.Equal(t, common.MAV_CMD_DO_GIMBAL_MANAGER_PITCHYAW, v.commands[1].Command)
+	require.Equal(t, float32(-45), v.commands[1].Param1)
+	require.Equal(t, float32(10), v.commands[1].Param2)
+	require.Equal(t, float32(common.GIMBAL_MANAGER_FLAGS_YAW_LOCK), v.commands[1].Param5)
+
+	require.Equal(t, [2]float32{-3, -3}, [2]float32{v.commands[2].Param1, v.commands[2].Param2})
+}
+
+func TestSetpointRateLimit(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		MaxRate:  10,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.SetPitchYaw(0, float32(i), 0, NaN(), NaN())
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	c.SetAttitude(common.GIMBAL_MANAGER_FLAGS_YAW_LOCK, [4]float32{1, 0, 0, 0}, NaN(), NaN(), NaN())
+
+	time.Sleep(300 * time.Millisecond)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	// the first setpoint is sent immediately, the others are replaced by
+	// the last one, that is sent after the period
+	require.Equal(t, 2, len(v.setpoints))
+
+	sp, ok := v.setpoints[0].(*common.MessageGimbalManagerSetPitchyaw)
+	require.Equal(t, true, ok)
+	require.Equal(t, float32(0), sp.Pitch)
+	require.Equal(t, byte(1), sp.TargetSystem)
+
+	att, ok := v.setpoints[1].(*common.MessageGimbalManagerSetAttitude)
+	require.Equal(t, true, ok)
+	require.Equal(t, [4]float32{1, 0, 0, 0}, att.Q)
+}
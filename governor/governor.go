@@ -0,0 +1,441 @@
+// Package governor implements a closed-loop controller of the output rates
+// of channels, that keeps links below saturation by using their feedback,
+// instead of static rate limits.
+//
+// The output rate of every limited message is scaled by a factor between
+// MinScale and 1, that is adjusted periodically for each channel: it is
+// decreased multiplicatively when the link reports congestion, and increased
+// additively otherwise. Congestion is detected with:
+//   - RADIO_STATUS messages received from the channel, when the free
+//     transmit buffer of the radio drops below a threshold
+//   - PING messages sent periodically through the channel, when replies are
+//     lost or when the round trip time grows above its baseline
+//   - samples reported by the application, i.e. obtained from TCP statistics
+//
+// Routers call Allowed before forwarding a frame to a channel.
+//
+// Round trip times measured with PING messages or reported by the application
+// are also reported to channels (see gomavlib.Channel.RTT), in order to adapt
+// presence and failsafe timeouts to the latency of links.
+//
+// The node to which the governor is attached must use a dialect that contains
+// the common messages.
+package governor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// Sample is a measurement of the state of a link.
+type Sample struct {
+	// the fraction of lost packets, between 0 and 1.
+	Loss float64
+
+	// the round trip time. Zero means unknown.
+	RTT time.Duration
+}
+
+// Conf allows to configure a Governor.
+type Conf struct {
+	// the node whose output rates are governed.
+	Node *gomavlib.Node
+
+	// the maximum rate of messages, in Hz, by message id. The rate is applied
+	// to each source (system id and component id) separately.
+	// Messages that are not listed are never limited.
+	Rates map[uint32]float64
+
+	// (optional) the endpoints whose channels are governed.
+	// It defaults to all endpoints.
+	Endpoints []gomavlib.Endpoint
+
+	// (optional) the period between adjustments of the scale factor.
+	// It defaults to 1 second.
+	Period time.Duration
+
+	// (optional) the minimum scale factor.
+	// It defaults to 0.05.
+	MinScale float64
+
+	// (optional) the factor by which the scale factor is multiplied when a
+	// link is congested.
+	// It defaults to 0.5.
+	Decrease float64
+
+	// (optional) the amount by which the scale factor is increased when a
+	// link is not congested.
+	// It defaults to 0.05.
+	Increase float64
+
+	// (optional) the free transmit buffer of a radio, in percent, below which
+	// the link is considered congested.
+	// It defaults to 50.
+	MinTxBuf int
+
+	// (optional) the period between PING messages. If zero, PING messages
+	// are not sent.
+	PingPeriod time.Duration
+
+	// (optional) the fraction of lost packets above which the link is
+	// considered congested.
+	// It defaults to 0.1.
+	MaxLoss float64
+
+	// (optional) the ratio between the round trip time and the minimum round
+	// trip time above which the link is considered congested.
+	// It defaults to 2.
+	MaxRTTRatio float64
+}
+
+type rateKey struct {
+	systemId    byte
+	componentId byte
+	messageId   uint32
+}
+
+type channelState struct {
+	scale    float64
+	lastSent map[rateKey]time.Time
+
+	// feedback collected in the current period
+	congested bool
+
+	// pings
+	pingSeq       uint32
+	pingsSent     map[uint32]time.Time
+	pingsLost     int
+	pingsAnswered int
+	minRTT        time.Duration
+}
+
+// Governor adjusts the output rates of the channels of a node.
+type Governor struct {
+	conf          Conf
+	removeHandler func()
+
+	mutex    sync.Mutex
+	channels map[*gomavlib.Channel]*channelState
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Governor. See Conf for the options.
+func New(conf Conf) (*Governor, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if len(conf.Rates) == 0 {
+		return nil, fmt.Errorf("Rates not provided")
+	}
+
+	for id, r := range conf.Rates {
+		if r <= 0 {
+			return nil, fmt.Errorf("rate of message %d must be > 0", id)
+		}
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageRadioStatus{},
+		&common.MessagePing{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Period == 0 {
+		conf.Period = 1 * time.Second
+	}
+	if conf.MinScale == 0 {
+		conf.MinScale = 0.05
+	}
+	if conf.MinScale < 0 || conf.MinScale > 1 {
+		return nil, fmt.Errorf("MinScale must be between 0 and 1")
+	}
+	if conf.Decrease == 0 {
+		conf.Decrease = 0.5
+	}
+	if conf.Decrease < 0 || conf.Decrease >= 1 {
+		return nil, fmt.Errorf("Decrease must be between 0 and 1")
+	}
+	if conf.Increase == 0 {
+		conf.Increase = 0.05
+	}
+	if conf.MinTxBuf == 0 {
+		conf.MinTxBuf = 50
+	}
+	if conf.MaxLoss == 0 {
+		conf.MaxLoss = 0.1
+	}
+	if conf.MaxRTTRatio == 0 {
+		conf.MaxRTTRatio = 2
+	}
+
+	g := &Governor{
+		conf:      conf,
+		channels:  make(map[*gomavlib.Channel]*channelState),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	g.removeHandler = conf.Node.AddFrameHandler(g.onEventFrame)
+
+	go g.run()
+
+	return g, nil
+}
+
+// Close stops the governor. It must be called before closing the node.
+func (g *Governor) Close() {
+	g.removeHandler()
+	close(g.terminate)
+	<-g.done
+}
+
+func (g *Governor) isGoverned(ch *gomavlib.Channel) bool {
+	if g.conf.Endpoints == nil {
+		return true
+	}
+	for _, e := range g.conf.Endpoints {
+		if ch.Endpoint == e {
+			return true
+		}
+	}
+	return false
+}
+
+// state returns the state of a channel. It must be called with the mutex locked.
+func (g *Governor) state(ch *gomavlib.Channel) *channelState {
+	st, ok := g.channels[ch]
+	if !ok {
+		st = &channelState{
+			scale:     1,
+			lastSent:  make(map[rateKey]time.Time),
+			pingsSent: make(map[uint32]time.Time),
+		}
+		g.channels[ch] = st
+	}
+	return st
+}
+
+// Scale returns the current scale factor of the output rates of a channel.
+func (g *Governor) Scale(ch *gomavlib.Channel) float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if st, ok := g.channels[ch]; ok {
+		return st.scale
+	}
+	return 1
+}
+
+// Allowed checks whether a frame can be written to a channel without
+// exceeding the scaled rate of its message. Frames that are allowed are
+// accounted, therefore the function must be called once per written frame.
+func (g *Governor) Allowed(ch *gomavlib.Channel, f frame.Frame) bool {
+	id := f.GetMessage().GetId()
+	rate, ok := g.conf.Rates[id]
+	if !ok || !g.isGoverned(ch) {
+		return true
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	st := g.state(ch)
+	key := rateKey{f.GetSystemId(), f.GetComponentId(), id}
+	now := time.Now()
+
+	interval := time.Duration(float64(time.Second) / (rate * st.scale))
+	if last, ok := st.lastSent[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+
+	st.lastSent[key] = now
+	return true
+}
+
+// Report adds a sample of the state of the link of a channel, that is used
+// in the next adjustment of the scale factor.
+func (g *Governor) Report(ch *gomavlib.Channel, s Sample) {
+	if !g.isGoverned(ch) {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	st := g.state(ch)
+	if s.Loss > g.conf.MaxLoss {
+		st.congested = true
+	}
+	if s.RTT > 0 {
+		g.addRTT(st, s.RTT)
+		ch.ReportRTT(s.RTT)
+	}
+}
+
+// addRTT must be called with the mutex locked.
+func (g *Governor) addRTT(st *channelState, rtt time.Duration) {
+	if st.minRTT == 0 || rtt < st.minRTT {
+		st.minRTT = rtt
+	}
+	if float64(rtt) > float64(st.minRTT)*g.conf.MaxRTTRatio {
+		st.congested = true
+	}
+}
+
+func (g *Governor) onEventFrame(evt *gomavlib.EventFrame) {
+	if !g.isGoverned(evt.Channel) {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageRadioStatus{}).GetId():
+		var rs common.MessageRadioStatus
+		if msg.Convert(&rs, evt.Message()) != nil {
+			return
+		}
+
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		if int(rs.Txbuf) < g.conf.MinTxBuf {
+			g.state(evt.Channel).congested = true
+		}
+
+	case (&common.MessagePing{}).GetId():
+		var ping common.MessagePing
+		if msg.Convert(&ping, evt.Message()) != nil {
+			return
+		}
+
+		nconf := g.conf.Node.Conf()
+		if ping.TargetSystem != nconf.OutSystemId || ping.TargetComponent != nconf.OutComponentId {
+			return
+		}
+
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		st := g.state(evt.Channel)
+		sent, ok := st.pingsSent[ping.Seq]
+		if !ok {
+			return
+		}
+		delete(st.pingsSent, ping.Seq)
+
+		// a ping can be answered by multiple systems
+		st.pingsAnswered++
+		rtt := evt.ReceiveTime.Sub(sent)
+		g.addRTT(st, rtt)
+		evt.Channel.ReportRTT(rtt)
+	}
+}
+
+func (g *Governor) run() {
+	defer close(g.done)
+
+	ticker := time.NewTicker(g.conf.Period)
+	defer ticker.Stop()
+
+	var pingC <-chan time.Time
+	if g.conf.PingPeriod > 0 {
+		pingTicker := time.NewTicker(g.conf.PingPeriod)
+		defer pingTicker.Stop()
+		pingC = pingTicker.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			g.adjust()
+
+		case <-pingC:
+			g.ping()
+
+		case <-g.terminate:
+			return
+		}
+	}
+}
+
+func (g *Governor) ping() {
+	now := time.Now()
+
+	for _, ch := range g.conf.Node.Channels() {
+		if !g.isGoverned(ch) {
+			continue
+		}
+
+		g.mutex.Lock()
+		st := g.state(ch)
+
+		// pings that have not been answered within the period are lost
+		for seq, sent := range st.pingsSent {
+			if now.Sub(sent) >= g.conf.Period {
+				delete(st.pingsSent, seq)
+				st.pingsLost++
+			}
+		}
+
+		st.pingSeq++
+		seq := st.pingSeq
+		st.pingsSent[seq] = now
+		g.mutex.Unlock()
+
+		g.conf.Node.WriteMessageTo(ch, &common.MessagePing{
+			TimeUsec: uint64(now.UnixNano() / 1000),
+			Seq:      seq,
+		})
+	}
+}
+
+func (g *Governor) adjust() {
+	open := make(map[*gomavlib.Channel]struct{})
+	for _, ch := range g.conf.Node.Channels() {
+		open[ch] = struct{}{}
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for ch, st := range g.channels {
+		if _, ok := open[ch]; !ok {
+			delete(g.channels, ch)
+			continue
+		}
+
+		if total := st.pingsLost + st.pingsAnswered; total > 0 &&
+			float64(st.pingsLost)/float64(total) > g.conf.MaxLoss {
+			st.congested = true
+		}
+
+		if st.congested {
+			st.scale *= g.conf.Decrease
+			if st.scale < g.conf.MinScale {
+				st.scale = g.conf.MinScale
+			}
+		} else {
+			st.scale += g.conf.Increase
+			if st.scale > 1 {
+				st.scale = 1
+			}
+		}
+
+		st.congested = false
+		st.pingsLost = 0
+		st.pingsAnswered = 0
+	}
+}
@@ -0,0 +1,206 @@
+package governor
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+// testRemote is the remote side of a link, that answers pings when enabled.
+type testRemote struct {
+	node *gomavlib.Node
+
+	mutex       sync.Mutex
+	answerPings bool
+}
+
+func newTestRemote(t *testing.T) (*gomavlib.Node, *testRemote) {
+	e1, e2 := testnode.Pipe()
+	gateway, node := testnode.New(t, testnode.Conf(10, e1), testnode.Conf(1, e2))
+	testnode.DiscardEvents(gateway)
+
+	r := &testRemote{node: node}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		ping, ok := fr.Message().(*common.MessagePing)
+		if !ok || ping.TargetSystem != 0 {
+			return
+		}
+
+		r.mutex.Lock()
+		answer := r.answerPings
+		r.mutex.Unlock()
+
+		if answer {
+			node.WriteMessageAll(&common.MessagePing{
+				TimeUsec:        ping.TimeUsec,
+				Seq:             ping.Seq,
+				TargetSystem:    fr.SystemId(),
+				TargetComponent: fr.ComponentId(),
+			})
+		}
+	})
+
+	return gateway, r
+}
+
+func (r *testRemote) setAnswerPings(v bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.answerPings = v
+}
+
+var testRates = map[uint32]float64{
+	(&common.MessageAttitude{}).GetId(): 10,
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{Rates: testRates})
+	require.EqualError(t, err, "Node not provided")
+
+	gateway, r := newTestRemote(t)
+	defer gateway.Close()
+	defer r.node.Close()
+
+	_, err = New(Conf{Node: gateway})
+	require.EqualError(t, err, "Rates not provided")
+
+	_, err = New(Conf{Node: gateway, Rates: map[uint32]float64{30: 0}})
+	require.EqualError(t, err, "rate of message 30 must be > 0")
+
+	_, err = New(Conf{Node: gateway, Rates: testRates, Decrease: 2})
+	require.EqualError(t, err, "Decrease must be between 0 and 1")
+}
+
+func TestAllowed(t *testing.T) {
+	gateway, r := newTestRemote(t)
+	defer gateway.Close()
+	defer r.node.Close()
+
+	g, err := New(Conf{
+		Node:  gateway,
+		Rates: testRates,
+	})
+	require.NoError(t, err)
+	defer g.Close()
+
+	ch := gateway.Channels()[0]
+
+	att := &frame.V2Frame{SystemId: 1, ComponentId: 1, Message: &common.MessageAttitude{}}
+	require.True(t, g.Allowed(ch, att))
+	require.False(t, g.Allowed(ch, att))
+
+	// rates are applied to each source separately
+	require.True(t, g.Allowed(ch, &frame.V2Frame{SystemId: 2, ComponentId: 1, Message: &common.MessageAttitude{}}))
+
+	// messages that are not listed are never limited
+	hb := &frame.V2Frame{SystemId: 1, ComponentId: 1, Message: &common.MessageHeartbeat{}}
+	require.True(t, g.Allowed(ch, hb))
+	require.True(t, g.Allowed(ch, hb))
+
+	time.Sleep(110 * time.Millisecond)
+	require.True(t, g.Allowed(ch, att))
+}
+
+func TestRadioStatus(t *testing.T) {
+	gateway, r := newTestRemote(t)
+	defer gateway.Close()
+	defer r.node.Close()
+
+	// adjustments are triggered manually
+	g, err := New(Conf{
+		Node:     gateway,
+		Rates:    testRates,
+		Period:   1 * time.Hour,
+		Increase: 0.5,
+	})
+	require.NoError(t, err)
+	defer g.Close()
+
+	ch := gateway.Channels()[0]
+
+	for i := 0; i < 3; i++ {
+		r.node.WriteMessageAll(&common.MessageRadioStatus{Txbuf: 20})
+		time.Sleep(50 * time.Millisecond)
+		g.adjust()
+	}
+	require.Equal(t, 0.125, g.Scale(ch))
+
+	// the scale factor recovers when the radio is not congested
+	for i := 0; i < 2; i++ {
+		r.node.WriteMessageAll(&common.MessageRadioStatus{Txbuf: 90})
+		time.Sleep(50 * time.Millisecond)
+		g.adjust()
+	}
+	require.Equal(t, float64(1), g.Scale(ch))
+}
+
+func TestPing(t *testing.T) {
+	gateway, r := newTestRemote(t)
+	defer gateway.Close()
+	defer r.node.Close()
+
+	g, err := New(Conf{
+		Node:        gateway,
+		Rates:       testRates,
+		Period:      100 * time.Millisecond,
+		PingPeriod:  20 * time.Millisecond,
+		MaxRTTRatio: 1000,
+	})
+	require.NoError(t, err)
+	defer g.Close()
+
+	ch := gateway.Channels()[0]
+
+	r.setAnswerPings(true)
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, float64(1), g.Scale(ch))
+
+	// lost pings are a sign of congestion
+	r.setAnswerPings(false)
+	time.Sleep(400 * time.Millisecond)
+	require.True(t, g.Scale(ch) < 0.5)
+}
+
+func TestReport(t *testing.T) {
+	gateway, r := newTestRemote(t)
+	defer gateway.Close()
+	defer r.node.Close()
+
+	g, err := New(Conf{
+		Node:   gateway,
+		Rates:  testRates,
+		Period: 1 * time.Hour,
+	})
+	require.NoError(t, err)
+	defer g.Close()
+
+	ch := gateway.Channels()[0]
+
+	g.Report(ch, Sample{RTT: 10 * time.Millisecond})
+	g.adjust()
+	require.Equal(t, float64(1), g.Scale(ch))
+
+	g.Report(ch, Sample{RTT: 50 * time.Millisecond})
+	g.adjust()
+	require.Equal(t, 0.5, g.Scale(ch))
+
+	g.Report(ch, Sample{Loss: 0.3})
+	g.adjust()
+	require.Equal(t, 0.25, g.Scale(ch))
+
+	// a lower scale factor lowers the rate, from 10Hz to 2.5Hz
+	att := &frame.V2Frame{SystemId: 1, ComponentId: 1, Message: &common.MessageAttitude{}}
+	require.True(t, g.Allowed(ch, att))
+	time.Sleep(150 * time.Millisecond)
+	require.False(t, g.Allowed(ch, att))
+	time.Sleep(300 * time.Millisecond)
+	require.True(t, g.Allowed(ch, att))
+}
@@ -0,0 +1,360 @@
+package highlatency
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	commandQueueSize = 16
+)
+
+// failure flags of HIGH_LATENCY2 that correspond to unhealthy sensors of
+// SYS_STATUS.
+var failureFlags = []struct {
+	sensor common.MAV_SYS_STATUS_SENSOR
+	flag   common.HL_FAILURE_FLAG
+}{
+	{common.MAV_SYS_STATUS_SENSOR_GPS, common.HL_FAILURE_FLAG_GPS},
+	{common.MAV_SYS_STATUS_SENSOR_DIFFERENTIAL_PRESSURE, common.HL_FAILURE_FLAG_DIFFERENTIAL_PRESSURE},
+	{common.MAV_SYS_STATUS_SENSOR_ABSOLUTE_PRESSURE, common.HL_FAILURE_FLAG_ABSOLUTE_PRESSURE},
+	{common.MAV_SYS_STATUS_SENSOR_3D_ACCEL, common.HL_FAILURE_FLAG_3D_ACCEL},
+	{common.MAV_SYS_STATUS_SENSOR_3D_GYRO, common.HL_FAILURE_FLAG_3D_GYRO},
+	{common.MAV_SYS_STATUS_SENSOR_3D_MAG, common.HL_FAILURE_FLAG_3D_MAG},
+	{common.MAV_SYS_STATUS_TERRAIN, common.HL_FAILURE_FLAG_TERRAIN},
+	{common.MAV_SYS_STATUS_SENSOR_BATTERY, common.HL_FAILURE_FLAG_BATTERY},
+	{common.MAV_SYS_STATUS_SENSOR_RC_RECEIVER, common.HL_FAILURE_FLAG_RC_RECEIVER},
+	{common.MAV_SYS_STATUS_GEOFENCE, common.HL_FAILURE_FLAG_GEOFENCE},
+	{common.MAV_SYS_STATUS_AHRS, common.HL_FAILURE_FLAG_ESTIMATOR},
+}
+
+// EmitterConf allows to configure an Emitter.
+type EmitterConf struct {
+	// the node used to receive the state of the vehicle and to write
+	// HIGH_LATENCY2 messages. It should use the system id of the vehicle.
+	Node *gomavlib.Node
+
+	// (optional) the system id of the vehicle, whose telemetry is aggregated.
+	// If zero, the state is provided with Update only, i.e. when the
+	// emitter runs on the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the autopilot of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the endpoint of the high-latency link. HIGH_LATENCY2
+	// messages are written to its channels only. If nil, they are written to
+	// all channels.
+	Endpoint gomavlib.Endpoint
+
+	// (optional) the period between HIGH_LATENCY2 messages.
+	// It defaults to 5 seconds.
+	Interval time.Duration
+
+	// (optional) whether the high-latency mode is enabled at startup.
+	// Afterwards, it can be changed with SetEnabled or by ground stations
+	// with MAV_CMD_CONTROL_HIGH_LATENCY.
+	Enabled bool
+
+	// (optional) a function that is called before writing each message,
+	// that allows to fill fields that are not aggregated, i.e. the air
+	// temperature, the setpoints or the custom payload.
+	// It is called by a dedicated routine.
+	Fill func(s *State)
+
+	// (optional) a function that is called when the mode is changed by a
+	// ground station.
+	// It is called by a dedicated routine.
+	OnChange func(enabled bool)
+}
+
+type emitterCommand struct {
+	evt *gomavlib.EventFrame
+	cmd *common.MessageCommandLong
+}
+
+// Emitter aggregates the state of a vehicle into HIGH_LATENCY2 messages,
+// that are written periodically when the high-latency mode is enabled.
+type Emitter struct {
+	conf          EmitterConf
+	removeHandler func()
+
+	mutex   sync.Mutex
+	state   State
+	enabled bool
+
+	commands  chan emitterCommand
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// NewEmitter allocates an Emitter. See EmitterConf for the options.
+func NewEmitter(conf EmitterConf) (*Emitter, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHighLatency2{},
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	if conf.Interval < 0 {
+		return nil, fmt.Errorf("Interval must be >= 0")
+	}
+	if conf.Interval == 0 {
+		conf.Interval = 5 * time.Second
+	}
+
+	e := &Emitter{
+		conf:      conf,
+		state:     State{Battery: -1},
+		enabled:   conf.Enabled,
+		commands:  make(chan emitterCommand, commandQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	e.removeHandler = conf.Node.AddFrameHandler(e.onEventFrame)
+
+	go e.run()
+
+	return e, nil
+}
+
+// Close stops the emitter. It must be called before closing the node.
+func (e *Emitter) Close() {
+	e.removeHandler()
+	close(e.terminate)
+	<-e.done
+}
+
+// Enabled returns whether the high-latency mode is enabled.
+func (e *Emitter) Enabled() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.enabled
+}
+
+// SetEnabled enables or disables the high-latency mode.
+func (e *Emitter) SetEnabled(enabled bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.enabled = enabled
+}
+
+// State returns the aggregated state.
+func (e *Emitter) State() State {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.state
+}
+
+func (e *Emitter) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.Message().GetId() == (&common.MessageCommandLong{}).GetId() {
+		var cmd common.MessageCommandLong
+		if msg.Convert(&cmd, evt.Message()) != nil ||
+			cmd.Command != common.MAV_CMD_CONTROL_HIGH_LATENCY {
+			return
+		}
+
+		nconf := e.conf.Node.Conf()
+		if cmd.TargetSystem != nconf.OutSystemId ||
+			(cmd.TargetComponent != 0 && cmd.TargetComponent != nconf.OutComponentId) {
+			return
+		}
+
+		// frame handlers must not block; commands are retransmitted by
+		// ground stations when they are dropped
+		select {
+		case e.commands <- emitterCommand{evt, &cmd}:
+		default:
+		}
+		return
+	}
+
+	if e.conf.SystemId == 0 ||
+		evt.SystemId() != e.conf.SystemId || evt.ComponentId() != e.conf.ComponentId {
+		return
+	}
+
+	e.Update(evt.Message())
+}
+
+// Update updates the aggregated state with a message of the vehicle.
+// Supported messages are HEARTBEAT, GLOBAL_POSITION_INT, VFR_HUD, GPS_RAW_INT,
+// SYS_STATUS, MISSION_CURRENT, NAV_CONTROLLER_OUTPUT and WIND_COV; other
+// messages are ignored. It is called automatically with the messages
+// received from the vehicle, when EmitterConf.SystemId is provided.
+func (e *Emitter) Update(m msg.Message) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	s := &e.state
+
+	switch m.GetId() {
+	case (&common.MessageHeartbeat{}).GetId():
+		var hb common.MessageHeartbeat
+		if msg.Convert(&hb, m) == nil {
+			s.Type = hb.Type
+			s.Autopilot = hb.Autopilot
+			s.CustomMode = hb.CustomMode
+		}
+
+	case (&common.MessageGlobalPositionInt{}).GetId():
+		var gp common.MessageGlobalPositionInt
+		if msg.Convert(&gp, m) == nil {
+			s.Timestamp = time.Duration(gp.TimeBootMs) * time.Millisecond
+			s.Latitude = float64(gp.Lat) / 1e7
+			s.Longitude = float64(gp.Lon) / 1e7
+			s.Altitude = float64(gp.Alt) / 1000
+			if gp.Hdg != math.MaxUint16 {
+				s.Heading = float64(gp.Hdg) / 100
+			}
+		}
+
+	case (&common.MessageVfrHud{}).GetId():
+		var vh common.MessageVfrHud
+		if msg.Convert(&vh, m) == nil {
+			s.Airspeed = float64(vh.Airspeed)
+			s.Groundspeed = float64(vh.Groundspeed)
+			s.Throttle = float64(vh.Throttle)
+			if math.Abs(float64(vh.Climb)) > math.Abs(s.ClimbRate) {
+				s.ClimbRate = float64(vh.Climb)
+			}
+		}
+
+	case (&common.MessageGpsRawInt{}).GetId():
+		var gps common.MessageGpsRawInt
+		if msg.Convert(&gps, m) == nil {
+			// accuracies are in millimeters, and zero when unknown
+			s.Eph = math.Max(s.Eph, float64(gps.HAcc)/1000)
+			s.Epv = math.Max(s.Epv, float64(gps.VAcc)/1000)
+		}
+
+	case (&common.MessageSysStatus{}).GetId():
+		var ss common.MessageSysStatus
+		if msg.Convert(&ss, m) == nil {
+			s.Battery = int(ss.BatteryRemaining)
+
+			s.FailureFlags = 0
+			for _, f := range failureFlags {
+				if (ss.OnboardControlSensorsEnabled&f.sensor) != 0 &&
+					(ss.OnboardControlSensorsHealth&f.sensor) == 0 {
+					s.FailureFlags |= f.flag
+				}
+			}
+		}
+
+	case (&common.MessageMissionCurrent{}).GetId():
+		var mc common.MessageMissionCurrent
+		if msg.Convert(&mc, m) == nil {
+			s.WpNum = mc.Seq
+		}
+
+	case (&common.MessageNavControllerOutput{}).GetId():
+		var nc common.MessageNavControllerOutput
+		if msg.Convert(&nc, m) == nil {
+			s.TargetHeading = float64(nc.TargetBearing)
+			s.TargetDistance = float64(nc.WpDist)
+		}
+
+	case (&common.MessageWindCov{}).GetId():
+		var wc common.MessageWindCov
+		if msg.Convert(&wc, m) == nil {
+			// the wind heading is the direction from which the wind blows
+			s.Windspeed = math.Hypot(float64(wc.WindX), float64(wc.WindY))
+			s.WindHeading = heading(math.Atan2(-float64(wc.WindY), -float64(wc.WindX)) * 180 / math.Pi)
+		}
+	}
+}
+
+func (e *Emitter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.emit()
+
+		case c := <-e.commands:
+			e.handleCommand(c)
+
+		case <-e.terminate:
+			return
+		}
+	}
+}
+
+func (e *Emitter) emit() {
+	e.mutex.Lock()
+	if !e.enabled {
+		e.mutex.Unlock()
+		return
+	}
+	s := e.state
+
+	// reset the values that are maximums since the last message
+	e.state.Eph = 0
+	e.state.Epv = 0
+	e.state.ClimbRate = 0
+	e.mutex.Unlock()
+
+	if e.conf.Fill != nil {
+		e.conf.Fill(&s)
+	}
+
+	m := s.Encode()
+
+	if e.conf.Endpoint == nil {
+		e.conf.Node.WriteMessageAll(m)
+		return
+	}
+
+	for _, ch := range e.conf.Node.Channels() {
+		if ch.Endpoint == e.conf.Endpoint {
+			e.conf.Node.WriteMessageTo(ch, m)
+		}
+	}
+}
+
+func (e *Emitter) handleCommand(c emitterCommand) {
+	enabled := c.cmd.Param1 > 0.5
+
+	e.mutex.Lock()
+	changed := e.enabled != enabled
+	e.enabled = enabled
+	e.mutex.Unlock()
+
+	e.conf.Node.WriteMessageTo(c.evt.Channel, &common.MessageCommandAck{
+		Command:         c.cmd.Command,
+		Result:          common.MAV_RESULT_ACCEPTED,
+		TargetSystem:    c.evt.SystemId(),
+		TargetComponent: c.evt.ComponentId(),
+	})
+
+	if changed && e.conf.OnChange != nil {
+		e.conf.OnChange(enabled)
+	}
+}
@@ -0,0 +1,172 @@
+package highlatency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestEmitterUpdate(t *testing.T) {
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5760"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	e, err := NewEmitter(EmitterConf{Node: node})
+	require.NoError(t, err)
+	defer e.Close()
+
+	e.Update(&common.MessageHeartbeat{
+		Type:       common.MAV_TYPE_QUADROTOR,
+		Autopilot:  common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		CustomMode: 5,
+	})
+	e.Update(&common.MessageGlobalPositionInt{
+		TimeBootMs: 1000,
+		Lat:        450000000,
+		Lon:        70000000,
+		Alt:        150000,
+		Hdg:        9000,
+	})
+	e.Update(&common.MessageVfrHud{Groundspeed: 5, Throttle: 40, Climb: -2})
+	e.Update(&common.MessageVfrHud{Groundspeed: 6, Throttle: 45, Climb: 1})
+	e.Update(&common.MessageGpsRawInt{HAcc: 800, VAcc: 1500})
+	e.Update(&common.MessageGpsRawInt{HAcc: 600, VAcc: 1200})
+	e.Update(&common.MessageSysStatus{
+		OnboardControlSensorsEnabled: common.MAV_SYS_STATUS_SENSOR_GPS | common.MAV_SYS_STATUS_SENSOR_3D_MAG,
+		OnboardControlSensorsHealth:  common.MAV_SYS_STATUS_SENSOR_3D_MAG,
+		BatteryRemaining:             75,
+	})
+	e.Update(&common.MessageMissionCurrent{Seq: 4})
+	e.Update(&common.MessageNavControllerOutput{TargetBearing: 100, WpDist: 250})
+	e.Update(&common.MessageWindCov{WindX: 0, WindY: -3})
+
+	s := e.State()
+	require.InDelta(t, 90, s.WindHeading, 1e-6)
+	s.WindHeading = 90
+
+	require.Equal(t, State{
+		Timestamp:      1 * time.Second,
+		Type:           common.MAV_TYPE_QUADROTOR,
+		Autopilot:      common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		CustomMode:     5,
+		Latitude:       45,
+		Longitude:      7,
+		Altitude:       150,
+		Heading:        90,
+		TargetHeading:  100,
+		TargetDistance: 250,
+		Throttle:       45,
+		Groundspeed:    6,
+		Windspeed:      3,
+		WindHeading:    90,
+		Eph:            0.8,
+		Epv:            1.5,
+		ClimbRate:      -2,
+		Battery:        75,
+		WpNum:          4,
+		FailureFlags:   common.HL_FAILURE_FLAG_GPS,
+	}, s)
+}
+
+func TestEmitterControl(t *testing.T) {
+	companion, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:        common.Dialect,
+		OutVersion:     gomavlib.V2,
+		OutSystemId:    1,
+		OutComponentId: 191,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5760"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer companion.Close()
+
+	go func() {
+		for range companion.Events() {
+		}
+	}()
+
+	changes := make(chan bool, 10)
+
+	e, err := NewEmitter(EmitterConf{
+		Node:     companion,
+		Interval: 50 * time.Millisecond,
+		Fill: func(s *State) {
+			s.TemperatureAir = 15
+		},
+		OnChange: func(enabled bool) {
+			changes <- enabled
+		},
+	})
+	require.NoError(t, err)
+	defer e.Close()
+
+	e.Update(&common.MessageMissionCurrent{Seq: 2})
+
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5760"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	messages := make(chan *common.MessageHighLatency2, 100)
+	go func() {
+		for evt := range gcs.Events() {
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if m, ok := fr.Message().(*common.MessageHighLatency2); ok {
+					messages <- m
+				}
+			}
+		}
+	}()
+
+	sender, err := command.New(command.Conf{
+		Node:        gcs,
+		SystemId:    1,
+		ComponentId: 191,
+	})
+	require.NoError(t, err)
+	defer sender.Close()
+
+	require.Equal(t, false, e.Enabled())
+
+	err = Control(context.Background(), sender, true)
+	require.NoError(t, err)
+	require.Equal(t, true, <-changes)
+	require.Equal(t, true, e.Enabled())
+
+	select {
+	case m := <-messages:
+		require.Equal(t, uint16(2), m.WpNum)
+		require.Equal(t, int8(15), m.TemperatureAir)
+		require.Equal(t, int8(-1), m.Battery)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	err = Control(context.Background(), sender, false)
+	require.NoError(t, err)
+	require.Equal(t, false, <-changes)
+	require.Equal(t, false, e.Enabled())
+}
@@ -0,0 +1,196 @@
+// Package highlatency implements the high-latency protocol, that allows to
+// monitor vehicles through links with low bandwidth and high latency, i.e.
+// satellite links, where telemetry streams are replaced by a single
+// HIGH_LATENCY2 message, sent every few seconds.
+//
+// The package provides an emitter, that aggregates the state of a vehicle
+// into HIGH_LATENCY2 messages, conversion of HIGH_LATENCY2 messages from and
+// into a State expressed in SI units, and Control, that enables or disables
+// the high-latency mode of a remote system with MAV_CMD_CONTROL_HIGH_LATENCY.
+//
+// The node used by the package must use a dialect that contains the common
+// messages.
+package highlatency
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// State is the state of a vehicle, that is carried by HIGH_LATENCY2.
+type State struct {
+	// the time since boot.
+	Timestamp time.Duration
+
+	// the type of the vehicle.
+	Type common.MAV_TYPE
+
+	// the autopilot type.
+	Autopilot common.MAV_AUTOPILOT
+
+	// the autopilot-specific mode. Only the lower 16 bits are transmitted.
+	CustomMode uint32
+
+	// the latitude, in degrees.
+	Latitude float64
+
+	// the longitude, in degrees.
+	Longitude float64
+
+	// the altitude above mean sea level, in meters.
+	Altitude float64
+
+	// the altitude setpoint, in meters.
+	TargetAltitude float64
+
+	// the heading, in degrees.
+	Heading float64
+
+	// the heading setpoint, in degrees.
+	TargetHeading float64
+
+	// the distance to the target waypoint or position, in meters.
+	TargetDistance float64
+
+	// the throttle, in percent.
+	Throttle float64
+
+	// the airspeed, in meters per second.
+	Airspeed float64
+
+	// the airspeed setpoint, in meters per second.
+	AirspeedSp float64
+
+	// the groundspeed, in meters per second.
+	Groundspeed float64
+
+	// the wind speed, in meters per second.
+	Windspeed float64
+
+	// the direction of the wind, in degrees.
+	WindHeading float64
+
+	// the maximum horizontal position error since the last message, in meters.
+	Eph float64
+
+	// the maximum vertical position error since the last message, in meters.
+	Epv float64
+
+	// the air temperature, in degrees Celsius.
+	TemperatureAir float64
+
+	// the maximum climb rate magnitude since the last message, in meters per
+	// second.
+	ClimbRate float64
+
+	// the battery level in percent, or -1 if unknown.
+	Battery int
+
+	// the current waypoint number.
+	WpNum uint16
+
+	// the failed subsystems.
+	FailureFlags common.HL_FAILURE_FLAG
+
+	// custom payload.
+	Custom [3]int8
+}
+
+func clamp(v float64, min float64, max float64) float64 {
+	return math.Max(min, math.Min(max, math.Round(v)))
+}
+
+// heading converts a heading in degrees into the range [0, 360).
+func heading(v float64) float64 {
+	v = math.Mod(v, 360)
+	if v < 0 {
+		v += 360
+	}
+	return v
+}
+
+// Encode converts the state into a HIGH_LATENCY2 message. Values that do
+// not fit into the fields of the message are clamped.
+func (s State) Encode() *common.MessageHighLatency2 {
+	return &common.MessageHighLatency2{
+		Timestamp:      uint32(s.Timestamp / time.Millisecond),
+		Type:           s.Type,
+		Autopilot:      s.Autopilot,
+		CustomMode:     uint16(s.CustomMode),
+		Latitude:       int32(clamp(s.Latitude*1e7, math.MinInt32, math.MaxInt32)),
+		Longitude:      int32(clamp(s.Longitude*1e7, math.MinInt32, math.MaxInt32)),
+		Altitude:       int16(clamp(s.Altitude, math.MinInt16, math.MaxInt16)),
+		TargetAltitude: int16(clamp(s.TargetAltitude, math.MinInt16, math.MaxInt16)),
+		Heading:        uint8(clamp(heading(s.Heading)/2, 0, 179)),
+		TargetHeading:  uint8(clamp(heading(s.TargetHeading)/2, 0, 179)),
+		TargetDistance: uint16(clamp(s.TargetDistance/10, 0, math.MaxUint16)),
+		Throttle:       uint8(clamp(s.Throttle, 0, 100)),
+		Airspeed:       uint8(clamp(s.Airspeed*5, 0, math.MaxUint8)),
+		AirspeedSp:     uint8(clamp(s.AirspeedSp*5, 0, math.MaxUint8)),
+		Groundspeed:    uint8(clamp(s.Groundspeed*5, 0, math.MaxUint8)),
+		Windspeed:      uint8(clamp(s.Windspeed*5, 0, math.MaxUint8)),
+		WindHeading:    uint8(clamp(heading(s.WindHeading)/2, 0, 179)),
+		Eph:            uint8(clamp(s.Eph*10, 0, math.MaxUint8)),
+		Epv:            uint8(clamp(s.Epv*10, 0, math.MaxUint8)),
+		TemperatureAir: int8(clamp(s.TemperatureAir, math.MinInt8, math.MaxInt8)),
+		ClimbRate:      int8(clamp(s.ClimbRate*10, math.MinInt8, math.MaxInt8)),
+		Battery:        int8(clamp(float64(s.Battery), -1, 100)),
+		WpNum:          s.WpNum,
+		FailureFlags:   s.FailureFlags,
+		Custom0:        s.Custom[0],
+		Custom1:        s.Custom[1],
+		Custom2:        s.Custom[2],
+	}
+}
+
+// Decode converts a HIGH_LATENCY2 message into a State.
+func Decode(m *common.MessageHighLatency2) State {
+	return State{
+		Timestamp:      time.Duration(m.Timestamp) * time.Millisecond,
+		Type:           m.Type,
+		Autopilot:      m.Autopilot,
+		CustomMode:     uint32(m.CustomMode),
+		Latitude:       float64(m.Latitude) / 1e7,
+		Longitude:      float64(m.Longitude) / 1e7,
+		Altitude:       float64(m.Altitude),
+		TargetAltitude: float64(m.TargetAltitude),
+		Heading:        float64(m.Heading) * 2,
+		TargetHeading:  float64(m.TargetHeading) * 2,
+		TargetDistance: float64(m.TargetDistance) * 10,
+		Throttle:       float64(m.Throttle),
+		Airspeed:       float64(m.Airspeed) / 5,
+		AirspeedSp:     float64(m.AirspeedSp) / 5,
+		Groundspeed:    float64(m.Groundspeed) / 5,
+		Windspeed:      float64(m.Windspeed) / 5,
+		WindHeading:    float64(m.WindHeading) * 2,
+		Eph:            float64(m.Eph) / 10,
+		Epv:            float64(m.Epv) / 10,
+		TemperatureAir: float64(m.TemperatureAir),
+		ClimbRate:      float64(m.ClimbRate) / 10,
+		Battery:        int(m.Battery),
+		WpNum:          m.WpNum,
+		FailureFlags:   m.FailureFlags,
+		Custom:         [3]int8{m.Custom0, m.Custom1, m.Custom2},
+	}
+}
+
+// Control enables or disables the high-latency mode of the target of a
+// command sender, with MAV_CMD_CONTROL_HIGH_LATENCY, and waits for the
+// acknowledgement. In high-latency mode, the target replaces telemetry
+// streams with HIGH_LATENCY2 messages.
+func Control(ctx context.Context, s *command.Sender, enable bool) error {
+	var param1 float32
+	if enable {
+		param1 = 1
+	}
+
+	_, err := s.SendLong(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_CONTROL_HIGH_LATENCY,
+		Param1:  param1,
+	}, nil)
+	return err
+}
@@ -0,0 +1,86 @@
+package highlatency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	s := State{
+		Timestamp:      12 * time.Second,
+		Type:           common.MAV_TYPE_FIXED_WING,
+		Autopilot:      common.MAV_AUTOPILOT_PX4,
+		CustomMode:     0x30004,
+		Latitude:       45.1234567,
+		Longitude:      -7.1234567,
+		Altitude:       120,
+		TargetAltitude: 150,
+		Heading:        270,
+		TargetHeading:  -90,
+		TargetDistance: 1500,
+		Throttle:       60,
+		Airspeed:       20,
+		AirspeedSp:     22,
+		Groundspeed:    18.4,
+		Windspeed:      3,
+		WindHeading:    180,
+		Eph:            1.5,
+		Epv:            2.5,
+		TemperatureAir: 21,
+		ClimbRate:      -1.2,
+		Battery:        80,
+		WpNum:          3,
+		FailureFlags:   common.HL_FAILURE_FLAG_GPS,
+		Custom:         [3]int8{1, 2, 3},
+	}
+
+	m := s.Encode()
+	require.Equal(t, &common.MessageHighLatency2{
+		Timestamp:      12000,
+		Type:           common.MAV_TYPE_FIXED_WING,
+		Autopilot:      common.MAV_AUTOPILOT_PX4,
+		CustomMode:     4,
+		Latitude:       451234567,
+		Longitude:      -71234567,
+		Altitude:       120,
+		TargetAltitude: 150,
+		Heading:        135,
+		TargetHeading:  135,
+		TargetDistance: 150,
+		Throttle:       60,
+		Airspeed:       100,
+		AirspeedSp:     110,
+		Groundspeed:    92,
+		Windspeed:      15,
+		WindHeading:    90,
+		Eph:            15,
+		Epv:            25,
+		TemperatureAir: 21,
+		ClimbRate:      -12,
+		Battery:        80,
+		WpNum:          3,
+		FailureFlags:   common.HL_FAILURE_FLAG_GPS,
+		Custom0:        1,
+		Custom1:        2,
+		Custom2:        3,
+	}, m)
+
+	d := Decode(m)
+	require.InDelta(t, 45.1234567, d.Latitude, 1e-7)
+	require.Equal(t, 270.0, d.Heading)
+	require.Equal(t, 1500.0, d.TargetDistance)
+	require.InDelta(t, 18.4, d.Groundspeed, 1e-9)
+	require.InDelta(t, -1.2, d.ClimbRate, 1e-9)
+	require.Equal(t, uint32(4), d.CustomMode)
+
+	// values are clamped
+	m = State{Altitude: 100000, Airspeed: 100, Battery: -1, Heading: 359.9}.Encode()
+	require.Equal(t, int16(32767), m.Altitude)
+	require.Equal(t, uint8(255), m.Airspeed)
+	require.Equal(t, int8(-1), m.Battery)
+	require.Equal(t, uint8(179), m.Heading)
+}
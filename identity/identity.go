@@ -0,0 +1,448 @@
+// Package identity implements a registry of the unique ids of vehicles,
+// that allows to keep stable vehicle identities when system ids change, i.e.
+// after a reconfiguration of the fleet.
+//
+// The unique id of each autopilot is read from AUTOPILOT_VERSION (uid2,
+// or uid when uid2 is not provided), that is requested with
+// MAV_CMD_REQUEST_MESSAGE when heartbeats of an autopilot start being
+// received. Events are emitted when a vehicle is identified, when a known
+// vehicle appears under a different system id and when a system id is
+// taken by a different vehicle.
+//
+// The node to which the registry is attached must use a dialect that
+// contains the common messages.
+package identity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	frameQueueSize = 64
+)
+
+// UID is the unique id of an autopilot, that is usually derived from the
+// serial number of its processor.
+type UID struct {
+	// the 64-bit unique id (AUTOPILOT_VERSION.uid).
+	Uid uint64
+
+	// the 144-bit unique id (AUTOPILOT_VERSION.uid2), that supersedes Uid
+	// when it is not zero.
+	Uid2 [18]byte
+}
+
+// IsZero returns whether the UID has not been provided.
+func (u UID) IsZero() bool {
+	return u == UID{}
+}
+
+// String implements fmt.Stringer. It returns the hexadecimal
+// representation of Uid2, or of Uid when Uid2 is zero.
+func (u UID) String() string {
+	if u.Uid2 != [18]byte{} {
+		return hex.EncodeToString(u.Uid2[:])
+	}
+	return fmt.Sprintf("%016x", u.Uid)
+}
+
+// Identity is a vehicle identified by its unique id.
+type Identity struct {
+	// the unique id.
+	UID UID
+
+	// the last system id under which the vehicle has been seen.
+	SystemId byte
+
+	// the component id of the autopilot.
+	ComponentId byte
+
+	// the system ids under which the vehicle has been seen before SystemId,
+	// from the oldest to the most recent.
+	PreviousSystemIds []byte
+
+	// the time at which the vehicle has been identified the first time.
+	FirstSeen time.Time
+
+	// the time at which the vehicle has been identified the last time.
+	LastSeen time.Time
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventNew means that a vehicle has been identified the first time.
+	EventNew EventType = iota
+
+	// EventSystemIdChanged means that a known vehicle has been identified
+	// under a different system id.
+	EventSystemIdChanged
+
+	// EventSystemIdReassigned means that a system id, that belonged to a
+	// vehicle, has been taken by a different vehicle.
+	EventSystemIdReassigned
+)
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	switch t {
+	case EventNew:
+		return "new"
+	case EventSystemIdChanged:
+		return "system id changed"
+	case EventSystemIdReassigned:
+		return "system id reassigned"
+	}
+	return "unknown"
+}
+
+// Event is a change of the registry.
+type Event struct {
+	// the type of the event.
+	Type EventType
+
+	// the vehicle, after the event.
+	Identity Identity
+
+	// the previous system id of the vehicle.
+	// It is filled only when Type is EventSystemIdChanged.
+	PreviousSystemId byte
+
+	// the vehicle that previously had the system id.
+	// It is filled only when Type is EventSystemIdReassigned.
+	PreviousUID UID
+
+	// the channel from which AUTOPILOT_VERSION has been received.
+	Channel *gomavlib.Channel
+}
+
+// Conf allows to configure a Registry.
+type Conf struct {
+	// the node from which frames are read.
+	Node *gomavlib.Node
+
+	// (optional) called when the registry changes.
+	// It is called by a dedicated routine, one event at a time.
+	OnEvent func(Event)
+
+	// (optional) the period between requests of AUTOPILOT_VERSION to
+	// autopilots that have not answered yet.
+	// It defaults to 2 seconds.
+	RequestPeriod time.Duration
+
+	// (optional) the time without heartbeats after which an autopilot is
+	// identified again when its heartbeats resume, since it may have been
+	// replaced by a different vehicle with the same system id.
+	// It defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+type systemState struct {
+	systemId      byte
+	componentId   byte
+	channel       *gomavlib.Channel
+	lastHeartbeat time.Time
+	lastRequest   time.Time
+	identified    bool
+}
+
+type frameEntry struct {
+	evt  *gomavlib.EventFrame
+	time time.Time
+}
+
+// Registry tracks the unique ids of vehicles.
+type Registry struct {
+	conf          Conf
+	removeHandler func()
+
+	// accessed by run() only
+	systems map[byte]*systemState
+
+	mutex      sync.Mutex
+	identities map[UID]*Identity
+	bySystemId map[byte]UID
+
+	frames    chan frameEntry
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Registry. See Conf for the options.
+func New(conf Conf) (*Registry, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHeartbeat{},
+		&common.MessageAutopilotVersion{},
+		&common.MessageCommandLong{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.RequestPeriod < 0 {
+		return nil, fmt.Errorf("RequestPeriod must be >= 0")
+	}
+	if conf.RequestPeriod == 0 {
+		conf.RequestPeriod = 2 * time.Second
+	}
+
+	if conf.Timeout < 0 {
+		return nil, fmt.Errorf("Timeout must be >= 0")
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 10 * time.Second
+	}
+
+	r := &Registry{
+		conf:       conf,
+		systems:    make(map[byte]*systemState),
+		identities: make(map[UID]*Identity),
+		bySystemId: make(map[byte]UID),
+		frames:     make(chan frameEntry, frameQueueSize),
+		terminate:  make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	r.removeHandler = conf.Node.AddFrameHandler(r.onEventFrame)
+
+	go r.run()
+
+	return r, nil
+}
+
+// Close stops the registry. It must be called before closing the node.
+func (r *Registry) Close() {
+	r.removeHandler()
+	close(r.terminate)
+	<-r.done
+}
+
+func copyIdentity(id *Identity) Identity {
+	c := *id
+	c.PreviousSystemIds = append([]byte(nil), id.PreviousSystemIds...)
+	return c
+}
+
+// Identities returns the known vehicles, sorted by system id.
+func (r *Registry) Identities() []Identity {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ret := make([]Identity, 0, len(r.identities))
+	for _, id := range r.identities {
+		ret = append(ret, copyIdentity(id))
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SystemId != ret[j].SystemId {
+			return ret[i].SystemId < ret[j].SystemId
+		}
+		return ret[i].UID.String() < ret[j].UID.String()
+	})
+
+	return ret
+}
+
+// Lookup returns the vehicle with the given unique id.
+func (r *Registry) Lookup(uid UID) (Identity, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id, ok := r.identities[uid]
+	if !ok {
+		return Identity{}, false
+	}
+	return copyIdentity(id), true
+}
+
+// BySystemId returns the vehicle that currently has the given system id.
+func (r *Registry) BySystemId(systemId byte) (Identity, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	uid, ok := r.bySystemId[systemId]
+	if !ok {
+		return Identity{}, false
+	}
+	return copyIdentity(r.identities[uid]), true
+}
+
+func (r *Registry) onEventFrame(evt *gomavlib.EventFrame) {
+	switch evt.Message().GetId() {
+	case (&common.MessageHeartbeat{}).GetId(), (&common.MessageAutopilotVersion{}).GetId():
+	default:
+		return
+	}
+
+	// frame handlers must not block; frames are dropped when the queue is
+	// full, and requests are sent again.
+	select {
+	case r.frames <- frameEntry{evt, time.Now()}:
+	default:
+	}
+}
+
+func (r *Registry) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.conf.RequestPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case fe := <-r.frames:
+			for _, e := range r.process(fe.evt, fe.time) {
+				if r.conf.OnEvent != nil {
+					r.conf.OnEvent(e)
+				}
+			}
+
+		case now := <-ticker.C:
+			for _, st := range r.systems {
+				if !st.identified && now.Sub(st.lastHeartbeat) < r.conf.Timeout &&
+					now.Sub(st.lastRequest) >= r.conf.RequestPeriod {
+					r.request(st, now)
+				}
+			}
+
+		case <-r.terminate:
+			return
+		}
+	}
+}
+
+func (r *Registry) request(st *systemState, now time.Time) {
+	st.lastRequest = now
+	r.conf.Node.WriteMessageTo(st.channel, &common.MessageCommandLong{
+		TargetSystem:    st.systemId,
+		TargetComponent: st.componentId,
+		Command:         common.MAV_CMD_REQUEST_MESSAGE,
+		Param1:          float32((&common.MessageAutopilotVersion{}).GetId()),
+	})
+}
+
+func (r *Registry) process(evt *gomavlib.EventFrame, now time.Time) []Event {
+	switch evt.Message().GetId() {
+	case (&common.MessageHeartbeat{}).GetId():
+		var hb common.MessageHeartbeat
+		if msg.Convert(&hb, evt.Message()) != nil || hb.Autopilot == common.MAV_AUTOPILOT_INVALID {
+			return nil
+		}
+
+		st, ok := r.systems[evt.SystemId()]
+		if !ok {
+			st = &systemState{systemId: evt.SystemId()}
+			r.systems[evt.SystemId()] = st
+		}
+
+		// the autopilot may have been replaced
+		if ok && now.Sub(st.lastHeartbeat) >= r.conf.Timeout {
+			st.identified = false
+		}
+
+		st.componentId = evt.ComponentId()
+		st.channel = evt.Channel
+		st.lastHeartbeat = now
+
+		if !st.identified && now.Sub(st.lastRequest) >= r.conf.RequestPeriod {
+			r.request(st, now)
+		}
+
+	case (&common.MessageAutopilotVersion{}).GetId():
+		var av common.MessageAutopilotVersion
+		if msg.Convert(&av, evt.Message()) != nil {
+			return nil
+		}
+
+		st, ok := r.systems[evt.SystemId()]
+		if !ok {
+			st = &systemState{
+				systemId:      evt.SystemId(),
+				componentId:   evt.ComponentId(),
+				channel:       evt.Channel,
+				lastHeartbeat: now,
+			}
+			r.systems[evt.SystemId()] = st
+		}
+		st.identified = true
+
+		// autopilots without unique ids can't be tracked
+		uid := UID{Uid: av.Uid, Uid2: av.Uid2}
+		if uid.IsZero() {
+			return nil
+		}
+
+		return r.identify(evt, uid, now)
+	}
+
+	return nil
+}
+
+func (r *Registry) identify(evt *gomavlib.EventFrame, uid UID, now time.Time) []Event {
+	sysId := evt.SystemId()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var evts []Event
+
+	id, ok := r.identities[uid]
+	switch {
+	case !ok:
+		id = &Identity{
+			UID:       uid,
+			SystemId:  sysId,
+			FirstSeen: now,
+		}
+		r.identities[uid] = id
+		evts = append(evts, Event{Type: EventNew})
+
+	case id.SystemId != sysId:
+		// the previous system id is released, unless it has already been
+		// taken by another vehicle
+		if cur, ok := r.bySystemId[id.SystemId]; ok && cur == uid {
+			delete(r.bySystemId, id.SystemId)
+		}
+
+		evts = append(evts, Event{
+			Type:             EventSystemIdChanged,
+			PreviousSystemId: id.SystemId,
+		})
+		id.PreviousSystemIds = append(id.PreviousSystemIds, id.SystemId)
+		id.SystemId = sysId
+	}
+
+	if prev, ok := r.bySystemId[sysId]; ok && prev != uid {
+		evts = append(evts, Event{
+			Type:        EventSystemIdReassigned,
+			PreviousUID: prev,
+		})
+	}
+
+	id.ComponentId = evt.ComponentId()
+	id.LastSeen = now
+	r.bySystemId[sysId] = uid
+
+	for i := range evts {
+		evts[i].Identity = copyIdentity(id)
+		evts[i].Channel = evt.Channel
+	}
+
+	return evts
+}
@@ -0,0 +1,163 @@
+package identity
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// newTestVehicle returns a vehicle that emits heartbeats of an autopilot
+// and answers requests of AUTOPILOT_VERSION with the given unique id.
+func newTestVehicle(t *testing.T, rwc io.ReadWriteCloser, systemId byte, uid UID) *gomavlib.Node {
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:                common.Dialect,
+		OutVersion:             gomavlib.V2,
+		OutSystemId:            systemId,
+		Endpoints:              []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: rwc}},
+		HeartbeatPeriod:        50 * time.Millisecond,
+		HeartbeatAutopilotType: int(common.MAV_AUTOPILOT_PX4),
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for evt := range node.Events() {
+			fr, ok := evt.(*gomavlib.EventFrame)
+			if !ok {
+				continue
+			}
+
+			cmd, ok := fr.Message().(*common.MessageCommandLong)
+			if !ok || cmd.Command != common.MAV_CMD_REQUEST_MESSAGE || cmd.Param1 != 148 ||
+				cmd.TargetSystem != systemId {
+				continue
+			}
+
+			node.WriteMessageAll(&common.MessageAutopilotVersion{
+				Uid:  uid.Uid,
+				Uid2: uid.Uid2,
+			})
+		}
+	}()
+
+	return node
+}
+
+func TestUID(t *testing.T) {
+	require.Equal(t, true, UID{}.IsZero())
+	require.Equal(t, "00000000deadbeef", UID{Uid: 0xDEADBEEF}.String())
+	require.Equal(t, "0102030000000000000000000000000000ff",
+		UID{Uid: 5, Uid2: [18]byte{1, 2, 3, 17: 0xFF}}.String())
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{})
+	require.EqualError(t, err, "Node not provided")
+}
+
+func TestRegistry(t *testing.T) {
+	var conns [3][2]net.Conn
+	for i := range conns {
+		conns[i][0], conns[i][1] = net.Pipe()
+	}
+
+	// the ground station uses a different dialect than the vehicles
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointCustom{ReadWriteCloser: conns[0][0]},
+			gomavlib.EndpointCustom{ReadWriteCloser: conns[1][0]},
+			gomavlib.EndpointCustom{ReadWriteCloser: conns[2][0]},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	events := make(chan Event, 10)
+
+	r, err := New(Conf{
+		Node:          gcs,
+		RequestPeriod: 100 * time.Millisecond,
+		Timeout:       300 * time.Millisecond,
+		OnEvent: func(e Event) {
+			events <- e
+		},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	uidA := UID{Uid2: [18]byte{0xAA, 17: 0x01}}
+	uidB := UID{Uid: 0xBB}
+
+	// vehicle A is identified
+	v := newTestVehicle(t, conns[0][1], 1, uidA)
+
+	e := <-events
+	require.Equal(t, EventNew, e.Type)
+	require.Equal(t, uidA, e.Identity.UID)
+	require.Equal(t, byte(1), e.Identity.SystemId)
+	require.Equal(t, byte(1), e.Identity.ComponentId)
+
+	id, ok := r.BySystemId(1)
+	require.Equal(t, true, ok)
+	require.Equal(t, uidA, id.UID)
+
+	// vehicle A is reconfigured with system id 2
+	v.Close()
+	v = newTestVehicle(t, conns[1][1], 2, uidA)
+
+	e = <-events
+	require.Equal(t, EventSystemIdChanged, e.Type)
+	require.Equal(t, byte(1), e.PreviousSystemId)
+	require.Equal(t, byte(2), e.Identity.SystemId)
+	require.Equal(t, []byte{1}, e.Identity.PreviousSystemIds)
+
+	_, ok = r.BySystemId(1)
+	require.Equal(t, false, ok)
+
+	// vehicle B replaces vehicle A, with the same system id
+	v.Close()
+	time.Sleep(400 * time.Millisecond)
+	v = newTestVehicle(t, conns[2][1], 2, uidB)
+	defer v.Close()
+
+	e = <-events
+	require.Equal(t, EventNew, e.Type)
+	require.Equal(t, uidB, e.Identity.UID)
+
+	e = <-events
+	require.Equal(t, EventSystemIdReassigned, e.Type)
+	require.Equal(t, uidA, e.PreviousUID)
+	require.Equal(t, byte(2), e.Identity.SystemId)
+
+	id, ok = r.BySystemId(2)
+	require.Equal(t, true, ok)
+	require.Equal(t, uidB, id.UID)
+
+	id, ok = r.Lookup(uidA)
+	require.Equal(t, true, ok)
+	require.Equal(t, byte(2), id.SystemId)
+
+	require.Equal(t, 2, len(r.Identities()))
+
+	// vehicles that keep their system id do not produce events
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event: %v", e)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,328 @@
+// Package interlock implements a guard that prevents a node from writing
+// critical commands without an explicit approval, in order to protect
+// backends shared by multiple operators from commands injected by mistake.
+//
+// By default, the following commands are critical:
+//   - MAV_CMD_COMPONENT_ARM_DISARM, when it arms, when it forces a disarm, or
+//     when it disarms a vehicle that is not known to be on the ground
+//   - MAV_CMD_DO_FLIGHTTERMINATION, when it activates the termination
+//   - MAV_CMD_DO_SET_SERVO
+//
+// A critical command, sent with COMMAND_LONG or COMMAND_INT, is written when
+// it is approved by Conf.Approve, or when it has been confirmed with Confirm.
+// Otherwise it is discarded, and a Request that contains a confirmation token
+// is passed to Conf.OnBlock. Once the token has been confirmed, the command
+// can be written again.
+//
+// The interlock is attached to a node with NodeConf.OutGuard and with a frame
+// handler, that tracks the landed state of vehicles:
+//
+//   il, _ := interlock.New(interlock.Conf{})
+//   node, _ := gomavlib.NewNode(gomavlib.NodeConf{..., OutGuard: il.Guard})
+//   node.AddFrameHandler(il.HandleFrame)
+package interlock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// magic value of the second parameter of MAV_CMD_COMPONENT_ARM_DISARM that
+// forces arming or disarming.
+const forceMagic = 21196
+
+// ErrInvalidToken is returned by Confirm when a token does not exist or has
+// expired.
+var ErrInvalidToken = fmt.Errorf("invalid or expired token")
+
+// Command is a command that is about to be written.
+type Command struct {
+	// the target system of the command.
+	TargetSystem byte
+
+	// the target component of the command.
+	TargetComponent byte
+
+	// the command.
+	Command common.MAV_CMD
+
+	// the parameters of the command. In case of COMMAND_INT, the fifth and
+	// sixth parameters are the integer X and Y fields.
+	Params [7]float32
+
+	// the last landed state reported by the target system through
+	// EXTENDED_SYS_STATE, or MAV_LANDED_STATE_UNDEFINED if unknown.
+	LandedState common.MAV_LANDED_STATE
+}
+
+// IsCritical is the default function that decides whether a command is
+// critical. See the package description for the list of critical commands.
+func IsCritical(c Command) bool {
+	switch c.Command {
+	case common.MAV_CMD_COMPONENT_ARM_DISARM:
+		return c.Params[0] != 0 ||
+			c.Params[1] == forceMagic ||
+			c.LandedState != common.MAV_LANDED_STATE_ON_GROUND
+
+	case common.MAV_CMD_DO_FLIGHTTERMINATION:
+		return c.Params[0] > 0.5
+
+	case common.MAV_CMD_DO_SET_SERVO:
+		return true
+	}
+
+	return false
+}
+
+// Request is a critical command that has been blocked.
+type Request struct {
+	// the token that must be passed to Confirm in order to allow the command.
+	Token string
+
+	// the blocked command.
+	Command Command
+
+	// the time at which the command has been blocked.
+	Time time.Time
+}
+
+// Conf allows to configure an Interlock.
+type Conf struct {
+	// (optional) a function that decides whether a command is critical.
+	// It defaults to IsCritical.
+	Critical func(c Command) bool
+
+	// (optional) a function that is called with critical commands that have
+	// not been confirmed. The command is written when it returns true,
+	// i.e. after the approval of the operator.
+	// It is called by the goroutines that write messages, therefore it must
+	// be safe for concurrent use.
+	Approve func(c Command) bool
+
+	// (optional) a function that is called when a critical command is
+	// blocked, i.e. to ask the operator for a confirmation. It is not called
+	// again when the same command is blocked again before the token expires.
+	// It is called by the goroutines that write messages, therefore it must
+	// not block.
+	OnBlock func(r Request)
+
+	// (optional) the validity of tokens, and the time during which a
+	// confirmed command can be written, including retransmissions.
+	// It defaults to 30 seconds.
+	ConfirmTimeout time.Duration
+}
+
+type entry struct {
+	req       Request
+	confirmed bool
+	expire    time.Time
+}
+
+// Interlock blocks critical commands that have not been approved.
+type Interlock struct {
+	conf Conf
+
+	mutex        sync.Mutex
+	landedStates map[byte]common.MAV_LANDED_STATE
+	entries      map[string]*entry
+}
+
+// New allocates an Interlock. See Conf for the options.
+func New(conf Conf) (*Interlock, error) {
+	if conf.ConfirmTimeout < 0 {
+		return nil, fmt.Errorf("ConfirmTimeout must be >= 0")
+	}
+	if conf.ConfirmTimeout == 0 {
+		conf.ConfirmTimeout = 30 * time.Second
+	}
+	if conf.Critical == nil {
+		conf.Critical = IsCritical
+	}
+
+	return &Interlock{
+		conf:         conf,
+		landedStates: make(map[byte]common.MAV_LANDED_STATE),
+		entries:      make(map[string]*entry),
+	}, nil
+}
+
+// HandleFrame tracks the landed state of vehicles. It must be added to the
+// node with Node.AddFrameHandler.
+func (i *Interlock) HandleFrame(evt *gomavlib.EventFrame) {
+	if evt.Message().GetId() != (&common.MessageExtendedSysState{}).GetId() {
+		return
+	}
+
+	var ess common.MessageExtendedSysState
+	if msg.Convert(&ess, evt.Message()) != nil {
+		return
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.landedStates[evt.SystemId()] = ess.LandedState
+}
+
+func parseCommand(m msg.Message) (Command, bool) {
+	switch m.GetId() {
+	case (&common.MessageCommandLong{}).GetId():
+		var cmd common.MessageCommandLong
+		if msg.Convert(&cmd, m) != nil {
+			return Command{}, false
+		}
+		return Command{
+			TargetSystem:    cmd.TargetSystem,
+			TargetComponent: cmd.TargetComponent,
+			Command:         cmd.Command,
+			Params: [7]float32{cmd.Param1, cmd.Param2, cmd.Param3, cmd.Param4,
+				cmd.Param5, cmd.Param6, cmd.Param7},
+		}, true
+
+	case (&common.MessageCommandInt{}).GetId():
+		var cmd common.MessageCommandInt
+		if msg.Convert(&cmd, m) != nil {
+			return Command{}, false
+		}
+		return Command{
+			TargetSystem:    cmd.TargetSystem,
+			TargetComponent: cmd.TargetComponent,
+			Command:         cmd.Command,
+			Params: [7]float32{cmd.Param1, cmd.Param2, cmd.Param3, cmd.Param4,
+				float32(cmd.X), float32(cmd.Y), cmd.Z},
+		}, true
+	}
+
+	return Command{}, false
+}
+
+// sameCommand returns whether two commands are equal, excluding the
+// landed state of the target.
+func sameCommand(a Command, b Command) bool {
+	a.LandedState = b.LandedState
+	return a == b
+}
+
+func newToken() string {
+	var buf [4]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// purge must be called with the mutex locked.
+func (i *Interlock) purge(now time.Time) {
+	for token, e := range i.entries {
+		if now.After(e.expire) {
+			delete(i.entries, token)
+		}
+	}
+}
+
+// Guard returns whether a message can be written. It must be set as
+// NodeConf.OutGuard.
+func (i *Interlock) Guard(m msg.Message) bool {
+	c, ok := parseCommand(m)
+	if !ok {
+		return true
+	}
+
+	i.mutex.Lock()
+	c.LandedState = i.landedStates[c.TargetSystem] // MAV_LANDED_STATE_UNDEFINED if missing
+	i.mutex.Unlock()
+
+	if !i.conf.Critical(c) {
+		return true
+	}
+
+	i.mutex.Lock()
+
+	now := time.Now()
+	i.purge(now)
+
+	for _, e := range i.entries {
+		if e.confirmed && sameCommand(e.req.Command, c) {
+			i.mutex.Unlock()
+			return true
+		}
+	}
+
+	i.mutex.Unlock()
+
+	if i.conf.Approve != nil && i.conf.Approve(c) {
+		return true
+	}
+
+	i.mutex.Lock()
+
+	// the command has already been blocked
+	for _, e := range i.entries {
+		if sameCommand(e.req.Command, c) {
+			i.mutex.Unlock()
+			return false
+		}
+	}
+
+	r := Request{
+		Token:   newToken(),
+		Command: c,
+		Time:    now,
+	}
+	i.entries[r.Token] = &entry{
+		req:    r,
+		expire: now.Add(i.conf.ConfirmTimeout),
+	}
+
+	i.mutex.Unlock()
+
+	if i.conf.OnBlock != nil {
+		i.conf.OnBlock(r)
+	}
+
+	return false
+}
+
+// Confirm allows the command associated with a token to be written, until
+// Conf.ConfirmTimeout elapses.
+func (i *Interlock) Confirm(token string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	i.purge(now)
+
+	e, ok := i.entries[token]
+	if !ok || e.confirmed {
+		return ErrInvalidToken
+	}
+
+	e.confirmed = true
+	e.expire = now.Add(i.conf.ConfirmTimeout)
+	return nil
+}
+
+// Pending returns the blocked commands that have not been confirmed yet,
+// sorted by time.
+func (i *Interlock) Pending() []Request {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.purge(time.Now())
+
+	var ret []Request
+	for _, e := range i.entries {
+		if !e.confirmed {
+			ret = append(ret, e.req)
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].Time.Before(ret[b].Time)
+	})
+	return ret
+}
@@ -0,0 +1,207 @@
+package interlock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+)
+
+func armCommand(arm float32) *common.MessageCommandLong {
+	return &common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 1,
+		Command:         common.MAV_CMD_COMPONENT_ARM_DISARM,
+		Param1:          arm,
+	}
+}
+
+func landedState(ls common.MAV_LANDED_STATE) *gomavlib.EventFrame {
+	return &gomavlib.EventFrame{Frame: &frame.V2Frame{
+		SystemId:    1,
+		ComponentId: 1,
+		Message:     &common.MessageExtendedSysState{LandedState: ls},
+	}}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{ConfirmTimeout: -1})
+	require.EqualError(t, err, "ConfirmTimeout must be >= 0")
+}
+
+func TestIsCritical(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		c        Command
+		critical bool
+	}{
+		{
+			"arm",
+			Command{Command: common.MAV_CMD_COMPONENT_ARM_DISARM, Params: [7]float32{1}},
+			true,
+		},
+		{
+			"disarm on ground",
+			Command{
+				Command:     common.MAV_CMD_COMPONENT_ARM_DISARM,
+				LandedState: common.MAV_LANDED_STATE_ON_GROUND,
+			},
+			false,
+		},
+		{
+			"disarm in air",
+			Command{
+				Command:     common.MAV_CMD_COMPONENT_ARM_DISARM,
+				LandedState: common.MAV_LANDED_STATE_IN_AIR,
+			},
+			true,
+		},
+		{
+			"disarm unknown",
+			Command{Command: common.MAV_CMD_COMPONENT_ARM_DISARM},
+			true,
+		},
+		{
+			"force disarm",
+			Command{
+				Command:     common.MAV_CMD_COMPONENT_ARM_DISARM,
+				Params:      [7]float32{0, 21196},
+				LandedState: common.MAV_LANDED_STATE_ON_GROUND,
+			},
+			true,
+		},
+		{
+			"termination",
+			Command{Command: common.MAV_CMD_DO_FLIGHTTERMINATION, Params: [7]float32{1}},
+			true,
+		},
+		{
+			"termination off",
+			Command{Command: common.MAV_CMD_DO_FLIGHTTERMINATION},
+			false,
+		},
+		{
+			"servo",
+			Command{Command: common.MAV_CMD_DO_SET_SERVO},
+			true,
+		},
+		{
+			"other",
+			Command{Command: common.MAV_CMD_NAV_RETURN_TO_LAUNCH},
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.critical, IsCritical(ca.c))
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var blocked []Request
+
+	il, err := New(Conf{
+		OnBlock: func(r Request) {
+			blocked = append(blocked, r)
+		},
+	})
+	require.NoError(t, err)
+
+	// other messages are not checked
+	require.Equal(t, true, il.Guard(&common.MessageHeartbeat{}))
+	require.Equal(t, true, il.Guard(&common.MessageCommandLong{
+		TargetSystem: 1,
+		Command:      common.MAV_CMD_NAV_RETURN_TO_LAUNCH,
+	}))
+
+	// disarms are critical until the vehicle is on ground
+	require.Equal(t, false, il.Guard(armCommand(0)))
+	require.Equal(t, 1, len(blocked))
+	il.HandleFrame(landedState(common.MAV_LANDED_STATE_ON_GROUND))
+	require.Equal(t, true, il.Guard(armCommand(0)))
+
+	// repeated commands are blocked once
+	require.Equal(t, false, il.Guard(armCommand(1)))
+	require.Equal(t, false, il.Guard(armCommand(1)))
+	require.Equal(t, 2, len(blocked))
+	require.Equal(t, Command{
+		TargetSystem:    1,
+		TargetComponent: 1,
+		Command:         common.MAV_CMD_COMPONENT_ARM_DISARM,
+		Params:          [7]float32{1},
+		LandedState:     common.MAV_LANDED_STATE_ON_GROUND,
+	}, blocked[1].Command)
+	require.Equal(t, 8, len(blocked[1].Token))
+
+	pending := il.Pending()
+	require.Equal(t, 2, len(pending))
+	require.Equal(t, blocked[1], pending[1])
+
+	err = il.Confirm("wrong")
+	require.Equal(t, ErrInvalidToken, err)
+
+	// confirmed commands are allowed, including retransmissions
+	err = il.Confirm(blocked[1].Token)
+	require.NoError(t, err)
+	require.Equal(t, true, il.Guard(armCommand(1)))
+	require.Equal(t, true, il.Guard(armCommand(1)))
+	require.Equal(t, 1, len(il.Pending()))
+
+	err = il.Confirm(blocked[1].Token)
+	require.Equal(t, ErrInvalidToken, err)
+
+	// other commands are still blocked
+	require.Equal(t, false, il.Guard(&common.MessageCommandInt{
+		TargetSystem: 1,
+		Command:      common.MAV_CMD_DO_SET_SERVO,
+		Param1:       9,
+		Param2:       1500,
+	}))
+	require.Equal(t, 3, len(blocked))
+}
+
+func TestApprove(t *testing.T) {
+	il, err := New(Conf{
+		Approve: func(c Command) bool {
+			return c.Command == common.MAV_CMD_DO_SET_SERVO
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, true, il.Guard(&common.MessageCommandLong{
+		TargetSystem: 1,
+		Command:      common.MAV_CMD_DO_SET_SERVO,
+	}))
+	require.Equal(t, false, il.Guard(armCommand(1)))
+}
+
+func TestExpiration(t *testing.T) {
+	var blocked []Request
+
+	il, err := New(Conf{
+		OnBlock: func(r Request) {
+			blocked = append(blocked, r)
+		},
+		ConfirmTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, false, il.Guard(armCommand(1)))
+	time.Sleep(100 * time.Millisecond)
+
+	err = il.Confirm(blocked[0].Token)
+	require.Equal(t, ErrInvalidToken, err)
+	require.Equal(t, 0, len(il.Pending()))
+
+	// confirmations expire too
+	require.Equal(t, false, il.Guard(armCommand(1)))
+	err = il.Confirm(blocked[1].Token)
+	require.NoError(t, err)
+	require.Equal(t, true, il.Guard(armCommand(1)))
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, false, il.Guard(armCommand(1)))
+}
@@ -0,0 +1,77 @@
+// Package testnode contains helpers that create the nodes used by the tests
+// of the packages built on top of gomavlib.
+package testnode
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// Conf returns the configuration of a test node with the given system id and
+// endpoints, that uses the common dialect, MAVLink v2 and doesn't emit
+// heartbeats.
+func Conf(systemId byte, endpoints ...gomavlib.EndpointConf) gomavlib.NodeConf {
+	return gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      systemId,
+		Endpoints:        endpoints,
+		HeartbeatDisable: true,
+	}
+}
+
+// Pipe returns two custom endpoints connected to each other through an
+// in-memory pipe.
+func Pipe() (gomavlib.EndpointConf, gomavlib.EndpointConf) {
+	c1, c2 := net.Pipe()
+	return gomavlib.EndpointCustom{ReadWriteCloser: c1},
+		gomavlib.EndpointCustom{ReadWriteCloser: c2}
+}
+
+// New creates two nodes with the given configurations. The test fails if
+// one of them can't be created.
+func New(t *testing.T, conf1 gomavlib.NodeConf, conf2 gomavlib.NodeConf) (*gomavlib.Node, *gomavlib.Node) {
+	node1, err := gomavlib.NewNode(conf1)
+	require.NoError(t, err)
+
+	node2, err := gomavlib.NewNode(conf2)
+	if err != nil {
+		node1.Close()
+	}
+	require.NoError(t, err)
+
+	return node1, node2
+}
+
+// Pair creates a ground station (system id 255) and a vehicle (system id 1)
+// connected through an in-memory pipe.
+func Pair(t *testing.T) (*gomavlib.Node, *gomavlib.Node) {
+	e1, e2 := Pipe()
+	return New(t, Conf(255, e1), Conf(1, e2))
+}
+
+// DiscardEvents reads and discards the events of a node in a goroutine, until
+// the node is closed.
+func DiscardEvents(n *gomavlib.Node) {
+	go func() {
+		for range n.Events() {
+		}
+	}()
+}
+
+// OnFrame calls cb with every frame received by a node, in a goroutine, until
+// the node is closed.
+func OnFrame(n *gomavlib.Node, cb func(fr *gomavlib.EventFrame)) {
+	go func() {
+		for evt := range n.Events() {
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				cb(fr)
+			}
+		}
+	}()
+}
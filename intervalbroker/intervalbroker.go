@@ -0,0 +1,356 @@
+// Package intervalbroker implements a service that answers message rate
+// requests on behalf of a vehicle, serving messages from a cache of the latest
+// frames received from it.
+//
+// The broker handles MAV_CMD_SET_MESSAGE_INTERVAL, MAV_CMD_GET_MESSAGE_INTERVAL
+// and MAV_CMD_REQUEST_MESSAGE addressed to the vehicle, and emits the cached
+// frames at the requested rates to the channel that sent the request. This
+// allows a proxy to satisfy the rate requests of ground stations without
+// forwarding them to the vehicle. Frames are re-emitted unchanged, therefore
+// they keep the system id, component id and sequence id of the vehicle.
+//
+// The node to which the broker is attached must use a dialect that contains
+// the common messages.
+package intervalbroker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	commandQueueSize = 16
+)
+
+// Conf allows to configure a Broker.
+type Conf struct {
+	// the node to which the broker is attached.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle. It defaults to 1.
+	ComponentId byte
+
+	// (optional) the minimum interval between two messages of a stream.
+	// Shorter requested intervals are raised to this value.
+	// It defaults to 10ms.
+	MinInterval time.Duration
+}
+
+type streamKey struct {
+	ch *gomavlib.Channel
+	id uint32
+}
+
+type stream struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// Broker answers message rate requests on behalf of a vehicle.
+type Broker struct {
+	conf          Conf
+	ackDE         *msg.DecEncoder
+	intervalDE    *msg.DecEncoder
+	removeHandler func()
+
+	mutex   sync.Mutex
+	cache   map[uint32]frame.Frame
+	streams map[streamKey]*stream
+
+	// accessed by run() only
+	sequenceId byte
+
+	commands  chan *gomavlib.EventFrame
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Broker. See Conf for the options.
+func New(conf Conf) (*Broker, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{},
+		&common.MessageMessageInterval{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.MinInterval == 0 {
+		conf.MinInterval = 10 * time.Millisecond
+	}
+
+	ackDE, err := msg.NewDecEncoder(&common.MessageCommandAck{})
+	if err != nil {
+		return nil, err
+	}
+
+	intervalDE, err := msg.NewDecEncoder(&common.MessageMessageInterval{})
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		conf:       conf,
+		ackDE:      ackDE,
+		intervalDE: intervalDE,
+		cache:      make(map[uint32]frame.Frame),
+		streams:    make(map[streamKey]*stream),
+		commands:   make(chan *gomavlib.EventFrame, commandQueueSize),
+		terminate:  make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	b.removeHandler = conf.Node.AddFrameHandler(b.onEventFrame)
+
+	go b.run()
+
+	return b, nil
+}
+
+// Close stops the broker. It must be called before closing the node.
+func (b *Broker) Close() {
+	b.removeHandler()
+	close(b.terminate)
+	<-b.done
+}
+
+// Handles returns whether a frame is a command that is handled by the broker.
+// Routers can use it to avoid forwarding these commands to the vehicle.
+func (b *Broker) Handles(evt *gomavlib.EventFrame) bool {
+	_, ok := b.command(evt)
+	return ok
+}
+
+func (b *Broker) command(evt *gomavlib.EventFrame) (*common.MessageCommandLong, bool) {
+	if evt.Message().GetId() != (&common.MessageCommandLong{}).GetId() {
+		return nil, false
+	}
+
+	var cmd common.MessageCommandLong
+	if msg.Convert(&cmd, evt.Message()) != nil {
+		return nil, false
+	}
+
+	if cmd.TargetSystem != b.conf.SystemId ||
+		(cmd.TargetComponent != b.conf.ComponentId && cmd.TargetComponent != 0) {
+		return nil, false
+	}
+
+	switch cmd.Command {
+	case common.MAV_CMD_SET_MESSAGE_INTERVAL,
+		common.MAV_CMD_GET_MESSAGE_INTERVAL,
+		common.MAV_CMD_REQUEST_MESSAGE:
+		return &cmd, true
+	}
+	return nil, false
+}
+
+func (b *Broker) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() == b.conf.SystemId {
+		if evt.ComponentId() == b.conf.ComponentId {
+			b.mutex.Lock()
+			b.cache[evt.Message().GetId()] = evt.Frame
+			b.mutex.Unlock()
+		}
+		return
+	}
+
+	if !b.Handles(evt) {
+		return
+	}
+
+	// frame handlers must not block; commands are dropped when the queue is full
+	select {
+	case b.commands <- evt:
+	default:
+	}
+}
+
+func (b *Broker) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.conf.MinInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt := <-b.commands:
+			cmd, ok := b.command(evt)
+			if !ok {
+				continue
+			}
+			b.handleCommand(evt, cmd)
+
+		case now := <-ticker.C:
+			b.sendStreams(now)
+
+		case <-b.terminate:
+			return
+		}
+	}
+}
+
+func (b *Broker) handleCommand(evt *gomavlib.EventFrame, cmd *common.MessageCommandLong) {
+	id := uint32(cmd.Param1)
+	key := streamKey{evt.Channel, id}
+
+	switch cmd.Command {
+	case common.MAV_CMD_SET_MESSAGE_INTERVAL:
+		interval := cmd.Param2
+
+		b.mutex.Lock()
+		if interval <= 0 {
+			// -1 disables the stream, 0 restores the default rate, that is the
+			// one of the vehicle
+			delete(b.streams, key)
+
+		} else {
+			d := time.Duration(interval) * time.Microsecond
+			if d < b.conf.MinInterval {
+				d = b.conf.MinInterval
+			}
+			b.streams[key] = &stream{
+				interval: d,
+				next:     time.Now(),
+			}
+		}
+		b.mutex.Unlock()
+
+		b.write(evt, b.ack(evt, cmd.Command, common.MAV_RESULT_ACCEPTED))
+
+	case common.MAV_CMD_GET_MESSAGE_INTERVAL:
+		b.mutex.Lock()
+		s, ok := b.streams[key]
+		b.mutex.Unlock()
+
+		// 0 means that the stream is not available
+		var intervalUs int32
+		if ok {
+			intervalUs = int32(s.interval / time.Microsecond)
+		}
+
+		b.write(evt, b.ack(evt, cmd.Command, common.MAV_RESULT_ACCEPTED))
+		b.write(evt, b.frame(evt, b.intervalDE, &common.MessageMessageInterval{
+			MessageId:  uint16(id),
+			IntervalUs: intervalUs,
+		}))
+
+	case common.MAV_CMD_REQUEST_MESSAGE:
+		b.mutex.Lock()
+		f, ok := b.cache[id]
+		b.mutex.Unlock()
+
+		if !ok {
+			b.write(evt, b.ack(evt, cmd.Command, common.MAV_RESULT_FAILED))
+			return
+		}
+
+		b.write(evt, b.ack(evt, cmd.Command, common.MAV_RESULT_ACCEPTED))
+		b.conf.Node.WriteFrameTo(evt.Channel, f)
+	}
+}
+
+func (b *Broker) sendStreams(now time.Time) {
+	type pending struct {
+		ch *gomavlib.Channel
+		f  frame.Frame
+	}
+	var toSend []pending
+
+	b.mutex.Lock()
+	for key, s := range b.streams {
+		if now.Before(s.next) {
+			continue
+		}
+
+		s.next = s.next.Add(s.interval)
+		if s.next.Before(now) {
+			s.next = now.Add(s.interval)
+		}
+
+		// messages that have not been received yet are skipped
+		if f, ok := b.cache[key.id]; ok {
+			toSend = append(toSend, pending{key.ch, f})
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, p := range toSend {
+		b.conf.Node.WriteFrameTo(p.ch, p.f)
+	}
+}
+
+func (b *Broker) write(evt *gomavlib.EventFrame, f frame.Frame) {
+	if f != nil {
+		b.conf.Node.WriteFrameTo(evt.Channel, f)
+	}
+}
+
+func (b *Broker) ack(evt *gomavlib.EventFrame, command common.MAV_CMD,
+	res common.MAV_RESULT) frame.Frame {
+	return b.frame(evt, b.ackDE, &common.MessageCommandAck{
+		Command:         command,
+		Result:          res,
+		TargetSystem:    evt.SystemId(),
+		TargetComponent: evt.ComponentId(),
+	})
+}
+
+// frame builds a frame that contains the given message and appears to be sent
+// by the vehicle, with the same version of the request.
+func (b *Broker) frame(evt *gomavlib.EventFrame, de *msg.DecEncoder, m msg.Message) frame.Frame {
+	_, isV2 := evt.Frame.(*frame.V2Frame)
+
+	content, err := de.Encode(m, isV2)
+	if err != nil {
+		return nil
+	}
+	raw := &msg.MessageRaw{Id: m.GetId(), Content: content}
+
+	seq := b.sequenceId
+	b.sequenceId++
+
+	if isV2 {
+		f := &frame.V2Frame{
+			SequenceId:  seq,
+			SystemId:    b.conf.SystemId,
+			ComponentId: b.conf.ComponentId,
+			Message:     raw,
+		}
+		f.Checksum = f.GenChecksum(de.CRCExtra())
+		return f
+	}
+
+	f := &frame.V1Frame{
+		SequenceId:  seq,
+		SystemId:    b.conf.SystemId,
+		ComponentId: b.conf.ComponentId,
+		Message:     raw,
+	}
+	f.Checksum = f.GenChecksum(de.CRCExtra())
+	return f
+}
@@ -0,0 +1,146 @@
+package intervalbroker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestBroker(t *testing.T) {
+	proxy, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 254,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5670"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	go func() {
+		for range proxy.Events() {
+		}
+	}()
+
+	b, err := New(Conf{
+		Node:     proxy,
+		SystemId: 1,
+	})
+	require.NoError(t, err)
+	defer b.Close()
+
+	vehicle, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5670"},
+		},
+		HeartbeatPeriod: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer vehicle.Close()
+
+	go func() {
+		for range vehicle.Events() {
+		}
+	}()
+
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      255,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointUdpClient{Address: "127.0.0.1:5670"}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	heartbeatId := float32((&common.MessageHeartbeat{}).GetId())
+
+	// wait until the vehicle heartbeat is cached
+	var ack *common.MessageCommandAck
+	for i := 0; i < 50 && (ack == nil || ack.Result != common.MAV_RESULT_ACCEPTED); i++ {
+		gcs.WriteMessageAll(&common.MessageCommandLong{
+			TargetSystem:    1,
+			TargetComponent: 1,
+			Command:         common.MAV_CMD_REQUEST_MESSAGE,
+			Param1:          heartbeatId,
+		})
+
+		timeout := time.After(100 * time.Millisecond)
+	recv:
+		for {
+			select {
+			case evt := <-gcs.Events():
+				if fr, ok := evt.(*gomavlib.EventFrame); ok {
+					if m, ok := fr.Message().(*common.MessageCommandAck); ok {
+						require.Equal(t, byte(1), fr.SystemId())
+						require.Equal(t, common.MAV_CMD_REQUEST_MESSAGE, m.Command)
+						require.Equal(t, uint8(255), m.TargetSystem)
+						ack = m
+
+						// the heartbeat may not be cached yet; wait before retrying
+						if ack.Result == common.MAV_RESULT_ACCEPTED {
+							break recv
+						}
+					}
+				}
+			case <-timeout:
+				break recv
+			}
+		}
+	}
+	require.NotNil(t, ack)
+	require.Equal(t, common.MAV_RESULT_ACCEPTED, ack.Result)
+
+	gcs.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 1,
+		Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+		Param1:          heartbeatId,
+		Param2:          20000,
+	})
+
+	gcs.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 1,
+		Command:         common.MAV_CMD_GET_MESSAGE_INTERVAL,
+		Param1:          heartbeatId,
+	})
+
+	heartbeats := 0
+	var interval *common.MessageMessageInterval
+	timeout := time.After(500 * time.Millisecond)
+outer:
+	for {
+		select {
+		case evt := <-gcs.Events():
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				switch m := fr.Message().(type) {
+				case *common.MessageHeartbeat:
+					require.Equal(t, byte(1), fr.SystemId())
+					heartbeats++
+
+				case *common.MessageMessageInterval:
+					interval = m
+				}
+			}
+		case <-timeout:
+			break outer
+		}
+	}
+
+	require.NotNil(t, interval)
+	require.Equal(t, int32(20000), interval.IntervalUs)
+
+	// the vehicle is not connected to the GCS, therefore all heartbeats
+	// are emitted by the broker
+	require.True(t, heartbeats >= 15)
+}
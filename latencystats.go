@@ -0,0 +1,196 @@
+package gomavlib
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// upper bounds of the buckets of latency histograms.
+var latencyBounds = []time.Duration{
+	1 * time.Microsecond,
+	2 * time.Microsecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	20 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	200 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// LatencyHistogram is the distribution of the durations of a processing stage.
+type LatencyHistogram struct {
+	// the upper bounds of the buckets, in increasing order.
+	Bounds []time.Duration
+
+	// the number of durations of each bucket. Counts[i] is the number of
+	// durations <= Bounds[i] and > Bounds[i-1]; the last element is the number
+	// of durations > the last bound.
+	Counts []uint64
+
+	// the number of durations.
+	Count uint64
+
+	// the sum of durations.
+	Sum time.Duration
+
+	// the longest duration.
+	Max time.Duration
+}
+
+func (h *LatencyHistogram) add(d time.Duration) {
+	if h.Counts == nil {
+		h.Bounds = latencyBounds
+		h.Counts = make([]uint64, len(latencyBounds)+1)
+	}
+
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+func (h LatencyHistogram) clone() LatencyHistogram {
+	if h.Counts != nil {
+		h.Counts = append([]uint64(nil), h.Counts...)
+	}
+	return h
+}
+
+// Mean returns the mean duration, or zero if there are no durations.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an upper bound of the given quantile (between 0 and 1) of
+// durations, that is the upper bound of the bucket that contains it, or Max
+// if it is lower. It returns zero if there are no durations.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(q * float64(h.Count)))
+	if target < 1 {
+		target = 1
+	}
+
+	cum := uint64(0)
+	for i, c := range h.Counts {
+		cum += c
+		if cum >= target {
+			if i < len(h.Bounds) && h.Bounds[i] < h.Max {
+				return h.Bounds[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+// LatencyStats contains the distributions of the durations of the stages in
+// which frames are processed by a channel. They are collected when
+// NodeConf.LatencyStatsEnable is true, and allow to find out whether the
+// bottleneck is the link, the decoding of messages or the application.
+type LatencyStats struct {
+	// the time spent in reads of the endpoint, including the time spent
+	// waiting for incoming data.
+	Read LatencyHistogram
+
+	// the time spent parsing frames, checking their checksum and signature,
+	// excluding reads and the decoding of messages.
+	Parse LatencyHistogram
+
+	// the time spent decoding messages.
+	Decode LatencyHistogram
+
+	// the time between the parsing of a frame and the moment in which its
+	// EventFrame is taken from Events(), including frame handlers.
+	// It grows when frame handlers or the consumer of events are slow.
+	Route LatencyHistogram
+
+	// the time spent encoding and writing frames to the endpoint.
+	Write LatencyHistogram
+}
+
+type latencyRecorder struct {
+	mutex sync.Mutex
+	stats LatencyStats
+
+	// accessed by the reader routine only
+	readTime   time.Duration
+	decodeTime time.Duration
+}
+
+func (r *latencyRecorder) add(h *LatencyHistogram, d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h.add(d)
+}
+
+func (r *latencyRecorder) onRead(d time.Duration) {
+	r.readTime += d
+	r.add(&r.stats.Read, d)
+}
+
+func (r *latencyRecorder) onDecode(d time.Duration) {
+	r.decodeTime += d
+	r.add(&r.stats.Decode, d)
+}
+
+// onFrame is called after a frame has been read, with the duration of the
+// read, that includes reads of the endpoint and decoding.
+func (r *latencyRecorder) onFrame(d time.Duration) {
+	parse := d - r.readTime - r.decodeTime
+	if parse < 0 {
+		parse = 0
+	}
+	r.readTime = 0
+	r.decodeTime = 0
+
+	r.add(&r.stats.Parse, parse)
+}
+
+// LatencyStats returns the distributions of the durations of the processing
+// stages of the channel, since its creation. They are empty if
+// NodeConf.LatencyStatsEnable is false.
+func (ch *Channel) LatencyStats() LatencyStats {
+	if ch.latency == nil {
+		return LatencyStats{}
+	}
+
+	ch.latency.mutex.Lock()
+	defer ch.latency.mutex.Unlock()
+
+	s := ch.latency.stats
+	return LatencyStats{
+		Read:   s.Read.clone(),
+		Parse:  s.Parse.clone(),
+		Decode: s.Decode.clone(),
+		Route:  s.Route.clone(),
+		Write:  s.Write.clone(),
+	}
+}
@@ -0,0 +1,452 @@
+// Package logdownload implements the log download protocol, that allows to
+// list the logs stored onboard a vehicle (i.e. dataflash logs) and to
+// download them.
+//
+// Logs are listed with LOG_REQUEST_LIST and LOG_ENTRY messages, and are
+// downloaded in windows: the content of each window is requested with a
+// LOG_REQUEST_DATA message and is streamed by the vehicle in LOG_DATA
+// messages. Chunks that are lost are requested again.
+//
+// The node with which logs are downloaded must use a dialect that contains
+// the common messages.
+package logdownload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// LOG_DATA messages are streamed at high rates, therefore the queue is
+	// bigger than the one of other helpers
+	messageQueueSize = 256
+
+	// maximum size of the data of a LOG_DATA message.
+	maxChunkSize = 90
+)
+
+// ErrTimeout is returned when the vehicle stops answering.
+var ErrTimeout = fmt.Errorf("log request timed out")
+
+// Entry is a log stored onboard the vehicle.
+type Entry struct {
+	// the id of the log.
+	Id uint16
+
+	// the creation time of the log, or zero if unknown.
+	Time time.Time
+
+	// the size of the log, in bytes.
+	Size uint32
+}
+
+// ClientConf allows to configure a Client.
+type ClientConf struct {
+	// the node with which logs are downloaded.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the time after which a request that has not been answered,
+	// or whose answer has stopped, is sent again. It defaults to 1 second.
+	Timeout time.Duration
+
+	// (optional) the maximum number of consecutive retransmissions of a
+	// request. It defaults to 3.
+	MaxRetransmissions int
+
+	// (optional) the size of the windows in which logs are downloaded.
+	// It defaults to 45KiB (512 chunks).
+	WindowSize uint32
+}
+
+// Client lists and downloads the logs of a vehicle.
+type Client struct {
+	conf          ClientConf
+	removeHandler func()
+
+	// serializes requests
+	requestMutex sync.Mutex
+
+	mutex    sync.Mutex
+	active   bool
+	messages chan msg.Message
+
+	terminate chan struct{}
+}
+
+// NewClient allocates a Client. See ClientConf for the options.
+func NewClient(conf ClientConf) (*Client, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageLogRequestList{},
+		&common.MessageLogEntry{},
+		&common.MessageLogRequestData{},
+		&common.MessageLogData{},
+		&common.MessageLogRequestEnd{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 1 * time.Second
+	}
+	if conf.MaxRetransmissions == 0 {
+		conf.MaxRetransmissions = 3
+	}
+	if conf.WindowSize == 0 {
+		conf.WindowSize = 512 * maxChunkSize
+	}
+
+	c := &Client{
+		conf:      conf,
+		messages:  make(chan msg.Message, messageQueueSize),
+		terminate: make(chan struct{}),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	return c, nil
+}
+
+// Close stops the client. Pending requests return an error.
+// It must be called before closing the node.
+func (c *Client) Close() {
+	c.removeHandler()
+	close(c.terminate)
+}
+
+func (c *Client) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != c.conf.SystemId || evt.ComponentId() != c.conf.ComponentId {
+		return
+	}
+
+	var m msg.Message
+
+	switch evt.Message().GetId() {
+	case (&common.MessageLogEntry{}).GetId():
+		var entry common.MessageLogEntry
+		if msg.Convert(&entry, evt.Message()) != nil {
+			return
+		}
+		m = &entry
+
+	case (&common.MessageLogData{}).GetId():
+		var data common.MessageLogData
+		if msg.Convert(&data, evt.Message()) != nil {
+			return
+		}
+		m = &data
+
+	default:
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.active {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case c.messages <- m:
+	default:
+	}
+}
+
+func (c *Client) startRequest() {
+	c.requestMutex.Lock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.active = true
+
+	// discard the messages of previous requests
+	for len(c.messages) > 0 {
+		<-c.messages
+	}
+}
+
+func (c *Client) stopRequest() {
+	c.mutex.Lock()
+	c.active = false
+	c.mutex.Unlock()
+
+	c.requestMutex.Unlock()
+}
+
+// errStalled is returned by requests that must not be sent again.
+var errStalled = fmt.Errorf("stalled")
+
+// wait sends a request and passes the received messages to handle, until it
+// reports that the request is done or returns an error. The request is sent
+// again when handle does not report progress within the timeout.
+func (c *Client) wait(ctx context.Context, request func() error,
+	handle func(msg.Message) (progress bool, done bool, err error)) error {
+	err := request()
+	if err != nil {
+		return err
+	}
+
+	retransmissions := 0
+
+	timer := time.NewTimer(c.conf.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case m := <-c.messages:
+			progress, done, err := handle(m)
+			if err != nil || done {
+				return err
+			}
+
+			if progress {
+				retransmissions = 0
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(c.conf.Timeout)
+			}
+
+		case <-timer.C:
+			if retransmissions >= c.conf.MaxRetransmissions {
+				return ErrTimeout
+			}
+
+			retransmissions++
+			err := request()
+			if err != nil {
+				return err
+			}
+			timer.Reset(c.conf.Timeout)
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-c.terminate:
+			return fmt.Errorf("terminated")
+		}
+	}
+}
+
+func entryFromMessage(m *common.MessageLogEntry) Entry {
+	e := Entry{
+		Id:   m.Id,
+		Size: m.Size,
+	}
+	if m.TimeUtc != 0 {
+		e.Time = time.Unix(int64(m.TimeUtc), 0).UTC()
+	}
+	return e
+}
+
+// List lists the logs of the vehicle, sorted by id. Entries that are lost
+// during the listing are requested again one by one.
+func (c *Client) List(ctx context.Context) ([]Entry, error) {
+	c.startRequest()
+	defer c.stopRequest()
+
+	count := -1
+	var lastId uint16
+	received := make(map[uint16]Entry)
+
+	request := func(start uint16, end uint16) func() error {
+		return func() error {
+			c.conf.Node.WriteMessageAll(&common.MessageLogRequestList{
+				TargetSystem:    c.conf.SystemId,
+				TargetComponent: c.conf.ComponentId,
+				Start:           start,
+				End:             end,
+			})
+			return nil
+		}
+	}
+
+	handle := func(m msg.Message) bool {
+		entry, ok := m.(*common.MessageLogEntry)
+		if !ok {
+			return false
+		}
+
+		count = int(entry.NumLogs)
+		lastId = entry.LastLogNum
+
+		// vehicles without logs send an entry with no logs
+		if count > 0 {
+			received[entry.Id] = entryFromMessage(entry)
+		}
+		return true
+	}
+
+	// entries are collected until they stop arriving, then the missing ones
+	// are requested by id
+	err := c.wait(ctx, func() error {
+		if count >= 0 {
+			return errStalled
+		}
+		return request(0, 0xFFFF)()
+	}, func(m msg.Message) (bool, bool, error) {
+		if !handle(m) {
+			return false, false, nil
+		}
+		return true, len(received) == count, nil
+	})
+	if err != nil && err != errStalled {
+		return nil, err
+	}
+
+	// ids are consecutive and end with the last one
+	for i := 0; i < count; i++ {
+		id := lastId - uint16(count-1-i)
+		if _, ok := received[id]; ok {
+			continue
+		}
+
+		err := c.wait(ctx, request(id, id), func(m msg.Message) (bool, bool, error) {
+			handle(m)
+			_, ok := received[id]
+			return false, ok, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make([]Entry, 0, len(received))
+	for _, e := range received {
+		ret = append(ret, e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Id < ret[j].Id
+	})
+
+	return ret, nil
+}
+
+// Download downloads a log and writes it into w. Progress, if not nil, is
+// called every time data is written, with the number of written bytes and
+// the size of the log.
+func (c *Client) Download(ctx context.Context, entry Entry, w io.Writer,
+	progress func(written uint32, size uint32)) error {
+	c.startRequest()
+	defer c.stopRequest()
+
+	// stop the streaming of data, even when the download fails
+	defer c.conf.Node.WriteMessageAll(&common.MessageLogRequestEnd{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+	})
+
+	written := uint32(0)
+
+	for written < entry.Size {
+		end := written + c.conf.WindowSize
+		if end > entry.Size {
+			end = entry.Size
+		}
+
+		// chunks that are not contiguous to the written ones, by offset
+		pending := make(map[uint32][]byte)
+
+		// the rest of the window is requested from the first missing offset
+		request := func() error {
+			c.conf.Node.WriteMessageAll(&common.MessageLogRequestData{
+				TargetSystem:    c.conf.SystemId,
+				TargetComponent: c.conf.ComponentId,
+				Id:              entry.Id,
+				Ofs:             written,
+				Count:           end - written,
+			})
+			return nil
+		}
+
+		err := c.wait(ctx, request, func(m msg.Message) (bool, bool, error) {
+			data, ok := m.(*common.MessageLogData)
+			if !ok || data.Id != entry.Id || data.Ofs < written || data.Ofs >= end {
+				return false, false, nil
+			}
+
+			// the log is shorter than expected
+			if data.Count == 0 {
+				end = written
+				entry.Size = written
+				return false, true, nil
+			}
+
+			count := uint32(data.Count)
+			if count > maxChunkSize {
+				count = maxChunkSize
+			}
+			if data.Ofs+count > end {
+				count = end - data.Ofs
+			}
+			pending[data.Ofs] = data.Data[:count]
+
+			prev := written
+			for {
+				chunk, ok := pending[written]
+				if !ok {
+					break
+				}
+				delete(pending, written)
+
+				_, err := w.Write(chunk)
+				if err != nil {
+					return false, false, err
+				}
+				written += uint32(len(chunk))
+			}
+
+			if written != prev && progress != nil {
+				progress(written, entry.Size)
+			}
+
+			if written >= end {
+				return true, true, nil
+			}
+
+			// the window has been received with gaps
+			if data.Ofs+count >= end {
+				request()
+			}
+
+			return written != prev, false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
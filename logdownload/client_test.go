@@ -0,0 +1,247 @@
+package logdownload
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// testVehicle implements the vehicle side of the log download protocol.
+type testVehicle struct {
+	node *gomavlib.Node
+
+	mutex sync.Mutex
+	// logs, by id
+	logs map[uint16][]byte
+	// id of an entry that is not sent during the first listing
+	skipEntry uint16
+	// offset of a chunk that is not sent, to simulate losses
+	dropChunk int
+	// number of received LOG_REQUEST_END
+	ended int
+	// disables answers
+	mute bool
+}
+
+func newTestVehicle(t *testing.T) (*gomavlib.Node, *testVehicle) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{
+		node: node,
+		logs: map[uint16][]byte{
+			3: testContent(100),
+			4: testContent(1000),
+			5: testContent(0),
+		},
+		dropChunk: -1,
+	}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		v.onMessage(fr.Message())
+	})
+
+	return gcs, v
+}
+
+func testContent(n int) []byte {
+	ret := make([]byte, n)
+	for i := range ret {
+		ret[i] = byte(i * 3)
+	}
+	return ret
+}
+
+func (v *testVehicle) onMessage(m msg.Message) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.mute {
+		return
+	}
+
+	switch tm := m.(type) {
+	case *common.MessageLogRequestList:
+		// a vehicle without logs sends a single entry
+		if len(v.logs) == 0 {
+			v.node.WriteMessageAll(&common.MessageLogEntry{})
+			return
+		}
+
+		for id := tm.Start; id <= tm.End && id <= 5; id++ {
+			content, ok := v.logs[id]
+			if !ok {
+				continue
+			}
+
+			if id == v.skipEntry {
+				v.skipEntry = 0
+				continue
+			}
+
+			v.node.WriteMessageAll(&common.MessageLogEntry{
+				Id:         id,
+				NumLogs:    uint16(len(v.logs)),
+				LastLogNum: 5,
+				TimeUtc:    1600000000 + uint32(id),
+				Size:       uint32(len(content)),
+			})
+		}
+
+	case *common.MessageLogRequestData:
+		content := v.logs[tm.Id]
+		end := int(tm.Ofs + tm.Count)
+		if end > len(content) {
+			end = len(content)
+		}
+
+		for ofs := int(tm.Ofs); ofs < end; ofs += maxChunkSize {
+			if ofs == v.dropChunk {
+				v.dropChunk = -1
+				continue
+			}
+
+			n := end - ofs
+			if n > maxChunkSize {
+				n = maxChunkSize
+			}
+
+			data := &common.MessageLogData{
+				Id:    tm.Id,
+				Ofs:   uint32(ofs),
+				Count: uint8(n),
+			}
+			copy(data.Data[:], content[ofs:ofs+n])
+			v.node.WriteMessageAll(data)
+		}
+
+	case *common.MessageLogRequestEnd:
+		v.ended++
+	}
+}
+
+func newTestClient(t *testing.T, gcs *gomavlib.Node) *Client {
+	c, err := NewClient(ClientConf{
+		Node:               gcs,
+		SystemId:           1,
+		Timeout:            100 * time.Millisecond,
+		MaxRetransmissions: 2,
+		WindowSize:         4 * maxChunkSize,
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func TestNewClientErrors(t *testing.T) {
+	_, err := NewClient(ClientConf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	_, err = NewClient(ClientConf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+}
+
+func TestClientList(t *testing.T) {
+	for _, ca := range []string{"standard", "losses", "empty"} {
+		t.Run(ca, func(t *testing.T) {
+			gcs, v := newTestVehicle(t)
+			defer gcs.Close()
+			defer v.node.Close()
+
+			switch ca {
+			case "losses":
+				v.skipEntry = 4
+
+			case "empty":
+				v.logs = map[uint16][]byte{}
+			}
+
+			c := newTestClient(t, gcs)
+			defer c.Close()
+
+			if ca == "empty" {
+				entries, err := c.List(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, []Entry{}, entries)
+				return
+			}
+
+			entries, err := c.List(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, []Entry{
+				{Id: 3, Time: time.Unix(1600000003, 0).UTC(), Size: 100},
+				{Id: 4, Time: time.Unix(1600000004, 0).UTC(), Size: 1000},
+				{Id: 5, Time: time.Unix(1600000005, 0).UTC(), Size: 0},
+			}, entries)
+		})
+	}
+}
+
+func TestClientDownload(t *testing.T) {
+	for _, ca := range []string{"standard", "losses"} {
+		t.Run(ca, func(t *testing.T) {
+			gcs, v := newTestVehicle(t)
+			defer gcs.Close()
+			defer v.node.Close()
+
+			if ca == "losses" {
+				v.dropChunk = 5 * maxChunkSize
+			}
+
+			c := newTestClient(t, gcs)
+			defer c.Close()
+
+			var progress []uint32
+			var buf bytes.Buffer
+
+			err := c.Download(context.Background(), Entry{Id: 4, Size: 1000}, &buf,
+				func(written uint32, size uint32) {
+					require.Equal(t, uint32(1000), size)
+					progress = append(progress, written)
+				})
+			require.NoError(t, err)
+			require.Equal(t, testContent(1000), buf.Bytes())
+			require.Equal(t, uint32(1000), progress[len(progress)-1])
+
+			time.Sleep(50 * time.Millisecond)
+
+			v.mutex.Lock()
+			require.Equal(t, 1, v.ended)
+			v.mutex.Unlock()
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs)
+	defer c.Close()
+
+	v.mutex.Lock()
+	v.mute = true
+	v.mutex.Unlock()
+
+	var buf bytes.Buffer
+	err := c.Download(context.Background(), Entry{Id: 4, Size: 1000}, &buf, nil)
+	require.Equal(t, ErrTimeout, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.List(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
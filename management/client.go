@@ -0,0 +1,276 @@
+package management
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// ErrTimeout is returned when a request is not answered.
+var ErrTimeout = fmt.Errorf("request timed out")
+
+// EndpointInfo describes an endpoint of a managed node.
+type EndpointInfo struct {
+	// the name of the endpoint.
+	Name string
+
+	// the description of the endpoint.
+	Endpoint string
+
+	// the ids of the messages that are not forwarded to the endpoint.
+	Blocked []uint32
+}
+
+// ClientConf allows to configure a Client.
+type ClientConf struct {
+	// the node with which requests are sent.
+	Node *gomavlib.Node
+
+	// the system id of the managed node.
+	SystemId byte
+
+	// the payload type of TUNNEL messages, that must be the same as the one of
+	// the manager.
+	PayloadType common.MAV_TUNNEL_PAYLOAD_TYPE
+
+	// (optional) the component id of the managed node.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the time after which a request that has not been answered
+	// is sent again. It defaults to 1 second.
+	Timeout time.Duration
+
+	// (optional) the maximum number of retransmissions of a request.
+	// It defaults to 3.
+	MaxRetransmissions int
+}
+
+// Client sends management requests to a node that runs a Manager.
+type Client struct {
+	conf          ClientConf
+	removeHandler func()
+
+	// serializes requests
+	requestMutex sync.Mutex
+	seq          uint32
+
+	mutex     sync.Mutex
+	pending   string
+	responses chan response
+}
+
+type response struct {
+	seq  string
+	line string
+}
+
+// NewClient allocates a Client. See ClientConf for the options.
+func NewClient(conf ClientConf) (*Client, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.PayloadType == 0 {
+		return nil, fmt.Errorf("PayloadType not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(&common.MessageTunnel{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 1 * time.Second
+	}
+	if conf.MaxRetransmissions == 0 {
+		conf.MaxRetransmissions = 3
+	}
+
+	c := &Client{
+		conf: conf,
+		// a list request is answered with multiple responses
+		responses: make(chan response, requestQueueSize),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	return c, nil
+}
+
+// Close closes the client. It must be called before closing the node.
+func (c *Client) Close() {
+	c.removeHandler()
+}
+
+// Add asks the managed node to add an endpoint.
+// See ParseEndpoint for the format of endpoint.
+func (c *Client) Add(name string, endpoint string) error {
+	_, err := c.do("add " + name + " " + endpoint)
+	return err
+}
+
+// Remove asks the managed node to remove an endpoint.
+func (c *Client) Remove(name string) error {
+	_, err := c.do("remove " + name)
+	return err
+}
+
+// Block asks the managed node to stop forwarding a message to an endpoint.
+func (c *Client) Block(name string, messageId uint32) error {
+	_, err := c.do("block " + name + " " + strconv.FormatUint(uint64(messageId), 10))
+	return err
+}
+
+// Unblock asks the managed node to forward again a message to an endpoint.
+func (c *Client) Unblock(name string, messageId uint32) error {
+	_, err := c.do("unblock " + name + " " + strconv.FormatUint(uint64(messageId), 10))
+	return err
+}
+
+// List returns the endpoints of the managed node, sorted by name.
+func (c *Client) List() ([]EndpointInfo, error) {
+	items, err := c.do("list")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []EndpointInfo
+	for _, item := range items {
+		fields := strings.Fields(item)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid response: %s", item)
+		}
+
+		info := EndpointInfo{
+			Name:     fields[0],
+			Endpoint: fields[1],
+		}
+
+		if len(fields) > 2 {
+			for _, s := range strings.Split(fields[2], ",") {
+				id, err := strconv.ParseUint(s, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid response: %s", item)
+				}
+				info.Blocked = append(info.Blocked, uint32(id))
+			}
+		}
+
+		ret = append(ret, info)
+	}
+
+	return ret, nil
+}
+
+// do sends a request and returns the items of the response.
+func (c *Client) do(req string) ([]string, error) {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	c.seq++
+	seq := strconv.FormatUint(uint64(c.seq), 10)
+
+	c.mutex.Lock()
+	c.pending = seq
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.pending = ""
+		c.mutex.Unlock()
+	}()
+
+	tun := newTunnel(c.conf.SystemId, c.conf.ComponentId, c.conf.PayloadType, seq+" "+req)
+
+	var items []string
+
+	for i := 0; i <= c.conf.MaxRetransmissions; i++ {
+		c.conf.Node.WriteMessageAll(tun)
+
+		// the manager answers retransmissions with all the responses,
+		// therefore items are collected again
+		items = nil
+
+		timer := time.NewTimer(c.conf.Timeout)
+
+	outer:
+		for {
+			select {
+			case r := <-c.responses:
+				// responses of previous requests may still be queued
+				if r.seq != seq {
+					continue
+				}
+
+				res := r.line
+				switch {
+				case res == "ok":
+					timer.Stop()
+					return items, nil
+
+				case strings.HasPrefix(res, "error "):
+					timer.Stop()
+					return nil, fmt.Errorf("%s", strings.TrimPrefix(res, "error "))
+
+				case strings.HasPrefix(res, "item "):
+					items = append(items, strings.TrimPrefix(res, "item "))
+				}
+
+			case <-timer.C:
+				break outer
+			}
+		}
+	}
+
+	return nil, ErrTimeout
+}
+
+func (c *Client) onEventFrame(evt *gomavlib.EventFrame) {
+	tun, ok := evt.Message().(*common.MessageTunnel)
+	if !ok || tun.PayloadType != c.conf.PayloadType ||
+		evt.SystemId() != c.conf.SystemId || evt.ComponentId() != c.conf.ComponentId {
+		return
+	}
+
+	nconf := c.conf.Node.Conf()
+	if tun.TargetSystem != nconf.OutSystemId || tun.TargetComponent != nconf.OutComponentId {
+		return
+	}
+
+	payload := tunnelPayload(tun)
+	i := strings.Index(payload, " ")
+	if i < 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	pending := c.pending
+	c.mutex.Unlock()
+
+	if payload[:i] != pending {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case c.responses <- response{pending, payload[i+1:]}:
+	default:
+	}
+}
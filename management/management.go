@@ -0,0 +1,480 @@
+// Package management implements a management channel that allows
+// authorized ground stations to add and remove endpoints of a running node,
+// and to choose which messages are forwarded to each endpoint, in order to
+// reconfigure headless gateways deployed in the field.
+//
+// Requests and responses are carried by TUNNEL messages with a private
+// payload type, that must be chosen by the user among the types that are not
+// registered in MAV_TUNNEL_PAYLOAD_TYPE, and must be the same on the manager
+// and on clients. The payload is a line of text. Available requests are:
+//
+//   <seq> add <name> <endpoint>
+//   <seq> remove <name>
+//   <seq> list
+//   <seq> block <name> <message id>
+//   <seq> unblock <name> <message id>
+//
+// where seq is a number chosen by the client, that is repeated in responses.
+// Requests are answered with "<seq> ok" or "<seq> error <reason>". A list
+// request is answered with a line "<seq> item <name> <endpoint> <blocked ids>"
+// for each endpoint, followed by "<seq> ok". A request that is received
+// again with the same seq is answered again without being executed.
+//
+// Endpoints are described in the format type:address, see ParseEndpoint.
+//
+// The node to which the manager is attached must use a dialect that contains
+// the common messages. Since requests can change the network layout, frames
+// should be signed, by setting NodeConf.InKey.
+package management
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+)
+
+const (
+	requestQueueSize = 16
+	payloadSize      = 128
+)
+
+// ParseEndpoint converts the description of an endpoint into an endpoint
+// configuration. Supported descriptions are:
+//   udps:listen_ip:port           (EndpointUdpServer)
+//   udpc:dest_ip:port             (EndpointUdpClient)
+//   udpb:broadcast_ip:port        (EndpointUdpBroadcast)
+//   tcps:listen_ip:port           (EndpointTcpServer)
+//   tcpc:dest_ip:port             (EndpointTcpClient)
+//   serial:port:baudrate          (EndpointSerial)
+func ParseEndpoint(desc string) (gomavlib.EndpointConf, error) {
+	i := strings.Index(desc, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid endpoint: %s", desc)
+	}
+
+	typ, address := desc[:i], desc[i+1:]
+	if address == "" {
+		return nil, fmt.Errorf("invalid endpoint: %s", desc)
+	}
+
+	switch typ {
+	case "udps":
+		return gomavlib.EndpointUdpServer{Address: address}, nil
+
+	case "udpc":
+		return gomavlib.EndpointUdpClient{Address: address}, nil
+
+	case "udpb":
+		return gomavlib.EndpointUdpBroadcast{BroadcastAddress: address}, nil
+
+	case "tcps":
+		return gomavlib.EndpointTcpServer{Address: address}, nil
+
+	case "tcpc":
+		return gomavlib.EndpointTcpClient{Address: address}, nil
+
+	case "serial":
+		return gomavlib.EndpointSerial{Address: address}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported endpoint type: %s", typ)
+}
+
+// Conf allows to configure a Manager.
+type Conf struct {
+	// the node whose endpoints are managed.
+	Node *gomavlib.Node
+
+	// a function that returns true when the sender of a request is
+	// allowed to manage the node, i.e. by checking its system id or channel.
+	Authorize func(evt *gomavlib.EventFrame) bool
+
+	// the payload type of TUNNEL messages.
+	PayloadType common.MAV_TUNNEL_PAYLOAD_TYPE
+
+	// (optional) existing endpoints that can be listed, removed and
+	// filtered, by name.
+	Endpoints map[string]gomavlib.Endpoint
+
+	// (optional) a function that converts the description of an endpoint
+	// into an endpoint configuration, i.e. to support additional endpoints.
+	// It defaults to ParseEndpoint.
+	ParseEndpoint func(desc string) (gomavlib.EndpointConf, error)
+}
+
+type entry struct {
+	endpoint gomavlib.Endpoint
+	desc     string
+	blocked  map[uint32]struct{}
+}
+
+type sourceKey struct {
+	systemId    byte
+	componentId byte
+}
+
+type lastRequest struct {
+	seq       string
+	responses []string
+}
+
+type request struct {
+	evt     *gomavlib.EventFrame
+	payload string
+}
+
+// Manager executes management requests received by a node.
+type Manager struct {
+	conf          Conf
+	removeHandler func()
+
+	mutex   sync.Mutex
+	entries map[string]*entry
+
+	// accessed by run() only
+	last map[sourceKey]*lastRequest
+
+	requests  chan request
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Manager. See Conf for the options.
+func New(conf Conf) (*Manager, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Authorize == nil {
+		return nil, fmt.Errorf("Authorize not provided")
+	}
+
+	if conf.PayloadType == 0 {
+		return nil, fmt.Errorf("PayloadType not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(&common.MessageTunnel{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ParseEndpoint == nil {
+		conf.ParseEndpoint = ParseEndpoint
+	}
+
+	m := &Manager{
+		conf:      conf,
+		entries:   make(map[string]*entry),
+		last:      make(map[sourceKey]*lastRequest),
+		requests:  make(chan request, requestQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	for name, e := range conf.Endpoints {
+		if !validName(name) {
+			return nil, fmt.Errorf("invalid endpoint name: '%s'", name)
+		}
+
+		m.entries[name] = &entry{
+			endpoint: e,
+			desc:     strings.TrimPrefix(fmt.Sprintf("%T", e.Conf()), "gomavlib."),
+			blocked:  make(map[uint32]struct{}),
+		}
+	}
+
+	m.removeHandler = conf.Node.AddFrameHandler(m.onEventFrame)
+
+	go m.run()
+
+	return m, nil
+}
+
+// Close stops the manager. It must be called before closing the node.
+func (m *Manager) Close() {
+	m.removeHandler()
+	close(m.terminate)
+	<-m.done
+}
+
+// Endpoint returns the endpoint with the given name.
+func (m *Manager) Endpoint(name string) (gomavlib.Endpoint, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, false
+	}
+	return e.endpoint, true
+}
+
+// Allowed returns whether a frame can be forwarded to a channel, i.e.
+// whether its message has not been blocked for the endpoint of the channel.
+// It allows routers to apply the filters set by ground stations.
+func (m *Manager) Allowed(ch *gomavlib.Channel, f frame.Frame) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, e := range m.entries {
+		if e.endpoint == ch.Endpoint {
+			_, blocked := e.blocked[f.GetMessage().GetId()]
+			return !blocked
+		}
+	}
+	return true
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n")
+}
+
+func (m *Manager) onEventFrame(evt *gomavlib.EventFrame) {
+	tun, ok := evt.Message().(*common.MessageTunnel)
+	if !ok || tun.PayloadType != m.conf.PayloadType {
+		return
+	}
+
+	nconf := m.conf.Node.Conf()
+	if tun.TargetSystem != nconf.OutSystemId ||
+		(tun.TargetComponent != 0 && tun.TargetComponent != nconf.OutComponentId) {
+		return
+	}
+
+	if !m.conf.Authorize(evt) {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case m.requests <- request{evt, tunnelPayload(tun)}:
+	default:
+	}
+}
+
+func (m *Manager) run() {
+	defer close(m.done)
+
+	for {
+		select {
+		case req := <-m.requests:
+			m.process(req)
+
+		case <-m.terminate:
+			return
+		}
+	}
+}
+
+func (m *Manager) process(req request) {
+	fields := strings.Fields(req.payload)
+	if len(fields) == 0 {
+		return
+	}
+
+	seq := fields[0]
+	key := sourceKey{req.evt.SystemId(), req.evt.ComponentId()}
+
+	// retransmission of a request whose responses have been lost
+	if last, ok := m.last[key]; ok && last.seq == seq {
+		m.reply(req.evt, last.responses)
+		return
+	}
+
+	var responses []string
+	for _, line := range m.execute(fields[1:]) {
+		responses = append(responses, seq+" "+line)
+	}
+
+	m.last[key] = &lastRequest{seq, responses}
+	m.reply(req.evt, responses)
+}
+
+func (m *Manager) execute(args []string) []string {
+	if len(args) == 0 {
+		return []string{"error missing request"}
+	}
+
+	var err error
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			return []string{"error usage: add <name> <endpoint>"}
+		}
+		err = m.add(args[1], args[2])
+
+	case "remove":
+		if len(args) != 2 {
+			return []string{"error usage: remove <name>"}
+		}
+		err = m.remove(args[1])
+
+	case "list":
+		return append(m.list(), "ok")
+
+	case "block", "unblock":
+		if len(args) != 3 {
+			return []string{"error usage: " + args[0] + " <name> <message id>"}
+		}
+
+		var id uint64
+		id, err = strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return []string{"error invalid message id"}
+		}
+		err = m.setBlocked(args[1], uint32(id), args[0] == "block")
+
+	default:
+		return []string{"error unknown request: " + args[0]}
+	}
+
+	if err != nil {
+		return []string{"error " + err.Error()}
+	}
+	return []string{"ok"}
+}
+
+func (m *Manager) add(name string, desc string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid name")
+	}
+
+	m.mutex.Lock()
+	_, exists := m.entries[name]
+	m.mutex.Unlock()
+
+	if exists {
+		return fmt.Errorf("endpoint already exists")
+	}
+
+	conf, err := m.conf.ParseEndpoint(desc)
+	if err != nil {
+		return err
+	}
+
+	e, err := m.conf.Node.AddEndpoint(conf)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries[name] = &entry{
+		endpoint: e,
+		desc:     desc,
+		blocked:  make(map[uint32]struct{}),
+	}
+	return nil
+}
+
+func (m *Manager) remove(name string) error {
+	m.mutex.Lock()
+	e, ok := m.entries[name]
+	m.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("endpoint not found")
+	}
+
+	err := m.conf.Node.RemoveEndpoint(e.endpoint)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.entries, name)
+	return nil
+}
+
+func (m *Manager) list() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var names []string
+	for name := range m.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ret []string
+	for _, name := range names {
+		e := m.entries[name]
+
+		var ids []uint32
+		for id := range e.blocked {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		line := "item " + name + " " + e.desc
+		if len(ids) > 0 {
+			var strs []string
+			for _, id := range ids {
+				strs = append(strs, strconv.FormatUint(uint64(id), 10))
+			}
+			line += " " + strings.Join(strs, ",")
+		}
+
+		ret = append(ret, line)
+	}
+	return ret
+}
+
+func (m *Manager) setBlocked(name string, id uint32, blocked bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.entries[name]
+	if !ok {
+		return fmt.Errorf("endpoint not found")
+	}
+
+	if blocked {
+		e.blocked[id] = struct{}{}
+	} else {
+		delete(e.blocked, id)
+	}
+	return nil
+}
+
+func (m *Manager) reply(evt *gomavlib.EventFrame, responses []string) {
+	for _, res := range responses {
+		m.conf.Node.WriteMessageTo(evt.Channel, newTunnel(
+			evt.SystemId(), evt.ComponentId(), m.conf.PayloadType, res))
+	}
+}
+
+func newTunnel(systemId byte, componentId byte,
+	payloadType common.MAV_TUNNEL_PAYLOAD_TYPE, payload string) *common.MessageTunnel {
+	tun := &common.MessageTunnel{
+		TargetSystem:    systemId,
+		TargetComponent: componentId,
+		PayloadType:     payloadType,
+	}
+
+	// responses that don't fit are truncated
+	n := copy(tun.Payload[:], payload)
+	tun.PayloadLength = uint8(n)
+	return tun
+}
+
+func tunnelPayload(tun *common.MessageTunnel) string {
+	l := int(tun.PayloadLength)
+	if l > payloadSize {
+		l = payloadSize
+	}
+	return string(tun.Payload[:l])
+}
@@ -0,0 +1,230 @@
+package management
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	for _, ca := range []struct {
+		desc string
+		conf gomavlib.EndpointConf
+	}{
+		{"udps:0.0.0.0:14550", gomavlib.EndpointUdpServer{Address: "0.0.0.0:14550"}},
+		{"udpc:1.2.3.4:14550", gomavlib.EndpointUdpClient{Address: "1.2.3.4:14550"}},
+		{"udpb:192.168.1.255:14550", gomavlib.EndpointUdpBroadcast{BroadcastAddress: "192.168.1.255:14550"}},
+		{"tcps:0.0.0.0:5760", gomavlib.EndpointTcpServer{Address: "0.0.0.0:5760"}},
+		{"tcpc:1.2.3.4:5760", gomavlib.EndpointTcpClient{Address: "1.2.3.4:5760"}},
+		{"serial:/dev/ttyUSB0:57600", gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"}},
+	} {
+		conf, err := ParseEndpoint(ca.desc)
+		require.NoError(t, err)
+		require.Equal(t, ca.conf, conf)
+	}
+
+	for _, desc := range []string{"", "udps", "udps:", "ftp:1.2.3.4:21"} {
+		_, err := ParseEndpoint(desc)
+		require.Error(t, err)
+	}
+}
+
+const testPayloadType common.MAV_TUNNEL_PAYLOAD_TYPE = 32900
+
+func testNodes(t *testing.T) (*gomavlib.Node, *gomavlib.Node) {
+	c1, c2 := net.Pipe()
+
+	gateway, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      10,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: c1}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range gateway.Events() {
+		}
+	}()
+
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      255,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: c2}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	return gateway, gcs
+}
+
+func TestNewErrors(t *testing.T) {
+	gateway, gcs := testNodes(t)
+	defer gateway.Close()
+	defer gcs.Close()
+
+	_, err := New(Conf{
+		Node: gateway,
+		Authorize: func(evt *gomavlib.EventFrame) bool {
+			return true
+		},
+	})
+	require.EqualError(t, err, "PayloadType not provided")
+
+	_, err = NewClient(ClientConf{
+		Node:     gcs,
+		SystemId: 10,
+	})
+	require.EqualError(t, err, "PayloadType not provided")
+}
+
+func TestManager(t *testing.T) {
+	gateway, gcs := testNodes(t)
+	defer gateway.Close()
+	defer gcs.Close()
+
+	m, err := New(Conf{
+		Node:        gateway,
+		PayloadType: testPayloadType,
+		Authorize: func(evt *gomavlib.EventFrame) bool {
+			return evt.SystemId() == 255
+		},
+		Endpoints: map[string]gomavlib.Endpoint{
+			"gcs": gateway.Endpoints()[0],
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	c, err := NewClient(ClientConf{
+		Node:        gcs,
+		SystemId:    10,
+		PayloadType: testPayloadType,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Add("video", "udps:127.0.0.1:5750")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(gateway.Endpoints()))
+
+	err = c.Add("video", "udps:127.0.0.1:5751")
+	require.EqualError(t, err, "endpoint already exists")
+
+	err = c.Add("other", "ftp:127.0.0.1:21")
+	require.EqualError(t, err, "unsupported endpoint type: ftp")
+
+	err = c.Block("video", 0)
+	require.NoError(t, err)
+	err = c.Block("video", 33)
+	require.NoError(t, err)
+
+	list, err := c.List()
+	require.NoError(t, err)
+	require.Equal(t, []EndpointInfo{
+		{Name: "gcs", Endpoint: "EndpointCustom"},
+		{Name: "video", Endpoint: "udps:127.0.0.1:5750", Blocked: []uint32{0, 33}},
+	}, list)
+
+	e, ok := m.Endpoint("video")
+	require.True(t, ok)
+
+	var ch *gomavlib.Channel
+	for _, cur := range gateway.Channels() {
+		if cur.Endpoint == gateway.Endpoints()[0] {
+			ch = cur
+		}
+	}
+	require.NotNil(t, ch)
+
+	hb := &frame.V2Frame{Message: &common.MessageHeartbeat{}}
+	require.True(t, m.Allowed(ch, hb))
+
+	err = c.Unblock("video", 0)
+	require.NoError(t, err)
+
+	err = c.Remove("video")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(gateway.Endpoints()))
+	require.NotEqual(t, e, gateway.Endpoints()[0])
+
+	err = c.Remove("video")
+	require.EqualError(t, err, "endpoint not found")
+}
+
+func TestManagerBlocked(t *testing.T) {
+	gateway, gcs := testNodes(t)
+	defer gateway.Close()
+	defer gcs.Close()
+
+	m, err := New(Conf{
+		Node:        gateway,
+		PayloadType: testPayloadType,
+		Authorize: func(evt *gomavlib.EventFrame) bool {
+			return true
+		},
+		Endpoints: map[string]gomavlib.Endpoint{
+			"gcs": gateway.Endpoints()[0],
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	c, err := NewClient(ClientConf{
+		Node:        gcs,
+		SystemId:    10,
+		PayloadType: testPayloadType,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Block("gcs", 0)
+	require.NoError(t, err)
+
+	ch := gateway.Channels()[0]
+	require.False(t, m.Allowed(ch, &frame.V2Frame{Message: &common.MessageHeartbeat{}}))
+	require.True(t, m.Allowed(ch, &frame.V2Frame{Message: &common.MessageAttitude{}}))
+}
+
+func TestManagerUnauthorized(t *testing.T) {
+	gateway, gcs := testNodes(t)
+	defer gateway.Close()
+	defer gcs.Close()
+
+	m, err := New(Conf{
+		Node:        gateway,
+		PayloadType: testPayloadType,
+		Authorize: func(evt *gomavlib.EventFrame) bool {
+			return evt.SystemId() == 254
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	c, err := NewClient(ClientConf{
+		Node:               gcs,
+		SystemId:           10,
+		PayloadType:        testPayloadType,
+		Timeout:            100 * time.Millisecond,
+		MaxRetransmissions: 1,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Add("video", "udps:127.0.0.1:5752")
+	require.Equal(t, ErrTimeout, err)
+	require.Equal(t, 1, len(gateway.Endpoints()))
+}
@@ -0,0 +1,197 @@
+// Package manualcontrol implements a sender that streams MANUAL_CONTROL
+// messages at a fixed rate, in order to pilot a vehicle with a joystick.
+//
+// When the application stops updating the joystick state, the sender
+// automatically switches to neutral values and then stops streaming, in order
+// to trigger the failsafe of the vehicle instead of keeping the last input.
+package manualcontrol
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// State is the state of a joystick. Axes are normalized in the range
+// [-1000, 1000], while the throttle (Z) is usually in the range [0, 1000].
+type State struct {
+	// pitch axis.
+	X int16
+	// roll axis.
+	Y int16
+	// thrust axis.
+	Z int16
+	// yaw axis.
+	R int16
+	// bitfield of pressed buttons.
+	Buttons uint16
+}
+
+// Conf allows to configure a Sender.
+type Conf struct {
+	// the node used to send messages.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	TargetSystem byte
+
+	// (optional) the rate at which messages are sent.
+	// It defaults to 10Hz.
+	Rate float64
+
+	// (optional) the duration after which, if the state has not been updated,
+	// neutral values are sent.
+	// It defaults to 500ms.
+	Timeout time.Duration
+
+	// (optional) the duration after which, if the state has not been updated,
+	// streaming stops.
+	// It defaults to 1s.
+	StopTimeout time.Duration
+
+	// (optional) the values that are sent when the state is not updated.
+	// It defaults to centered axes and a throttle of 500.
+	Neutral *State
+
+	// (optional) called when streaming stops because the state
+	// has not been updated.
+	OnStop func()
+}
+
+// Sender streams MANUAL_CONTROL messages.
+type Sender struct {
+	conf Conf
+
+	mutex      sync.Mutex
+	state      State
+	lastUpdate time.Time
+	streaming  bool
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Sender. See Conf for the options.
+// Streaming starts with the first call to Update().
+func New(conf Conf) (*Sender, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(&common.MessageManualControl{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Rate == 0 {
+		conf.Rate = 10
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 500 * time.Millisecond
+	}
+	if conf.StopTimeout == 0 {
+		conf.StopTimeout = 1 * time.Second
+	}
+	if conf.StopTimeout < conf.Timeout {
+		return nil, fmt.Errorf("StopTimeout must be greater or equal than Timeout")
+	}
+	if conf.Neutral == nil {
+		conf.Neutral = &State{Z: 500}
+	}
+
+	s := &Sender{
+		conf:      conf,
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the sender. It must be called before closing the node.
+func (s *Sender) Close() {
+	close(s.terminate)
+	<-s.done
+}
+
+// Update sets the joystick state and starts streaming if it was stopped.
+func (s *Sender) Update(st State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state = st
+	s.lastUpdate = time.Now()
+	s.streaming = true
+}
+
+// Streaming returns whether the sender is currently streaming.
+func (s *Sender) Streaming() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streaming
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.conf.Rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			st, ok, stopped := s.next()
+			if stopped && s.conf.OnStop != nil {
+				s.conf.OnStop()
+			}
+			if !ok {
+				continue
+			}
+
+			s.conf.Node.WriteMessageAll(&common.MessageManualControl{
+				Target:  s.conf.TargetSystem,
+				X:       st.X,
+				Y:       st.Y,
+				Z:       st.Z,
+				R:       st.R,
+				Buttons: st.Buttons,
+			})
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+// next returns the state that must be sent, whether it must be sent and
+// whether streaming has just stopped.
+func (s *Sender) next() (State, bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.streaming {
+		return State{}, false, false
+	}
+
+	elapsed := time.Since(s.lastUpdate)
+
+	if elapsed >= s.conf.StopTimeout {
+		s.streaming = false
+		return State{}, false, true
+	}
+
+	if elapsed >= s.conf.Timeout {
+		return *s.conf.Neutral, true, false
+	}
+
+	return s.state, true, false
+}
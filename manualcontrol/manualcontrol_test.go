@@ -0,0 +1,94 @@
+package manualcontrol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestSender(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5630"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5630"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	stopped := make(chan struct{})
+
+	s, err := New(Conf{
+		Node:         node2,
+		TargetSystem: 1,
+		Rate:         50,
+		Timeout:      200 * time.Millisecond,
+		StopTimeout:  400 * time.Millisecond,
+		OnStop: func() {
+			close(stopped)
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.Update(State{X: 100, Y: -100, Z: 800, R: 10, Buttons: 3})
+
+	// the state is streamed, then neutral values are sent
+	gotState := false
+	gotNeutral := false
+	for evt := range node1.Events() {
+		ee, ok := evt.(*gomavlib.EventFrame)
+		if !ok {
+			continue
+		}
+
+		m, ok := ee.Message().(*common.MessageManualControl)
+		if !ok {
+			continue
+		}
+		require.Equal(t, uint8(1), m.Target)
+
+		if !gotState {
+			require.Equal(t, &common.MessageManualControl{
+				Target: 1, X: 100, Y: -100, Z: 800, R: 10, Buttons: 3,
+			}, m)
+			gotState = true
+		} else if m.Z == 500 {
+			require.Equal(t, &common.MessageManualControl{Target: 1, Z: 500}, m)
+			gotNeutral = true
+			break
+		}
+	}
+	require.Equal(t, true, gotNeutral)
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnStop not called")
+	}
+	require.Equal(t, false, s.Streaming())
+}
@@ -0,0 +1,403 @@
+package mission
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const messageQueueSize = 16
+
+// ErrTimeout is returned when the vehicle stops answering during a transfer.
+var ErrTimeout = fmt.Errorf("mission transfer timed out")
+
+// AckError is returned when a transfer is rejected by the vehicle.
+type AckError struct {
+	// the result sent by the vehicle.
+	Result common.MAV_MISSION_RESULT
+}
+
+// Error implements the error interface.
+func (e *AckError) Error() string {
+	return fmt.Sprintf("mission transfer failed: %s", e.Result)
+}
+
+// ClientConf allows to configure a Client.
+type ClientConf struct {
+	// the node with which missions are transferred.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the type of the transferred missions, i.e.
+	// MAV_MISSION_TYPE_FENCE or MAV_MISSION_TYPE_RALLY.
+	// It defaults to MAV_MISSION_TYPE_MISSION.
+	MissionType common.MAV_MISSION_TYPE
+
+	// (optional) the time after which a message that has not been answered
+	// is sent again. It defaults to 1.5 seconds.
+	Timeout time.Duration
+
+	// (optional) the maximum number of retransmissions of a message.
+	// It defaults to 5.
+	MaxRetransmissions int
+}
+
+// Client uploads and downloads missions to and from a vehicle, with the
+// mission protocol: missions are transferred one item at a time, through
+// MISSION_COUNT, MISSION_REQUEST_INT, MISSION_ITEM_INT and MISSION_ACK
+// messages, and lost messages are sent again.
+type Client struct {
+	conf          ClientConf
+	removeHandler func()
+
+	// serializes transfers
+	transferMutex sync.Mutex
+
+	mutex    sync.Mutex
+	active   bool
+	messages chan msg.Message
+
+	terminate chan struct{}
+}
+
+// NewClient allocates a Client. See ClientConf for the options.
+func NewClient(conf ClientConf) (*Client, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageMissionCount{},
+		&common.MessageMissionRequestList{},
+		&common.MessageMissionRequestInt{},
+		&common.MessageMissionItemInt{},
+		&common.MessageMissionAck{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 1500 * time.Millisecond
+	}
+	if conf.MaxRetransmissions == 0 {
+		conf.MaxRetransmissions = 5
+	}
+
+	c := &Client{
+		conf:      conf,
+		messages:  make(chan msg.Message, messageQueueSize),
+		terminate: make(chan struct{}),
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	return c, nil
+}
+
+// Close stops the client. Pending transfers return an error.
+// It must be called before closing the node.
+func (c *Client) Close() {
+	c.removeHandler()
+	close(c.terminate)
+}
+
+func (c *Client) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != c.conf.SystemId || evt.ComponentId() != c.conf.ComponentId {
+		return
+	}
+
+	var m msg.Message
+	var target byte
+	var missionType common.MAV_MISSION_TYPE
+
+	switch evt.Message().GetId() {
+	case (&common.MessageMissionRequestInt{}).GetId():
+		var req common.MessageMissionRequestInt
+		if msg.Convert(&req, evt.Message()) != nil {
+			return
+		}
+		m, target, missionType = &req, req.TargetSystem, req.MissionType
+
+	case (&common.MessageMissionRequest{}).GetId():
+		// vehicles that still use the deprecated MISSION_REQUEST are answered
+		// with MISSION_ITEM_INT anyway
+		var req common.MessageMissionRequest
+		if msg.Convert(&req, evt.Message()) != nil {
+			return
+		}
+		m = &common.MessageMissionRequestInt{
+			TargetSystem:    req.TargetSystem,
+			TargetComponent: req.TargetComponent,
+			Seq:             req.Seq,
+			MissionType:     req.MissionType,
+		}
+		target, missionType = req.TargetSystem, req.MissionType
+
+	case (&common.MessageMissionCount{}).GetId():
+		var count common.MessageMissionCount
+		if msg.Convert(&count, evt.Message()) != nil {
+			return
+		}
+		m, target, missionType = &count, count.TargetSystem, count.MissionType
+
+	case (&common.MessageMissionItemInt{}).GetId():
+		var item common.MessageMissionItemInt
+		if msg.Convert(&item, evt.Message()) != nil {
+			return
+		}
+		m, target, missionType = &item, item.TargetSystem, item.MissionType
+
+	case (&common.MessageMissionAck{}).GetId():
+		var ack common.MessageMissionAck
+		if msg.Convert(&ack, evt.Message()) != nil {
+			return
+		}
+		m, target, missionType = &ack, ack.TargetSystem, ack.MissionType
+
+	default:
+		return
+	}
+
+	if target != c.conf.Node.Conf().OutSystemId || missionType != c.conf.MissionType {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.active {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case c.messages <- m:
+	default:
+	}
+}
+
+func (c *Client) startTransfer() {
+	c.transferMutex.Lock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.active = true
+
+	// discard the messages of previous transfers
+	for len(c.messages) > 0 {
+		<-c.messages
+	}
+}
+
+func (c *Client) stopTransfer() {
+	c.mutex.Lock()
+	c.active = false
+	c.mutex.Unlock()
+
+	c.transferMutex.Unlock()
+}
+
+// exchange writes a message and passes the received messages to handle,
+// until it returns true or an error. The message is sent again when no
+// message is accepted within the timeout.
+func (c *Client) exchange(ctx context.Context, out msg.Message,
+	handle func(msg.Message) (bool, error)) error {
+	c.conf.Node.WriteMessageAll(out)
+
+	retransmissions := 0
+
+	timer := time.NewTimer(c.conf.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case m := <-c.messages:
+			done, err := handle(m)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+
+		case <-timer.C:
+			if retransmissions >= c.conf.MaxRetransmissions {
+				return ErrTimeout
+			}
+
+			retransmissions++
+			c.conf.Node.WriteMessageAll(out)
+			timer.Reset(c.conf.Timeout)
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-c.terminate:
+			return fmt.Errorf("terminated")
+		}
+	}
+}
+
+func (c *Client) writeAck(result common.MAV_MISSION_RESULT) {
+	c.conf.Node.WriteMessageAll(&common.MessageMissionAck{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		Type:            result,
+		MissionType:     c.conf.MissionType,
+	})
+}
+
+// UploadMission uploads a mission to the vehicle, replacing the existing one.
+// Items are sent in the given order, with their sequence number, target and
+// mission type filled. Use Validate to check a mission before uploading it.
+// If the vehicle rejects the mission, an *AckError is returned.
+func (c *Client) UploadMission(ctx context.Context, items []*common.MessageMissionItemInt) error {
+	if len(items) > 0xFFFF {
+		return fmt.Errorf("mission contains too many items")
+	}
+
+	c.startTransfer()
+	defer c.stopTransfer()
+
+	var out msg.Message = &common.MessageMissionCount{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		Count:           uint16(len(items)),
+		MissionType:     c.conf.MissionType,
+	}
+	lastRequested := len(items) == 0
+	finished := false
+
+	for !finished {
+		var next msg.Message
+
+		err := c.exchange(ctx, out, func(m msg.Message) (bool, error) {
+			switch tm := m.(type) {
+			case *common.MessageMissionRequestInt:
+				if int(tm.Seq) >= len(items) {
+					return false, nil
+				}
+
+				item := *items[tm.Seq]
+				item.TargetSystem = c.conf.SystemId
+				item.TargetComponent = c.conf.ComponentId
+				item.Seq = tm.Seq
+				item.MissionType = c.conf.MissionType
+				next = &item
+
+				if int(tm.Seq) == len(items)-1 {
+					lastRequested = true
+				}
+				return true, nil
+
+			case *common.MessageMissionAck:
+				if tm.Type != common.MAV_MISSION_ACCEPTED {
+					return false, &AckError{tm.Type}
+				}
+
+				// an acceptance is valid only after the last item has been requested
+				if lastRequested {
+					finished = true
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			if _, ok := err.(*AckError); !ok {
+				c.writeAck(common.MAV_MISSION_OPERATION_CANCELLED)
+			}
+			return err
+		}
+
+		out = next
+	}
+
+	return nil
+}
+
+// DownloadMission downloads the mission of the vehicle.
+// If the vehicle rejects the transfer, an *AckError is returned.
+func (c *Client) DownloadMission(ctx context.Context) ([]*common.MessageMissionItemInt, error) {
+	c.startTransfer()
+	defer c.stopTransfer()
+
+	cancel := func(err error) ([]*common.MessageMissionItemInt, error) {
+		if _, ok := err.(*AckError); !ok {
+			c.writeAck(common.MAV_MISSION_OPERATION_CANCELLED)
+		}
+		return nil, err
+	}
+
+	handleAck := func(m msg.Message) error {
+		if ack, ok := m.(*common.MessageMissionAck); ok && ack.Type != common.MAV_MISSION_ACCEPTED {
+			return &AckError{ack.Type}
+		}
+		return nil
+	}
+
+	var count int
+
+	err := c.exchange(ctx, &common.MessageMissionRequestList{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		MissionType:     c.conf.MissionType,
+	}, func(m msg.Message) (bool, error) {
+		if tm, ok := m.(*common.MessageMissionCount); ok {
+			count = int(tm.Count)
+			return true, nil
+		}
+		return false, handleAck(m)
+	})
+	if err != nil {
+		return cancel(err)
+	}
+
+	items := make([]*common.MessageMissionItemInt, count)
+
+	for i := 0; i < count; i++ {
+		err := c.exchange(ctx, &common.MessageMissionRequestInt{
+			TargetSystem:    c.conf.SystemId,
+			TargetComponent: c.conf.ComponentId,
+			Seq:             uint16(i),
+			MissionType:     c.conf.MissionType,
+		}, func(m msg.Message) (bool, error) {
+			// items of previous requests may still be queued
+			if tm, ok := m.(*common.MessageMissionItemInt); ok && int(tm.Seq) == i {
+				items[i] = tm
+				return true, nil
+			}
+			return false, handleAck(m)
+		})
+		if err != nil {
+			return cancel(err)
+		}
+	}
+
+	c.writeAck(common.MAV_MISSION_ACCEPTED)
+
+	return items, nil
+}
@@ -0,0 +1,263 @@
+package mission
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// testVehicle implements the vehicle side of the mission protocol.
+type testVehicle struct {
+	node *gomavlib.Node
+
+	mutex sync.Mutex
+	// the number of received messages to discard, to simulate losses
+	drop int
+	// the result sent in place of requests for items
+	reject common.MAV_MISSION_RESULT
+	// disables answers
+	mute    bool
+	items   []*common.MessageMissionItemInt
+	pending []*common.MessageMissionItemInt
+	acks    []common.MAV_MISSION_RESULT
+}
+
+func newTestVehicle(t *testing.T) (*gomavlib.Node, *testVehicle) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{node: node}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		v.onMessage(fr.Message())
+	})
+
+	return gcs, v
+}
+
+func (v *testVehicle) onMessage(m msg.Message) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.drop > 0 {
+		v.drop--
+		return
+	}
+
+	if ack, ok := m.(*common.MessageMissionAck); ok {
+		v.acks = append(v.acks, ack.Type)
+		return
+	}
+
+	if v.mute {
+		return
+	}
+
+	ack := func(result common.MAV_MISSION_RESULT) {
+		v.node.WriteMessageAll(&common.MessageMissionAck{
+			TargetSystem:    255,
+			TargetComponent: 1,
+			Type:            result,
+		})
+	}
+
+	request := func(seq int) {
+		v.node.WriteMessageAll(&common.MessageMissionRequestInt{
+			TargetSystem:    255,
+			TargetComponent: 1,
+			Seq:             uint16(seq),
+		})
+	}
+
+	switch tm := m.(type) {
+	case *common.MessageMissionCount:
+		if v.reject != 0 {
+			ack(v.reject)
+			return
+		}
+
+		v.pending = make([]*common.MessageMissionItemInt, tm.Count)
+		if tm.Count == 0 {
+			v.items = nil
+			ack(common.MAV_MISSION_ACCEPTED)
+			return
+		}
+		request(0)
+
+	case *common.MessageMissionItemInt:
+		if int(tm.Seq) >= len(v.pending) {
+			return
+		}
+
+		v.pending[tm.Seq] = tm
+		if int(tm.Seq) < len(v.pending)-1 {
+			request(int(tm.Seq) + 1)
+			return
+		}
+
+		v.items = v.pending
+		ack(common.MAV_MISSION_ACCEPTED)
+
+	case *common.MessageMissionRequestList:
+		if v.reject != 0 {
+			ack(v.reject)
+			return
+		}
+
+		v.node.WriteMessageAll(&common.MessageMissionCount{
+			TargetSystem:    255,
+			TargetComponent: 1,
+			Count:           uint16(len(v.items)),
+		})
+
+	case *common.MessageMissionRequestInt:
+		if int(tm.Seq) >= len(v.items) {
+			return
+		}
+
+		item := *v.items[tm.Seq]
+		item.TargetSystem = 255
+		item.TargetComponent = 1
+		v.node.WriteMessageAll(&item)
+	}
+}
+
+func (v *testVehicle) receivedAcks() []common.MAV_MISSION_RESULT {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.acks
+}
+
+func newTestClient(t *testing.T, gcs *gomavlib.Node) *Client {
+	c, err := NewClient(ClientConf{
+		Node:               gcs,
+		SystemId:           1,
+		Timeout:            100 * time.Millisecond,
+		MaxRetransmissions: 2,
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func TestNewClientErrors(t *testing.T) {
+	_, err := NewClient(ClientConf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	_, err = NewClient(ClientConf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+}
+
+func TestClientUploadDownload(t *testing.T) {
+	for _, ca := range []string{"standard", "losses"} {
+		t.Run(ca, func(t *testing.T) {
+			gcs, v := newTestVehicle(t)
+			defer gcs.Close()
+			defer v.node.Close()
+
+			c := newTestClient(t, gcs)
+			defer c.Close()
+
+			if ca == "losses" {
+				v.drop = 1
+			}
+
+			err := c.UploadMission(context.Background(), testMission())
+			require.NoError(t, err)
+			v.mutex.Lock()
+			require.Equal(t, 4, len(v.items))
+			v.mutex.Unlock()
+
+			if ca == "losses" {
+				v.mutex.Lock()
+				v.drop = 1
+				v.mutex.Unlock()
+			}
+
+			items, err := c.DownloadMission(context.Background())
+			require.NoError(t, err)
+
+			expected := testMission()
+			for _, item := range expected {
+				item.TargetSystem = 255
+				item.TargetComponent = 1
+			}
+			require.Equal(t, expected, items)
+
+			// the final acknowledgement is sent asynchronously
+			time.Sleep(50 * time.Millisecond)
+			require.Equal(t, []common.MAV_MISSION_RESULT{common.MAV_MISSION_ACCEPTED}, v.receivedAcks())
+		})
+	}
+}
+
+func TestClientEmpty(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs)
+	defer c.Close()
+
+	err := c.UploadMission(context.Background(), nil)
+	require.NoError(t, err)
+
+	items, err := c.DownloadMission(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, len(items))
+}
+
+func TestClientRejected(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs)
+	defer c.Close()
+
+	v.reject = common.MAV_MISSION_NO_SPACE
+
+	err := c.UploadMission(context.Background(), testMission())
+	require.Equal(t, &AckError{common.MAV_MISSION_NO_SPACE}, err)
+	require.EqualError(t, err, "mission transfer failed: MAV_MISSION_NO_SPACE")
+
+	_, err = c.DownloadMission(context.Background())
+	require.Equal(t, &AckError{common.MAV_MISSION_NO_SPACE}, err)
+}
+
+func TestClientTimeout(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs)
+	defer c.Close()
+
+	v.mute = true
+
+	err := c.UploadMission(context.Background(), testMission())
+	require.Equal(t, ErrTimeout, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.DownloadMission(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// the vehicle is notified of the cancellations
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, []common.MAV_MISSION_RESULT{
+		common.MAV_MISSION_OPERATION_CANCELLED,
+		common.MAV_MISSION_OPERATION_CANCELLED,
+	}, v.receivedAcks())
+}
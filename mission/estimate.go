@@ -0,0 +1,229 @@
+package mission
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	earthRadius = 6371000
+
+	// maximum number of items that are simulated, that bounds missions
+	// with many jumps
+	maxSimulatedItems = 100000
+)
+
+// EstimateConf allows to configure Estimate.
+type EstimateConf struct {
+	// the horizontal speed of the vehicle, in m/s.
+	// It is replaced by DO_CHANGE_SPEED commands.
+	CruiseSpeed float64
+
+	// (optional) the climb speed of the vehicle, in m/s.
+	// It defaults to 2.5.
+	ClimbSpeed float64
+
+	// (optional) the descent speed of the vehicle, in m/s.
+	// It defaults to 1.5.
+	DescentSpeed float64
+
+	// (optional) the power used while moving, in W.
+	// If zero, the energy is not estimated.
+	Power float64
+
+	// (optional) the power used while holding position, in W.
+	// It defaults to Power.
+	HoverPower float64
+}
+
+// Estimation is the result of Estimate.
+type Estimation struct {
+	// the traveled distance, in meters.
+	Distance float64
+
+	// the duration of the mission.
+	Duration time.Duration
+
+	// the used energy, in Wh.
+	Energy float64
+}
+
+type position struct {
+	global bool
+	x      float64 // latitude or meters
+	y      float64 // longitude or meters
+	z      float64
+}
+
+func itemPosition(item *common.MessageMissionItemInt) position {
+	if isGlobal(item.Frame) {
+		return position{true, float64(item.X) / 1e7, float64(item.Y) / 1e7, float64(item.Z)}
+	}
+	return position{false, float64(item.X) / 1e4, float64(item.Y) / 1e4, float64(item.Z)}
+}
+
+// horizontalDistance returns the distance between two positions, in meters.
+func horizontalDistance(a, b position) float64 {
+	if !a.global {
+		return math.Hypot(b.x-a.x, b.y-a.y)
+	}
+
+	// haversine formula
+	lat1 := a.x * math.Pi / 180
+	lat2 := b.x * math.Pi / 180
+	dlat := lat2 - lat1
+	dlon := (b.y - a.y) * math.Pi / 180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Estimate simulates a valid mission and estimates the traveled distance,
+// the duration and the used energy. The vehicle starts from the position of
+// the first item that moves it, and returns there with RETURN_TO_LAUNCH.
+// Legs are flown at constant speed, and climbs and descents happen together
+// with horizontal movements. Missions with unlimited loiters or infinite
+// jumps are rejected.
+func Estimate(items []*common.MessageMissionItemInt, conf EstimateConf) (*Estimation, error) {
+	if conf.CruiseSpeed <= 0 {
+		return nil, fmt.Errorf("CruiseSpeed must be > 0")
+	}
+	if conf.ClimbSpeed == 0 {
+		conf.ClimbSpeed = 2.5
+	}
+	if conf.DescentSpeed == 0 {
+		conf.DescentSpeed = 1.5
+	}
+	if conf.HoverPower == 0 {
+		conf.HoverPower = conf.Power
+	}
+
+	err := Validate(items)
+	if err != nil {
+		return nil, err
+	}
+
+	var est Estimation
+	var moving, holding float64 // seconds
+	speed := conf.CruiseSpeed
+	var home, cur *position
+	jumps := make(map[int]int)
+
+	moveTo := func(seq int, dest position) error {
+		if cur == nil {
+			home = &dest
+			cur = &dest
+			return nil
+		}
+
+		if dest.global != cur.global {
+			return fmt.Errorf("item %d: mission mixes global and local frames", seq)
+		}
+
+		horiz := horizontalDistance(*cur, dest)
+		vert := dest.z - cur.z
+
+		vertTime := vert / conf.ClimbSpeed
+		if vert < 0 {
+			vertTime = -vert / conf.DescentSpeed
+		}
+
+		est.Distance += math.Hypot(horiz, vert)
+		moving += math.Max(horiz/speed, vertTime)
+		cur = &dest
+		return nil
+	}
+
+	steps := 0
+	for i := 0; i < len(items); {
+		steps++
+		if steps > maxSimulatedItems {
+			return nil, fmt.Errorf("mission is too long to be simulated")
+		}
+
+		item := items[i]
+
+		switch item.Command {
+		case common.MAV_CMD_NAV_LOITER_UNLIM:
+			return nil, fmt.Errorf("item %d: mission contains an unlimited loiter", i)
+
+		case common.MAV_CMD_NAV_RETURN_TO_LAUNCH:
+			if home != nil {
+				err := moveTo(i, *home)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case common.MAV_CMD_DO_CHANGE_SPEED:
+			if item.Param2 > 0 {
+				speed = float64(item.Param2)
+			}
+
+		case common.MAV_CMD_CONDITION_DELAY:
+			holding += float64(item.Param1)
+
+		case common.MAV_CMD_NAV_DELAY:
+			if item.Param1 > 0 {
+				holding += float64(item.Param1)
+			}
+
+		case common.MAV_CMD_DO_JUMP:
+			remaining, ok := jumps[i]
+			if !ok {
+				if item.Param2 < 0 {
+					return nil, fmt.Errorf("item %d: mission contains an infinite jump", i)
+				}
+				remaining = int(item.Param2)
+			}
+			if remaining > 0 {
+				jumps[i] = remaining - 1
+				i = int(item.Param1)
+				continue
+			}
+			jumps[i] = 0
+		}
+
+		if isPositionCommand(item.Command) {
+			dest := itemPosition(item)
+
+			// a zero position means the current position
+			if (item.Command == common.MAV_CMD_NAV_TAKEOFF ||
+				item.Command == common.MAV_CMD_NAV_LAND ||
+				item.Command == common.MAV_CMD_NAV_VTOL_TAKEOFF ||
+				item.Command == common.MAV_CMD_NAV_VTOL_LAND) &&
+				item.X == 0 && item.Y == 0 && cur != nil {
+				dest.global = cur.global
+				dest.x = cur.x
+				dest.y = cur.y
+			}
+
+			err := moveTo(i, dest)
+			if err != nil {
+				return nil, err
+			}
+
+			switch item.Command {
+			case common.MAV_CMD_NAV_WAYPOINT, common.MAV_CMD_NAV_LOITER_TIME:
+				if item.Param1 > 0 {
+					holding += float64(item.Param1)
+				}
+
+			case common.MAV_CMD_NAV_LOITER_TURNS:
+				circle := 2 * math.Pi * math.Abs(float64(item.Param3))
+				d := float64(item.Param1) * circle
+				est.Distance += d
+				moving += d / speed
+			}
+		}
+
+		i++
+	}
+
+	est.Duration = time.Duration((moving + holding) * float64(time.Second))
+	est.Energy = (moving*conf.Power + holding*conf.HoverPower) / 3600
+	return &est, nil
+}
@@ -0,0 +1,40 @@
+package mission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestEstimate(t *testing.T) {
+	est, err := Estimate(testMission(), EstimateConf{
+		CruiseSpeed: 10,
+		ClimbSpeed:  2,
+		Power:       360,
+	})
+	require.NoError(t, err)
+
+	// waypoint (100m, 10s) with a 5s hold, jump to the waypoint with another
+	// 5s hold, return to launch (100m, 10s)
+	require.InDelta(t, 200, est.Distance, 0.5)
+	require.InDelta(t, float64(30*time.Second), float64(est.Duration), float64(200*time.Millisecond))
+	require.InDelta(t, 3, est.Energy, 0.05)
+}
+
+func TestEstimateError(t *testing.T) {
+	items := testMission()
+	items[2].Param2 = -1
+	_, err := Estimate(items, EstimateConf{CruiseSpeed: 10})
+	require.EqualError(t, err, "item 2: mission contains an infinite jump")
+
+	items = testMission()
+	items[1].Command = common.MAV_CMD_NAV_LOITER_UNLIM
+	_, err = Estimate(items, EstimateConf{CruiseSpeed: 10})
+	require.EqualError(t, err, "item 1: mission contains an unlimited loiter")
+
+	_, err = Estimate(testMission(), EstimateConf{})
+	require.EqualError(t, err, "CruiseSpeed must be > 0")
+}
@@ -0,0 +1,213 @@
+// Package mission implements validation and simulation of missions, that
+// allow to reject invalid plans before they are uploaded to a vehicle, a
+// client of the mission protocol, that uploads and downloads missions, and a
+// server, that receives missions from ground stations.
+//
+// Missions are sequences of MISSION_ITEM_INT messages of the common dialect.
+package mission
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// Issue is a problem of a mission item.
+type Issue struct {
+	// the position of the item in the mission.
+	Seq int
+
+	// the description of the problem.
+	Text string
+}
+
+// String implements fmt.Stringer.
+func (i Issue) String() string {
+	return fmt.Sprintf("item %d: %s", i.Seq, i.Text)
+}
+
+// ValidationError is returned by Validate when a mission is invalid.
+type ValidationError struct {
+	Issues []Issue
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	strs := make([]string, len(e.Issues))
+	for i, is := range e.Issues {
+		strs[i] = is.String()
+	}
+	return "invalid mission: " + strings.Join(strs, "; ")
+}
+
+var globalFrames = map[common.MAV_FRAME]struct{}{
+	common.MAV_FRAME_GLOBAL:                  {},
+	common.MAV_FRAME_GLOBAL_RELATIVE_ALT:     {},
+	common.MAV_FRAME_GLOBAL_INT:              {},
+	common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT: {},
+	common.MAV_FRAME_GLOBAL_TERRAIN_ALT:      {},
+	common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT:  {},
+}
+
+var localFrames = map[common.MAV_FRAME]struct{}{
+	common.MAV_FRAME_LOCAL_NED: {},
+	common.MAV_FRAME_LOCAL_ENU: {},
+}
+
+// commands that move the vehicle to a position, that must be expressed in a
+// global or local frame.
+var positionCommands = map[common.MAV_CMD]struct{}{
+	common.MAV_CMD_NAV_WAYPOINT:                {},
+	common.MAV_CMD_NAV_LOITER_UNLIM:            {},
+	common.MAV_CMD_NAV_LOITER_TURNS:            {},
+	common.MAV_CMD_NAV_LOITER_TIME:             {},
+	common.MAV_CMD_NAV_LAND:                    {},
+	common.MAV_CMD_NAV_TAKEOFF:                 {},
+	common.MAV_CMD_NAV_CONTINUE_AND_CHANGE_ALT: {},
+	common.MAV_CMD_NAV_LOITER_TO_ALT:           {},
+	common.MAV_CMD_NAV_SPLINE_WAYPOINT:         {},
+	common.MAV_CMD_NAV_VTOL_TAKEOFF:            {},
+	common.MAV_CMD_NAV_VTOL_LAND:               {},
+	common.MAV_CMD_NAV_PAYLOAD_PLACE:           {},
+}
+
+type paramRange struct {
+	param int
+	name  string
+	min   float64
+	max   float64
+}
+
+// ranges of command parameters. NaN values mean that the default value
+// must be used, and are always accepted.
+var paramRanges = map[common.MAV_CMD][]paramRange{
+	common.MAV_CMD_NAV_WAYPOINT: {
+		{1, "hold time", 0, math.Inf(1)},
+		{2, "acceptance radius", 0, math.Inf(1)},
+	},
+	common.MAV_CMD_NAV_LOITER_TURNS: {
+		{1, "turns", math.SmallestNonzeroFloat32, math.Inf(1)},
+	},
+	common.MAV_CMD_NAV_LOITER_TIME: {
+		{1, "loiter time", 0, math.Inf(1)},
+	},
+	common.MAV_CMD_NAV_DELAY: {
+		{1, "delay", -1, math.Inf(1)},
+	},
+	common.MAV_CMD_CONDITION_DELAY: {
+		{1, "delay", 0, math.Inf(1)},
+	},
+	common.MAV_CMD_CONDITION_DISTANCE: {
+		{1, "distance", 0, math.Inf(1)},
+	},
+	common.MAV_CMD_CONDITION_YAW: {
+		{1, "angle", 0, 360},
+		{3, "direction", -1, 1},
+		{4, "relative", 0, 1},
+	},
+	common.MAV_CMD_DO_CHANGE_SPEED: {
+		{1, "speed type", 0, 3},
+		{2, "speed", -2, math.Inf(1)},
+		{3, "throttle", -2, 100},
+	},
+	common.MAV_CMD_DO_JUMP: {
+		{2, "repeat count", -1, math.Inf(1)},
+	},
+}
+
+func itemParam(item *common.MessageMissionItemInt, n int) float32 {
+	switch n {
+	case 1:
+		return item.Param1
+	case 2:
+		return item.Param2
+	case 3:
+		return item.Param3
+	}
+	return item.Param4
+}
+
+func isGlobal(frame common.MAV_FRAME) bool {
+	_, ok := globalFrames[frame]
+	return ok
+}
+
+func isLocal(frame common.MAV_FRAME) bool {
+	_, ok := localFrames[frame]
+	return ok
+}
+
+func isPositionCommand(cmd common.MAV_CMD) bool {
+	_, ok := positionCommands[cmd]
+	return ok
+}
+
+// Validate checks a mission and returns a *ValidationError that contains all
+// the issues found, or nil if the mission is valid. It checks
+// - that sequence numbers start at zero and have no gaps
+// - that the mission type is MAV_MISSION_TYPE_MISSION
+// - that commands that move the vehicle use a global or local frame
+// - that coordinates and command parameters are within their ranges
+// - that DO_JUMP commands point to existing items, that are not DO_JUMP
+func Validate(items []*common.MessageMissionItemInt) error {
+	var issues []Issue
+	add := func(seq int, format string, args ...interface{}) {
+		issues = append(issues, Issue{seq, fmt.Sprintf(format, args...)})
+	}
+
+	for i, item := range items {
+		if int(item.Seq) != i {
+			add(i, "sequence number is %d, expected %d", item.Seq, i)
+		}
+
+		if item.MissionType != common.MAV_MISSION_TYPE_MISSION {
+			add(i, "mission type is %s", item.MissionType)
+		}
+
+		if isPositionCommand(item.Command) && !isGlobal(item.Frame) && !isLocal(item.Frame) {
+			add(i, "frame %s is not compatible with command %s", item.Frame, item.Command)
+		}
+
+		if isGlobal(item.Frame) && isPositionCommand(item.Command) {
+			if item.X < -90e7 || item.X > 90e7 {
+				add(i, "latitude is out of range")
+			}
+			if item.Y < -180e7 || item.Y > 180e7 {
+				add(i, "longitude is out of range")
+			}
+		}
+
+		for _, r := range paramRanges[item.Command] {
+			v := float64(itemParam(item, r.param))
+			if !math.IsNaN(v) && (v < r.min || v > r.max) {
+				add(i, "%s of command %s is out of range (%v)", r.name, item.Command, v)
+			}
+		}
+
+		if item.Command == common.MAV_CMD_DO_JUMP {
+			target := item.Param1
+			switch {
+			case target != float32(math.Trunc(float64(target))) || target < 0 || int(target) >= len(items):
+				add(i, "DO_JUMP target %v does not exist", target)
+
+			case int(target) == i:
+				add(i, "DO_JUMP points to itself")
+
+			case items[int(target)].Command == common.MAV_CMD_DO_JUMP:
+				add(i, "DO_JUMP points to another DO_JUMP")
+			}
+
+			repeat := item.Param2
+			if repeat != float32(math.Trunc(float64(repeat))) {
+				add(i, "DO_JUMP repeat count must be an integer")
+			}
+		}
+	}
+
+	if issues != nil {
+		return &ValidationError{issues}
+	}
+	return nil
+}
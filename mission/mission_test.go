@@ -0,0 +1,96 @@
+package mission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func testItem(seq uint16, cmd common.MAV_CMD, frame common.MAV_FRAME,
+	params [4]float32, x int32, y int32, z float32) *common.MessageMissionItemInt {
+	return &common.MessageMissionItemInt{
+		Seq:          seq,
+		Frame:        frame,
+		Command:      cmd,
+		Autocontinue: 1,
+		Param1:       params[0],
+		Param2:       params[1],
+		Param3:       params[2],
+		Param4:       params[3],
+		X:            x,
+		Y:            y,
+		Z:            z,
+		MissionType:  common.MAV_MISSION_TYPE_MISSION,
+	}
+}
+
+func testMission() []*common.MessageMissionItemInt {
+	return []*common.MessageMissionItemInt{
+		testItem(0, common.MAV_CMD_NAV_TAKEOFF, common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			[4]float32{}, 450000000, 90000000, 10),
+		testItem(1, common.MAV_CMD_NAV_WAYPOINT, common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			[4]float32{5, 2, 0, 0}, 450009000, 90000000, 10),
+		testItem(2, common.MAV_CMD_DO_JUMP, common.MAV_FRAME_MISSION,
+			[4]float32{1, 1, 0, 0}, 0, 0, 0),
+		testItem(3, common.MAV_CMD_NAV_RETURN_TO_LAUNCH, common.MAV_FRAME_MISSION,
+			[4]float32{}, 0, 0, 0),
+	}
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(testMission()))
+	require.NoError(t, Validate(nil))
+}
+
+func TestValidateError(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		modify func([]*common.MessageMissionItemInt)
+		issue  Issue
+	}{
+		{
+			"sequence",
+			func(items []*common.MessageMissionItemInt) { items[1].Seq = 5 },
+			Issue{1, "sequence number is 5, expected 1"},
+		},
+		{
+			"mission type",
+			func(items []*common.MessageMissionItemInt) { items[1].MissionType = common.MAV_MISSION_TYPE_FENCE },
+			Issue{1, "mission type is MAV_MISSION_TYPE_FENCE"},
+		},
+		{
+			"frame",
+			func(items []*common.MessageMissionItemInt) { items[1].Frame = common.MAV_FRAME_MISSION },
+			Issue{1, "frame MAV_FRAME_MISSION is not compatible with command MAV_CMD_NAV_WAYPOINT"},
+		},
+		{
+			"latitude",
+			func(items []*common.MessageMissionItemInt) { items[1].X = 950000000 },
+			Issue{1, "latitude is out of range"},
+		},
+		{
+			"param",
+			func(items []*common.MessageMissionItemInt) { items[1].Param1 = -1 },
+			Issue{1, "hold time of command MAV_CMD_NAV_WAYPOINT is out of range (-1)"},
+		},
+		{
+			"jump target",
+			func(items []*common.MessageMissionItemInt) { items[2].Param1 = 7 },
+			Issue{2, "DO_JUMP target 7 does not exist"},
+		},
+		{
+			"jump itself",
+			func(items []*common.MessageMissionItemInt) { items[2].Param1 = 2 },
+			Issue{2, "DO_JUMP points to itself"},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			items := testMission()
+			ca.modify(items)
+			err := Validate(items)
+			require.Equal(t, &ValidationError{[]Issue{ca.issue}}, err)
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package mission
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// ServerConf allows to configure a Server.
+type ServerConf struct {
+	// the node through which missions are received. Transfers addressed to
+	// the system id and the component id of the node are handled.
+	Node *gomavlib.Node
+
+	// (optional) the type of the received missions, i.e.
+	// MAV_MISSION_TYPE_FENCE or MAV_MISSION_TYPE_RALLY.
+	// It defaults to MAV_MISSION_TYPE_MISSION.
+	MissionType common.MAV_MISSION_TYPE
+
+	// (optional) a function that checks uploaded missions before they are
+	// accepted, i.e. Validate. Missions are rejected with MAV_MISSION_INVALID
+	// when it returns an error, or with the given result when it returns an
+	// *AckError.
+	// It is called by the routine of the server, therefore it must not block.
+	Validate func(items []*common.MessageMissionItemInt) error
+
+	// (optional) a function that is called when a mission has been uploaded
+	// and accepted, or cleared.
+	// It is called by the routine of the server, therefore it must not block.
+	OnMission func(items []*common.MessageMissionItemInt)
+
+	// (optional) the maximum number of items of a mission. Uploads of longer
+	// missions are rejected with MAV_MISSION_NO_SPACE.
+	// It defaults to 0xFFFF.
+	MaxItems int
+
+	// (optional) the time after which a request for an item that has not
+	// been answered is sent again. It defaults to 1.5 seconds.
+	Timeout time.Duration
+
+	// (optional) the maximum number of retransmissions of a request.
+	// It defaults to 5.
+	MaxRetransmissions int
+}
+
+type serverMessage struct {
+	ch          *gomavlib.Channel
+	systemId    byte
+	componentId byte
+	m           msg.Message
+}
+
+// transfer is the state of an upload.
+type transfer struct {
+	ch              *gomavlib.Channel
+	systemId        byte
+	componentId     byte
+	items           []*common.MessageMissionItemInt
+	next            int
+	retransmissions int
+}
+
+// Server receives missions from ground stations, with the mission protocol:
+// after a MISSION_COUNT, items are requested one at a time with
+// MISSION_REQUEST_INT, requests whose item is lost are sent again, and the
+// mission is validated and acknowledged with MISSION_ACK.
+// The current mission can be downloaded and cleared by ground stations.
+type Server struct {
+	conf          ServerConf
+	removeHandler func()
+
+	mutex sync.Mutex
+	items []*common.MessageMissionItemInt
+
+	messages  chan serverMessage
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// NewServer allocates a Server. See ServerConf for the options.
+func NewServer(conf ServerConf) (*Server, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageMissionCount{},
+		&common.MessageMissionRequestList{},
+		&common.MessageMissionRequestInt{},
+		&common.MessageMissionItemInt{},
+		&common.MessageMissionAck{},
+		&common.MessageMissionClearAll{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.MaxItems < 0 {
+		return nil, fmt.Errorf("MaxItems must be >= 0")
+	}
+	if conf.MaxItems == 0 || conf.MaxItems > 0xFFFF {
+		conf.MaxItems = 0xFFFF
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 1500 * time.Millisecond
+	}
+	if conf.MaxRetransmissions == 0 {
+		conf.MaxRetransmissions = 5
+	}
+
+	s := &Server{
+		conf:      conf,
+		messages:  make(chan serverMessage, messageQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	s.removeHandler = conf.Node.AddFrameHandler(s.onEventFrame)
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the server. Uploads in progress are discarded.
+// It must be called before closing the node.
+func (s *Server) Close() {
+	s.removeHandler()
+	close(s.terminate)
+	<-s.done
+}
+
+// Items returns the current mission.
+func (s *Server) Items() []*common.MessageMissionItemInt {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*common.MessageMissionItemInt(nil), s.items...)
+}
+
+func (s *Server) onEventFrame(evt *gomavlib.EventFrame) {
+	var m msg.Message
+	var targetSystem, targetComponent byte
+	var missionType common.MAV_MISSION_TYPE
+
+	switch evt.Message().GetId() {
+	case (&common.MessageMissionCount{}).GetId():
+		var count common.MessageMissionCount
+		if msg.Convert(&count, evt.Message()) != nil {
+			return
+		}
+		m, targetSystem, targetComponent, missionType = &count,
+			count.TargetSystem, count.TargetComponent, count.MissionType
+
+	case (&common.MessageMissionItemInt{}).GetId():
+		var item common.MessageMissionItemInt
+		if msg.Convert(&item, evt.Message()) != nil {
+			return
+		}
+		m, targetSystem, targetComponent, missionType = &item,
+			item.TargetSystem, item.TargetComponent, item.MissionType
+
+	case (&common.MessageMissionRequestList{}).GetId():
+		var req common.MessageMissionRequestList
+		if msg.Convert(&req, evt.Message()) != nil {
+			return
+		}
+		m, targetSystem, targetComponent, missionType = &req,
+			req.TargetSystem, req.TargetComponent, req.MissionType
+
+	case (&common.MessageMissionRequestInt{}).GetId():
+		var req common.MessageMissionRequestInt
+		if msg.Convert(&req, evt.Message()) != nil {
+			return
+		}
+		m, targetSystem, targetComponent, missionType = &req,
+			req.TargetSystem, req.TargetComponent, req.MissionType
+
+	case (&common.MessageMissionAck{}).GetId():
+		var ack common.MessageMissionAck
+		if msg.Convert(&ack, evt.Message()) != nil {
+			return
+		}
+		m, targetSystem, targetComponent, missionType = &ack,
+			ack.TargetSystem, ack.TargetComponent, ack.MissionType
+
+	case (&common.MessageMissionClearAll{}).GetId():
+		var clear common.MessageMissionClearAll
+		if msg.Convert(&clear, evt.Message()) != nil {
+			return
+		}
+		m, targetSystem, targetComponent, missionType = &clear,
+			clear.TargetSystem, clear.TargetComponent, clear.MissionType
+
+		// all mission types can be cleared at once
+		if missionType == common.MAV_MISSION_TYPE_ALL {
+			missionType = s.conf.MissionType
+		}
+
+	default:
+		return
+	}
+
+	// the target component can be MAV_COMP_ID_ALL
+	nconf := s.conf.Node.Conf()
+	if targetSystem != nconf.OutSystemId ||
+		(targetComponent != 0 && targetComponent != nconf.OutComponentId) ||
+		missionType != s.conf.MissionType {
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case s.messages <- serverMessage{evt.Channel, evt.SystemId(), evt.ComponentId(), m}:
+	default:
+	}
+}
+
+func (s *Server) run() {
+	defer close(s.done)
+
+	// the upload in progress
+	var cur *transfer
+
+	// the last completed upload, whose acknowledgement is sent again when
+	// its last item is received again
+	var last *transfer
+	var lastResult common.MAV_MISSION_RESULT
+
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	stopTimer := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+
+	request := func() {
+		s.write(cur.ch, &common.MessageMissionRequestInt{
+			TargetSystem:    cur.systemId,
+			TargetComponent: cur.componentId,
+			Seq:             uint16(cur.next),
+			MissionType:     s.conf.MissionType,
+		})
+		stopTimer()
+		timer.Reset(s.conf.Timeout)
+	}
+
+	for {
+		select {
+		case sm := <-s.messages:
+			from := func(t *transfer) bool {
+				return t != nil && t.systemId == sm.systemId && t.componentId == sm.componentId
+			}
+
+			switch m := sm.m.(type) {
+			case *common.MessageMissionCount:
+				// another ground station is uploading
+				if cur != nil && !from(cur) {
+					s.ack(sm, common.MAV_MISSION_DENIED)
+					continue
+				}
+
+				cur = nil
+				last = nil
+				stopTimer()
+
+				if int(m.Count) > s.conf.MaxItems {
+					s.ack(sm, common.MAV_MISSION_NO_SPACE)
+					continue
+				}
+
+				t := &transfer{
+					ch:          sm.ch,
+					systemId:    sm.systemId,
+					componentId: sm.componentId,
+					items:       make([]*common.MessageMissionItemInt, m.Count),
+				}
+
+				if m.Count == 0 {
+					last, lastResult = t, s.complete(sm, t.items)
+					continue
+				}
+
+				cur = t
+				request()
+
+			case *common.MessageMissionItemInt:
+				if from(cur) {
+					// items of previous requests may still be queued
+					if int(m.Seq) != cur.next {
+						continue
+					}
+
+					cur.items[cur.next] = m
+					cur.next++
+					cur.retransmissions = 0
+
+					if cur.next < len(cur.items) {
+						request()
+						continue
+					}
+
+					stopTimer()
+					last, lastResult = cur, s.complete(sm, cur.items)
+					cur = nil
+					continue
+				}
+
+				// the acknowledgement has been lost
+				if cur == nil && from(last) && int(m.Seq) == len(last.items)-1 {
+					s.ack(sm, lastResult)
+				}
+
+			case *common.MessageMissionAck:
+				// the ground station cancelled the upload
+				if from(cur) {
+					cur = nil
+					stopTimer()
+				}
+
+			case *common.MessageMissionRequestList:
+				s.mutex.Lock()
+				count := len(s.items)
+				s.mutex.Unlock()
+
+				s.write(sm.ch, &common.MessageMissionCount{
+					TargetSystem:    sm.systemId,
+					TargetComponent: sm.componentId,
+					Count:           uint16(count),
+					MissionType:     s.conf.MissionType,
+				})
+
+			case *common.MessageMissionRequestInt:
+				// uploads and downloads are not performed by the same
+				// ground station in parallel
+				if from(cur) {
+					continue
+				}
+
+				s.mutex.Lock()
+				var item common.MessageMissionItemInt
+				ok := int(m.Seq) < len(s.items)
+				if ok {
+					item = *s.items[m.Seq]
+				}
+				s.mutex.Unlock()
+
+				if !ok {
+					s.ack(sm, common.MAV_MISSION_INVALID_SEQUENCE)
+					continue
+				}
+
+				item.TargetSystem = sm.systemId
+				item.TargetComponent = sm.componentId
+				item.Seq = m.Seq
+				item.MissionType = s.conf.MissionType
+				s.write(sm.ch, &item)
+
+			case *common.MessageMissionClearAll:
+				if cur != nil {
+					s.ack(sm, common.MAV_MISSION_DENIED)
+					continue
+				}
+
+				last = nil
+				s.setItems(nil)
+				s.ack(sm, common.MAV_MISSION_ACCEPTED)
+			}
+
+		case <-timer.C:
+			if cur == nil {
+				continue
+			}
+
+			if cur.retransmissions >= s.conf.MaxRetransmissions {
+				s.write(cur.ch, &common.MessageMissionAck{
+					TargetSystem:    cur.systemId,
+					TargetComponent: cur.componentId,
+					Type:            common.MAV_MISSION_OPERATION_CANCELLED,
+					MissionType:     s.conf.MissionType,
+				})
+				cur = nil
+				continue
+			}
+
+			cur.retransmissions++
+			request()
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+// complete validates an uploaded mission, stores it when it is valid and
+// sends the result to the ground station.
+func (s *Server) complete(sm serverMessage, items []*common.MessageMissionItemInt) common.MAV_MISSION_RESULT {
+	result := common.MAV_MISSION_ACCEPTED
+
+	if s.conf.Validate != nil {
+		err := s.conf.Validate(items)
+		if err != nil {
+			if ackErr, ok := err.(*AckError); ok {
+				result = ackErr.Result
+			} else {
+				result = common.MAV_MISSION_INVALID
+			}
+		}
+	}
+
+	if result == common.MAV_MISSION_ACCEPTED {
+		s.setItems(items)
+	}
+
+	s.ack(sm, result)
+	return result
+}
+
+func (s *Server) setItems(items []*common.MessageMissionItemInt) {
+	s.mutex.Lock()
+	s.items = items
+	s.mutex.Unlock()
+
+	if s.conf.OnMission != nil {
+		s.conf.OnMission(items)
+	}
+}
+
+func (s *Server) ack(sm serverMessage, result common.MAV_MISSION_RESULT) {
+	s.write(sm.ch, &common.MessageMissionAck{
+		TargetSystem:    sm.systemId,
+		TargetComponent: sm.componentId,
+		Type:            result,
+		MissionType:     s.conf.MissionType,
+	})
+}
+
+func (s *Server) write(ch *gomavlib.Channel, m msg.Message) {
+	s.conf.Node.WriteMessageTo(ch, m)
+}
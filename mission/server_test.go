@@ -0,0 +1,143 @@
+package mission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+func TestNewServerErrors(t *testing.T) {
+	_, err := NewServer(ServerConf{})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(vehicle)
+
+	_, err = NewServer(ServerConf{Node: vehicle, MaxItems: -1})
+	require.EqualError(t, err, "MaxItems must be >= 0")
+}
+
+func TestServer(t *testing.T) {
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(vehicle)
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	missions := make(chan []*common.MessageMissionItemInt, 10)
+
+	s, err := NewServer(ServerConf{
+		Node:     vehicle,
+		Validate: Validate,
+		OnMission: func(items []*common.MessageMissionItemInt) {
+			missions <- items
+		},
+		MaxItems: 5,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	c, err := NewClient(ClientConf{
+		Node:     gcs,
+		SystemId: 1,
+		Timeout:  100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.UploadMission(context.Background(), testMission())
+	require.NoError(t, err)
+
+	expected := testMission()
+	for _, item := range expected {
+		item.TargetSystem = 1
+		item.TargetComponent = 1
+	}
+	require.Equal(t, expected, <-missions)
+	require.Equal(t, expected, s.Items())
+
+	items, err := c.DownloadMission(context.Background())
+	require.NoError(t, err)
+	for _, item := range expected {
+		item.TargetSystem = 255
+		item.TargetComponent = 1
+	}
+	require.Equal(t, expected, items)
+
+	// invalid missions are rejected and the current one is kept
+	invalid := testMission()
+	invalid[1].X = 100e7
+	err = c.UploadMission(context.Background(), invalid)
+	require.Equal(t, &AckError{common.MAV_MISSION_INVALID}, err)
+	require.Equal(t, 4, len(s.Items()))
+
+	err = c.UploadMission(context.Background(), make([]*common.MessageMissionItemInt, 6))
+	require.Equal(t, &AckError{common.MAV_MISSION_NO_SPACE}, err)
+
+	// empty missions clear the current one
+	err = c.UploadMission(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(<-missions))
+	require.Equal(t, 0, len(s.Items()))
+	require.Equal(t, 0, len(missions))
+}
+
+func TestServerRetransmissions(t *testing.T) {
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(vehicle)
+
+	s, err := NewServer(ServerConf{
+		Node:               vehicle,
+		Timeout:            50 * time.Millisecond,
+		MaxRetransmissions: 2,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	gcs.WriteMessageAll(&common.MessageMissionCount{
+		TargetSystem:    1,
+		TargetComponent: 1,
+		Count:           2,
+	})
+
+	// the ground station doesn't answer, the request is sent again until
+	// the transfer is cancelled
+	requests := 0
+	for evt := range gcs.Events() {
+		fr, ok := evt.(*gomavlib.EventFrame)
+		if !ok {
+			continue
+		}
+
+		if req, ok := fr.Message().(*common.MessageMissionRequestInt); ok {
+			require.Equal(t, uint16(0), req.Seq)
+			requests++
+			continue
+		}
+
+		if ack, ok := fr.Message().(*common.MessageMissionAck); ok {
+			require.Equal(t, common.MAV_MISSION_OPERATION_CANCELLED, ack.Type)
+			break
+		}
+	}
+	require.Equal(t, 3, requests)
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+}
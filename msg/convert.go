@@ -0,0 +1,69 @@
+package msg
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Convert copies the fields of a message into another message with the same
+// id and fields, that usually belongs to a different dialect. It allows to use
+// a message decoded with a dialect (i.e. ardupilotmega.MessageCommandLong) as
+// if it was decoded with another dialect (i.e. common.MessageCommandLong).
+// Fields of dst that are not present in src are left untouched.
+func Convert(dst Message, src Message) error {
+	if dst.GetId() != src.GetId() {
+		return fmt.Errorf("message ids do not match (%d vs %d)", dst.GetId(), src.GetId())
+	}
+
+	dv := reflect.ValueOf(dst)
+	sv := reflect.ValueOf(src)
+	if dv.Kind() != reflect.Ptr || sv.Kind() != reflect.Ptr {
+		return fmt.Errorf("messages must be pointers")
+	}
+	dv = dv.Elem()
+	sv = sv.Elem()
+
+	// fast path
+	if dv.Type() == sv.Type() {
+		dv.Set(sv)
+		return nil
+	}
+
+	dt := dv.Type()
+	for i := 0; i < dt.NumField(); i++ {
+		sf := sv.FieldByName(dt.Field(i).Name)
+		if !sf.IsValid() {
+			continue
+		}
+
+		err := valueConvert(dv.Field(i), sf)
+		if err != nil {
+			return fmt.Errorf("field %s: %s", dt.Field(i).Name, err)
+		}
+	}
+
+	return nil
+}
+
+func valueConvert(dst reflect.Value, src reflect.Value) error {
+	if dst.Kind() == reflect.Array {
+		if src.Kind() != reflect.Array || src.Len() != dst.Len() {
+			return fmt.Errorf("incompatible types (%s vs %s)", dst.Type(), src.Type())
+		}
+		for i := 0; i < dst.Len(); i++ {
+			err := valueConvert(dst.Index(i), src.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// enums are converted into enums of another dialect
+	if dst.Kind() != src.Kind() || !src.Type().ConvertibleTo(dst.Type()) {
+		return fmt.Errorf("incompatible types (%s vs %s)", dst.Type(), src.Type())
+	}
+
+	dst.Set(src.Convert(dst.Type()))
+	return nil
+}
@@ -0,0 +1,48 @@
+package msg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type OTHER_MAV_TYPE int
+
+type MessageOtherHeartbeat struct {
+	Type           OTHER_MAV_TYPE `mavenum:"uint8"`
+	Autopilot      MAV_AUTOPILOT  `mavenum:"uint8"`
+	BaseMode       MAV_MODE_FLAG  `mavenum:"uint8"`
+	CustomMode     uint32
+	SystemStatus   MAV_STATE `mavenum:"uint8"`
+	MavlinkVersion uint8
+}
+
+func (*MessageOtherHeartbeat) GetId() uint32 {
+	return 0
+}
+
+func TestConvert(t *testing.T) {
+	var dst MessageOtherHeartbeat
+	err := Convert(&dst, &MessageHeartbeat{
+		Type:           1,
+		Autopilot:      2,
+		BaseMode:       3,
+		CustomMode:     4,
+		SystemStatus:   5,
+		MavlinkVersion: 3,
+	})
+	require.NoError(t, err)
+	require.Equal(t, MessageOtherHeartbeat{
+		Type:           1,
+		Autopilot:      2,
+		BaseMode:       3,
+		CustomMode:     4,
+		SystemStatus:   5,
+		MavlinkVersion: 3,
+	}, dst)
+}
+
+func TestConvertError(t *testing.T) {
+	err := Convert(&MessageOtherHeartbeat{}, &MessageRequestDataStream{})
+	require.EqualError(t, err, "message ids do not match (0 vs 66)")
+}
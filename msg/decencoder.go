@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/aler9/gomavlib/x25"
 )
@@ -72,15 +73,15 @@ var fieldTypeSizes = map[fieldType]byte{
 	typeChar:   1,
 }
 
+var reUpper = regexp.MustCompile("([A-Z])")
+
 func fieldGoToDef(in string) string {
-	re := regexp.MustCompile("([A-Z])")
-	in = re.ReplaceAllString(in, "_${1}")
+	in = reUpper.ReplaceAllString(in, "_${1}")
 	return strings.ToLower(in[1:])
 }
 
 func msgGoToDef(in string) string {
-	re := regexp.MustCompile("([A-Z])")
-	in = re.ReplaceAllString(in, "_${1}")
+	in = reUpper.ReplaceAllString(in, "_${1}")
 	return strings.ToUpper(in[1:])
 }
 
@@ -95,6 +96,7 @@ type decEncoderField struct {
 
 // DecEncoder is an object that allows to decode and encode a Message.
 type DecEncoder struct {
+	name         string
 	fields       []*decEncoderField
 	sizeNormal   byte
 	sizeExtended byte
@@ -102,8 +104,57 @@ type DecEncoder struct {
 	crcExtra     byte
 }
 
+// maximum number of cached DecEncoders. When it is exceeded, the DecEncoder
+// that was cached first is discarded.
+var decEncoderCacheSize = 1024
+
+// DecEncoders are not modified after their creation, therefore they are
+// shared by the whole process.
+var decEncoderCache = struct {
+	mutex   sync.RWMutex
+	entries map[reflect.Type]*DecEncoder
+	keys    []reflect.Type
+}{
+	entries: make(map[reflect.Type]*DecEncoder),
+}
+
 // NewDecEncoder allocates a DecEncoder.
+// The last DecEncoders are cached, therefore subsequent calls with a message
+// of the same type usually return the same DecEncoder.
 func NewDecEncoder(msg Message) (*DecEncoder, error) {
+	typ := reflect.TypeOf(msg)
+
+	decEncoderCache.mutex.RLock()
+	mde, ok := decEncoderCache.entries[typ]
+	decEncoderCache.mutex.RUnlock()
+	if ok {
+		return mde, nil
+	}
+
+	mde, err := newDecEncoder(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	decEncoderCache.mutex.Lock()
+	defer decEncoderCache.mutex.Unlock()
+
+	// if another routine stored a DecEncoder in the meanwhile, use it
+	if cached, ok := decEncoderCache.entries[typ]; ok {
+		return cached, nil
+	}
+
+	for len(decEncoderCache.keys) >= decEncoderCacheSize {
+		delete(decEncoderCache.entries, decEncoderCache.keys[0])
+		decEncoderCache.keys = append(decEncoderCache.keys[:0], decEncoderCache.keys[1:]...)
+	}
+	decEncoderCache.entries[typ] = mde
+	decEncoderCache.keys = append(decEncoderCache.keys, typ)
+
+	return mde, nil
+}
+
+func newDecEncoder(msg Message) (*DecEncoder, error) {
 	mde := &DecEncoder{}
 	mde.elemType = reflect.TypeOf(msg).Elem()
 
@@ -113,7 +164,11 @@ func NewDecEncoder(msg Message) (*DecEncoder, error) {
 	if !strings.HasPrefix(mde.elemType.Name(), "Message") {
 		return nil, fmt.Errorf("message struct name must begin with 'Message'")
 	}
-	msgName := msgGoToDef(mde.elemType.Name()[len("Message"):])
+	mde.name = msgGoToDef(mde.elemType.Name()[len("Message"):])
+
+	if msg.GetId() > MaxId {
+		return nil, fmt.Errorf("message id %d does not fit into 24 bits", msg.GetId())
+	}
 
 	// collect message fields
 	for i := 0; i < mde.elemType.NumField(); i++ {
@@ -199,14 +254,9 @@ func NewDecEncoder(msg Message) (*DecEncoder, error) {
 		}
 
 		mde.fields[i] = &decEncoderField{
-			isEnum: isEnum,
-			ftype:  dialectType,
-			name: func() string {
-				if mavname := field.Tag.Get("mavname"); mavname != "" {
-					return mavname
-				}
-				return fieldGoToDef(field.Name)
-			}(),
+			isEnum:      isEnum,
+			ftype:       dialectType,
+			name:        FieldName(field),
 			arrayLength: arrayLength,
 			index:       i,
 			isExtension: isExtension,
@@ -235,7 +285,7 @@ func NewDecEncoder(msg Message) (*DecEncoder, error) {
 	// https://mavlink.io/en/guide/serialization.html#crc_extra
 	mde.crcExtra = func() byte {
 		h := x25.New()
-		h.Write([]byte(msgName + " "))
+		h.Write([]byte(mde.name + " "))
 
 		for _, f := range mde.fields {
 			// skip extensions
@@ -257,11 +307,48 @@ func NewDecEncoder(msg Message) (*DecEncoder, error) {
 	return mde, nil
 }
 
+// FieldName returns the name of a message field as it appears in the
+// definition file, i.e. the content of the mavname tag or the field name
+// converted into snake case.
+func FieldName(field reflect.StructField) string {
+	if mavname := field.Tag.Get("mavname"); mavname != "" {
+		return mavname
+	}
+	return fieldGoToDef(field.Name)
+}
+
+// Name returns the message name as it appears in the definition file.
+func (mde *DecEncoder) Name() string {
+	return mde.name
+}
+
 // CRCExtra returns the message CRC extra.
 func (mde *DecEncoder) CRCExtra() byte {
 	return mde.crcExtra
 }
 
+// SizeNormal returns the size of the message payload without extensions,
+// that is the size of the payload in V1 frames.
+func (mde *DecEncoder) SizeNormal() int {
+	return int(mde.sizeNormal)
+}
+
+// SizeExtended returns the size of the message payload with extensions.
+func (mde *DecEncoder) SizeExtended() int {
+	return int(mde.sizeExtended)
+}
+
+// PayloadBounds returns the minimum and maximum size of the payload of the
+// message when encoded in a frame. In V1 frames, payload size is fixed.
+// In V2 frames, payloads can be truncated down to 1 byte and can contain
+// extensions.
+func (mde *DecEncoder) PayloadBounds(isV2 bool) (int, int) {
+	if isV2 {
+		return 1, int(mde.sizeExtended)
+	}
+	return int(mde.sizeNormal), int(mde.sizeNormal)
+}
+
 // Decode decodes a Message.
 func (mde *DecEncoder) Decode(buf []byte, isV2 bool) (Message, error) {
 	msg := reflect.New(mde.elemType)
@@ -309,6 +396,13 @@ func (mde *DecEncoder) Decode(buf []byte, isV2 bool) (Message, error) {
 
 // Encode encodes a message.
 func (mde *DecEncoder) Encode(msg Message, isV2 bool) ([]byte, error) {
+	return mde.EncodeWithMinLength(msg, isV2, 1)
+}
+
+// EncodeWithMinLength encodes a message. In case of V2 messages, trailing
+// empty bytes are truncated, but the payload is never shortened below minLength
+// bytes. A minLength greater or equal than the message size disables truncation.
+func (mde *DecEncoder) EncodeWithMinLength(msg Message, isV2 bool, minLength int) ([]byte, error) {
 	var buf []byte
 
 	if isV2 == true {
@@ -348,8 +442,12 @@ func (mde *DecEncoder) Encode(msg Message, isV2 bool) ([]byte, error) {
 	// even with truncation, message length must be at least 1 byte
 	// https://github.com/mavlink/c_library_v2/blob/master/mavlink_helpers.h#L103
 	if isV2 == true {
+		if minLength < 1 {
+			minLength = 1
+		}
+
 		end := len(buf)
-		for end > 1 && buf[end-1] == 0x00 {
+		for end > minLength && buf[end-1] == 0x00 {
 			end--
 		}
 		buf = buf[:end]
@@ -394,7 +492,7 @@ func valueDecode(target reflect.Value, buf []byte, f *decEncoderField) int {
 	case *string:
 		// find nil character or string end
 		end := 0
-		for buf[end] != 0 && end < int(f.arrayLength) {
+		for end < int(f.arrayLength) && buf[end] != 0 {
 			end++
 		}
 		*tt = string(buf[:end])
@@ -2,6 +2,8 @@ package msg
 
 import (
 	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -371,3 +373,142 @@ func TestEncode(t *testing.T) {
 		})
 	}
 }
+
+// strings that fill their field are not terminated by a nil character, and
+// must be decoded without reading beyond the field, that can be the end of
+// the payload.
+func TestDecodeStringFillsField(t *testing.T) {
+	mp, err := NewDecEncoder(&MessagePlayTune{})
+	require.NoError(t, err)
+
+	tune := strings.Repeat("a", 30)
+
+	for _, c := range []struct {
+		name   string
+		isV2   bool
+		raw    []byte
+		parsed Message
+	}{
+		{
+			"string at the end of the payload",
+			false,
+			append([]byte{0x01, 0x02}, tune...),
+			&MessagePlayTune{
+				TargetSystem:    1,
+				TargetComponent: 2,
+				Tune:            tune,
+			},
+		},
+		{
+			"string followed by another field",
+			true,
+			append(append([]byte{0x01, 0x02}, tune...), "test2"...),
+			&MessagePlayTune{
+				TargetSystem:    1,
+				TargetComponent: 2,
+				Tune:            tune,
+				Tune2:           "test2",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			msg, err := mp.Decode(c.raw, c.isV2)
+			require.NoError(t, err)
+			require.Equal(t, c.parsed, msg)
+		})
+	}
+}
+
+func TestEncodeWithMinLength(t *testing.T) {
+	m := &MessageAhrs{
+		OmegaIx:     1,
+		OmegaIy:     2,
+		OmegaIz:     3,
+		AccelWeight: 4,
+		RenormVal:   5,
+	}
+
+	mp, err := NewDecEncoder(m)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		minLength int
+		length    int
+	}{
+		{0, 20},
+		{1, 20},
+		{24, 24},
+		{28, 28},
+		{255, 28},
+	} {
+		byt, err := mp.EncodeWithMinLength(m, true, c.minLength)
+		require.NoError(t, err)
+		require.Equal(t, c.length, len(byt))
+
+		dec, err := mp.Decode(byt, true)
+		require.NoError(t, err)
+		require.Equal(t, m, dec)
+	}
+
+	// V1 messages are never truncated
+	byt, err := mp.EncodeWithMinLength(m, false, 1)
+	require.NoError(t, err)
+	require.Equal(t, 28, len(byt))
+}
+
+func TestPayloadBounds(t *testing.T) {
+	mp, err := NewDecEncoder(&MessageOpticalFlow{})
+	require.NoError(t, err)
+
+	require.Equal(t, 26, mp.SizeNormal())
+	require.Equal(t, 34, mp.SizeExtended())
+
+	min, max := mp.PayloadBounds(false)
+	require.Equal(t, 26, min)
+	require.Equal(t, 26, max)
+
+	min, max = mp.PayloadBounds(true)
+	require.Equal(t, 1, min)
+	require.Equal(t, 34, max)
+}
+
+func TestDecEncoderCache(t *testing.T) {
+	mp1, err := NewDecEncoder(&MessageOpticalFlow{})
+	require.NoError(t, err)
+
+	mp2, err := NewDecEncoder(&MessageOpticalFlow{})
+	require.NoError(t, err)
+
+	require.True(t, mp1 == mp2)
+}
+
+func TestDecEncoderCacheSize(t *testing.T) {
+	defer func(size int) { decEncoderCacheSize = size }(decEncoderCacheSize)
+	decEncoderCacheSize = 1
+	decEncoderCache.entries = make(map[reflect.Type]*DecEncoder)
+	decEncoderCache.keys = nil
+
+	mp1, err := NewDecEncoder(&MessageOpticalFlow{})
+	require.NoError(t, err)
+
+	_, err = NewDecEncoder(&MessageAhrs{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(decEncoderCache.entries))
+
+	mp2, err := NewDecEncoder(&MessageOpticalFlow{})
+	require.NoError(t, err)
+	require.True(t, mp1 != mp2)
+}
+
+type MessageTooLarge struct {
+	A uint8
+}
+
+func (*MessageTooLarge) GetId() uint32 {
+	return 0x1000000
+}
+
+func TestDecEncoderIdTooLarge(t *testing.T) {
+	_, err := NewDecEncoder(&MessageTooLarge{})
+	require.EqualError(t, err, "message id 16777216 does not fit into 24 bits")
+}
@@ -2,6 +2,15 @@
 // decode messages.
 package msg
 
+const (
+	// MaxV1Id is the maximum id of a message that can be sent inside a V1 frame.
+	MaxV1Id = 0xFF
+
+	// MaxId is the maximum id of a message, that is encoded with 24 bits
+	// inside V2 frames.
+	MaxId = 0xFFFFFF
+)
+
 // Message is the interface that must be implemented by all Mavlink messages.
 // Furthermore, any message must be labeled "MessageNameOfMessage".
 type Message interface {
@@ -24,3 +33,9 @@ type MessageRaw struct {
 func (m *MessageRaw) GetId() uint32 {
 	return m.Id
 }
+
+// IsV2Only checks whether a message can be sent inside V2 frames only,
+// since its id does not fit into a V1 frame.
+func IsV2Only(m Message) bool {
+	return m.GetId() > MaxV1Id
+}
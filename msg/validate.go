@@ -0,0 +1,145 @@
+package msg
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// ValidationError is the error returned by DecEncoder.Validate when a field
+// can't be encoded without altering its value.
+type ValidationError struct {
+	// the message name, as it appears in the definition file
+	Message string
+
+	// the field name, as it appears in the definition file
+	Field string
+
+	// the index of the element, in case of arrays, or -1
+	Index int
+
+	// the reason
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("message %s, field %s[%d]: %s", e.Message, e.Field, e.Index, e.Reason)
+	}
+	return fmt.Sprintf("message %s, field %s: %s", e.Message, e.Field, e.Reason)
+}
+
+// enumRange returns the range of values of a enum field, given its wire type.
+func enumRange(t fieldType) (int64, int64) {
+	switch t {
+	case typeUint8:
+		return 0, math.MaxUint8
+	case typeInt8:
+		return math.MinInt8, math.MaxInt8
+	case typeUint16:
+		return 0, math.MaxUint16
+	case typeUint32:
+		return 0, math.MaxUint32
+	case typeInt32:
+		return math.MinInt32, math.MaxInt32
+	}
+	return 0, math.MaxInt64 // typeUint64
+}
+
+// enumKnown checks whether the value of a enum is a known value, or a
+// combination of known flags, in case of bitmasks. Enums without text
+// representation are always considered known.
+func enumKnown(v reflect.Value) bool {
+	tm, ok := v.Interface().(encoding.TextMarshaler)
+	if !ok {
+		return true
+	}
+
+	if _, err := tm.MarshalText(); err == nil {
+		return true
+	}
+
+	i := v.Int()
+	if i < 0 {
+		return false
+	}
+
+	flag := reflect.New(v.Type()).Elem()
+	for bit := uint(0); bit < 64; bit++ {
+		if (i & (1 << bit)) == 0 {
+			continue
+		}
+		flag.SetInt(1 << bit)
+		if _, err := flag.Interface().(encoding.TextMarshaler).MarshalText(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (mde *DecEncoder) validateValue(v reflect.Value, f *decEncoderField, index int) error {
+	newError := func(format string, args ...interface{}) error {
+		return &ValidationError{
+			Message: mde.name,
+			Field:   f.name,
+			Index:   index,
+			Reason:  fmt.Sprintf(format, args...),
+		}
+	}
+
+	if f.isEnum {
+		min, max := enumRange(f.ftype)
+		i := v.Int()
+		if i < min || i > max {
+			return newError("value %d overflows %s", i, fieldTypeString[f.ftype])
+		}
+
+		if !enumKnown(v) {
+			return newError("value %d is not a known value of %s", i, v.Type().Name())
+		}
+		return nil
+	}
+
+	if v.Kind() == reflect.String {
+		if l := len(v.String()); l > int(f.arrayLength) {
+			return newError("string is %d bytes long, while the maximum length is %d", l, f.arrayLength)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks whether a message can be encoded without altering it, i.e.
+// whether strings fit into their fields and enums contain values that are
+// known and fit into their wire types. Messages are otherwise silently
+// truncated during encoding.
+func (mde *DecEncoder) Validate(msg Message) error {
+	rv := reflect.ValueOf(msg)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Type() != mde.elemType {
+		return fmt.Errorf("message has type %T, while the DecEncoder requires *%s", msg, mde.elemType)
+	}
+	rv = rv.Elem()
+
+	for _, f := range mde.fields {
+		target := rv.Field(f.index)
+
+		if target.Kind() == reflect.Array {
+			for i := 0; i < target.Len(); i++ {
+				err := mde.validateValue(target.Index(i), f, i)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		err := mde.validateValue(target, f, -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
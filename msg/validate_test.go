@@ -0,0 +1,106 @@
+package msg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testMode int
+
+func (e testMode) MarshalText() ([]byte, error) {
+	switch e {
+	case 0:
+		return []byte("MODE_MANUAL"), nil
+	case 1:
+		return []byte("MODE_AUTO"), nil
+	}
+	return nil, errors.New("invalid value")
+}
+
+type testFlag int
+
+func (e testFlag) MarshalText() ([]byte, error) {
+	switch e {
+	case 1:
+		return []byte("FLAG_ARMED"), nil
+	case 2:
+		return []byte("FLAG_GUIDED"), nil
+	case 4:
+		return []byte("FLAG_AUTO"), nil
+	}
+	return nil, errors.New("invalid value")
+}
+
+type MessageValidateTest struct {
+	Mode     testMode    `mavenum:"uint8"`
+	Flags    testFlag    `mavenum:"uint16"`
+	Modes    [2]testMode `mavenum:"int8"`
+	Name     string      `mavlen:"8"`
+	Initial  string
+	Value    float32
+	Counters [3]uint16
+}
+
+func (*MessageValidateTest) GetId() uint32 {
+	return 1000
+}
+
+func TestValidate(t *testing.T) {
+	mde, err := NewDecEncoder(&MessageValidateTest{})
+	require.NoError(t, err)
+
+	require.NoError(t, mde.Validate(&MessageValidateTest{
+		Mode:    1,
+		Flags:   1 | 4,
+		Name:    "12345678",
+		Initial: "a",
+	}))
+
+	for _, ca := range []struct {
+		name string
+		msg  Message
+		err  string
+	}{
+		{
+			"enum overflow",
+			&MessageValidateTest{Mode: 256},
+			"message VALIDATE_TEST, field mode: value 256 overflows uint8_t",
+		},
+		{
+			"enum unknown",
+			&MessageValidateTest{Mode: 2},
+			"message VALIDATE_TEST, field mode: value 2 is not a known value of testMode",
+		},
+		{
+			"flags unknown",
+			&MessageValidateTest{Flags: 1 | 8},
+			"message VALIDATE_TEST, field flags: value 9 is not a known value of testFlag",
+		},
+		{
+			"enum array",
+			&MessageValidateTest{Modes: [2]testMode{0, -1}},
+			"message VALIDATE_TEST, field modes[1]: value -1 is not a known value of testMode",
+		},
+		{
+			"string too long",
+			&MessageValidateTest{Name: "123456789"},
+			"message VALIDATE_TEST, field name: string is 9 bytes long, while the maximum length is 8",
+		},
+		{
+			"char too long",
+			&MessageValidateTest{Initial: "ab"},
+			"message VALIDATE_TEST, field initial: string is 2 bytes long, while the maximum length is 1",
+		},
+		{
+			"wrong type",
+			&MessageHeartbeat{},
+			"message has type *msg.MessageHeartbeat, while the DecEncoder requires *msg.MessageValidateTest",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.EqualError(t, mde.Validate(ca.msg), ca.err)
+		})
+	}
+}
@@ -0,0 +1,301 @@
+// Package namedvalue implements helpers to publish and collect named values
+// (NAMED_VALUE_FLOAT, NAMED_VALUE_INT and DEBUG_VECT), that allow to quickly
+// instrument algorithms running on companion computers and plot their
+// variables in ground stations.
+//
+// The node to which helpers are attached must use a dialect that contains
+// the common messages.
+package namedvalue
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// names are truncated to this length
+	nameMaxLength = 10
+)
+
+func truncateName(name string) string {
+	if len(name) > nameMaxLength {
+		return name[:nameMaxLength]
+	}
+	return name
+}
+
+func checkNode(node *gomavlib.Node) error {
+	if node == nil {
+		return fmt.Errorf("Node not provided")
+	}
+
+	if node.Conf().Dialect == nil {
+		return fmt.Errorf("node must use a dialect")
+	}
+
+	return node.Conf().Dialect.CheckMessages(
+		&common.MessageNamedValueFloat{},
+		&common.MessageNamedValueInt{},
+		&common.MessageDebugVect{})
+}
+
+// PublisherConf allows to configure a Publisher.
+type PublisherConf struct {
+	// the node used to publish values.
+	Node *gomavlib.Node
+
+	// (optional) the minimum interval between two publications of a value
+	// with the same name. Publications that happen before the interval has
+	// elapsed are discarded.
+	// It defaults to 100ms.
+	MinInterval time.Duration
+}
+
+// Publisher publishes named values.
+// Names longer than 10 characters are truncated.
+type Publisher struct {
+	conf  PublisherConf
+	start time.Time
+
+	mutex    sync.Mutex
+	lastSent map[string]time.Time
+}
+
+// NewPublisher allocates a Publisher. See PublisherConf for the options.
+func NewPublisher(conf PublisherConf) (*Publisher, error) {
+	err := checkNode(conf.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.MinInterval == 0 {
+		conf.MinInterval = 100 * time.Millisecond
+	}
+
+	return &Publisher{
+		conf:     conf,
+		start:    time.Now(),
+		lastSent: make(map[string]time.Time),
+	}, nil
+}
+
+// allow checks the rate limit of a value. kind separates values of different
+// messages that share the same name.
+func (p *Publisher) allow(kind string, name string) bool {
+	key := kind + ":" + name
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if last, ok := p.lastSent[key]; ok && now.Sub(last) < p.conf.MinInterval {
+		return false
+	}
+	p.lastSent[key] = now
+	return true
+}
+
+func (p *Publisher) timeBootMs() uint32 {
+	return uint32(time.Since(p.start) / time.Millisecond)
+}
+
+// Float publishes a NAMED_VALUE_FLOAT. It returns false if the value has been
+// discarded because of the rate limit.
+func (p *Publisher) Float(name string, value float32) bool {
+	name = truncateName(name)
+	if !p.allow("float", name) {
+		return false
+	}
+
+	p.conf.Node.WriteMessageAll(&common.MessageNamedValueFloat{
+		TimeBootMs: p.timeBootMs(),
+		Name:       name,
+		Value:      value,
+	})
+	return true
+}
+
+// Int publishes a NAMED_VALUE_INT. It returns false if the value has been
+// discarded because of the rate limit.
+func (p *Publisher) Int(name string, value int32) bool {
+	name = truncateName(name)
+	if !p.allow("int", name) {
+		return false
+	}
+
+	p.conf.Node.WriteMessageAll(&common.MessageNamedValueInt{
+		TimeBootMs: p.timeBootMs(),
+		Name:       name,
+		Value:      value,
+	})
+	return true
+}
+
+// Vect publishes a DEBUG_VECT. It returns false if the value has been
+// discarded because of the rate limit.
+func (p *Publisher) Vect(name string, x float32, y float32, z float32) bool {
+	name = truncateName(name)
+	if !p.allow("vect", name) {
+		return false
+	}
+
+	p.conf.Node.WriteMessageAll(&common.MessageDebugVect{
+		TimeUsec: uint64(time.Since(p.start) / time.Microsecond),
+		Name:     name,
+		X:        x,
+		Y:        y,
+		Z:        z,
+	})
+	return true
+}
+
+// Sample is a value received at a given time.
+type Sample struct {
+	// the time at which the value was received.
+	Time time.Time
+	// the value.
+	Value float64
+}
+
+// SubscriberConf allows to configure a Subscriber.
+type SubscriberConf struct {
+	// the node from which values are read.
+	Node *gomavlib.Node
+
+	// (optional) the system id from which values are collected.
+	// If zero, values of all systems are collected.
+	SystemId byte
+
+	// (optional) the number of samples kept for each series.
+	// It defaults to 100.
+	HistoryLength int
+}
+
+// Subscriber collects named values into series.
+// Values of NAMED_VALUE_FLOAT and NAMED_VALUE_INT are stored in a series with
+// the name of the value, while components of DEBUG_VECT are stored in three
+// series, named "<name>.x", "<name>.y" and "<name>.z".
+type Subscriber struct {
+	conf          SubscriberConf
+	removeHandler func()
+
+	mutex  sync.Mutex
+	series map[string][]Sample
+}
+
+// NewSubscriber allocates a Subscriber. See SubscriberConf for the options.
+func NewSubscriber(conf SubscriberConf) (*Subscriber, error) {
+	err := checkNode(conf.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.HistoryLength == 0 {
+		conf.HistoryLength = 100
+	}
+
+	s := &Subscriber{
+		conf:   conf,
+		series: make(map[string][]Sample),
+	}
+
+	s.removeHandler = conf.Node.AddFrameHandler(s.onEventFrame)
+
+	return s, nil
+}
+
+// Close detaches the subscriber from the node.
+func (s *Subscriber) Close() {
+	s.removeHandler()
+}
+
+// Names returns the names of the available series, sorted.
+func (s *Subscriber) Names() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := make([]string, 0, len(s.series))
+	for name := range s.series {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Series returns a copy of all series, sorted by time.
+func (s *Subscriber) Series() map[string][]Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := make(map[string][]Sample, len(s.series))
+	for name, samples := range s.series {
+		ret[name] = append([]Sample(nil), samples...)
+	}
+	return ret
+}
+
+// Latest returns the latest sample of a series.
+func (s *Subscriber) Latest(name string) (Sample, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	samples, ok := s.series[name]
+	if !ok {
+		return Sample{}, false
+	}
+	return samples[len(samples)-1], true
+}
+
+func (s *Subscriber) add(name string, t time.Time, value float64) {
+	samples := append(s.series[name], Sample{t, value})
+	if len(samples) > s.conf.HistoryLength {
+		samples = samples[len(samples)-s.conf.HistoryLength:]
+	}
+	s.series[name] = samples
+}
+
+func (s *Subscriber) onEventFrame(evt *gomavlib.EventFrame) {
+	if s.conf.SystemId != 0 && evt.SystemId() != s.conf.SystemId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageNamedValueFloat{}).GetId():
+		var m common.MessageNamedValueFloat
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.add(m.Name, evt.ReceiveTime, float64(m.Value))
+
+	case (&common.MessageNamedValueInt{}).GetId():
+		var m common.MessageNamedValueInt
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.add(m.Name, evt.ReceiveTime, float64(m.Value))
+
+	case (&common.MessageDebugVect{}).GetId():
+		var m common.MessageDebugVect
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.add(m.Name+".x", evt.ReceiveTime, float64(m.X))
+		s.add(m.Name+".y", evt.ReceiveTime, float64(m.Y))
+		s.add(m.Name+".z", evt.ReceiveTime, float64(m.Z))
+	}
+}
@@ -0,0 +1,85 @@
+package namedvalue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestPublisherSubscriber(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5680"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	s, err := NewSubscriber(SubscriberConf{
+		Node:          node1,
+		SystemId:      1,
+		HistoryLength: 3,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5680"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	p, err := NewPublisher(PublisherConf{
+		Node:        node2,
+		MinInterval: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, true, p.Float("altitude_estimate", 1))
+	require.Equal(t, false, p.Float("altitude_estimate", 2))
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+
+		p.Float("altitude_estimate", float32(i))
+		p.Int("count", int32(i))
+		p.Vect("vel", 1, 2, float32(i))
+
+		if len(s.Names()) == 5 && len(s.Series()["count"]) == 3 {
+			break
+		}
+	}
+
+	require.Equal(t, []string{"altitude_e", "count", "vel.x", "vel.y", "vel.z"}, s.Names())
+
+	series := s.Series()
+	require.Equal(t, 3, len(series["count"]))
+	require.True(t, series["count"][0].Value < series["count"][2].Value)
+	require.False(t, series["count"][2].Time.Before(series["count"][0].Time))
+
+	sample, ok := s.Latest("vel.y")
+	require.Equal(t, true, ok)
+	require.Equal(t, float64(2), sample.Value)
+
+	_, ok = s.Latest("missing")
+	require.Equal(t, false, ok)
+}
@@ -21,7 +21,7 @@ Basic example (more are available at https://github.com/aler9/gomavlib/tree/mast
   func main() {
   	node, err := gomavlib.NewNode(gomavlib.NodeConf{
 		Endpoints: []gomavlib.EndpointConf{
-			gomavlib.EndpointSerial{"/dev/ttyUSB0:57600"},
+			gomavlib.EndpointSerial{Address: "/dev/ttyUSB0:57600"},
 		},
   		Dialect:     ardupilotmega.Dialect,
 		OutVersion:  gomavlib.V2,
@@ -44,6 +44,8 @@ package gomavlib
 
 import (
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/aler9/gomavlib/dialect"
@@ -69,6 +71,24 @@ type writeExceptReq struct {
 	what   interface{}
 }
 
+type endpointAddReq struct {
+	e   Endpoint
+	ca  *channelAccepter
+	ch  *Channel
+	res chan bool
+}
+
+type endpointRemoveRes struct {
+	err      error
+	ca       *channelAccepter
+	channels []*Channel
+}
+
+type endpointRemoveReq struct {
+	e   Endpoint
+	res chan endpointRemoveRes
+}
+
 // NodeConf allows to configure a Node.
 type NodeConf struct {
 	// the endpoints with which this node will
@@ -77,14 +97,22 @@ type NodeConf struct {
 
 	// (optional) the dialect which contains the messages that will be encoded and decoded.
 	// If not provided, messages are decoded in the MessageRaw struct.
+	// Nodes that use the same dialect share the objects used to decode and encode it.
 	Dialect *dialect.Dialect
 
+	// (optional) the behavior in case Dialect contains multiple messages with
+	// the same id. By default, NewNode() returns an error that reports the
+	// conflicting types.
+	DialectCollisionPolicy dialect.CollisionPolicy
+
 	// (optional) the secret key used to validate incoming frames.
 	// Non signed frames are discarded, as well as frames with a version < 2.0.
+	// Endpoints wrapped in EndpointSigned use the keys of their SigningDomain.
 	InKey *frame.V2Key
 
 	// Mavlink version used to encode messages. See Version
-	// for the available options.
+	// for the available options. VAuto replies to each system with the version
+	// it uses.
 	OutVersion Version
 	// the system id, added to every outgoing frame and used to identify this
 	// node in the network.
@@ -94,6 +122,30 @@ type NodeConf struct {
 	// (optional) the secret key used to sign outgoing frames.
 	// This feature requires a version >= 2.0.
 	OutKey *frame.V2Key
+	// (optional) disables the empty-byte truncation of outgoing v2 messages.
+	// Some receivers do not handle truncated messages correctly.
+	OutTruncationDisable bool
+	// (optional) the minimum length of outgoing v2 messages after empty-byte
+	// truncation. It defaults to 1.
+	OutTruncationMinLength int
+	// (optional) validates messages passed to the write methods. Messages
+	// with strings that don't fit into their fields or enums with unknown
+	// values are discarded, instead of being written altered. The reason is
+	// returned by the Checked variants of the write methods, i.e.
+	// WriteMessageAllChecked(), and can be obtained with ValidateMessage().
+	OutValidate bool
+	// (optional) a function that is called with every message passed to the
+	// write methods, including the messages of written frames. Messages for
+	// which it returns false are discarded. It allows to block critical
+	// commands, i.e. with package interlock.
+	// It is called by the goroutines that call the write methods, therefore
+	// it must be safe for concurrent use and must not block.
+	OutGuard func(message msg.Message) bool
+
+	// (optional) collects the durations of the stages in which frames are
+	// read, parsed, decoded, routed and written, that can be obtained with
+	// Channel.LatencyStats(). It adds a small overhead to every frame.
+	LatencyStatsEnable bool
 
 	// (optional) disables the periodic sending of heartbeats to open channels.
 	HeartbeatDisable bool
@@ -111,30 +163,147 @@ type NodeConf struct {
 	StreamRequestEnable bool
 	// (optional) the requested stream frequency in Hz. It defaults to 4.
 	StreamRequestFrequency int
+
+	// (optional) the time after which a component that does not send
+	// heartbeats is removed from Presence(). It defaults to 10 seconds.
+	PresenceTimeout time.Duration
+	// (optional) when greater than zero, the presence timeout of components
+	// seen on a channel is extended to this factor times the estimated round
+	// trip time of the channel (see Channel.RTT), when longer, in order to
+	// avoid removing components that are reachable through high-latency
+	// links, i.e. satellite links.
+	PresenceRTTFactor float64
+	// (optional) emits EventComponentAppear and EventComponentDisappear when
+	// components are added to and removed from Components().
+	ComponentEventsEnable bool
+
+	// (optional) the size of the write queue of each channel.
+	// By default, writes are fully serialized: a message is handed to the
+	// channels only after all of them have taken the previous one, therefore
+	// all channels receive messages in the same global order, but a slow
+	// channel slows down writes to the others.
+	// With a queue, each channel still has a single writer and receives
+	// messages in the same order, but a slow channel blocks the others only
+	// when its queue is full.
+	WriteQueueSize int
+
+	// (optional) a store that persists the sequence ids and the signature
+	// timestamps of outgoing frames, in order to resume them after a restart.
+	// Otherwise, receivers see sequence ids jump back to zero and compute
+	// a wrong packet loss.
+	// The state of each channel is saved periodically and when the channel is closed.
+	SequenceStore SequenceStore
+	// (optional) the period between saves of the state of each channel.
+	// It defaults to 1 second.
+	SequenceStorePeriod time.Duration
+
+	// (optional) a function that is called when a server endpoint accepts a
+	// new channel, before the channel is opened. It can reject the channel by
+	// returning false, i.e. to authenticate clients by their address, or
+	// attach a value to the channel, i.e. session state, that can then be
+	// retrieved with Channel.Value().
+	// It is called by the goroutine that accepts channels of the endpoint,
+	// therefore it must not block.
+	ChannelAccept func(ch *Channel) (interface{}, bool)
+
+	// (optional) a function that returns true when heartbeats of ground
+	// control stations (MAV_TYPE_GCS) must not be forwarded through a channel,
+	// in order to save bandwidth on radio links shared by many systems.
+	// It applies to frames written with WriteFrameTo, WriteFrameAll and
+	// WriteFrameExcept; heartbeats of vehicles and heartbeats emitted by the
+	// node are always written.
+	GcsHeartbeatSuppress func(ch *Channel) bool
+
+	// (optional) emits EventSerialDevicePlugged and EventSerialDeviceUnplugged
+	// when USB serial devices are plugged and unplugged, i.e. autopilots and
+	// telemetry radios. Devices are found by scanning the lists maintained by
+	// the operating system (sysfs on Linux, /dev on macOS, the registry on
+	// Windows). When enabled, Endpoints can be empty.
+	SerialHotplugEnable bool
+	// (optional) the period between scans of devices. It defaults to 1 second.
+	SerialHotplugPeriod time.Duration
+	// (optional) a function that decides whether a device is a candidate,
+	// i.e. by its vendor id. By default, all USB serial devices are.
+	SerialHotplugFilter func(d SerialDevice) bool
+	// (optional) attaches an EndpointSerial to each plugged device, and removes
+	// it when the device is unplugged.
+	SerialHotplugAttach bool
+	// (optional) the baud rate of attached devices. If zero, it is detected
+	// by listening to heartbeats (see EndpointSerial).
+	SerialHotplugBaudRate int
+
+	// (optional) sends TIMESYNC requests periodically, and measures the
+	// clock offset and the round trip time of the components that answer
+	// them, that can be obtained with Timesync(). Round trip times are also
+	// reported to the channels (see Channel.RTT).
+	TimesyncEnable bool
+	// (optional) the period between TIMESYNC requests. It defaults to 1 second.
+	TimesyncPeriod time.Duration
+	// (optional) answers TIMESYNC requests of other components, with the
+	// clock of the node (nanoseconds since the Unix epoch), i.e. to allow an
+	// autopilot to synchronize with a companion computer.
+	TimesyncAnswer bool
+
+	// (optional) emits an ordered set of events when the node is closed:
+	// EventChannelClose for each channel and EventEndpointStop for each
+	// endpoint, in the order of Endpoints(), then EventNodeTerminated, before
+	// closing Events(). This allows to run cleanup routines per channel and
+	// to tell a deliberate shutdown from closures caused by errors (see
+	// EventChannelClose.Error). When enabled, Events() must be read until it
+	// is closed, otherwise Close() blocks.
+	ShutdownEventsEnable bool
+}
+
+// FrameHandler is a function that is called when a frame is received.
+type FrameHandler func(*EventFrame)
+
+type frameHandlerEntry struct {
+	h FrameHandler
 }
 
 // Node is a high-level Mavlink encoder and decoder that works with endpoints.
+//
+// Write methods (WriteMessageTo, WriteMessageAll, WriteFrameExcept, ...) can be
+// called by multiple goroutines in parallel. Messages and frames written by a
+// single goroutine are sent to each channel in the same order of the calls.
+// Writes of different goroutines are sent in the order in which the node
+// accepts them, which is the order in which calls are issued, unless they are
+// issued in parallel. Each channel has a single writer, therefore frames are
+// never interleaved.
 type Node struct {
-	conf              NodeConf
-	dialectDE         *dialect.DecEncoder
-	channelAccepters  map[*channelAccepter]struct{}
-	channels          map[*Channel]struct{}
-	nodeHeartbeat     *nodeHeartbeat
-	nodeStreamRequest *nodeStreamRequest
+	conf               NodeConf
+	dialectDE          *dialect.DecEncoder
+	channelAccepters   map[*channelAccepter]struct{}
+	channels           map[*Channel]struct{}
+	closingChannels    map[*Channel]struct{}
+	nodeHeartbeat      *nodeHeartbeat
+	nodeStreamRequest  *nodeStreamRequest
+	nodePresence       *nodePresence
+	nodeSerialHotplug  *nodeSerialHotplug
+	nodeTimesync       *nodeTimesync
+	frameHandlersMutex sync.RWMutex
+	frameHandlers      map[*frameHandlerEntry]struct{}
+	endpointsMutex     sync.RWMutex
+	endpoints          []Endpoint
+	tapsMutex          sync.RWMutex
+	taps               map[*tapEntry]struct{}
 
 	eventsOut    chan Event
 	channelNew   chan *Channel
 	channelClose chan *Channel
 	writeTo      chan writeToReq
 	writeAll     chan interface{}
-	writeExcept  chan writeExceptReq
-	terminate    chan struct{}
-	done         chan struct{}
+	writeExcept    chan writeExceptReq
+	channelsReq    chan chan []*Channel
+	endpointAdd    chan endpointAddReq
+	endpointRemove chan endpointRemoveReq
+	terminate      chan struct{}
+	done           chan struct{}
 }
 
 // NewNode allocates a Node. See NodeConf for the options.
 func NewNode(conf NodeConf) (*Node, error) {
-	if len(conf.Endpoints) == 0 {
+	if len(conf.Endpoints) == 0 && !conf.SerialHotplugEnable {
 		return nil, fmt.Errorf("at least one endpoint must be provided")
 	}
 	if conf.HeartbeatPeriod == 0 {
@@ -149,6 +318,30 @@ func NewNode(conf NodeConf) (*Node, error) {
 	if conf.StreamRequestFrequency == 0 {
 		conf.StreamRequestFrequency = 4
 	}
+	if conf.PresenceTimeout == 0 {
+		conf.PresenceTimeout = 10 * time.Second
+	}
+	if conf.PresenceRTTFactor < 0 {
+		return nil, fmt.Errorf("PresenceRTTFactor must be >= 0")
+	}
+	if conf.WriteQueueSize < 0 {
+		return nil, fmt.Errorf("WriteQueueSize must be >= 0")
+	}
+	if conf.SequenceStorePeriod == 0 {
+		conf.SequenceStorePeriod = 1 * time.Second
+	}
+	if conf.SerialHotplugPeriod == 0 {
+		conf.SerialHotplugPeriod = 1 * time.Second
+	}
+	if conf.SerialHotplugBaudRate < 0 {
+		return nil, fmt.Errorf("SerialHotplugBaudRate must be >= 0")
+	}
+	if conf.TimesyncPeriod < 0 {
+		return nil, fmt.Errorf("TimesyncPeriod must be >= 0")
+	}
+	if conf.TimesyncPeriod == 0 {
+		conf.TimesyncPeriod = 1 * time.Second
+	}
 
 	// check Transceiver configuration here, since Transceiver is created dynamically
 	if conf.OutVersion == 0 {
@@ -163,12 +356,15 @@ func NewNode(conf NodeConf) (*Node, error) {
 	if conf.OutKey != nil && conf.OutVersion != V2 {
 		return nil, fmt.Errorf("OutKey requires V2 frames")
 	}
+	if conf.OutTruncationMinLength < 0 {
+		return nil, fmt.Errorf("OutTruncationMinLength must be >= 0")
+	}
 
 	dialectDE, err := func() (*dialect.DecEncoder, error) {
 		if conf.Dialect == nil {
 			return nil, nil
 		}
-		return dialect.NewDecEncoder(conf.Dialect)
+		return dialect.NewDecEncoderWithPolicy(conf.Dialect, conf.DialectCollisionPolicy)
 	}()
 	if err != nil {
 		return nil, err
@@ -179,16 +375,22 @@ func NewNode(conf NodeConf) (*Node, error) {
 		dialectDE:        dialectDE,
 		channelAccepters: make(map[*channelAccepter]struct{}),
 		channels:         make(map[*Channel]struct{}),
+		closingChannels:  make(map[*Channel]struct{}),
+		frameHandlers:    make(map[*frameHandlerEntry]struct{}),
+		taps:             make(map[*tapEntry]struct{}),
 		// these can be unbuffered as long as eventsIn's goroutine
 		// does not write to eventsOut
-		eventsOut:    make(chan Event),
-		channelNew:   make(chan *Channel),
-		channelClose: make(chan *Channel),
-		writeTo:      make(chan writeToReq),
-		writeAll:     make(chan interface{}),
-		writeExcept:  make(chan writeExceptReq),
-		terminate:    make(chan struct{}),
-		done:         make(chan struct{}),
+		eventsOut:      make(chan Event),
+		channelNew:     make(chan *Channel),
+		channelClose:   make(chan *Channel),
+		writeTo:        make(chan writeToReq),
+		writeAll:       make(chan interface{}),
+		writeExcept:    make(chan writeExceptReq),
+		channelsReq:    make(chan chan []*Channel),
+		endpointAdd:    make(chan endpointAddReq),
+		endpointRemove: make(chan endpointRemoveReq),
+		terminate:      make(chan struct{}),
+		done:           make(chan struct{}),
 	}
 
 	closeExisting := func() {
@@ -208,6 +410,17 @@ func NewNode(conf NodeConf) (*Node, error) {
 			return nil, err
 		}
 
+		n.endpoints = append(n.endpoints, tp)
+
+		err = checkSigningDomain(conf, tp)
+		if err != nil {
+			tp.(io.Closer).Close()
+			closeExisting()
+			return nil, err
+		}
+
+		n.addEndpointTaps(tp)
+
 		switch ttp := tp.(type) {
 		case endpointChannelAccepter:
 			ca, err := newChannelAccepter(n, ttp)
@@ -234,6 +447,9 @@ func NewNode(conf NodeConf) (*Node, error) {
 
 	n.nodeHeartbeat = newNodeHeartbeat(n)
 	n.nodeStreamRequest = newNodeStreamRequest(n)
+	n.nodePresence = newNodePresence(n)
+	n.nodeSerialHotplug = newNodeSerialHotplug(n)
+	n.nodeTimesync = newNodeTimesync(n)
 
 	if n.nodeHeartbeat != nil {
 		go n.nodeHeartbeat.run()
@@ -243,6 +459,18 @@ func NewNode(conf NodeConf) (*Node, error) {
 		go n.nodeStreamRequest.run()
 	}
 
+	if n.nodePresence != nil {
+		go n.nodePresence.run()
+	}
+
+	if n.nodeSerialHotplug != nil {
+		go n.nodeSerialHotplug.run()
+	}
+
+	if n.nodeTimesync != nil {
+		go n.nodeTimesync.run()
+	}
+
 	for ch := range n.channels {
 		go ch.run()
 	}
@@ -263,16 +491,39 @@ outer:
 	for {
 		select {
 		case ch := <-n.channelNew:
+			// the endpoint may have been removed in the meanwhile
+			if !n.hasEndpoint(ch.Endpoint) {
+				ch.rwc.Close()
+				continue
+			}
 			n.channels[ch] = struct{}{}
 			go ch.run()
 
 		case ch := <-n.channelClose:
+			// the channel may have been removed with its endpoint
+			if _, ok := n.channels[ch]; !ok {
+				continue
+			}
 			delete(n.channels, ch)
 			close(ch.terminate)
 
+			// the channel releases its resources asynchronously; keep it in
+			// order to wait for it when the node is closed.
+			n.pruneClosingChannels()
+			n.closingChannels[ch] = struct{}{}
+
+			if n.nodePresence != nil {
+				n.nodePresence.onChannelClose(ch)
+			}
+
+			if n.nodeTimesync != nil {
+				n.nodeTimesync.onChannelClose(ch)
+			}
+
 		case req := <-n.writeTo:
+			// the channel may have been closed in the meanwhile
 			if _, ok := n.channels[req.ch]; !ok {
-				return
+				continue
 			}
 			req.ch.writec <- req.what
 
@@ -288,6 +539,30 @@ outer:
 				}
 			}
 
+		case res := <-n.channelsReq:
+			var ret []*Channel
+			for ch := range n.channels {
+				ret = append(ret, ch)
+			}
+			res <- ret
+
+		case req := <-n.endpointAdd:
+			n.endpointsMutex.Lock()
+			n.endpoints = append(n.endpoints, req.e)
+			n.endpointsMutex.Unlock()
+
+			if req.ca != nil {
+				n.channelAccepters[req.ca] = struct{}{}
+				go req.ca.run()
+			} else {
+				n.channels[req.ch] = struct{}{}
+				go req.ch.run()
+			}
+			req.res <- true
+
+		case req := <-n.endpointRemove:
+			req.res <- n.removeEndpoint(req.e)
+
 		case <-n.terminate:
 			break outer
 		}
@@ -305,6 +580,12 @@ outer:
 			case <-n.writeTo:
 			case <-n.writeAll:
 			case <-n.writeExcept:
+			case res := <-n.channelsReq:
+				res <- nil
+			case req := <-n.endpointAdd:
+				req.res <- false
+			case req := <-n.endpointRemove:
+				req.res <- endpointRemoveRes{err: errorTerminated}
 			}
 		}
 	}()
@@ -317,23 +598,82 @@ outer:
 		n.nodeStreamRequest.close()
 	}
 
-	for ca := range n.channelAccepters {
-		ca.close()
+	if n.nodePresence != nil {
+		n.nodePresence.close()
+	}
+
+	if n.nodeSerialHotplug != nil {
+		n.nodeSerialHotplug.close()
+	}
+
+	if n.nodeTimesync != nil {
+		n.nodeTimesync.close()
+	}
+
+	// stop endpoints in order, each one after its channels
+	n.endpointsMutex.RLock()
+	endpoints := append([]Endpoint(nil), n.endpoints...)
+	n.endpointsMutex.RUnlock()
+
+	for _, e := range endpoints {
+		for ca := range n.channelAccepters {
+			if Endpoint(ca.eca) == e {
+				delete(n.channelAccepters, ca)
+				ca.close()
+			}
+		}
+
+		for ch := range n.channels {
+			if ch.Endpoint == e {
+				delete(n.channels, ch)
+				close(ch.terminate)
+				<-ch.done
+			}
+		}
+
+		if n.conf.ShutdownEventsEnable {
+			n.eventsOut <- &EventEndpointStop{e}
+		}
 	}
 
 	for ch := range n.channels {
 		close(ch.terminate)
 		<-ch.done
 	}
+
+	// wait for channels closed by themselves, i.e. evicted by an idle timeout,
+	// in order to release their sockets before returning from Close().
+	for ch := range n.closingChannels {
+		<-ch.done
+	}
+
+	if n.conf.ShutdownEventsEnable {
+		n.eventsOut <- &EventNodeTerminated{}
+	}
+}
+
+// pruneClosingChannels removes closed channels that have released their
+// resources.
+func (n *Node) pruneClosingChannels() {
+	for ch := range n.closingChannels {
+		select {
+		case <-ch.done:
+			delete(n.closingChannels, ch)
+		default:
+		}
+	}
 }
 
 // Close halts node operations and waits for all routines to return.
 func (n *Node) Close() {
-	// consume events, in case user is not calling Events()
-	go func() {
-		for range n.eventsOut {
-		}
-	}()
+	// consume events, in case user is not calling Events().
+	// Shutdown events are not consumed, in order to deliver them all.
+	if !n.conf.ShutdownEventsEnable {
+		go func() {
+			for range n.eventsOut {
+			}
+		}()
+	}
 
 	close(n.terminate)
 	<-n.done
@@ -341,36 +681,340 @@ func (n *Node) Close() {
 	close(n.eventsOut)
 }
 
+// Conf returns the configuration used to initialize the node,
+// with default values filled in.
+func (n *Node) Conf() NodeConf {
+	return n.conf
+}
+
+// AddFrameHandler adds a function that is called for every received frame,
+// before the frame is emitted through Events(). It allows to build services
+// on top of the node. The function is called by the routine that reads the
+// channel, therefore it must not block.
+// It returns a function that removes the handler.
+func (n *Node) AddFrameHandler(h FrameHandler) func() {
+	e := &frameHandlerEntry{h}
+
+	n.frameHandlersMutex.Lock()
+	defer n.frameHandlersMutex.Unlock()
+	n.frameHandlers[e] = struct{}{}
+
+	return func() {
+		n.frameHandlersMutex.Lock()
+		defer n.frameHandlersMutex.Unlock()
+		delete(n.frameHandlers, e)
+	}
+}
+
+func (n *Node) callFrameHandlers(evt *EventFrame) {
+	// copy handlers, in order to allow them to remove themselves
+	var handlers []FrameHandler
+	func() {
+		n.frameHandlersMutex.RLock()
+		defer n.frameHandlersMutex.RUnlock()
+
+		for e := range n.frameHandlers {
+			handlers = append(handlers, e.h)
+		}
+	}()
+
+	for _, h := range handlers {
+		h(evt)
+	}
+}
+
 // Events returns a channel from which receiving events. Possible events are:
 //   *EventChannelOpen
 //   *EventChannelClose
+//   *EventEndpointGiveUp
+//   *EventEndpointStop
 //   *EventFrame
 //   *EventParseError
 //   *EventStreamRequested
+//   *EventVersionDowngrade
+//   *EventComponentAppear
+//   *EventComponentDisappear
+//   *EventSerialDevicePlugged
+//   *EventSerialDeviceUnplugged
+//   *EventNodeTerminated
 // See individual events for meaning and content.
 func (n *Node) Events() chan Event {
 	return n.eventsOut
 }
 
+// Channels returns the channels that are currently open, in no particular
+// order.
+func (n *Node) Channels() []*Channel {
+	res := make(chan []*Channel)
+	n.channelsReq <- res
+	return <-res
+}
+
+// AddEndpoint adds an endpoint to a running node. The endpoint is appended
+// to Endpoints() and its channels are opened as if the endpoint was
+// provided in NodeConf.Endpoints.
+func (n *Node) AddEndpoint(conf EndpointConf) (Endpoint, error) {
+	e, err := conf.init()
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSigningDomain(n.conf, e)
+	if err != nil {
+		e.(io.Closer).Close()
+		return nil, err
+	}
+
+	n.addEndpointTaps(e)
+
+	req := endpointAddReq{
+		e:   e,
+		res: make(chan bool),
+	}
+
+	switch te := e.(type) {
+	case endpointChannelAccepter:
+		req.ca, err = newChannelAccepter(n, te)
+		if err != nil {
+			n.removeTaps(e)
+			te.Close()
+			return nil, err
+		}
+
+	case endpointChannelSingle:
+		req.ch, err = newChannel(n, te, te.Label(), te)
+		if err != nil {
+			n.removeTaps(e)
+			te.Close()
+			return nil, err
+		}
+
+	default:
+		panic(fmt.Errorf("endpoint %T does not implement any interface", e))
+	}
+
+	n.endpointAdd <- req
+	if !<-req.res {
+		n.removeTaps(e)
+		e.(io.Closer).Close()
+		return nil, errorTerminated
+	}
+
+	return e, nil
+}
+
+// RemoveEndpoint closes the channels of an endpoint and removes the endpoint
+// from a running node. It waits until the channels are closed, therefore
+// it must not be called by the routine that reads Events().
+func (n *Node) RemoveEndpoint(e Endpoint) error {
+	res := make(chan endpointRemoveRes)
+	n.endpointRemove <- endpointRemoveReq{e, res}
+	r := <-res
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.ca != nil {
+		r.ca.close()
+	}
+
+	for _, ch := range r.channels {
+		<-ch.done
+	}
+
+	return nil
+}
+
+func (n *Node) hasEndpoint(e Endpoint) bool {
+	n.endpointsMutex.RLock()
+	defer n.endpointsMutex.RUnlock()
+
+	for _, cur := range n.endpoints {
+		if cur == e {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEndpoint is called by run().
+func (n *Node) removeEndpoint(e Endpoint) endpointRemoveRes {
+	found := func() bool {
+		n.endpointsMutex.Lock()
+		defer n.endpointsMutex.Unlock()
+
+		for i, cur := range n.endpoints {
+			if cur == e {
+				n.endpoints = append(n.endpoints[:i], n.endpoints[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}()
+	if !found {
+		return endpointRemoveRes{err: fmt.Errorf("endpoint not found")}
+	}
+
+	n.removeTaps(e)
+
+	var res endpointRemoveRes
+
+	for ca := range n.channelAccepters {
+		if Endpoint(ca.eca) == e {
+			delete(n.channelAccepters, ca)
+			res.ca = ca
+		}
+	}
+
+	for ch := range n.channels {
+		if ch.Endpoint == e {
+			delete(n.channels, ch)
+			close(ch.terminate)
+
+			if n.nodePresence != nil {
+				n.nodePresence.onChannelClose(ch)
+			}
+
+			if n.nodeTimesync != nil {
+				n.nodeTimesync.onChannelClose(ch)
+			}
+
+			res.channels = append(res.channels, ch)
+		}
+	}
+
+	return res
+}
+
+// ValidateMessage checks whether a message can be written without altering
+// it, i.e. whether strings fit into their fields and enums contain known
+// values that fit into their wire types. It returns a *msg.ValidationError
+// that describes the first invalid field.
+// It returns frame.ErrV2OnlyMessage if the message can't be sent since its id
+// does not fit into the V1 frames set by OutVersion.
+func (n *Node) ValidateMessage(message msg.Message) error {
+	if n.conf.OutVersion == V1 && msg.IsV2Only(message) {
+		return frame.ErrV2OnlyMessage
+	}
+
+	if n.dialectDE == nil {
+		return fmt.Errorf("message cannot be encoded since dialect is nil")
+	}
+
+	mp, ok, err := n.dialectDE.MessageDE(message.GetId())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("message cannot be encoded since it is not in the dialect")
+	}
+
+	return mp.Validate(message)
+}
+
+// ErrMessageGuarded is returned by the Checked variants of the write methods
+// when a message is discarded by OutGuard.
+var ErrMessageGuarded = fmt.Errorf("message discarded by OutGuard")
+
+// checkMessage returns an error if a message passed to the write methods
+// can't be written.
+func (n *Node) checkMessage(message msg.Message) error {
+	// V1 frames can't carry the message whatever OutValidate is
+	if n.conf.OutVersion == V1 && msg.IsV2Only(message) {
+		return frame.ErrV2OnlyMessage
+	}
+
+	// messages are processed on first use, therefore errors in their
+	// definitions are reported here
+	if _, ok := message.(*msg.MessageRaw); !ok && n.dialectDE != nil {
+		_, _, err := n.dialectDE.MessageDE(message.GetId())
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.conf.OutValidate {
+		err := n.ValidateMessage(message)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.conf.OutGuard != nil && !n.conf.OutGuard(message) {
+		return ErrMessageGuarded
+	}
+	return nil
+}
+
+// checkFrame returns whether a frame passed to the write methods can be
+// written.
+func (n *Node) checkFrame(fr frame.Frame) bool {
+	if n.conf.OutGuard == nil || fr.GetMessage() == nil {
+		return true
+	}
+	return n.conf.OutGuard(fr.GetMessage())
+}
+
 // WriteMessageTo writes a message to given channel.
+// If the channel has been closed, the message is discarded.
 func (n *Node) WriteMessageTo(channel *Channel, message msg.Message) {
+	n.WriteMessageToChecked(channel, message)
+}
+
+// WriteMessageToChecked is like WriteMessageTo, but returns an error when
+// the message is discarded: frame.ErrV2OnlyMessage if its id doesn't fit
+// into the V1 frames set by OutVersion, the error of its definition if it
+// can't be processed, a *msg.ValidationError if a field is invalid and
+// OutValidate is set, or ErrMessageGuarded if it is rejected by OutGuard.
+func (n *Node) WriteMessageToChecked(channel *Channel, message msg.Message) error {
+	err := n.checkMessage(message)
+	if err != nil {
+		return err
+	}
 	n.writeTo <- writeToReq{channel, message}
+	return nil
 }
 
 // WriteMessageAll writes a message to all channels.
 func (n *Node) WriteMessageAll(message msg.Message) {
+	n.WriteMessageAllChecked(message)
+}
+
+// WriteMessageAllChecked is like WriteMessageAll, but returns an error when
+// the message is discarded. See WriteMessageToChecked.
+func (n *Node) WriteMessageAllChecked(message msg.Message) error {
+	err := n.checkMessage(message)
+	if err != nil {
+		return err
+	}
 	n.writeAll <- message
+	return nil
 }
 
 // WriteMessageExcept writes a message to all channels except specified channel.
 func (n *Node) WriteMessageExcept(exceptChannel *Channel, message msg.Message) {
+	n.WriteMessageExceptChecked(exceptChannel, message)
+}
+
+// WriteMessageExceptChecked is like WriteMessageExcept, but returns an error
+// when the message is discarded. See WriteMessageToChecked.
+func (n *Node) WriteMessageExceptChecked(exceptChannel *Channel, message msg.Message) error {
+	err := n.checkMessage(message)
+	if err != nil {
+		return err
+	}
 	n.writeExcept <- writeExceptReq{exceptChannel, message}
+	return nil
 }
 
 // WriteFrameTo writes a frame to given channel.
+// If the channel has been closed, the frame is discarded.
 // This function is intended only for routing pre-existing frames to other nodes,
 // since all frame fields must be filled manually.
 func (n *Node) WriteFrameTo(channel *Channel, frame frame.Frame) {
+	if !n.checkFrame(frame) {
+		return
+	}
 	n.writeTo <- writeToReq{channel, frame}
 }
 
@@ -378,6 +1022,9 @@ func (n *Node) WriteFrameTo(channel *Channel, frame frame.Frame) {
 // This function is intended only for routing pre-existing frames to other nodes,
 // since all frame fields must be filled manually.
 func (n *Node) WriteFrameAll(frame frame.Frame) {
+	if !n.checkFrame(frame) {
+		return
+	}
 	n.writeAll <- frame
 }
 
@@ -385,5 +1032,8 @@ func (n *Node) WriteFrameAll(frame frame.Frame) {
 // This function is intended only for routing pre-existing frames to other nodes,
 // since all frame fields must be filled manually.
 func (n *Node) WriteFrameExcept(exceptChannel *Channel, frame frame.Frame) {
+	if !n.checkFrame(frame) {
+		return
+	}
 	n.writeExcept <- writeExceptReq{exceptChannel, frame}
 }
@@ -2,17 +2,36 @@ package gomavlib
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/tarm/serial"
 
 	"github.com/aler9/gomavlib/dialect"
 	"github.com/aler9/gomavlib/frame"
 	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/transceiver"
 )
 
 type MAV_TYPE int
@@ -45,6 +64,17 @@ func (*MessageRequestDataStream) GetId() uint32 {
 	return 66
 }
 
+type MessageTimesync struct {
+	Tc1             int64
+	Ts1             int64
+	TargetSystem    uint8 `mavext:"true"`
+	TargetComponent uint8 `mavext:"true"`
+}
+
+func (*MessageTimesync) GetId() uint32 {
+	return 111
+}
+
 func doTest(t *testing.T, t1 EndpointConf, t2 EndpointConf) {
 	var testMsg1 = &MessageHeartbeat{
 		Type:           1,
@@ -103,7 +133,6 @@ func doTest(t *testing.T, t1 EndpointConf, t2 EndpointConf) {
 
 	go func() {
 		defer wg.Done()
-		defer node1.Close()
 
 		step := 0
 
@@ -137,7 +166,6 @@ func doTest(t *testing.T, t1 EndpointConf, t2 EndpointConf) {
 
 	go func() {
 		defer wg.Done()
-		defer node2.Close()
 
 		// wait connection to server
 		time.Sleep(500 * time.Millisecond)
@@ -176,291 +204,2927 @@ func doTest(t *testing.T, t1 EndpointConf, t2 EndpointConf) {
 
 	wg.Wait()
 
+	// nodes are closed when both sides are done, since the last message is
+	// written asynchronously and would be discarded by Close()
+	node1.Close()
+	node2.Close()
+
 	require.Equal(t, true, success)
 }
 
 func TestNodeTcpServerClient(t *testing.T) {
-	doTest(t, EndpointTcpServer{"127.0.0.1:5601"}, EndpointTcpClient{"127.0.0.1:5601"})
+	doTest(t, EndpointTcpServer{Address: "127.0.0.1:5601"}, EndpointTcpClient{Address: "127.0.0.1:5601"})
 }
 
-func TestNodeUdpServerClient(t *testing.T) {
-	doTest(t, EndpointUdpServer{"127.0.0.1:5601"}, EndpointUdpClient{"127.0.0.1:5601"})
+// testTLSConfigs returns the configurations of a server and a client that
+// authenticate each other with a self-signed certificate.
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	parsed, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}
 }
 
-func TestNodeUdpBroadcastBroadcast(t *testing.T) {
-	doTest(t, EndpointUdpBroadcast{"127.255.255.255:5602", ":5601"},
-		EndpointUdpBroadcast{"127.255.255.255:5601", ":5602"})
+func TestNodeTcpTlsServerClient(t *testing.T) {
+	serverConf, clientConf := testTLSConfigs(t)
+	doTest(t, EndpointTcpTlsServer{Address: "127.0.0.1:5601", TLSConfig: serverConf},
+		EndpointTcpTlsClient{Address: "127.0.0.1:5601", TLSConfig: clientConf})
 }
 
-type testLoopback chan []byte
+func TestNodeWebsocketServerClient(t *testing.T) {
+	doTest(t, EndpointWebsocketServer{Address: "127.0.0.1:5601", Path: "/mavlink"},
+		EndpointWebsocketClient{Address: "ws://127.0.0.1:5601/mavlink"})
+}
 
-func (ch testLoopback) Close() error {
-	close(ch)
-	return nil
+func TestNodeWebsocketTlsServerClient(t *testing.T) {
+	serverConf, clientConf := testTLSConfigs(t)
+	doTest(t, EndpointWebsocketServer{Address: "127.0.0.1:5601", TLSConfig: serverConf},
+		EndpointWebsocketClient{Address: "wss://127.0.0.1:5601", TLSConfig: clientConf})
 }
 
-func (ch testLoopback) Read(buf []byte) (int, error) {
-	ret, ok := <-ch
-	if !ok {
-		return 0, errorTerminated
+func TestWebsocketCheckOrigin(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		origin  string
+		allowed []string
+		ok      bool
+	}{
+		{"no origin", "", nil, true},
+		{"same origin", "http://127.0.0.1:5601", nil, true},
+		{"cross origin", "http://example.com", nil, false},
+		{"allowed origin", "http://example.com", []string{"http://example.com"}, true},
+		{"any origin", "http://example.com", []string{"*"}, true},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:5601/", nil)
+			if ca.origin != "" {
+				r.Header.Set("Origin", ca.origin)
+			}
+			require.Equal(t, ca.ok, websocketCheckOrigin(ca.allowed)(r))
+		})
 	}
-	n := copy(buf, ret)
-	return n, nil
 }
 
-func (ch testLoopback) Write(buf []byte) (int, error) {
-	ch <- buf
-	return len(buf), nil
-}
+func TestNodeHttpServerClient(t *testing.T) {
+	t.Run("websocket", func(t *testing.T) {
+		doTest(t, EndpointHttpServer{Address: "127.0.0.1:5601", Path: "/mavlink"},
+			EndpointHttpClient{Address: "http://127.0.0.1:5601/mavlink"})
+	})
 
-type testEndpoint struct {
-	io.ReadCloser
-	io.Writer
+	t.Run("long polling", func(t *testing.T) {
+		doTest(t, EndpointHttpServer{Address: "127.0.0.1:5601", Path: "/mavlink"},
+			EndpointHttpClient{Address: "http://127.0.0.1:5601/mavlink", WebsocketDisable: true})
+	})
 }
 
-func TestNodeCustomCustom(t *testing.T) {
-	l1 := make(testLoopback)
-	l2 := make(testLoopback)
-	doTest(t, EndpointCustom{&testEndpoint{l1, l2}},
-		EndpointCustom{&testEndpoint{l2, l1}})
+func TestNodeHttpTlsServerClient(t *testing.T) {
+	serverConf, clientConf := testTLSConfigs(t)
+	doTest(t, EndpointHttpServer{Address: "127.0.0.1:5601", TLSConfig: serverConf},
+		EndpointHttpClient{Address: "https://127.0.0.1:5601", TLSConfig: clientConf, WebsocketDisable: true})
 }
 
-func TestNodeError(t *testing.T) {
-	_, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-		OutVersion:  V2,
-		OutSystemId: 11,
-		Endpoints: []EndpointConf{
-			EndpointUdpServer{"127.0.0.1:5600"},
-			EndpointUdpServer{"127.0.0.1:5600"},
-		},
-		HeartbeatDisable: true,
-	})
-	require.Error(t, err)
+func TestNodeZmqReqRep(t *testing.T) {
+	doTest(t, EndpointZmq{Mode: ZmqRep, Address: "tcp://127.0.0.1:5601"},
+		EndpointZmq{Mode: ZmqReq, Address: "tcp://127.0.0.1:5601"})
 }
 
-func TestNodeCloseInLoop(t *testing.T) {
+func TestNodeZmqPubSub(t *testing.T) {
+	d := &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}}
+
 	node1, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		Dialect:     d,
 		OutVersion:  V2,
-		OutSystemId: 11,
-		Endpoints: []EndpointConf{
-			EndpointUdpServer{"127.0.0.1:5600"},
-		},
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointZmq{
+			Address:      "tcp://127.0.0.1:5601",
+			SubAddresses: []string{"tcp://127.0.0.1:5602"},
+		}},
 		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
+	defer node1.Close()
 
 	node2, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		Dialect:     d,
 		OutVersion:  V2,
 		OutSystemId: 11,
-		Endpoints: []EndpointConf{
-			EndpointUdpClient{"127.0.0.1:5600"},
-		},
+		Endpoints: []EndpointConf{EndpointZmq{
+			Address:      "tcp://*:5602",
+			SubAddresses: []string{"tcp://127.0.0.1:5601"},
+		}},
 		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
+	defer node2.Close()
+
+	// frames published before subscriptions are processed are lost,
+	// therefore they are published until they are received
+	exchange := func(src *Node, dest *Node, m *MessageHeartbeat) {
+		timeout := time.After(5 * time.Second)
+		for {
+			src.WriteMessageAll(m)
+
+			select {
+			case evt := <-dest.Events():
+				if e, ok := evt.(*EventFrame); ok {
+					require.Equal(t, m, e.Message())
+					return
+				}
 
-	go func() {
-		defer node2.Close()
-
-		// wait connection to server
-		time.Sleep(500 * time.Millisecond)
+			case <-src.Events():
 
-		node2.WriteMessageAll(&MessageHeartbeat{
-			Type:           1,
-			Autopilot:      2,
-			BaseMode:       3,
-			CustomMode:     6,
-			SystemStatus:   4,
-			MavlinkVersion: 5,
-		})
-	}()
+			case <-time.After(100 * time.Millisecond):
 
-	for evt := range node1.Events() {
-		if _, ok := evt.(*EventChannelOpen); ok {
-			node1.Close()
+			case <-timeout:
+				t.Fatal("frame not received")
+			}
 		}
 	}
+
+	exchange(node1, node2, &MessageHeartbeat{Type: 1, MavlinkVersion: 3})
+	exchange(node2, node1, &MessageHeartbeat{Type: 2, MavlinkVersion: 3})
 }
 
-func TestNodeWriteMultipleInLoop(t *testing.T) {
-	node1, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+func TestNodeUdpServerClient(t *testing.T) {
+	doTest(t, EndpointUdpServer{Address: "127.0.0.1:5601"}, EndpointUdpClient{Address: "127.0.0.1:5601"})
+}
+
+func TestNodeUdpClientLocalAddress(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5603})
+	require.NoError(t, err)
+	defer server.Close()
+
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
 		OutVersion:  V2,
-		OutSystemId: 11,
-		Endpoints: []EndpointConf{
-			EndpointUdpServer{"127.0.0.1:5600"},
-		},
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointUdpClient{
+			Address:      "127.0.0.1:5603",
+			LocalAddress: "127.0.0.1:5604",
+		}},
 		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
+	defer node.Close()
+
+	// frames written before the socket is created are discarded
+	buf := make([]byte, 1024)
+	for {
+		node.WriteMessageAll(&MessageHeartbeat{})
+
+		server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, addr, err := server.ReadFromUDP(buf)
+		if err == nil {
+			require.Equal(t, 5604, addr.Port)
+			break
+		}
+	}
+}
 
-	node2, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+func TestNodeUdpClientReplyToLastSender(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5603})
+	require.NoError(t, err)
+	defer server.Close()
+
+	// a bridge that replies from another port
+	bridge, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5602})
+	require.NoError(t, err)
+	defer bridge.Close()
+
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
 		OutVersion:  V2,
-		OutSystemId: 11,
-		Endpoints: []EndpointConf{
-			EndpointUdpClient{"127.0.0.1:5600"},
-		},
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointUdpClient{
+			Address:           "127.0.0.1:5603",
+			ReplyToLastSender: true,
+		}},
 		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
+	defer node.Close()
+
+	// frames are written to Address until a frame is received
+	buf := make([]byte, 1024)
+	var clientAddr *net.UDPAddr
+	for {
+		node.WriteMessageAll(&MessageHeartbeat{})
+
+		server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, addr, err := server.ReadFromUDP(buf)
+		if err == nil {
+			clientAddr = addr
+			break
+		}
+	}
 
-	go func() {
-		defer node2.Close()
-
-		// wait connection to server
-		time.Sleep(500 * time.Millisecond)
+	var out bytes.Buffer
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(nil),
+		Writer:      &out,
+		DialectDE:   node.dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 11,
+	})
+	require.NoError(t, err)
+	err = tr.WriteMessage(&MessageHeartbeat{})
+	require.NoError(t, err)
 
-		node2.WriteMessageAll(&MessageHeartbeat{
-			Type:           1,
-			Autopilot:      2,
-			BaseMode:       3,
-			CustomMode:     6,
-			SystemStatus:   4,
-			MavlinkVersion: 5,
-		})
-	}()
+	_, err = bridge.WriteTo(out.Bytes(), clientAddr)
+	require.NoError(t, err)
 
-	for evt := range node1.Events() {
-		if _, ok := evt.(*EventChannelOpen); ok {
-			for i := 0; i < 100; i++ {
-				node1.WriteMessageAll(&MessageHeartbeat{
-					Type:           1,
-					Autopilot:      2,
-					BaseMode:       3,
-					CustomMode:     6,
-					SystemStatus:   4,
-					MavlinkVersion: 5,
-				})
-			}
-			node1.Close()
+	for evt := range node.Events() {
+		if fr, ok := evt.(*EventFrame); ok {
+			require.Equal(t, byte(11), fr.SystemId())
+			break
 		}
 	}
+
+	// frames are written to the last sender
+	node.WriteMessageAll(&MessageHeartbeat{})
+
+	bridge.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, addr, err := bridge.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, clientAddr.Port, addr.Port)
 }
 
-func TestNodeSignature(t *testing.T) {
-	key1 := frame.NewV2Key(bytes.Repeat([]byte("\x4F"), 32))
-	key2 := frame.NewV2Key(bytes.Repeat([]byte("\xA8"), 32))
+func TestNodeUdpServerAllowedSources(t *testing.T) {
+	doTest(t, EndpointUdpServer{Address: "127.0.0.1:5601", AllowedSources: []string{"127.0.0.0/8"}},
+		EndpointUdpClient{Address: "127.0.0.1:5601"})
+}
 
-	var testMsg = &MessageHeartbeat{
-		Type:           7,
-		Autopilot:      5,
-		BaseMode:       4,
-		CustomMode:     3,
-		SystemStatus:   2,
-		MavlinkVersion: 1,
-	}
+func TestNodeUdpServerIdleTimeout(t *testing.T) {
+	d := &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}}
 
 	node1, err := NewNode(NodeConf{
-		Dialect: &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-		Endpoints: []EndpointConf{
-			EndpointUdpServer{"127.0.0.1:5600"},
-		},
+		Dialect:     d,
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointUdpServer{
+			Address:     "127.0.0.1:5601",
+			IdleTimeout: 300 * time.Millisecond,
+		}},
 		HeartbeatDisable: true,
-		InKey:            key2,
-		OutVersion:       V2,
-		OutSystemId:      10,
-		OutKey:           key1,
 	})
 	require.NoError(t, err)
+	defer node1.Close()
 
 	node2, err := NewNode(NodeConf{
-		Dialect: &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-		Endpoints: []EndpointConf{
-			EndpointUdpClient{"127.0.0.1:5600"},
-		},
-		HeartbeatDisable: true,
-		InKey:            key1,
+		Dialect:          d,
 		OutVersion:       V2,
 		OutSystemId:      11,
-		OutKey:           key2,
+		Endpoints:        []EndpointConf{EndpointUdpClient{Address: "127.0.0.1:5601"}},
+		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
-
-	success := false
-	var wg sync.WaitGroup
-	wg.Add(2)
+	defer node2.Close()
 
 	go func() {
-		defer wg.Done()
-		defer node1.Close()
+		for range node2.Events() {
+		}
+	}()
 
-		_, ok := <-node1.Events()
-		if !ok {
+	// send datagrams until the client is seen by the server
+	var ch *Channel
+	for ch == nil {
+		node2.WriteMessageAll(&MessageHeartbeat{})
+
+		select {
+		case evt := <-node1.Events():
+			if e, ok := evt.(*EventChannelOpen); ok {
+				ch = e.Channel
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// the client stops sending datagrams and is removed
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case evt := <-node1.Events():
+			if e, ok := evt.(*EventChannelClose); ok {
+				require.Equal(t, ch, e.Channel)
+				return
+			}
+		case <-timeout:
+			t.Errorf("channel not closed")
 			return
 		}
-	}()
+	}
+}
 
-	go func() {
-		defer wg.Done()
-		defer node2.Close()
+func TestNodeUdpServerMaxClients(t *testing.T) {
+	d := &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}}
 
-		time.Sleep(500 * time.Millisecond)
+	node1, err := NewNode(NodeConf{
+		Dialect:     d,
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointUdpServer{
+			Address:    "127.0.0.1:5601",
+			MaxClients: 1,
+		}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	var clients []*Node
+	for i := 0; i < 2; i++ {
+		node, err := NewNode(NodeConf{
+			Dialect:          d,
+			OutVersion:       V2,
+			OutSystemId:      byte(11 + i),
+			Endpoints:        []EndpointConf{EndpointUdpClient{Address: "127.0.0.1:5601"}},
+			HeartbeatDisable: true,
+		})
+		require.NoError(t, err)
+		defer node.Close()
 
-		node2.WriteMessageAll(testMsg)
+		go func() {
+			for range node.Events() {
+			}
+		}()
 
-		_, ok := <-node2.Events()
-		if !ok {
-			return
+		clients = append(clients, node)
+	}
+
+	frames := make(map[byte]int)
+	timeout := time.After(500 * time.Millisecond)
+	for {
+		for _, c := range clients {
+			c.WriteMessageAll(&MessageHeartbeat{})
 		}
 
-		success = true
-	}()
+		select {
+		case evt := <-node1.Events():
+			if e, ok := evt.(*EventFrame); ok {
+				frames[e.SystemId()]++
+			}
+			continue
 
-	wg.Wait()
+		case <-timeout:
 
-	require.Equal(t, true, success)
+		case <-time.After(50 * time.Millisecond):
+			continue
+		}
+		break
+	}
+
+	require.Equal(t, 1, len(frames))
+	require.Equal(t, 1, len(node1.Channels()))
 }
 
-func TestNodeRouting(t *testing.T) {
-	var testMsg = &MessageHeartbeat{
-		Type:           7,
-		Autopilot:      5,
-		BaseMode:       4,
-		CustomMode:     3,
-		SystemStatus:   2,
-		MavlinkVersion: 1,
-	}
+func TestNodeUdpServerRejectedSources(t *testing.T) {
+	d := &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}}
 
 	node1, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		Dialect:     d,
 		OutVersion:  V2,
 		OutSystemId: 10,
-		Endpoints: []EndpointConf{
-			EndpointUdpClient{"127.0.0.1:5600"},
-		},
+		Endpoints: []EndpointConf{EndpointUdpServer{
+			Address:        "127.0.0.1:5601",
+			AllowedSources: []string{"10.0.0.0/8", "192.168.1.5"},
+		}},
 		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
+	defer node1.Close()
 
 	node2, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-		OutVersion:  V2,
-		OutSystemId: 11,
-		Endpoints: []EndpointConf{
-			EndpointUdpServer{"127.0.0.1:5600"},
-			EndpointUdpClient{"127.0.0.1:5601"},
-		},
+		Dialect:          d,
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointUdpClient{Address: "127.0.0.1:5601"}},
 		HeartbeatDisable: true,
 	})
 	require.NoError(t, err)
+	defer node2.Close()
 
-	node3, err := NewNode(NodeConf{
-		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-		OutVersion:  V2,
-		OutSystemId: 12,
-		Endpoints: []EndpointConf{
-			EndpointUdpServer{"127.0.0.1:5601"},
-		},
-		HeartbeatDisable: true,
-	})
-	require.NoError(t, err)
+	timeout := time.After(500 * time.Millisecond)
+	for {
+		node2.WriteMessageAll(&MessageHeartbeat{})
 
-	// wait client connection
-	time.Sleep(500 * time.Millisecond)
+		select {
+		case evt := <-node1.Events():
+			t.Errorf("unexpected event: %T", evt)
+			return
+
+		case <-timeout:
+			return
+
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestNodeUdpBroadcastBroadcast(t *testing.T) {
+	doTest(t, EndpointUdpBroadcast{BroadcastAddress: "127.255.255.255:5602", LocalAddress: ":5601"},
+		EndpointUdpBroadcast{BroadcastAddress: "127.255.255.255:5601", LocalAddress: ":5602"})
+}
+
+func TestNodeUdpMulticast(t *testing.T) {
+	doTest(t, EndpointUdpMulticast{Address: "239.255.145.50:5605"},
+		EndpointUdpMulticast{Address: "239.255.145.50:5605"})
+}
+
+func TestNodeUdpMulticastRemoteAddr(t *testing.T) {
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointUdpMulticast{Address: "239.255.145.50:5605"}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	conn, err := net.ListenUDP("udp4", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(nil),
+		Writer:      &buf,
+		DialectDE:   node1.dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 11,
+	})
+	require.NoError(t, err)
+	err = tr.WriteMessage(&MessageHeartbeat{})
+	require.NoError(t, err)
+
+	_, err = conn.WriteTo(buf.Bytes(), &net.UDPAddr{IP: net.IPv4(239, 255, 145, 50), Port: 5605})
+	require.NoError(t, err)
+
+	for evt := range node1.Events() {
+		if fr, ok := evt.(*EventFrame); ok {
+			require.Equal(t, byte(11), fr.SystemId())
+			require.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, fr.Channel.RemoteAddr().(*net.UDPAddr).Port)
+			break
+		}
+	}
+}
+
+func TestNodeUdpFanout(t *testing.T) {
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointUdpFanout{
+			Address: "127.0.0.1:5604",
+			RateClasses: []UdpFanoutRateClass{{
+				Sources:      []string{"127.0.0.2"},
+				MaxFrequency: 1,
+			}},
+		}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	newSubscriber := func(ip net.IP) *net.UDPConn {
+		conn, err := net.DialUDP("udp4", &net.UDPAddr{IP: ip},
+			&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5604})
+		require.NoError(t, err)
+
+		// any datagram subscribes
+		_, err = conn.Write([]byte("subscribe"))
+		require.NoError(t, err)
+		return conn
+	}
+
+	var subs []*net.UDPConn
+	for i := 0; i < 3; i++ {
+		sub := newSubscriber(net.IPv4(127, 0, 0, 1))
+		defer sub.Close()
+		subs = append(subs, sub)
+	}
+
+	limited := newSubscriber(net.IPv4(127, 0, 0, 2))
+	defer limited.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		node.WriteMessageAll(&MessageHeartbeat{Type: MAV_TYPE(i)})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	count := func(conn *net.UDPConn) int {
+		n := 0
+		buf := make([]byte, 512)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			_, err := conn.Read(buf)
+			if err != nil {
+				return n
+			}
+			n++
+		}
+	}
+
+	for _, sub := range subs {
+		require.Equal(t, 5, count(sub))
+	}
+	require.Equal(t, 1, count(limited))
+}
+
+func TestNodePipeServerClient(t *testing.T) {
+	address := filepath.Join(os.TempDir(), "gomavlib-test.sock")
+	if runtime.GOOS == "windows" {
+		address = `\\.\pipe\gomavlib-test`
+	}
+
+	doTest(t, EndpointPipeServer{Address: address}, EndpointPipeClient{Address: address})
+}
+
+func TestNodeRfc2217(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:5603")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// the server bridges the remote serial port with a custom endpoint
+	serial, endpoint := net.Pipe()
+	baud := make(chan uint32, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tc := newTelnetConn(conn, func(opt byte, data []byte) {
+			if opt == telnetOptComPort && len(data) == 5 && data[0] == rfc2217SetBaudrate {
+				select {
+				case baud <- binary.BigEndian.Uint32(data[1:]):
+				default:
+				}
+			}
+		})
+
+		go io.Copy(tc, serial)
+		io.Copy(serial, tc)
+	}()
+
+	doTest(t, EndpointRfc2217{Address: "127.0.0.1:5603", Baud: 57600},
+		EndpointCustom{ReadWriteCloser: endpoint})
+
+	require.Equal(t, uint32(57600), <-baud)
+}
+
+func TestNodeControl(t *testing.T) {
+	var mutex sync.Mutex
+	called := make(map[string]struct{})
+	control := func(network, address string, c syscall.RawConn) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		called[network] = struct{}{}
+		return nil
+	}
+
+	doTest(t, EndpointTcpServer{Address: "127.0.0.1:5601", Control: control},
+		EndpointTcpClient{Address: "127.0.0.1:5601", Control: control})
+	doTest(t, EndpointUdpServer{Address: "127.0.0.1:5601", Control: control},
+		EndpointUdpClient{Address: "127.0.0.1:5601", Control: control})
+
+	require.Equal(t, map[string]struct{}{
+		"tcp4": {},
+		"udp4": {},
+	}, called)
+}
+
+type testLoopback chan []byte
+
+func (ch testLoopback) Close() error {
+	close(ch)
+	return nil
+}
+
+func (ch testLoopback) Read(buf []byte) (int, error) {
+	ret, ok := <-ch
+	if !ok {
+		return 0, errorTerminated
+	}
+	n := copy(buf, ret)
+	return n, nil
+}
+
+func (ch testLoopback) Write(buf []byte) (int, error) {
+	// buf is reused by the writer after Write() returns
+	ch <- append([]byte(nil), buf...)
+	return len(buf), nil
+}
+
+type testEndpoint struct {
+	io.ReadCloser
+	io.Writer
+}
+
+func TestNodeCustomCustom(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+	doTest(t, EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+		EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}})
+}
+
+func TestNodeCustomFactory(t *testing.T) {
+	dialectDE, err := dialect.NewDecEncoder(&dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}})
+	require.NoError(t, err)
+
+	conns := make(chan *io.PipeWriter, 2)
+	dials := 0
+
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointCustomFactory{
+			Dial: func() (io.ReadWriteCloser, error) {
+				dials++
+				r, w := io.Pipe()
+				conns <- w
+				return &testEndpoint{r, ioutil.Discard}, nil
+			},
+			Label: "modem",
+		}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	evt := <-node.Events()
+	ee, ok := evt.(*EventChannelOpen)
+	require.Equal(t, true, ok)
+	require.Equal(t, "modem", ee.Channel.String())
+
+	// the connection is lost
+	w := <-conns
+	w.CloseWithError(syscall.EIO)
+
+	// a new connection is created, while the channel stays open
+	w = <-conns
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(nil),
+		Writer:      w,
+		DialectDE:   dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 11,
+	})
+	require.NoError(t, err)
+	go tr.WriteMessage(&MessageHeartbeat{})
+
+	evt = <-node.Events()
+	_, ok = evt.(*EventFrame)
+	require.Equal(t, true, ok)
+	require.Equal(t, 2, dials)
+}
+
+func TestNodeReconnectPolicy(t *testing.T) {
+	_, err := NewNode(NodeConf{
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointCustomFactory{
+			Dial: func() (io.ReadWriteCloser, error) {
+				return nil, fmt.Errorf("unreachable")
+			},
+			Reconnect: ReconnectPolicy{Multiplier: 0.5},
+		}},
+	})
+	require.Error(t, err)
+
+	var mutex sync.Mutex
+	var dials []time.Time
+
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointCustomFactory{
+			Dial: func() (io.ReadWriteCloser, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				dials = append(dials, time.Now())
+				return nil, fmt.Errorf("unreachable")
+			},
+			Reconnect: ReconnectPolicy{
+				InitialDelay: 20 * time.Millisecond,
+				MaxDelay:     80 * time.Millisecond,
+				Multiplier:   2,
+				MaxAttempts:  5,
+			},
+		}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	evt := <-node.Events()
+	_, ok := evt.(*EventChannelOpen)
+	require.Equal(t, true, ok)
+
+	evt = <-node.Events()
+	ee, ok := evt.(*EventEndpointGiveUp)
+	require.Equal(t, true, ok)
+	require.Equal(t, 5, ee.Attempts)
+	require.EqualError(t, ee.Error, "unreachable")
+
+	evt = <-node.Events()
+	_, ok = evt.(*EventChannelClose)
+	require.Equal(t, true, ok)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, 5, len(dials))
+
+	// exponential backoff, bounded by MaxDelay
+	for i, min := range []time.Duration{20, 40, 80, 80} {
+		require.True(t, dials[i+1].Sub(dials[i]) >= min*time.Millisecond)
+	}
+}
+
+func TestNodeSerialReopen(t *testing.T) {
+	ports := make(chan *io.PipeWriter, 2)
+	opens := 0
+
+	origOpen := serialOpen
+	defer func() { serialOpen = origOpen }()
+	serialOpen = func(conf serial.Config, rtsCts bool) (io.ReadWriteCloser, error) {
+		opens++
+
+		// the port has disappeared
+		if opens == 2 {
+			return nil, syscall.ENOENT
+		}
+
+		r, w := io.Pipe()
+		ports <- w
+		return &testEndpoint{r, ioutil.Discard}, nil
+	}
+
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointSerial{Address: "/dev/ttyUSB0:57600"}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	evt := <-node.Events()
+	_, ok := evt.(*EventChannelOpen)
+	require.Equal(t, true, ok)
+
+	// unplug the port
+	w := <-ports
+	w.CloseWithError(syscall.EIO)
+
+	evt = <-node.Events()
+	_, ok = evt.(*EventChannelClose)
+	require.Equal(t, true, ok)
+
+	// the port is reopened
+	evt = <-node.Events()
+	_, ok = evt.(*EventChannelOpen)
+	require.Equal(t, true, ok)
+	<-ports
+}
+
+func TestNodeSerialHotplug(t *testing.T) {
+	var mutex sync.Mutex
+	devices := []SerialDevice{
+		{Path: "/dev/ttyS0"},
+		{Path: "/dev/ttyACM0", VendorId: 0x26ac, ProductId: 0x11},
+	}
+
+	origEnumerate := serialEnumerate
+	defer func() { serialEnumerate = origEnumerate }()
+	serialEnumerate = func() ([]SerialDevice, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]SerialDevice(nil), devices...), nil
+	}
+
+	var opened []string
+
+	origOpen := serialOpen
+	defer func() { serialOpen = origOpen }()
+	serialOpen = func(conf serial.Config, rtsCts bool) (io.ReadWriteCloser, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		opened = append(opened, fmt.Sprintf("%s:%d", conf.Name, conf.Baud))
+		return newTestSerialPort(nil, false), nil
+	}
+
+	node, err := NewNode(NodeConf{
+		Dialect:             &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:          V2,
+		OutSystemId:         10,
+		HeartbeatDisable:    true,
+		SerialHotplugEnable: true,
+		SerialHotplugPeriod: 50 * time.Millisecond,
+		SerialHotplugFilter: func(d SerialDevice) bool {
+			return d.VendorId != 0
+		},
+		SerialHotplugAttach:   true,
+		SerialHotplugBaudRate: 57600,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	// devices found at startup are reported
+	var plugged *EventSerialDevicePlugged
+	opens := 0
+	for plugged == nil || opens == 0 {
+		switch evt := (<-node.Events()).(type) {
+		case *EventSerialDevicePlugged:
+			plugged = evt
+		case *EventChannelOpen:
+			opens++
+		}
+	}
+
+	require.Equal(t, SerialDevice{Path: "/dev/ttyACM0", VendorId: 0x26ac, ProductId: 0x11}, plugged.Device)
+	require.NoError(t, plugged.AttachError)
+	require.Equal(t, "/dev/ttyACM0:57600", plugged.Endpoint.Conf().(EndpointSerial).Address)
+	require.Equal(t, []Endpoint{plugged.Endpoint}, node.Endpoints())
+
+	mutex.Lock()
+	require.Equal(t, []string{"/dev/ttyACM0:57600"}, opened)
+	devices = devices[:1]
+	mutex.Unlock()
+
+	// the endpoint is removed when the device is unplugged
+	for {
+		if evt, ok := (<-node.Events()).(*EventSerialDeviceUnplugged); ok {
+			require.Equal(t, &EventSerialDeviceUnplugged{
+				Device:   plugged.Device,
+				Endpoint: plugged.Endpoint,
+			}, evt)
+			break
+		}
+	}
+	require.Equal(t, 0, len(node.Endpoints()))
+}
+
+func TestNodeSerialLineSettings(t *testing.T) {
+	var opened []serial.Config
+	var rtsCtsOpened []bool
+
+	origOpen := serialOpen
+	defer func() { serialOpen = origOpen }()
+	serialOpen = func(conf serial.Config, rtsCts bool) (io.ReadWriteCloser, error) {
+		opened = append(opened, conf)
+		rtsCtsOpened = append(rtsCtsOpened, rtsCts)
+		return newTestSerialPort(nil, false), nil
+	}
+
+	for _, ca := range []struct {
+		conf EndpointSerial
+		err  string
+	}{
+		{EndpointSerial{Address: "/dev/ttyUSB0:57600", DataBits: 9}, "invalid data bits"},
+		{EndpointSerial{Address: "/dev/ttyUSB0:57600", Parity: ParitySpace + 1}, "invalid parity"},
+		{EndpointSerial{Address: "/dev/ttyUSB0:57600", StopBits: 3}, "invalid stop bits"},
+		{EndpointSerial{Address: "/dev/ttyUSB0"}, "invalid address"},
+	} {
+		_, err := NewNode(NodeConf{
+			Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:       V2,
+			OutSystemId:      10,
+			Endpoints:        []EndpointConf{ca.conf},
+			HeartbeatDisable: true,
+		})
+		require.EqualError(t, err, ca.err)
+	}
+	require.Equal(t, 0, len(opened))
+
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{
+			EndpointSerial{Address: "COM12:57600"},
+			EndpointSerial{
+				Address:  "/dev/ttyS1:115200",
+				DataBits: 7,
+				Parity:   ParityEven,
+				StopBits: 2,
+				RtsCts:   true,
+			},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	node.Close()
+
+	require.Equal(t, []serial.Config{
+		{Name: "COM12", Baud: 57600, Size: 8, Parity: serial.ParityNone, StopBits: serial.Stop1},
+		{Name: "/dev/ttyS1", Baud: 115200, Size: 7, Parity: serial.ParityEven, StopBits: serial.Stop2},
+	}, opened)
+	require.Equal(t, []bool{false, true}, rtsCtsOpened)
+}
+
+type testSerialPort struct {
+	data      []byte
+	timeout   bool
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newTestSerialPort(data []byte, timeout bool) *testSerialPort {
+	return &testSerialPort{data: data, timeout: timeout, closed: make(chan struct{})}
+}
+
+func (p *testSerialPort) Read(buf []byte) (int, error) {
+	if len(p.data) > 0 {
+		n := copy(buf, p.data)
+		p.data = p.data[n:]
+		return n, nil
+	}
+
+	// ports with a read timeout return io.EOF when there's no data
+	if p.timeout {
+		time.Sleep(10 * time.Millisecond)
+		return 0, io.EOF
+	}
+
+	<-p.closed
+	return 0, io.EOF
+}
+
+func (p *testSerialPort) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (p *testSerialPort) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+func TestNodeSerialAutoBaud(t *testing.T) {
+	dialectDE, err := dialect.NewDecEncoder(&dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}})
+	require.NoError(t, err)
+
+	var heartbeat bytes.Buffer
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(nil),
+		Writer:      &heartbeat,
+		DialectDE:   dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 11,
+	})
+	require.NoError(t, err)
+	err = tr.WriteMessage(&MessageHeartbeat{Type: 1})
+	require.NoError(t, err)
+
+	var mutex sync.Mutex
+	var bauds []int
+
+	origOpen := serialOpen
+	defer func() { serialOpen = origOpen }()
+	serialOpen = func(conf serial.Config, rtsCts bool) (io.ReadWriteCloser, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		bauds = append(bauds, conf.Baud)
+
+		if conf.Baud != 115200 {
+			return newTestSerialPort(bytes.Repeat([]byte{0xFD, 0x09, 0x00, 0xAA}, 50), conf.ReadTimeout != 0), nil
+		}
+
+		// a partial frame followed by valid heartbeats
+		data := append([]byte{0xFE, 0x09, 0x01}, heartbeat.Bytes()...)
+		data = append(data, heartbeat.Bytes()...)
+		return newTestSerialPort(data, conf.ReadTimeout != 0), nil
+	}
+
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointSerial{Address: "/dev/ttyUSB0:auto"}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	for evt := range node.Events() {
+		if fr, ok := evt.(*EventFrame); ok {
+			require.Equal(t, &MessageHeartbeat{Type: 1}, fr.Message())
+			break
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, []int{57600, 115200, 115200}, bauds)
+}
+
+func TestNodeFrameReceiveTime(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	before := time.Now()
+	node2.WriteMessageAll(&MessageHeartbeat{})
+
+	for evt := range node1.Events() {
+		if ee, ok := evt.(*EventFrame); ok {
+			after := time.Now()
+			require.False(t, ee.ReceiveTime.Before(before))
+			require.False(t, ee.ReceiveTime.After(after))
+			break
+		}
+	}
+}
+
+func TestNodeVersionAuto(t *testing.T) {
+	for _, ver := range []Version{V1, V2} {
+		t.Run(ver.String(), func(t *testing.T) {
+			l1 := make(testLoopback)
+			l2 := make(testLoopback)
+
+			node1, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				OutVersion:       VAuto,
+				OutSystemId:      10,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer node1.Close()
+
+			node2, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				OutVersion:       ver,
+				OutSystemId:      11,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer node2.Close()
+
+			remote := make(chan *Channel, 1)
+			go func() {
+				for evt := range node1.Events() {
+					if ee, ok := evt.(*EventFrame); ok {
+						remote <- ee.Channel
+						node1.WriteMessageTo(ee.Channel, &MessageHeartbeat{})
+					}
+				}
+			}()
+
+			node2.WriteMessageAll(&MessageHeartbeat{})
+
+			for evt := range node2.Events() {
+				if ee, ok := evt.(*EventFrame); ok {
+					_, isV2 := ee.Frame.(*frame.V2Frame)
+					require.Equal(t, ver == V2, isV2)
+					break
+				}
+			}
+
+			v, ok := (<-remote).RemoteVersion(11)
+			require.Equal(t, true, ok)
+			require.Equal(t, ver, v)
+		})
+	}
+}
+
+func TestNodeVersionDowngrade(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	for _, ver := range []transceiver.Version{transceiver.V2, transceiver.V2, transceiver.V1} {
+		tr, err := transceiver.New(transceiver.TransceiverConf{
+			Reader:         bytes.NewReader(nil),
+			Writer:         l1,
+			DialectDE:      node.dialectDE,
+			OutVersion:     ver,
+			OutSystemId:    11,
+			OutComponentId: 2,
+		})
+		require.NoError(t, err)
+		go tr.WriteMessage(&MessageHeartbeat{})
+
+		var evt Event
+		for {
+			evt = <-node.Events()
+			if _, ok := evt.(*EventChannelOpen); !ok {
+				break
+			}
+		}
+
+		if ver == transceiver.V1 {
+			ee, ok := evt.(*EventVersionDowngrade)
+			require.Equal(t, true, ok)
+			require.Equal(t, byte(11), ee.SystemId)
+			require.Equal(t, byte(2), ee.ComponentId)
+			evt = <-node.Events()
+		}
+
+		ee, ok := evt.(*EventFrame)
+		require.Equal(t, true, ok)
+
+		if ver == transceiver.V1 {
+			require.Equal(t, VersionStats{V1Frames: 1, V2Frames: 2}, ee.Channel.VersionStats())
+		}
+	}
+}
+
+func TestNodeChannelsTraffic(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	node1.WriteMessageAll(&MessageHeartbeat{Type: 1})
+
+	for evt := range node2.Events() {
+		if _, ok := evt.(*EventFrame); ok {
+			break
+		}
+	}
+
+	channels := node1.Channels()
+	require.Equal(t, 1, len(channels))
+
+	// stats of the sender are updated after the frame has been written
+	for channels[0].TrafficStats().BytesOut == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, TrafficStats{BytesOut: 17}, channels[0].TrafficStats())
+
+	channels = node2.Channels()
+	require.Equal(t, 1, len(channels))
+	require.Equal(t, TrafficStats{BytesIn: 17}, channels[0].TrafficStats())
+}
+
+func TestNodeOutValidate(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+		OutValidate:      true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	err = node1.ValidateMessage(&MessageHeartbeat{Type: 300})
+	require.EqualError(t, err, "message HEARTBEAT, field type: value 300 overflows uint8_t")
+
+	err = node1.ValidateMessage(&MessageRequestDataStream{})
+	require.EqualError(t, err, "message cannot be encoded since it is not in the dialect")
+
+	// the invalid message is discarded
+	node1.WriteMessageAll(&MessageHeartbeat{Type: 300})
+
+	err = node1.WriteMessageAllChecked(&MessageHeartbeat{Type: 300})
+	_, ok := err.(*msg.ValidationError)
+	require.Equal(t, true, ok)
+	require.EqualError(t, err, "message HEARTBEAT, field type: value 300 overflows uint8_t")
+
+	err = node1.WriteMessageAllChecked(&MessageHeartbeat{Type: 1})
+	require.NoError(t, err)
+
+	for evt := range node2.Events() {
+		if ee, ok := evt.(*EventFrame); ok {
+			require.Equal(t, &MessageHeartbeat{Type: 1}, ee.Message())
+			break
+		}
+	}
+}
+
+func TestNodeOutGuard(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	var guardMutex sync.Mutex
+	var guarded []msg.Message
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+		OutGuard: func(m msg.Message) bool {
+			guardMutex.Lock()
+			defer guardMutex.Unlock()
+			guarded = append(guarded, m)
+			return m.(*MessageHeartbeat).Type != 2
+		},
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	// blocked messages and frames are discarded
+	node1.WriteMessageAll(&MessageHeartbeat{Type: 2})
+	err = node1.WriteMessageAllChecked(&MessageHeartbeat{Type: 2})
+	require.Equal(t, ErrMessageGuarded, err)
+	node1.WriteFrameAll(&frame.V2Frame{SystemId: 12, ComponentId: 1, Message: &MessageHeartbeat{Type: 2}})
+	node1.WriteMessageAll(&MessageHeartbeat{Type: 1})
+
+	for evt := range node2.Events() {
+		if ee, ok := evt.(*EventFrame); ok {
+			require.Equal(t, &MessageHeartbeat{Type: 1}, ee.Message())
+			break
+		}
+	}
+
+	guardMutex.Lock()
+	defer guardMutex.Unlock()
+	require.Equal(t, []msg.Message{
+		&MessageHeartbeat{Type: 2},
+		&MessageHeartbeat{Type: 2},
+		&MessageHeartbeat{Type: 2},
+		&MessageHeartbeat{Type: 1},
+	}, guarded)
+}
+
+func TestNodeVersionAutoTarget(t *testing.T) {
+	ch := &Channel{
+		n:              &Node{conf: NodeConf{OutVersion: VAuto}},
+		remoteVersions: make(map[byte]Version),
+		lastVersions:   make(map[byte]Version),
+	}
+
+	require.Equal(t, V1, ch.outVersion(&MessageRequestDataStream{TargetSystem: 1}))
+
+	ch.onFrameVersion(&frame.V1Frame{SystemId: 1, Message: &MessageHeartbeat{}})
+	ch.onFrameVersion(&frame.V2Frame{SystemId: 2, Message: &MessageHeartbeat{}})
+
+	require.Equal(t, V1, ch.outVersion(&MessageRequestDataStream{TargetSystem: 1}))
+	require.Equal(t, V2, ch.outVersion(&MessageRequestDataStream{TargetSystem: 2}))
+	require.Equal(t, V2, ch.outVersion(&MessageHeartbeat{}))
+
+	// versions are never downgraded
+	ch.onFrameVersion(&frame.V1Frame{SystemId: 2, Message: &MessageHeartbeat{}})
+	require.Equal(t, V2, ch.outVersion(&MessageRequestDataStream{TargetSystem: 2}))
+}
+
+func TestNodeVersionAutoV2Only(t *testing.T) {
+	ch := &Channel{
+		n:              &Node{conf: NodeConf{OutVersion: VAuto}},
+		remoteVersions: make(map[byte]Version),
+		lastVersions:   make(map[byte]Version),
+	}
+
+	require.Equal(t, V1, ch.outVersion(&MessageHeartbeat{}))
+	require.Equal(t, V2, ch.outVersion(&msg.MessageRaw{Id: 300}))
+}
+
+func TestNodeValidateMessageV2Only(t *testing.T) {
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V1,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{make(testLoopback), make(testLoopback)}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	err = node.ValidateMessage(&msg.MessageRaw{Id: 300})
+	require.Equal(t, frame.ErrV2OnlyMessage, err)
+
+	err = node.ValidateMessage(&MessageHeartbeat{Type: 1})
+	require.NoError(t, err)
+}
+
+func TestNodeWriteMessageV2Only(t *testing.T) {
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V1,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{make(testLoopback), make(testLoopback)}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// the id is checked even if OutValidate is not set
+	err = node.WriteMessageAllChecked(&msg.MessageRaw{Id: 300})
+	require.Equal(t, frame.ErrV2OnlyMessage, err)
+}
+
+func TestNodeWriteErrors(t *testing.T) {
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{make(testLoopback), make(testLoopback)}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	// the message is not in the dialect, therefore it can't be encoded
+	node.WriteMessageAll(&MessageTimesync{Tc1: 1})
+
+	ch := node.Channels()[0]
+	for ch.TrafficStats().WriteErrors == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, TrafficStats{WriteErrors: 1}, ch.TrafficStats())
+}
+
+func TestNodeWriteMessageToExcept(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+	l3 := make(testLoopback)
+	l4 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{
+			EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+			EndpointCustom{ReadWriteCloser: &testEndpoint{l3, l4}},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	newRemote := func(id byte, endpoint EndpointConf) *Node {
+		node, err := NewNode(NodeConf{
+			Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:       V2,
+			OutSystemId:      id,
+			Endpoints:        []EndpointConf{endpoint},
+			HeartbeatDisable: true,
+		})
+		require.NoError(t, err)
+		return node
+	}
+
+	node2 := newRemote(11, EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}})
+	defer node2.Close()
+
+	node3 := newRemote(12, EndpointCustom{ReadWriteCloser: &testEndpoint{l4, l3}})
+	defer node3.Close()
+
+	received := func(node *Node) chan *MessageHeartbeat {
+		ch := make(chan *MessageHeartbeat, 10)
+		go func() {
+			for evt := range node.Events() {
+				if ee, ok := evt.(*EventFrame); ok {
+					ch <- ee.Message().(*MessageHeartbeat)
+				}
+			}
+		}()
+		return ch
+	}
+
+	recv2 := received(node2)
+	recv3 := received(node3)
+
+	node2.WriteMessageAll(&MessageHeartbeat{})
+	node3.WriteMessageAll(&MessageHeartbeat{})
+
+	// find the channels of the remote nodes
+	channels := make(map[byte]*Channel)
+	for evt := range node1.Events() {
+		if ee, ok := evt.(*EventFrame); ok {
+			channels[ee.SystemId()] = ee.Channel
+			if len(channels) == 2 {
+				break
+			}
+		}
+	}
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	node1.WriteMessageExcept(channels[11], &MessageHeartbeat{Type: 1})
+	require.Equal(t, MAV_TYPE(1), (<-recv3).Type)
+
+	node1.WriteMessageTo(channels[11], &MessageHeartbeat{Type: 2})
+	require.Equal(t, MAV_TYPE(2), (<-recv2).Type)
+
+	select {
+	case <-recv3:
+		t.Errorf("unexpected message")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNodeWriteMessageToClosed(t *testing.T) {
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointTcpServer{Address: "127.0.0.1:5600"}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointTcpClient{Address: "127.0.0.1:5600"}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	var ch *Channel
+	for evt := range node1.Events() {
+		if ee, ok := evt.(*EventChannelOpen); ok {
+			ch = ee.Channel
+			node2.Close()
+		}
+		if _, ok := evt.(*EventChannelClose); ok {
+			break
+		}
+	}
+
+	// writing to a closed channel must not stop the node
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		node1.WriteMessageTo(ch, &MessageHeartbeat{})
+		node1.WriteMessageAll(&MessageHeartbeat{})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Errorf("node stopped")
+	}
+}
+
+func TestNodeWriteOrder(t *testing.T) {
+	for _, queueSize := range []int{0, 8} {
+		t.Run(strconv.Itoa(queueSize), func(t *testing.T) {
+			l1 := make(testLoopback)
+			l2 := make(testLoopback)
+
+			node1, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				OutVersion:       V2,
+				OutSystemId:      10,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+				HeartbeatDisable: true,
+				WriteQueueSize:   queueSize,
+			})
+			require.NoError(t, err)
+			defer node1.Close()
+
+			node2, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				OutVersion:       V2,
+				OutSystemId:      11,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer node2.Close()
+
+			go func() {
+				for range node1.Events() {
+				}
+			}()
+
+			const writers = 4
+			const count = 50
+
+			// each writer writes an increasing sequence
+			for i := 0; i < writers; i++ {
+				go func(i int) {
+					for j := 0; j < count; j++ {
+						node1.WriteMessageAll(&MessageHeartbeat{
+							Type:       MAV_TYPE(i),
+							CustomMode: uint32(j),
+						})
+					}
+				}(i)
+			}
+
+			next := make(map[MAV_TYPE]uint32)
+			received := 0
+			for evt := range node2.Events() {
+				if ee, ok := evt.(*EventFrame); ok {
+					m := ee.Message().(*MessageHeartbeat)
+					require.Equal(t, next[m.Type], m.CustomMode)
+					next[m.Type]++
+
+					received++
+					if received == writers*count {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+type testTapBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *testTapBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *testTapBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Len()
+}
+
+func TestNodeTap(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	require.Equal(t, 1, len(node1.Endpoints()))
+
+	var in testTapBuffer
+	var out testTapBuffer
+	removeIn := node1.AddTap(node1.Endpoints()[0], TapWriter(&in, TapIn))
+	removeOut := node1.AddTap(node1.Endpoints()[0], TapWriter(&out, TapOut))
+
+	recv := func() *EventFrame {
+		for evt := range node1.Events() {
+			if fr, ok := evt.(*EventFrame); ok {
+				return fr
+			}
+		}
+		return nil
+	}
+
+	node2.WriteMessageAll(&MessageHeartbeat{Type: 1})
+	fr := recv()
+
+	// received bytes contain the received frame
+	require.Equal(t, byte(11), fr.SystemId())
+	require.True(t, in.Len() > 0)
+	require.Equal(t, 0, out.Len())
+
+	inLen := in.Len()
+	node1.WriteMessageAll(&MessageHeartbeat{Type: 2})
+	for i := 0; i < 50 && out.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, inLen, in.Len())
+	require.True(t, out.Len() > 0)
+
+	// written bytes are a valid frame
+	out.mutex.Lock()
+	raw := append([]byte(nil), out.buf.Bytes()...)
+	out.mutex.Unlock()
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(raw),
+		Writer:      ioutil.Discard,
+		DialectDE:   node1.dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 1,
+	})
+	require.NoError(t, err)
+	f, err := tr.Read()
+	require.NoError(t, err)
+	require.Equal(t, byte(10), f.GetSystemId())
+
+	removeIn()
+	removeOut()
+
+	node2.WriteMessageAll(&MessageHeartbeat{Type: 3})
+	recv()
+	require.Equal(t, inLen, in.Len())
+}
+
+func TestNodeAddRemoveEndpoint(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	events := make(chan Event, 10)
+	go func() {
+		for evt := range node1.Events() {
+			events <- evt
+		}
+	}()
+
+	e, err := node1.AddEndpoint(EndpointTcpServer{Address: "127.0.0.1:5613"})
+	require.NoError(t, err)
+	require.Equal(t, []Endpoint{node1.Endpoints()[0], e}, node1.Endpoints())
+
+	node2, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointTcpClient{Address: "127.0.0.1:5613"}},
+		HeartbeatDisable: false,
+		HeartbeatPeriod:  100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	waitEvent := func(cond func(evt Event) bool) {
+		for {
+			select {
+			case evt := <-events:
+				if cond(evt) {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("event not received")
+			}
+		}
+	}
+
+	waitEvent(func(evt Event) bool {
+		fr, ok := evt.(*EventFrame)
+		return ok && fr.Channel.Endpoint == e && fr.SystemId() == 11
+	})
+
+	err = node1.RemoveEndpoint(e)
+	require.NoError(t, err)
+
+	waitEvent(func(evt Event) bool {
+		ec, ok := evt.(*EventChannelClose)
+		return ok && ec.Channel.Endpoint == e
+	})
+
+	require.Equal(t, 1, len(node1.Endpoints()))
+
+	err = node1.RemoveEndpoint(e)
+	require.Error(t, err)
+}
+
+func TestNodeSequenceStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sequences.json")
+
+	key := frame.NewV2Key(bytes.Repeat([]byte("\x4F"), 32))
+
+	run := func(count int) []*frame.V2Frame {
+		store, err := NewFileSequenceStore(path)
+		require.NoError(t, err)
+
+		l1 := make(testLoopback)
+		l2 := make(testLoopback)
+
+		node1, err := NewNode(NodeConf{
+			Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:       V2,
+			OutSystemId:      10,
+			OutKey:           key,
+			Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+			HeartbeatDisable: true,
+			SequenceStore:    store,
+		})
+		require.NoError(t, err)
+
+		node2, err := NewNode(NodeConf{
+			Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:       V2,
+			OutSystemId:      11,
+			InKey:            key,
+			Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+			HeartbeatDisable: true,
+		})
+		require.NoError(t, err)
+		defer node2.Close()
+
+		go func() {
+			for range node1.Events() {
+			}
+		}()
+
+		var frames []*frame.V2Frame
+		for i := 0; i < count; i++ {
+			node1.WriteMessageAll(&MessageHeartbeat{Type: 1})
+			for evt := range node2.Events() {
+				if fr, ok := evt.(*EventFrame); ok {
+					frames = append(frames, fr.Frame.(*frame.V2Frame))
+					break
+				}
+			}
+		}
+
+		// the state is saved when the node is closed
+		node1.Close()
+		return frames
+	}
+
+	frames := run(3)
+	require.Equal(t, byte(0), frames[0].SequenceId)
+	require.Equal(t, byte(2), frames[2].SequenceId)
+
+	store, err := NewFileSequenceStore(path)
+	require.NoError(t, err)
+	st, ok := store.Load("10:1:custom")
+	require.Equal(t, true, ok)
+	require.Equal(t, byte(3), st.SequenceId)
+	require.Equal(t, frames[2].SignatureTimestamp, st.SignatureTimestamp)
+
+	// sequence ids and signature timestamps are resumed after a restart
+	resumed := run(1)
+	require.Equal(t, byte(3), resumed[0].SequenceId)
+	require.True(t, resumed[0].SignatureTimestamp > frames[2].SignatureTimestamp)
+}
+
+func TestNodeEndpointTap(t *testing.T) {
+	dialectDE, err := dialect.NewDecEncoder(&dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}})
+	require.NoError(t, err)
+
+	var heartbeat bytes.Buffer
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(nil),
+		Writer:      &heartbeat,
+		DialectDE:   dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 11,
+	})
+	require.NoError(t, err)
+	err = tr.WriteMessage(&MessageHeartbeat{Type: 1})
+	require.NoError(t, err)
+
+	_, err = NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointTap{
+			Endpoint: EndpointCustom{ReadWriteCloser: &testEndpoint{make(testLoopback), ioutil.Discard}},
+		}},
+	})
+	require.EqualError(t, err, "In or Out must be provided")
+
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	var in testTapBuffer
+	var out testTapBuffer
+	conf := EndpointTap{
+		Endpoint: EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+		In:       &in,
+		Out:      &out,
+	}
+
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{conf},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	require.Equal(t, conf, node.Endpoints()[0].Conf())
+
+	frames := make(chan *EventFrame)
+	go func() {
+		for evt := range node.Events() {
+			if fr, ok := evt.(*EventFrame); ok {
+				frames <- fr
+			}
+		}
+	}()
+
+	// bytes that can't be decoded are captured too
+	l1 <- []byte{0x01, 0x02, 0x03}
+	l1 <- heartbeat.Bytes()
+
+	fr := <-frames
+	require.Equal(t, &MessageHeartbeat{Type: 1}, fr.Message())
+
+	in.mutex.Lock()
+	require.Equal(t, append([]byte{0x01, 0x02, 0x03}, heartbeat.Bytes()...), in.buf.Bytes())
+	in.mutex.Unlock()
+
+	node.WriteMessageAll(&MessageHeartbeat{Type: 2})
+	written := <-l2
+
+	// the tap is called after the bytes have been written
+	for out.Len() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out.mutex.Lock()
+	require.Equal(t, written, out.buf.Bytes())
+	out.mutex.Unlock()
+}
+
+func TestNodeEndpointTapAdded(t *testing.T) {
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{make(testLoopback), ioutil.Discard}}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	// the tap works at any nesting level
+	var out testTapBuffer
+	e, err := node.AddEndpoint(EndpointImpaired{Endpoint: EndpointTap{
+		Endpoint: EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+		Out:      &out,
+	}})
+	require.NoError(t, err)
+
+	node.WriteMessageAll(&MessageHeartbeat{Type: 2})
+	written := <-l2
+
+	// the tap is called after the bytes have been written
+	for out.Len() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out.mutex.Lock()
+	require.Equal(t, written, out.buf.Bytes())
+	out.mutex.Unlock()
+
+	// taps are removed with the endpoint
+	err = node.RemoveEndpoint(e)
+	require.NoError(t, err)
+
+	node.tapsMutex.RLock()
+	require.Equal(t, 0, len(node.taps))
+	node.tapsMutex.RUnlock()
+}
+
+func TestNodeEndpointTapServerClient(t *testing.T) {
+	var server testTapBuffer
+	var client testTapBuffer
+	doTest(t, EndpointTap{
+		Endpoint: EndpointTcpServer{Address: "127.0.0.1:5601"},
+		In:       &server,
+		Out:      &server,
+	}, EndpointTap{
+		Endpoint: EndpointTcpClient{Address: "127.0.0.1:5601"},
+		In:       &client,
+		Out:      &client,
+	})
+
+	// both sides have seen the four frames exchanged
+	require.Equal(t, server.Len(), client.Len())
+	require.True(t, server.Len() > 0)
+}
+
+func TestNodeImpairedServerClient(t *testing.T) {
+	// frames in flight are discarded when the endpoint is closed, therefore
+	// the server, that is closed right after writing, has no output impairment
+	doTest(t, EndpointImpaired{
+		Endpoint: EndpointUdpServer{Address: "127.0.0.1:5601"},
+		In:       LinkImpairment{Delay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond},
+	}, EndpointImpaired{
+		Endpoint: EndpointUdpClient{Address: "127.0.0.1:5601"},
+		Out:      LinkImpairment{Delay: 10 * time.Millisecond, Bandwidth: 10000},
+	})
+}
+
+func TestNodeImpaired(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		imp      LinkImpairment
+		received bool
+	}{
+		{"delay", LinkImpairment{Delay: 200 * time.Millisecond}, true},
+		{"loss", LinkImpairment{Loss: 1}, false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			l1 := make(testLoopback)
+			l2 := make(testLoopback)
+
+			node1, err := NewNode(NodeConf{
+				Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				OutVersion:  V2,
+				OutSystemId: 10,
+				Endpoints: []EndpointConf{EndpointImpaired{
+					Endpoint: EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+					Out:      ca.imp,
+				}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer node1.Close()
+
+			node2, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				OutVersion:       V2,
+				OutSystemId:      11,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer node2.Close()
+
+			go func() {
+				for range node1.Events() {
+				}
+			}()
+
+			received := make(chan time.Time)
+			go func() {
+				for evt := range node2.Events() {
+					if fr, ok := evt.(*EventFrame); ok {
+						received <- fr.ReceiveTime
+					}
+				}
+			}()
+
+			before := time.Now()
+			node1.WriteMessageAll(&MessageHeartbeat{})
+
+			select {
+			case rt := <-received:
+				require.Equal(t, true, ca.received)
+				require.True(t, rt.Sub(before) >= ca.imp.Delay)
+
+			case <-time.After(500 * time.Millisecond):
+				require.Equal(t, false, ca.received)
+			}
+		})
+	}
+}
+
+type testCanSocket struct {
+	in        chan canFrame
+	out       chan canFrame
+	terminate chan struct{}
+}
+
+func newTestCanSocket(in chan canFrame, out chan canFrame) *testCanSocket {
+	return &testCanSocket{in, out, make(chan struct{})}
+}
+
+func (s *testCanSocket) readFrame() (canFrame, error) {
+	select {
+	case f := <-s.in:
+		return f, nil
+	case <-s.terminate:
+		return canFrame{}, io.EOF
+	}
+}
+
+func (s *testCanSocket) writeFrame(f canFrame) error {
+	select {
+	case s.out <- f:
+		return nil
+	case <-s.terminate:
+		return io.EOF
+	}
+}
+
+func (s *testCanSocket) Close() error {
+	close(s.terminate)
+	return nil
+}
+
+func TestNodeCan(t *testing.T) {
+	c1 := make(chan canFrame, 64)
+	c2 := make(chan canFrame, 64)
+
+	// observe the frames on the bus
+	bus := make(chan canFrame, 64)
+	go func() {
+		for f := range c1 {
+			bus <- f
+			c2 <- f
+		}
+	}()
+
+	node1, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointCustom{ReadWriteCloser: newEndpointCan(
+			EndpointCan{Interface: "can0", NodeId: 10, Channel: 2},
+			newTestCanSocket(make(chan canFrame), c1))}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{EndpointCustom{ReadWriteCloser: newEndpointCan(
+			EndpointCan{Interface: "can0", NodeId: 11, Channel: 2},
+			newTestCanSocket(c2, make(chan canFrame)))}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	msg := &MessageHeartbeat{
+		Type:           1,
+		Autopilot:      2,
+		BaseMode:       3,
+		CustomMode:     6,
+		SystemStatus:   4,
+		MavlinkVersion: 5,
+	}
+	node1.WriteMessageAll(msg)
+
+	for evt := range node2.Events() {
+		if fr, ok := evt.(*EventFrame); ok {
+			require.Equal(t, msg, fr.Message())
+			break
+		}
+	}
+
+	// a v2 heartbeat (21 bytes) plus protocol and channel requires 4 frames
+	toggle := false
+	for i := 0; i < 4; i++ {
+		f := <-bus
+		require.Equal(t, uint32(canPriority<<24|canTunnelDataTypeId<<8|10), f.id)
+		tail := f.data[len(f.data)-1]
+		require.Equal(t, i == 0, (tail&canTailStart) != 0)
+		require.Equal(t, i == 3, (tail&canTailEnd) != 0)
+		require.Equal(t, toggle, (tail&canTailToggle) != 0)
+		toggle = !toggle
+	}
+}
+
+func TestNodeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flight.tlog")
+
+	func() {
+		node, err := NewNode(NodeConf{
+			Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:       V2,
+			OutSystemId:      10,
+			Endpoints:        []EndpointConf{EndpointFileWriter{Path: path}},
+			HeartbeatDisable: true,
+		})
+		require.NoError(t, err)
+		defer node.Close()
+
+		for i := 0; i < 3; i++ {
+			if i != 0 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			node.WriteMessageAll(&MessageHeartbeat{Type: MAV_TYPE(i)})
+		}
+
+		// wait for the last frame to be written
+		time.Sleep(50 * time.Millisecond)
+	}()
+
+	node, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      11,
+		Endpoints:        []EndpointConf{EndpointFileReader{Path: path, Speed: 2}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	var start time.Time
+	i := 0
+	for evt := range node.Events() {
+		switch ee := evt.(type) {
+		case *EventFrame:
+			require.Equal(t, byte(10), ee.SystemId())
+			require.Equal(t, &MessageHeartbeat{Type: MAV_TYPE(i)}, ee.Message())
+			if i == 0 {
+				start = time.Now()
+			}
+			i++
+
+		case *EventChannelClose:
+			require.Equal(t, 3, i)
+
+			// 400ms of recording replayed at speed 2
+			elapsed := time.Since(start)
+			require.True(t, elapsed >= 150*time.Millisecond, elapsed)
+			require.True(t, elapsed < 350*time.Millisecond, elapsed)
+			return
+		}
+	}
+}
+
+func TestNodeError(t *testing.T) {
+	_, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.Error(t, err)
+}
+
+type MessageInvalid struct {
+	A int
+}
+
+func (*MessageInvalid) GetId() uint32 {
+	return 200
+}
+
+func TestNodeErrorInvalidMessage(t *testing.T) {
+	// messages are processed on first use
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}, &MessageInvalid{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	go func() {
+		for range node.Events() {
+		}
+	}()
+
+	err = node.WriteMessageAllChecked(&MessageInvalid{})
+	require.Error(t, err)
+
+	err = node.WriteMessageAllChecked(&MessageHeartbeat{})
+	require.NoError(t, err)
+}
+
+func TestNodeCloseInLoop(t *testing.T) {
+	node1, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	node2, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpClient{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		defer node2.Close()
+
+		// wait connection to server
+		time.Sleep(500 * time.Millisecond)
+
+		node2.WriteMessageAll(&MessageHeartbeat{
+			Type:           1,
+			Autopilot:      2,
+			BaseMode:       3,
+			CustomMode:     6,
+			SystemStatus:   4,
+			MavlinkVersion: 5,
+		})
+	}()
+
+	for evt := range node1.Events() {
+		if _, ok := evt.(*EventChannelOpen); ok {
+			node1.Close()
+		}
+	}
+}
+
+func TestNodeWriteMultipleInLoop(t *testing.T) {
+	node1, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	node2, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpClient{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		defer node2.Close()
+
+		// wait connection to server
+		time.Sleep(500 * time.Millisecond)
+
+		node2.WriteMessageAll(&MessageHeartbeat{
+			Type:           1,
+			Autopilot:      2,
+			BaseMode:       3,
+			CustomMode:     6,
+			SystemStatus:   4,
+			MavlinkVersion: 5,
+		})
+	}()
+
+	for evt := range node1.Events() {
+		if _, ok := evt.(*EventChannelOpen); ok {
+			for i := 0; i < 100; i++ {
+				node1.WriteMessageAll(&MessageHeartbeat{
+					Type:           1,
+					Autopilot:      2,
+					BaseMode:       3,
+					CustomMode:     6,
+					SystemStatus:   4,
+					MavlinkVersion: 5,
+				})
+			}
+			node1.Close()
+		}
+	}
+}
+
+func TestNodeSignature(t *testing.T) {
+	key1 := frame.NewV2Key(bytes.Repeat([]byte("\x4F"), 32))
+	key2 := frame.NewV2Key(bytes.Repeat([]byte("\xA8"), 32))
+
+	var testMsg = &MessageHeartbeat{
+		Type:           7,
+		Autopilot:      5,
+		BaseMode:       4,
+		CustomMode:     3,
+		SystemStatus:   2,
+		MavlinkVersion: 1,
+	}
+
+	node1, err := NewNode(NodeConf{
+		Dialect: &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+		InKey:            key2,
+		OutVersion:       V2,
+		OutSystemId:      10,
+		OutKey:           key1,
+	})
+	require.NoError(t, err)
+
+	node2, err := NewNode(NodeConf{
+		Dialect: &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		Endpoints: []EndpointConf{
+			EndpointUdpClient{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+		InKey:            key1,
+		OutVersion:       V2,
+		OutSystemId:      11,
+		OutKey:           key2,
+	})
+	require.NoError(t, err)
+
+	success := false
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer node1.Close()
+
+		_, ok := <-node1.Events()
+		if !ok {
+			return
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer node2.Close()
+
+		time.Sleep(500 * time.Millisecond)
+
+		node2.WriteMessageAll(testMsg)
+
+		_, ok := <-node2.Events()
+		if !ok {
+			return
+		}
+
+		success = true
+	}()
+
+	wg.Wait()
+
+	require.Equal(t, true, success)
+}
+
+func TestNodeSigningDomain(t *testing.T) {
+	internalKey := frame.NewV2Key(bytes.Repeat([]byte("\x4F"), 32))
+	partnerKey := frame.NewV2Key(bytes.Repeat([]byte("\xA8"), 32))
+
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+	l3 := make(testLoopback)
+	l4 := make(testLoopback)
+
+	router, err := NewNode(NodeConf{
+		Dialect: &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		Endpoints: []EndpointConf{
+			EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+			EndpointSigned{
+				Endpoint: EndpointCustom{ReadWriteCloser: &testEndpoint{l3, l4}},
+				Domain: &SigningDomain{
+					InKey:                partnerKey,
+					OutKey:               partnerKey,
+					FirstSignatureLinkId: 5,
+				},
+			},
+		},
+		HeartbeatDisable: true,
+		InKey:            internalKey,
+		OutVersion:       V2,
+		OutSystemId:      10,
+		OutKey:           internalKey,
+	})
+	require.NoError(t, err)
+	defer router.Close()
+
+	newPeer := func(systemId byte, key *frame.V2Key, rwc io.ReadWriteCloser) *Node {
+		peer, err := NewNode(NodeConf{
+			Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+			Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: rwc}},
+			HeartbeatDisable: true,
+			InKey:            key,
+			OutVersion:       V2,
+			OutSystemId:      systemId,
+			OutKey:           key,
+		})
+		require.NoError(t, err)
+		return peer
+	}
+
+	internal := newPeer(11, internalKey, &testEndpoint{l2, l1})
+	defer internal.Close()
+
+	partner := newPeer(12, partnerKey, &testEndpoint{l4, l3})
+	defer partner.Close()
+
+	recv := func(n *Node) *EventFrame {
+		for evt := range n.Events() {
+			if fr, ok := evt.(*EventFrame); ok {
+				return fr
+			}
+		}
+		return nil
+	}
+
+	// frames signed with the key of each side are accepted
+	internal.WriteMessageAll(&MessageHeartbeat{Type: 1})
+	fr := recv(router)
+	require.Equal(t, byte(11), fr.SystemId())
+	require.Equal(t, router.Endpoints()[0], fr.Channel.Endpoint)
+
+	partner.WriteMessageAll(&MessageHeartbeat{Type: 2})
+	fr = recv(router)
+	require.Equal(t, byte(12), fr.SystemId())
+	require.Equal(t, router.Endpoints()[1], fr.Channel.Endpoint)
+	_, ok := fr.Channel.Endpoint.Conf().(EndpointSigned)
+	require.True(t, ok)
+
+	// frames are signed with the key of each side
+	go func() {
+		for range router.Events() {
+		}
+	}()
+	router.WriteMessageAll(&MessageHeartbeat{Type: 3})
+
+	fr = recv(internal)
+	require.Equal(t, byte(10), fr.SystemId())
+
+	fr = recv(partner)
+	require.Equal(t, byte(10), fr.SystemId())
+	require.Equal(t, byte(5), fr.Frame.(*frame.V2Frame).SignatureLinkId)
+}
+
+func TestNodeSigningDomainWrapped(t *testing.T) {
+	partnerKey := frame.NewV2Key(bytes.Repeat([]byte("\xA8"), 32))
+
+	for _, ca := range []string{"tap", "impaired", "tap of impaired"} {
+		t.Run(ca, func(t *testing.T) {
+			l1 := make(testLoopback)
+			l2 := make(testLoopback)
+
+			var conf EndpointConf = EndpointSigned{
+				Endpoint: EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+				Domain: &SigningDomain{
+					InKey:                partnerKey,
+					OutKey:               partnerKey,
+					FirstSignatureLinkId: 5,
+				},
+			}
+
+			switch ca {
+			case "tap":
+				conf = EndpointTap{Endpoint: conf, Out: ioutil.Discard}
+
+			case "impaired":
+				conf = EndpointImpaired{Endpoint: conf}
+
+			case "tap of impaired":
+				conf = EndpointTap{Endpoint: EndpointImpaired{Endpoint: conf}, Out: ioutil.Discard}
+			}
+
+			router, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				Endpoints:        []EndpointConf{conf},
+				HeartbeatDisable: true,
+				OutVersion:       V2,
+				OutSystemId:      10,
+			})
+			require.NoError(t, err)
+			defer router.Close()
+
+			partner, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+				HeartbeatDisable: true,
+				InKey:            partnerKey,
+				OutVersion:       V2,
+				OutSystemId:      12,
+				OutKey:           partnerKey,
+			})
+			require.NoError(t, err)
+			defer partner.Close()
+
+			go func() {
+				for range router.Events() {
+				}
+			}()
+
+			// the partner discards frames that are not signed with its key
+			router.WriteMessageAll(&MessageHeartbeat{Type: 3})
+
+			for evt := range partner.Events() {
+				if fr, ok := evt.(*EventFrame); ok {
+					require.Equal(t, byte(10), fr.SystemId())
+					require.Equal(t, byte(5), fr.Frame.(*frame.V2Frame).SignatureLinkId)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNodeSigningDomainNested(t *testing.T) {
+	d := &SigningDomain{}
+
+	_, err := NewNode(NodeConf{
+		Dialect: &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		Endpoints: []EndpointConf{
+			EndpointSigned{
+				Endpoint: EndpointTap{
+					Endpoint: EndpointSigned{Endpoint: EndpointUdpServer{Address: "127.0.0.1:5614"}, Domain: d},
+					Out:      ioutil.Discard,
+				},
+				Domain: d,
+			},
+		},
+		HeartbeatDisable: true,
+		OutVersion:       V2,
+		OutSystemId:      10,
+	})
+	require.EqualError(t, err, "signed endpoints can't be signed again")
+}
+
+func TestNodeSigningDomainVersion(t *testing.T) {
+	_, err := NewNode(NodeConf{
+		Dialect: &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		Endpoints: []EndpointConf{
+			EndpointSigned{
+				Endpoint: EndpointUdpServer{Address: "127.0.0.1:5614"},
+				Domain:   &SigningDomain{OutKey: frame.NewV2Key(bytes.Repeat([]byte("\x4F"), 32))},
+			},
+		},
+		HeartbeatDisable: true,
+		OutVersion:       V1,
+		OutSystemId:      10,
+	})
+	require.EqualError(t, err, "OutKey requires V2 frames")
+}
+
+func TestNodeMirror(t *testing.T) {
+	for _, ca := range []string{
+		"mirror",
+		"tap of mirror",
+		"impaired of mirror",
+		"tap of impaired of mirror",
+		"mirror of tap",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			l1 := make(testLoopback)
+			l2 := make(testLoopback)
+			l3 := make(testLoopback)
+			l4 := make(testLoopback)
+
+			var conf EndpointConf = EndpointCustom{ReadWriteCloser: &testEndpoint{l3, l4}}
+
+			switch ca {
+			case "mirror":
+				conf = EndpointMirror{Endpoint: conf}
+
+			case "tap of mirror":
+				conf = EndpointTap{Endpoint: EndpointMirror{Endpoint: conf}, Out: ioutil.Discard}
+
+			case "impaired of mirror":
+				conf = EndpointImpaired{Endpoint: EndpointMirror{Endpoint: conf}}
+
+			case "tap of impaired of mirror":
+				conf = EndpointTap{
+					Endpoint: EndpointImpaired{Endpoint: EndpointMirror{Endpoint: conf}},
+					Out:      ioutil.Discard,
+				}
+
+			case "mirror of tap":
+				conf = EndpointMirror{Endpoint: EndpointTap{Endpoint: conf, Out: ioutil.Discard}}
+			}
+
+			router, err := NewNode(NodeConf{
+				Dialect: &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				Endpoints: []EndpointConf{
+					EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}},
+					conf,
+				},
+				HeartbeatDisable: true,
+				OutVersion:       V2,
+				OutSystemId:      10,
+			})
+			require.NoError(t, err)
+			defer router.Close()
+
+			newPeer := func(systemId byte, rwc io.ReadWriteCloser) *Node {
+				peer, err := NewNode(NodeConf{
+					Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+					Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: rwc}},
+					HeartbeatDisable: true,
+					OutVersion:       V2,
+					OutSystemId:      systemId,
+				})
+				require.NoError(t, err)
+				return peer
+			}
+
+			internal := newPeer(11, &testEndpoint{l2, l1})
+			defer internal.Close()
+
+			observer := newPeer(12, &testEndpoint{l4, l3})
+			defer observer.Close()
+
+			recv := func(n *Node) *EventFrame {
+				for evt := range n.Events() {
+					if fr, ok := evt.(*EventFrame); ok {
+						return fr
+					}
+				}
+				return nil
+			}
+
+			// frames of the mirror are discarded
+			observer.WriteMessageAll(&MessageHeartbeat{Type: 1})
+			internal.WriteMessageAll(&MessageHeartbeat{Type: 2})
+
+			fr := recv(router)
+			require.Equal(t, byte(11), fr.SystemId())
+
+			var mirror *Channel
+			for _, ch := range router.Channels() {
+				if ch.Endpoint == router.Endpoints()[1] {
+					mirror = ch
+				}
+			}
+			require.NotNil(t, mirror)
+
+			for mirror.TrafficStats().DroppedFrames == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			require.Equal(t, uint64(1), mirror.TrafficStats().DroppedFrames)
+
+			// frames are written to the mirror
+			go func() {
+				for range router.Events() {
+				}
+			}()
+			router.WriteFrameExcept(fr.Channel, fr.Frame)
+
+			fr = recv(observer)
+			require.Equal(t, byte(11), fr.SystemId())
+			require.Equal(t, &MessageHeartbeat{Type: 2}, fr.Message())
+		})
+	}
+}
+
+func TestNodeMirrorSigned(t *testing.T) {
+	signed := func(e EndpointConf) EndpointConf {
+		return EndpointSigned{Endpoint: e, Domain: &SigningDomain{}}
+	}
+	tap := func(e EndpointConf) EndpointConf {
+		return EndpointTap{Endpoint: e, Out: ioutil.Discard}
+	}
+	mirror := func(e EndpointConf) EndpointConf {
+		return EndpointMirror{Endpoint: e}
+	}
+	base := EndpointUdpServer{Address: "127.0.0.1:5614"}
+
+	for _, ca := range []struct {
+		name string
+		conf EndpointConf
+		err  string
+	}{
+		{"mirror of signed", mirror(signed(base)), "signed endpoints can't be mirrored"},
+		{"mirror of tap of signed", mirror(tap(signed(base))), "signed endpoints can't be mirrored"},
+		{"tap of mirror of signed", tap(mirror(signed(base))), "signed endpoints can't be mirrored"},
+		{"signed of mirror", signed(mirror(base)), "mirror endpoints can't be signed"},
+		{"signed of tap of mirror", signed(tap(mirror(base))), "mirror endpoints can't be signed"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := NewNode(NodeConf{
+				Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+				Endpoints:        []EndpointConf{ca.conf},
+				HeartbeatDisable: true,
+				OutVersion:       V2,
+				OutSystemId:      10,
+			})
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestNodeRouting(t *testing.T) {
+	var testMsg = &MessageHeartbeat{
+		Type:           7,
+		Autopilot:      5,
+		BaseMode:       4,
+		CustomMode:     3,
+		SystemStatus:   2,
+		MavlinkVersion: 1,
+	}
+
+	node1, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{
+			EndpointUdpClient{Address: "127.0.0.1:5600"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	node2, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5600"},
+			EndpointUdpClient{Address: "127.0.0.1:5601"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	node3, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 12,
+		Endpoints: []EndpointConf{
+			EndpointUdpServer{Address: "127.0.0.1:5601"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	// wait client connection
+	time.Sleep(500 * time.Millisecond)
 
 	node1.WriteMessageAll(testMsg)
 
@@ -481,70 +3145,389 @@ func TestNodeRouting(t *testing.T) {
 	}()
 
 	go func() {
-		defer wg.Done()
-
-		for evt := range node3.Events() {
-			switch e := evt.(type) {
-			case *EventFrame:
-				if _, ok := e.Message().(*MessageHeartbeat); !ok ||
-					e.SystemId() != 10 ||
-					e.ComponentId() != 1 {
-					t.Fatal("wrong message received")
-				}
-				success = true
-				return
-			}
+		defer wg.Done()
+
+		for evt := range node3.Events() {
+			switch e := evt.(type) {
+			case *EventFrame:
+				if _, ok := e.Message().(*MessageHeartbeat); !ok ||
+					e.SystemId() != 10 ||
+					e.ComponentId() != 1 {
+					t.Fatal("wrong message received")
+				}
+				success = true
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	node1.Close()
+	node2.Close()
+	node3.Close()
+
+	require.Equal(t, true, success)
+}
+
+func TestNodeHeartbeat(t *testing.T) {
+	success := false
+
+	func() {
+		node1, err := NewNode(NodeConf{
+			Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:  V2,
+			OutSystemId: 10,
+			Endpoints: []EndpointConf{
+				EndpointUdpServer{Address: "127.0.0.1:5600"},
+			},
+			HeartbeatDisable: true,
+		})
+		require.NoError(t, err)
+		defer node1.Close()
+
+		node2, err := NewNode(NodeConf{
+			Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
+			OutVersion:  V2,
+			OutSystemId: 11,
+			Endpoints: []EndpointConf{
+				EndpointUdpClient{Address: "127.0.0.1:5600"},
+			},
+			HeartbeatDisable: false,
+			HeartbeatPeriod:  500 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		defer node2.Close()
+
+		for evt := range node1.Events() {
+			if ee, ok := evt.(*EventFrame); ok {
+				if _, ok = ee.Message().(*MessageHeartbeat); ok {
+					success = true
+					break
+				}
+			}
+		}
+	}()
+
+	require.Equal(t, true, success)
+}
+
+func TestNodePresence(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+		PresenceTimeout:  300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:             &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:          V2,
+		OutSystemId:         11,
+		OutComponentId:      100,
+		Endpoints:           []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatPeriod:     50 * time.Millisecond,
+		HeartbeatSystemType: 30, // MAV_TYPE_CAMERA
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	require.Equal(t, 0, len(node1.Presence()))
+
+	for evt := range node1.Events() {
+		if _, ok := evt.(*EventFrame); ok {
+			break
+		}
+	}
+
+	pr := node1.Presence()
+	require.Equal(t, 1, len(pr))
+	require.Equal(t, byte(11), pr[0].SystemId)
+	require.Equal(t, byte(100), pr[0].ComponentId)
+	require.Equal(t, 30, pr[0].Type)
+	require.Equal(t, 4, pr[0].SystemStatus)
+	require.Equal(t, "custom", pr[0].Channel.String())
+
+	// components are removed when heartbeats stop
+	node2.Close()
+	time.Sleep(400 * time.Millisecond)
+	require.Equal(t, 0, len(node1.Presence()))
+}
+
+// testOnceCloser is a ReadCloser that can be closed multiple times.
+type testOnceCloser struct {
+	io.ReadCloser
+	once sync.Once
+}
+
+func (c *testOnceCloser) Close() error {
+	c.once.Do(func() { c.ReadCloser.Close() })
+	return nil
+}
+
+func TestNodeComponents(t *testing.T) {
+	for _, ca := range []string{"timeout", "channel close"} {
+		t.Run(ca, func(t *testing.T) {
+			testNodeComponents(t, ca)
+		})
+	}
+}
+
+func testNodeComponents(t *testing.T, ca string) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+	r1 := &testOnceCloser{ReadCloser: l1}
+
+	node1, err := NewNode(NodeConf{
+		Dialect:               &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:            V2,
+		OutSystemId:           10,
+		Endpoints:             []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{r1, l2}}},
+		HeartbeatDisable:      true,
+		PresenceTimeout:       300 * time.Millisecond,
+		ComponentEventsEnable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:             &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:          V2,
+		OutSystemId:         11,
+		OutComponentId:      100,
+		Endpoints:           []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatPeriod:     50 * time.Millisecond,
+		HeartbeatSystemType: 30, // MAV_TYPE_CAMERA
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	require.Equal(t, 0, len(node1.Components()))
+
+	// the appearance is emitted before the first heartbeat
+	var ch *Channel
+	for evt := range node1.Events() {
+		if ea, ok := evt.(*EventComponentAppear); ok {
+			ch = ea.Channel
+			require.Equal(t, &EventComponentAppear{
+				Channel:     ch,
+				SystemId:    11,
+				ComponentId: 100,
+				Type:        30,
+				Autopilot:   0,
+			}, ea)
+			break
+		}
+		_, ok := evt.(*EventFrame)
+		require.False(t, ok)
+	}
+
+	for evt := range node1.Events() {
+		if _, ok := evt.(*EventFrame); ok {
+			break
+		}
+	}
+
+	comps := node1.Components()
+	require.Equal(t, 1, len(comps))
+	require.Equal(t, byte(11), comps[0].SystemId)
+	require.Equal(t, byte(100), comps[0].ComponentId)
+	require.Equal(t, 30, comps[0].Type)
+	require.Equal(t, 4, comps[0].SystemStatus)
+	require.Equal(t, []*Channel{ch}, comps[0].Channels)
+
+	// the disappearance is emitted when heartbeats stop or the channel is
+	// closed, and the component appears only once
+	node2.Close()
+	if ca == "channel close" {
+		r1.Close()
+	}
+
+	for evt := range node1.Events() {
+		switch ee := evt.(type) {
+		case *EventComponentAppear:
+			t.Errorf("unexpected appearance")
+
+		case *EventComponentDisappear:
+			require.Equal(t, &EventComponentDisappear{SystemId: 11, ComponentId: 100}, ee)
+			require.Equal(t, 0, len(node1.Components()))
+			return
+		}
+	}
+}
+
+func TestChannelRTT(t *testing.T) {
+	ch := &Channel{}
+	require.Equal(t, time.Duration(0), ch.RTT())
+	require.Equal(t, 1*time.Second, ch.adaptiveTimeout(1*time.Second, 3))
+
+	ch.ReportRTT(800 * time.Millisecond)
+	require.Equal(t, 800*time.Millisecond, ch.RTT())
+
+	ch.ReportRTT(0)
+	require.Equal(t, 800*time.Millisecond, ch.RTT())
+
+	ch.ReportRTT(1600 * time.Millisecond)
+	require.Equal(t, 900*time.Millisecond, ch.RTT())
+
+	require.Equal(t, 2700*time.Millisecond, ch.adaptiveTimeout(1*time.Second, 3))
+	require.Equal(t, 1*time.Second, ch.adaptiveTimeout(1*time.Second, 1))
+	require.Equal(t, 1*time.Second, ch.adaptiveTimeout(1*time.Second, 0))
+}
+
+func TestNodePresenceRTT(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:           &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:        V2,
+		OutSystemId:       10,
+		Endpoints:         []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable:  true,
+		PresenceTimeout:   300 * time.Millisecond,
+		PresenceRTTFactor: 3,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:         &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:      V2,
+		OutSystemId:     11,
+		Endpoints:       []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatPeriod: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	var ch *Channel
+	for evt := range node1.Events() {
+		if fr, ok := evt.(*EventFrame); ok {
+			ch = fr.Channel
+			break
+		}
+	}
+
+	go func() {
+		for range node1.Events() {
 		}
 	}()
 
-	wg.Wait()
-	node1.Close()
+	// the timeout is extended to 3 times the round trip time
+	ch.ReportRTT(250 * time.Millisecond)
+
 	node2.Close()
-	node3.Close()
+	time.Sleep(400 * time.Millisecond)
+	require.Equal(t, 1, len(node1.Presence()))
 
-	require.Equal(t, true, success)
+	time.Sleep(600 * time.Millisecond)
+	require.Equal(t, 0, len(node1.Presence()))
 }
 
-func TestNodeHeartbeat(t *testing.T) {
-	success := false
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	require.Equal(t, time.Duration(0), h.Mean())
+	require.Equal(t, time.Duration(0), h.Quantile(0.5))
 
-	func() {
-		node1, err := NewNode(NodeConf{
-			Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-			OutVersion:  V2,
-			OutSystemId: 10,
-			Endpoints: []EndpointConf{
-				EndpointUdpServer{"127.0.0.1:5600"},
-			},
-			HeartbeatDisable: true,
-		})
-		require.NoError(t, err)
-		defer node1.Close()
+	for i := 0; i < 9; i++ {
+		h.add(3 * time.Microsecond)
+	}
+	h.add(30 * time.Second)
+
+	require.Equal(t, uint64(10), h.Count)
+	require.Equal(t, uint64(9), h.Counts[2])
+	require.Equal(t, uint64(1), h.Counts[len(h.Counts)-1])
+	require.Equal(t, 30*time.Second, h.Max)
+	require.Equal(t, (27*time.Microsecond+30*time.Second)/10, h.Mean())
+	require.Equal(t, 5*time.Microsecond, h.Quantile(0.5))
+	require.Equal(t, 5*time.Microsecond, h.Quantile(0.9))
+	require.Equal(t, 30*time.Second, h.Quantile(0.99))
+}
 
-		node2, err := NewNode(NodeConf{
-			Dialect:     &dialect.Dialect{3, []msg.Message{&MessageHeartbeat{}}},
-			OutVersion:  V2,
-			OutSystemId: 11,
-			Endpoints: []EndpointConf{
-				EndpointUdpClient{"127.0.0.1:5600"},
-			},
-			HeartbeatDisable: false,
-			HeartbeatPeriod:  500 * time.Millisecond,
-		})
-		require.NoError(t, err)
-		defer node2.Close()
+func TestNodeLatencyStats(t *testing.T) {
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	node1, err := NewNode(NodeConf{
+		Dialect:            &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:         V2,
+		OutSystemId:        10,
+		Endpoints:          []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable:   true,
+		LatencyStatsEnable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:            &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:         V2,
+		OutSystemId:        11,
+		Endpoints:          []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable:   true,
+		LatencyStatsEnable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
 
+	channels := make(chan *Channel, 3)
+	go func() {
 		for evt := range node1.Events() {
-			if ee, ok := evt.(*EventFrame); ok {
-				if _, ok = ee.Message().(*MessageHeartbeat); ok {
-					success = true
-					break
-				}
+			if fr, ok := evt.(*EventFrame); ok {
+				channels <- fr.Channel
 			}
 		}
 	}()
 
-	require.Equal(t, true, success)
+	for i := 0; i < 3; i++ {
+		node2.WriteMessageAll(&MessageHeartbeat{Type: 1})
+	}
+
+	var ch *Channel
+	for i := 0; i < 3; i++ {
+		ch = <-channels
+	}
+
+	node1.WriteMessageAll(&MessageHeartbeat{Type: 1})
+
+	// wait for the route of the last frame and for the write
+	time.Sleep(100 * time.Millisecond)
+
+	st := ch.LatencyStats()
+	require.NotEqual(t, uint64(0), st.Read.Count)
+	require.Equal(t, uint64(3), st.Parse.Count)
+	require.Equal(t, uint64(3), st.Decode.Count)
+	require.Equal(t, uint64(3), st.Route.Count)
+	require.Equal(t, uint64(1), st.Write.Count)
+	require.Equal(t, len(st.Route.Bounds)+1, len(st.Route.Counts))
+
+	// statistics are copied
+	st.Route.Counts[0] = 1000
+	require.NotEqual(t, uint64(1000), ch.LatencyStats().Route.Counts[0])
 }
 
 func TestNodeStreamRequest(t *testing.T) {
@@ -559,7 +3542,7 @@ func TestNodeStreamRequest(t *testing.T) {
 			OutVersion:  V2,
 			OutSystemId: 10,
 			Endpoints: []EndpointConf{
-				EndpointUdpServer{"127.0.0.1:5600"},
+				EndpointUdpServer{Address: "127.0.0.1:5600"},
 			},
 			HeartbeatDisable:    true,
 			StreamRequestEnable: true,
@@ -575,7 +3558,7 @@ func TestNodeStreamRequest(t *testing.T) {
 			OutVersion:  V2,
 			OutSystemId: 10,
 			Endpoints: []EndpointConf{
-				EndpointUdpClient{"127.0.0.1:5600"},
+				EndpointUdpClient{Address: "127.0.0.1:5600"},
 			},
 			HeartbeatDisable:       false,
 			HeartbeatPeriod:        500 * time.Millisecond,
@@ -601,3 +3584,510 @@ func TestNodeStreamRequest(t *testing.T) {
 
 	require.Equal(t, true, success)
 }
+
+func TestNodeChannelAccept(t *testing.T) {
+	attempts := 0
+
+	node1, err := NewNode(NodeConf{
+		Dialect:          &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:       V2,
+		OutSystemId:      10,
+		Endpoints:        []EndpointConf{EndpointTcpServer{Address: "127.0.0.1:5603"}},
+		HeartbeatDisable: true,
+		ChannelAccept: func(ch *Channel) (interface{}, bool) {
+			attempts++
+			// reject the first connection
+			if attempts == 1 {
+				return nil, false
+			}
+			return fmt.Sprintf("session%d", attempts), true
+		},
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 11,
+		Endpoints: []EndpointConf{EndpointTcpClient{
+			Address:   "127.0.0.1:5603",
+			Reconnect: ReconnectPolicy{InitialDelay: 100 * time.Millisecond},
+		}},
+		HeartbeatPeriod: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	for evt := range node1.Events() {
+		if e, ok := evt.(*EventFrame); ok {
+			require.Equal(t, "session2", e.Channel.Value())
+			break
+		}
+	}
+
+	require.Equal(t, 2, attempts)
+}
+
+func TestNodeGcsHeartbeatSuppress(t *testing.T) {
+	for _, ca := range []string{"decoded", "raw"} {
+		t.Run(ca, func(t *testing.T) {
+			d := &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}}
+
+			l1 := make(testLoopback)
+			l2 := make(testLoopback)
+			l3 := make(testLoopback)
+			l4 := make(testLoopback)
+
+			source, err := NewNode(NodeConf{
+				Dialect:          d,
+				OutVersion:       V2,
+				OutSystemId:      10,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer source.Close()
+
+			routerDialect := d
+			if ca == "raw" {
+				routerDialect = nil
+			}
+
+			router, err := NewNode(NodeConf{
+				Dialect:     routerDialect,
+				OutVersion:  V2,
+				OutSystemId: 11,
+				Endpoints: []EndpointConf{
+					EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}},
+					EndpointCustom{ReadWriteCloser: &testEndpoint{l3, l4}},
+				},
+				HeartbeatDisable: true,
+				GcsHeartbeatSuppress: func(ch *Channel) bool {
+					return true
+				},
+			})
+			require.NoError(t, err)
+			defer router.Close()
+
+			dest, err := NewNode(NodeConf{
+				Dialect:          d,
+				OutVersion:       V2,
+				OutSystemId:      12,
+				Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l4, l3}}},
+				HeartbeatDisable: true,
+			})
+			require.NoError(t, err)
+			defer dest.Close()
+
+			go func() {
+				for range source.Events() {
+				}
+			}()
+
+			go func() {
+				for evt := range router.Events() {
+					if e, ok := evt.(*EventFrame); ok {
+						router.WriteFrameExcept(e.Channel, e.Frame)
+					}
+				}
+			}()
+
+			source.WriteMessageAll(&MessageHeartbeat{Type: 6, MavlinkVersion: 3})
+			source.WriteMessageAll(&MessageHeartbeat{Type: 2, MavlinkVersion: 3})
+
+			for evt := range dest.Events() {
+				if e, ok := evt.(*EventFrame); ok {
+					require.Equal(t, byte(10), e.SystemId())
+					require.Equal(t, &MessageHeartbeat{Type: 2, MavlinkVersion: 3}, e.Message())
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestNodeSitlHelper is the SITL spawned by TestNodeSitl. It sends heartbeats
+// that contain its process id, then simulates a crash.
+func TestNodeSitlHelper(t *testing.T) {
+	if os.Getenv("GOMAVLIB_TEST_SITL") != "1" {
+		return
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:5603")
+	if err != nil {
+		os.Exit(2)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		os.Exit(2)
+	}
+
+	dialectDE, err := dialect.NewDecEncoder(&dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}})
+	if err != nil {
+		os.Exit(2)
+	}
+
+	tr, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      conn,
+		Writer:      conn,
+		DialectDE:   dialectDE,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 1,
+	})
+	if err != nil {
+		os.Exit(2)
+	}
+
+	for i := 0; i < 3; i++ {
+		tr.WriteMessage(&MessageHeartbeat{CustomMode: uint32(os.Getpid())})
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	os.Exit(1)
+}
+
+func TestNodeSitl(t *testing.T) {
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{EndpointSitl{
+			Path:      os.Args[0],
+			Args:      []string{"-test.run=^TestNodeSitlHelper$"},
+			Env:       []string{"GOMAVLIB_TEST_SITL=1"},
+			Address:   "127.0.0.1:5603",
+			Reconnect: ReconnectPolicy{InitialDelay: 100 * time.Millisecond},
+		}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	// the process is restarted after the crash
+	var pids []int
+	for evt := range node.Events() {
+		if e, ok := evt.(*EventFrame); ok {
+			pid := int(e.Message().(*MessageHeartbeat).CustomMode)
+			if len(pids) == 0 || pids[len(pids)-1] != pid {
+				pids = append(pids, pid)
+			}
+			if len(pids) == 2 {
+				break
+			}
+		}
+	}
+
+	// the process is killed when the node is closed
+	node.Close()
+
+	for _, pid := range pids {
+		p, err := os.FindProcess(pid)
+		if err == nil {
+			require.Error(t, p.Signal(syscall.Signal(0)))
+		}
+	}
+}
+
+func TestNodeTimesync(t *testing.T) {
+	d := &dialect.Dialect{Version: 3, Messages: []msg.Message{
+		&MessageHeartbeat{},
+		&MessageTimesync{},
+	}}
+
+	l1 := make(testLoopback)
+	l2 := make(testLoopback)
+
+	gcs, err := NewNode(NodeConf{
+		Dialect:          d,
+		OutVersion:       V2,
+		OutSystemId:      255,
+		OutComponentId:   190,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l1, l2}}},
+		HeartbeatDisable: true,
+		TimesyncEnable:   true,
+		TimesyncPeriod:   50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	companion, err := NewNode(NodeConf{
+		Dialect:          d,
+		OutVersion:       V2,
+		OutSystemId:      1,
+		OutComponentId:   191,
+		Endpoints:        []EndpointConf{EndpointCustom{ReadWriteCloser: &testEndpoint{l2, l1}}},
+		HeartbeatDisable: true,
+		TimesyncAnswer:   true,
+	})
+	require.NoError(t, err)
+	defer companion.Close()
+
+	requests := make(chan *MessageTimesync, 100)
+	go func() {
+		for evt := range companion.Events() {
+			if fr, ok := evt.(*EventFrame); ok {
+				if m, ok := fr.Message().(*MessageTimesync); ok {
+					requests <- m
+				}
+			}
+		}
+	}()
+
+	responses := make(chan *MessageTimesync, 100)
+	go func() {
+		for evt := range gcs.Events() {
+			if fr, ok := evt.(*EventFrame); ok {
+				if m, ok := fr.Message().(*MessageTimesync); ok {
+					responses <- m
+				}
+			}
+		}
+	}()
+
+	req := <-requests
+	require.Equal(t, int64(0), req.Tc1)
+	require.NotEqual(t, int64(0), req.Ts1)
+	require.Equal(t, uint8(0), req.TargetSystem)
+
+	res := <-responses
+	require.NotEqual(t, int64(0), res.Tc1)
+	require.Equal(t, uint8(255), res.TargetSystem)
+	require.Equal(t, uint8(190), res.TargetComponent)
+
+	for i := 0; ; i++ {
+		ts := gcs.Timesync()
+		if len(ts) == 1 && ts[0].Samples >= 3 {
+			break
+		}
+		require.True(t, i < 100)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ts := gcs.Timesync()
+	require.Equal(t, byte(1), ts[0].SystemId)
+	require.Equal(t, byte(191), ts[0].ComponentId)
+	require.Equal(t, gcs.Channels()[0], ts[0].Channel)
+	// the clocks of the nodes are the same
+	require.True(t, ts[0].Offset > -50*time.Millisecond && ts[0].Offset < 50*time.Millisecond)
+	require.True(t, ts[0].RTT > 0)
+	require.True(t, gcs.Channels()[0].RTT() > 0)
+
+	// the companion does not send requests
+	require.Equal(t, 0, len(companion.Timesync()))
+}
+
+func TestNodeShutdownEvents(t *testing.T) {
+	a1, a2 := net.Pipe()
+	defer a2.Close()
+	b1, b2 := net.Pipe()
+
+	node, err := NewNode(NodeConf{
+		Dialect:     &dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageHeartbeat{}}},
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints: []EndpointConf{
+			EndpointCustom{ReadWriteCloser: a1},
+			EndpointCustom{ReadWriteCloser: b1},
+		},
+		HeartbeatDisable:     true,
+		ShutdownEventsEnable: true,
+	})
+	require.NoError(t, err)
+
+	endpoints := node.Endpoints()
+	require.Equal(t, 2, len(endpoints))
+
+	events := make(chan Event, 100)
+	go func() {
+		defer close(events)
+		for evt := range node.Events() {
+			if _, ok := evt.(*EventChannelOpen); !ok {
+				events <- evt
+			}
+		}
+	}()
+
+	// the closure of the second channel is caused by an error
+	b2.Close()
+
+	evt := <-events
+	ec, ok := evt.(*EventChannelClose)
+	require.Equal(t, true, ok)
+	require.Equal(t, endpoints[1], ec.Channel.Endpoint)
+	require.Error(t, ec.Error)
+
+	node.Close()
+
+	var recv []Event
+	for evt := range events {
+		recv = append(recv, evt)
+	}
+
+	require.Equal(t, 4, len(recv))
+
+	ec, ok = recv[0].(*EventChannelClose)
+	require.Equal(t, true, ok)
+	require.Equal(t, endpoints[0], ec.Channel.Endpoint)
+	require.NoError(t, ec.Error)
+
+	require.Equal(t, &EventEndpointStop{endpoints[0]}, recv[1])
+	require.Equal(t, &EventEndpointStop{endpoints[1]}, recv[2])
+	require.Equal(t, &EventNodeTerminated{}, recv[3])
+}
+
+// testRadio is a frame-oriented transport that connects dialers and
+// listeners of the same address in memory.
+type testRadio struct {
+	mutex     sync.Mutex
+	listeners map[string]*testRadioAcceptor
+}
+
+type testRadioConn struct {
+	in        chan []byte
+	out       chan []byte
+	closeOnce sync.Once
+	closed    chan struct{}
+	peer      *testRadioConn
+}
+
+func (c *testRadioConn) ReadFrame() ([]byte, error) {
+	select {
+	case buf := <-c.in:
+		return buf, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-c.peer.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *testRadioConn) WriteFrame(buf []byte) error {
+	select {
+	case c.out <- append([]byte(nil), buf...):
+		return nil
+	case <-c.closed:
+		return io.EOF
+	case <-c.peer.closed:
+		return io.EOF
+	}
+}
+
+func (c *testRadioConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+type testRadioAcceptor struct {
+	conns  chan *testRadioConn
+	closed chan struct{}
+}
+
+func (a *testRadioAcceptor) Accept() (string, TransportConn, error) {
+	select {
+	case c := <-a.conns:
+		return "peer", c, nil
+	case <-a.closed:
+		return "", nil, io.EOF
+	}
+}
+
+func (a *testRadioAcceptor) Close() error {
+	close(a.closed)
+	return nil
+}
+
+func (*testRadio) Label() string {
+	return "radio"
+}
+
+func (r *testRadio) Dial(address string) (TransportConn, error) {
+	r.mutex.Lock()
+	a, ok := r.listeners[address]
+	r.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no listener")
+	}
+
+	ch1 := make(chan []byte, 16)
+	ch2 := make(chan []byte, 16)
+	client := &testRadioConn{in: ch1, out: ch2, closed: make(chan struct{})}
+	server := &testRadioConn{in: ch2, out: ch1, closed: make(chan struct{})}
+	client.peer = server
+	server.peer = client
+
+	select {
+	case a.conns <- server:
+		return client, nil
+	case <-a.closed:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+func (r *testRadio) Listen(address string) (TransportAcceptor, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	a := &testRadioAcceptor{
+		conns:  make(chan *testRadioConn),
+		closed: make(chan struct{}),
+	}
+	r.listeners[address] = a
+	return a, nil
+}
+
+type testStreamTransport struct{}
+
+func (testStreamTransport) Label() string {
+	return "stream"
+}
+
+// the registry is global, therefore the transport is registered once even
+// if tests are run multiple times.
+var registerTestRadio sync.Once
+
+func TestNodeTransport(t *testing.T) {
+	registerTestRadio.Do(func() {
+		RegisterTransport("testradio", &testRadio{listeners: make(map[string]*testRadioAcceptor)})
+	})
+
+	require.Panics(t, func() {
+		RegisterTransport("testradio", &testRadio{})
+	})
+	require.Panics(t, func() {
+		RegisterTransport("teststream", testStreamTransport{})
+	})
+
+	require.Contains(t, Transports(), "testradio")
+
+	_, err := NewNode(NodeConf{
+		OutVersion:  V2,
+		OutSystemId: 10,
+		Endpoints:   []EndpointConf{EndpointTransport{Transport: "lora", Address: "a"}},
+	})
+	require.EqualError(t, err, "transport lora is not registered")
+
+	t.Run("registered", func(t *testing.T) {
+		doTest(t, EndpointTransport{Transport: "testradio", Address: "a", Listen: true},
+			EndpointTransport{Transport: "testradio", Address: "a"})
+	})
+
+	t.Run("instance", func(t *testing.T) {
+		radio := &testRadio{listeners: make(map[string]*testRadioAcceptor)}
+		doTest(t, EndpointTransport{Instance: radio, Address: "a", Listen: true},
+			EndpointTransport{Instance: radio, Address: "a"})
+	})
+
+	t.Run("built-in", func(t *testing.T) {
+		require.Contains(t, Transports(), "tcp")
+		require.Contains(t, Transports(), "udp")
+
+		doTest(t, EndpointTransport{Transport: "tcp", Address: "127.0.0.1:5601", Listen: true},
+			EndpointTransport{Transport: "tcp", Address: "127.0.0.1:5601"})
+	})
+}
@@ -28,7 +28,7 @@ func newNodeHeartbeat(n *Node) *nodeHeartbeat {
 
 	// heartbeat message must exist in dialect and correspond to standard
 	msgHeartbeat := func() msg.Message {
-		for _, m := range n.conf.Dialect.Messages {
+		for _, m := range n.conf.Dialect.MessagesSnapshot() {
 			if m.GetId() == 0 {
 				return m
 			}
@@ -0,0 +1,336 @@
+package gomavlib
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib/msg"
+)
+
+// Presence is a component that has been seen on a channel, through its
+// heartbeats.
+type Presence struct {
+	// the channel from which heartbeats are received
+	Channel *Channel
+	// the system id of the component
+	SystemId byte
+	// the component id, that is also the role of the component (MAV_COMPONENT)
+	ComponentId byte
+	// the type of the component (MAV_TYPE), i.e. quadrotor, camera, gimbal
+	Type int
+	// the autopilot type (MAV_AUTOPILOT)
+	Autopilot int
+	// the status of the system (MAV_STATE)
+	SystemStatus int
+	// the time at which the last heartbeat has been received
+	LastSeen time.Time
+}
+
+// Component is a component that has been seen on one or more channels,
+// through its heartbeats.
+type Component struct {
+	// the system id of the component
+	SystemId byte
+	// the component id, that is also the role of the component (MAV_COMPONENT)
+	ComponentId byte
+	// the type of the component (MAV_TYPE), taken from the last heartbeat
+	Type int
+	// the autopilot type (MAV_AUTOPILOT), taken from the last heartbeat
+	Autopilot int
+	// the status of the system (MAV_STATE), taken from the last heartbeat
+	SystemStatus int
+	// the time at which the last heartbeat has been received
+	LastSeen time.Time
+	// the channels from which heartbeats are received, sorted by label
+	Channels []*Channel
+}
+
+type componentKey struct {
+	systemId    byte
+	componentId byte
+}
+
+type presenceKey struct {
+	ch          *Channel
+	systemId    byte
+	componentId byte
+}
+
+type nodePresence struct {
+	n       *Node
+	mutex   sync.Mutex
+	entries map[presenceKey]*Presence
+
+	// components removed because their channels have been closed, whose
+	// events are emitted by the routine of the presence
+	closed []componentKey
+	notify chan struct{}
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+func newNodePresence(n *Node) *nodePresence {
+	// dialect must be enabled
+	if n.conf.Dialect == nil {
+		return nil
+	}
+
+	// heartbeat message must exist in dialect and correspond to standard
+	msgHeartbeat := func() msg.Message {
+		for _, m := range n.conf.Dialect.MessagesSnapshot() {
+			if m.GetId() == 0 {
+				return m
+			}
+		}
+		return nil
+	}()
+	if msgHeartbeat == nil {
+		return nil
+	}
+	mde, err := msg.NewDecEncoder(msgHeartbeat)
+	if err != nil || mde.CRCExtra() != 50 {
+		return nil
+	}
+
+	p := &nodePresence{
+		n:         n,
+		entries:   make(map[presenceKey]*Presence),
+		notify:    make(chan struct{}, 1),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	return p
+}
+
+func (p *nodePresence) close() {
+	close(p.terminate)
+	<-p.done
+}
+
+func (p *nodePresence) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.n.conf.PresenceTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		// periodic cleanup
+		case now := <-ticker.C:
+			removed := func() []componentKey {
+				p.mutex.Lock()
+				defer p.mutex.Unlock()
+
+				var removed []componentKey
+				for key, e := range p.entries {
+					if now.Sub(e.LastSeen) >= p.timeout(e.Channel) {
+						delete(p.entries, key)
+						removed = append(removed, componentKey{key.systemId, key.componentId})
+					}
+				}
+				return p.lost(removed)
+			}()
+
+			p.emitDisappear(removed)
+
+		case <-p.notify:
+			p.mutex.Lock()
+			removed := p.closed
+			p.closed = nil
+			p.mutex.Unlock()
+
+			p.emitDisappear(removed)
+
+		case <-p.terminate:
+			return
+		}
+	}
+}
+
+// lost returns the components, among the given ones, that are not seen on
+// any channel. It must be called with the mutex locked.
+func (p *nodePresence) lost(keys []componentKey) []componentKey {
+	var ret []componentKey
+
+	for _, ck := range keys {
+		if !p.seen(ck) && !containsComponentKey(ret, ck) {
+			ret = append(ret, ck)
+		}
+	}
+
+	return ret
+}
+
+// seen must be called with the mutex locked.
+func (p *nodePresence) seen(ck componentKey) bool {
+	for key := range p.entries {
+		if key.systemId == ck.systemId && key.componentId == ck.componentId {
+			return true
+		}
+	}
+	return false
+}
+
+func containsComponentKey(keys []componentKey, ck componentKey) bool {
+	for _, k := range keys {
+		if k == ck {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *nodePresence) emitDisappear(keys []componentKey) {
+	if !p.n.conf.ComponentEventsEnable {
+		return
+	}
+
+	for _, ck := range keys {
+		select {
+		case p.n.eventsOut <- &EventComponentDisappear{
+			SystemId:    ck.systemId,
+			ComponentId: ck.componentId,
+		}:
+		case <-p.terminate:
+			return
+		}
+	}
+}
+
+func (p *nodePresence) timeout(ch *Channel) time.Duration {
+	return ch.adaptiveTimeout(p.n.conf.PresenceTimeout, p.n.conf.PresenceRTTFactor)
+}
+
+func (p *nodePresence) onEventFrame(evt *EventFrame) {
+	if evt.Message().GetId() != 0 {
+		return
+	}
+
+	// the message may be a MessageRaw if it can't be decoded
+	rv := reflect.ValueOf(evt.Message()).Elem()
+	if rv.Kind() != reflect.Struct || !rv.FieldByName("Type").IsValid() {
+		return
+	}
+
+	key := presenceKey{evt.Channel, evt.SystemId(), evt.ComponentId()}
+	e := &Presence{
+		Channel:      evt.Channel,
+		SystemId:     evt.SystemId(),
+		ComponentId:  evt.ComponentId(),
+		Type:         int(rv.FieldByName("Type").Int()),
+		Autopilot:    int(rv.FieldByName("Autopilot").Int()),
+		SystemStatus: int(rv.FieldByName("SystemStatus").Int()),
+		LastSeen:     evt.ReceiveTime,
+	}
+
+	p.mutex.Lock()
+	appeared := !p.seen(componentKey{key.systemId, key.componentId})
+	p.entries[key] = e
+	p.mutex.Unlock()
+
+	// the event is emitted by the routine that reads the channel, before
+	// the frame
+	if appeared && p.n.conf.ComponentEventsEnable {
+		p.n.eventsOut <- &EventComponentAppear{
+			Channel:     e.Channel,
+			SystemId:    e.SystemId,
+			ComponentId: e.ComponentId,
+			Type:        e.Type,
+			Autopilot:   e.Autopilot,
+		}
+	}
+}
+
+func (p *nodePresence) onChannelClose(ch *Channel) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var removed []componentKey
+	for key := range p.entries {
+		if key.ch == ch {
+			delete(p.entries, key)
+			removed = append(removed, componentKey{key.systemId, key.componentId})
+		}
+	}
+
+	// this is called by the routine of the node, that must not be blocked
+	// by the consumer of events
+	p.closed = append(p.closed, p.lost(removed)...)
+	if len(p.closed) > 0 {
+		select {
+		case p.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Presence returns the components that have been seen on each channel,
+// through their heartbeats, sorted by system id, component id and channel.
+// A component is removed when no heartbeats are received within
+// NodeConf.PresenceTimeout, extended on high-latency channels according to
+// NodeConf.PresenceRTTFactor, or when its channel is closed.
+// It requires a dialect that contains the standard heartbeat message.
+func (n *Node) Presence() []Presence {
+	if n.nodePresence == nil {
+		return nil
+	}
+
+	n.nodePresence.mutex.Lock()
+	defer n.nodePresence.mutex.Unlock()
+
+	now := time.Now()
+	ret := make([]Presence, 0, len(n.nodePresence.entries))
+	for _, e := range n.nodePresence.entries {
+		if now.Sub(e.LastSeen) < n.nodePresence.timeout(e.Channel) {
+			ret = append(ret, *e)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SystemId != ret[j].SystemId {
+			return ret[i].SystemId < ret[j].SystemId
+		}
+		if ret[i].ComponentId != ret[j].ComponentId {
+			return ret[i].ComponentId < ret[j].ComponentId
+		}
+		return ret[i].Channel.label < ret[j].Channel.label
+	})
+
+	return ret
+}
+
+// Components returns the components that have been seen on any channel,
+// through their heartbeats, sorted by system id and component id. They are
+// the components returned by Presence(), grouped by system id and component
+// id. When NodeConf.ComponentEventsEnable is true, EventComponentAppear and
+// EventComponentDisappear are emitted when components are added and removed.
+// It requires a dialect that contains the standard heartbeat message.
+func (n *Node) Components() []Component {
+	var ret []Component
+
+	for _, p := range n.Presence() {
+		if len(ret) == 0 || ret[len(ret)-1].SystemId != p.SystemId ||
+			ret[len(ret)-1].ComponentId != p.ComponentId {
+			ret = append(ret, Component{
+				SystemId:    p.SystemId,
+				ComponentId: p.ComponentId,
+			})
+		}
+
+		c := &ret[len(ret)-1]
+		c.Channels = append(c.Channels, p.Channel)
+
+		if p.LastSeen.After(c.LastSeen) {
+			c.Type = p.Type
+			c.Autopilot = p.Autopilot
+			c.SystemStatus = p.SystemStatus
+			c.LastSeen = p.LastSeen
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,155 @@
+package gomavlib
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+type serialHotplugDevice struct {
+	device   SerialDevice
+	endpoint Endpoint
+}
+
+type nodeSerialHotplug struct {
+	n *Node
+
+	// accessed by run() only
+	devices map[string]*serialHotplugDevice
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+func newNodeSerialHotplug(n *Node) *nodeSerialHotplug {
+	// module is disabled
+	if !n.conf.SerialHotplugEnable {
+		return nil
+	}
+
+	h := &nodeSerialHotplug{
+		n:         n,
+		devices:   make(map[string]*serialHotplugDevice),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	return h
+}
+
+func (h *nodeSerialHotplug) close() {
+	close(h.terminate)
+	<-h.done
+}
+
+func (h *nodeSerialHotplug) run() {
+	defer close(h.done)
+
+	// devices plugged before the start are reported too
+	if !h.scan() {
+		return
+	}
+
+	ticker := time.NewTicker(h.n.conf.SerialHotplugPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !h.scan() {
+				return
+			}
+
+		case <-h.terminate:
+			return
+		}
+	}
+}
+
+// scan compares the current devices with the known ones. It returns false
+// when the module has been terminated.
+func (h *nodeSerialHotplug) scan() bool {
+	devices, err := SerialDevices()
+	if err != nil {
+		return true
+	}
+
+	cur := make(map[string]SerialDevice)
+	for _, d := range devices {
+		if h.n.conf.SerialHotplugFilter == nil || h.n.conf.SerialHotplugFilter(d) {
+			cur[d.Path] = d
+		}
+	}
+
+	var removed []string
+	for path := range h.devices {
+		if _, ok := cur[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, path := range removed {
+		hd := h.devices[path]
+		delete(h.devices, path)
+
+		if hd.endpoint != nil {
+			h.n.RemoveEndpoint(hd.endpoint)
+		}
+
+		if !h.emit(&EventSerialDeviceUnplugged{
+			Device:   hd.device,
+			Endpoint: hd.endpoint,
+		}) {
+			return false
+		}
+	}
+
+	// devices are sorted by path
+	for _, d := range devices {
+		if _, ok := cur[d.Path]; !ok {
+			continue
+		}
+		if _, ok := h.devices[d.Path]; ok {
+			continue
+		}
+
+		hd := &serialHotplugDevice{device: d}
+		h.devices[d.Path] = hd
+
+		evt := &EventSerialDevicePlugged{Device: d}
+
+		// a device whose attachment fails is not attached again until it
+		// is plugged again
+		if h.n.conf.SerialHotplugAttach {
+			baud := "auto"
+			if h.n.conf.SerialHotplugBaudRate != 0 {
+				baud = strconv.Itoa(h.n.conf.SerialHotplugBaudRate)
+			}
+
+			e, err := h.n.AddEndpoint(EndpointSerial{Address: d.Path + ":" + baud})
+			if err == errorTerminated {
+				return false
+			}
+
+			hd.endpoint = e
+			evt.Endpoint = e
+			evt.AttachError = err
+		}
+
+		if !h.emit(evt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *nodeSerialHotplug) emit(evt Event) bool {
+	select {
+	case h.n.eventsOut <- evt:
+		return true
+	case <-h.terminate:
+		return false
+	}
+}
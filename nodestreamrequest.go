@@ -42,7 +42,7 @@ func newNodeStreamRequest(n *Node) *nodeStreamRequest {
 
 	// heartbeat message must exist in dialect and correspond to standard
 	msgHeartbeat := func() msg.Message {
-		for _, m := range n.conf.Dialect.Messages {
+		for _, m := range n.conf.Dialect.MessagesSnapshot() {
 			if m.GetId() == 0 {
 				return m
 			}
@@ -59,7 +59,7 @@ func newNodeStreamRequest(n *Node) *nodeStreamRequest {
 
 	// request data stream message must exist in dialect and correspond to standard
 	msgRequestDataStream := func() msg.Message {
-		for _, m := range n.conf.Dialect.Messages {
+		for _, m := range n.conf.Dialect.MessagesSnapshot() {
 			if m.GetId() == 66 {
 				return m
 			}
@@ -0,0 +1,275 @@
+package gomavlib
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// responses whose round trip time is longer are discarded, since they
+	// are probably answers to old requests or come from misbehaving systems.
+	timesyncMaxRTT = 10 * time.Second
+)
+
+// Timesync is the clock synchronization with a remote component, measured
+// through TIMESYNC exchanges on a channel.
+type Timesync struct {
+	// the channel through which the component is reached
+	Channel *Channel
+	// the system id of the component
+	SystemId byte
+	// the component id of the component
+	ComponentId byte
+	// the smoothed offset of the clock of the component with respect to the
+	// clock of the node, i.e. the remote time is the local time plus Offset
+	Offset time.Duration
+	// the smoothed round trip time
+	RTT time.Duration
+	// the number of measurements
+	Samples int
+	// the time at which the last measurement has been received
+	LastUpdate time.Time
+}
+
+type nodeTimesync struct {
+	n           *Node
+	msgTimesync msg.Message
+
+	mutex   sync.Mutex
+	entries map[presenceKey]*Timesync
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// findMessage returns the message of the dialect with the given id, if it
+// corresponds to the standard.
+func (n *Node) findMessage(id uint32, crcExtra byte) msg.Message {
+	if n.conf.Dialect == nil {
+		return nil
+	}
+
+	for _, m := range n.conf.Dialect.MessagesSnapshot() {
+		if m.GetId() == id {
+			mde, err := msg.NewDecEncoder(m)
+			if err != nil || mde.CRCExtra() != crcExtra {
+				return nil
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func newNodeTimesync(n *Node) *nodeTimesync {
+	// module is disabled
+	if !n.conf.TimesyncEnable && !n.conf.TimesyncAnswer {
+		return nil
+	}
+
+	// timesync message must exist in dialect and correspond to standard
+	msgTimesync := n.findMessage(111, 34)
+	if msgTimesync == nil {
+		return nil
+	}
+
+	t := &nodeTimesync{
+		n:           n,
+		msgTimesync: msgTimesync,
+		entries:     make(map[presenceKey]*Timesync),
+		terminate:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	return t
+}
+
+func (t *nodeTimesync) close() {
+	close(t.terminate)
+	<-t.done
+}
+
+// timesyncNow returns the clock of the node, in nanoseconds.
+func timesyncNow() int64 {
+	return time.Now().UnixNano()
+}
+
+func (t *nodeTimesync) run() {
+	defer close(t.done)
+
+	// the module only answers requests
+	if !t.n.conf.TimesyncEnable {
+		<-t.terminate
+		return
+	}
+
+	ticker := time.NewTicker(t.n.conf.TimesyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			// requests are broadcast, and answered by each component
+			t.n.WriteMessageAll(t.newMessage(0, timesyncNow(), 0, 0))
+
+			t.mutex.Lock()
+			for key, e := range t.entries {
+				if now.Sub(e.LastUpdate) >= t.n.conf.PresenceTimeout {
+					delete(t.entries, key)
+				}
+			}
+			t.mutex.Unlock()
+
+		case <-t.terminate:
+			return
+		}
+	}
+}
+
+func (t *nodeTimesync) newMessage(tc1 int64, ts1 int64, targetSystem byte, targetComponent byte) msg.Message {
+	m := reflect.New(reflect.TypeOf(t.msgTimesync).Elem())
+	m.Elem().FieldByName("Tc1").SetInt(tc1)
+	m.Elem().FieldByName("Ts1").SetInt(ts1)
+
+	// target fields are extensions of recent versions of the message
+	if f := m.Elem().FieldByName("TargetSystem"); f.IsValid() {
+		f.SetUint(uint64(targetSystem))
+	}
+	if f := m.Elem().FieldByName("TargetComponent"); f.IsValid() {
+		f.SetUint(uint64(targetComponent))
+	}
+
+	return m.Interface().(msg.Message)
+}
+
+// isTarget returns whether a message is addressed to the node, when it
+// contains target fields.
+func (t *nodeTimesync) isTarget(rv reflect.Value) bool {
+	if f := rv.FieldByName("TargetSystem"); f.IsValid() &&
+		f.Uint() != 0 && byte(f.Uint()) != t.n.conf.OutSystemId {
+		return false
+	}
+	if f := rv.FieldByName("TargetComponent"); f.IsValid() &&
+		f.Uint() != 0 && byte(f.Uint()) != t.n.conf.OutComponentId {
+		return false
+	}
+	return true
+}
+
+func (t *nodeTimesync) onEventFrame(evt *EventFrame) {
+	if evt.Message().GetId() != 111 {
+		return
+	}
+
+	// the message may be a MessageRaw if it can't be decoded
+	rv := reflect.ValueOf(evt.Message()).Elem()
+	if rv.Kind() != reflect.Struct || !rv.FieldByName("Tc1").IsValid() {
+		return
+	}
+
+	// discard messages of the node, that may be looped back by routers
+	if evt.SystemId() == t.n.conf.OutSystemId && evt.ComponentId() == t.n.conf.OutComponentId {
+		return
+	}
+
+	if !t.isTarget(rv) {
+		return
+	}
+
+	tc1 := rv.FieldByName("Tc1").Int()
+	ts1 := rv.FieldByName("Ts1").Int()
+
+	// request
+	if tc1 == 0 {
+		if t.n.conf.TimesyncAnswer {
+			t.n.WriteMessageTo(evt.Channel,
+				t.newMessage(timesyncNow(), ts1, evt.SystemId(), evt.ComponentId()))
+		}
+		return
+	}
+
+	// response to a request of the node
+	if !t.n.conf.TimesyncEnable {
+		return
+	}
+
+	now := timesyncNow()
+	rtt := time.Duration(now - ts1)
+	if rtt <= 0 || rtt > timesyncMaxRTT {
+		return
+	}
+
+	// the remote clock is read halfway through the exchange
+	offset := time.Duration(tc1 - (ts1 + (now-ts1)/2))
+
+	evt.Channel.ReportRTT(rtt)
+
+	key := presenceKey{evt.Channel, evt.SystemId(), evt.ComponentId()}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		e = &Timesync{
+			Channel:     evt.Channel,
+			SystemId:    evt.SystemId(),
+			ComponentId: evt.ComponentId(),
+			Offset:      offset,
+			RTT:         rtt,
+		}
+		t.entries[key] = e
+	} else {
+		// exponentially weighted moving averages, as in Channel.ReportRTT
+		e.Offset += (offset - e.Offset) / 8
+		e.RTT += (rtt - e.RTT) / 8
+	}
+	e.Samples++
+	e.LastUpdate = evt.ReceiveTime
+}
+
+func (t *nodeTimesync) onChannelClose(ch *Channel) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key := range t.entries {
+		if key.ch == ch {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Timesync returns the clock synchronization with the components that
+// answer the TIMESYNC requests of the node, sorted by system id, component
+// id and channel. Requests are sent when NodeConf.TimesyncEnable is true.
+// Round trip times are also reported to the channels (see Channel.RTT).
+// It requires a dialect that contains the standard TIMESYNC message.
+func (n *Node) Timesync() []Timesync {
+	if n.nodeTimesync == nil {
+		return nil
+	}
+
+	n.nodeTimesync.mutex.Lock()
+	defer n.nodeTimesync.mutex.Unlock()
+
+	ret := make([]Timesync, 0, len(n.nodeTimesync.entries))
+	for _, e := range n.nodeTimesync.entries {
+		ret = append(ret, *e)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SystemId != ret[j].SystemId {
+			return ret[i].SystemId < ret[j].SystemId
+		}
+		if ret[i].ComponentId != ret[j].ComponentId {
+			return ret[i].ComponentId < ret[j].ComponentId
+		}
+		return ret[i].Channel.label < ret[j].Channel.label
+	})
+
+	return ret
+}
@@ -0,0 +1,294 @@
+// Package onboardcomputer implements a publisher that periodically sends
+// ONBOARD_COMPUTER_STATUS with the resources of the companion computer on
+// which the node is running (CPU usage, RAM usage, temperatures) and the
+// traffic of the channels of the node, in order to show the health of the
+// companion computer in ground stations, alongside autopilot telemetry.
+//
+// CPU, RAM and temperatures are read from the Linux kernel, and are reported
+// as unused on other systems. The node should use the component id of onboard
+// computers (MAV_COMP_ID_ONBOARD_COMPUTER) and a dialect that contains the
+// common messages.
+package onboardcomputer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	// period of the slices of the CPU usage histogram
+	cpuSlicePeriod = 100 * time.Millisecond
+	cpuSliceCount  = 10
+
+	maxCores = 8
+	maxTemps = 8
+	maxLinks = 6
+
+	// link types
+	linkTypeUART  = 0
+	linkTypeWired = 10
+)
+
+// Conf allows to configure a Publisher.
+type Conf struct {
+	// the node used to publish the status.
+	Node *gomavlib.Node
+
+	// (optional) the type of the onboard computer (0: mission computer
+	// primary, 1-2: mission computer backup, 3: compute node, 6-9: payload
+	// computers).
+	// It defaults to 0.
+	Type uint8
+
+	// (optional) the period of the status.
+	// It defaults to 1s.
+	Period time.Duration
+
+	// (optional) a function that is called before sending every status,
+	// that allows to fill or override fields, i.e. GPU usage, fan speeds
+	// and storage.
+	OnStatus func(*common.MessageOnboardComputerStatus)
+}
+
+// Publisher is a companion computer status publisher.
+type Publisher struct {
+	conf  Conf
+	start time.Time
+
+	// accessed by run() only
+	prevCombined cpuTimes
+	cpuSlices    []uint8
+	prevCores    []cpuTimes
+	prevTraffic  map[*gomavlib.Channel]gomavlib.TrafficStats
+	prevPublish  time.Time
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Publisher. See Conf for the options.
+func New(conf Conf) (*Publisher, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageOnboardComputerStatus{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Period == 0 {
+		conf.Period = 1 * time.Second
+	}
+
+	p := &Publisher{
+		conf:        conf,
+		start:       time.Now(),
+		prevTraffic: make(map[*gomavlib.Channel]gomavlib.TrafficStats),
+		prevPublish: time.Now(),
+		terminate:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if byts, err := ioutil.ReadFile(procStatPath); err == nil {
+		p.prevCombined, p.prevCores, _ = parseProcStat(byts)
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// Close stops the publisher. It must be called before closing the node.
+func (p *Publisher) Close() {
+	close(p.terminate)
+	<-p.done
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	sliceTicker := time.NewTicker(cpuSlicePeriod)
+	defer sliceTicker.Stop()
+
+	publishTicker := time.NewTicker(p.conf.Period)
+	defer publishTicker.Stop()
+
+	for {
+		select {
+		case <-sliceTicker.C:
+			p.onSlice()
+
+		case <-publishTicker.C:
+			p.conf.Node.WriteMessageAll(p.status())
+
+		case <-p.terminate:
+			return
+		}
+	}
+}
+
+// onSlice adds the CPU usage of the last slice to the histogram.
+func (p *Publisher) onSlice() {
+	byts, err := ioutil.ReadFile(procStatPath)
+	if err != nil {
+		return
+	}
+
+	combined, _, ok := parseProcStat(byts)
+	if !ok {
+		return
+	}
+
+	p.cpuSlices = append(p.cpuSlices, combined.usage(p.prevCombined))
+	if len(p.cpuSlices) > cpuSliceCount {
+		p.cpuSlices = p.cpuSlices[1:]
+	}
+	p.prevCombined = combined
+}
+
+func linkType(ch *gomavlib.Channel) uint32 {
+	switch ch.Endpoint.Conf().(type) {
+	case gomavlib.EndpointSerial, gomavlib.EndpointRfc2217:
+		return linkTypeUART
+	}
+	return linkTypeWired
+}
+
+func newStatus() *common.MessageOnboardComputerStatus {
+	m := &common.MessageOnboardComputerStatus{
+		TemperatureBoard: math.MaxInt8,
+		RamUsage:         math.MaxUint32,
+		RamTotal:         math.MaxUint32,
+	}
+	for i := range m.CpuCores {
+		m.CpuCores[i] = math.MaxUint8
+	}
+	for i := range m.CpuCombined {
+		m.CpuCombined[i] = math.MaxUint8
+	}
+	for i := range m.GpuCores {
+		m.GpuCores[i] = math.MaxUint8
+	}
+	for i := range m.GpuCombined {
+		m.GpuCombined[i] = math.MaxUint8
+	}
+	for i := range m.TemperatureCore {
+		m.TemperatureCore[i] = math.MaxInt8
+	}
+	for i := range m.FanSpeed {
+		m.FanSpeed[i] = math.MaxInt16
+	}
+	for i := 0; i < 4; i++ {
+		m.StorageType[i] = math.MaxUint32
+		m.StorageUsage[i] = math.MaxUint32
+		m.StorageTotal[i] = math.MaxUint32
+	}
+	for i := 0; i < maxLinks; i++ {
+		m.LinkType[i] = math.MaxUint32
+		m.LinkTxRate[i] = math.MaxUint32
+		m.LinkRxRate[i] = math.MaxUint32
+		m.LinkTxMax[i] = math.MaxUint32
+		m.LinkRxMax[i] = math.MaxUint32
+	}
+	return m
+}
+
+// status builds a status with the resources used since the previous one.
+func (p *Publisher) status() *common.MessageOnboardComputerStatus {
+	now := time.Now()
+	m := newStatus()
+
+	m.TimeUsec = uint64(now.UnixNano() / 1000)
+	m.Type = p.conf.Type
+
+	m.Uptime = uint32(now.Sub(p.start) / time.Millisecond)
+	if byts, err := ioutil.ReadFile(procUptimePath); err == nil {
+		if v, ok := parseUptime(byts); ok {
+			m.Uptime = v
+		}
+	}
+
+	if byts, err := ioutil.ReadFile(procStatPath); err == nil {
+		if _, cores, ok := parseProcStat(byts); ok {
+			for i := 0; i < len(cores) && i < maxCores; i++ {
+				if i < len(p.prevCores) {
+					m.CpuCores[i] = cores[i].usage(p.prevCores[i])
+				}
+			}
+			p.prevCores = cores
+		}
+	}
+
+	// oldest slice first
+	copy(m.CpuCombined[:], p.cpuSlices)
+
+	if byts, err := ioutil.ReadFile(procMeminfoPath); err == nil {
+		if used, total, ok := parseMeminfo(byts); ok {
+			m.RamUsage = used
+			m.RamTotal = total
+		}
+	}
+
+	temps := readTemperatures()
+	for i := 0; i < len(temps) && i < maxTemps; i++ {
+		m.TemperatureCore[i] = temps[i]
+	}
+
+	p.fillLinks(m, now)
+
+	if p.conf.OnStatus != nil {
+		p.conf.OnStatus(m)
+	}
+
+	return m
+}
+
+// fillLinks fills the traffic of the channels of the node, in KiB/s.
+func (p *Publisher) fillLinks(m *common.MessageOnboardComputerStatus, now time.Time) {
+	channels := p.conf.Node.Channels()
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].String() < channels[j].String()
+	})
+
+	elapsed := now.Sub(p.prevPublish).Seconds()
+	p.prevPublish = now
+
+	traffic := make(map[*gomavlib.Channel]gomavlib.TrafficStats)
+
+	for i, ch := range channels {
+		stats := ch.TrafficStats()
+		traffic[ch] = stats
+
+		if i >= maxLinks {
+			continue
+		}
+
+		m.LinkType[i] = linkType(ch)
+
+		// channels opened after the previous status are reported from the
+		// next one
+		prev, ok := p.prevTraffic[ch]
+		if !ok || elapsed <= 0 {
+			m.LinkTxRate[i] = 0
+			m.LinkRxRate[i] = 0
+			continue
+		}
+
+		m.LinkTxRate[i] = uint32(float64(stats.BytesOut-prev.BytesOut) / 1024 / elapsed)
+		m.LinkRxRate[i] = uint32(float64(stats.BytesIn-prev.BytesIn) / 1024 / elapsed)
+	}
+
+	p.prevTraffic = traffic
+}
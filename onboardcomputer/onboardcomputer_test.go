@@ -0,0 +1,87 @@
+package onboardcomputer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestPublisher(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:        common.Dialect,
+		OutVersion:     gomavlib.V2,
+		OutSystemId:    1,
+		OutComponentId: byte(common.MAV_COMP_ID_ONBOARD_COMPUTER),
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5750"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5750"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	// open the channel of the server
+	for len(node1.Channels()) == 0 {
+		node2.WriteMessageAll(&common.MessageHeartbeat{})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	p, err := New(Conf{
+		Node:   node1,
+		Type:   3,
+		Period: 200 * time.Millisecond,
+		OnStatus: func(m *common.MessageOnboardComputerStatus) {
+			m.FanSpeed[0] = 1200
+		},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	count := 0
+	for evt := range node2.Events() {
+		fr, ok := evt.(*gomavlib.EventFrame)
+		if !ok {
+			continue
+		}
+
+		m, ok := fr.Message().(*common.MessageOnboardComputerStatus)
+		if !ok {
+			continue
+		}
+
+		require.Equal(t, byte(common.MAV_COMP_ID_ONBOARD_COMPUTER), fr.ComponentId())
+		require.Equal(t, uint8(3), m.Type)
+		require.Equal(t, int16(1200), m.FanSpeed[0])
+		require.Equal(t, int16(math.MaxInt16), m.FanSpeed[1])
+		require.Equal(t, uint32(linkTypeWired), m.LinkType[0])
+		require.Equal(t, uint32(math.MaxUint32), m.LinkType[1])
+		require.Equal(t, uint32(math.MaxUint32), m.StorageTotal[0])
+
+		count++
+		if count == 2 {
+			break
+		}
+	}
+}
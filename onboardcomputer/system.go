@@ -0,0 +1,162 @@
+package onboardcomputer
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the files from which resources are read. They are available on Linux only;
+// on other systems, resources are reported as unused.
+var (
+	procStatPath    = "/proc/stat"
+	procMeminfoPath = "/proc/meminfo"
+	procUptimePath  = "/proc/uptime"
+	thermalGlob     = "/sys/class/thermal/thermal_zone*/temp"
+)
+
+// cpuTimes contains the idle and total time spent by a CPU, in ticks.
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// usage returns the usage between two readings, in percent.
+func (t cpuTimes) usage(prev cpuTimes) uint8 {
+	total := t.total - prev.total
+	if total == 0 || t.total < prev.total {
+		return 0
+	}
+	idle := t.idle - prev.idle
+	if idle > total {
+		return 0
+	}
+	return uint8(100 - idle*100/total)
+}
+
+// parseProcStat parses /proc/stat, and returns the times of all CPUs
+// together and the times of each CPU.
+func parseProcStat(byts []byte) (cpuTimes, []cpuTimes, bool) {
+	var combined cpuTimes
+	var cores []cpuTimes
+	found := false
+
+	sc := bufio.NewScanner(bytes.NewReader(byts))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		var t cpuTimes
+		for i, f := range fields[1:] {
+			// guest times are already included in user times
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return cpuTimes{}, nil, false
+			}
+			t.total += v
+
+			// idle and iowait
+			if i == 3 || i == 4 {
+				t.idle += v
+			}
+		}
+
+		if fields[0] == "cpu" {
+			combined = t
+			found = true
+		} else {
+			cores = append(cores, t)
+		}
+	}
+
+	return combined, cores, found
+}
+
+// parseMeminfo parses /proc/meminfo, and returns the used and total RAM,
+// in MiB.
+func parseMeminfo(byts []byte) (uint32, uint32, bool) {
+	values := make(map[string]uint64)
+
+	sc := bufio.NewScanner(bytes.NewReader(byts))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = v // kB
+	}
+
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, false
+	}
+
+	available, ok := values["MemAvailable"]
+	if !ok {
+		// kernels older than 3.14
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+
+	if available > total {
+		available = total
+	}
+
+	return uint32((total - available) / 1024), uint32(total / 1024), true
+}
+
+// parseUptime parses /proc/uptime, and returns the uptime in milliseconds.
+func parseUptime(byts []byte) (uint32, bool) {
+	fields := strings.Fields(string(byts))
+	if len(fields) < 1 {
+		return 0, false
+	}
+
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(v * 1000), true
+}
+
+// readTemperatures returns the temperatures of the thermal zones, in degC.
+func readTemperatures() []int8 {
+	paths, _ := filepath.Glob(thermalGlob)
+	sort.Strings(paths)
+
+	var ret []int8
+	for _, p := range paths {
+		byts, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+
+		v, err := strconv.ParseInt(strings.TrimSpace(string(byts)), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// millidegrees, bounded to avoid the value that means unused
+		v /= 1000
+		if v > 126 {
+			v = 126
+		} else if v < -128 {
+			v = -128
+		}
+		ret = append(ret, int8(v))
+	}
+
+	return ret
+}
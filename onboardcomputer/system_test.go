@@ -0,0 +1,56 @@
+package onboardcomputer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	combined, cores, ok := parseProcStat([]byte(
+		"cpu  400 0 100 400 100 0 0 0 0 0\n" +
+			"cpu0 300 0 50 100 50 0 0 0 0 0\n" +
+			"cpu1 100 0 50 300 50 0 0 0 0 0\n" +
+			"intr 1234\n"))
+	require.Equal(t, true, ok)
+	require.Equal(t, cpuTimes{idle: 500, total: 1000}, combined)
+	require.Equal(t, []cpuTimes{{idle: 150, total: 500}, {idle: 350, total: 500}}, cores)
+
+	require.Equal(t, uint8(75), cpuTimes{idle: 550, total: 1200}.usage(combined))
+	require.Equal(t, uint8(0), combined.usage(combined))
+
+	_, _, ok = parseProcStat([]byte("intr 1234\n"))
+	require.Equal(t, false, ok)
+}
+
+func TestParseMeminfo(t *testing.T) {
+	used, total, ok := parseMeminfo([]byte(
+		"MemTotal:        4194304 kB\n" +
+			"MemFree:          524288 kB\n" +
+			"MemAvailable:    1048576 kB\n"))
+	require.Equal(t, true, ok)
+	require.Equal(t, uint32(3072), used)
+	require.Equal(t, uint32(4096), total)
+
+	// kernels without MemAvailable
+	used, total, ok = parseMeminfo([]byte(
+		"MemTotal:        4194304 kB\n" +
+			"MemFree:          524288 kB\n" +
+			"Buffers:          262144 kB\n" +
+			"Cached:           262144 kB\n"))
+	require.Equal(t, true, ok)
+	require.Equal(t, uint32(3072), used)
+	require.Equal(t, uint32(4096), total)
+
+	_, _, ok = parseMeminfo([]byte("Buffers: 10 kB\n"))
+	require.Equal(t, false, ok)
+}
+
+func TestParseUptime(t *testing.T) {
+	v, ok := parseUptime([]byte("1234.56 4567.89\n"))
+	require.Equal(t, true, ok)
+	require.Equal(t, uint32(1234560), v)
+
+	_, ok = parseUptime([]byte(""))
+	require.Equal(t, false, ok)
+}
@@ -0,0 +1,352 @@
+package opendroneid
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	uasIdMaxLength       = 20
+	descriptionMaxLength = 23
+	operatorIdMaxLength  = 20
+
+	// encoded values that mean "unknown"
+	directionUnknown       = 36100
+	speedHorizontalUnknown = 25500
+	speedVerticalUnknown   = 6300
+	altitudeUnknown        = -1000
+
+	// maximum encodable values
+	directionMax       = 35999
+	speedHorizontalMax = 25425
+	speedVerticalMax   = 6200
+)
+
+func checkString(name string, s string, maxLength int) error {
+	if len(s) > maxLength {
+		return fmt.Errorf("%s is longer than %d characters", name, maxLength)
+	}
+	for _, c := range []byte(s) {
+		if c < 0x20 || c > 0x7E {
+			return fmt.Errorf("%s contains non-printable characters", name)
+		}
+	}
+	return nil
+}
+
+func checkCoordinates(lat float64, lon float64) error {
+	if math.IsNaN(lat) != math.IsNaN(lon) {
+		return fmt.Errorf("latitude and longitude must be both known or unknown")
+	}
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("invalid latitude")
+	}
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("invalid longitude")
+	}
+	return nil
+}
+
+// encodeCoordinate converts a coordinate in degrees into degE7. Unknown
+// coordinates are encoded with zero.
+func encodeCoordinate(v float64) int32 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return int32(math.Round(v * 1e7))
+}
+
+// encodeAltitude encodes an altitude in meters.
+func encodeAltitude(v float64) float32 {
+	if math.IsNaN(v) {
+		return altitudeUnknown
+	}
+	return float32(v)
+}
+
+// BasicId contains the identity of the unmanned aircraft.
+type BasicId struct {
+	// the format of UasId.
+	IdType common.MAV_ODID_ID_TYPE
+
+	// the type of the unmanned aircraft.
+	UaType common.MAV_ODID_UA_TYPE
+
+	// the identifier, i.e. the serial number, with a maximum length
+	// of 20 characters.
+	UasId string
+}
+
+// Message validates the fields and builds an OPEN_DRONE_ID_BASIC_ID message.
+func (b BasicId) Message() (*common.MessageOpenDroneIdBasicId, error) {
+	if b.IdType == common.MAV_ODID_ID_TYPE_NONE {
+		return nil, fmt.Errorf("IdType not provided")
+	}
+
+	if b.UasId == "" {
+		return nil, fmt.Errorf("UasId not provided")
+	}
+
+	err := checkString("UasId", b.UasId, uasIdMaxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &common.MessageOpenDroneIdBasicId{
+		IdType: b.IdType,
+		UaType: b.UaType,
+	}
+	copy(m.UasId[:], b.UasId)
+	return m, nil
+}
+
+// Location contains the position and the velocity of the unmanned aircraft.
+// Unknown values must be set to NaN.
+type Location struct {
+	// whether the unmanned aircraft is on the ground or in the air.
+	Status common.MAV_ODID_STATUS
+
+	// direction of movement, clockwise from true North, in degrees.
+	Direction float64
+
+	// ground speed, in m/s. Values larger than 254.25 m/s are clamped.
+	SpeedHorizontal float64
+
+	// vertical speed, positive up, in m/s. Values larger than 62 m/s
+	// in absolute value are clamped.
+	SpeedVertical float64
+
+	// latitude, in degrees.
+	Latitude float64
+
+	// longitude, in degrees.
+	Longitude float64
+
+	// barometric altitude, in meters.
+	AltitudeBarometric float64
+
+	// geodetic altitude (WGS84), in meters.
+	AltitudeGeodetic float64
+
+	// the reference of Height.
+	HeightReference common.MAV_ODID_HEIGHT_REF
+
+	// height above the reference, in meters.
+	Height float64
+
+	// the accuracy of the horizontal position.
+	HorizontalAccuracy common.MAV_ODID_HOR_ACC
+
+	// the accuracy of the vertical position.
+	VerticalAccuracy common.MAV_ODID_VER_ACC
+
+	// the accuracy of the barometric altitude.
+	BarometerAccuracy common.MAV_ODID_VER_ACC
+
+	// the accuracy of the speeds.
+	SpeedAccuracy common.MAV_ODID_SPEED_ACC
+
+	// (optional) the time at which the position has been measured.
+	// It defaults to the time at which the message is built.
+	Timestamp time.Time
+
+	// the accuracy of Timestamp.
+	TimestampAccuracy common.MAV_ODID_TIME_ACC
+}
+
+// UnknownLocation returns a Location in which all values are unknown.
+func UnknownLocation() Location {
+	nan := math.NaN()
+	return Location{
+		Direction:          nan,
+		SpeedHorizontal:    nan,
+		SpeedVertical:      nan,
+		Latitude:           nan,
+		Longitude:          nan,
+		AltitudeBarometric: nan,
+		AltitudeGeodetic:   nan,
+		Height:             nan,
+	}
+}
+
+// Message validates the fields and builds an OPEN_DRONE_ID_LOCATION message.
+func (l Location) Message() (*common.MessageOpenDroneIdLocation, error) {
+	err := checkCoordinates(l.Latitude, l.Longitude)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &common.MessageOpenDroneIdLocation{
+		Status:             l.Status,
+		Latitude:           encodeCoordinate(l.Latitude),
+		Longitude:          encodeCoordinate(l.Longitude),
+		AltitudeBarometric: encodeAltitude(l.AltitudeBarometric),
+		AltitudeGeodetic:   encodeAltitude(l.AltitudeGeodetic),
+		HeightReference:    l.HeightReference,
+		Height:             encodeAltitude(l.Height),
+		HorizontalAccuracy: l.HorizontalAccuracy,
+		VerticalAccuracy:   l.VerticalAccuracy,
+		BarometerAccuracy:  l.BarometerAccuracy,
+		SpeedAccuracy:      l.SpeedAccuracy,
+		TimestampAccuracy:  l.TimestampAccuracy,
+	}
+
+	switch {
+	case math.IsNaN(l.Direction):
+		m.Direction = directionUnknown
+
+	case l.Direction < 0 || l.Direction >= 360:
+		return nil, fmt.Errorf("invalid direction")
+
+	default:
+		m.Direction = uint16(math.Min(math.Round(l.Direction*100), directionMax))
+	}
+
+	switch {
+	case math.IsNaN(l.SpeedHorizontal):
+		m.SpeedHorizontal = speedHorizontalUnknown
+
+	case l.SpeedHorizontal < 0:
+		return nil, fmt.Errorf("invalid horizontal speed")
+
+	default:
+		m.SpeedHorizontal = uint16(math.Min(math.Round(l.SpeedHorizontal*100), speedHorizontalMax))
+	}
+
+	if math.IsNaN(l.SpeedVertical) {
+		m.SpeedVertical = speedVerticalUnknown
+	} else {
+		v := math.Round(l.SpeedVertical * 100)
+		v = math.Max(math.Min(v, speedVerticalMax), -speedVerticalMax)
+		m.SpeedVertical = int16(v)
+	}
+
+	// seconds after the full hour, in UTC
+	t := l.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+	m.Timestamp = float32(t.Sub(t.Truncate(time.Hour)).Seconds())
+
+	return m, nil
+}
+
+// SelfId contains a description of the operation.
+type SelfId struct {
+	// the type of Description.
+	DescriptionType common.MAV_ODID_DESC_TYPE
+
+	// the description, with a maximum length of 23 characters.
+	Description string
+}
+
+// Message validates the fields and builds an OPEN_DRONE_ID_SELF_ID message.
+func (s SelfId) Message() (*common.MessageOpenDroneIdSelfId, error) {
+	err := checkString("Description", s.Description, descriptionMaxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return &common.MessageOpenDroneIdSelfId{
+		DescriptionType: s.DescriptionType,
+		Description:     s.Description,
+	}, nil
+}
+
+// System contains the location of the operator and the classification of
+// the unmanned aircraft. Unknown values must be set to NaN.
+type System struct {
+	// the source of the operator location.
+	OperatorLocationType common.MAV_ODID_OPERATOR_LOCATION_TYPE
+
+	// the classification type of the unmanned aircraft.
+	ClassificationType common.MAV_ODID_CLASSIFICATION_TYPE
+
+	// latitude of the operator, in degrees.
+	OperatorLatitude float64
+
+	// longitude of the operator, in degrees.
+	OperatorLongitude float64
+
+	// (optional) number of aircraft in the area, group or formation.
+	// It defaults to 1.
+	AreaCount uint16
+
+	// radius of the area of the group or formation, in meters.
+	AreaRadius uint16
+
+	// area ceiling (WGS84), in meters.
+	AreaCeiling float64
+
+	// area floor (WGS84), in meters.
+	AreaFloor float64
+
+	// the category of the unmanned aircraft, when ClassificationType is EU.
+	CategoryEu common.MAV_ODID_CATEGORY_EU
+
+	// the class of the unmanned aircraft, when ClassificationType is EU.
+	ClassEu common.MAV_ODID_CLASS_EU
+}
+
+// Message validates the fields and builds an OPEN_DRONE_ID_SYSTEM message.
+func (s System) Message() (*common.MessageOpenDroneIdSystem, error) {
+	err := checkCoordinates(s.OperatorLatitude, s.OperatorLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.ClassificationType != common.MAV_ODID_CLASSIFICATION_TYPE_EU &&
+		(s.CategoryEu != common.MAV_ODID_CATEGORY_EU_UNDECLARED ||
+			s.ClassEu != common.MAV_ODID_CLASS_EU_UNDECLARED) {
+		return nil, fmt.Errorf("CategoryEu and ClassEu require the EU classification type")
+	}
+
+	areaCount := s.AreaCount
+	if areaCount == 0 {
+		areaCount = 1
+	}
+
+	return &common.MessageOpenDroneIdSystem{
+		OperatorLocationType: s.OperatorLocationType,
+		ClassificationType:   s.ClassificationType,
+		OperatorLatitude:     encodeCoordinate(s.OperatorLatitude),
+		OperatorLongitude:    encodeCoordinate(s.OperatorLongitude),
+		AreaCount:            areaCount,
+		AreaRadius:           s.AreaRadius,
+		AreaCeiling:          encodeAltitude(s.AreaCeiling),
+		AreaFloor:            encodeAltitude(s.AreaFloor),
+		CategoryEu:           s.CategoryEu,
+		ClassEu:              s.ClassEu,
+	}, nil
+}
+
+// OperatorId contains the identity of the operator.
+type OperatorId struct {
+	// the type of OperatorId.
+	OperatorIdType common.MAV_ODID_OPERATOR_ID_TYPE
+
+	// the identifier, i.e. the registration number assigned by the civil
+	// aviation authority, with a maximum length of 20 characters.
+	OperatorId string
+}
+
+// Message validates the fields and builds an OPEN_DRONE_ID_OPERATOR_ID message.
+func (o OperatorId) Message() (*common.MessageOpenDroneIdOperatorId, error) {
+	if o.OperatorId == "" {
+		return nil, fmt.Errorf("OperatorId not provided")
+	}
+
+	err := checkString("OperatorId", o.OperatorId, operatorIdMaxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return &common.MessageOpenDroneIdOperatorId{
+		OperatorIdType: o.OperatorIdType,
+		OperatorId:     o.OperatorId,
+	}, nil
+}
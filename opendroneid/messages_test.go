@@ -0,0 +1,128 @@
+package opendroneid
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestBasicId(t *testing.T) {
+	m, err := BasicId{
+		IdType: common.MAV_ODID_ID_TYPE_SERIAL_NUMBER,
+		UaType: common.MAV_ODID_UA_TYPE_HELICOPTER_OR_MULTIROTOR,
+		UasId:  "1596F123456789",
+	}.Message()
+	require.NoError(t, err)
+	require.Equal(t, common.MAV_ODID_ID_TYPE_SERIAL_NUMBER, m.IdType)
+	require.Equal(t, [20]uint8{'1', '5', '9', '6', 'F', '1', '2', '3', '4', '5', '6', '7', '8', '9'}, m.UasId)
+
+	for _, ca := range []struct {
+		name string
+		b    BasicId
+	}{
+		{"missing id type", BasicId{UasId: "abc"}},
+		{"missing id", BasicId{IdType: common.MAV_ODID_ID_TYPE_SERIAL_NUMBER}},
+		{"id too long", BasicId{IdType: common.MAV_ODID_ID_TYPE_SERIAL_NUMBER, UasId: "123456789012345678901"}},
+		{"id non printable", BasicId{IdType: common.MAV_ODID_ID_TYPE_SERIAL_NUMBER, UasId: "ab\x00c"}},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := ca.b.Message()
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 10, 5, 30, 500000000, time.UTC)
+
+	m, err := Location{
+		Status:             common.MAV_ODID_STATUS_AIRBORNE,
+		Direction:          90.5,
+		SpeedHorizontal:    300,
+		SpeedVertical:      -1.5,
+		Latitude:           45.1234567,
+		Longitude:          -7.7654321,
+		AltitudeBarometric: 120,
+		AltitudeGeodetic:   math.NaN(),
+		Height:             50,
+		Timestamp:          ts,
+	}.Message()
+	require.NoError(t, err)
+	require.Equal(t, uint16(9050), m.Direction)
+	require.Equal(t, uint16(speedHorizontalMax), m.SpeedHorizontal)
+	require.Equal(t, int16(-150), m.SpeedVertical)
+	require.Equal(t, int32(451234567), m.Latitude)
+	require.Equal(t, int32(-77654321), m.Longitude)
+	require.Equal(t, float32(120), m.AltitudeBarometric)
+	require.Equal(t, float32(altitudeUnknown), m.AltitudeGeodetic)
+	require.Equal(t, float32(330.5), m.Timestamp)
+
+	m, err = UnknownLocation().Message()
+	require.NoError(t, err)
+	require.Equal(t, uint16(directionUnknown), m.Direction)
+	require.Equal(t, uint16(speedHorizontalUnknown), m.SpeedHorizontal)
+	require.Equal(t, int16(speedVerticalUnknown), m.SpeedVertical)
+	require.Equal(t, int32(0), m.Latitude)
+	require.Equal(t, int32(0), m.Longitude)
+	require.Equal(t, float32(altitudeUnknown), m.Height)
+
+	for _, ca := range []struct {
+		name string
+		edit func(l *Location)
+	}{
+		{"invalid direction", func(l *Location) { l.Direction = 360 }},
+		{"negative speed", func(l *Location) { l.SpeedHorizontal = -1 }},
+		{"invalid latitude", func(l *Location) { l.Latitude, l.Longitude = 91, 0 }},
+		{"partial position", func(l *Location) { l.Latitude = 10 }},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			l := UnknownLocation()
+			ca.edit(&l)
+			_, err := l.Message()
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestSelfId(t *testing.T) {
+	m, err := SelfId{Description: "survey"}.Message()
+	require.NoError(t, err)
+	require.Equal(t, "survey", m.Description)
+
+	_, err = SelfId{Description: "a description that is too long"}.Message()
+	require.Error(t, err)
+}
+
+func TestSystem(t *testing.T) {
+	m, err := System{
+		OperatorLocationType: common.MAV_ODID_OPERATOR_LOCATION_TYPE_LIVE_GNSS,
+		OperatorLatitude:     45,
+		OperatorLongitude:    7,
+		AreaCeiling:          math.NaN(),
+		AreaFloor:            math.NaN(),
+	}.Message()
+	require.NoError(t, err)
+	require.Equal(t, int32(450000000), m.OperatorLatitude)
+	require.Equal(t, uint16(1), m.AreaCount)
+	require.Equal(t, float32(altitudeUnknown), m.AreaCeiling)
+
+	_, err = System{
+		OperatorLatitude:  math.NaN(),
+		OperatorLongitude: math.NaN(),
+		CategoryEu:        common.MAV_ODID_CATEGORY_EU_OPEN,
+	}.Message()
+	require.Error(t, err)
+}
+
+func TestOperatorId(t *testing.T) {
+	m, err := OperatorId{OperatorId: "FIN87astrdge12k8"}.Message()
+	require.NoError(t, err)
+	require.Equal(t, "FIN87astrdge12k8", m.OperatorId)
+
+	_, err = OperatorId{}.Message()
+	require.Error(t, err)
+}
@@ -0,0 +1,234 @@
+// Package opendroneid implements builders and a broadcaster of Open Drone ID
+// messages (OPEN_DRONE_ID_*), that allow to drive Remote ID transmitters.
+//
+// Builders convert values expressed in common units (degrees, meters, m/s)
+// into messages, validating them and encoding unknown values as required by
+// the specification. The broadcaster sends the messages periodically: location
+// and system messages, that contain dynamic data, every second, while
+// the others every 3 seconds.
+//
+// The node to which the broadcaster is attached must use a dialect that
+// contains the common messages.
+package opendroneid
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// Conf allows to configure a Broadcaster.
+type Conf struct {
+	// the node used to send messages.
+	Node *gomavlib.Node
+
+	// (optional) the system id of the Remote ID transmitter.
+	// If zero, messages are sent to all systems.
+	TargetSystem byte
+
+	// (optional) the component id of the Remote ID transmitter.
+	// If zero, messages are sent to all components.
+	TargetComponent byte
+
+	// the identity of the unmanned aircraft.
+	BasicId BasicId
+
+	// the location of the operator and the classification of the aircraft.
+	// It can be updated with SetSystem().
+	System System
+
+	// (optional) the description of the operation.
+	SelfId *SelfId
+
+	// (optional) the identity of the operator.
+	OperatorId *OperatorId
+
+	// (optional) the period of location and system messages.
+	// It defaults to 1s, that is the maximum allowed.
+	DynamicPeriod time.Duration
+
+	// (optional) the period of the other messages.
+	// It defaults to 3s, that is the maximum allowed.
+	StaticPeriod time.Duration
+}
+
+// Broadcaster periodically sends Open Drone ID messages.
+// Until SetLocation() is called, the location is sent with all values unknown.
+type Broadcaster struct {
+	conf Conf
+
+	mutex    sync.Mutex
+	basicId  *common.MessageOpenDroneIdBasicId
+	selfId   *common.MessageOpenDroneIdSelfId
+	operator *common.MessageOpenDroneIdOperatorId
+	location *common.MessageOpenDroneIdLocation
+	system   *common.MessageOpenDroneIdSystem
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Broadcaster. See Conf for the options.
+func New(conf Conf) (*Broadcaster, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageOpenDroneIdBasicId{},
+		&common.MessageOpenDroneIdLocation{},
+		&common.MessageOpenDroneIdSelfId{},
+		&common.MessageOpenDroneIdSystem{},
+		&common.MessageOpenDroneIdOperatorId{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.DynamicPeriod == 0 {
+		conf.DynamicPeriod = 1 * time.Second
+	}
+	if conf.DynamicPeriod > 1*time.Second {
+		return nil, fmt.Errorf("DynamicPeriod must be <= 1s")
+	}
+	if conf.StaticPeriod == 0 {
+		conf.StaticPeriod = 3 * time.Second
+	}
+	if conf.StaticPeriod > 3*time.Second {
+		return nil, fmt.Errorf("StaticPeriod must be <= 3s")
+	}
+
+	b := &Broadcaster{
+		conf:      conf,
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	b.basicId, err = conf.BasicId.Message()
+	if err != nil {
+		return nil, err
+	}
+	b.basicId.TargetSystem = conf.TargetSystem
+	b.basicId.TargetComponent = conf.TargetComponent
+
+	if conf.SelfId != nil {
+		b.selfId, err = conf.SelfId.Message()
+		if err != nil {
+			return nil, err
+		}
+		b.selfId.TargetSystem = conf.TargetSystem
+		b.selfId.TargetComponent = conf.TargetComponent
+	}
+
+	if conf.OperatorId != nil {
+		b.operator, err = conf.OperatorId.Message()
+		if err != nil {
+			return nil, err
+		}
+		b.operator.TargetSystem = conf.TargetSystem
+		b.operator.TargetComponent = conf.TargetComponent
+	}
+
+	err = b.SetSystem(conf.System)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.SetLocation(UnknownLocation())
+	if err != nil {
+		return nil, err
+	}
+
+	go b.run()
+
+	return b, nil
+}
+
+// Close stops the broadcaster.
+func (b *Broadcaster) Close() {
+	close(b.terminate)
+	<-b.done
+}
+
+// SetLocation sets the location of the unmanned aircraft.
+func (b *Broadcaster) SetLocation(l Location) error {
+	m, err := l.Message()
+	if err != nil {
+		return err
+	}
+	m.TargetSystem = b.conf.TargetSystem
+	m.TargetComponent = b.conf.TargetComponent
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.location = m
+	return nil
+}
+
+// SetSystem sets the location of the operator and the classification of the
+// unmanned aircraft.
+func (b *Broadcaster) SetSystem(s System) error {
+	m, err := s.Message()
+	if err != nil {
+		return err
+	}
+	m.TargetSystem = b.conf.TargetSystem
+	m.TargetComponent = b.conf.TargetComponent
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.system = m
+	return nil
+}
+
+func (b *Broadcaster) sendDynamic() {
+	b.mutex.Lock()
+	location := b.location
+	system := b.system
+	b.mutex.Unlock()
+
+	b.conf.Node.WriteMessageAll(location)
+	b.conf.Node.WriteMessageAll(system)
+}
+
+func (b *Broadcaster) sendStatic() {
+	b.conf.Node.WriteMessageAll(b.basicId)
+	if b.selfId != nil {
+		b.conf.Node.WriteMessageAll(b.selfId)
+	}
+	if b.operator != nil {
+		b.conf.Node.WriteMessageAll(b.operator)
+	}
+}
+
+func (b *Broadcaster) run() {
+	defer close(b.done)
+
+	b.sendStatic()
+	b.sendDynamic()
+
+	dynamicTicker := time.NewTicker(b.conf.DynamicPeriod)
+	defer dynamicTicker.Stop()
+
+	staticTicker := time.NewTicker(b.conf.StaticPeriod)
+	defer staticTicker.Stop()
+
+	for {
+		select {
+		case <-dynamicTicker.C:
+			b.sendDynamic()
+
+		case <-staticTicker.C:
+			b.sendStatic()
+
+		case <-b.terminate:
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package opendroneid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestBroadcaster(t *testing.T) {
+	transmitter, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5700"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer transmitter.Close()
+
+	autopilot, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5700"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer autopilot.Close()
+
+	go func() {
+		for range autopilot.Events() {
+		}
+	}()
+
+	_, err = New(Conf{
+		Node:    autopilot,
+		BasicId: BasicId{UasId: "abc"},
+	})
+	require.Error(t, err)
+
+	b, err := New(Conf{
+		Node:            autopilot,
+		TargetSystem:    1,
+		TargetComponent: byte(common.MAV_COMP_ID_ODID_TXRX_1),
+		BasicId: BasicId{
+			IdType: common.MAV_ODID_ID_TYPE_SERIAL_NUMBER,
+			UaType: common.MAV_ODID_UA_TYPE_HELICOPTER_OR_MULTIROTOR,
+			UasId:  "1596F123456789",
+		},
+		OperatorId:    &OperatorId{OperatorId: "FIN87astrdge12k8"},
+		DynamicPeriod: 50 * time.Millisecond,
+		StaticPeriod:  150 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer b.Close()
+
+	err = b.SetLocation(Location{
+		Latitude:  45,
+		Longitude: 7,
+	})
+	require.NoError(t, err)
+
+	counts := make(map[uint32]int)
+	var location *common.MessageOpenDroneIdLocation
+	timeout := time.After(500 * time.Millisecond)
+outer:
+	for {
+		select {
+		case evt := <-transmitter.Events():
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				counts[fr.Message().GetId()]++
+				if m, ok := fr.Message().(*common.MessageOpenDroneIdLocation); ok {
+					require.Equal(t, uint8(1), m.TargetSystem)
+					location = m
+				}
+			}
+		case <-timeout:
+			break outer
+		}
+	}
+
+	require.NotNil(t, location)
+	require.Equal(t, int32(450000000), location.Latitude)
+	require.True(t, counts[(&common.MessageOpenDroneIdLocation{}).GetId()] >= 5)
+	require.True(t, counts[(&common.MessageOpenDroneIdSystem{}).GetId()] >= 5)
+	require.True(t, counts[(&common.MessageOpenDroneIdBasicId{}).GetId()] >= 2)
+	require.True(t, counts[(&common.MessageOpenDroneIdOperatorId{}).GetId()] >= 2)
+	require.Equal(t, 0, counts[(&common.MessageOpenDroneIdSelfId{}).GetId()])
+}
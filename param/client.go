@@ -0,0 +1,560 @@
+package param
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const valueQueueSize = 64
+
+// name of the parameter that contains the hash of all the other parameters.
+// Vehicles that support it send it before the others when parameters are
+// listed, and stop the listing when it is set.
+const hashParam = "_HASH_CHECK"
+
+// paramValue is a received parameter, with the parameter count of the vehicle
+// and its raw value.
+type paramValue struct {
+	Param
+	count int
+	raw   float32
+}
+
+// Change is a change of a cached parameter.
+type Change struct {
+	// the parameter, with its new value.
+	Param Param
+
+	// the previous value. It is zero when the parameter has been added.
+	Previous float64
+
+	// whether the parameter was not in the cache.
+	Added bool
+}
+
+// ErrTimeout is returned when the vehicle stops answering.
+var ErrTimeout = fmt.Errorf("parameter request timed out")
+
+// ClientConf allows to configure a Client.
+type ClientConf struct {
+	// the node with which parameters are exchanged.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the encoding of integer values.
+	// It defaults to EncodingBytewise.
+	Encoding Encoding
+
+	// (optional) the time after which a request that has not been answered
+	// is sent again. It defaults to 1 second.
+	Timeout time.Duration
+
+	// (optional) the maximum number of retransmissions of a request.
+	// It defaults to 3.
+	MaxRetransmissions int
+
+	// (optional) a store that persists the parameters of the vehicle.
+	// The cache is filled with the stored parameters, and listings of
+	// vehicles that report a parameter hash are stopped, and the stored
+	// parameters are returned, when the hash has not changed. When the hash
+	// has changed, all the parameters are listed again, since the protocol
+	// doesn't allow to find out which ones have changed.
+	Store Store
+
+	// (optional) the key of the vehicle in the store.
+	// It defaults to "<SystemId>-<ComponentId>".
+	StoreKey string
+
+	// (optional) a function that is called when a received value changes
+	// the cache, including changes performed by other ground stations.
+	// It is called by the routine of the node, therefore it must not block.
+	OnChange func(Change)
+}
+
+// Client reads and writes the parameters of a vehicle, and keeps a cache of
+// their values and types, that is updated with every received PARAM_VALUE
+// message, including the ones that are not requested by the client.
+type Client struct {
+	conf          ClientConf
+	removeHandler func()
+
+	// serializes requests
+	requestMutex sync.Mutex
+
+	mutex  sync.Mutex
+	cache  map[string]Param
+	hash   uint32 // hash of the cache, zero if unknown or outdated
+	active bool
+	values chan paramValue
+
+	terminate chan struct{}
+}
+
+// NewClient allocates a Client. See ClientConf for the options.
+func NewClient(conf ClientConf) (*Client, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageParamRequestList{},
+		&common.MessageParamRequestRead{},
+		&common.MessageParamSet{},
+		&common.MessageParamValue{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 1 * time.Second
+	}
+	if conf.MaxRetransmissions == 0 {
+		conf.MaxRetransmissions = 3
+	}
+	if conf.StoreKey == "" {
+		conf.StoreKey = fmt.Sprintf("%d-%d", conf.SystemId, conf.ComponentId)
+	}
+
+	c := &Client{
+		conf:      conf,
+		cache:     make(map[string]Param),
+		values:    make(chan paramValue, valueQueueSize),
+		terminate: make(chan struct{}),
+	}
+
+	if conf.Store != nil {
+		if e, ok := conf.Store.Load(conf.StoreKey); ok {
+			for _, p := range e.Params {
+				c.cache[p.Name] = p
+			}
+			c.hash = e.Hash
+		}
+	}
+
+	c.removeHandler = conf.Node.AddFrameHandler(c.onEventFrame)
+
+	return c, nil
+}
+
+// Close stops the client. Pending requests return an error.
+// It must be called before closing the node.
+func (c *Client) Close() {
+	c.removeHandler()
+	close(c.terminate)
+}
+
+func (c *Client) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != c.conf.SystemId || evt.ComponentId() != c.conf.ComponentId {
+		return
+	}
+
+	if evt.Message().GetId() != (&common.MessageParamValue{}).GetId() {
+		return
+	}
+
+	var pv common.MessageParamValue
+	if msg.Convert(&pv, evt.Message()) != nil {
+		return
+	}
+
+	v, err := decodeValue(pv.ParamValue, pv.ParamType, c.conf.Encoding)
+	if err != nil {
+		return
+	}
+
+	p := Param{
+		Name:  pv.ParamId,
+		Index: int(pv.ParamIndex),
+		Type:  pv.ParamType,
+		Value: v,
+	}
+	if pv.ParamIndex == 0xFFFF {
+		p.Index = -1
+	}
+
+	c.mutex.Lock()
+
+	var change *Change
+	if p.Name != hashParam {
+		prev, ok := c.cache[p.Name]
+		switch {
+		case !ok:
+			change = &Change{Param: p, Added: true}
+		case prev.Value != p.Value || prev.Type != p.Type:
+			change = &Change{Param: p, Previous: prev.Value}
+		}
+
+		if change != nil {
+			c.hash = 0
+		}
+
+		// the index is unknown when the parameter is changed by
+		// another ground station
+		if p.Index < 0 && ok {
+			p.Index = prev.Index
+		}
+
+		c.cache[p.Name] = p
+	}
+
+	if c.active {
+		// frame handlers must not block
+		select {
+		case c.values <- paramValue{p, int(pv.ParamCount), pv.ParamValue}:
+		default:
+		}
+	}
+
+	c.mutex.Unlock()
+
+	if change != nil && c.conf.OnChange != nil {
+		c.conf.OnChange(*change)
+	}
+}
+
+func (c *Client) startRequest() {
+	c.requestMutex.Lock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.active = true
+
+	// discard the values of previous requests
+	for len(c.values) > 0 {
+		<-c.values
+	}
+}
+
+func (c *Client) stopRequest() {
+	c.mutex.Lock()
+	c.active = false
+	c.mutex.Unlock()
+
+	c.requestMutex.Unlock()
+}
+
+// exchange writes a request and passes the received values to handle,
+// until it returns true. The request is sent again when no value is accepted
+// within the timeout.
+func (c *Client) exchange(ctx context.Context, req msg.Message, handle func(paramValue) bool) error {
+	c.conf.Node.WriteMessageAll(req)
+
+	retransmissions := 0
+
+	timer := time.NewTimer(c.conf.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case v := <-c.values:
+			if handle(v) {
+				return nil
+			}
+
+		case <-timer.C:
+			if retransmissions >= c.conf.MaxRetransmissions {
+				return ErrTimeout
+			}
+
+			retransmissions++
+			c.conf.Node.WriteMessageAll(req)
+			timer.Reset(c.conf.Timeout)
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-c.terminate:
+			return fmt.Errorf("terminated")
+		}
+	}
+}
+
+// Cached returns a parameter from the cache, without contacting the vehicle.
+func (c *Client) Cached(name string) (Param, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, ok := c.cache[name]
+	return p, ok
+}
+
+// cached returns the cached parameters, sorted by index, if the cache
+// corresponds to the given hash.
+func (c *Client) cached(hash uint32) ([]Param, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if hash == 0 || hash != c.hash {
+		return nil, false
+	}
+
+	ret := make([]Param, 0, len(c.cache))
+	for _, p := range c.cache {
+		if p.Index >= 0 {
+			ret = append(ret, p)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Index < ret[j].Index
+	})
+
+	return ret, true
+}
+
+// GetAll reads all the parameters of the vehicle, sorted by index.
+// Parameters that are lost during the listing are requested again one by
+// one, by index.
+// When the vehicle reports a parameter hash that is equal to the one of the
+// cache, the listing is stopped and the cached parameters are returned.
+// Otherwise, all the parameters are received again.
+// Read parameters are saved into ClientConf.Store.
+func (c *Client) GetAll(ctx context.Context) ([]Param, error) {
+	c.startRequest()
+	defer c.stopRequest()
+
+	count := -1
+	received := make(map[int]Param)
+	var hash uint32
+	var cached []Param
+
+	handle := func(v paramValue) bool {
+		if v.Name == hashParam {
+			// the hash is sent before the other parameters
+			if count >= 0 {
+				return false
+			}
+
+			// the hash is transmitted bytewise
+			hash = math.Float32bits(v.raw)
+
+			var ok bool
+			cached, ok = c.cached(hash)
+			if !ok {
+				return false
+			}
+
+			// setting the hash stops the listing
+			c.conf.Node.WriteMessageAll(&common.MessageParamSet{
+				TargetSystem:    c.conf.SystemId,
+				TargetComponent: c.conf.ComponentId,
+				ParamId:         hashParam,
+				ParamValue:      v.raw,
+				ParamType:       v.Type,
+			})
+			return true
+		}
+
+		if v.Index < 0 {
+			return false
+		}
+
+		if count < 0 {
+			count = v.count
+		}
+		if v.Index < count {
+			received[v.Index] = v.Param
+		}
+		return len(received) == count
+	}
+
+	// values are collected until they stop arriving, then the missing ones
+	// are requested by index
+	req := &common.MessageParamRequestList{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+	}
+	c.conf.Node.WriteMessageAll(req)
+
+	retransmissions := 0
+	timer := time.NewTimer(c.conf.Timeout)
+	defer timer.Stop()
+
+outer:
+	for {
+		select {
+		case v := <-c.values:
+			if handle(v) {
+				break outer
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(c.conf.Timeout)
+
+		case <-timer.C:
+			// values have been received, recover the missing ones
+			if count >= 0 {
+				break outer
+			}
+
+			if retransmissions >= c.conf.MaxRetransmissions {
+				return nil, ErrTimeout
+			}
+
+			retransmissions++
+			c.conf.Node.WriteMessageAll(req)
+			timer.Reset(c.conf.Timeout)
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-c.terminate:
+			return nil, fmt.Errorf("terminated")
+		}
+	}
+
+	if cached != nil {
+		return cached, nil
+	}
+
+	for i := 0; i < count; i++ {
+		if _, ok := received[i]; ok {
+			continue
+		}
+
+		err := c.exchange(ctx, &common.MessageParamRequestRead{
+			TargetSystem:    c.conf.SystemId,
+			TargetComponent: c.conf.ComponentId,
+			ParamIndex:      int16(i),
+		}, func(v paramValue) bool {
+			handle(v)
+			_, ok := received[i]
+			return ok
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make([]Param, 0, count)
+	for _, p := range received {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Index < ret[j].Index
+	})
+
+	c.mutex.Lock()
+	c.hash = hash
+	c.mutex.Unlock()
+
+	if c.conf.Store != nil {
+		// errors are ignored, since parameters have been read anyway
+		c.conf.Store.Save(c.conf.StoreKey, StoreEntry{
+			Hash:   hash,
+			Params: ret,
+		})
+	}
+
+	return ret, nil
+}
+
+// Get reads a parameter of the vehicle.
+func (c *Client) Get(ctx context.Context, name string) (Param, error) {
+	err := checkName(name)
+	if err != nil {
+		return Param{}, err
+	}
+
+	c.startRequest()
+	defer c.stopRequest()
+
+	return c.get(ctx, name)
+}
+
+func (c *Client) get(ctx context.Context, name string) (Param, error) {
+	var ret Param
+
+	err := c.exchange(ctx, &common.MessageParamRequestRead{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		ParamId:         name,
+		ParamIndex:      -1,
+	}, func(v paramValue) bool {
+		if v.Name != name {
+			return false
+		}
+		ret = v.Param
+		return true
+	})
+
+	return ret, err
+}
+
+// Set writes a parameter of the vehicle, and returns the value that has been
+// confirmed by the vehicle. The type of the parameter is taken from the
+// cache, or is read from the vehicle when the parameter is not cached.
+func (c *Client) Set(ctx context.Context, name string, value float64) (Param, error) {
+	err := checkName(name)
+	if err != nil {
+		return Param{}, err
+	}
+
+	c.startRequest()
+	defer c.stopRequest()
+
+	cur, ok := c.Cached(name)
+	if !ok {
+		cur, err = c.get(ctx, name)
+		if err != nil {
+			return Param{}, err
+		}
+	}
+
+	raw, err := encodeValue(value, cur.Type, c.conf.Encoding)
+	if err != nil {
+		return Param{}, err
+	}
+
+	var ret Param
+
+	err = c.exchange(ctx, &common.MessageParamSet{
+		TargetSystem:    c.conf.SystemId,
+		TargetComponent: c.conf.ComponentId,
+		ParamId:         name,
+		ParamValue:      raw,
+		ParamType:       cur.Type,
+	}, func(v paramValue) bool {
+		if v.Name != name {
+			return false
+		}
+		ret = v.Param
+		return true
+	})
+	if err != nil {
+		return Param{}, err
+	}
+
+	if float32(ret.Value) != float32(value) {
+		return ret, fmt.Errorf("parameter %s has been set to %v instead of %v",
+			name, ret.Value, value)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,386 @@
+package param
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// testVehicle implements the vehicle side of the parameter protocol.
+type testVehicle struct {
+	node *gomavlib.Node
+
+	mutex sync.Mutex
+	// parameters with their encoded value
+	params []common.MessageParamValue
+	// indexes that are not sent during the first listing, to simulate losses
+	skip map[int]struct{}
+	// the maximum value that can be set
+	max float32
+	// disables answers
+	mute bool
+	// the parameter hash, zero to disable hash checks
+	hash uint32
+	// whether the listing has been stopped by setting the hash
+	hashStopped bool
+	// the number of parameters sent by listings
+	listed int
+}
+
+func newTestVehicle(t *testing.T) (*gomavlib.Node, *testVehicle) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{
+		node: node,
+		params: []common.MessageParamValue{
+			{ParamId: "SYS_AUTOSTART", ParamValue: mustEncode(4001, common.MAV_PARAM_TYPE_INT32), ParamType: common.MAV_PARAM_TYPE_INT32},
+			{ParamId: "MPC_XY_VEL_MAX", ParamValue: 12, ParamType: common.MAV_PARAM_TYPE_REAL32},
+			{ParamId: "COM_RC_IN_MODE", ParamValue: mustEncode(1, common.MAV_PARAM_TYPE_INT32), ParamType: common.MAV_PARAM_TYPE_INT32},
+			{ParamId: "MAV_TYPE", ParamValue: mustEncode(2, common.MAV_PARAM_TYPE_UINT8), ParamType: common.MAV_PARAM_TYPE_UINT8},
+		},
+		max: 1000,
+	}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		v.onMessage(fr.Message())
+	})
+
+	return gcs, v
+}
+
+func mustEncode(v float64, typ common.MAV_PARAM_TYPE) float32 {
+	raw, err := encodeValue(v, typ, EncodingBytewise)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func (v *testVehicle) send(i int) {
+	pv := v.params[i]
+	pv.ParamCount = uint16(len(v.params))
+	pv.ParamIndex = uint16(i)
+	v.node.WriteMessageAll(&pv)
+}
+
+func (v *testVehicle) find(name string) int {
+	for i, p := range v.params {
+		if p.ParamId == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (v *testVehicle) list() {
+	for i := range v.params {
+		if _, ok := v.skip[i]; ok {
+			continue
+		}
+		v.send(i)
+		v.listed++
+	}
+	v.skip = nil
+}
+
+func (v *testVehicle) onMessage(m msg.Message) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.mute {
+		return
+	}
+
+	switch tm := m.(type) {
+	case *common.MessageParamRequestList:
+		if v.hash == 0 {
+			v.list()
+			return
+		}
+
+		v.node.WriteMessageAll(&common.MessageParamValue{
+			ParamId:    hashParam,
+			ParamValue: math.Float32frombits(v.hash),
+			ParamType:  common.MAV_PARAM_TYPE_INT32,
+			ParamCount: uint16(len(v.params)),
+			ParamIndex: 0xFFFF,
+		})
+		v.hashStopped = false
+
+		// leave the time to stop the listing
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+
+			v.mutex.Lock()
+			defer v.mutex.Unlock()
+
+			if !v.hashStopped {
+				v.list()
+			}
+		}()
+
+	case *common.MessageParamRequestRead:
+		i := int(tm.ParamIndex)
+		if i < 0 {
+			i = v.find(tm.ParamId)
+		}
+		if i >= 0 && i < len(v.params) {
+			v.send(i)
+		}
+
+	case *common.MessageParamSet:
+		if tm.ParamId == hashParam {
+			v.hashStopped = true
+			return
+		}
+
+		i := v.find(tm.ParamId)
+		if i < 0 {
+			return
+		}
+
+		if tm.ParamType == common.MAV_PARAM_TYPE_REAL32 && tm.ParamValue > v.max {
+			tm.ParamValue = v.max
+		}
+		v.params[i].ParamValue = tm.ParamValue
+		v.send(i)
+	}
+}
+
+func newTestClient(t *testing.T, gcs *gomavlib.Node) *Client {
+	c, err := NewClient(ClientConf{
+		Node:               gcs,
+		SystemId:           1,
+		Timeout:            100 * time.Millisecond,
+		MaxRetransmissions: 2,
+	})
+	require.NoError(t, err)
+	return c
+}
+
+var testParams = []Param{
+	{"SYS_AUTOSTART", 0, common.MAV_PARAM_TYPE_INT32, 4001},
+	{"MPC_XY_VEL_MAX", 1, common.MAV_PARAM_TYPE_REAL32, 12},
+	{"COM_RC_IN_MODE", 2, common.MAV_PARAM_TYPE_INT32, 1},
+	{"MAV_TYPE", 3, common.MAV_PARAM_TYPE_UINT8, 2},
+}
+
+func TestNewClientErrors(t *testing.T) {
+	_, err := NewClient(ClientConf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	_, err = NewClient(ClientConf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+}
+
+func TestClientGetAll(t *testing.T) {
+	for _, ca := range []string{"standard", "gaps"} {
+		t.Run(ca, func(t *testing.T) {
+			gcs, v := newTestVehicle(t)
+			defer gcs.Close()
+			defer v.node.Close()
+
+			if ca == "gaps" {
+				v.skip = map[int]struct{}{1: {}, 3: {}}
+			}
+
+			c := newTestClient(t, gcs)
+			defer c.Close()
+
+			params, err := c.GetAll(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, testParams, params)
+
+			p, ok := c.Cached("MAV_TYPE")
+			require.True(t, ok)
+			require.Equal(t, testParams[3], p)
+		})
+	}
+}
+
+func TestClientGetSet(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs)
+	defer c.Close()
+
+	p, err := c.Get(context.Background(), "COM_RC_IN_MODE")
+	require.NoError(t, err)
+	require.Equal(t, testParams[2], p)
+
+	// the type is read from the vehicle
+	p, err = c.Set(context.Background(), "SYS_AUTOSTART", 4010)
+	require.NoError(t, err)
+	require.Equal(t, Param{"SYS_AUTOSTART", 0, common.MAV_PARAM_TYPE_INT32, 4010}, p)
+
+	v.mutex.Lock()
+	require.Equal(t, mustEncode(4010, common.MAV_PARAM_TYPE_INT32), v.params[0].ParamValue)
+	v.mutex.Unlock()
+
+	_, err = c.Set(context.Background(), "MAV_TYPE", 300)
+	require.EqualError(t, err, "value 300 does not fit into MAV_PARAM_TYPE_UINT8")
+
+	// the vehicle sets a different value
+	_, err = c.Set(context.Background(), "MPC_XY_VEL_MAX", 2000)
+	require.EqualError(t, err, "parameter MPC_XY_VEL_MAX has been set to 1000 instead of 2000")
+
+	_, err = c.Get(context.Background(), "UNKNOWN")
+	require.Equal(t, ErrTimeout, err)
+
+	_, err = c.Get(context.Background(), "A_NAME_LONGER_THAN_16")
+	require.EqualError(t, err, "invalid parameter name: 'A_NAME_LONGER_THAN_16'")
+}
+
+func TestClientTimeout(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	c := newTestClient(t, gcs)
+	defer c.Close()
+
+	v.mute = true
+
+	_, err := c.GetAll(context.Background())
+	require.Equal(t, ErrTimeout, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Set(ctx, "MAV_TYPE", 1)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClientStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib-param")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	v.hash = 0x12345678
+
+	newClient := func() *Client {
+		c, err := NewClient(ClientConf{
+			Node:     gcs,
+			SystemId: 1,
+			Timeout:  100 * time.Millisecond,
+			Store:    store,
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	// the first listing is complete
+	c := newClient()
+	params, err := c.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, testParams, params)
+	c.Close()
+
+	e, ok := store.Load("1-1")
+	require.True(t, ok)
+	require.Equal(t, StoreEntry{Hash: 0x12345678, Params: testParams}, e)
+
+	v.mutex.Lock()
+	require.Equal(t, 4, v.listed)
+	v.mutex.Unlock()
+
+	// the stored parameters are used when the hash has not changed
+	c = newClient()
+	p, ok := c.Cached("MAV_TYPE")
+	require.True(t, ok)
+	require.Equal(t, testParams[3], p)
+
+	params, err = c.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, testParams, params)
+	c.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	v.mutex.Lock()
+	require.Equal(t, true, v.hashStopped)
+	require.Equal(t, 4, v.listed)
+
+	// parameters are listed again when the hash changes
+	v.hash = 0x12345679
+	v.params[1].ParamValue = 10
+	v.mutex.Unlock()
+
+	c = newClient()
+	params, err = c.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, float64(10), params[1].Value)
+	c.Close()
+
+	v.mutex.Lock()
+	require.Equal(t, 8, v.listed)
+	v.mutex.Unlock()
+
+	e, ok = store.Load("1-1")
+	require.True(t, ok)
+	require.Equal(t, uint32(0x12345679), e.Hash)
+	require.Equal(t, float64(10), e.Params[1].Value)
+}
+
+func TestClientOnChange(t *testing.T) {
+	gcs, v := newTestVehicle(t)
+	defer gcs.Close()
+	defer v.node.Close()
+
+	changes := make(chan Change, 10)
+
+	c, err := NewClient(ClientConf{
+		Node:     gcs,
+		SystemId: 1,
+		Timeout:  100 * time.Millisecond,
+		OnChange: func(ch Change) {
+			changes <- ch
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Get(context.Background(), "MAV_TYPE")
+	require.NoError(t, err)
+	require.Equal(t, Change{Param: testParams[3], Added: true}, <-changes)
+
+	// values that don't change are not notified
+	_, err = c.Get(context.Background(), "MAV_TYPE")
+	require.NoError(t, err)
+
+	// changes performed by other ground stations are notified
+	v.mutex.Lock()
+	v.params[3].ParamValue = mustEncode(1, common.MAV_PARAM_TYPE_UINT8)
+	v.send(3)
+	v.mutex.Unlock()
+
+	require.Equal(t, Change{
+		Param:    Param{"MAV_TYPE", 3, common.MAV_PARAM_TYPE_UINT8, 1},
+		Previous: 2,
+	}, <-changes)
+	require.Equal(t, 0, len(changes))
+}
@@ -0,0 +1,126 @@
+// Package param implements the parameter protocol, that allows to read and
+// write the configuration parameters of a vehicle (Client), and to expose the
+// parameters of a component, i.e. a companion computer, to ground stations
+// (Server).
+//
+// Parameters are carried by PARAM_VALUE messages, that contain their value
+// as a float, regardless of their type. Integer values are encoded either by
+// copying their bytes into the float (bytewise encoding, used by PX4) or by
+// casting them to float (C-cast encoding, used by ArduPilot).
+//
+// The node with which parameters are exchanged must use a dialect that
+// contains the common messages.
+package param
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	// maximum length of the name of a parameter.
+	maxNameLength = 16
+)
+
+// Encoding is the encoding of integer values inside PARAM_VALUE and
+// PARAM_SET messages.
+type Encoding int
+
+const (
+	// EncodingBytewise copies the bytes of integer values into the float field.
+	EncodingBytewise Encoding = iota
+
+	// EncodingCast casts integer values to float.
+	EncodingCast
+)
+
+// Param is a parameter.
+type Param struct {
+	// the name of the parameter.
+	Name string
+
+	// the index of the parameter, or -1 if unknown.
+	Index int
+
+	// the type of the parameter.
+	Type common.MAV_PARAM_TYPE
+
+	// the value of the parameter.
+	Value float64
+}
+
+type intRange struct {
+	bits   uint
+	signed bool
+}
+
+var intTypes = map[common.MAV_PARAM_TYPE]intRange{
+	common.MAV_PARAM_TYPE_UINT8:  {8, false},
+	common.MAV_PARAM_TYPE_INT8:   {8, true},
+	common.MAV_PARAM_TYPE_UINT16: {16, false},
+	common.MAV_PARAM_TYPE_INT16:  {16, true},
+	common.MAV_PARAM_TYPE_UINT32: {32, false},
+	common.MAV_PARAM_TYPE_INT32:  {32, true},
+}
+
+func checkName(name string) error {
+	if name == "" || len(name) > maxNameLength {
+		return fmt.Errorf("invalid parameter name: '%s'", name)
+	}
+	return nil
+}
+
+// decodeValue converts the float of a PARAM_VALUE message into the value of
+// a parameter.
+func decodeValue(raw float32, typ common.MAV_PARAM_TYPE, enc Encoding) (float64, error) {
+	if typ == common.MAV_PARAM_TYPE_REAL32 {
+		return float64(raw), nil
+	}
+
+	r, ok := intTypes[typ]
+	if !ok {
+		return 0, fmt.Errorf("unsupported parameter type: %s", typ)
+	}
+
+	if enc == EncodingCast {
+		return float64(raw), nil
+	}
+
+	bits := uint64(math.Float32bits(raw)) & (1<<r.bits - 1)
+	if r.signed && bits&(1<<(r.bits-1)) != 0 {
+		return float64(int64(bits) - 1<<r.bits), nil
+	}
+	return float64(bits), nil
+}
+
+// encodeValue converts the value of a parameter into the float of a
+// PARAM_SET message.
+func encodeValue(v float64, typ common.MAV_PARAM_TYPE, enc Encoding) (float32, error) {
+	if typ == common.MAV_PARAM_TYPE_REAL32 {
+		return float32(v), nil
+	}
+
+	r, ok := intTypes[typ]
+	if !ok {
+		return 0, fmt.Errorf("unsupported parameter type: %s", typ)
+	}
+
+	var min, max float64
+	if r.signed {
+		min, max = -float64(uint64(1)<<(r.bits-1)), float64(uint64(1)<<(r.bits-1)-1)
+	} else {
+		min, max = 0, float64(uint64(1)<<r.bits-1)
+	}
+
+	if v != math.Trunc(v) || v < min || v > max {
+		return 0, fmt.Errorf("value %v does not fit into %s", v, typ)
+	}
+
+	if enc == EncodingCast {
+		return float32(v), nil
+	}
+
+	return math.Float32frombits(uint32(int64(v)) & uint32(uint64(1)<<r.bits-1)), nil
+}
@@ -0,0 +1,48 @@
+package param
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestValueEncoding(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		typ   common.MAV_PARAM_TYPE
+		enc   Encoding
+		value float64
+		raw   float32
+	}{
+		{"real32", common.MAV_PARAM_TYPE_REAL32, EncodingBytewise, 1.5, 1.5},
+		{"int8 bytewise", common.MAV_PARAM_TYPE_INT8, EncodingBytewise, -1, math.Float32frombits(0xFF)},
+		{"uint16 bytewise", common.MAV_PARAM_TYPE_UINT16, EncodingBytewise, 500, math.Float32frombits(500)},
+		{"int32 bytewise", common.MAV_PARAM_TYPE_INT32, EncodingBytewise, -2, math.Float32frombits(0xFFFFFFFE)},
+		{"uint32 bytewise", common.MAV_PARAM_TYPE_UINT32, EncodingBytewise, 4000000000, math.Float32frombits(4000000000)},
+		{"int16 cast", common.MAV_PARAM_TYPE_INT16, EncodingCast, -300, -300},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			raw, err := encodeValue(ca.value, ca.typ, ca.enc)
+			require.NoError(t, err)
+			require.Equal(t, math.Float32bits(ca.raw), math.Float32bits(raw))
+
+			v, err := decodeValue(raw, ca.typ, ca.enc)
+			require.NoError(t, err)
+			require.Equal(t, ca.value, v)
+		})
+	}
+}
+
+func TestValueEncodingErrors(t *testing.T) {
+	_, err := encodeValue(300, common.MAV_PARAM_TYPE_UINT8, EncodingBytewise)
+	require.EqualError(t, err, "value 300 does not fit into MAV_PARAM_TYPE_UINT8")
+
+	_, err = encodeValue(1.5, common.MAV_PARAM_TYPE_INT32, EncodingCast)
+	require.EqualError(t, err, "value 1.5 does not fit into MAV_PARAM_TYPE_INT32")
+
+	_, err = encodeValue(1, common.MAV_PARAM_TYPE_REAL64, EncodingBytewise)
+	require.EqualError(t, err, "unsupported parameter type: MAV_PARAM_TYPE_REAL64")
+}
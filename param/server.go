@@ -0,0 +1,399 @@
+package param
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const requestQueueSize = 64
+
+// ServerParam is a parameter exposed by a Server.
+type ServerParam struct {
+	// the name of the parameter.
+	Name string
+
+	// the type of the parameter.
+	Type common.MAV_PARAM_TYPE
+
+	// the initial value of the parameter.
+	Value float64
+
+	// (optional) a function that is called when a ground station sets the
+	// parameter, before the value is changed. The value is not changed when
+	// it returns an error.
+	// It is called by the routine of the server, therefore it must not block.
+	OnSet func(value float64) error
+}
+
+// ServerConf allows to configure a Server.
+type ServerConf struct {
+	// the node through which parameters are exposed. Requests addressed to
+	// the system id and the component id of the node are answered.
+	Node *gomavlib.Node
+
+	// the parameters. Their index is their position in the slice.
+	Params []ServerParam
+
+	// (optional) the encoding of integer values.
+	// It defaults to EncodingBytewise.
+	Encoding Encoding
+
+	// (optional) the period between the PARAM_VALUE messages that are sent
+	// in response to a PARAM_REQUEST_LIST, in order to avoid saturating
+	// the link. It defaults to 10ms.
+	ListPeriod time.Duration
+}
+
+type serverRequest struct {
+	ch *gomavlib.Channel
+	m  msg.Message
+}
+
+// Server exposes parameters to ground stations, answering PARAM_REQUEST_LIST,
+// PARAM_REQUEST_READ and PARAM_SET messages. After every change, the new
+// value is sent to all channels.
+type Server struct {
+	conf          ServerConf
+	removeHandler func()
+
+	mutex   sync.Mutex
+	params  []ServerParam
+	indexes map[string]int
+
+	requests  chan serverRequest
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// NewServer allocates a Server. See ServerConf for the options.
+func NewServer(conf ServerConf) (*Server, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageParamRequestList{},
+		&common.MessageParamRequestRead{},
+		&common.MessageParamSet{},
+		&common.MessageParamValue{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ListPeriod == 0 {
+		conf.ListPeriod = 10 * time.Millisecond
+	}
+
+	s := &Server{
+		conf:      conf,
+		indexes:   make(map[string]int),
+		requests:  make(chan serverRequest, requestQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	for _, p := range conf.Params {
+		err := s.add(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.removeHandler = conf.Node.AddFrameHandler(s.onEventFrame)
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the server. It must be called before closing the node.
+func (s *Server) Close() {
+	s.removeHandler()
+	close(s.terminate)
+	<-s.done
+}
+
+// add must be called with the mutex locked, or before the server is started.
+func (s *Server) add(p ServerParam) error {
+	err := checkName(p.Name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.indexes[p.Name]; ok {
+		return fmt.Errorf("parameter %s is registered twice", p.Name)
+	}
+
+	_, err = encodeValue(p.Value, p.Type, s.conf.Encoding)
+	if err != nil {
+		return err
+	}
+
+	s.indexes[p.Name] = len(s.params)
+	s.params = append(s.params, p)
+	return nil
+}
+
+// Add registers a parameter. It is appended to the existing ones, therefore
+// their indexes don't change, while the parameter count announced to ground
+// stations grows.
+func (s *Server) Add(p ServerParam) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.add(p)
+}
+
+// Get returns the current value of a parameter.
+func (s *Server) Get(name string) (Param, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, ok := s.indexes[name]
+	if !ok {
+		return Param{}, false
+	}
+
+	return s.param(i), true
+}
+
+// Params returns all the parameters, sorted by index.
+func (s *Server) Params() []Param {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := make([]Param, len(s.params))
+	for i := range s.params {
+		ret[i] = s.param(i)
+	}
+	return ret
+}
+
+// Set changes the value of a parameter from the application, without calling
+// its OnSet callback, and sends the new value to all channels.
+func (s *Server) Set(name string, value float64) error {
+	s.mutex.Lock()
+
+	i, ok := s.indexes[name]
+	if !ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("parameter %s not found", name)
+	}
+
+	_, err := encodeValue(value, s.params[i].Type, s.conf.Encoding)
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+
+	s.params[i].Value = value
+	m := s.value(i)
+	s.mutex.Unlock()
+
+	s.conf.Node.WriteMessageAll(m)
+	return nil
+}
+
+// param must be called with the mutex locked.
+func (s *Server) param(i int) Param {
+	return Param{
+		Name:  s.params[i].Name,
+		Index: i,
+		Type:  s.params[i].Type,
+		Value: s.params[i].Value,
+	}
+}
+
+// value must be called with the mutex locked.
+func (s *Server) value(i int) *common.MessageParamValue {
+	p := s.params[i]
+
+	// values have been checked when registering or setting parameters
+	raw, _ := encodeValue(p.Value, p.Type, s.conf.Encoding)
+
+	return &common.MessageParamValue{
+		ParamId:    p.Name,
+		ParamValue: raw,
+		ParamType:  p.Type,
+		ParamCount: uint16(len(s.params)),
+		ParamIndex: uint16(i),
+	}
+}
+
+func (s *Server) onEventFrame(evt *gomavlib.EventFrame) {
+	nconf := s.conf.Node.Conf()
+
+	// the target component can be MAV_COMP_ID_ALL
+	addressed := func(targetSystem byte, targetComponent byte) bool {
+		return targetSystem == nconf.OutSystemId &&
+			(targetComponent == 0 || targetComponent == nconf.OutComponentId)
+	}
+
+	var m msg.Message
+
+	switch evt.Message().GetId() {
+	case (&common.MessageParamRequestList{}).GetId():
+		var req common.MessageParamRequestList
+		if msg.Convert(&req, evt.Message()) != nil ||
+			!addressed(req.TargetSystem, req.TargetComponent) {
+			return
+		}
+		m = &req
+
+	case (&common.MessageParamRequestRead{}).GetId():
+		var req common.MessageParamRequestRead
+		if msg.Convert(&req, evt.Message()) != nil ||
+			!addressed(req.TargetSystem, req.TargetComponent) {
+			return
+		}
+		m = &req
+
+	case (&common.MessageParamSet{}).GetId():
+		var req common.MessageParamSet
+		if msg.Convert(&req, evt.Message()) != nil ||
+			!addressed(req.TargetSystem, req.TargetComponent) {
+			return
+		}
+		m = &req
+
+	default:
+		return
+	}
+
+	// frame handlers must not block
+	select {
+	case s.requests <- serverRequest{evt.Channel, m}:
+	default:
+	}
+}
+
+func (s *Server) run() {
+	defer close(s.done)
+
+	// state of the listing in progress
+	var listCh *gomavlib.Channel
+	listNext := 0
+	var listTicker *time.Ticker
+	var listC <-chan time.Time
+
+	stopListing := func() {
+		if listTicker != nil {
+			listTicker.Stop()
+			listTicker = nil
+			listC = nil
+		}
+	}
+	defer stopListing()
+
+	for {
+		select {
+		case req := <-s.requests:
+			switch m := req.m.(type) {
+			case *common.MessageParamRequestList:
+				// a new request restarts the listing
+				stopListing()
+				listCh = req.ch
+				listNext = 0
+				listTicker = time.NewTicker(s.conf.ListPeriod)
+				listC = listTicker.C
+
+			case *common.MessageParamRequestRead:
+				s.handleRead(req.ch, m)
+
+			case *common.MessageParamSet:
+				s.handleSet(m)
+			}
+
+		case <-listC:
+			s.mutex.Lock()
+			if listNext >= len(s.params) {
+				s.mutex.Unlock()
+				stopListing()
+				continue
+			}
+			v := s.value(listNext)
+			s.mutex.Unlock()
+
+			s.conf.Node.WriteMessageTo(listCh, v)
+			listNext++
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+func (s *Server) handleRead(ch *gomavlib.Channel, req *common.MessageParamRequestRead) {
+	s.mutex.Lock()
+
+	i := int(req.ParamIndex)
+	if i < 0 {
+		var ok bool
+		i, ok = s.indexes[req.ParamId]
+		if !ok {
+			s.mutex.Unlock()
+			return
+		}
+	} else if i >= len(s.params) {
+		s.mutex.Unlock()
+		return
+	}
+
+	v := s.value(i)
+	s.mutex.Unlock()
+
+	s.conf.Node.WriteMessageTo(ch, v)
+}
+
+func (s *Server) handleSet(req *common.MessageParamSet) {
+	s.mutex.Lock()
+
+	i, ok := s.indexes[req.ParamId]
+	if !ok {
+		s.mutex.Unlock()
+		return
+	}
+	p := s.params[i]
+
+	s.mutex.Unlock()
+
+	// the current value is sent back when the new one is rejected
+	func() {
+		if req.ParamType != p.Type {
+			return
+		}
+
+		value, err := decodeValue(req.ParamValue, p.Type, s.conf.Encoding)
+		if err != nil {
+			return
+		}
+
+		_, err = encodeValue(value, p.Type, s.conf.Encoding)
+		if err != nil {
+			return
+		}
+
+		if p.OnSet != nil && p.OnSet(value) != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.params[i].Value = value
+		s.mutex.Unlock()
+	}()
+
+	s.mutex.Lock()
+	v := s.value(i)
+	s.mutex.Unlock()
+
+	// changes are notified to all ground stations
+	s.conf.Node.WriteMessageAll(v)
+}
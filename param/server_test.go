@@ -0,0 +1,143 @@
+package param
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+func newTestServer(t *testing.T, params []ServerParam) (*gomavlib.Node, *gomavlib.Node, *Server) {
+	e1, e2 := testnode.Pipe()
+	companionConf := testnode.Conf(1, e2)
+	companionConf.OutComponentId = 191
+
+	gcs, companion := testnode.New(t, testnode.Conf(255, e1), companionConf)
+	testnode.DiscardEvents(gcs)
+	testnode.DiscardEvents(companion)
+
+	s, err := NewServer(ServerConf{
+		Node:       companion,
+		Params:     params,
+		ListPeriod: 1 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	return gcs, companion, s
+}
+
+func TestNewServerErrors(t *testing.T) {
+	_, err := NewServer(ServerConf{})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, companion, s := newTestServer(t, nil)
+	defer gcs.Close()
+	defer companion.Close()
+	defer s.Close()
+
+	_, err = NewServer(ServerConf{
+		Node: companion,
+		Params: []ServerParam{
+			{Name: "A", Type: common.MAV_PARAM_TYPE_REAL32},
+			{Name: "A", Type: common.MAV_PARAM_TYPE_REAL32},
+		},
+	})
+	require.EqualError(t, err, "parameter A is registered twice")
+
+	_, err = NewServer(ServerConf{
+		Node:   companion,
+		Params: []ServerParam{{Name: "B", Type: common.MAV_PARAM_TYPE_UINT8, Value: 300}},
+	})
+	require.EqualError(t, err, "value 300 does not fit into MAV_PARAM_TYPE_UINT8")
+
+	err = s.Set("C", 1)
+	require.EqualError(t, err, "parameter C not found")
+}
+
+func TestServer(t *testing.T) {
+	var setValues []float64
+
+	gcs, companion, s := newTestServer(t, []ServerParam{
+		{Name: "CAM_FPS", Type: common.MAV_PARAM_TYPE_UINT8, Value: 30},
+		{Name: "CAM_GAIN", Type: common.MAV_PARAM_TYPE_REAL32, Value: 1.5},
+		{
+			Name:  "CAM_MODE",
+			Type:  common.MAV_PARAM_TYPE_INT32,
+			Value: 0,
+			OnSet: func(v float64) error {
+				setValues = append(setValues, v)
+				if v > 3 {
+					return fmt.Errorf("invalid mode")
+				}
+				return nil
+			},
+		},
+	})
+	defer gcs.Close()
+	defer companion.Close()
+	defer s.Close()
+
+	c, err := NewClient(ClientConf{
+		Node:        gcs,
+		SystemId:    1,
+		ComponentId: 191,
+		Timeout:     100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	params, err := c.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Param{
+		{Name: "CAM_FPS", Index: 0, Type: common.MAV_PARAM_TYPE_UINT8, Value: 30},
+		{Name: "CAM_GAIN", Index: 1, Type: common.MAV_PARAM_TYPE_REAL32, Value: 1.5},
+		{Name: "CAM_MODE", Index: 2, Type: common.MAV_PARAM_TYPE_INT32, Value: 0},
+	}, params)
+
+	p, err := c.Get(context.Background(), "CAM_GAIN")
+	require.NoError(t, err)
+	require.Equal(t, 1.5, p.Value)
+
+	// values accepted by the callback are set
+	p, err = c.Set(context.Background(), "CAM_MODE", 2)
+	require.NoError(t, err)
+	require.Equal(t, float64(2), p.Value)
+
+	cur, ok := s.Get("CAM_MODE")
+	require.Equal(t, true, ok)
+	require.Equal(t, float64(2), cur.Value)
+
+	// values rejected by the callback are not set
+	p, err = c.Set(context.Background(), "CAM_MODE", 5)
+	require.EqualError(t, err, "parameter CAM_MODE has been set to 2 instead of 5")
+	require.Equal(t, []float64{2, 5}, setValues)
+
+	// changes of the application are sent to ground stations
+	err = s.Set("CAM_FPS", 60)
+	require.NoError(t, err)
+
+	for i := 0; ; i++ {
+		p, ok := c.Cached("CAM_FPS")
+		if ok && p.Value == 60 {
+			break
+		}
+		require.True(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// parameters can be added at runtime
+	err = s.Add(ServerParam{Name: "CAM_ISO", Type: common.MAV_PARAM_TYPE_UINT16, Value: 400})
+	require.NoError(t, err)
+
+	params, err = c.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(params))
+	require.Equal(t, Param{Name: "CAM_ISO", Index: 3, Type: common.MAV_PARAM_TYPE_UINT16, Value: 400}, params[3])
+	require.Equal(t, params, s.Params())
+}
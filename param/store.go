@@ -0,0 +1,95 @@
+package param
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// StoreEntry is the content of a Store for a vehicle.
+type StoreEntry struct {
+	// the hash of the parameters reported by the vehicle through the
+	// _HASH_CHECK parameter, or zero if unknown.
+	Hash uint32
+
+	// the parameters, sorted by index.
+	Params []Param
+}
+
+// Store is the interface that must be implemented by stores that persist the
+// parameters of vehicles across restarts. Entries are identified by
+// ClientConf.StoreKey. Methods are called by multiple routines in parallel.
+type Store interface {
+	// Load returns the entry associated with a key, if present.
+	Load(key string) (StoreEntry, bool)
+
+	// Save saves the entry associated with a key.
+	Save(key string, entry StoreEntry) error
+}
+
+// FileStore is a Store that saves the parameters of each vehicle into a JSON
+// file inside a directory.
+type FileStore struct {
+	dir string
+
+	mutex sync.Mutex
+}
+
+// NewFileStore allocates a FileStore. The directory is created if it doesn't
+// exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, url.QueryEscape(key)+".json")
+}
+
+// Load implements Store.
+func (s *FileStore) Load(key string) (StoreEntry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	byts, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return StoreEntry{}, false
+	}
+
+	var e StoreEntry
+	err = json.Unmarshal(byts, &e)
+	if err != nil {
+		return StoreEntry{}, false
+	}
+
+	return e, true
+}
+
+// Save implements Store.
+func (s *FileStore) Save(key string, entry StoreEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	byts, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file and rename it, in order not to leave
+	// a truncated file in case of crash
+	path := s.path(key)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, byts, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,304 @@
+package positiontarget
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// PX4 exits offboard mode when setpoints arrive at less than 2Hz
+	px4MinRate = 2
+
+	// PX4 requires setpoints to be streamed for a while before switching
+	// to offboard mode
+	readyPeriod = 1 * time.Second
+)
+
+// CallbackConf allows to configure a CallbackStreamer.
+type CallbackConf struct {
+	// the node used to send messages.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	TargetSystem byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1 (MAV_COMP_ID_AUTOPILOT1).
+	TargetComponent byte
+
+	// (optional) the rate at which setpoints are sent.
+	// It must be greater than 2Hz, that is the minimum rate accepted by PX4
+	// in offboard mode. It defaults to 20Hz.
+	Rate float64
+
+	// a function that returns the current setpoint, that must be a
+	// *common.MessageSetPositionTargetLocalNed or a
+	// *common.MessageSetAttitudeTarget. Target ids and time are filled
+	// automatically. If it returns nil, the previous setpoint is sent again.
+	// It is called once per period by a dedicated routine, therefore it
+	// can block without affecting the rate of the stream.
+	Callback func() msg.Message
+
+	// (optional) the time after which, if the callback has not returned a
+	// setpoint, the callback is considered stalled and the failsafe
+	// setpoint is sent in place of the last one.
+	// It defaults to 500ms.
+	StallTimeout time.Duration
+
+	// (optional) the setpoint sent when the callback stalls. It defaults
+	// to a SET_POSITION_TARGET_LOCAL_NED with zero velocity and zero yaw
+	// rate, that stops the vehicle without exiting offboard mode.
+	Failsafe msg.Message
+
+	// (optional) a function that is called when the callback stalls and
+	// when it recovers.
+	// It is called by the routine that sends setpoints, therefore it must
+	// not block.
+	OnStall func(stalled bool)
+}
+
+// CallbackStreamer streams SET_POSITION_TARGET_LOCAL_NED or
+// SET_ATTITUDE_TARGET setpoints, computed by a callback, at a fixed rate.
+// When the callback stalls, it stops the vehicle with a failsafe setpoint.
+type CallbackStreamer struct {
+	conf  CallbackConf
+	start time.Time
+
+	mutex      sync.Mutex
+	readySince time.Time
+	readyWait  chan struct{}
+
+	request   chan struct{}
+	result    chan msg.Message
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// NewCallbackStreamer allocates a CallbackStreamer. See CallbackConf for the
+// options. Streaming starts with the first setpoint returned by the callback.
+func NewCallbackStreamer(conf CallbackConf) (*CallbackStreamer, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Callback == nil {
+		return nil, fmt.Errorf("Callback not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageSetPositionTargetLocalNed{},
+		&common.MessageSetAttitudeTarget{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.TargetComponent == 0 {
+		conf.TargetComponent = 1
+	}
+
+	if conf.Rate == 0 {
+		conf.Rate = 20
+	}
+	if conf.Rate <= px4MinRate {
+		return nil, fmt.Errorf("Rate must be greater than %dHz", px4MinRate)
+	}
+
+	if conf.StallTimeout < 0 {
+		return nil, fmt.Errorf("StallTimeout must be >= 0")
+	}
+	if conf.StallTimeout == 0 {
+		conf.StallTimeout = 500 * time.Millisecond
+	}
+
+	if conf.Failsafe == nil {
+		conf.Failsafe = &common.MessageSetPositionTargetLocalNed{
+			CoordinateFrame: common.MAV_FRAME_LOCAL_NED,
+			TypeMask:        IgnoreAll.WithVelocity().WithYawRate().Value(),
+		}
+	}
+
+	if !isSetpoint(conf.Failsafe) {
+		return nil, fmt.Errorf("unsupported failsafe setpoint %T", conf.Failsafe)
+	}
+
+	s := &CallbackStreamer{
+		conf:      conf,
+		start:     time.Now(),
+		readyWait: make(chan struct{}),
+		request:   make(chan struct{}, 1),
+		result:    make(chan msg.Message, 1),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the streamer. It must be called before closing the node.
+// It does not wait for a stalled callback to return.
+func (s *CallbackStreamer) Close() {
+	close(s.terminate)
+	<-s.done
+}
+
+func isSetpoint(m msg.Message) bool {
+	switch m.(type) {
+	case *common.MessageSetPositionTargetLocalNed, *common.MessageSetAttitudeTarget:
+		return true
+	}
+	return false
+}
+
+// WaitReady waits until setpoints have been streamed without stalls for
+// long enough to allow PX4 to switch to offboard mode. The mode can then be
+// set, i.e. with the vehicle package.
+func (s *CallbackStreamer) WaitReady(ctx context.Context) error {
+	for {
+		s.mutex.Lock()
+		ok := !s.readySince.IsZero() && time.Since(s.readySince) >= readyPeriod
+		wait := s.readyWait
+		s.mutex.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.terminate:
+			return fmt.Errorf("terminated")
+		}
+	}
+}
+
+// setReady is called at each period with whether the last setpoint is a
+// fresh one.
+func (s *CallbackStreamer) setReady(fresh bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case !fresh:
+		s.readySince = time.Time{}
+
+	case s.readySince.IsZero():
+		s.readySince = time.Now()
+
+	default:
+		// wake up routines that are waiting for the stream
+		if time.Since(s.readySince) >= readyPeriod {
+			close(s.readyWait)
+			s.readyWait = make(chan struct{})
+		}
+	}
+}
+
+func (s *CallbackStreamer) runCallback() {
+	for {
+		select {
+		case <-s.request:
+		case <-s.terminate:
+			return
+		}
+
+		m := s.conf.Callback()
+		if m != nil && !isSetpoint(m) {
+			m = nil
+		}
+
+		select {
+		case s.result <- m:
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+func (s *CallbackStreamer) run() {
+	defer close(s.done)
+
+	go s.runCallback()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.conf.Rate))
+	defer ticker.Stop()
+
+	var last msg.Message
+	lastTime := s.start
+	stalled := false
+
+	// the first setpoint is requested immediately
+	s.request <- struct{}{}
+
+	for {
+		select {
+		case m := <-s.result:
+			if m != nil {
+				last = m
+			}
+			lastTime = time.Now()
+
+		case <-ticker.C:
+			// ask for the next setpoint, unless a call is in progress
+			select {
+			case s.request <- struct{}{}:
+			default:
+			}
+
+			nowStalled := time.Since(lastTime) > s.conf.StallTimeout
+			if nowStalled != stalled {
+				stalled = nowStalled
+				if s.conf.OnStall != nil {
+					s.conf.OnStall(stalled)
+				}
+			}
+
+			switch {
+			case stalled:
+				s.write(s.conf.Failsafe)
+
+			case last != nil:
+				s.write(last)
+			}
+
+			s.setReady(!stalled && last != nil)
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+func (s *CallbackStreamer) write(m msg.Message) {
+	timeBootMs := uint32(time.Since(s.start) / time.Millisecond)
+
+	// send a copy, since messages are encoded asynchronously
+	switch m := m.(type) {
+	case *common.MessageSetPositionTargetLocalNed:
+		c := *m
+		c.TimeBootMs = timeBootMs
+		c.TargetSystem = s.conf.TargetSystem
+		c.TargetComponent = s.conf.TargetComponent
+		s.conf.Node.WriteMessageAll(&c)
+
+	case *common.MessageSetAttitudeTarget:
+		c := *m
+		c.TimeBootMs = timeBootMs
+		c.TargetSystem = s.conf.TargetSystem
+		c.TargetComponent = s.conf.TargetComponent
+		s.conf.Node.WriteMessageAll(&c)
+	}
+}
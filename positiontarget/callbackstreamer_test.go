@@ -0,0 +1,175 @@
+package positiontarget
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// newTestStreamer returns a ground station node, a channel that receives the
+// messages received by a vehicle, and a function that closes both.
+func newTestStreamer(t *testing.T) (*gomavlib.Node, chan msg.Message, func()) {
+	gcs, vehicle := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	recv := make(chan msg.Message, 1000)
+	testnode.OnFrame(vehicle, func(fr *gomavlib.EventFrame) {
+		recv <- fr.Message()
+	})
+
+	return gcs, recv, func() {
+		gcs.Close()
+		vehicle.Close()
+	}
+}
+
+func TestCallbackStreamerErrors(t *testing.T) {
+	_, err := NewCallbackStreamer(CallbackConf{})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, _, closeNodes := newTestStreamer(t)
+	defer closeNodes()
+
+	_, err = NewCallbackStreamer(CallbackConf{Node: gcs})
+	require.EqualError(t, err, "Callback not provided")
+
+	cb := func() msg.Message { return nil }
+
+	_, err = NewCallbackStreamer(CallbackConf{Node: gcs, Callback: cb, Rate: 2})
+	require.EqualError(t, err, "Rate must be greater than 2Hz")
+
+	_, err = NewCallbackStreamer(CallbackConf{Node: gcs, Callback: cb, Failsafe: &common.MessageHeartbeat{}})
+	require.EqualError(t, err, "unsupported failsafe setpoint *common.MessageHeartbeat")
+}
+
+func TestCallbackStreamer(t *testing.T) {
+	gcs, recv, closeNodes := newTestStreamer(t)
+	defer closeNodes()
+
+	s, err := NewCallbackStreamer(CallbackConf{
+		Node:         gcs,
+		TargetSystem: 1,
+		Rate:         50,
+		Callback: func() msg.Message {
+			return &common.MessageSetAttitudeTarget{
+				TypeMask: 0x07,
+				Q:        [4]float32{1, 0, 0, 0},
+				Thrust:   0.5,
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = s.WaitReady(ctx)
+	require.NoError(t, err)
+
+	// setpoints are sent at the configured rate
+	for len(recv) > 0 {
+		<-recv
+	}
+
+	start := time.Now()
+	count := 0
+	var m *common.MessageSetAttitudeTarget
+	for count < 25 {
+		if mm, ok := (<-recv).(*common.MessageSetAttitudeTarget); ok {
+			m = mm
+			count++
+		}
+	}
+	require.InDelta(t, 0.5, time.Since(start).Seconds(), 0.2)
+
+	require.Equal(t, uint8(1), m.TargetSystem)
+	require.Equal(t, uint8(1), m.TargetComponent)
+	require.Equal(t, float32(0.5), m.Thrust)
+	require.NotEqual(t, uint32(0), m.TimeBootMs)
+}
+
+func TestCallbackStreamerStall(t *testing.T) {
+	gcs, recv, closeNodes := newTestStreamer(t)
+	defer closeNodes()
+
+	var mutex sync.Mutex
+	block := make(chan struct{})
+	blocked := false
+
+	stalls := make(chan bool, 10)
+
+	s, err := NewCallbackStreamer(CallbackConf{
+		Node:         gcs,
+		TargetSystem: 1,
+		StallTimeout: 200 * time.Millisecond,
+		Callback: func() msg.Message {
+			mutex.Lock()
+			b := blocked
+			mutex.Unlock()
+			if b {
+				<-block
+			}
+
+			return &common.MessageSetPositionTargetLocalNed{
+				CoordinateFrame: common.MAV_FRAME_LOCAL_NED,
+				TypeMask:        IgnoreAll.WithPosition().Value(),
+				Z:               -10,
+			}
+		},
+		OnStall: func(stalled bool) {
+			stalls <- stalled
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	readTarget := func() *common.MessageSetPositionTargetLocalNed {
+		for {
+			if m, ok := (<-recv).(*common.MessageSetPositionTargetLocalNed); ok {
+				return m
+			}
+		}
+	}
+
+	m := readTarget()
+	require.Equal(t, float32(-10), m.Z)
+
+	mutex.Lock()
+	blocked = true
+	mutex.Unlock()
+
+	require.Equal(t, true, <-stalls)
+
+	// the failsafe setpoint stops the vehicle
+	for {
+		m = readTarget()
+		if m.TypeMask == IgnoreAll.WithVelocity().WithYawRate().Value() {
+			break
+		}
+	}
+	require.Equal(t, float32(0), m.Vx)
+	require.Equal(t, float32(0), m.Vy)
+	require.Equal(t, float32(0), m.Vz)
+
+	mutex.Lock()
+	blocked = false
+	mutex.Unlock()
+	close(block)
+
+	require.Equal(t, false, <-stalls)
+
+	for {
+		m = readTarget()
+		if m.TypeMask == IgnoreAll.WithPosition().Value() {
+			break
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package positiontarget implements a helper that streams position targets
+// (SET_POSITION_TARGET_GLOBAL_INT or SET_POSITION_TARGET_LOCAL_NED) to a
+// vehicle, in order to control it in guided / offboard mode.
+//
+// The last target is sent again periodically, since autopilots (in particular
+// PX4 in offboard mode) exit the mode when targets stop arriving.
+//
+// The package also provides a CallbackStreamer, that streams local position
+// targets or attitude targets (SET_ATTITUDE_TARGET) computed by a callback
+// at a fixed rate, and stops the vehicle when the callback stalls.
+package positiontarget
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	earthRadius = 6378137.0
+
+	// GotoMoving() computes velocities only if targets are closer than this
+	movingMaxPeriod = 2 * time.Second
+)
+
+// Conf allows to configure a Streamer.
+type Conf struct {
+	// the node used to send messages.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	TargetSystem byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1 (MAV_COMP_ID_AUTOPILOT1).
+	TargetComponent byte
+
+	// (optional) the rate at which the target is sent.
+	// PX4 requires at least 2Hz. It defaults to 10Hz.
+	Rate float64
+}
+
+// Streamer streams position targets.
+type Streamer struct {
+	conf  Conf
+	start time.Time
+
+	mutex      sync.Mutex
+	target     msg.Message
+	prevMoving *movingTarget
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+type movingTarget struct {
+	lat  float64
+	lon  float64
+	alt  float32
+	time time.Time
+}
+
+// New allocates a Streamer. See Conf for the options.
+// Streaming starts with the first target.
+func New(conf Conf) (*Streamer, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageSetPositionTargetGlobalInt{},
+		&common.MessageSetPositionTargetLocalNed{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.TargetComponent == 0 {
+		conf.TargetComponent = 1
+	}
+	if conf.Rate == 0 {
+		conf.Rate = 10
+	}
+
+	s := &Streamer{
+		conf:      conf,
+		start:     time.Now(),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the streamer. It must be called before closing the node.
+func (s *Streamer) Close() {
+	close(s.terminate)
+	<-s.done
+}
+
+// SetGlobalInt sets a global target. Target ids and time are filled automatically.
+func (s *Streamer) SetGlobalInt(m *common.MessageSetPositionTargetGlobalInt) {
+	m.TargetSystem = s.conf.TargetSystem
+	m.TargetComponent = s.conf.TargetComponent
+
+	s.mutex.Lock()
+	s.target = m
+	s.prevMoving = nil
+	s.mutex.Unlock()
+
+	s.send()
+}
+
+// SetLocalNed sets a local target. Target ids and time are filled automatically.
+func (s *Streamer) SetLocalNed(m *common.MessageSetPositionTargetLocalNed) {
+	m.TargetSystem = s.conf.TargetSystem
+	m.TargetComponent = s.conf.TargetComponent
+
+	s.mutex.Lock()
+	s.target = m
+	s.prevMoving = nil
+	s.mutex.Unlock()
+
+	s.send()
+}
+
+// GotoMoving sets a global target, that is a point moving over time, for
+// instance the position of the user in follow-me applications.
+// lat and lon are in degrees, alt is in meters above home.
+// Velocities are computed from consecutive calls and are used as feed-forward.
+func (s *Streamer) GotoMoving(lat float64, lon float64, alt float32) {
+	now := time.Now()
+
+	m := &common.MessageSetPositionTargetGlobalInt{
+		TargetSystem:    s.conf.TargetSystem,
+		TargetComponent: s.conf.TargetComponent,
+		CoordinateFrame: common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+		TypeMask:        IgnoreAll.WithPosition().Value(),
+		LatInt:          int32(math.Round(lat * 1e7)),
+		LonInt:          int32(math.Round(lon * 1e7)),
+		Alt:             alt,
+	}
+
+	s.mutex.Lock()
+
+	if prev := s.prevMoving; prev != nil && now.Sub(prev.time) < movingMaxPeriod {
+		dt := now.Sub(prev.time).Seconds()
+		if dt > 0 {
+			latRad := lat * math.Pi / 180
+			m.Vx = float32((lat - prev.lat) * math.Pi / 180 * earthRadius / dt)
+			m.Vy = float32((lon - prev.lon) * math.Pi / 180 * earthRadius * math.Cos(latRad) / dt)
+			m.Vz = -(alt - prev.alt) / float32(dt)
+			m.TypeMask = IgnoreAll.WithPosition().WithVelocity().Value()
+		}
+	}
+
+	s.target = m
+	s.prevMoving = &movingTarget{
+		lat:  lat,
+		lon:  lon,
+		alt:  alt,
+		time: now,
+	}
+	s.mutex.Unlock()
+
+	s.send()
+}
+
+// Stop stops streaming targets.
+func (s *Streamer) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.target = nil
+	s.prevMoving = nil
+}
+
+func (s *Streamer) send() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.target == nil {
+		return
+	}
+
+	timeBootMs := uint32(time.Since(s.start) / time.Millisecond)
+
+	// send a copy, since messages are encoded asynchronously
+	switch m := s.target.(type) {
+	case *common.MessageSetPositionTargetGlobalInt:
+		c := *m
+		c.TimeBootMs = timeBootMs
+		s.conf.Node.WriteMessageAll(&c)
+
+	case *common.MessageSetPositionTargetLocalNed:
+		c := *m
+		c.TimeBootMs = timeBootMs
+		s.conf.Node.WriteMessageAll(&c)
+	}
+}
+
+func (s *Streamer) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.conf.Rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.send()
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package positiontarget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestStreamerGotoMoving(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5650"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5650"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	go func() {
+		for range node2.Events() {
+		}
+	}()
+
+	s, err := New(Conf{
+		Node:         node2,
+		TargetSystem: 1,
+		Rate:         20,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	readTarget := func() *common.MessageSetPositionTargetGlobalInt {
+		for evt := range node1.Events() {
+			if ee, ok := evt.(*gomavlib.EventFrame); ok {
+				if m, ok := ee.Message().(*common.MessageSetPositionTargetGlobalInt); ok {
+					return m
+				}
+			}
+		}
+		return nil
+	}
+
+	s.GotoMoving(45.0, 9.0, 10)
+
+	m := readTarget()
+	require.Equal(t, uint8(1), m.TargetSystem)
+	require.Equal(t, uint8(1), m.TargetComponent)
+	require.Equal(t, common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT, m.CoordinateFrame)
+	require.Equal(t, IgnoreAll.WithPosition().Value(), m.TypeMask)
+	require.Equal(t, int32(450000000), m.LatInt)
+	require.Equal(t, int32(90000000), m.LonInt)
+	require.Equal(t, float32(10), m.Alt)
+
+	// the target is sent again periodically
+	m = readTarget()
+	require.Equal(t, int32(450000000), m.LatInt)
+
+	// move north and up
+	time.Sleep(500 * time.Millisecond)
+	s.GotoMoving(45.0001, 9.0, 11)
+
+	for {
+		m = readTarget()
+		if m.LatInt == 450001000 {
+			break
+		}
+	}
+	require.Equal(t, IgnoreAll.WithPosition().WithVelocity().Value(), m.TypeMask)
+	require.InDelta(t, 22, m.Vx, 5)
+	require.InDelta(t, 0, m.Vy, 0.1)
+	require.InDelta(t, -2, m.Vz, 0.5)
+}
@@ -0,0 +1,64 @@
+package positiontarget
+
+import (
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// TypeMask is a builder of POSITION_TARGET_TYPEMASK values.
+// Start from IgnoreAll and enable the fields that must be used by the vehicle,
+// for instance IgnoreAll.WithPosition().WithYaw().
+type TypeMask common.POSITION_TARGET_TYPEMASK
+
+// IgnoreAll is a type mask that ignores all fields.
+const IgnoreAll = TypeMask(common.POSITION_TARGET_TYPEMASK_X_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_Y_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_Z_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_VX_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_VY_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_VZ_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_AX_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_AY_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_AZ_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_YAW_IGNORE |
+	common.POSITION_TARGET_TYPEMASK_YAW_RATE_IGNORE)
+
+// WithPosition enables position fields.
+func (m TypeMask) WithPosition() TypeMask {
+	return m &^ TypeMask(common.POSITION_TARGET_TYPEMASK_X_IGNORE|
+		common.POSITION_TARGET_TYPEMASK_Y_IGNORE|
+		common.POSITION_TARGET_TYPEMASK_Z_IGNORE)
+}
+
+// WithVelocity enables velocity fields.
+func (m TypeMask) WithVelocity() TypeMask {
+	return m &^ TypeMask(common.POSITION_TARGET_TYPEMASK_VX_IGNORE|
+		common.POSITION_TARGET_TYPEMASK_VY_IGNORE|
+		common.POSITION_TARGET_TYPEMASK_VZ_IGNORE)
+}
+
+// WithAcceleration enables acceleration fields.
+func (m TypeMask) WithAcceleration() TypeMask {
+	return m &^ TypeMask(common.POSITION_TARGET_TYPEMASK_AX_IGNORE|
+		common.POSITION_TARGET_TYPEMASK_AY_IGNORE|
+		common.POSITION_TARGET_TYPEMASK_AZ_IGNORE)
+}
+
+// WithForce interprets acceleration fields as forces.
+func (m TypeMask) WithForce() TypeMask {
+	return m | TypeMask(common.POSITION_TARGET_TYPEMASK_FORCE_SET)
+}
+
+// WithYaw enables the yaw field.
+func (m TypeMask) WithYaw() TypeMask {
+	return m &^ TypeMask(common.POSITION_TARGET_TYPEMASK_YAW_IGNORE)
+}
+
+// WithYawRate enables the yaw rate field.
+func (m TypeMask) WithYawRate() TypeMask {
+	return m &^ TypeMask(common.POSITION_TARGET_TYPEMASK_YAW_RATE_IGNORE)
+}
+
+// Value returns the mask in the format used by messages.
+func (m TypeMask) Value() common.POSITION_TARGET_TYPEMASK {
+	return common.POSITION_TARGET_TYPEMASK(m)
+}
@@ -0,0 +1,16 @@
+package positiontarget
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestTypeMask(t *testing.T) {
+	require.Equal(t, common.POSITION_TARGET_TYPEMASK(0xDF8), IgnoreAll.WithPosition().Value())
+	require.Equal(t, common.POSITION_TARGET_TYPEMASK(0xDC7), IgnoreAll.WithVelocity().Value())
+	require.Equal(t, common.POSITION_TARGET_TYPEMASK(0x9C0), IgnoreAll.WithPosition().WithVelocity().WithYaw().Value())
+	require.Equal(t, common.POSITION_TARGET_TYPEMASK(0x63F), IgnoreAll.WithAcceleration().WithForce().WithYawRate().Value())
+}
@@ -0,0 +1,59 @@
+// +build ignore
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/quic"
+)
+
+func main() {
+	// load the certificate of the authority that signed the certificate of
+	// the server
+	byts, err := ioutil.ReadFile("ca.crt")
+	if err != nil {
+		panic(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(byts) {
+		panic("invalid certificate")
+	}
+
+	// set up a QUIC endpoint in client mode, i.e. to reach a vehicle through
+	// a LTE link
+	endpoint, err := quic.EndpointQuicClient{
+		Address:   "vehicle.example.com:5600",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	}.EndpointConf()
+	if err != nil {
+		panic(err)
+	}
+
+	// create a node which
+	// - communicates through the QUIC connection
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints:   []gomavlib.EndpointConf{endpoint},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print every message we receive
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			fmt.Printf("received: id=%d, %+v\n", frm.Message().GetId(), frm.Message())
+		}
+	}
+}
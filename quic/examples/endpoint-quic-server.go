@@ -0,0 +1,52 @@
+// +build ignore
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/quic"
+)
+
+func main() {
+	// load the certificate of the server
+	cert, err := tls.LoadX509KeyPair("server.crt", "server.key")
+	if err != nil {
+		panic(err)
+	}
+
+	// set up a QUIC endpoint in server mode. Clients keep their channel
+	// when their address changes.
+	endpoint, err := quic.EndpointQuicServer{
+		Address:   "0.0.0.0:5600",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}.EndpointConf()
+	if err != nil {
+		panic(err)
+	}
+
+	// create a node which
+	// - communicates with QUIC clients
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints:   []gomavlib.EndpointConf{endpoint},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print every message we receive
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			fmt.Printf("received: id=%d, %+v\n", frm.Message().GetId(), frm.Message())
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package quic provides endpoints that work with QUIC connections, in which
+// each frame is carried by an unreliable datagram (RFC 9221). Connections
+// are encrypted with TLS, lost frames are not retransmitted, thus they do not
+// delay the following ones as in TCP, and the server follows clients whose
+// address changes (connection migration), i.e. when a LTE modem obtains a new
+// IP, without closing their channels.
+//
+// The package is a separate module, in order to keep the main module free
+// from the dependency on github.com/quic-go/quic-go.
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+
+	"github.com/aler9/gomavlib"
+)
+
+const (
+	// the label of the transport.
+	transportName = "quic"
+
+	// the application protocol negotiated when TLSConfig.NextProtos is empty.
+	defaultNextProto = "mavlink"
+
+	connectTimeout  = 10 * time.Second
+	idleTimeout     = 30 * time.Second
+	keepalivePeriod = 10 * time.Second
+)
+
+// listenPacket allocates the socket of client connections.
+var listenPacket = func() (net.PacketConn, error) {
+	return net.ListenUDP("udp", &net.UDPAddr{})
+}
+
+func quicConfig() *quicgo.Config {
+	return &quicgo.Config{
+		EnableDatagrams: true,
+		MaxIdleTimeout:  idleTimeout,
+		KeepAlivePeriod: keepalivePeriod,
+	}
+}
+
+func fillTLSConfig(c *tls.Config) *tls.Config {
+	c = c.Clone()
+	if len(c.NextProtos) == 0 {
+		c.NextProtos = []string{defaultNextProto}
+	}
+	return c
+}
+
+// EndpointQuicClient sets up a endpoint that works with a QUIC client.
+// The connection is established again when it drops.
+type EndpointQuicClient struct {
+	// domain name or IP of the server to connect to, example: 1.2.3.4:5600
+	Address string
+
+	// the TLS configuration, that must allow to verify the certificate of
+	// the server, i.e. through RootCAs.
+	// NextProtos defaults to "mavlink".
+	TLSConfig *tls.Config
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect gomavlib.ReconnectPolicy
+}
+
+// EndpointConf checks the configuration and returns the configuration of
+// the endpoint, that can be inserted into NodeConf.Endpoints.
+func (conf EndpointQuicClient) EndpointConf() (gomavlib.EndpointConf, error) {
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.TLSConfig == nil {
+		return nil, fmt.Errorf("TLSConfig not provided")
+	}
+
+	return gomavlib.EndpointTransport{
+		Instance:  &transport{tlsConfig: fillTLSConfig(conf.TLSConfig)},
+		Address:   conf.Address,
+		Reconnect: conf.Reconnect,
+	}, nil
+}
+
+// EndpointQuicServer sets up a endpoint that works with a QUIC server.
+// Each connected client has its own channel.
+type EndpointQuicServer struct {
+	// listen address, example: 0.0.0.0:5600
+	Address string
+
+	// the TLS configuration, that must contain the certificate of the server.
+	// NextProtos defaults to "mavlink".
+	TLSConfig *tls.Config
+}
+
+// EndpointConf checks the configuration and returns the configuration of
+// the endpoint, that can be inserted into NodeConf.Endpoints.
+func (conf EndpointQuicServer) EndpointConf() (gomavlib.EndpointConf, error) {
+	_, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.TLSConfig == nil {
+		return nil, fmt.Errorf("TLSConfig not provided")
+	}
+
+	if len(conf.TLSConfig.Certificates) == 0 && conf.TLSConfig.GetCertificate == nil {
+		return nil, fmt.Errorf("TLSConfig does not contain any certificate")
+	}
+
+	return gomavlib.EndpointTransport{
+		Instance: &transport{tlsConfig: fillTLSConfig(conf.TLSConfig)},
+		Address:  conf.Address,
+		Listen:   true,
+	}, nil
+}
+
+// transport is the transport of client and server endpoints.
+type transport struct {
+	tlsConfig *tls.Config
+}
+
+func (*transport) Label() string {
+	return transportName
+}
+
+func (t *transport) Dial(address string) (gomavlib.TransportConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := listenPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	qc, err := quicgo.Dial(ctx, pc, addr, t.tlsConfig, quicConfig())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	return &conn{qc: qc, pc: pc}, nil
+}
+
+func (t *transport) Listen(address string) (gomavlib.TransportAcceptor, error) {
+	ln, err := quicgo.ListenAddr(address, t.tlsConfig, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return &acceptor{ln: ln}, nil
+}
+
+type acceptor struct {
+	ln *quicgo.Listener
+}
+
+func (a *acceptor) Accept() (string, gomavlib.TransportConn, error) {
+	qc, err := a.ln.Accept(context.Background())
+	if err != nil {
+		return "", nil, err
+	}
+
+	return qc.RemoteAddr().String(), &conn{qc: qc}, nil
+}
+
+func (a *acceptor) Close() error {
+	return a.ln.Close()
+}
+
+// conn is a QUIC connection, in which each frame is carried by a datagram.
+type conn struct {
+	qc *quicgo.Conn
+
+	// the socket of client connections, that is not closed by qc.
+	pc net.PacketConn
+
+	buf []byte
+}
+
+// ReadFrame implements gomavlib.FrameReadWriter.
+func (c *conn) ReadFrame() ([]byte, error) {
+	return c.qc.ReceiveDatagram(context.Background())
+}
+
+// WriteFrame implements gomavlib.FrameReadWriter.
+func (c *conn) WriteFrame(buf []byte) error {
+	return c.qc.SendDatagram(buf)
+}
+
+// Read implements io.Reader. Datagrams are returned in parts if p is too
+// small.
+func (c *conn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		buf, err := c.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = buf
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write implements io.Writer. Each call writes a datagram.
+func (c *conn) Write(p []byte) (int, error) {
+	err := c.WriteFrame(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close implements io.Closer.
+func (c *conn) Close() error {
+	err := c.qc.CloseWithError(0, "")
+	if c.pc != nil {
+		c.pc.Close()
+	}
+	return err
+}
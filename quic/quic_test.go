@@ -0,0 +1,299 @@
+package quic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+// testTLSConfigs returns the TLS configurations of a server with a
+// self-signed certificate, and of a client that trusts it.
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	serverConf := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	clientConf := &tls.Config{
+		RootCAs: pool,
+	}
+	return serverConf, clientConf
+}
+
+// rebindingConn is a socket whose local port can be changed, in order to
+// simulate a change of the address of the client.
+type rebindingConn struct {
+	mutex  sync.Mutex
+	cur    *net.UDPConn
+	closed bool
+}
+
+func newRebindingConn() (*rebindingConn, error) {
+	cur, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	return &rebindingConn{cur: cur}, nil
+}
+
+func (c *rebindingConn) current() *net.UDPConn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cur
+}
+
+func (c *rebindingConn) rebind() error {
+	next, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	prev := c.cur
+	c.cur = next
+	c.mutex.Unlock()
+
+	return prev.Close()
+}
+
+func (c *rebindingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		cur := c.current()
+		n, addr, err := cur.ReadFrom(p)
+		if err != nil {
+			c.mutex.Lock()
+			rebound := c.cur != cur && !c.closed
+			c.mutex.Unlock()
+			if rebound {
+				continue
+			}
+		}
+		return n, addr, err
+	}
+}
+
+func (c *rebindingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.current().WriteTo(p, addr)
+}
+
+func (c *rebindingConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return c.cur.Close()
+}
+
+func (c *rebindingConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *rebindingConn) SetDeadline(t time.Time) error {
+	return c.current().SetDeadline(t)
+}
+
+func (c *rebindingConn) SetReadDeadline(t time.Time) error {
+	return c.current().SetReadDeadline(t)
+}
+
+func (c *rebindingConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
+
+func newQuicNodes(t *testing.T, address string) (*gomavlib.Node, *gomavlib.Node) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+
+	serverConf, err := EndpointQuicServer{
+		Address:   address,
+		TLSConfig: serverTLS,
+	}.EndpointConf()
+	require.NoError(t, err)
+
+	clientConf, err := EndpointQuicClient{
+		Address:   address,
+		TLSConfig: clientTLS,
+	}.EndpointConf()
+	require.NoError(t, err)
+
+	// the client writes heartbeats until the connection is established
+	clientNodeConf := testnode.Conf(11, clientConf)
+	clientNodeConf.HeartbeatDisable = false
+	clientNodeConf.HeartbeatPeriod = 100 * time.Millisecond
+
+	return testnode.New(t, testnode.Conf(10, serverConf), clientNodeConf)
+}
+
+// exchange waits for a heartbeat of the client, then writes a message
+// from the server to the client, until it is received.
+func exchange(t *testing.T, server *gomavlib.Node, client *gomavlib.Node, load uint16) *gomavlib.Channel {
+	var ch *gomavlib.Channel
+
+	for evt := range server.Events() {
+		if e, ok := evt.(*gomavlib.EventFrame); ok {
+			_, ok := e.Message().(*common.MessageHeartbeat)
+			require.Equal(t, true, ok)
+			require.Equal(t, byte(11), e.SystemId())
+			ch = e.Channel
+			break
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			server.WriteMessageTo(ch, &common.MessageSysStatus{Load: load})
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for evt := range client.Events() {
+		if e, ok := evt.(*gomavlib.EventFrame); ok {
+			if m, ok := e.Message().(*common.MessageSysStatus); ok && m.Load == load {
+				require.Equal(t, byte(10), e.SystemId())
+				break
+			}
+		}
+	}
+
+	return ch
+}
+
+func TestEndpointConfErrors(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+
+	for _, ca := range []struct {
+		conf interface {
+			EndpointConf() (gomavlib.EndpointConf, error)
+		}
+		err string
+	}{
+		{EndpointQuicClient{Address: "127.0.0.1", TLSConfig: clientTLS}, "invalid address"},
+		{EndpointQuicClient{Address: "127.0.0.1:5600"}, "TLSConfig not provided"},
+		{EndpointQuicServer{Address: "127.0.0.1", TLSConfig: serverTLS}, "invalid address"},
+		{EndpointQuicServer{Address: "127.0.0.1:5600"}, "TLSConfig not provided"},
+		{
+			EndpointQuicServer{Address: "127.0.0.1:5600", TLSConfig: clientTLS},
+			"TLSConfig does not contain any certificate",
+		},
+	} {
+		_, err := ca.conf.EndpointConf()
+		require.EqualError(t, err, ca.err)
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	server, client := newQuicNodes(t, "127.0.0.1:5620")
+	defer server.Close()
+	defer client.Close()
+
+	ch := exchange(t, server, client, 100)
+	require.Equal(t, "quic:127.0.0.1:", ch.String()[:len("quic:127.0.0.1:")])
+
+	chans := client.Channels()
+	require.Equal(t, 1, len(chans))
+	require.Equal(t, "quic:127.0.0.1:5620", chans[0].String())
+}
+
+func TestMigration(t *testing.T) {
+	var pc *rebindingConn
+	prev := listenPacket
+	listenPacket = func() (net.PacketConn, error) {
+		var err error
+		pc, err = newRebindingConn()
+		return pc, err
+	}
+	defer func() { listenPacket = prev }()
+
+	server, client := newQuicNodes(t, "127.0.0.1:5621")
+	defer server.Close()
+	defer client.Close()
+
+	ch := exchange(t, server, client, 100)
+
+	// change the address of the client
+	err := pc.rebind()
+	require.NoError(t, err)
+
+	// the server follows the client, without closing the channel
+	closed := make(chan struct{})
+	go func() {
+		for evt := range server.Events() {
+			if _, ok := evt.(*gomavlib.EventChannelClose); ok {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			server.WriteMessageTo(ch, &common.MessageSysStatus{Load: 200})
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for evt := range client.Events() {
+		if e, ok := evt.(*gomavlib.EventFrame); ok {
+			if m, ok := e.Message().(*common.MessageSysStatus); ok && m.Load == 200 {
+				break
+			}
+		}
+	}
+
+	select {
+	case <-closed:
+		t.Fatal("channel closed")
+	default:
+	}
+
+	require.Equal(t, 1, len(server.Channels()))
+	require.Equal(t, ch, server.Channels()[0])
+}
@@ -0,0 +1,304 @@
+// Package rcoverride implements a helper that overrides RC channels of a
+// vehicle by streaming RC_CHANNELS_OVERRIDE messages at a fixed rate.
+//
+// Overrides are always released cleanly, when requested, when the helper is
+// closed or when its context is done, in order to avoid channels that remain
+// stuck to the last overridden value.
+package rcoverride
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	// ChannelCount is the number of channels that can be overridden.
+	ChannelCount = 18
+
+	// channels 1-8 use 0 to release and UINT16_MAX to ignore,
+	// channels 9-18 use UINT16_MAX-1 to release and 0 to ignore.
+	lowChannelCount    = 8
+	lowChannelRelease  = 0
+	lowChannelIgnore   = 0xFFFF
+	highChannelRelease = 0xFFFE
+	highChannelIgnore  = 0
+)
+
+type channelState int
+
+const (
+	channelStateIgnored channelState = iota
+	channelStateOverridden
+	channelStateReleasing
+)
+
+type channel struct {
+	state    channelState
+	value    uint16
+	releases int
+}
+
+// Conf allows to configure an Overrider.
+type Conf struct {
+	// the node used to send messages.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	TargetSystem byte
+
+	// (optional) the component id of the vehicle.
+	// It defaults to 1 (MAV_COMP_ID_AUTOPILOT1).
+	TargetComponent byte
+
+	// (optional) the rate at which messages are sent.
+	// It defaults to 10Hz.
+	Rate float64
+
+	// (optional) the number of messages that are sent in order to release a channel.
+	// It defaults to 3.
+	ReleaseCount int
+
+	// (optional) a context that, when done, releases all channels and stops the overrider.
+	Context context.Context
+}
+
+// Overrider overrides RC channels.
+type Overrider struct {
+	conf Conf
+
+	mutex      sync.Mutex
+	channels   [ChannelCount]channel
+	terminated bool
+
+	terminateOnce sync.Once
+	terminate     chan struct{}
+	done          chan struct{}
+}
+
+// New allocates an Overrider. See Conf for the options.
+func New(conf Conf) (*Overrider, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(&common.MessageRcChannelsOverride{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.TargetComponent == 0 {
+		conf.TargetComponent = 1
+	}
+	if conf.Rate == 0 {
+		conf.Rate = 10
+	}
+	if conf.ReleaseCount == 0 {
+		conf.ReleaseCount = 3
+	}
+	if conf.Context == nil {
+		conf.Context = context.Background()
+	}
+
+	o := &Overrider{
+		conf:      conf,
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go o.run()
+
+	return o, nil
+}
+
+// Close releases all overridden channels and stops the overrider.
+// It returns after the release messages have been sent.
+func (o *Overrider) Close() {
+	o.terminateOnce.Do(func() {
+		close(o.terminate)
+	})
+	<-o.done
+}
+
+// Set overrides a channel with the given value, in microseconds.
+// Channels are numbered from 1 to ChannelCount.
+func (o *Overrider) Set(ch int, value uint16) error {
+	if ch < 1 || ch > ChannelCount {
+		return fmt.Errorf("invalid channel: %d", ch)
+	}
+	if value == 0 || value >= highChannelRelease {
+		return fmt.Errorf("invalid value: %d", value)
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.terminated {
+		return fmt.Errorf("terminated")
+	}
+
+	o.channels[ch-1] = channel{
+		state: channelStateOverridden,
+		value: value,
+	}
+	return nil
+}
+
+// Release releases the given channels, or all channels if none is provided,
+// giving back control to the RC transmitter.
+func (o *Overrider) Release(chs ...int) error {
+	for _, ch := range chs {
+		if ch < 1 || ch > ChannelCount {
+			return fmt.Errorf("invalid channel: %d", ch)
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(chs) == 0 {
+		for i := range o.channels {
+			o.releaseChannel(i)
+		}
+		return nil
+	}
+
+	for _, ch := range chs {
+		o.releaseChannel(ch - 1)
+	}
+	return nil
+}
+
+func (o *Overrider) releaseChannel(i int) {
+	if o.channels[i].state != channelStateOverridden {
+		return
+	}
+	o.channels[i] = channel{
+		state:    channelStateReleasing,
+		releases: o.conf.ReleaseCount,
+	}
+}
+
+func (o *Overrider) run() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / o.conf.Rate))
+	defer ticker.Stop()
+
+	ctxDone := o.conf.Context.Done()
+	terminate := o.terminate
+	terminating := false
+
+	for {
+		select {
+		case <-ticker.C:
+			m, pending := o.next()
+			if m != nil {
+				o.conf.Node.WriteMessageAll(m)
+			}
+
+			if terminating && !pending {
+				return
+			}
+
+		case <-ctxDone:
+			o.startTermination()
+			ctxDone, terminate, terminating = nil, nil, true
+
+		case <-terminate:
+			o.startTermination()
+			ctxDone, terminate, terminating = nil, nil, true
+		}
+	}
+}
+
+func (o *Overrider) startTermination() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.terminated = true
+	for i := range o.channels {
+		o.releaseChannel(i)
+	}
+}
+
+// next returns the message that must be sent, if any, and whether
+// some channels are still pending release.
+func (o *Overrider) next() (*common.MessageRcChannelsOverride, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	var values [ChannelCount]uint16
+	active := false
+	pending := false
+
+	for i := range o.channels {
+		ch := &o.channels[i]
+		var v uint16
+
+		switch ch.state {
+		case channelStateOverridden:
+			active = true
+			v = ch.value
+
+		case channelStateReleasing:
+			active = true
+			if i < lowChannelCount {
+				v = lowChannelRelease
+			} else {
+				v = highChannelRelease
+			}
+
+			ch.releases--
+			if ch.releases == 0 {
+				ch.state = channelStateIgnored
+			} else {
+				pending = true
+			}
+
+		default:
+			if i < lowChannelCount {
+				v = lowChannelIgnore
+			} else {
+				v = highChannelIgnore
+			}
+		}
+
+		values[i] = v
+	}
+
+	if !active {
+		return nil, false
+	}
+
+	return &common.MessageRcChannelsOverride{
+		TargetSystem:    o.conf.TargetSystem,
+		TargetComponent: o.conf.TargetComponent,
+		Chan1Raw:        values[0],
+		Chan2Raw:        values[1],
+		Chan3Raw:        values[2],
+		Chan4Raw:        values[3],
+		Chan5Raw:        values[4],
+		Chan6Raw:        values[5],
+		Chan7Raw:        values[6],
+		Chan8Raw:        values[7],
+		Chan9Raw:        values[8],
+		Chan10Raw:       values[9],
+		Chan11Raw:       values[10],
+		Chan12Raw:       values[11],
+		Chan13Raw:       values[12],
+		Chan14Raw:       values[13],
+		Chan15Raw:       values[14],
+		Chan16Raw:       values[15],
+		Chan17Raw:       values[16],
+		Chan18Raw:       values[17],
+	}, pending
+}
@@ -0,0 +1,128 @@
+package rcoverride
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+func readOverride(t *testing.T, node *gomavlib.Node) *common.MessageRcChannelsOverride {
+	for evt := range node.Events() {
+		if ee, ok := evt.(*gomavlib.EventFrame); ok {
+			if m, ok := ee.Message().(*common.MessageRcChannelsOverride); ok {
+				return m
+			}
+		}
+	}
+	t.Fatal("node closed")
+	return nil
+}
+
+func TestOverrider(t *testing.T) {
+	for _, ca := range []string{"close", "context"} {
+		t.Run(ca, func(t *testing.T) {
+			gcs, vehicle := testnode.Pair(t)
+			defer vehicle.Close()
+			defer gcs.Close()
+			testnode.DiscardEvents(gcs)
+
+			ctx, ctxCancel := context.WithCancel(context.Background())
+			defer ctxCancel()
+
+			o, err := New(Conf{
+				Node:         gcs,
+				TargetSystem: 1,
+				Rate:         50,
+				Context:      ctx,
+			})
+			require.NoError(t, err)
+			defer o.Close()
+
+			err = o.Set(3, 1500)
+			require.NoError(t, err)
+			err = o.Set(12, 1600)
+			require.NoError(t, err)
+
+			m := readOverride(t, vehicle)
+			require.Equal(t, uint8(1), m.TargetSystem)
+			require.Equal(t, uint8(1), m.TargetComponent)
+			require.Equal(t, uint16(0xFFFF), m.Chan1Raw)
+			require.Equal(t, uint16(1500), m.Chan3Raw)
+			require.Equal(t, uint16(0), m.Chan9Raw)
+			require.Equal(t, uint16(1600), m.Chan12Raw)
+
+			if ca == "close" {
+				o.Close()
+			} else {
+				ctxCancel()
+			}
+
+			for {
+				m = readOverride(t, vehicle)
+				if m.Chan3Raw == 0 {
+					break
+				}
+			}
+			require.Equal(t, uint16(0xFFFF), m.Chan1Raw)
+			require.Equal(t, uint16(0xFFFE), m.Chan12Raw)
+
+			// nodes are connected through a pipe, therefore messages must be
+			// read until all release messages have been sent
+			testnode.DiscardEvents(vehicle)
+			o.Close()
+			err = o.Set(3, 1500)
+			require.EqualError(t, err, "terminated")
+		})
+	}
+}
+
+func TestOverriderRelease(t *testing.T) {
+	gcs, vehicle := testnode.Pair(t)
+	defer vehicle.Close()
+	defer gcs.Close()
+	testnode.DiscardEvents(gcs)
+
+	o, err := New(Conf{
+		Node:         gcs,
+		TargetSystem: 1,
+		Rate:         50,
+		ReleaseCount: 1,
+	})
+	require.NoError(t, err)
+	defer o.Close()
+
+	err = o.Set(1, 1500)
+	require.NoError(t, err)
+	err = o.Set(2, 1500)
+	require.NoError(t, err)
+
+	readOverride(t, vehicle)
+
+	err = o.Release(1)
+	require.NoError(t, err)
+
+	var m *common.MessageRcChannelsOverride
+	for {
+		m = readOverride(t, vehicle)
+		if m.Chan1Raw != 1500 {
+			break
+		}
+	}
+	require.Equal(t, uint16(0), m.Chan1Raw)
+	require.Equal(t, uint16(1500), m.Chan2Raw)
+
+	// after the release, the channel is ignored
+	m = readOverride(t, vehicle)
+	require.Equal(t, uint16(0xFFFF), m.Chan1Raw)
+	require.Equal(t, uint16(1500), m.Chan2Raw)
+
+	err = o.Release(19)
+	require.EqualError(t, err, "invalid channel: 19")
+
+	testnode.DiscardEvents(vehicle)
+}
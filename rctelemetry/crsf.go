@@ -0,0 +1,91 @@
+package rctelemetry
+
+import (
+	"encoding/binary"
+)
+
+const (
+	crsfAddressFlightController = 0xC8
+
+	crsfTypeGPS        = 0x02
+	crsfTypeBattery    = 0x08
+	crsfTypeAttitude   = 0x1E
+	crsfTypeFlightMode = 0x21
+
+	// flight mode names are truncated to this length
+	crsfFlightModeMaxLen = 15
+)
+
+// crsfCRC computes the CRC8 (polynomial 0xD5) of a CRSF frame.
+func crsfCRC(buf []byte) byte {
+	crc := byte(0)
+	for _, b := range buf {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if (crc & 0x80) != 0 {
+				crc = (crc << 1) ^ 0xD5
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crsfFrame(typ byte, payload []byte) []byte {
+	buf := make([]byte, 0, 4+len(payload))
+	buf = append(buf, crsfAddressFlightController, byte(len(payload)+2), typ)
+	buf = append(buf, payload...)
+	buf = append(buf, crsfCRC(buf[2:]))
+	return buf
+}
+
+func crsfGPS(s State) []byte {
+	payload := make([]byte, 15)
+	binary.BigEndian.PutUint32(payload[0:], uint32(s.Lat))
+	binary.BigEndian.PutUint32(payload[4:], uint32(s.Lon))
+	// km/h * 10
+	binary.BigEndian.PutUint16(payload[8:], uint16(uint32(s.GroundSpeed)*36/100))
+	binary.BigEndian.PutUint16(payload[10:], s.Heading)
+	// meters with a 1000m offset
+	binary.BigEndian.PutUint16(payload[12:], uint16(s.Alt/1000+1000))
+	payload[14] = s.Satellites
+	return crsfFrame(crsfTypeGPS, payload)
+}
+
+func crsfBattery(s State) []byte {
+	payload := make([]byte, 8)
+	// dV
+	binary.BigEndian.PutUint16(payload[0:], s.Voltage/100)
+	// dA
+	if s.Current > 0 {
+		binary.BigEndian.PutUint16(payload[2:], uint16(s.Current/10))
+	}
+	// 24-bit mAh
+	if s.Consumed > 0 {
+		payload[4] = byte(s.Consumed >> 16)
+		payload[5] = byte(s.Consumed >> 8)
+		payload[6] = byte(s.Consumed)
+	}
+	if s.Remaining > 0 {
+		payload[7] = byte(s.Remaining)
+	}
+	return crsfFrame(crsfTypeBattery, payload)
+}
+
+func crsfAttitude(s State) []byte {
+	payload := make([]byte, 6)
+	// rad * 10000
+	binary.BigEndian.PutUint16(payload[0:], uint16(int16(s.Pitch*10000)))
+	binary.BigEndian.PutUint16(payload[2:], uint16(int16(s.Roll*10000)))
+	binary.BigEndian.PutUint16(payload[4:], uint16(int16(s.Yaw*10000)))
+	return crsfFrame(crsfTypeAttitude, payload)
+}
+
+func crsfFlightMode(name string) []byte {
+	if len(name) > crsfFlightModeMaxLen {
+		name = name[:crsfFlightModeMaxLen]
+	}
+	payload := append([]byte(name), 0x00)
+	return crsfFrame(crsfTypeFlightMode, payload)
+}
@@ -0,0 +1,34 @@
+package rctelemetry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRSFFrames(t *testing.T) {
+	s := State{
+		Lat:         453000000,
+		Lon:         91000000,
+		Alt:         120000,
+		GroundSpeed: 1000,
+		Heading:     9000,
+		Satellites:  12,
+		Voltage:     12600,
+		Current:     1500,
+		Consumed:    850,
+		Remaining:   75,
+	}
+
+	require.Equal(t, []byte("\xc8\x11\x02\x1b\x00\x3b\x40\x05\x6c\x8c\xc0\x01\x68\x23\x28\x04\x60\x0c\xd1"),
+		crsfGPS(s))
+	require.Equal(t, []byte("\xc8\x0a\x08\x00\x7e\x00\x96\x00\x03\x52\x4b\x99"),
+		crsfBattery(s))
+	require.Equal(t, []byte("\xc8\x05\x21\x35\x2a\x00\xec"),
+		crsfFlightMode("5*"))
+}
+
+func TestCRSFFlightModeTruncate(t *testing.T) {
+	buf := crsfFlightMode("averyveryverylongmodename")
+	require.Equal(t, 4+crsfFlightModeMaxLen+1, len(buf))
+}
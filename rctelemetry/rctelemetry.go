@@ -0,0 +1,365 @@
+// Package rctelemetry implements a bridge that converts the state of a
+// Mavlink vehicle into RC telemetry frames (FrSky S.Port or CRSF), that can be
+// written to a serial port connected to a RC receiver, in order to show the
+// telemetry on RC transmitters.
+//
+// The state of the vehicle is built from the messages received by a node,
+// that must use a dialect that contains the common messages.
+package rctelemetry
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+var reSerial = regexp.MustCompile("^(.+?):([0-9]+)$")
+
+// Protocol is a RC telemetry protocol.
+type Protocol int
+
+const (
+	// ProtocolCRSF is the Crossfire / ExpressLRS protocol.
+	// Frames are written periodically to the receiver.
+	ProtocolCRSF Protocol = iota
+
+	// ProtocolSPort is the FrSky Smart Port protocol.
+	// Frames are written when the receiver polls the sensor.
+	ProtocolSPort
+)
+
+// State is the state of a vehicle.
+type State struct {
+	// whether the vehicle is armed.
+	Armed bool
+	// autopilot type.
+	Autopilot common.MAV_AUTOPILOT
+	// autopilot-specific flight mode.
+	CustomMode uint32
+	// latitude, in degE7.
+	Lat int32
+	// longitude, in degE7.
+	Lon int32
+	// altitude (MSL), in mm.
+	Alt int32
+	// altitude above home, in mm.
+	RelativeAlt int32
+	// ground speed, in cm/s.
+	GroundSpeed uint16
+	// vertical speed (positive up), in cm/s.
+	VerticalSpeed int16
+	// heading, in cdeg.
+	Heading uint16
+	// number of visible satellites.
+	Satellites uint8
+	// battery voltage, in mV.
+	Voltage uint16
+	// battery current, in cA.
+	Current int16
+	// consumed charge, in mAh.
+	Consumed int32
+	// remaining battery, in percent.
+	Remaining int8
+	// roll angle, in rad.
+	Roll float32
+	// pitch angle, in rad.
+	Pitch float32
+	// yaw angle, in rad.
+	Yaw float32
+}
+
+// Conf allows to configure a Bridge.
+type Conf struct {
+	// the node from which the vehicle state is read.
+	Node *gomavlib.Node
+
+	// the protocol used to write telemetry.
+	Protocol Protocol
+
+	// the address of the serial port in format name:baudrate
+	// example: /dev/ttyUSB0:57600
+	Device string
+
+	// (optional) a custom port, that is used in place of Device.
+	Port io.ReadWriteCloser
+
+	// (optional) the system id of the vehicle.
+	// It defaults to the first system that sends a heartbeat with a valid autopilot.
+	SystemId byte
+
+	// (optional) the period of CRSF frames.
+	// It defaults to 200ms.
+	Period time.Duration
+
+	// (optional) the physical id of the S.Port sensor, including the parity bits.
+	// It defaults to 0x1B.
+	SPortPhysicalId byte
+
+	// (optional) returns the name of the flight mode that is shown on the transmitter.
+	// It defaults to the numeric value of the custom mode.
+	FlightModeName func(s State) string
+}
+
+// Bridge is a RC telemetry bridge.
+type Bridge struct {
+	conf          Conf
+	port          io.ReadWriteCloser
+	removeHandler func()
+
+	mutex    sync.Mutex
+	systemId byte
+	state    State
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Bridge. See Conf for the options.
+func New(conf Conf) (*Bridge, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHeartbeat{},
+		&common.MessageGlobalPositionInt{},
+		&common.MessageGpsRawInt{},
+		&common.MessageSysStatus{},
+		&common.MessageAttitude{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Protocol != ProtocolCRSF && conf.Protocol != ProtocolSPort {
+		return nil, fmt.Errorf("unsupported protocol")
+	}
+
+	if conf.Period == 0 {
+		conf.Period = 200 * time.Millisecond
+	}
+	if conf.SPortPhysicalId == 0 {
+		conf.SPortPhysicalId = 0x1B
+	}
+
+	port := conf.Port
+	if port == nil {
+		matches := reSerial.FindStringSubmatch(conf.Device)
+		if matches == nil {
+			return nil, fmt.Errorf("invalid device")
+		}
+
+		baud, _ := strconv.Atoi(matches[2])
+
+		port, err = serial.OpenPort(&serial.Config{
+			Name: matches[1],
+			Baud: baud,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := &Bridge{
+		conf:      conf,
+		port:      port,
+		systemId:  conf.SystemId,
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	b.removeHandler = conf.Node.AddFrameHandler(b.onEventFrame)
+
+	if conf.Protocol == ProtocolCRSF {
+		go b.runCRSF()
+	} else {
+		go b.runSPort()
+	}
+
+	return b, nil
+}
+
+// Close closes the bridge and its serial port.
+func (b *Bridge) Close() {
+	b.removeHandler()
+	close(b.terminate)
+	b.port.Close()
+	<-b.done
+}
+
+// State returns the current state of the vehicle.
+func (b *Bridge) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+func (b *Bridge) flightModeName(s State) string {
+	if b.conf.FlightModeName != nil {
+		return b.conf.FlightModeName(s)
+	}
+
+	name := strconv.FormatUint(uint64(s.CustomMode), 10)
+
+	// transmitters show an asterisk when the vehicle is disarmed
+	if !s.Armed {
+		name += "*"
+	}
+	return name
+}
+
+func (b *Bridge) onEventFrame(evt *gomavlib.EventFrame) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.systemId == 0 {
+		var hb common.MessageHeartbeat
+		if msg.Convert(&hb, evt.Message()) != nil ||
+			hb.Autopilot == common.MAV_AUTOPILOT_INVALID {
+			return
+		}
+		b.systemId = evt.SystemId()
+	}
+
+	if evt.SystemId() != b.systemId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageHeartbeat{}).GetId():
+		var m common.MessageHeartbeat
+		if msg.Convert(&m, evt.Message()) != nil ||
+			m.Autopilot == common.MAV_AUTOPILOT_INVALID {
+			return
+		}
+		b.state.Armed = (m.BaseMode & common.MAV_MODE_FLAG_SAFETY_ARMED) != 0
+		b.state.Autopilot = m.Autopilot
+		b.state.CustomMode = m.CustomMode
+
+	case (&common.MessageGlobalPositionInt{}).GetId():
+		var m common.MessageGlobalPositionInt
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		b.state.Lat = m.Lat
+		b.state.Lon = m.Lon
+		b.state.Alt = m.Alt
+		b.state.RelativeAlt = m.RelativeAlt
+		b.state.VerticalSpeed = -m.Vz
+		if m.Hdg != 65535 {
+			b.state.Heading = m.Hdg
+		}
+
+	case (&common.MessageGpsRawInt{}).GetId():
+		var m common.MessageGpsRawInt
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		if m.Vel != 65535 {
+			b.state.GroundSpeed = m.Vel
+		}
+		if m.SatellitesVisible != 255 {
+			b.state.Satellites = m.SatellitesVisible
+		}
+
+	case (&common.MessageSysStatus{}).GetId():
+		var m common.MessageSysStatus
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		b.state.Voltage = m.VoltageBattery
+		b.state.Current = m.CurrentBattery
+		b.state.Remaining = m.BatteryRemaining
+
+	case (&common.MessageBatteryStatus{}).GetId():
+		var m common.MessageBatteryStatus
+		if msg.Convert(&m, evt.Message()) != nil || m.Id != 0 {
+			return
+		}
+		if m.CurrentConsumed >= 0 {
+			b.state.Consumed = m.CurrentConsumed
+		}
+
+	case (&common.MessageAttitude{}).GetId():
+		var m common.MessageAttitude
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		b.state.Roll = m.Roll
+		b.state.Pitch = m.Pitch
+		b.state.Yaw = m.Yaw
+	}
+}
+
+func (b *Bridge) runCRSF() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.conf.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s := b.State()
+
+			var buf []byte
+			buf = append(buf, crsfGPS(s)...)
+			buf = append(buf, crsfBattery(s)...)
+			buf = append(buf, crsfAttitude(s)...)
+			buf = append(buf, crsfFlightMode(b.flightModeName(s))...)
+
+			_, err := b.port.Write(buf)
+			if err != nil {
+				<-b.terminate
+				return
+			}
+
+		case <-b.terminate:
+			return
+		}
+	}
+}
+
+func (b *Bridge) runSPort() {
+	defer close(b.done)
+
+	buf := make([]byte, 64)
+	prevStart := false
+	next := 0
+
+	for {
+		n, err := b.port.Read(buf)
+		if err != nil {
+			<-b.terminate
+			return
+		}
+
+		for _, c := range buf[:n] {
+			// a poll is composed of a start byte followed by the physical id
+			if prevStart && c == b.conf.SPortPhysicalId {
+				values := sportValues(b.State())
+				v := values[next%len(values)]
+				next++
+
+				_, err := b.port.Write(sportFrame(v.id, v.value))
+				if err != nil {
+					<-b.terminate
+					return
+				}
+			}
+			prevStart = (c == sportStart)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package rctelemetry
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+type testPort struct {
+	*io.PipeWriter
+}
+
+func (testPort) Read(buf []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestBridgeCRSF(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5620"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5620"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	b, err := New(Conf{
+		Node:     node1,
+		Protocol: ProtocolCRSF,
+		Port:     testPort{pw},
+		Period:   50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer b.Close()
+
+	go func() {
+		for i := 0; i < 20; i++ {
+			node2.WriteMessageAll(&common.MessageHeartbeat{
+				Autopilot: common.MAV_AUTOPILOT_PX4,
+				BaseMode:  common.MAV_MODE_FLAG_SAFETY_ARMED,
+			})
+			node2.WriteMessageAll(&common.MessageSysStatus{
+				VoltageBattery: 12600,
+			})
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	// wait for a battery frame with the voltage
+	expected := crsfBattery(State{Voltage: 12600})
+	var buf []byte
+	tmp := make([]byte, 128)
+	for !bytes.Contains(buf, expected) {
+		n, err := pr.Read(tmp)
+		require.NoError(t, err)
+		buf = append(buf, tmp[:n]...)
+	}
+
+	s := b.State()
+	require.Equal(t, true, s.Armed)
+	require.Equal(t, common.MAV_AUTOPILOT_PX4, s.Autopilot)
+}
@@ -0,0 +1,100 @@
+package rctelemetry
+
+import (
+	"encoding/binary"
+)
+
+const (
+	sportStart     = 0x7E
+	sportEscape    = 0x7D
+	sportEscapeXor = 0x20
+	sportDataFrame = 0x10
+
+	sportIdAlt       = 0x0100
+	sportIdVario     = 0x0110
+	sportIdCurrent   = 0x0200
+	sportIdVoltage   = 0x0210
+	sportIdFuel      = 0x0600
+	sportIdGPSCoord  = 0x0800
+	sportIdGPSAlt    = 0x0820
+	sportIdGPSSpeed  = 0x0830
+	sportIdGPSCourse = 0x0840
+)
+
+type sportValue struct {
+	id    uint16
+	value uint32
+}
+
+// sportCoord encodes a coordinate in the S.Port format, that uses
+// minutes * 10000 and two flags that specify sign and axis.
+func sportCoord(deg int32, isLon bool) uint32 {
+	neg := deg < 0
+	if neg {
+		deg = -deg
+	}
+
+	v := uint32(int64(deg)*6/100) & 0x3FFFFFFF
+	if neg {
+		v |= 0x40000000
+	}
+	if isLon {
+		v |= 0x80000000
+	}
+	return v
+}
+
+// sportValues returns the values that are sent in rotation when the sensor is polled.
+func sportValues(s State) []sportValue {
+	current := uint32(0)
+	if s.Current > 0 {
+		current = uint32(s.Current / 10)
+	}
+	fuel := uint32(0)
+	if s.Remaining > 0 {
+		fuel = uint32(s.Remaining)
+	}
+
+	return []sportValue{
+		{sportIdAlt, uint32(s.RelativeAlt / 10)},              // cm
+		{sportIdVario, uint32(int32(s.VerticalSpeed))},        // cm/s
+		{sportIdCurrent, current},                             // dA
+		{sportIdVoltage, uint32(s.Voltage / 10)},              // cV
+		{sportIdFuel, fuel},                                   // percent
+		{sportIdGPSCoord, sportCoord(s.Lat, false)},           // latitude
+		{sportIdGPSCoord, sportCoord(s.Lon, true)},            // longitude
+		{sportIdGPSAlt, uint32(s.Alt / 10)},                   // cm
+		{sportIdGPSSpeed, uint32(s.GroundSpeed) * 1944 / 100}, // knots * 1000
+		{sportIdGPSCourse, uint32(s.Heading)},                 // cdeg
+	}
+}
+
+// sportCRC computes the checksum of a S.Port frame.
+func sportCRC(buf []byte) byte {
+	crc := uint16(0)
+	for _, b := range buf {
+		crc += uint16(b)
+		crc += crc >> 8
+		crc &= 0xFF
+	}
+	return byte(0xFF - crc)
+}
+
+func sportFrame(id uint16, value uint32) []byte {
+	raw := make([]byte, 8)
+	raw[0] = sportDataFrame
+	binary.LittleEndian.PutUint16(raw[1:], id)
+	binary.LittleEndian.PutUint32(raw[3:], value)
+	raw[7] = sportCRC(raw[:7])
+
+	// escape reserved bytes
+	buf := make([]byte, 0, len(raw))
+	for _, b := range raw {
+		if b == sportStart || b == sportEscape {
+			buf = append(buf, sportEscape, b^sportEscapeXor)
+		} else {
+			buf = append(buf, b)
+		}
+	}
+	return buf
+}
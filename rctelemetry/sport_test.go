@@ -0,0 +1,21 @@
+package rctelemetry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPortFrame(t *testing.T) {
+	require.Equal(t, []byte("\x10\x10\x02\xec\x04\x00\x00\xec"),
+		sportFrame(sportIdVoltage, 1260))
+
+	// 0x7E must be escaped
+	require.Equal(t, []byte("\x10\x10\x02\x7d\x5e\x00\x00\x00\x5f"),
+		sportFrame(sportIdVoltage, 0x7E))
+}
+
+func TestSPortCoord(t *testing.T) {
+	require.Equal(t, uint32(0x19ebbe0), sportCoord(453000000, false))
+	require.Equal(t, uint32(0xc0535020), sportCoord(-91000000, true))
+}
@@ -0,0 +1,276 @@
+// Package redact implements a redactor that zeroes or fuzzes selected fields
+// of messages, i.e. GPS coordinates and operator ids, before frames are
+// forwarded to untrusted endpoints like public demo feeds.
+//
+// Frames can be decoded or raw (MessageRaw); in the latter case, the messages
+// that match a rule are decoded, redacted and encoded again, therefore frames
+// are routed by nodes that don't decode messages too. Checksums are computed
+// again, and signatures too, when a key is provided.
+package redact
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// Rule describes how the fields of a message are redacted.
+type Rule struct {
+	// a message of the dialect.
+	Message msg.Message
+
+	// (optional) the fields that are set to their zero value.
+	Zero []string
+
+	// (optional) the numeric fields to which a random offset is added, in
+	// format field name -> maximum absolute value of the offset. Integer
+	// offsets are rounded, and values are clamped to the range of the field.
+	Fuzz map[string]float64
+
+	// (optional) a function that is called after the other changes, that
+	// allows to perform arbitrary changes on the redacted message.
+	Func func(m msg.Message)
+}
+
+// Conf allows to configure a Redactor.
+type Conf struct {
+	// the rules, one for each redacted message.
+	Rules []Rule
+
+	// (optional) the key used to sign redacted frames whose original frame
+	// was signed. Since the original signature would become invalid,
+	// redacted frames are not signed when the key is not provided.
+	Key *frame.V2Key
+}
+
+type rule struct {
+	Rule
+	typ  reflect.Type
+	de   *msg.DecEncoder
+	zero []int
+	fuzz map[int]float64
+}
+
+// Redactor redacts messages and frames.
+type Redactor struct {
+	conf  Conf
+	rules map[uint32]*rule
+}
+
+func isNumeric(t reflect.Type) bool {
+	if t.Kind() == reflect.Array {
+		return isNumeric(t.Elem())
+	}
+
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// New allocates a Redactor. See Conf for the options.
+func New(conf Conf) (*Redactor, error) {
+	r := &Redactor{
+		conf:  conf,
+		rules: make(map[uint32]*rule),
+	}
+
+	for _, ru := range conf.Rules {
+		if ru.Message == nil {
+			return nil, fmt.Errorf("Message must be provided")
+		}
+
+		if _, ok := r.rules[ru.Message.GetId()]; ok {
+			return nil, fmt.Errorf("duplicate rule for message with id %d", ru.Message.GetId())
+		}
+
+		de, err := msg.NewDecEncoder(ru.Message)
+		if err != nil {
+			return nil, fmt.Errorf("message %T: %s", ru.Message, err)
+		}
+
+		ir := &rule{
+			Rule: ru,
+			typ:  reflect.TypeOf(ru.Message).Elem(),
+			de:   de,
+			fuzz: make(map[int]float64),
+		}
+
+		for _, name := range ru.Zero {
+			f, ok := ir.typ.FieldByName(name)
+			if !ok {
+				return nil, fmt.Errorf("message %T does not contain field %s", ru.Message, name)
+			}
+			ir.zero = append(ir.zero, f.Index[0])
+		}
+
+		for name, amount := range ru.Fuzz {
+			f, ok := ir.typ.FieldByName(name)
+			if !ok {
+				return nil, fmt.Errorf("message %T does not contain field %s", ru.Message, name)
+			}
+
+			if !isNumeric(f.Type) {
+				return nil, fmt.Errorf("field %s is not numeric", name)
+			}
+
+			if amount < 0 {
+				return nil, fmt.Errorf("fuzz amount of field %s must be >= 0", name)
+			}
+
+			ir.fuzz[f.Index[0]] = amount
+		}
+
+		r.rules[ru.Message.GetId()] = ir
+	}
+
+	return r, nil
+}
+
+func fuzzValue(v reflect.Value, amount float64) {
+	offset := (rand.Float64()*2 - 1) * amount
+
+	switch v.Kind() {
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fuzzValue(v.Index(i), amount)
+		}
+
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(v.Float() + offset)
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := uint(v.Type().Bits())
+		min := -math.Ldexp(1, int(bits)-1)
+		max := math.Ldexp(1, int(bits)-1) - 1
+		nv := math.Max(min, math.Min(max, math.Round(float64(v.Int())+offset)))
+		v.SetInt(int64(nv))
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits := uint(v.Type().Bits())
+		max := math.Ldexp(1, int(bits)) - 1
+		nv := math.Max(0, math.Min(max, math.Round(float64(v.Uint())+offset)))
+		v.SetUint(uint64(nv))
+	}
+}
+
+func (ir *rule) redact(m msg.Message) msg.Message {
+	// work on a copy, since the original message may be shared with other
+	// routines, i.e. when it is routed to multiple channels
+	nv := reflect.New(ir.typ)
+	nv.Elem().Set(reflect.ValueOf(m).Elem())
+	e := nv.Elem()
+
+	for _, i := range ir.zero {
+		f := e.Field(i)
+		f.Set(reflect.Zero(f.Type()))
+	}
+
+	for i, amount := range ir.fuzz {
+		fuzzValue(e.Field(i), amount)
+	}
+
+	ret := nv.Interface().(msg.Message)
+	if ir.Func != nil {
+		ir.Func(ret)
+	}
+	return ret
+}
+
+// RedactMessage redacts a message. It returns a redacted copy of the message,
+// or the message itself if there's no rule for it.
+func (r *Redactor) RedactMessage(m msg.Message) (msg.Message, error) {
+	ir, ok := r.rules[m.GetId()]
+	if !ok {
+		return m, nil
+	}
+
+	if reflect.TypeOf(m).Elem() != ir.typ {
+		return nil, fmt.Errorf("message has type %T, while the rule requires %T", m, ir.Message)
+	}
+
+	return ir.redact(m), nil
+}
+
+// RedactFrame redacts a frame. If there's no rule for its message, the frame
+// itself is returned. Otherwise, a new frame is returned, that contains the
+// encoded redacted message, a new checksum and, if the original frame was
+// signed and Conf.Key is provided, a new signature.
+func (r *Redactor) RedactFrame(f frame.Frame) (frame.Frame, error) {
+	ir, ok := r.rules[f.GetMessage().GetId()]
+	if !ok {
+		return f, nil
+	}
+
+	_, isV2 := f.(*frame.V2Frame)
+
+	m := f.GetMessage()
+	if raw, ok := m.(*msg.MessageRaw); ok {
+		if sum := f.GenChecksum(ir.de.CRCExtra()); sum != f.GetChecksum() {
+			return nil, fmt.Errorf("wrong checksum (expected %.4x, got %.4x, id=%d)",
+				sum, f.GetChecksum(), raw.Id)
+		}
+
+		var err error
+		m, err = ir.de.Decode(raw.Content, isV2)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := r.RedactMessage(m)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ir.de.Encode(m, isV2)
+	if err != nil {
+		return nil, err
+	}
+	raw := &msg.MessageRaw{Id: m.GetId(), Content: content}
+
+	switch ff := f.(type) {
+	case *frame.V1Frame:
+		nf := &frame.V1Frame{
+			SequenceId:  ff.SequenceId,
+			SystemId:    ff.SystemId,
+			ComponentId: ff.ComponentId,
+			Message:     raw,
+		}
+		nf.Checksum = nf.GenChecksum(ir.de.CRCExtra())
+		return nf, nil
+
+	case *frame.V2Frame:
+		nf := &frame.V2Frame{
+			IncompatibilityFlag: ff.IncompatibilityFlag &^ frame.V2FlagSigned,
+			CompatibilityFlag:   ff.CompatibilityFlag,
+			SequenceId:          ff.SequenceId,
+			SystemId:            ff.SystemId,
+			ComponentId:         ff.ComponentId,
+			Message:             raw,
+		}
+
+		// the checksum covers the flags, therefore they are set before it
+		if ff.IsSigned() && r.conf.Key != nil {
+			nf.IncompatibilityFlag |= frame.V2FlagSigned
+			nf.SignatureLinkId = ff.SignatureLinkId
+			nf.SignatureTimestamp = ff.SignatureTimestamp
+		}
+
+		nf.Checksum = nf.GenChecksum(ir.de.CRCExtra())
+
+		if nf.IsSigned() {
+			nf.Signature = nf.GenSignature(r.conf.Key)
+		}
+		return nf, nil
+	}
+
+	return nil, fmt.Errorf("unsupported frame")
+}
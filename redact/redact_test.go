@@ -0,0 +1,179 @@
+package redact
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/transceiver"
+)
+
+func testRedactor(t *testing.T, key *frame.V2Key) *Redactor {
+	r, err := New(Conf{
+		Rules: []Rule{
+			{
+				Message: &common.MessageGlobalPositionInt{},
+				Fuzz:    map[string]float64{"Lat": 1000, "Lon": 1000},
+				Zero:    []string{"RelativeAlt"},
+			},
+			{
+				Message: &common.MessageOpenDroneIdOperatorId{},
+				Zero:    []string{"OperatorId"},
+				Func: func(m msg.Message) {
+					m.(*common.MessageOpenDroneIdOperatorId).OperatorIdType = 1
+				},
+			},
+		},
+		Key: key,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+func TestNewError(t *testing.T) {
+	_, err := New(Conf{Rules: []Rule{{}}})
+	require.EqualError(t, err, "Message must be provided")
+
+	_, err = New(Conf{Rules: []Rule{{
+		Message: &common.MessageGlobalPositionInt{},
+		Zero:    []string{"Missing"},
+	}}})
+	require.EqualError(t, err, "message *common.MessageGlobalPositionInt does not contain field Missing")
+
+	_, err = New(Conf{Rules: []Rule{{
+		Message: &common.MessageOpenDroneIdOperatorId{},
+		Fuzz:    map[string]float64{"OperatorId": 1},
+	}}})
+	require.EqualError(t, err, "field OperatorId is not numeric")
+
+	_, err = New(Conf{Rules: []Rule{
+		{Message: &common.MessageGlobalPositionInt{}},
+		{Message: &common.MessageGlobalPositionInt{}},
+	}})
+	require.EqualError(t, err, "duplicate rule for message with id 33")
+}
+
+func TestRedactMessage(t *testing.T) {
+	r := testRedactor(t, nil)
+
+	orig := &common.MessageGlobalPositionInt{
+		Lat:         450000000,
+		Lon:         2147483000,
+		Alt:         100000,
+		RelativeAlt: 20000,
+	}
+
+	for i := 0; i < 100; i++ {
+		m, err := r.RedactMessage(orig)
+		require.NoError(t, err)
+
+		gp := m.(*common.MessageGlobalPositionInt)
+		require.True(t, gp.Lat >= 450000000-1000 && gp.Lat <= 450000000+1000)
+		require.True(t, gp.Lon >= 2147483000-1000)
+		require.Equal(t, int32(100000), gp.Alt)
+		require.Equal(t, int32(0), gp.RelativeAlt)
+	}
+
+	// the original message is not modified
+	require.Equal(t, int32(20000), orig.RelativeAlt)
+
+	m, err := r.RedactMessage(&common.MessageOpenDroneIdOperatorId{OperatorId: "OP123"})
+	require.NoError(t, err)
+	require.Equal(t, &common.MessageOpenDroneIdOperatorId{OperatorIdType: 1}, m)
+
+	// messages without rules are not redacted
+	other := &common.MessageHeartbeat{}
+	m, err = r.RedactMessage(other)
+	require.NoError(t, err)
+	require.True(t, m == other)
+}
+
+func TestRedactFrame(t *testing.T) {
+	key := frame.NewV2Key([]byte("secret"))
+
+	for _, ca := range []string{"v1", "v2", "v2 signed", "v2 raw"} {
+		t.Run(ca, func(t *testing.T) {
+			de, err := dialect.NewDecEncoder(&dialect.Dialect{
+				Version:  3,
+				Messages: []msg.Message{&common.MessageGlobalPositionInt{}},
+			})
+			require.NoError(t, err)
+
+			ver := transceiver.V2
+			if ca == "v1" {
+				ver = transceiver.V1
+			}
+
+			var outKey *frame.V2Key
+			if ca == "v2 signed" {
+				outKey = key
+			}
+
+			var buf bytes.Buffer
+			tx, err := transceiver.New(transceiver.TransceiverConf{
+				Reader:      bytes.NewReader(nil),
+				Writer:      &buf,
+				DialectDE:   de,
+				OutVersion:  ver,
+				OutSystemId: 1,
+				OutKey:      outKey,
+			})
+			require.NoError(t, err)
+			err = tx.WriteMessage(&common.MessageGlobalPositionInt{
+				Lat:         450000000,
+				Alt:         100000,
+				RelativeAlt: 20000,
+			})
+			require.NoError(t, err)
+
+			// route with or without decoding
+			rxDE := de
+			if ca == "v2 raw" {
+				rxDE = nil
+			}
+			rx, err := transceiver.New(transceiver.TransceiverConf{
+				Reader:      &buf,
+				Writer:      &buf,
+				DialectDE:   rxDE,
+				OutVersion:  ver,
+				OutSystemId: 2,
+			})
+			require.NoError(t, err)
+			f, err := rx.Read()
+			require.NoError(t, err)
+
+			f, err = testRedactor(t, key).RedactFrame(f)
+			require.NoError(t, err)
+			err = rx.WriteFrame(f)
+			require.NoError(t, err)
+
+			// the checksum and the signature are valid
+			var inKey *frame.V2Key
+			if ca == "v2 signed" {
+				inKey = key
+			}
+			rx2, err := transceiver.New(transceiver.TransceiverConf{
+				Reader:      &buf,
+				Writer:      &buf,
+				DialectDE:   de,
+				InKey:       inKey,
+				OutVersion:  ver,
+				OutSystemId: 3,
+			})
+			require.NoError(t, err)
+			f, err = rx2.Read()
+			require.NoError(t, err)
+
+			gp := f.GetMessage().(*common.MessageGlobalPositionInt)
+			require.True(t, gp.Lat >= 450000000-1000 && gp.Lat <= 450000000+1000)
+			require.Equal(t, int32(100000), gp.Alt)
+			require.Equal(t, int32(0), gp.RelativeAlt)
+			require.Equal(t, byte(1), f.GetSystemId())
+		})
+	}
+}
@@ -0,0 +1,426 @@
+// Package resample implements a resampler that converts the irregular
+// GLOBAL_POSITION_INT and ATTITUDE streams of a vehicle into a time series
+// with a fixed rate, by interpolating between consecutive messages or
+// extrapolating the last one, within configurable bounds. It allows to feed
+// control loops and databases with clean data.
+//
+// The node to which the resampler is attached must use a dialect that contains
+// the common messages.
+package resample
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	earthRadius = 6371000
+
+	// maximum rate at which the estimated boot time of the vehicle can move
+	// forward, that allows to follow vehicle clocks slower than the local one
+	clockDriftMax = 1e-3
+
+	// a decrease of the boot time larger than this is considered a reboot
+	rebootThreshold = 10 * time.Second
+)
+
+// Sample is the state of a vehicle at a given time.
+type Sample struct {
+	// the time the sample refers to.
+	Time time.Time
+
+	// whether the position fields are valid, i.e. Time is within the
+	// interpolation or extrapolation bounds of GLOBAL_POSITION_INT.
+	PositionValid bool
+	// latitude, in deg.
+	Lat float64
+	// longitude, in deg.
+	Lon float64
+	// altitude (MSL), in m.
+	Alt float64
+	// altitude above home, in m.
+	RelativeAlt float64
+	// speed along the north axis, in m/s.
+	Vx float64
+	// speed along the east axis, in m/s.
+	Vy float64
+	// speed along the down axis, in m/s.
+	Vz float64
+
+	// whether the attitude fields are valid, i.e. Time is within the
+	// interpolation or extrapolation bounds of ATTITUDE.
+	AttitudeValid bool
+	// roll angle, in rad.
+	Roll float64
+	// pitch angle, in rad.
+	Pitch float64
+	// yaw angle, in rad (-pi..+pi).
+	Yaw float64
+	// roll angular speed, in rad/s.
+	RollSpeed float64
+	// pitch angular speed, in rad/s.
+	PitchSpeed float64
+	// yaw angular speed, in rad/s.
+	YawSpeed float64
+}
+
+// Conf allows to configure a Resampler.
+type Conf struct {
+	// the node from which messages are read.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// called with a new sample at every period.
+	// It is called by a dedicated routine.
+	OnSample func(Sample)
+
+	// (optional) the period of samples.
+	// It defaults to 100ms.
+	Period time.Duration
+
+	// (optional) the delay of samples with respect to the current time.
+	// Increasing it allows to interpolate between messages instead of
+	// extrapolating the last one, at the cost of latency.
+	// It defaults to zero.
+	Delay time.Duration
+
+	// (optional) the maximum distance between two messages that are
+	// interpolated.
+	// It defaults to 1s.
+	MaxGap time.Duration
+
+	// (optional) the maximum distance between a sample and the last message,
+	// when the message is extrapolated. Samples farther away are invalid.
+	// It defaults to 500ms.
+	MaxExtrapolation time.Duration
+}
+
+type sample struct {
+	t time.Time
+	v []float64
+}
+
+// series contains the recent messages of a stream.
+type series struct {
+	samples     []sample
+	interpolate func(a []float64, b []float64, f float64) []float64
+	extrapolate func(a []float64, dt float64) []float64
+}
+
+func (s *series) add(t time.Time, v []float64) {
+	// discard messages received out of order
+	if len(s.samples) > 0 && !t.After(s.samples[len(s.samples)-1].t) {
+		return
+	}
+	s.samples = append(s.samples, sample{t, v})
+}
+
+// prune removes the messages that are not needed anymore to compute samples
+// at or after t.
+func (s *series) prune(t time.Time) {
+	i := 0
+	for i < len(s.samples)-1 && !s.samples[i+1].t.After(t) {
+		i++
+	}
+	s.samples = s.samples[i:]
+}
+
+func (s *series) at(t time.Time, maxGap time.Duration, maxExtrapolation time.Duration) ([]float64, bool) {
+	var prev, next *sample
+	for i := range s.samples {
+		if s.samples[i].t.After(t) {
+			next = &s.samples[i]
+			break
+		}
+		prev = &s.samples[i]
+	}
+
+	if prev == nil {
+		return nil, false
+	}
+
+	if next != nil && next.t.Sub(prev.t) <= maxGap {
+		f := float64(t.Sub(prev.t)) / float64(next.t.Sub(prev.t))
+		return s.interpolate(prev.v, next.v, f), true
+	}
+
+	dt := t.Sub(prev.t)
+	if dt > maxExtrapolation {
+		return nil, false
+	}
+	return s.extrapolate(prev.v, dt.Seconds()), true
+}
+
+func lerp(a float64, b float64, f float64) float64 {
+	return a + (b-a)*f
+}
+
+// wrapAngle brings an angle into the range -pi..+pi.
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
+
+// lerpAngle interpolates two angles along the shortest path.
+func lerpAngle(a float64, b float64, f float64) float64 {
+	return wrapAngle(a + wrapAngle(b-a)*f)
+}
+
+// position vectors contain lat, lon, alt, relative alt, vx, vy, vz
+func interpolatePosition(a []float64, b []float64, f float64) []float64 {
+	ret := make([]float64, len(a))
+	for i := range a {
+		ret[i] = lerp(a[i], b[i], f)
+	}
+
+	// longitude crosses the antimeridian
+	ret[1] = lerpAngle(a[1]*math.Pi/180, b[1]*math.Pi/180, f) * 180 / math.Pi
+	return ret
+}
+
+func extrapolatePosition(a []float64, dt float64) []float64 {
+	ret := append([]float64(nil), a...)
+	ret[0] += a[4] * dt / earthRadius * 180 / math.Pi
+	ret[1] += a[5] * dt / (earthRadius * math.Cos(a[0]*math.Pi/180)) * 180 / math.Pi
+	ret[1] = wrapAngle(ret[1]*math.Pi/180) * 180 / math.Pi
+	ret[2] -= a[6] * dt
+	ret[3] -= a[6] * dt
+	return ret
+}
+
+// attitude vectors contain roll, pitch, yaw, roll speed, pitch speed, yaw speed
+func interpolateAttitude(a []float64, b []float64, f float64) []float64 {
+	ret := make([]float64, len(a))
+	for i := 0; i < 3; i++ {
+		ret[i] = lerpAngle(a[i], b[i], f)
+	}
+	for i := 3; i < 6; i++ {
+		ret[i] = lerp(a[i], b[i], f)
+	}
+	return ret
+}
+
+func extrapolateAttitude(a []float64, dt float64) []float64 {
+	ret := append([]float64(nil), a...)
+	for i := 0; i < 3; i++ {
+		ret[i] = wrapAngle(a[i] + a[i+3]*dt)
+	}
+	return ret
+}
+
+// Resampler is a vehicle position and attitude resampler.
+type Resampler struct {
+	conf          Conf
+	removeHandler func()
+
+	mutex      sync.Mutex
+	bootTime   time.Time // estimated local time of the boot of the vehicle
+	lastRecv   time.Time
+	lastBootMs uint32
+	position   *series
+	attitude   *series
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Resampler. See Conf for the options.
+func New(conf Conf) (*Resampler, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.OnSample == nil {
+		return nil, fmt.Errorf("OnSample not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageGlobalPositionInt{},
+		&common.MessageAttitude{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Period == 0 {
+		conf.Period = 100 * time.Millisecond
+	}
+	if conf.MaxGap == 0 {
+		conf.MaxGap = 1 * time.Second
+	}
+	if conf.MaxExtrapolation == 0 {
+		conf.MaxExtrapolation = 500 * time.Millisecond
+	}
+
+	r := newResampler(conf)
+
+	r.removeHandler = conf.Node.AddFrameHandler(r.onEventFrame)
+
+	go r.run()
+
+	return r, nil
+}
+
+func newResampler(conf Conf) *Resampler {
+	return &Resampler{
+		conf: conf,
+		position: &series{
+			interpolate: interpolatePosition,
+			extrapolate: extrapolatePosition,
+		},
+		attitude: &series{
+			interpolate: interpolateAttitude,
+			extrapolate: extrapolateAttitude,
+		},
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Close stops the resampler. It must be called before closing the node.
+func (r *Resampler) Close() {
+	r.removeHandler()
+	close(r.terminate)
+	<-r.done
+}
+
+func (r *Resampler) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.conf.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			r.conf.OnSample(r.sampleAt(now.Add(-r.conf.Delay)))
+
+		case <-r.terminate:
+			return
+		}
+	}
+}
+
+// messageTime converts the boot time of a message into a local time.
+// The boot time of the vehicle is estimated with the message that arrived
+// with the lowest latency, in order to remove the jitter of the link.
+func (r *Resampler) messageTime(bootMs uint32, recv time.Time) time.Time {
+	boot := recv.Add(-time.Duration(bootMs) * time.Millisecond)
+
+	switch {
+	case r.lastRecv.IsZero():
+		r.bootTime = boot
+
+	case time.Duration(r.lastBootMs)*time.Millisecond-time.Duration(bootMs)*time.Millisecond > rebootThreshold:
+		r.bootTime = boot
+		r.position.samples = nil
+		r.attitude.samples = nil
+
+	default:
+		allowed := r.bootTime.Add(time.Duration(float64(recv.Sub(r.lastRecv)) * clockDriftMax))
+		if boot.Before(allowed) {
+			r.bootTime = boot
+		} else {
+			r.bootTime = allowed
+		}
+	}
+
+	r.lastRecv = recv
+	r.lastBootMs = bootMs
+	return r.bootTime.Add(time.Duration(bootMs) * time.Millisecond)
+}
+
+func (r *Resampler) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != r.conf.SystemId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageGlobalPositionInt{}).GetId():
+		var m common.MessageGlobalPositionInt
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		r.addPosition(&m, time.Now())
+
+	case (&common.MessageAttitude{}).GetId():
+		var m common.MessageAttitude
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		r.addAttitude(&m, time.Now())
+	}
+}
+
+func (r *Resampler) addPosition(m *common.MessageGlobalPositionInt, recv time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.position.add(r.messageTime(m.TimeBootMs, recv), []float64{
+		float64(m.Lat) / 1e7,
+		float64(m.Lon) / 1e7,
+		float64(m.Alt) / 1000,
+		float64(m.RelativeAlt) / 1000,
+		float64(m.Vx) / 100,
+		float64(m.Vy) / 100,
+		float64(m.Vz) / 100,
+	})
+}
+
+func (r *Resampler) addAttitude(m *common.MessageAttitude, recv time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.attitude.add(r.messageTime(m.TimeBootMs, recv), []float64{
+		float64(m.Roll),
+		float64(m.Pitch),
+		float64(m.Yaw),
+		float64(m.Rollspeed),
+		float64(m.Pitchspeed),
+		float64(m.Yawspeed),
+	})
+}
+
+// sampleAt computes the sample at a given time. Samples must be computed in
+// chronological order, since older messages are discarded.
+func (r *Resampler) sampleAt(t time.Time) Sample {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := Sample{Time: t}
+
+	if v, ok := r.position.at(t, r.conf.MaxGap, r.conf.MaxExtrapolation); ok {
+		s.PositionValid = true
+		s.Lat, s.Lon, s.Alt, s.RelativeAlt = v[0], v[1], v[2], v[3]
+		s.Vx, s.Vy, s.Vz = v[4], v[5], v[6]
+	}
+
+	if v, ok := r.attitude.at(t, r.conf.MaxGap, r.conf.MaxExtrapolation); ok {
+		s.AttitudeValid = true
+		s.Roll, s.Pitch, s.Yaw = v[0], v[1], v[2]
+		s.RollSpeed, s.PitchSpeed, s.YawSpeed = v[3], v[4], v[5]
+	}
+
+	r.position.prune(t)
+	r.attitude.prune(t)
+
+	return s
+}
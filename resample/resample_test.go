@@ -0,0 +1,170 @@
+package resample
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func TestResampleInterpolation(t *testing.T) {
+	r := newResampler(Conf{
+		MaxGap:           1 * time.Second,
+		MaxExtrapolation: 500 * time.Millisecond,
+	})
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the second message arrives late, that must not alter its time
+	r.addPosition(&common.MessageGlobalPositionInt{
+		TimeBootMs: 1000,
+		Lat:        450000000,
+		Lon:        1799999000,
+		Alt:        100000,
+		Vx:         100,
+	}, start)
+	r.addPosition(&common.MessageGlobalPositionInt{
+		TimeBootMs: 1200,
+		Lat:        450000200,
+		Lon:        -1799999000,
+		Alt:        110000,
+		Vx:         100,
+	}, start.Add(300*time.Millisecond))
+
+	r.addAttitude(&common.MessageAttitude{
+		TimeBootMs: 1000,
+		Yaw:        3,
+		Yawspeed:   1,
+	}, start)
+
+	// interpolation
+	s := r.sampleAt(start.Add(100 * time.Millisecond))
+	require.Equal(t, true, s.PositionValid)
+	require.InDelta(t, 45.00001, s.Lat, 1e-7)
+	require.InDelta(t, 180, math.Abs(s.Lon), 1e-6)
+	require.InDelta(t, 105, s.Alt, 0.01)
+	require.InDelta(t, 1, s.Vx, 1e-9)
+
+	// extrapolation
+	require.Equal(t, true, s.AttitudeValid)
+	require.InDelta(t, 3.1, s.Yaw, 1e-9)
+	require.InDelta(t, 1, s.YawSpeed, 1e-9)
+
+	s = r.sampleAt(start.Add(400 * time.Millisecond))
+	require.Equal(t, true, s.PositionValid)
+	require.InDelta(t, 45.00002+0.2/float64(earthRadius)*180/math.Pi, s.Lat, 1e-8)
+	require.Equal(t, true, s.AttitudeValid)
+	require.InDelta(t, 3.4-2*math.Pi, s.Yaw, 1e-9)
+
+	// out of bounds
+	s = r.sampleAt(start.Add(800 * time.Millisecond))
+	require.Equal(t, false, s.PositionValid)
+	require.Equal(t, false, s.AttitudeValid)
+}
+
+func TestResampleGap(t *testing.T) {
+	r := newResampler(Conf{
+		MaxGap:           100 * time.Millisecond,
+		MaxExtrapolation: 50 * time.Millisecond,
+	})
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r.addAttitude(&common.MessageAttitude{TimeBootMs: 0, Roll: 0.1}, start)
+	r.addAttitude(&common.MessageAttitude{TimeBootMs: 500, Roll: 0.2}, start.Add(500*time.Millisecond))
+
+	s := r.sampleAt(start.Add(20 * time.Millisecond))
+	require.Equal(t, true, s.AttitudeValid)
+	require.InDelta(t, 0.1, s.Roll, 1e-6)
+
+	s = r.sampleAt(start.Add(250 * time.Millisecond))
+	require.Equal(t, false, s.AttitudeValid)
+
+	s = r.sampleAt(start.Add(520 * time.Millisecond))
+	require.Equal(t, true, s.AttitudeValid)
+	require.InDelta(t, 0.2, s.Roll, 1e-6)
+}
+
+func TestResampler(t *testing.T) {
+	node1, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5740"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node1.Close()
+
+	go func() {
+		for range node1.Events() {
+		}
+	}()
+
+	node2, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5740"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node2.Close()
+
+	samples := make(chan Sample, 100)
+
+	r, err := New(Conf{
+		Node:     node1,
+		SystemId: 1,
+		OnSample: func(s Sample) {
+			select {
+			case samples <- s:
+			default:
+			}
+		},
+		Period:           20 * time.Millisecond,
+		Delay:            50 * time.Millisecond,
+		MaxExtrapolation: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	for i := 0; i < 20; i++ {
+		node2.WriteMessageAll(&common.MessageGlobalPositionInt{
+			TimeBootMs: uint32(i * 30),
+			Lat:        450000000,
+			Lon:        90000000,
+			Alt:        100000,
+		})
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	valid := false
+	for len(samples) > 0 {
+		s := <-samples
+		require.Equal(t, false, s.AttitudeValid)
+		if s.PositionValid {
+			valid = true
+			require.InDelta(t, 45, s.Lat, 1e-9)
+			require.InDelta(t, 9, s.Lon, 1e-9)
+			require.InDelta(t, 100, s.Alt, 1e-9)
+		}
+	}
+	require.Equal(t, true, valid)
+
+	// samples become invalid when messages stop
+	time.Sleep(300 * time.Millisecond)
+	for len(samples) > 0 {
+		<-samples
+	}
+	s := <-samples
+	require.Equal(t, false, s.PositionValid)
+}
@@ -0,0 +1,160 @@
+package rtcm
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// NTRIPConf allows to configure an NTRIP connection.
+type NTRIPConf struct {
+	// the address of the caster, example: caster.example.com:2101
+	Address string
+
+	// the mountpoint, i.e. the stream of a base station.
+	Mountpoint string
+
+	// (optional) the credentials of the caster.
+	User     string
+	Password string
+
+	// (optional) a NMEA GGA sentence that is sent after connecting, that
+	// contains the approximate position of the vehicle. It is required
+	// by casters that provide virtual reference stations.
+	GGA string
+}
+
+type ntripConn struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (c *ntripConn) Close() error {
+	return c.conn.Close()
+}
+
+// DialNTRIP connects to an NTRIP caster and returns the RTCM3 stream of a
+// mountpoint, that can be written into an Injector. Both NTRIP 1.0 and
+// NTRIP 2.0 casters are supported.
+// The context is used for the connection and the handshake only.
+func DialNTRIP(ctx context.Context, conf NTRIPConf) (io.ReadCloser, error) {
+	host, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.Mountpoint == "" {
+		return nil, fmt.Errorf("Mountpoint not provided")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// close the connection when the context is canceled during the handshake
+	handshakeDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handshakeDone:
+		}
+	}()
+
+	r, err := ntripHandshake(conn, host, conf)
+	close(handshakeDone)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return &ntripConn{Reader: r, conn: conn}, nil
+}
+
+func httpStatusCode(status string) string {
+	parts := strings.Fields(status)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+func ntripHandshake(conn net.Conn, host string, conf NTRIPConf) (io.Reader, error) {
+	req := "GET /" + strings.TrimPrefix(conf.Mountpoint, "/") + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"Ntrip-Version: Ntrip/2.0\r\n" +
+		"User-Agent: NTRIP gomavlib\r\n"
+	if conf.User != "" {
+		req += "Authorization: Basic " +
+			base64.StdEncoding.EncodeToString([]byte(conf.User+":"+conf.Password)) + "\r\n"
+	}
+	req += "Connection: close\r\n\r\n"
+
+	_, err := conn.Write([]byte(req))
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+
+	status, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+
+	switch {
+	// NTRIP 1.0 casters reply with a non-standard status line, followed
+	// by the stream
+	case status == "ICY 200 OK":
+		r = br
+
+	case strings.HasPrefix(status, "HTTP/1.") && httpStatusCode(status) == "200":
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.EqualFold(header.Get("Transfer-Encoding"), "chunked") {
+			r = httputil.NewChunkedReader(br)
+		} else {
+			r = br
+		}
+
+	// casters reply with the source table when the mountpoint is not found
+	case strings.HasPrefix(status, "SOURCETABLE"):
+		return nil, fmt.Errorf("mountpoint not found")
+
+	default:
+		return nil, fmt.Errorf("bad status: %s", status)
+	}
+
+	if conf.GGA != "" {
+		_, err := conn.Write([]byte(strings.TrimSpace(conf.GGA) + "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
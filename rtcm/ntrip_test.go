@@ -0,0 +1,123 @@
+package rtcm
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCaster starts a caster that checks the request and writes the
+// given response.
+func newTestCaster(t *testing.T, response string, requests chan textproto.MIMEHeader) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				tp := textproto.NewReader(bufio.NewReader(conn))
+				line, err := tp.ReadLine()
+				if err != nil {
+					return
+				}
+				header, err := tp.ReadMIMEHeader()
+				if err != nil {
+					return
+				}
+				header.Set("Request-Line", line)
+				requests <- header
+
+				conn.Write([]byte(response))
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestDialNTRIP(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		response string
+	}{
+		{
+			"v1",
+			"ICY 200 OK\r\nrtcm data",
+		},
+		{
+			"v2",
+			"HTTP/1.1 200 OK\r\nContent-Type: gnss/data\r\n\r\nrtcm data",
+		},
+		{
+			"v2 chunked",
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nrtcm\r\n5\r\n data\r\n0\r\n\r\n",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			requests := make(chan textproto.MIMEHeader, 1)
+			l := newTestCaster(t, ca.response, requests)
+			defer l.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			r, err := DialNTRIP(ctx, NTRIPConf{
+				Address:    l.Addr().String(),
+				Mountpoint: "BASE",
+				User:       "user",
+				Password:   "pass",
+			})
+			require.NoError(t, err)
+			defer r.Close()
+
+			req := <-requests
+			require.Equal(t, "GET /BASE HTTP/1.1", req.Get("Request-Line"))
+			require.Equal(t, "Ntrip/2.0", req.Get("Ntrip-Version"))
+			require.Equal(t, "Basic dXNlcjpwYXNz", req.Get("Authorization"))
+
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "rtcm data", string(data))
+		})
+	}
+}
+
+func TestDialNTRIPErrors(t *testing.T) {
+	requests := make(chan textproto.MIMEHeader, 10)
+
+	l := newTestCaster(t, "SOURCETABLE 200 OK\r\n\r\nENDSOURCETABLE\r\n", requests)
+	defer l.Close()
+
+	_, err := DialNTRIP(context.Background(), NTRIPConf{
+		Address:    l.Addr().String(),
+		Mountpoint: "MISSING",
+	})
+	require.EqualError(t, err, "mountpoint not found")
+
+	l2 := newTestCaster(t, "HTTP/1.1 401 Unauthorized\r\n\r\n", requests)
+	defer l2.Close()
+
+	_, err = DialNTRIP(context.Background(), NTRIPConf{
+		Address:    l2.Addr().String(),
+		Mountpoint: "BASE",
+	})
+	require.EqualError(t, err, "bad status: HTTP/1.1 401 Unauthorized")
+
+	_, err = DialNTRIP(context.Background(), NTRIPConf{
+		Address: l2.Addr().String(),
+	})
+	require.EqualError(t, err, "Mountpoint not provided")
+}
@@ -0,0 +1,101 @@
+package rtcm
+
+const (
+	preamble = 0xD3
+
+	headerSize = 3
+	crcSize    = 3
+
+	// MaxMessageSize is the maximum size of a RTCM3 message, including its
+	// header and its checksum.
+	MaxMessageSize = headerSize + 1023 + crcSize
+)
+
+// crc24q computes the CRC-24Q checksum used by RTCM3.
+func crc24q(buf []byte) uint32 {
+	var crc uint32
+	for _, b := range buf {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if (crc & 0x1000000) != 0 {
+				crc ^= 0x1864CFB
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// parser splits a RTCM3 byte stream into messages. Bytes that do not
+// belong to valid messages are skipped, in order to synchronize with
+// streams that start in the middle of a message.
+type parser struct {
+	buf       []byte
+	crcErrors uint64
+}
+
+// push adds bytes to the parser, and returns the messages that have been
+// completed, including their headers and checksums.
+func (p *parser) push(data []byte) [][]byte {
+	p.buf = append(p.buf, data...)
+
+	var ret [][]byte
+
+	for {
+		// find the preamble
+		i := 0
+		for i < len(p.buf) && p.buf[i] != preamble {
+			i++
+		}
+		p.buf = p.buf[i:]
+
+		if len(p.buf) < headerSize {
+			break
+		}
+
+		// reserved bits must be zero
+		if (p.buf[1] & 0xFC) != 0 {
+			p.buf = p.buf[1:]
+			continue
+		}
+
+		size := headerSize + (int(p.buf[1]&0x03)<<8 | int(p.buf[2])) + crcSize
+		if len(p.buf) < size {
+			break
+		}
+
+		crc := uint32(p.buf[size-3])<<16 | uint32(p.buf[size-2])<<8 | uint32(p.buf[size-1])
+		if crc24q(p.buf[:size-crcSize]) != crc {
+			p.crcErrors++
+			p.buf = p.buf[1:]
+			continue
+		}
+
+		ret = append(ret, append([]byte(nil), p.buf[:size]...))
+		p.buf = p.buf[size:]
+	}
+
+	// release the memory of long streams of garbage
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+
+	return ret
+}
+
+// Encode frames a RTCM3 message body into a message, by adding the header and
+// the checksum. It is useful to inject messages generated by software, i.e.
+// by a base station emulator.
+func Encode(body []byte) []byte {
+	buf := make([]byte, headerSize+len(body)+crcSize)
+	buf[0] = preamble
+	buf[1] = byte(len(body)>>8) & 0x03
+	buf[2] = byte(len(body))
+	copy(buf[headerSize:], body)
+
+	crc := crc24q(buf[:headerSize+len(body)])
+	buf[len(buf)-3] = byte(crc >> 16)
+	buf[len(buf)-2] = byte(crc >> 8)
+	buf[len(buf)-1] = byte(crc)
+	return buf
+}
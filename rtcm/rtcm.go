@@ -0,0 +1,167 @@
+// Package rtcm implements the injection of RTCM3 corrections into vehicles
+// through GPS_RTCM_DATA messages, in order to provide RTK positioning
+// with a base station or an NTRIP caster.
+//
+// The injector reads a RTCM3 byte stream, i.e. from a serial port connected
+// to a base station or from an NTRIP caster (see DialNTRIP), splits it into
+// messages, and writes each message to all channels of the node, split into
+// up to 4 fragments of 180 bytes.
+//
+// The node used by the package must use a dialect that contains the common
+// messages.
+package rtcm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+const (
+	fragmentSize = 180
+	maxFragments = 4
+
+	// MaxInjectSize is the maximum size of a RTCM3 message that can be
+	// carried by GPS_RTCM_DATA messages.
+	MaxInjectSize = fragmentSize * maxFragments
+)
+
+// Stats are the statistics of an Injector.
+type Stats struct {
+	// the number of injected RTCM3 messages.
+	Messages uint64
+
+	// the number of written GPS_RTCM_DATA messages.
+	Fragments uint64
+
+	// the number of RTCM3 messages that have been discarded since they do
+	// not fit into GPS_RTCM_DATA messages.
+	Dropped uint64
+
+	// the number of RTCM3 messages with a wrong checksum.
+	CRCErrors uint64
+}
+
+// Conf allows to configure an Injector.
+type Conf struct {
+	// the node used to write messages.
+	Node *gomavlib.Node
+}
+
+// Injector injects RTCM3 corrections into vehicles.
+type Injector struct {
+	conf Conf
+
+	mutex    sync.Mutex
+	parser   parser
+	sequence uint8
+	stats    Stats
+}
+
+// New allocates an Injector. See Conf for the options.
+func New(conf Conf) (*Injector, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageGpsRtcmData{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Injector{
+		conf: conf,
+	}, nil
+}
+
+// Write implements io.Writer. It adds bytes of a RTCM3 stream, and injects
+// the messages that have been completed. It never returns an error,
+// therefore a stream can be injected with io.Copy().
+func (i *Injector) Write(p []byte) (int, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, m := range i.parser.push(p) {
+		if len(m) > MaxInjectSize {
+			i.stats.Dropped++
+			continue
+		}
+		i.inject(m)
+	}
+	i.stats.CRCErrors = i.parser.crcErrors
+
+	return len(p), nil
+}
+
+// Inject injects a single RTCM3 message, including its header and its
+// checksum.
+func (i *Injector) Inject(m []byte) error {
+	if len(m) > MaxInjectSize {
+		return fmt.Errorf("message is too long (%d bytes, maximum is %d)", len(m), MaxInjectSize)
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.inject(m)
+	return nil
+}
+
+// Stats returns the statistics of the injector.
+func (i *Injector) Stats() Stats {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.stats
+}
+
+func (i *Injector) inject(m []byte) {
+	seq := i.sequence & 0x1F
+	i.sequence++
+	i.stats.Messages++
+
+	// messages that fit into a single GPS_RTCM_DATA are not fragmented
+	if len(m) <= fragmentSize {
+		out := &common.MessageGpsRtcmData{
+			Flags: seq << 3,
+			Len:   uint8(len(m)),
+		}
+		copy(out.Data[:], m)
+		i.conf.Node.WriteMessageAll(out)
+		i.stats.Fragments++
+		return
+	}
+
+	// the autopilot considers a message complete when all 4 fragments have
+	// been received or when a fragment is not full, therefore an empty
+	// fragment is appended to messages that fill their last fragment.
+	count := (len(m) + fragmentSize - 1) / fragmentSize
+	if (len(m)%fragmentSize) == 0 && count < maxFragments {
+		count++
+	}
+
+	for id := 0; id < count; id++ {
+		start := id * fragmentSize
+		end := start + fragmentSize
+		if start > len(m) {
+			start = len(m)
+		}
+		if end > len(m) {
+			end = len(m)
+		}
+
+		out := &common.MessageGpsRtcmData{
+			Flags: 0x01 | uint8(id)<<1 | seq<<3,
+			Len:   uint8(end - start),
+		}
+		copy(out.Data[:], m[start:end])
+		i.conf.Node.WriteMessageAll(out)
+		i.stats.Fragments++
+	}
+}
@@ -0,0 +1,158 @@
+package rtcm
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+func testBody(size int) []byte {
+	buf := make([]byte, size)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	return buf
+}
+
+func TestCRC24Q(t *testing.T) {
+	require.Equal(t, uint32(0xCDE703), crc24q([]byte("123456789")))
+}
+
+func TestParser(t *testing.T) {
+	m1 := Encode(testBody(20))
+	m2 := Encode(testBody(300))
+
+	corrupted := Encode(testBody(10))
+	corrupted[5]++
+
+	stream := append([]byte{0x01, 0xD3, 0xFF, 0x02}, m1...)
+	stream = append(stream, corrupted...)
+	stream = append(stream, m2...)
+
+	var p parser
+	var msgs [][]byte
+
+	// the stream is split into small parts
+	for i := 0; i < len(stream); i += 7 {
+		end := i + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		msgs = append(msgs, p.push(stream[i:end])...)
+	}
+
+	require.Equal(t, [][]byte{m1, m2}, msgs)
+	require.Equal(t, uint64(1), p.crcErrors)
+}
+
+func TestInjector(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	vehicle, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      1,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: c1}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer vehicle.Close()
+
+	recv := make(chan *common.MessageGpsRtcmData, 100)
+	go func() {
+		for evt := range vehicle.Events() {
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if m, ok := fr.Message().(*common.MessageGpsRtcmData); ok {
+					recv <- m
+				}
+			}
+		}
+	}()
+
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      255,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: c2}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	_, err = New(Conf{})
+	require.EqualError(t, err, "Node not provided")
+
+	inj, err := New(Conf{Node: gcs})
+	require.NoError(t, err)
+
+	// reassemble messages as the autopilot does, and check flags
+	readMessage := func(seq uint8, fragments int) []byte {
+		var buf []byte
+		for i := 0; i < fragments; i++ {
+			select {
+			case m := <-recv:
+				require.Equal(t, seq, m.Flags>>3)
+				if fragments == 1 {
+					require.Equal(t, uint8(0), m.Flags&0x01)
+				} else {
+					require.Equal(t, uint8(1), m.Flags&0x01)
+					require.Equal(t, uint8(i), (m.Flags>>1)&0x03)
+				}
+				buf = append(buf, m.Data[:m.Len]...)
+
+			case <-time.After(2 * time.Second):
+				t.Fatal("message not received")
+			}
+		}
+		return buf
+	}
+
+	for seq, ca := range []struct {
+		size      int
+		fragments int
+	}{
+		{100, 1},
+		{180, 1},
+		{360, 3},
+		{500, 3},
+		{720, 4},
+	} {
+		// sizes include the header and the checksum
+		m := Encode(testBody(ca.size - 6))
+
+		_, err := inj.Write(m)
+		require.NoError(t, err)
+
+		require.Equal(t, m, readMessage(uint8(seq), ca.fragments))
+	}
+
+	err = inj.Inject(Encode(testBody(800)))
+	require.EqualError(t, err, "message is too long (806 bytes, maximum is 720)")
+
+	_, err = inj.Write(Encode(testBody(800)))
+	require.NoError(t, err)
+
+	// sequence ids wrap around after 32 messages
+	m := Encode(testBody(10))
+	for i := 5; i < 40; i++ {
+		err := inj.Inject(m)
+		require.NoError(t, err)
+		require.Equal(t, m, readMessage(uint8(i%32), 1))
+	}
+
+	require.Equal(t, Stats{
+		Messages:  40,
+		Fragments: 47,
+		Dropped:   1,
+	}, inj.Stats())
+}
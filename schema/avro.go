@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aler9/gomavlib/dialect"
+)
+
+var avroTypes = map[string]string{
+	"int8":   "int",
+	"uint8":  "int",
+	"int16":  "int",
+	"uint16": "int",
+	"int32":  "int",
+	"uint32": "long",
+	"int64":  "long",
+	// Avro does not provide unsigned 64-bit integers
+	"uint64": "long",
+	"float":  "float",
+	"double": "double",
+	"char":   "string",
+}
+
+type avroEnum struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Symbols []string `json:"symbols"`
+}
+
+type avroArray struct {
+	Type  string      `json:"type"`
+	Items interface{} `json:"items"`
+}
+
+type avroField struct {
+	Name string      `json:"name"`
+	Type interface{} `json:"type"`
+}
+
+type avroRecord struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Doc       string      `json:"doc"`
+	Fields    []avroField `json:"fields"`
+}
+
+// Avro exports the messages of a dialect into an Avro schema, that is a union
+// of records, one per message, in the namespace with the given name. Enums
+// are defined by the first record that uses them. Since Avro does not provide
+// unsigned 64-bit integers, uint64 fields are exported as long.
+func Avro(d *dialect.Dialect, name string) ([]byte, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	mod, err := newModel(d)
+	if err != nil {
+		return nil, err
+	}
+
+	defined := make(map[*enum]struct{})
+	union := make([]avroRecord, len(mod.messages))
+
+	for i, m := range mod.messages {
+		fields := make([]avroField, len(m.fields))
+
+		for j, f := range m.fields {
+			var typ interface{} = avroTypes[f.typ]
+
+			if f.enum != nil {
+				if _, ok := defined[f.enum]; ok {
+					typ = f.enum.name
+				} else {
+					symbols := make([]string, len(f.enum.values))
+					for k, v := range f.enum.values {
+						symbols[k] = v.label
+					}
+					typ = avroEnum{"enum", f.enum.name, symbols}
+					defined[f.enum] = struct{}{}
+				}
+			}
+
+			if f.typ != "char" && f.length > 0 {
+				typ = avroArray{"array", typ}
+			}
+
+			fields[j] = avroField{f.name, typ}
+		}
+
+		union[i] = avroRecord{
+			Type:      "record",
+			Name:      m.name,
+			Namespace: name,
+			Doc:       fmt.Sprintf("message id %d, CRC extra %d, dialect version %d", m.id, m.crcExtra, mod.version),
+			Fields:    fields,
+		}
+	}
+
+	return json.MarshalIndent(union, "", "  ")
+}
@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/aler9/gomavlib/dialect"
+)
+
+// intRanges contains the limits of integer types.
+var intRanges = map[string][2]interface{}{
+	"int8":   {math.MinInt8, math.MaxInt8},
+	"uint8":  {0, math.MaxUint8},
+	"int16":  {math.MinInt16, math.MaxInt16},
+	"uint16": {0, math.MaxUint16},
+	"int32":  {math.MinInt32, math.MaxInt32},
+	"uint32": {0, uint32(math.MaxUint32)},
+	"int64":  {int64(math.MinInt64), int64(math.MaxInt64)},
+	"uint64": {0, uint64(math.MaxUint64)},
+}
+
+type object map[string]interface{}
+
+func jsonSchemaType(f *field) object {
+	switch {
+	case f.typ == "char":
+		return object{"type": "string", "maxLength": f.length}
+
+	case f.enum != nil:
+		return object{"$ref": "#/$defs/" + f.enum.name}
+
+	case f.typ == "float" || f.typ == "double":
+		return object{"type": "number"}
+	}
+
+	r := intRanges[f.typ]
+	return object{"type": "integer", "minimum": r[0], "maximum": r[1]}
+}
+
+// JSONSchema exports the messages of a dialect into a JSON Schema document
+// (draft 2020-12), titled with the given name. Messages and enums are
+// placed into $defs; the document validates any message of the dialect.
+func JSONSchema(d *dialect.Dialect, name string) ([]byte, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	mod, err := newModel(d)
+	if err != nil {
+		return nil, err
+	}
+
+	defs := object{}
+	var anyOf []object
+
+	for _, e := range mod.enums {
+		labels := make([]string, len(e.values))
+		for i, v := range e.values {
+			labels[i] = v.label
+		}
+		defs[e.name] = object{"type": "string", "enum": labels}
+	}
+
+	for _, m := range mod.messages {
+		props := object{}
+		required := make([]string, len(m.fields))
+
+		for i, f := range m.fields {
+			typ := jsonSchemaType(f)
+			if f.typ != "char" && f.length > 0 {
+				typ = object{
+					"type":     "array",
+					"items":    typ,
+					"minItems": f.length,
+					"maxItems": f.length,
+				}
+			}
+
+			props[f.name] = typ
+			required[i] = f.name
+		}
+
+		defs[m.name] = object{
+			"title":                m.name,
+			"$comment":             fmt.Sprintf("message id %d, CRC extra %d", m.id, m.crcExtra),
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+		anyOf = append(anyOf, object{"$ref": "#/$defs/" + m.name})
+	}
+
+	return json.MarshalIndent(object{
+		"$schema":  "https://json-schema.org/draft/2020-12/schema",
+		"title":    name,
+		"$comment": fmt.Sprintf("dialect version %d", mod.version),
+		"$defs":    defs,
+		"anyOf":    anyOf,
+	}, "", "  ")
+}
@@ -0,0 +1,97 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/aler9/gomavlib/dialect"
+)
+
+var protobufTypes = map[string]string{
+	"int8":   "int32",
+	"uint8":  "uint32",
+	"int16":  "int32",
+	"uint16": "uint32",
+	"int32":  "int32",
+	"uint32": "uint32",
+	"int64":  "int64",
+	"uint64": "uint64",
+	"float":  "float",
+	"double": "double",
+	"char":   "string",
+}
+
+// protobufEnum reports whether an enum can be exported as a protobuf enum,
+// whose values must fit into 32-bit signed integers.
+func protobufEnum(e *enum) bool {
+	for _, v := range e.values {
+		if v.value < math.MinInt32 || v.value > math.MaxInt32 {
+			return false
+		}
+	}
+	return true
+}
+
+// Protobuf exports the messages of a dialect into a protobuf definition file
+// (proto3), in the package with the given name. Field numbers follow the order
+// of fields, therefore they are stable across dialect versions, since new
+// fields are always appended as extensions.
+//
+// Enums must start with a zero value: when they don't, a value named
+// <ENUM>_UNSPECIFIED is added. Enums with values that do not fit into 32-bit
+// signed integers are exported as integers.
+func Protobuf(d *dialect.Dialect, name string) ([]byte, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	mod, err := newModel(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "// dialect version %d\n\n", mod.version)
+	fmt.Fprintf(&b, "syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&b, "package %s;\n", name)
+
+	for _, e := range mod.enums {
+		if !protobufEnum(e) {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\nenum %s {\n", e.name)
+		if e.values[0].value != 0 {
+			fmt.Fprintf(&b, "  %s_UNSPECIFIED = 0;\n", e.name)
+		}
+		for _, v := range e.values {
+			fmt.Fprintf(&b, "  %s = %d;\n", v.label, v.value)
+		}
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	for _, m := range mod.messages {
+		fmt.Fprintf(&b, "\n// message id %d, CRC extra %d\n", m.id, m.crcExtra)
+		fmt.Fprintf(&b, "message %s {\n", m.name)
+
+		for i, f := range m.fields {
+			typ := protobufTypes[f.typ]
+			if f.enum != nil && protobufEnum(f.enum) {
+				typ = f.enum.name
+			}
+
+			if f.typ != "char" && f.length > 0 {
+				typ = "repeated " + typ
+			}
+
+			fmt.Fprintf(&b, "  %s %s = %d;\n", typ, f.name, i+1)
+		}
+
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,222 @@
+// Package schema implements the export of the messages of a dialect into
+// JSON Schema, Avro and protobuf definitions, in order to validate and evolve
+// data pipelines that ingest decoded messages against the exact dialect
+// version in use.
+//
+// Definitions describe messages as they are encoded by encoding/json: fields
+// are named after the fields of the Go structs, character arrays are strings,
+// other arrays are lists and enums are represented by their labels. Every
+// message becomes a definition named after the message in the definition
+// files (i.e. HEARTBEAT).
+package schema
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/msg"
+)
+
+var reName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*(\\.[A-Za-z_][A-Za-z0-9_]*)*$")
+
+// enumValue is a value of an enum.
+type enumValue struct {
+	label string
+	value int64
+}
+
+// enum is an enum, with its values sorted.
+type enum struct {
+	name   string
+	values []enumValue
+}
+
+// field is a field of a message.
+type field struct {
+	name string
+
+	// the wire type, i.e. uint8, float, char
+	typ string
+
+	// the length of arrays and strings, or zero
+	length int
+
+	// the enum of the field, or nil
+	enum *enum
+}
+
+// message is a message of a dialect.
+type message struct {
+	name     string
+	id       uint32
+	crcExtra byte
+	fields   []*field
+}
+
+// model contains the messages of a dialect, sorted by id, and their enums,
+// sorted by name.
+type model struct {
+	version  int
+	messages []*message
+	enums    []*enum
+}
+
+var wireTypeFromGo = map[string]string{
+	"int8":    "int8",
+	"uint8":   "uint8",
+	"int16":   "int16",
+	"uint16":  "uint16",
+	"int32":   "int32",
+	"uint32":  "uint32",
+	"int64":   "int64",
+	"uint64":  "uint64",
+	"float32": "float",
+	"float64": "double",
+}
+
+// enumValues finds the values of an enum through its MarshalText method, that
+// fails with unknown values. Since enum types do not expose their values,
+// candidates are all the values that fit into 16 bits and powers of two,
+// that cover both enums and bitmasks of definition files.
+func enumValues(rt reflect.Type, wireType string) []enumValue {
+	var candidates []int64
+
+	limit := int64(0xFFFF)
+	if wireType == "uint8" || wireType == "int8" {
+		limit = 0xFF
+	}
+	for i := int64(0); i <= limit; i++ {
+		candidates = append(candidates, i)
+	}
+
+	if limit == 0xFFFF {
+		for i := uint(16); i < 63; i++ {
+			candidates = append(candidates, int64(1)<<i)
+		}
+	}
+
+	var ret []enumValue
+	v := reflect.New(rt).Elem()
+
+	for _, c := range candidates {
+		v.SetInt(c)
+		label, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err == nil {
+			ret = append(ret, enumValue{string(label), c})
+		}
+	}
+
+	return ret
+}
+
+func newModel(d *dialect.Dialect) (*model, error) {
+	mod := &model{version: d.Version}
+	enums := make(map[reflect.Type]*enum)
+
+	for _, m := range d.MessagesSnapshot() {
+		mde, err := msg.NewDecEncoder(m)
+		if err != nil {
+			return nil, err
+		}
+
+		me := &message{
+			name:     mde.Name(),
+			id:       m.GetId(),
+			crcExtra: mde.CRCExtra(),
+		}
+
+		rt := reflect.TypeOf(m).Elem()
+
+		for i := 0; i < rt.NumField(); i++ {
+			sf := rt.Field(i)
+			f := &field{name: sf.Name}
+
+			goType := sf.Type
+			if goType.Kind() == reflect.Array {
+				f.length = goType.Len()
+				goType = goType.Elem()
+			}
+
+			switch {
+			case sf.Tag.Get("mavenum") != "":
+				f.typ = sf.Tag.Get("mavenum")
+
+				_, ok := goType.MethodByName("MarshalText")
+				if goType.Kind() == reflect.Int && ok {
+					e, ok := enums[goType]
+					if !ok {
+						e = &enum{
+							name:   goType.Name(),
+							values: enumValues(goType, f.typ),
+						}
+						enums[goType] = e
+					}
+
+					// enums without known values are exported as integers
+					if len(e.values) > 0 {
+						f.enum = e
+					}
+				}
+
+			case goType.Kind() == reflect.String:
+				f.typ = "char"
+				f.length = 1
+				if tag := sf.Tag.Get("mavlen"); tag != "" {
+					f.length, err = strconv.Atoi(tag)
+					if err != nil {
+						return nil, fmt.Errorf("string has invalid length: %v", tag)
+					}
+				}
+
+			default:
+				var ok bool
+				f.typ, ok = wireTypeFromGo[goType.Name()]
+				if !ok {
+					return nil, fmt.Errorf("unsupported type: %s", goType.Name())
+				}
+			}
+
+			me.fields = append(me.fields, f)
+		}
+
+		mod.messages = append(mod.messages, me)
+	}
+
+	sort.Slice(mod.messages, func(i, j int) bool {
+		return mod.messages[i].id < mod.messages[j].id
+	})
+
+	for _, e := range enums {
+		if len(e.values) > 0 {
+			mod.enums = append(mod.enums, e)
+		}
+	}
+	sort.Slice(mod.enums, func(i, j int) bool {
+		return mod.enums[i].name < mod.enums[j].name
+	})
+
+	// messages and enums share the same namespace in all formats
+	names := make(map[string]struct{})
+	for _, m := range mod.messages {
+		names[m.name] = struct{}{}
+	}
+	for _, e := range mod.enums {
+		if _, ok := names[e.name]; ok {
+			return nil, fmt.Errorf("enum %s has the same name of a message", e.name)
+		}
+	}
+
+	return mod, nil
+}
+
+func checkName(name string) error {
+	if !reName.MatchString(name) {
+		return fmt.Errorf("invalid name: '%s'", name)
+	}
+	return nil
+}
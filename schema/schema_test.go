@@ -0,0 +1,171 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+type TEST_ENUM int
+
+const (
+	TEST_ENUM_A TEST_ENUM = 1
+	TEST_ENUM_B TEST_ENUM = 2
+)
+
+func (e TEST_ENUM) MarshalText() ([]byte, error) {
+	switch e {
+	case TEST_ENUM_A:
+		return []byte("TEST_ENUM_A"), nil
+	case TEST_ENUM_B:
+		return []byte("TEST_ENUM_B"), nil
+	}
+	return nil, errors.New("invalid value")
+}
+
+type MessageTest struct {
+	Mode   TEST_ENUM `mavenum:"uint8"`
+	Values [2]int16
+	Name   string `mavlen:"4"`
+	Big    uint64
+	Ext    float32 `mavext:"true"`
+}
+
+func (*MessageTest) GetId() uint32 {
+	return 5
+}
+
+var testDialect = &dialect.Dialect{Version: 2, Messages: []msg.Message{&MessageTest{}}}
+
+func TestJSONSchema(t *testing.T) {
+	byts, err := JSONSchema(testDialect, "test")
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "test",
+		"$comment": "dialect version 2",
+		"$defs": {
+			"TEST_ENUM": {"type": "string", "enum": ["TEST_ENUM_A", "TEST_ENUM_B"]},
+			"TEST": {
+				"title": "TEST",
+				"$comment": "message id 5, CRC extra 42",
+				"type": "object",
+				"properties": {
+					"Mode": {"$ref": "#/$defs/TEST_ENUM"},
+					"Values": {
+						"type": "array",
+						"items": {"type": "integer", "minimum": -32768, "maximum": 32767},
+						"minItems": 2,
+						"maxItems": 2
+					},
+					"Name": {"type": "string", "maxLength": 4},
+					"Big": {"type": "integer", "minimum": 0, "maximum": 18446744073709551615},
+					"Ext": {"type": "number"}
+				},
+				"required": ["Mode", "Values", "Name", "Big", "Ext"],
+				"additionalProperties": false
+			}
+		},
+		"anyOf": [{"$ref": "#/$defs/TEST"}]
+	}`, string(byts))
+
+	_, err = JSONSchema(testDialect, "invalid name")
+	require.EqualError(t, err, "invalid name: 'invalid name'")
+}
+
+func TestAvro(t *testing.T) {
+	byts, err := Avro(testDialect, "org.test")
+	require.NoError(t, err)
+	require.JSONEq(t, `[{
+		"type": "record",
+		"name": "TEST",
+		"namespace": "org.test",
+		"doc": "message id 5, CRC extra 42, dialect version 2",
+		"fields": [
+			{"name": "Mode", "type": {"type": "enum", "name": "TEST_ENUM", "symbols": ["TEST_ENUM_A", "TEST_ENUM_B"]}},
+			{"name": "Values", "type": {"type": "array", "items": "int"}},
+			{"name": "Name", "type": "string"},
+			{"name": "Big", "type": "long"},
+			{"name": "Ext", "type": "float"}
+		]
+	}]`, string(byts))
+}
+
+func TestProtobuf(t *testing.T) {
+	byts, err := Protobuf(testDialect, "test")
+	require.NoError(t, err)
+	require.Equal(t, "// dialect version 2\n"+
+		"\n"+
+		"syntax = \"proto3\";\n"+
+		"\n"+
+		"package test;\n"+
+		"\n"+
+		"enum TEST_ENUM {\n"+
+		"  TEST_ENUM_UNSPECIFIED = 0;\n"+
+		"  TEST_ENUM_A = 1;\n"+
+		"  TEST_ENUM_B = 2;\n"+
+		"}\n"+
+		"\n"+
+		"// message id 5, CRC extra 42\n"+
+		"message TEST {\n"+
+		"  TEST_ENUM Mode = 1;\n"+
+		"  repeated int32 Values = 2;\n"+
+		"  string Name = 3;\n"+
+		"  uint64 Big = 4;\n"+
+		"  float Ext = 5;\n"+
+		"}\n", string(byts))
+}
+
+func TestJSONSchemaMatchesEncoding(t *testing.T) {
+	byts, err := JSONSchema(common.Dialect, "common")
+	require.NoError(t, err)
+
+	var doc struct {
+		Defs map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+			Enum       []string               `json:"enum"`
+		} `json:"$defs"`
+	}
+	err = json.Unmarshal(byts, &doc)
+	require.NoError(t, err)
+
+	// the properties of a definition are the keys of an encoded message
+	encoded, err := json.Marshal(&common.MessageHeartbeat{
+		Type:         common.MAV_TYPE_QUADROTOR,
+		Autopilot:    common.MAV_AUTOPILOT_PX4,
+		BaseMode:     common.MAV_MODE_FLAG_SAFETY_ARMED,
+		SystemStatus: common.MAV_STATE_ACTIVE,
+	})
+	require.NoError(t, err)
+
+	var keys map[string]interface{}
+	err = json.Unmarshal(encoded, &keys)
+	require.NoError(t, err)
+
+	var exp, cur []string
+	for k := range keys {
+		exp = append(exp, k)
+	}
+	for k := range doc.Defs["HEARTBEAT"].Properties {
+		cur = append(cur, k)
+	}
+	sort.Strings(exp)
+	sort.Strings(cur)
+	require.Equal(t, exp, cur)
+
+	require.Contains(t, doc.Defs["MAV_TYPE"].Enum, "MAV_TYPE_QUADROTOR")
+	require.Contains(t, doc.Defs["MAV_SYS_STATUS_SENSOR"].Enum, "MAV_SYS_STATUS_OBSTACLE_AVOIDANCE")
+
+	_, err = Avro(common.Dialect, "common")
+	require.NoError(t, err)
+
+	_, err = Protobuf(common.Dialect, "common")
+	require.NoError(t, err)
+}
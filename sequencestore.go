@@ -0,0 +1,124 @@
+package gomavlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// SequenceState is the state of the outgoing frames of a channel.
+type SequenceState struct {
+	// the sequence id of the next outgoing frame.
+	SequenceId byte
+
+	// the timestamp of the last outgoing signature.
+	SignatureTimestamp uint64
+}
+
+// SequenceStore is the interface that must be implemented by stores that
+// persist the SequenceState of channels across restarts. States are identified
+// by a key that contains the system id, the component id and the channel label.
+// Methods are called by multiple routines in parallel.
+type SequenceStore interface {
+	// Load returns the state associated with a key, if present.
+	Load(key string) (SequenceState, bool)
+
+	// Save saves the state associated with a key.
+	Save(key string, state SequenceState) error
+}
+
+// FileSequenceStore is a SequenceStore that saves states into a JSON file.
+type FileSequenceStore struct {
+	path string
+
+	mutex  sync.Mutex
+	states map[string]SequenceState
+}
+
+// NewFileSequenceStore allocates a FileSequenceStore. The file is read if it
+// exists, and is created at the first save otherwise.
+func NewFileSequenceStore(path string) (*FileSequenceStore, error) {
+	s := &FileSequenceStore{
+		path:   path,
+		states: make(map[string]SequenceState),
+	}
+
+	byts, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(byts, &s.states)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %s", path, err)
+	}
+
+	return s, nil
+}
+
+// Load implements SequenceStore.
+func (s *FileSequenceStore) Load(key string) (SequenceState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st, ok := s.states[key]
+	return st, ok
+}
+
+// Save implements SequenceStore.
+func (s *FileSequenceStore) Save(key string, state SequenceState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.states[key] = state
+
+	byts, err := json.Marshal(s.states)
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file and rename it, in order not to leave
+	// a truncated file in case of crash
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, byts, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (ch *Channel) sequenceKey() string {
+	return fmt.Sprintf("%d:%d:%s", ch.n.conf.OutSystemId, ch.n.conf.OutComponentId, ch.label)
+}
+
+// loadSequence returns the state with which the channel starts.
+func (ch *Channel) loadSequence() SequenceState {
+	if ch.sequenceStore == nil {
+		return SequenceState{}
+	}
+
+	st, ok := ch.sequenceStore.Load(ch.sequenceKey())
+	if !ok {
+		return SequenceState{}
+	}
+
+	// signatures may have been emitted after the last save,
+	// in case the node was not closed properly
+	st.SignatureTimestamp += uint64(ch.n.conf.SequenceStorePeriod / (10 * time.Microsecond))
+	return st
+}
+
+// saveSequence saves the state of the channel. It is called by the writer routine.
+func (ch *Channel) saveSequence() {
+	// errors are ignored, like write errors
+	ch.sequenceStore.Save(ch.sequenceKey(), SequenceState{
+		SequenceId:         ch.transceiver.OutSequenceId(),
+		SignatureTimestamp: ch.transceiver.OutSignatureTimestamp(),
+	})
+}
@@ -0,0 +1,39 @@
+package gomavlib
+
+import (
+	"sort"
+)
+
+// SerialDevice is a USB serial device, i.e. an autopilot or a telemetry radio.
+type SerialDevice struct {
+	// the path of the device, that can be used in EndpointSerial.Address
+	// example: /dev/ttyACM0 or COM3
+	Path string
+	// the USB vendor id, or zero if unknown
+	VendorId uint16
+	// the USB product id, or zero if unknown
+	ProductId uint16
+	// the USB serial number, if known
+	SerialNumber string
+	// a description of the device, i.e. the USB product name, if known
+	Description string
+}
+
+// serialEnumerate lists serial devices. It is replaced in tests.
+var serialEnumerate = serialDevices
+
+// SerialDevices returns the USB serial devices that are currently plugged,
+// sorted by path.
+// Vendor ids, product ids, serial numbers and descriptions are available on
+// Linux only.
+func SerialDevices() ([]SerialDevice, error) {
+	ret, err := serialEnumerate()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Path < ret[j].Path
+	})
+	return ret, nil
+}
@@ -0,0 +1,33 @@
+// +build darwin
+
+package gomavlib
+
+import (
+	"path/filepath"
+)
+
+// call-out devices created by the drivers of USB serial adapters
+var serialDevicePatterns = []string{
+	"/dev/cu.usbmodem*",
+	"/dev/cu.usbserial*",
+	"/dev/cu.SLAB_USBtoUART*",
+	"/dev/cu.wchusbserial*",
+}
+
+// serialDevices lists the devices in /dev, since querying IOKit requires cgo.
+func serialDevices() ([]SerialDevice, error) {
+	var ret []SerialDevice
+
+	for _, pattern := range serialDevicePatterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			ret = append(ret, SerialDevice{Path: path})
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,62 @@
+// +build linux
+
+package gomavlib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const sysfsTtyDir = "/sys/class/tty"
+
+func readSysfs(dir string, name string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+// serialDevices lists the devices of the usbserial (ttyUSB) and cdc_acm
+// (ttyACM) drivers through sysfs, that is the source of udev.
+func serialDevices() ([]SerialDevice, error) {
+	entries, err := ioutil.ReadDir(sysfsTtyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []SerialDevice
+
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "ttyUSB") && !strings.HasPrefix(name, "ttyACM") {
+			continue
+		}
+
+		d := SerialDevice{Path: "/dev/" + name}
+
+		// the USB device is an ancestor of the tty device
+		dir, err := filepath.EvalSymlinks(filepath.Join(sysfsTtyDir, name, "device"))
+		if err == nil {
+			for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+				vid, err := strconv.ParseUint(readSysfs(dir, "idVendor"), 16, 16)
+				if err != nil {
+					continue
+				}
+
+				pid, _ := strconv.ParseUint(readSysfs(dir, "idProduct"), 16, 16)
+				d.VendorId = uint16(vid)
+				d.ProductId = uint16(pid)
+				d.SerialNumber = readSysfs(dir, "serial")
+				d.Description = readSysfs(dir, "product")
+				break
+			}
+		}
+
+		ret = append(ret, d)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!windows
+
+package gomavlib
+
+import (
+	"fmt"
+)
+
+func serialDevices() ([]SerialDevice, error) {
+	return nil, fmt.Errorf("listing serial devices is not supported on this platform")
+}
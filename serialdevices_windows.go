@@ -0,0 +1,80 @@
+// +build windows
+
+package gomavlib
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modadvapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procRegEnumValueW = modadvapi32.NewProc("RegEnumValueW")
+)
+
+const (
+	serialCommKey     = `HARDWARE\DEVICEMAP\SERIALCOMM`
+	errorNoMoreItems  = 259
+	serialCommMaxName = 256
+)
+
+// serialDevices lists the ports in the SERIALCOMM key of the registry, that
+// is filled by the drivers of the ports, excluding the ports of the
+// motherboard.
+func serialDevices() ([]SerialDevice, error) {
+	var key syscall.Handle
+	err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE,
+		syscall.StringToUTF16Ptr(serialCommKey), 0, syscall.KEY_READ, &key)
+	if err != nil {
+		// the key does not exist when there are no ports
+		if err == syscall.ERROR_FILE_NOT_FOUND {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.RegCloseKey(key)
+
+	var ret []SerialDevice
+
+	for i := 0; ; i++ {
+		var name [serialCommMaxName]uint16
+		nameLen := uint32(len(name))
+		var data [serialCommMaxName]uint16
+		dataLen := uint32(len(data) * 2)
+		var typ uint32
+
+		r, _, _ := procRegEnumValueW.Call(
+			uintptr(key),
+			uintptr(i),
+			uintptr(unsafe.Pointer(&name[0])),
+			uintptr(unsafe.Pointer(&nameLen)),
+			0,
+			uintptr(unsafe.Pointer(&typ)),
+			uintptr(unsafe.Pointer(&data[0])),
+			uintptr(unsafe.Pointer(&dataLen)))
+		if r == errorNoMoreItems {
+			break
+		}
+		if r != 0 {
+			return nil, syscall.Errno(r)
+		}
+
+		if typ != syscall.REG_SZ {
+			continue
+		}
+
+		// the value name is the name of the device, i.e. \Device\USBSER000
+		devName := syscall.UTF16ToString(name[:nameLen])
+		if strings.HasPrefix(devName, `\Device\Serial`) {
+			continue
+		}
+
+		ret = append(ret, SerialDevice{
+			Path:        syscall.UTF16ToString(data[:]),
+			Description: devName,
+		})
+	}
+
+	return ret, nil
+}
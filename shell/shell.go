@@ -0,0 +1,301 @@
+// Package shell implements a client of the MAVLink shell, that allows to
+// open an interactive session with the shell of an autopilot (i.e. the
+// NuttShell of PX4) and to run commands on it.
+//
+// Input and output of the shell are carried by SERIAL_CONTROL messages
+// addressed to the SERIAL_CONTROL_DEV_SHELL device. The autopilot sends
+// output only in response to messages of the client, therefore the client
+// polls it periodically.
+//
+// The node used by the client must use a dialect that contains the common
+// messages.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// maximum size of the data of a SERIAL_CONTROL message.
+	maxDataLength = 70
+)
+
+// Conf allows to configure a Shell.
+type Conf struct {
+	// the node used to communicate with the autopilot.
+	Node *gomavlib.Node
+
+	// the system id of the autopilot.
+	SystemId byte
+
+	// (optional) the component id of the autopilot.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the period between requests of pending output.
+	// It defaults to 100 milliseconds.
+	PollPeriod time.Duration
+
+	// (optional) the prompt of the shell, that is used by Exec to detect the
+	// end of the output of commands. It defaults to "nsh> ".
+	Prompt string
+}
+
+// Shell is a session with the shell of an autopilot. It implements
+// io.ReadWriter: written bytes are sent to the shell as they were typed,
+// while the output of the shell, including the echo of typed characters,
+// can be read.
+type Shell struct {
+	conf          Conf
+	removeHandler func()
+
+	// serializes writes
+	writeMutex sync.Mutex
+
+	mutex  sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+
+	received  chan struct{}
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Shell and opens a session. See Conf for the options.
+func New(conf Conf) (*Shell, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(&common.MessageSerialControl{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	if conf.PollPeriod < 0 {
+		return nil, fmt.Errorf("PollPeriod must be >= 0")
+	}
+	if conf.PollPeriod == 0 {
+		conf.PollPeriod = 100 * time.Millisecond
+	}
+
+	if conf.Prompt == "" {
+		conf.Prompt = "nsh> "
+	}
+
+	s := &Shell{
+		conf:      conf,
+		received:  make(chan struct{}, 1),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	s.removeHandler = conf.Node.AddFrameHandler(s.onEventFrame)
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close closes the session and releases the shell. Pending reads return
+// io.EOF. It must be called before closing the node.
+func (s *Shell) Close() {
+	s.removeHandler()
+	close(s.terminate)
+	<-s.done
+
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	// a message without the exclusive flag releases the shell
+	s.writeMutex.Lock()
+	s.write(0, nil)
+	s.writeMutex.Unlock()
+}
+
+func (s *Shell) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != s.conf.SystemId || evt.ComponentId() != s.conf.ComponentId {
+		return
+	}
+
+	if evt.Message().GetId() != (&common.MessageSerialControl{}).GetId() {
+		return
+	}
+
+	var m common.MessageSerialControl
+	if msg.Convert(&m, evt.Message()) != nil {
+		return
+	}
+
+	if m.Device != common.SERIAL_CONTROL_DEV_SHELL ||
+		(m.Flags&common.SERIAL_CONTROL_FLAG_REPLY) == 0 ||
+		m.Count == 0 {
+		return
+	}
+
+	count := int(m.Count)
+	if count > maxDataLength {
+		count = maxDataLength
+	}
+
+	s.mutex.Lock()
+	s.buf.Write(m.Data[:count])
+	s.mutex.Unlock()
+
+	// frame handlers must not block
+	select {
+	case s.received <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Shell) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.conf.PollPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.writeMutex.Lock()
+			s.write(common.SERIAL_CONTROL_FLAG_RESPOND|common.SERIAL_CONTROL_FLAG_EXCLUSIVE, nil)
+			s.writeMutex.Unlock()
+
+		case <-s.terminate:
+			return
+		}
+	}
+}
+
+func (s *Shell) write(flags common.SERIAL_CONTROL_FLAG, data []byte) {
+	m := &common.MessageSerialControl{
+		Device: common.SERIAL_CONTROL_DEV_SHELL,
+		Flags:  flags,
+		Count:  uint8(len(data)),
+	}
+	copy(m.Data[:], data)
+	s.conf.Node.WriteMessageAll(m)
+}
+
+// Write implements io.Writer. Bytes are sent to the shell as they were
+// typed; commands are run when a newline is written.
+func (s *Shell) Write(p []byte) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	select {
+	case <-s.terminate:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	for i := 0; i < len(p); i += maxDataLength {
+		end := i + maxDataLength
+		if end > len(p) {
+			end = len(p)
+		}
+		s.write(common.SERIAL_CONTROL_FLAG_RESPOND|common.SERIAL_CONTROL_FLAG_EXCLUSIVE, p[i:end])
+	}
+
+	return len(p), nil
+}
+
+// Read implements io.Reader. It blocks until some output of the shell is
+// available, and returns io.EOF when the session is closed.
+func (s *Shell) Read(p []byte) (int, error) {
+	return s.read(context.Background(), p)
+}
+
+func (s *Shell) read(ctx context.Context, p []byte) (int, error) {
+	for {
+		s.mutex.Lock()
+		if s.buf.Len() > 0 {
+			n, _ := s.buf.Read(p)
+			s.mutex.Unlock()
+			return n, nil
+		}
+		closed := s.closed
+		s.mutex.Unlock()
+
+		if closed {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-s.received:
+		case <-s.terminate:
+			// return the output received before the termination
+			<-s.done
+			s.mutex.Lock()
+			s.closed = true
+			s.mutex.Unlock()
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Exec runs a command and returns its output, that ends when the prompt is
+// printed again. The echo of the command and the prompt are removed, and
+// line endings are converted into "\n". Pending output is discarded before
+// running the command. Exec must not be called concurrently with Read.
+func (s *Shell) Exec(ctx context.Context, command string) (string, error) {
+	s.mutex.Lock()
+	s.buf.Reset()
+	s.mutex.Unlock()
+
+	_, err := s.Write([]byte(command + "\n"))
+	if err != nil {
+		return "", err
+	}
+
+	var out []byte
+	buf := make([]byte, 256)
+
+	for {
+		n, err := s.read(ctx, buf)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, buf[:n]...)
+
+		// the prompt is printed at the beginning of the last line
+		str := strings.ReplaceAll(string(out), "\r\n", "\n")
+		i := strings.LastIndex(str, "\n")
+		if i < 0 || !strings.HasPrefix(str[i+1:], s.conf.Prompt) {
+			continue
+		}
+		str = str[:i+1]
+
+		// remove the echo of the command
+		if strings.HasPrefix(str, command+"\n") {
+			str = str[len(command)+1:]
+		}
+
+		return str, nil
+	}
+}
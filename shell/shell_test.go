@@ -0,0 +1,227 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+// testAutopilot emulates the shell of PX4: typed characters are echoed,
+// and commands are answered when a newline is received. Output is sent
+// only in response to messages of the client.
+type testAutopilot struct {
+	node *gomavlib.Node
+
+	mutex     sync.Mutex
+	line      string
+	pending   []byte
+	exclusive bool
+	released  bool
+	polls     int
+}
+
+func newTestAutopilot(t *testing.T) (*gomavlib.Node, *testAutopilot) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	a := &testAutopilot{node: node}
+
+	testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+		if m, ok := fr.Message().(*common.MessageSerialControl); ok {
+			a.onMessage(m)
+		}
+	})
+
+	return gcs, a
+}
+
+func (a *testAutopilot) onMessage(m *common.MessageSerialControl) {
+	if m.Device != common.SERIAL_CONTROL_DEV_SHELL {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if (m.Flags & common.SERIAL_CONTROL_FLAG_EXCLUSIVE) == 0 {
+		a.exclusive = false
+		a.released = true
+		return
+	}
+	a.exclusive = true
+
+	if m.Count == 0 {
+		a.polls++
+	}
+
+	for _, b := range m.Data[:m.Count] {
+		if b != '\n' {
+			a.line += string(b)
+			a.pending = append(a.pending, b)
+			continue
+		}
+
+		a.pending = append(a.pending, []byte("\r\n")...)
+		switch a.line {
+		case "ver":
+			a.pending = append(a.pending, []byte("FW version: 1.13.0\r\nOS: NuttX\r\n")...)
+		case "long":
+			a.pending = append(a.pending, []byte(strings.Repeat("0123456789", 20)+"\r\n")...)
+		case "":
+		default:
+			a.pending = append(a.pending, []byte("nsh: "+a.line+": command not found\r\n")...)
+		}
+		a.pending = append(a.pending, []byte("nsh> ")...)
+		a.line = ""
+	}
+
+	if (m.Flags & common.SERIAL_CONTROL_FLAG_RESPOND) == 0 {
+		return
+	}
+
+	for len(a.pending) > 0 {
+		n := len(a.pending)
+		if n > maxDataLength {
+			n = maxDataLength
+		}
+		reply := &common.MessageSerialControl{
+			Device: common.SERIAL_CONTROL_DEV_SHELL,
+			Flags:  common.SERIAL_CONTROL_FLAG_REPLY,
+			Count:  uint8(n),
+		}
+		copy(reply.Data[:], a.pending[:n])
+		a.node.WriteMessageAll(reply)
+		a.pending = a.pending[n:]
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, a := newTestAutopilot(t)
+	defer gcs.Close()
+	defer a.node.Close()
+
+	_, err = New(Conf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+
+	_, err = New(Conf{Node: gcs, SystemId: 1, PollPeriod: -1})
+	require.EqualError(t, err, "PollPeriod must be >= 0")
+}
+
+func TestReadWrite(t *testing.T) {
+	gcs, a := newTestAutopilot(t)
+	defer gcs.Close()
+	defer a.node.Close()
+
+	s, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = io.WriteString(s, "ver\n")
+	require.NoError(t, err)
+
+	var out []byte
+	buf := make([]byte, 8)
+	for !strings.HasSuffix(string(out), "nsh> ") {
+		n, err := s.Read(buf)
+		require.NoError(t, err)
+		out = append(out, buf[:n]...)
+	}
+	require.Equal(t, "ver\r\nFW version: 1.13.0\r\nOS: NuttX\r\nnsh> ", string(out))
+}
+
+func TestExec(t *testing.T) {
+	gcs, a := newTestAutopilot(t)
+	defer gcs.Close()
+	defer a.node.Close()
+
+	s, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer s.Close()
+
+	out, err := s.Exec(context.Background(), "ver")
+	require.NoError(t, err)
+	require.Equal(t, "FW version: 1.13.0\nOS: NuttX\n", out)
+
+	out, err = s.Exec(context.Background(), "long")
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("0123456789", 20)+"\n", out)
+
+	out, err = s.Exec(context.Background(), "missing")
+	require.NoError(t, err)
+	require.Equal(t, "nsh: missing: command not found\n", out)
+}
+
+func TestPoll(t *testing.T) {
+	gcs, a := newTestAutopilot(t)
+	defer gcs.Close()
+	defer a.node.Close()
+
+	s, err := New(Conf{Node: gcs, SystemId: 1, PollPeriod: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// output that is produced asynchronously is sent with the next poll
+	a.mutex.Lock()
+	a.pending = []byte("INFO  [commander] armed\r\n")
+	a.mutex.Unlock()
+
+	buf := make([]byte, 64)
+	n, err := s.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "INFO  [commander] armed\r\n", string(buf[:n]))
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	require.True(t, a.polls >= 1)
+	require.True(t, a.exclusive)
+}
+
+func TestClose(t *testing.T) {
+	gcs, a := newTestAutopilot(t)
+	defer gcs.Close()
+	defer a.node.Close()
+
+	s, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = s.read(ctx, make([]byte, 8))
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	readDone := make(chan error)
+	go func() {
+		_, err := s.Read(make([]byte, 8))
+		readDone <- err
+	}()
+
+	s.Close()
+	require.Equal(t, io.EOF, <-readDone)
+
+	_, err = s.Write([]byte("ver\n"))
+	require.Equal(t, io.ErrClosedPipe, err)
+
+	// the release is received asynchronously
+	for i := 0; ; i++ {
+		a.mutex.Lock()
+		released := a.released
+		a.mutex.Unlock()
+		if released {
+			break
+		}
+		require.True(t, i < 50)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,381 @@
+package sink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const parquetMagic = "PAR1"
+
+// physical types of Parquet.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// converted types of Parquet.
+const (
+	parquetNone            = -1
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetUint8           = 11
+	parquetUint16          = 12
+	parquetUint32          = 13
+	parquetUint64          = 14
+	parquetInt8            = 15
+	parquetInt16           = 16
+)
+
+const (
+	parquetRequired     = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+var parquetTypes = map[columnType]struct {
+	physical  int32
+	converted int32
+}{
+	columnInt8:      {parquetInt32, parquetInt8},
+	columnUint8:     {parquetInt32, parquetUint8},
+	columnInt16:     {parquetInt32, parquetInt16},
+	columnUint16:    {parquetInt32, parquetUint16},
+	columnInt32:     {parquetInt32, parquetNone},
+	columnUint32:    {parquetInt32, parquetUint32},
+	columnInt64:     {parquetInt64, parquetNone},
+	columnUint64:    {parquetInt64, parquetUint64},
+	columnFloat:     {parquetFloat, parquetNone},
+	columnDouble:    {parquetDouble, parquetNone},
+	columnString:    {parquetByteArray, parquetUTF8},
+	columnTimestamp: {parquetInt64, parquetTimestampMicros},
+}
+
+// ParquetConf allows to configure a Parquet sink.
+type ParquetConf struct {
+	// the node from which messages are received.
+	Node *gomavlib.Node
+
+	// the directory in which files are written. It is created if it doesn't
+	// exist.
+	Dir string
+
+	// (optional) the messages that are written. If not provided, all the
+	// messages of the dialect are written.
+	Messages []msg.Message
+
+	// (optional) the period of the row groups in which messages are written.
+	// It defaults to 1 second.
+	FlushPeriod time.Duration
+}
+
+// Parquet writes the messages received by a node into Parquet files.
+// Each message type is written into a file named after the message, in
+// lowercase, i.e. heartbeat.parquet, that is created when the first message
+// of the type is received, and overwritten if it exists. Columns are not
+// compressed, and integers are annotated with their MAVLink type. The receive
+// time is a timestamp in microseconds.
+// Files are finalized, and therefore can be read, only after Close().
+type Parquet struct {
+	s *sink
+}
+
+// NewParquet allocates a Parquet sink. See ParquetConf for the options.
+func NewParquet(conf ParquetConf) (*Parquet, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Dir == "" {
+		return nil, fmt.Errorf("Dir not provided")
+	}
+
+	err := os.MkdirAll(conf.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSink(conf.Node, conf.Messages, conf.FlushPeriod, &parquetBackend{
+		dir:   conf.Dir,
+		files: make(map[*table]*parquetFile),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Parquet{s}, nil
+}
+
+// Close stops the sink, writes pending messages and finalizes the files.
+// It returns the first error returned by the file system. It must be called
+// before closing the node.
+func (p *Parquet) Close() error {
+	return p.s.close()
+}
+
+// Err returns the first error returned by the file system. After an error,
+// messages are not written anymore.
+func (p *Parquet) Err() error {
+	return p.s.getErr()
+}
+
+// MessagesWritten returns the number of messages that have been written.
+func (p *Parquet) MessagesWritten() uint64 {
+	written, _ := p.s.counters()
+	return written
+}
+
+// MessagesDropped returns the number of messages that have been dropped
+// since the file system was not fast enough.
+func (p *Parquet) MessagesDropped() uint64 {
+	_, dropped := p.s.counters()
+	return dropped
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+type parquetRowGroup struct {
+	numRows int64
+	chunks  []parquetChunk
+}
+
+// parquetFile is a Parquet file with a row group per flush and a page per
+// column chunk.
+type parquetFile struct {
+	table     *table
+	f         *os.File
+	offset    int64
+	numRows   int64
+	rowGroups []parquetRowGroup
+}
+
+func newParquetFile(path string, t *table) (*parquetFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Write([]byte(parquetMagic))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &parquetFile{
+		table:  t,
+		f:      f,
+		offset: int64(len(parquetMagic)),
+	}, nil
+}
+
+func (pf *parquetFile) write(byts []byte) error {
+	_, err := pf.f.Write(byts)
+	if err != nil {
+		return err
+	}
+	pf.offset += int64(len(byts))
+	return nil
+}
+
+// encodePlain encodes the values of a column with the PLAIN encoding.
+func encodePlain(ctype columnType, rows [][]interface{}, col int) []byte {
+	var buf []byte
+	var tmp [8]byte
+
+	for _, row := range rows {
+		switch v := row[col].(type) {
+		case int64:
+			if parquetTypes[ctype].physical == parquetInt32 {
+				binary.LittleEndian.PutUint32(tmp[:], uint32(v))
+				buf = append(buf, tmp[:4]...)
+			} else {
+				binary.LittleEndian.PutUint64(tmp[:], uint64(v))
+				buf = append(buf, tmp[:8]...)
+			}
+
+		case uint64:
+			binary.LittleEndian.PutUint64(tmp[:], v)
+			buf = append(buf, tmp[:8]...)
+
+		case float32:
+			binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(v))
+			buf = append(buf, tmp[:4]...)
+
+		case float64:
+			binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+			buf = append(buf, tmp[:8]...)
+
+		case string:
+			binary.LittleEndian.PutUint32(tmp[:], uint32(len(v)))
+			buf = append(buf, tmp[:4]...)
+			buf = append(buf, v...)
+		}
+	}
+
+	return buf
+}
+
+func (pf *parquetFile) writeRowGroup(rows [][]interface{}) error {
+	rg := parquetRowGroup{
+		numRows: int64(len(rows)),
+	}
+
+	for i, c := range pf.table.columns {
+		data := encodePlain(c.ctype, rows, i)
+
+		w := &thriftWriter{}
+		w.structBegin()
+		w.fieldI32(1, parquetDataPage)
+		w.fieldI32(2, int32(len(data)))
+		w.fieldI32(3, int32(len(data)))
+		w.fieldStructBegin(5)
+		w.fieldI32(1, int32(len(rows)))
+		w.fieldI32(2, parquetPlain)
+		w.fieldI32(3, parquetRLE)
+		w.fieldI32(4, parquetRLE)
+		w.structEnd()
+		w.structEnd()
+
+		chunk := parquetChunk{
+			offset: pf.offset,
+			size:   int64(len(w.buf) + len(data)),
+		}
+
+		err := pf.write(append(w.buf, data...))
+		if err != nil {
+			return err
+		}
+
+		rg.chunks = append(rg.chunks, chunk)
+	}
+
+	pf.numRows += rg.numRows
+	pf.rowGroups = append(pf.rowGroups, rg)
+	return nil
+}
+
+// close writes the footer and closes the file.
+func (pf *parquetFile) close() error {
+	defer pf.f.Close()
+
+	w := &thriftWriter{}
+	w.structBegin()
+	w.fieldI32(1, 1)
+
+	w.fieldList(2, thriftStruct, len(pf.table.columns)+1)
+	w.structBegin()
+	w.fieldBinary(4, "schema")
+	w.fieldI32(5, int32(len(pf.table.columns)))
+	w.structEnd()
+	for _, c := range pf.table.columns {
+		typ := parquetTypes[c.ctype]
+		w.structBegin()
+		w.fieldI32(1, typ.physical)
+		w.fieldI32(3, parquetRequired)
+		w.fieldBinary(4, c.name)
+		if typ.converted != parquetNone {
+			w.fieldI32(6, typ.converted)
+		}
+		w.structEnd()
+	}
+
+	w.fieldI64(3, pf.numRows)
+
+	w.fieldList(4, thriftStruct, len(pf.rowGroups))
+	for _, rg := range pf.rowGroups {
+		w.structBegin()
+
+		var totalSize int64
+		w.fieldList(1, thriftStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			c := pf.table.columns[i]
+			w.structBegin()
+			w.fieldI64(2, chunk.offset)
+			w.fieldStructBegin(3)
+			w.fieldI32(1, parquetTypes[c.ctype].physical)
+			w.fieldList(2, thriftI32, 1)
+			w.i32(parquetPlain)
+			w.fieldList(3, thriftBinary, 1)
+			w.binary(c.name)
+			w.fieldI32(4, parquetUncompressed)
+			w.fieldI64(5, rg.numRows)
+			w.fieldI64(6, chunk.size)
+			w.fieldI64(7, chunk.size)
+			w.fieldI64(9, chunk.offset)
+			w.structEnd()
+			w.structEnd()
+
+			totalSize += chunk.size
+		}
+
+		w.fieldI64(2, totalSize)
+		w.fieldI64(3, rg.numRows)
+		w.structEnd()
+	}
+
+	w.fieldBinary(6, "gomavlib")
+	w.structEnd()
+
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(w.buf)))
+	footer := append(append(w.buf, tmp[:]...), parquetMagic...)
+
+	err := pf.write(footer)
+	if err != nil {
+		return err
+	}
+
+	return pf.f.Sync()
+}
+
+type parquetBackend struct {
+	dir   string
+	files map[*table]*parquetFile
+	order []*parquetFile
+}
+
+func (b *parquetBackend) createTable(t *table) error {
+	pf, err := newParquetFile(filepath.Join(b.dir, t.name+".parquet"), t)
+	if err != nil {
+		return err
+	}
+
+	b.files[t] = pf
+	b.order = append(b.order, pf)
+	return nil
+}
+
+func (b *parquetBackend) flush(batches []*batch) error {
+	for _, ba := range batches {
+		err := b.files[ba.table].writeRowGroup(ba.rows)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *parquetBackend) close() error {
+	var ret error
+	for _, pf := range b.order {
+		err := pf.close()
+		if err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"encoding/binary"
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// readParquet returns the metadata of a file and the content of the first
+// page of each column chunk.
+func readParquet(t *testing.T, path string) (map[int16]interface{}, [][][]byte) {
+	byts, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	require.Equal(t, "PAR1", string(byts[:4]))
+	require.Equal(t, "PAR1", string(byts[len(byts)-4:]))
+
+	size := binary.LittleEndian.Uint32(byts[len(byts)-8:])
+	r := &thriftReader{byts[len(byts)-8-int(size) : len(byts)-8]}
+	meta := r.value(thriftStruct).(map[int16]interface{})
+	require.Equal(t, 0, len(r.buf))
+
+	var pages [][][]byte
+	for _, rg := range meta[4].([]interface{}) {
+		var rgPages [][]byte
+		for _, cc := range rg.(map[int16]interface{})[1].([]interface{}) {
+			cmeta := cc.(map[int16]interface{})[3].(map[int16]interface{})
+			offset := cmeta[9].(int64)
+
+			r := &thriftReader{byts[offset:]}
+			header := r.value(thriftStruct).(map[int16]interface{})
+			require.Equal(t, header[2], header[3])
+			require.Equal(t, cmeta[6].(int64), int64(len(byts[offset:])-len(r.buf))+header[2].(int64))
+
+			rgPages = append(rgPages, r.buf[:header[2].(int64)])
+		}
+		pages = append(pages, rgPages)
+	}
+
+	return meta, pages
+}
+
+func TestParquet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib-sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+	testnode.DiscardEvents(vehicle)
+
+	p, err := NewParquet(ParquetConf{
+		Node:        gcs,
+		Dir:         filepath.Join(dir, "flight"),
+		Messages:    []msg.Message{&common.MessageParamValue{}},
+		FlushPeriod: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	vehicle.WriteMessageAll(&common.MessageParamValue{
+		ParamId:    "RATE_RLL_P",
+		ParamValue: 0.5,
+		ParamType:  common.MAV_PARAM_TYPE_REAL32,
+		ParamCount: 2,
+	})
+
+	for p.MessagesWritten() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	vehicle.WriteMessageAll(&common.MessageParamValue{
+		ParamId:    "RATE_PIT_P",
+		ParamValue: 0.25,
+		ParamType:  common.MAV_PARAM_TYPE_REAL32,
+		ParamCount: 2,
+		ParamIndex: 1,
+	})
+
+	for p.MessagesWritten() == 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = p.Close()
+	require.NoError(t, err)
+
+	meta, pages := readParquet(t, filepath.Join(dir, "flight", "param_value.parquet"))
+
+	require.Equal(t, int64(1), meta[1])
+	require.Equal(t, int64(2), meta[3])
+
+	schema := meta[2].([]interface{})
+	require.Equal(t, map[int16]interface{}{4: "schema", 5: int64(9)}, schema[0])
+	require.Equal(t, map[int16]interface{}{1: int64(parquetInt64), 3: int64(0),
+		4: "_receive_time", 6: int64(parquetTimestampMicros)}, schema[1])
+	require.Equal(t, map[int16]interface{}{1: int64(parquetByteArray), 3: int64(0),
+		4: "param_id", 6: int64(parquetUTF8)}, schema[5])
+	require.Equal(t, map[int16]interface{}{1: int64(parquetFloat), 3: int64(0),
+		4: "param_value"}, schema[6])
+	require.Equal(t, map[int16]interface{}{1: int64(parquetInt32), 3: int64(0),
+		4: "param_type", 6: int64(parquetUint8)}, schema[7])
+
+	// a row group per flush
+	require.Equal(t, 2, len(pages))
+
+	for i, name := range []string{"RATE_RLL_P", "RATE_PIT_P"} {
+		require.Equal(t, 9, len(pages[i]))
+
+		ts := int64(binary.LittleEndian.Uint64(pages[i][0]))
+		require.WithinDuration(t, time.Now(), time.Unix(0, ts*int64(time.Microsecond)), 5*time.Second)
+
+		require.Equal(t, []byte{1, 0, 0, 0}, pages[i][1])
+		require.Equal(t, append([]byte{6, 0, 0, 0}, "custom"...), pages[i][3])
+		require.Equal(t, append([]byte{10, 0, 0, 0}, name...), pages[i][4])
+		require.Equal(t, []byte{9, 0, 0, 0}, pages[i][6])
+		require.Equal(t, []byte{byte(i), 0, 0, 0}, pages[i][8])
+	}
+
+	require.Equal(t, float32(0.25), math.Float32frombits(binary.LittleEndian.Uint32(pages[1][5])))
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+// TestParquetGolden writes rows with a fixed receive time and compares the
+// file with a golden file, that has been checked against the Parquet format
+// specification. Run with -update to write the golden file again after an
+// intentional change of the format.
+func TestParquetGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib-sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tab, err := newTable(&common.MessageParamValue{})
+	require.NoError(t, err)
+
+	b := &parquetBackend{
+		dir:   dir,
+		files: make(map[*table]*parquetFile),
+	}
+
+	err = b.createTable(tab)
+	require.NoError(t, err)
+
+	// 2021-01-01 00:00:00 UTC
+	ts := int64(1609459200000000)
+
+	err = b.flush([]*batch{{
+		table: tab,
+		rows: [][]interface{}{
+			{ts, int64(1), int64(1), "custom", "RATE_RLL_P", float32(0.5), int64(9), int64(2), int64(0)},
+			{ts + 1000, int64(1), int64(1), "custom", "RATE_PIT_P", float32(0.25), int64(9), int64(2), int64(1)},
+		},
+	}})
+	require.NoError(t, err)
+
+	err = b.flush([]*batch{{
+		table: tab,
+		rows: [][]interface{}{
+			{ts + 2000, int64(1), int64(1), "custom", "RATE_RLL_P", float32(0.75), int64(9), int64(2), int64(0)},
+		},
+	}})
+	require.NoError(t, err)
+
+	err = b.close()
+	require.NoError(t, err)
+
+	byts, err := ioutil.ReadFile(filepath.Join(dir, "param_value.parquet"))
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", "param_value.parquet")
+
+	if *updateGolden {
+		err = ioutil.WriteFile(golden, byts, 0644)
+		require.NoError(t, err)
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	require.NoError(t, err)
+	require.Equal(t, expected, byts)
+
+	meta, pages := readParquet(t, golden)
+	require.Equal(t, int64(3), meta[3])
+	require.Equal(t, 2, len(pages))
+	require.Equal(t, append(append([]byte{10, 0, 0, 0}, "RATE_RLL_P"...),
+		append([]byte{10, 0, 0, 0}, "RATE_PIT_P"...)...), pages[0][4])
+	require.Equal(t, float32(0.75), math.Float32frombits(binary.LittleEndian.Uint32(pages[1][5])))
+}
@@ -0,0 +1,231 @@
+package sink
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// columnType is the type of a column.
+type columnType int
+
+const (
+	columnInt8 columnType = iota
+	columnUint8
+	columnInt16
+	columnUint16
+	columnInt32
+	columnUint32
+	columnInt64
+	columnUint64
+	columnFloat
+	columnDouble
+	columnString
+
+	// the receive time, in microseconds since the Unix epoch
+	columnTimestamp
+)
+
+var columnTypeFromGo = map[string]columnType{
+	"int8":    columnInt8,
+	"uint8":   columnUint8,
+	"int16":   columnInt16,
+	"uint16":  columnUint16,
+	"int32":   columnInt32,
+	"uint32":  columnUint32,
+	"int64":   columnInt64,
+	"uint64":  columnUint64,
+	"float32": columnFloat,
+	"float64": columnDouble,
+	"string":  columnString,
+}
+
+// the columns that precede the fields of the message.
+const (
+	columnReceiveTime = "_receive_time"
+	columnSystemId    = "_system_id"
+	columnComponentId = "_component_id"
+	columnChannel     = "_channel"
+)
+
+// column is a column of a table, that contains a field of a message or an
+// element of an array field.
+type column struct {
+	name  string
+	ctype columnType
+
+	// the index of the struct field, or -1 for the columns that precede fields
+	field int
+	// the index of the array element, or -1
+	element int
+}
+
+// table is the table of a message type. Its columns are the receive time, the
+// system id, the component id, the channel and the fields of the message,
+// with arrays split into a column per element.
+type table struct {
+	name    string
+	columns []column
+}
+
+func newTable(m msg.Message) (*table, error) {
+	mde, err := msg.NewDecEncoder(m)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &table{
+		name: strings.ToLower(mde.Name()),
+		columns: []column{
+			{columnReceiveTime, columnTimestamp, -1, -1},
+			{columnSystemId, columnUint8, -1, -1},
+			{columnComponentId, columnUint8, -1, -1},
+			{columnChannel, columnString, -1, -1},
+		},
+	}
+
+	rt := reflect.TypeOf(m).Elem()
+
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name := msg.FieldName(f)
+
+		goType := f.Type
+		length := 0
+		if goType.Kind() == reflect.Array {
+			length = goType.Len()
+			goType = goType.Elem()
+		}
+
+		// enums are stored with their wire type
+		typeName := goType.Kind().String()
+		if tag := f.Tag.Get("mavenum"); tag != "" {
+			typeName = tag
+		}
+
+		ctype, ok := columnTypeFromGo[typeName]
+		if !ok {
+			return nil, fmt.Errorf("unsupported type: %s", typeName)
+		}
+
+		if length == 0 {
+			t.columns = append(t.columns, column{name, ctype, i, -1})
+			continue
+		}
+
+		for j := 0; j < length; j++ {
+			t.columns = append(t.columns, column{fmt.Sprintf("%s_%d", name, j), ctype, i, j})
+		}
+	}
+
+	return t, nil
+}
+
+// row returns the values of a frame, in the order of columns. Integers are
+// returned as int64, except uint64, floats as float32 or float64, and
+// strings as string.
+func (t *table) row(evt *gomavlib.EventFrame) []interface{} {
+	rv := reflect.ValueOf(evt.Message()).Elem()
+	ret := make([]interface{}, len(t.columns))
+
+	for i, c := range t.columns {
+		if c.field < 0 {
+			switch c.name {
+			case columnReceiveTime:
+				ret[i] = evt.ReceiveTime.UnixNano() / int64(time.Microsecond)
+			case columnSystemId:
+				ret[i] = int64(evt.SystemId())
+			case columnComponentId:
+				ret[i] = int64(evt.ComponentId())
+			case columnChannel:
+				ret[i] = evt.Channel.String()
+			}
+			continue
+		}
+
+		v := rv.Field(c.field)
+		if c.element >= 0 {
+			v = v.Index(c.element)
+		}
+
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if c.ctype == columnUint64 {
+				ret[i] = uint64(v.Int())
+			} else {
+				ret[i] = v.Int()
+			}
+
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			ret[i] = int64(v.Uint())
+
+		case reflect.Uint64:
+			ret[i] = v.Uint()
+
+		case reflect.Float32:
+			ret[i] = float32(v.Float())
+
+		case reflect.Float64:
+			ret[i] = v.Float()
+
+		case reflect.String:
+			ret[i] = v.String()
+		}
+	}
+
+	return ret
+}
+
+// tableSet contains the tables of the message types that have been received.
+type tableSet struct {
+	filter map[reflect.Type]struct{}
+	tables map[reflect.Type]*table
+}
+
+func newTableSet(messages []msg.Message) *tableSet {
+	s := &tableSet{
+		tables: make(map[reflect.Type]*table),
+	}
+
+	if len(messages) != 0 {
+		s.filter = make(map[reflect.Type]struct{})
+		for _, m := range messages {
+			s.filter[reflect.TypeOf(m)] = struct{}{}
+		}
+	}
+
+	return s
+}
+
+// get returns the table of a message, or nil if the message must not be
+// written.
+func (s *tableSet) get(m msg.Message) (*table, bool, error) {
+	typ := reflect.TypeOf(m)
+
+	// messages that can't be decoded are discarded
+	if _, ok := m.(*msg.MessageRaw); ok {
+		return nil, false, nil
+	}
+
+	if s.filter != nil {
+		if _, ok := s.filter[typ]; !ok {
+			return nil, false, nil
+		}
+	}
+
+	if t, ok := s.tables[typ]; ok {
+		return t, false, nil
+	}
+
+	t, err := newTable(m)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.tables[typ] = t
+	return t, true, nil
+}
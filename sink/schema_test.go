@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func TestTable(t *testing.T) {
+	tab, err := newTable(&common.MessageHilActuatorControls{})
+	require.NoError(t, err)
+	require.Equal(t, "hil_actuator_controls", tab.name)
+
+	var names []string
+	for _, c := range tab.columns {
+		names = append(names, c.name)
+	}
+
+	expected := []string{"_receive_time", "_system_id", "_component_id", "_channel", "time_usec"}
+	for i := 0; i < 16; i++ {
+		expected = append(expected, fmt.Sprintf("controls_%d", i))
+	}
+	expected = append(expected, "mode", "flags")
+	require.Equal(t, expected, names)
+
+	require.Equal(t, columnTimestamp, tab.columns[0].ctype)
+	require.Equal(t, columnString, tab.columns[3].ctype)
+	require.Equal(t, columnUint64, tab.columns[4].ctype)
+	require.Equal(t, column{"controls_3", columnFloat, 1, 3}, tab.columns[8])
+	require.Equal(t, column{"mode", columnUint8, 2, -1}, tab.columns[21])
+}
+
+func TestTableSet(t *testing.T) {
+	s := newTableSet([]msg.Message{&common.MessageHeartbeat{}})
+
+	tab, created, err := s.get(&common.MessageHeartbeat{})
+	require.NoError(t, err)
+	require.Equal(t, true, created)
+	require.Equal(t, "heartbeat", tab.name)
+
+	tab2, created, err := s.get(&common.MessageHeartbeat{})
+	require.NoError(t, err)
+	require.Equal(t, false, created)
+	require.Equal(t, tab, tab2)
+
+	// not in the filter
+	tab, _, err = s.get(&common.MessageAttitude{})
+	require.NoError(t, err)
+	require.Nil(t, tab)
+
+	// not decoded
+	tab, _, err = s.get(&msg.MessageRaw{Id: 0})
+	require.NoError(t, err)
+	require.Nil(t, tab)
+}
@@ -0,0 +1,244 @@
+// Package sink implements writers that store the messages received by a node
+// into SQL databases and Parquet files, in order to analyze flights with SQL
+// or dataframe libraries without a conversion step.
+//
+// Each message type is stored in its own table, whose columns are the receive
+// time, the system id, the component id, the channel and the fields of the
+// message, as they are named in definition files. Array fields are split
+// into a column per element, except character arrays, that are stored as
+// strings. Enums are stored as integers.
+//
+// The node to which the sinks are attached must use a dialect, since only
+// decoded messages can be stored.
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// messages can be received at high rates, therefore the queue is bigger
+	// than the one of other helpers
+	eventQueueSize = 1024
+
+	defaultFlushPeriod = 1 * time.Second
+)
+
+// batch contains the rows of a table that have been received since the
+// last flush.
+type batch struct {
+	table *table
+	rows  [][]interface{}
+}
+
+// backend is the storage used by a sink.
+type backend interface {
+	// createTable is called when the first message of a type is received.
+	createTable(t *table) error
+
+	// flush is called periodically with the pending rows, grouped by table.
+	flush(batches []*batch) error
+
+	// close is called after the last flush.
+	close() error
+}
+
+// sink writes the messages received by a node into a backend.
+type sink struct {
+	backend       backend
+	flushPeriod   time.Duration
+	removeHandler func()
+
+	// accessed by run() only
+	tables  *tableSet
+	batches []*batch
+	pending map[*table]*batch
+
+	mutex   sync.Mutex
+	err     error
+	written uint64
+	dropped uint64
+
+	events    chan *gomavlib.EventFrame
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+func newSink(node *gomavlib.Node, messages []msg.Message,
+	flushPeriod time.Duration, backend backend) (*sink, error) {
+	if node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	if len(messages) != 0 {
+		err := node.Conf().Dialect.CheckMessages(messages...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flushPeriod == 0 {
+		flushPeriod = defaultFlushPeriod
+	}
+
+	s := &sink{
+		backend:     backend,
+		flushPeriod: flushPeriod,
+		tables:      newTableSet(messages),
+		pending:     make(map[*table]*batch),
+		events:      make(chan *gomavlib.EventFrame, eventQueueSize),
+		terminate:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	s.removeHandler = node.AddFrameHandler(s.onEventFrame)
+
+	go s.run()
+
+	return s, nil
+}
+
+func (s *sink) close() error {
+	s.removeHandler()
+	close(s.terminate)
+	<-s.done
+	return s.getErr()
+}
+
+func (s *sink) getErr() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+func (s *sink) setErr(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *sink) counters() (uint64, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.written, s.dropped
+}
+
+func (s *sink) onEventFrame(evt *gomavlib.EventFrame) {
+	// frame handlers must not block; messages are dropped when the queue is
+	// full
+	select {
+	case s.events <- evt:
+	default:
+		s.mutex.Lock()
+		s.dropped++
+		s.mutex.Unlock()
+	}
+}
+
+func (s *sink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt := <-s.events:
+			s.push(evt)
+
+		case <-ticker.C:
+			s.flush()
+
+		case <-s.terminate:
+			s.drain()
+			s.flush()
+
+			err := s.backend.close()
+			if err != nil {
+				s.setErr(err)
+			}
+			return
+		}
+	}
+}
+
+// drain writes the messages that are still in the queue.
+func (s *sink) drain() {
+	for {
+		select {
+		case evt := <-s.events:
+			s.push(evt)
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *sink) push(evt *gomavlib.EventFrame) {
+	if s.getErr() != nil {
+		return
+	}
+
+	t, created, err := s.tables.get(evt.Message())
+	if err != nil {
+		s.setErr(err)
+		return
+	}
+	if t == nil {
+		return
+	}
+
+	if created {
+		err := s.backend.createTable(t)
+		if err != nil {
+			s.setErr(err)
+			return
+		}
+	}
+
+	b, ok := s.pending[t]
+	if !ok {
+		b = &batch{table: t}
+		s.pending[t] = b
+		s.batches = append(s.batches, b)
+	}
+
+	b.rows = append(b.rows, t.row(evt))
+}
+
+func (s *sink) flush() {
+	if len(s.batches) == 0 {
+		return
+	}
+
+	batches := s.batches
+	s.batches = nil
+	s.pending = make(map[*table]*batch)
+
+	if s.getErr() != nil {
+		return
+	}
+
+	err := s.backend.flush(batches)
+	if err != nil {
+		s.setErr(err)
+		return
+	}
+
+	n := 0
+	for _, b := range batches {
+		n += len(b.rows)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.written += uint64(n)
+}
@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+type testBackend struct {
+	mutex   sync.Mutex
+	tables  []string
+	rows    int
+	flushes int
+	err     error
+}
+
+func (b *testBackend) createTable(t *table) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tables = append(b.tables, t.name)
+	return nil
+}
+
+func (b *testBackend) flush(batches []*batch) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.flushes++
+	if b.err != nil {
+		return b.err
+	}
+	for _, ba := range batches {
+		b.rows += len(ba.rows)
+	}
+	return nil
+}
+
+func (b *testBackend) close() error {
+	return nil
+}
+
+func (b *testBackend) getFlushes() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.flushes
+}
+
+func TestSinkError(t *testing.T) {
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+	testnode.DiscardEvents(vehicle)
+
+	b := &testBackend{err: fmt.Errorf("disk full")}
+	s, err := newSink(gcs, nil, 50*time.Millisecond, b)
+	require.NoError(t, err)
+
+	vehicle.WriteMessageAll(&common.MessageHeartbeat{})
+
+	for b.getFlushes() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.EqualError(t, s.getErr(), "disk full")
+
+	// after an error, messages are not written anymore
+	vehicle.WriteMessageAll(&common.MessageHeartbeat{})
+	time.Sleep(200 * time.Millisecond)
+
+	require.EqualError(t, s.close(), "disk full")
+	require.Equal(t, 1, b.getFlushes())
+	require.Equal(t, []string{"heartbeat"}, b.tables)
+	written, _ := s.counters()
+	require.Equal(t, uint64(0), written)
+}
@@ -0,0 +1,181 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// SQLConf allows to configure a SQL sink.
+type SQLConf struct {
+	// the node from which messages are received.
+	Node *gomavlib.Node
+
+	// the database in which messages are written. The database must support
+	// the ? placeholder, like SQLite and MySQL. The driver is chosen by the
+	// user, i.e. by importing github.com/mattn/go-sqlite3 and calling
+	// sql.Open("sqlite3", "flight.db").
+	DB *sql.DB
+
+	// (optional) the messages that are written. If not provided, all the
+	// messages of the dialect are written.
+	Messages []msg.Message
+
+	// (optional) the period of the transactions in which messages are
+	// written. It defaults to 1 second.
+	FlushPeriod time.Duration
+}
+
+// SQL writes the messages received by a node into a SQL database.
+// Tables are named after messages, in lowercase, i.e. heartbeat, and are
+// created when the first message of each type is received, if they don't
+// exist. Integers are stored as INTEGER, with uint64 values stored with
+// the bits of int64, floats as REAL and strings as TEXT. The receive time is
+// stored in microseconds since the Unix epoch.
+type SQL struct {
+	s *sink
+}
+
+// NewSQL allocates a SQL sink. See SQLConf for the options.
+func NewSQL(conf SQLConf) (*SQL, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.DB == nil {
+		return nil, fmt.Errorf("DB not provided")
+	}
+
+	s, err := newSink(conf.Node, conf.Messages, conf.FlushPeriod, &sqlBackend{
+		db:      conf.DB,
+		inserts: make(map[*table]string),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQL{s}, nil
+}
+
+// Close stops the sink and writes pending messages. It returns the first
+// error returned by the database. It must be called before closing the node.
+func (q *SQL) Close() error {
+	return q.s.close()
+}
+
+// Err returns the first error returned by the database. After an error,
+// messages are not written anymore.
+func (q *SQL) Err() error {
+	return q.s.getErr()
+}
+
+// MessagesWritten returns the number of messages that have been written.
+func (q *SQL) MessagesWritten() uint64 {
+	written, _ := q.s.counters()
+	return written
+}
+
+// MessagesDropped returns the number of messages that have been dropped
+// since the database was not fast enough.
+func (q *SQL) MessagesDropped() uint64 {
+	_, dropped := q.s.counters()
+	return dropped
+}
+
+func sqlQuote(name string) string {
+	return "\"" + name + "\""
+}
+
+var sqlTypes = map[columnType]string{
+	columnInt8:      "INTEGER",
+	columnUint8:     "INTEGER",
+	columnInt16:     "INTEGER",
+	columnUint16:    "INTEGER",
+	columnInt32:     "INTEGER",
+	columnUint32:    "INTEGER",
+	columnInt64:     "INTEGER",
+	columnUint64:    "INTEGER",
+	columnFloat:     "REAL",
+	columnDouble:    "REAL",
+	columnString:    "TEXT",
+	columnTimestamp: "INTEGER",
+}
+
+type sqlBackend struct {
+	db      *sql.DB
+	inserts map[*table]string
+}
+
+func (b *sqlBackend) createTable(t *table) error {
+	defs := make([]string, len(t.columns))
+	names := make([]string, len(t.columns))
+	placeholders := make([]string, len(t.columns))
+
+	for i, c := range t.columns {
+		defs[i] = sqlQuote(c.name) + " " + sqlTypes[c.ctype]
+		names[i] = sqlQuote(c.name)
+		placeholders[i] = "?"
+	}
+
+	_, err := b.db.Exec("CREATE TABLE IF NOT EXISTS " + sqlQuote(t.name) +
+		" (" + strings.Join(defs, ", ") + ")")
+	if err != nil {
+		return err
+	}
+
+	b.inserts[t] = "INSERT INTO " + sqlQuote(t.name) +
+		" (" + strings.Join(names, ", ") + ") VALUES (" +
+		strings.Join(placeholders, ", ") + ")"
+	return nil
+}
+
+func (b *sqlBackend) flush(batches []*batch) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = b.insert(tx, batches)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (b *sqlBackend) insert(tx *sql.Tx, batches []*batch) error {
+	for _, ba := range batches {
+		stmt, err := tx.Prepare(b.inserts[ba.table])
+		if err != nil {
+			return err
+		}
+
+		for _, row := range ba.rows {
+			// database/sql doesn't support uint64 values with the high bit set
+			for i, v := range row {
+				if u, ok := v.(uint64); ok {
+					row[i] = int64(u)
+				}
+			}
+
+			_, err := stmt.Exec(row...)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+
+		stmt.Close()
+	}
+
+	return nil
+}
+
+func (b *sqlBackend) close() error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package sink
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// testDriver is a database/sql driver that records statements.
+type testDriver struct {
+	mutex      sync.Mutex
+	statements []string
+	commits    int
+}
+
+var testDB = &testDriver{}
+
+func init() {
+	sql.Register("sinktest", testDB)
+}
+
+func (d *testDriver) reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.statements = nil
+	d.commits = 0
+}
+
+func (d *testDriver) get() ([]string, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.statements...), d.commits
+}
+
+func (d *testDriver) Open(name string) (driver.Conn, error) {
+	return &testConn{d}, nil
+}
+
+type testConn struct {
+	d *testDriver
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return &testStmt{c.d, query}, nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *testConn) Commit() error {
+	c.d.mutex.Lock()
+	defer c.d.mutex.Unlock()
+	c.d.commits++
+	return nil
+}
+
+func (c *testConn) Rollback() error {
+	return nil
+}
+
+type testStmt struct {
+	d     *testDriver
+	query string
+}
+
+func (s *testStmt) Close() error {
+	return nil
+}
+
+func (s *testStmt) NumInput() int {
+	return -1
+}
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mutex.Lock()
+	defer s.d.mutex.Unlock()
+	stmt := s.query
+	if len(args) != 0 {
+		stmt += fmt.Sprint(args)
+	}
+	s.d.statements = append(s.d.statements, stmt)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestSQLErrors(t *testing.T) {
+	db, err := sql.Open("sinktest", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = NewSQL(SQLConf{DB: db})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+	testnode.DiscardEvents(vehicle)
+
+	_, err = NewSQL(SQLConf{Node: gcs})
+	require.EqualError(t, err, "DB not provided")
+}
+
+func TestSQL(t *testing.T) {
+	testDB.reset()
+
+	db, err := sql.Open("sinktest", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+	testnode.DiscardEvents(vehicle)
+
+	s, err := NewSQL(SQLConf{
+		Node:     gcs,
+		DB:       db,
+		Messages: []msg.Message{&common.MessageParamValue{}, &common.MessageHilActuatorControls{}},
+		// messages are written by Close()
+		FlushPeriod: 1 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	// not in Messages
+	vehicle.WriteMessageAll(&common.MessageHeartbeat{})
+
+	vehicle.WriteMessageAll(&common.MessageParamValue{
+		ParamId:    "RATE_RLL_P",
+		ParamValue: 0.5,
+		ParamType:  common.MAV_PARAM_TYPE_REAL32,
+		ParamCount: 2,
+	})
+
+	vehicle.WriteMessageAll(&common.MessageParamValue{
+		ParamId:    "RATE_PIT_P",
+		ParamValue: 0.25,
+		ParamType:  common.MAV_PARAM_TYPE_REAL32,
+		ParamCount: 2,
+		ParamIndex: 1,
+	})
+
+	vehicle.WriteMessageAll(&common.MessageHilActuatorControls{
+		Flags: 1 << 63,
+	})
+
+	// tables are created when the first message of each type is received
+	for {
+		statements, _ := testDB.get()
+		if len(statements) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = s.Close()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), s.MessagesWritten())
+	require.Equal(t, uint64(0), s.MessagesDropped())
+
+	statements, commits := testDB.get()
+	require.Equal(t, 1, commits)
+	require.Equal(t, 5, len(statements))
+
+	require.Equal(t, "CREATE TABLE IF NOT EXISTS \"param_value\" ("+
+		"\"_receive_time\" INTEGER, \"_system_id\" INTEGER, \"_component_id\" INTEGER, "+
+		"\"_channel\" TEXT, \"param_id\" TEXT, \"param_value\" REAL, \"param_type\" INTEGER, "+
+		"\"param_count\" INTEGER, \"param_index\" INTEGER)", statements[0])
+
+	require.Regexp(t, "^CREATE TABLE IF NOT EXISTS \"hil_actuator_controls\" "+
+		"\\(.*\"controls_15\" REAL, \"mode\" INTEGER, \"flags\" INTEGER\\)$", statements[1])
+
+	insert := "INSERT INTO \"param_value\" (\"_receive_time\", \"_system_id\", \"_component_id\", " +
+		"\"_channel\", \"param_id\", \"param_value\", \"param_type\", \"param_count\", \"param_index\") " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	require.Regexp(t, "^"+regexp.QuoteMeta(insert)+"\\[[0-9]+ 1 1 custom RATE_RLL_P 0.5 9 2 0\\]$", statements[2])
+	require.Regexp(t, "^"+regexp.QuoteMeta(insert)+"\\[[0-9]+ 1 1 custom RATE_PIT_P 0.25 9 2 1\\]$", statements[3])
+
+	// uint64 values are stored with the bits of int64
+	require.Regexp(t, "^INSERT INTO \"hil_actuator_controls\" .*\\[.* -9223372036854775808\\]$", statements[4])
+}
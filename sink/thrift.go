@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"encoding/binary"
+)
+
+// types of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, that is used
+// by the metadata of Parquet files.
+type thriftWriter struct {
+	buf []byte
+
+	// the id of the last field of each open struct
+	lastIds []int16
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	w.buf = append(w.buf, make([]byte, binary.MaxVarintLen64)...)
+	n := binary.PutUvarint(w.buf[len(w.buf)-binary.MaxVarintLen64:], v)
+	w.buf = w.buf[:len(w.buf)-binary.MaxVarintLen64+n]
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &w.lastIds[len(w.lastIds)-1]
+
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.zigzag(int64(id))
+	}
+
+	*last = id
+}
+
+func (w *thriftWriter) structBegin() {
+	w.lastIds = append(w.lastIds, 0)
+}
+
+func (w *thriftWriter) structEnd() {
+	w.buf = append(w.buf, 0)
+	w.lastIds = w.lastIds[:len(w.lastIds)-1]
+}
+
+func (w *thriftWriter) listBegin(typ byte, size int) {
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|typ)
+	} else {
+		w.buf = append(w.buf, 0xF0|typ)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *thriftWriter) i32(v int32) {
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) binary(v string) {
+	w.varint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *thriftWriter) fieldI32(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.i32(v)
+}
+
+func (w *thriftWriter) fieldI64(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) fieldBinary(id int16, v string) {
+	w.fieldHeader(id, thriftBinary)
+	w.binary(v)
+}
+
+func (w *thriftWriter) fieldList(id int16, typ byte, size int) {
+	w.fieldHeader(id, thriftList)
+	w.listBegin(typ, size)
+}
+
+func (w *thriftWriter) fieldStructBegin(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.structBegin()
+}
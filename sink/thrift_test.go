@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// thriftReader decodes structs encoded with the Thrift compact protocol into
+// maps of field ids.
+type thriftReader struct {
+	buf []byte
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+
+	case thriftBinary:
+		n := r.varint()
+		v := string(r.buf[:n])
+		r.buf = r.buf[n:]
+		return v
+
+	case thriftList:
+		h := r.buf[0]
+		r.buf = r.buf[1:]
+		size := int(h >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		var ret []interface{}
+		for i := 0; i < size; i++ {
+			ret = append(ret, r.value(h&0x0F))
+		}
+		return ret
+
+	case thriftStruct:
+		ret := make(map[int16]interface{})
+		var last int16
+		for {
+			h := r.buf[0]
+			r.buf = r.buf[1:]
+			if h == 0 {
+				return ret
+			}
+			id := last + int16(h>>4)
+			if h>>4 == 0 {
+				id = int16(r.zigzag())
+			}
+			ret[id] = r.value(h & 0x0F)
+			last = id
+		}
+	}
+
+	panic(fmt.Errorf("unsupported type %d", typ))
+}
+
+func TestThrift(t *testing.T) {
+	w := &thriftWriter{}
+	w.structBegin()
+	w.fieldI32(1, -3)
+	w.fieldI64(20, 1<<40)
+	w.fieldList(21, thriftBinary, 16)
+	for i := 0; i < 16; i++ {
+		w.binary("a")
+	}
+	w.fieldStructBegin(22)
+	w.fieldBinary(1, "test")
+	w.structEnd()
+	w.structEnd()
+
+	r := &thriftReader{w.buf}
+	v := r.value(thriftStruct).(map[int16]interface{})
+	require.Equal(t, 0, len(r.buf))
+	require.Equal(t, int64(-3), v[1])
+	require.Equal(t, int64(1<<40), v[20])
+	require.Equal(t, 16, len(v[21].([]interface{})))
+	require.Equal(t, map[int16]interface{}{1: "test"}, v[22])
+}
@@ -0,0 +1,542 @@
+// Package spoof implements a detector of spoofed traffic, aimed at nodes that
+// listen on open UDP ports, where any host can inject frames that pretend to
+// come from a vehicle.
+//
+// The detector applies the following heuristics to the frames of each system:
+//   - sequence discontinuities: frames of a component whose sequence number
+//     jumps back or forward by more than the expected losses, that happens
+//     when two sources emit frames with the same ids
+//   - signature state: unsigned frames received from a system that has
+//     previously sent signed frames
+//   - heartbeat changes: heartbeats of a component whose type or autopilot
+//     changes
+//   - impossible position jumps: GLOBAL_POSITION_INT messages that imply a
+//     speed greater than the speed of the vehicle
+//
+// Each anomaly increases the confidence score of the system, a value between
+// 0 and 1 that decays over time. A system is suspect when its score reaches
+// a threshold.
+//
+// The node to which the detector is attached must use a dialect that contains
+// the common messages.
+package spoof
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	frameQueueSize = 256
+
+	// the maximum number of frames that can be lost between two frames of a
+	// component before the sequence is considered discontinuous
+	maxSequenceGap = 32
+
+	// frames whose sequence number is behind the last one by less than this
+	// value are considered duplicates, that are received through redundant
+	// links
+	maxSequenceDuplicate = 8
+
+	// tolerance on the distance between two positions, that accounts for
+	// GPS glitches
+	positionTolerance = 50
+
+	earthRadius = 6371000
+
+	checkPeriod = 1 * time.Second
+)
+
+// Anomaly is a suspicious condition detected in the traffic of a system.
+type Anomaly int
+
+const (
+	// AnomalySequence is a discontinuity in sequence numbers.
+	AnomalySequence Anomaly = iota
+
+	// AnomalySignature is an unsigned frame of a system that signs frames.
+	AnomalySignature
+
+	// AnomalyHeartbeat is a change of type or autopilot in heartbeats.
+	AnomalyHeartbeat
+
+	// AnomalyPosition is an impossible position jump.
+	AnomalyPosition
+)
+
+// String implements fmt.Stringer.
+func (a Anomaly) String() string {
+	switch a {
+	case AnomalySequence:
+		return "sequence"
+	case AnomalySignature:
+		return "signature"
+	case AnomalyHeartbeat:
+		return "heartbeat"
+	case AnomalyPosition:
+		return "position"
+	}
+	return "unknown"
+}
+
+var defaultWeights = map[Anomaly]float64{
+	AnomalySequence:  0.1,
+	AnomalySignature: 0.8,
+	AnomalyHeartbeat: 0.5,
+	AnomalyPosition:  0.4,
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventAnomaly means that an anomaly has been detected.
+	EventAnomaly EventType = iota
+
+	// EventSuspect means that the score of a system has reached the threshold.
+	EventSuspect
+
+	// EventClear means that the score of a suspect system has fallen below
+	// the threshold.
+	EventClear
+)
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	switch t {
+	case EventAnomaly:
+		return "anomaly"
+	case EventSuspect:
+		return "suspect"
+	case EventClear:
+		return "clear"
+	}
+	return "unknown"
+}
+
+// Event is an anomaly or a change of the state of a system.
+type Event struct {
+	// the type of the event.
+	Type EventType
+
+	// the system id.
+	SystemId byte
+
+	// the anomaly. It is filled only when Type is EventAnomaly.
+	Anomaly Anomaly
+
+	// the channel from which the anomalous frame has been received.
+	// It is filled only when Type is EventAnomaly.
+	Channel *gomavlib.Channel
+
+	// the score of the system, after the event.
+	Score float64
+
+	// the time at which the event has been detected.
+	Time time.Time
+}
+
+// Status is the state of a system.
+type Status struct {
+	// the system id.
+	SystemId byte
+
+	// the confidence score that the traffic of the system is spoofed,
+	// between 0 and 1.
+	Score float64
+
+	// whether the score has reached the threshold.
+	Suspect bool
+
+	// the number of detected anomalies, by type.
+	Anomalies map[Anomaly]int
+}
+
+// Conf allows to configure a Detector.
+type Conf struct {
+	// the node from which frames are read.
+	Node *gomavlib.Node
+
+	// (optional) called when an anomaly is detected, or when the state of a
+	// system changes.
+	// It is called by a dedicated routine, one event at a time.
+	OnEvent func(Event)
+
+	// (optional) the score at which a system becomes suspect.
+	// It defaults to 0.5.
+	Threshold float64
+
+	// (optional) the increase of the score caused by each anomaly, that is
+	// computed as weight * (1 - score). Missing anomalies use the default
+	// weights: sequence 0.1, signature 0.8, heartbeat 0.5, position 0.4.
+	Weights map[Anomaly]float64
+
+	// (optional) the time after which the score of a system is halved.
+	// It defaults to 1 minute.
+	HalfLife time.Duration
+
+	// (optional) the maximum speed of vehicles, in meters per second.
+	// It defaults to 150.
+	MaxSpeed float64
+}
+
+type componentState struct {
+	sequence  byte
+	heartbeat bool
+	typ       common.MAV_TYPE
+	autopilot common.MAV_AUTOPILOT
+}
+
+type systemState struct {
+	components map[byte]*componentState
+	signed     bool
+
+	position     bool
+	lat          float64
+	lon          float64
+	positionTime time.Time
+
+	score     float64
+	scoreTime time.Time
+	suspect   bool
+	anomalies map[Anomaly]int
+}
+
+type frameEntry struct {
+	evt  *gomavlib.EventFrame
+	time time.Time
+}
+
+// Detector detects spoofed traffic.
+type Detector struct {
+	conf          Conf
+	removeHandler func()
+
+	mutex   sync.Mutex
+	systems map[byte]*systemState
+
+	frames    chan frameEntry
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Detector. See Conf for the options.
+func New(conf Conf) (*Detector, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHeartbeat{},
+		&common.MessageGlobalPositionInt{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Threshold < 0 || conf.Threshold > 1 {
+		return nil, fmt.Errorf("Threshold must be between 0 and 1")
+	}
+	if conf.Threshold == 0 {
+		conf.Threshold = 0.5
+	}
+
+	weights := make(map[Anomaly]float64)
+	for a, w := range defaultWeights {
+		weights[a] = w
+	}
+	for a, w := range conf.Weights {
+		if w < 0 || w > 1 {
+			return nil, fmt.Errorf("weight of anomaly %s must be between 0 and 1", a)
+		}
+		weights[a] = w
+	}
+	conf.Weights = weights
+
+	if conf.HalfLife < 0 {
+		return nil, fmt.Errorf("HalfLife must be >= 0")
+	}
+	if conf.HalfLife == 0 {
+		conf.HalfLife = 1 * time.Minute
+	}
+
+	if conf.MaxSpeed < 0 {
+		return nil, fmt.Errorf("MaxSpeed must be >= 0")
+	}
+	if conf.MaxSpeed == 0 {
+		conf.MaxSpeed = 150
+	}
+
+	d := &Detector{
+		conf:      conf,
+		systems:   make(map[byte]*systemState),
+		frames:    make(chan frameEntry, frameQueueSize),
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	d.removeHandler = conf.Node.AddFrameHandler(d.onEventFrame)
+
+	go d.run()
+
+	return d, nil
+}
+
+// Close stops the detector. It must be called before closing the node.
+func (d *Detector) Close() {
+	d.removeHandler()
+	close(d.terminate)
+	<-d.done
+}
+
+func (d *Detector) onEventFrame(evt *gomavlib.EventFrame) {
+	// frame handlers must not block; frames are dropped when the queue is
+	// full. The reception time is saved here, since frames may wait in the
+	// queue.
+	select {
+	case d.frames <- frameEntry{evt, time.Now()}:
+	default:
+	}
+}
+
+func (d *Detector) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(checkPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case fe := <-d.frames:
+			for _, e := range d.process(fe.evt, fe.time) {
+				d.emit(e)
+			}
+
+		case now := <-ticker.C:
+			for _, e := range d.decayAll(now) {
+				d.emit(e)
+			}
+
+		case <-d.terminate:
+			return
+		}
+	}
+}
+
+func (d *Detector) emit(e Event) {
+	if d.conf.OnEvent != nil {
+		d.conf.OnEvent(e)
+	}
+}
+
+// decay updates the score of a system, and returns an EventClear if the
+// system is not suspect anymore. It must be called with the mutex locked.
+func (d *Detector) decay(sys byte, st *systemState, now time.Time) []Event {
+	if st.score == 0 {
+		st.scoreTime = now
+		return nil
+	}
+
+	elapsed := now.Sub(st.scoreTime)
+	if elapsed > 0 {
+		st.score *= math.Pow(0.5, float64(elapsed)/float64(d.conf.HalfLife))
+		st.scoreTime = now
+	}
+
+	if st.suspect && st.score < d.conf.Threshold {
+		st.suspect = false
+		return []Event{{
+			Type:     EventClear,
+			SystemId: sys,
+			Score:    st.score,
+			Time:     now,
+		}}
+	}
+
+	return nil
+}
+
+func (d *Detector) decayAll(now time.Time) []Event {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ret []Event
+	for sys, st := range d.systems {
+		ret = append(ret, d.decay(sys, st, now)...)
+	}
+	return ret
+}
+
+func sequenceId(f frame.Frame) byte {
+	switch ff := f.(type) {
+	case *frame.V1Frame:
+		return ff.SequenceId
+	case *frame.V2Frame:
+		return ff.SequenceId
+	}
+	return 0
+}
+
+func isSigned(f frame.Frame) bool {
+	ff, ok := f.(*frame.V2Frame)
+	return ok && ff.IsSigned()
+}
+
+// distance returns the distance between two positions, in meters.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	// haversine formula
+	lat1 = lat1 * math.Pi / 180
+	lat2 = lat2 * math.Pi / 180
+	dlat := lat2 - lat1
+	dlon := (lon2 - lon1) * math.Pi / 180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func (d *Detector) process(evt *gomavlib.EventFrame, now time.Time) []Event {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	st, ok := d.systems[evt.SystemId()]
+	if !ok {
+		st = &systemState{
+			components: make(map[byte]*componentState),
+			scoreTime:  now,
+			anomalies:  make(map[Anomaly]int),
+		}
+		d.systems[evt.SystemId()] = st
+	}
+
+	var anomalies []Anomaly
+
+	// sequence
+	seq := sequenceId(evt.Frame)
+	cs, ok := st.components[evt.ComponentId()]
+	if !ok {
+		cs = &componentState{sequence: seq}
+		st.components[evt.ComponentId()] = cs
+	} else {
+		diff := seq - cs.sequence
+		switch {
+		case diff >= 1 && diff <= maxSequenceGap:
+			cs.sequence = seq
+
+		case diff == 0 || diff >= 256-maxSequenceDuplicate:
+			// duplicate
+
+		default:
+			cs.sequence = seq
+			anomalies = append(anomalies, AnomalySequence)
+		}
+	}
+
+	// signature
+	if isSigned(evt.Frame) {
+		st.signed = true
+	} else if st.signed {
+		anomalies = append(anomalies, AnomalySignature)
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageHeartbeat{}).GetId():
+		var m common.MessageHeartbeat
+		if msg.Convert(&m, evt.Message()) == nil {
+			if cs.heartbeat && (m.Type != cs.typ || m.Autopilot != cs.autopilot) {
+				anomalies = append(anomalies, AnomalyHeartbeat)
+			}
+			cs.heartbeat = true
+			cs.typ = m.Type
+			cs.autopilot = m.Autopilot
+		}
+
+	case (&common.MessageGlobalPositionInt{}).GetId():
+		var m common.MessageGlobalPositionInt
+		if msg.Convert(&m, evt.Message()) == nil && (m.Lat != 0 || m.Lon != 0) {
+			lat := float64(m.Lat) / 1e7
+			lon := float64(m.Lon) / 1e7
+
+			if st.position {
+				dt := now.Sub(st.positionTime).Seconds()
+				if distance(st.lat, st.lon, lat, lon) > d.conf.MaxSpeed*dt+positionTolerance {
+					anomalies = append(anomalies, AnomalyPosition)
+				}
+			}
+
+			st.position = true
+			st.lat = lat
+			st.lon = lon
+			st.positionTime = now
+		}
+	}
+
+	ret := d.decay(evt.SystemId(), st, now)
+
+	for _, a := range anomalies {
+		st.anomalies[a]++
+		st.score += d.conf.Weights[a] * (1 - st.score)
+
+		ret = append(ret, Event{
+			Type:     EventAnomaly,
+			SystemId: evt.SystemId(),
+			Anomaly:  a,
+			Channel:  evt.Channel,
+			Score:    st.score,
+			Time:     now,
+		})
+	}
+
+	if !st.suspect && len(anomalies) > 0 && st.score >= d.conf.Threshold {
+		st.suspect = true
+		ret = append(ret, Event{
+			Type:     EventSuspect,
+			SystemId: evt.SystemId(),
+			Score:    st.score,
+			Time:     now,
+		})
+	}
+
+	return ret
+}
+
+// Systems returns the state of the systems seen by the detector, sorted by
+// system id.
+func (d *Detector) Systems() []Status {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+
+	ret := make([]Status, 0, len(d.systems))
+	for sys, st := range d.systems {
+		score := st.score * math.Pow(0.5, float64(now.Sub(st.scoreTime))/float64(d.conf.HalfLife))
+
+		anomalies := make(map[Anomaly]int, len(st.anomalies))
+		for a, n := range st.anomalies {
+			anomalies[a] = n
+		}
+
+		ret = append(ret, Status{
+			SystemId:  sys,
+			Score:     score,
+			Suspect:   st.suspect,
+			Anomalies: anomalies,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].SystemId < ret[j].SystemId
+	})
+	return ret
+}
@@ -0,0 +1,198 @@
+package spoof
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+func testNode(t *testing.T, conf gomavlib.EndpointConf, sysid byte) *gomavlib.Node {
+	n, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      sysid,
+		Endpoints:        []gomavlib.EndpointConf{conf},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+
+	go func() {
+		for range n.Events() {
+		}
+	}()
+
+	return n
+}
+
+func testFrame(seq byte, signed bool, m msg.Message) *gomavlib.EventFrame {
+	f := &frame.V2Frame{
+		SequenceId:  seq,
+		SystemId:    1,
+		ComponentId: 1,
+		Message:     m,
+	}
+	if signed {
+		f.IncompatibilityFlag = frame.V2FlagSigned
+	}
+	return &gomavlib.EventFrame{Frame: f}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{})
+	require.EqualError(t, err, "Node not provided")
+
+	node := testNode(t, gomavlib.EndpointUdpServer{Address: "127.0.0.1:5740"}, 255)
+	defer node.Close()
+
+	_, err = New(Conf{Node: node, Threshold: 2})
+	require.EqualError(t, err, "Threshold must be between 0 and 1")
+
+	_, err = New(Conf{Node: node, Weights: map[Anomaly]float64{AnomalyPosition: -1}})
+	require.EqualError(t, err, "weight of anomaly position must be between 0 and 1")
+}
+
+func TestHeuristics(t *testing.T) {
+	node := testNode(t, gomavlib.EndpointUdpServer{Address: "127.0.0.1:5740"}, 255)
+	defer node.Close()
+
+	d, err := New(Conf{Node: node})
+	require.NoError(t, err)
+	defer d.Close()
+
+	anomalies := func(evts []Event) []Anomaly {
+		var ret []Anomaly
+		for _, e := range evts {
+			if e.Type == EventAnomaly {
+				ret = append(ret, e.Anomaly)
+			}
+		}
+		return ret
+	}
+
+	now := time.Now()
+	hb := &common.MessageHeartbeat{Type: common.MAV_TYPE_QUADROTOR}
+	pos := func(lat int32) *common.MessageGlobalPositionInt {
+		return &common.MessageGlobalPositionInt{Lat: lat, Lon: 100000000}
+	}
+
+	// regular traffic, with losses and duplicates
+	require.Equal(t, []Anomaly(nil), anomalies(d.process(testFrame(250, false, hb), now)))
+	require.Equal(t, []Anomaly(nil), anomalies(d.process(testFrame(251, false, pos(450000000)), now)))
+	require.Equal(t, []Anomaly(nil), anomalies(d.process(testFrame(10, false, hb), now)))
+	require.Equal(t, []Anomaly(nil), anomalies(d.process(testFrame(8, false, hb), now)))
+	now = now.Add(1 * time.Second)
+	require.Equal(t, []Anomaly(nil), anomalies(d.process(testFrame(11, false, pos(450010000)), now)))
+
+	// sequence discontinuity
+	require.Equal(t, []Anomaly{AnomalySequence}, anomalies(d.process(testFrame(200, false, hb), now)))
+
+	// heartbeat change
+	require.Equal(t, []Anomaly{AnomalyHeartbeat},
+		anomalies(d.process(testFrame(201, false, &common.MessageHeartbeat{Type: common.MAV_TYPE_GCS}), now)))
+
+	// impossible position jump (about 11 km in 1 second)
+	now = now.Add(1 * time.Second)
+	require.Equal(t, []Anomaly{AnomalyPosition}, anomalies(d.process(testFrame(202, false, pos(451000000)), now)))
+
+	// unsigned frame after signed ones
+	hb = &common.MessageHeartbeat{Type: common.MAV_TYPE_GCS}
+	require.Equal(t, []Anomaly(nil), anomalies(d.process(testFrame(203, true, hb), now)))
+	evts := d.process(testFrame(204, false, hb), now)
+	require.Equal(t, []Anomaly{AnomalySignature}, anomalies(evts))
+
+	sys := d.Systems()
+	require.Equal(t, 1, len(sys))
+	require.Equal(t, byte(1), sys[0].SystemId)
+	require.Equal(t, true, sys[0].Suspect)
+	require.Equal(t, map[Anomaly]int{
+		AnomalySequence:  1,
+		AnomalyHeartbeat: 1,
+		AnomalyPosition:  1,
+		AnomalySignature: 1,
+	}, sys[0].Anomalies)
+}
+
+func TestScore(t *testing.T) {
+	node := testNode(t, gomavlib.EndpointUdpServer{Address: "127.0.0.1:5740"}, 255)
+	defer node.Close()
+
+	d, err := New(Conf{
+		Node:     node,
+		HalfLife: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	now := time.Now()
+	d.process(testFrame(0, true, &common.MessageHeartbeat{}), now)
+
+	evts := d.process(testFrame(1, false, &common.MessageHeartbeat{}), now)
+	require.Equal(t, 2, len(evts))
+	require.Equal(t, EventAnomaly, evts[0].Type)
+	require.InDelta(t, 0.8, evts[0].Score, 0.0001)
+	require.Equal(t, EventSuspect, evts[1].Type)
+
+	// the score decays
+	d.mutex.Lock()
+	evts = d.decay(1, d.systems[1], now.Add(5*time.Second))
+	d.mutex.Unlock()
+	require.Equal(t, 0, len(evts))
+
+	d.mutex.Lock()
+	evts = d.decay(1, d.systems[1], now.Add(10*time.Second))
+	d.mutex.Unlock()
+	require.Equal(t, []Event{{
+		Type:     EventClear,
+		SystemId: 1,
+		Score:    evts[0].Score,
+		Time:     now.Add(10 * time.Second),
+	}}, evts)
+	require.InDelta(t, 0.4, evts[0].Score, 0.0001)
+}
+
+func TestDetector(t *testing.T) {
+	events := make(chan Event, 100)
+
+	gcs := testNode(t, gomavlib.EndpointUdpServer{Address: "127.0.0.1:5740"}, 255)
+	defer gcs.Close()
+
+	d, err := New(Conf{
+		Node: gcs,
+		OnEvent: func(e Event) {
+			events <- e
+		},
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	// a vehicle and a spoofer that use the same system id
+	vehicle := testNode(t, gomavlib.EndpointUdpClient{Address: "127.0.0.1:5740"}, 1)
+	defer vehicle.Close()
+	spoofer := testNode(t, gomavlib.EndpointUdpClient{Address: "127.0.0.1:5740"}, 1)
+	defer spoofer.Close()
+
+	for i := 0; i < 10; i++ {
+		vehicle.WriteMessageAll(&common.MessageHeartbeat{Type: common.MAV_TYPE_QUADROTOR})
+		spoofer.WriteMessageAll(&common.MessageHeartbeat{Type: common.MAV_TYPE_FIXED_WING})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for {
+		select {
+		case e := <-events:
+			if e.Type == EventSuspect {
+				require.Equal(t, byte(1), e.SystemId)
+				return
+			}
+
+		case <-time.After(2 * time.Second):
+			t.Fatal("event not received")
+		}
+	}
+}
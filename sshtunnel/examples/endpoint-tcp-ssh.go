@@ -0,0 +1,75 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/sshtunnel"
+)
+
+func main() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+
+	// load the key of this client, used by the SSH server to authenticate it
+	byts, err := ioutil.ReadFile(filepath.Join(home, ".ssh", "id_ed25519"))
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.ParsePrivateKey(byts)
+	if err != nil {
+		panic(err)
+	}
+
+	// authenticate the SSH server with the known_hosts file
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		panic(err)
+	}
+
+	// set up a TCP endpoint in client mode, through a SSH tunnel,
+	// i.e. with mavlink-router running on a companion computer
+	endpoint, err := sshtunnel.EndpointTcpSsh{
+		SshAddress:      "companion.example.com:22",
+		User:            "pi",
+		HostKeyCallback: hostKeyCallback,
+		Signers:         []ssh.Signer{signer},
+		Address:         "127.0.0.1:5760",
+	}.EndpointConf()
+	if err != nil {
+		panic(err)
+	}
+
+	// create a node which
+	// - communicates through the tunnel
+	// - understands ardupilotmega dialect
+	// - writes messages with given system id
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints:   []gomavlib.EndpointConf{endpoint},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2, // change to V1 if you're unable to communicate with the target
+		OutSystemId: 10,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// print every message we receive
+	for evt := range node.Events() {
+		if frm, ok := evt.(*gomavlib.EventFrame); ok {
+			fmt.Printf("received: id=%d, %+v\n", frm.Message().GetId(), frm.Message())
+		}
+	}
+}
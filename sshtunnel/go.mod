@@ -0,0 +1,18 @@
+module github.com/aler9/gomavlib/sshtunnel
+
+go 1.17
+
+require (
+	github.com/aler9/gomavlib v0.0.0
+	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.14.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+)
+
+replace github.com/aler9/gomavlib => ../
@@ -0,0 +1,207 @@
+// Package sshtunnel provides an endpoint that works with a TCP client, whose
+// connection is tunneled through a SSH server (jump host).
+//
+// The package is a separate module, in order to keep the main module free
+// from the dependency on golang.org/x/crypto.
+package sshtunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/aler9/gomavlib"
+)
+
+const (
+	connectTimeout   = 10 * time.Second
+	keepalivePeriod  = 10 * time.Second
+	keepaliveTimeout = 60 * time.Second
+)
+
+// EndpointTcpSsh sets up a endpoint that works with a TCP client, whose
+// connection is tunneled through a SSH server (jump host). It allows to reach
+// a device, i.e. a companion computer, that only exposes the SSH port.
+// The tunnel is established again when it drops.
+type EndpointTcpSsh struct {
+	// domain name or IP of the SSH server, example: 1.2.3.4:22
+	SshAddress string
+
+	// the user used to log into the SSH server.
+	User string
+
+	// a function that verifies the key of the SSH server, i.e.
+	// ssh.FixedHostKey() or the callback returned by knownhosts.New().
+	HostKeyCallback ssh.HostKeyCallback
+
+	// (optional) the keys used to authenticate with the SSH server.
+	// Keys can be loaded with ssh.ParsePrivateKey().
+	Signers []ssh.Signer
+
+	// (optional) authenticate with the keys of the SSH agent whose socket is
+	// set in the SSH_AUTH_SOCK environment variable.
+	Agent bool
+
+	// (optional) the password used to authenticate with the SSH server.
+	Password string
+
+	// domain name or IP of the server to connect to, as seen by the SSH
+	// server, example: 127.0.0.1:5760
+	Address string
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	Reconnect gomavlib.ReconnectPolicy
+}
+
+// EndpointConf checks the configuration and returns the configuration of
+// the endpoint, that can be inserted into NodeConf.Endpoints.
+func (conf EndpointTcpSsh) EndpointConf() (gomavlib.EndpointConf, error) {
+	_, _, err := net.SplitHostPort(conf.SshAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH address")
+	}
+
+	_, _, err = net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	if conf.User == "" {
+		return nil, fmt.Errorf("User not provided")
+	}
+
+	if conf.HostKeyCallback == nil {
+		return nil, fmt.Errorf("HostKeyCallback not provided")
+	}
+
+	if len(conf.Signers) == 0 && !conf.Agent && conf.Password == "" {
+		return nil, fmt.Errorf("at least one authentication method must be provided")
+	}
+
+	return gomavlib.EndpointTransport{
+		Instance:  &transport{conf: conf},
+		Address:   conf.Address,
+		Reconnect: conf.Reconnect,
+	}, nil
+}
+
+// transport is the transport of the endpoint.
+type transport struct {
+	conf EndpointTcpSsh
+}
+
+func (*transport) Label() string {
+	return "ssh"
+}
+
+func (t *transport) Dial(address string) (gomavlib.TransportConn, error) {
+	conf := t.conf
+	var auths []ssh.AuthMethod
+
+	if len(conf.Signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(conf.Signers...))
+	}
+
+	if conf.Agent {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+		}
+
+		agentConn, err := net.DialTimeout("unix", sock, connectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		// the agent is needed during the handshake only
+		defer agentConn.Close()
+
+		auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+	}
+
+	if conf.Password != "" {
+		auths = append(auths, ssh.Password(conf.Password))
+	}
+
+	client, err := ssh.Dial("tcp", conf.SshAddress, &ssh.ClientConfig{
+		User:            conf.User,
+		Auth:            auths,
+		HostKeyCallback: conf.HostKeyCallback,
+		Timeout:         connectTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.Dial("tcp", address)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	c := &tunnelConn{
+		Conn:      conn,
+		client:    client,
+		terminate: make(chan struct{}),
+	}
+	go c.keepalive()
+	return c, nil
+}
+
+// tunnelConn is a connection tunneled through a SSH client. Since tunneled
+// connections do not support deadlines, a drop of the tunnel is detected
+// with keepalives, that close the client when they are not answered.
+type tunnelConn struct {
+	net.Conn
+	client    *ssh.Client
+	terminate chan struct{}
+}
+
+func (c *tunnelConn) Close() error {
+	close(c.terminate)
+	c.Conn.Close()
+	return c.client.Close()
+}
+
+func (c *tunnelConn) keepalive() {
+	ticker := time.NewTicker(keepalivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			res := make(chan error, 1)
+			go func() {
+				_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
+				res <- err
+			}()
+
+			timer := time.NewTimer(keepaliveTimeout)
+			select {
+			case err := <-res:
+				timer.Stop()
+				// servers reply to unknown requests with a failure,
+				// that means that the tunnel is alive
+				if err != nil {
+					c.client.Close()
+					return
+				}
+
+			case <-timer.C:
+				c.client.Close()
+				return
+
+			case <-c.terminate:
+				timer.Stop()
+				return
+			}
+
+		case <-c.terminate:
+			return
+		}
+	}
+}
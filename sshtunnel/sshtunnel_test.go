@@ -0,0 +1,296 @@
+package sshtunnel
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// testSshServer is a SSH server that forwards tunneled connections.
+func testSshServer(t *testing.T, address string, clientKey ssh.PublicKey) (ssh.PublicKey, func()) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	conf := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "user" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized")
+		},
+	}
+	conf.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp4", address)
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			nconn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(nconn, conf)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+
+				for nc := range chans {
+					var payload struct {
+						DestAddr string
+						DestPort uint32
+						OrigAddr string
+						OrigPort uint32
+					}
+					if nc.ChannelType() != "direct-tcpip" ||
+						ssh.Unmarshal(nc.ExtraData(), &payload) != nil {
+						nc.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+
+					dest, err := net.Dial("tcp4", net.JoinHostPort(payload.DestAddr,
+						strconv.FormatUint(uint64(payload.DestPort), 10)))
+					if err != nil {
+						nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+
+					ch, reqs, err := nc.Accept()
+					if err != nil {
+						dest.Close()
+						continue
+					}
+					go ssh.DiscardRequests(reqs)
+
+					go func() {
+						io.Copy(ch, dest)
+						ch.Close()
+					}()
+					go func() {
+						io.Copy(dest, ch)
+						dest.Close()
+					}()
+				}
+			}()
+		}
+	}()
+
+	return hostKey.PublicKey(), func() { ln.Close() }
+}
+
+// doTest exchanges a message in each direction between a TCP server and
+// a tunneled client.
+func doTest(t *testing.T, serverAddress string, conf EndpointTcpSsh) {
+	econf, err := conf.EndpointConf()
+	require.NoError(t, err)
+
+	server, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      10,
+		Endpoints:        []gomavlib.EndpointConf{gomavlib.EndpointTcpServer{Address: serverAddress}},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	// the client writes heartbeats until the tunnel is established
+	client, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:         common.Dialect,
+		OutVersion:      gomavlib.V2,
+		OutSystemId:     11,
+		Endpoints:       []gomavlib.EndpointConf{econf},
+		HeartbeatPeriod: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	for evt := range server.Events() {
+		if e, ok := evt.(*gomavlib.EventFrame); ok {
+			_, ok := e.Message().(*common.MessageHeartbeat)
+			require.Equal(t, true, ok)
+			require.Equal(t, byte(11), e.SystemId())
+			server.WriteMessageAll(&common.MessageSysStatus{Load: 100})
+			break
+		}
+	}
+
+	for evt := range client.Events() {
+		if e, ok := evt.(*gomavlib.EventFrame); ok {
+			require.Equal(t, "ssh:"+conf.Address, e.Channel.String())
+			require.Equal(t, &common.MessageSysStatus{Load: 100}, e.Message())
+			require.Equal(t, byte(10), e.SystemId())
+			break
+		}
+	}
+
+	go func() {
+		for range server.Events() {
+		}
+	}()
+	go func() {
+		for range client.Events() {
+		}
+	}()
+}
+
+func TestEndpoint(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	hostKey, closeServer := testSshServer(t, "127.0.0.1:5615", signer.PublicKey())
+	defer closeServer()
+
+	doTest(t, "127.0.0.1:5616", EndpointTcpSsh{
+		SshAddress:      "127.0.0.1:5615",
+		User:            "user",
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Signers:         []ssh.Signer{signer},
+		Address:         "127.0.0.1:5616",
+	})
+}
+
+func TestEndpointAgent(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	hostKey, closeServer := testSshServer(t, "127.0.0.1:5617", signer.PublicKey())
+	defer closeServer()
+
+	keyring := agent.NewKeyring()
+	err = keyring.Add(agent.AddedKey{PrivateKey: priv})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "gomavlib")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	prev, hadPrev := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sock)
+	defer func() {
+		if hadPrev {
+			os.Setenv("SSH_AUTH_SOCK", prev)
+		} else {
+			os.Unsetenv("SSH_AUTH_SOCK")
+		}
+	}()
+
+	doTest(t, "127.0.0.1:5618", EndpointTcpSsh{
+		SshAddress:      "127.0.0.1:5617",
+		User:            "user",
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Agent:           true,
+		Address:         "127.0.0.1:5618",
+	})
+}
+
+func TestEndpointConfErrors(t *testing.T) {
+	for _, ca := range []struct {
+		conf EndpointTcpSsh
+		err  string
+	}{
+		{
+			EndpointTcpSsh{SshAddress: "127.0.0.1", Address: "127.0.0.1:5600"},
+			"invalid SSH address",
+		},
+		{
+			EndpointTcpSsh{SshAddress: "127.0.0.1:22", Address: "127.0.0.1"},
+			"invalid address",
+		},
+		{
+			EndpointTcpSsh{SshAddress: "127.0.0.1:22", Address: "127.0.0.1:5600"},
+			"User not provided",
+		},
+		{
+			EndpointTcpSsh{SshAddress: "127.0.0.1:22", Address: "127.0.0.1:5600", User: "user"},
+			"HostKeyCallback not provided",
+		},
+		{
+			EndpointTcpSsh{
+				SshAddress:      "127.0.0.1:22",
+				Address:         "127.0.0.1:5600",
+				User:            "user",
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			"at least one authentication method must be provided",
+		},
+	} {
+		_, err := ca.conf.EndpointConf()
+		require.EqualError(t, err, ca.err)
+	}
+}
+
+func TestEndpointUnreachable(t *testing.T) {
+	econf, err := EndpointTcpSsh{
+		SshAddress:      "127.0.0.1:5619",
+		User:            "user",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Password:        "password",
+		Address:         "127.0.0.1:5600",
+		Reconnect: gomavlib.ReconnectPolicy{
+			InitialDelay: 10 * time.Millisecond,
+			MaxAttempts:  1,
+		},
+	}.EndpointConf()
+	require.NoError(t, err)
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemId:      11,
+		Endpoints:        []gomavlib.EndpointConf{econf},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer node.Close()
+
+	for evt := range node.Events() {
+		if e, ok := evt.(*gomavlib.EventEndpointGiveUp); ok {
+			require.Equal(t, 1, e.Attempts)
+			break
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package swarm implements helpers to address multiple vehicles at once,
+// i.e. to send simultaneous mode changes or waypoint updates to a swarm.
+//
+// Commands are sent to each vehicle with a command.Sender, therefore they are
+// retransmitted and acknowledged separately. Transmissions are staggered, in
+// order not to saturate shared links, and the results of all vehicles are
+// collected, so that partial failures can be reported and retried.
+//
+// The node to which the swarm is attached must use a dialect that contains
+// the common messages.
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// Result is the outcome of an operation on a vehicle.
+type Result struct {
+	// the system id of the vehicle.
+	SystemId byte
+
+	// the final acknowledgement of a command, if any.
+	Ack *common.MessageCommandAck
+
+	// the error of the operation, or nil if it succeeded.
+	Err error
+}
+
+// PartialError is returned when an operation fails on some vehicles.
+type PartialError struct {
+	// the results of the vehicles on which the operation failed.
+	Failed []Result
+}
+
+// Error implements the error interface.
+func (e *PartialError) Error() string {
+	strs := make([]string, len(e.Failed))
+	for i, r := range e.Failed {
+		strs[i] = fmt.Sprintf("system %d: %s", r.SystemId, r.Err)
+	}
+	return fmt.Sprintf("operation failed on %d vehicles: %s", len(e.Failed), strings.Join(strs, "; "))
+}
+
+// SystemIds returns the system ids of the vehicles on which the operation
+// failed, i.e. to retry it.
+func (e *PartialError) SystemIds() []byte {
+	ret := make([]byte, len(e.Failed))
+	for i, r := range e.Failed {
+		ret[i] = r.SystemId
+	}
+	return ret
+}
+
+// Conf allows to configure a Swarm.
+type Conf struct {
+	// the node with which vehicles are addressed.
+	Node *gomavlib.Node
+
+	// the system ids of the vehicles.
+	SystemIds []byte
+
+	// (optional) the component id of the vehicles.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the delay between the start of operations on consecutive
+	// vehicles. It defaults to 20ms.
+	Stagger time.Duration
+
+	// (optional) the retry policy of commands.
+	Retry command.RetryPolicy
+}
+
+// Swarm addresses a group of vehicles.
+type Swarm struct {
+	conf    Conf
+	senders map[byte]*command.Sender
+}
+
+// New allocates a Swarm. See Conf for the options.
+func New(conf Conf) (*Swarm, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if len(conf.SystemIds) == 0 {
+		return nil, fmt.Errorf("SystemIds not provided")
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+	if conf.Stagger == 0 {
+		conf.Stagger = 20 * time.Millisecond
+	}
+
+	s := &Swarm{
+		conf:    conf,
+		senders: make(map[byte]*command.Sender),
+	}
+
+	for _, id := range conf.SystemIds {
+		if _, ok := s.senders[id]; ok {
+			s.Close()
+			return nil, fmt.Errorf("duplicate system id: %d", id)
+		}
+
+		sender, err := command.New(command.Conf{
+			Node:        conf.Node,
+			SystemId:    id,
+			ComponentId: conf.ComponentId,
+			Retry:       conf.Retry,
+		})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		s.senders[id] = sender
+	}
+
+	return s, nil
+}
+
+// Close stops the swarm. Pending operations return an error.
+// It must be called before closing the node.
+func (s *Swarm) Close() {
+	for _, sender := range s.senders {
+		sender.Close()
+	}
+}
+
+// Each runs an operation on every vehicle, in parallel, starting it on a
+// vehicle at a time, separated by Stagger. It returns the results, sorted by
+// system id, and a *PartialError if the operation failed on some vehicles.
+func (s *Swarm) Each(ctx context.Context,
+	op func(ctx context.Context, systemId byte) (*common.MessageCommandAck, error)) ([]Result, error) {
+	results := make([]Result, len(s.conf.SystemIds))
+
+	var wg sync.WaitGroup
+
+	for i, id := range s.conf.SystemIds {
+		if i > 0 {
+			select {
+			case <-time.After(s.conf.Stagger):
+			case <-ctx.Done():
+			}
+		}
+
+		results[i].SystemId = id
+
+		// operations that have not been started fail immediately
+		if ctx.Err() != nil {
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *Result) {
+			defer wg.Done()
+			r.Ack, r.Err = op(ctx, r.SystemId)
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].SystemId < results[j].SystemId
+	})
+
+	var failed []Result
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+
+	if failed != nil {
+		return results, &PartialError{failed}
+	}
+	return results, nil
+}
+
+// SendLong sends a command to every vehicle with COMMAND_LONG, and waits
+// for their acknowledgements. See Each for the returned values.
+func (s *Swarm) SendLong(ctx context.Context, cmd *common.MessageCommandLong) ([]Result, error) {
+	return s.Each(ctx, func(ctx context.Context, systemId byte) (*common.MessageCommandAck, error) {
+		return s.senders[systemId].SendLong(ctx, cmd, nil)
+	})
+}
+
+// SendInt sends a command to every vehicle with COMMAND_INT, and waits
+// for their acknowledgements. See Each for the returned values.
+func (s *Swarm) SendInt(ctx context.Context, cmd *common.MessageCommandInt) ([]Result, error) {
+	return s.Each(ctx, func(ctx context.Context, systemId byte) (*common.MessageCommandAck, error) {
+		return s.senders[systemId].SendInt(ctx, cmd, nil)
+	})
+}
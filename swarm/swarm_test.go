@@ -0,0 +1,160 @@
+package swarm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+// testVehicles are vehicles that answer commands with a given result, and
+// record the time at which commands are received.
+type testVehicles struct {
+	nodes []*gomavlib.Node
+
+	mutex    sync.Mutex
+	received map[byte]time.Time
+}
+
+func newTestVehicles(t *testing.T, results map[byte]common.MAV_RESULT) (*gomavlib.Node, *testVehicles) {
+	tv := &testVehicles{received: make(map[byte]time.Time)}
+
+	var endpoints []gomavlib.EndpointConf
+
+	for id, result := range results {
+		e1, e2 := testnode.Pipe()
+		endpoints = append(endpoints, e1)
+
+		node, err := gomavlib.NewNode(testnode.Conf(id, e2))
+		require.NoError(t, err)
+		tv.nodes = append(tv.nodes, node)
+
+		id, result := id, result
+		testnode.OnFrame(node, func(fr *gomavlib.EventFrame) {
+			cmd, ok := fr.Message().(*common.MessageCommandLong)
+			if !ok || cmd.TargetSystem != id {
+				return
+			}
+
+			tv.mutex.Lock()
+			if _, ok := tv.received[id]; !ok {
+				tv.received[id] = time.Now()
+			}
+			tv.mutex.Unlock()
+
+			node.WriteMessageAll(&common.MessageCommandAck{
+				Command:      cmd.Command,
+				Result:       result,
+				TargetSystem: 255,
+			})
+		})
+	}
+
+	gcs, err := gomavlib.NewNode(testnode.Conf(255, endpoints...))
+	require.NoError(t, err)
+
+	testnode.DiscardEvents(gcs)
+
+	return gcs, tv
+}
+
+func (tv *testVehicles) close() {
+	for _, n := range tv.nodes {
+		n.Close()
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{SystemIds: []byte{1}})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, tv := newTestVehicles(t, map[byte]common.MAV_RESULT{1: common.MAV_RESULT_ACCEPTED})
+	defer gcs.Close()
+	defer tv.close()
+
+	_, err = New(Conf{Node: gcs})
+	require.EqualError(t, err, "SystemIds not provided")
+
+	_, err = New(Conf{Node: gcs, SystemIds: []byte{1, 1}})
+	require.EqualError(t, err, "duplicate system id: 1")
+}
+
+func TestSendLong(t *testing.T) {
+	gcs, tv := newTestVehicles(t, map[byte]common.MAV_RESULT{
+		1: common.MAV_RESULT_ACCEPTED,
+		2: common.MAV_RESULT_DENIED,
+		3: common.MAV_RESULT_ACCEPTED,
+	})
+	defer gcs.Close()
+	defer tv.close()
+
+	s, err := New(Conf{
+		Node:      gcs,
+		SystemIds: []byte{3, 2, 1, 4},
+		Stagger:   50 * time.Millisecond,
+		Retry: command.RetryPolicy{
+			AckTimeout:         100 * time.Millisecond,
+			MaxRetransmissions: 1,
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	results, err := s.SendLong(context.Background(), &common.MessageCommandLong{
+		Command: common.MAV_CMD_DO_SET_MODE,
+		Param1:  1,
+	})
+
+	perr, ok := err.(*PartialError)
+	require.True(t, ok)
+	require.Equal(t, []byte{2, 4}, perr.SystemIds())
+	require.EqualError(t, err, "operation failed on 2 vehicles: "+
+		"system 2: command MAV_CMD_DO_SET_MODE failed: MAV_RESULT_DENIED; "+
+		"system 4: command timed out")
+
+	require.Equal(t, 4, len(results))
+	for i, r := range results {
+		require.Equal(t, byte(i+1), r.SystemId)
+	}
+	require.Equal(t, common.MAV_RESULT_ACCEPTED, results[0].Ack.Result)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, common.MAV_RESULT_DENIED, results[1].Ack.Result)
+	require.Equal(t, common.MAV_RESULT_ACCEPTED, results[2].Ack.Result)
+	require.Nil(t, results[3].Ack)
+
+	// transmissions are staggered, in the order of SystemIds
+	tv.mutex.Lock()
+	defer tv.mutex.Unlock()
+	require.True(t, tv.received[2].Sub(tv.received[3]) >= 40*time.Millisecond)
+	require.True(t, tv.received[1].Sub(tv.received[2]) >= 40*time.Millisecond)
+}
+
+func TestEachContext(t *testing.T) {
+	gcs, tv := newTestVehicles(t, map[byte]common.MAV_RESULT{1: common.MAV_RESULT_ACCEPTED})
+	defer gcs.Close()
+	defer tv.close()
+
+	s, err := New(Conf{
+		Node:      gcs,
+		SystemIds: []byte{1, 2},
+		Stagger:   1 * time.Second,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	results, err := s.Each(ctx, func(ctx context.Context, systemId byte) (*common.MessageCommandAck, error) {
+		return nil, nil
+	})
+	require.Equal(t, &PartialError{[]Result{{SystemId: 2, Err: context.DeadlineExceeded}}}, err)
+	require.Equal(t, []Result{{SystemId: 1}, {SystemId: 2, Err: context.DeadlineExceeded}}, results)
+}
@@ -0,0 +1,145 @@
+package gomavlib
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// TapDirection is the direction of the bytes passed to a tap.
+type TapDirection int
+
+const (
+	// TapIn is the direction of bytes read from a channel.
+	TapIn TapDirection = iota
+
+	// TapOut is the direction of bytes written to a channel.
+	TapOut
+)
+
+// String implements fmt.Stringer.
+func (d TapDirection) String() string {
+	if d == TapIn {
+		return "in"
+	}
+	return "out"
+}
+
+// TapFunc is a function that receives the raw bytes read from or written to
+// a channel. Read bytes are passed in the chunks returned by the endpoint,
+// therefore they are not aligned to frames, while written bytes are always
+// whole frames. The function is called by the routines that read and write
+// the channel, therefore it must not block, and it must not modify or retain buf.
+type TapFunc func(ch *Channel, dir TapDirection, buf []byte)
+
+// TapWriter returns a TapFunc that writes to w the bytes with the given
+// direction. It allows, for instance, to save the frames sent or received
+// by an endpoint into a file.
+func TapWriter(w io.Writer, dir TapDirection) TapFunc {
+	var mutex sync.Mutex
+	return func(ch *Channel, d TapDirection, buf []byte) {
+		if d != dir {
+			return
+		}
+
+		// channels of the same endpoint are read and written in parallel
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write(buf)
+	}
+}
+
+type tapEntry struct {
+	endpoint Endpoint
+	f        TapFunc
+}
+
+// Endpoints returns the endpoints of the node, in the same order of
+// NodeConf.Endpoints, followed by the ones added with AddEndpoint().
+func (n *Node) Endpoints() []Endpoint {
+	n.endpointsMutex.RLock()
+	defer n.endpointsMutex.RUnlock()
+	return append([]Endpoint(nil), n.endpoints...)
+}
+
+// AddTap adds a function that receives the raw bytes read from and written to
+// the channels of an endpoint. It allows to debug a single link without
+// recording the traffic of the whole node. Taps can be added and removed while
+// the node is running. Endpoints can be obtained with Endpoints() or
+// from Channel.Endpoint.
+// It returns a function that removes the tap.
+func (n *Node) AddTap(e Endpoint, f TapFunc) func() {
+	t := &tapEntry{e, f}
+
+	n.tapsMutex.Lock()
+	defer n.tapsMutex.Unlock()
+	n.taps[t] = struct{}{}
+
+	return func() {
+		n.tapsMutex.Lock()
+		defer n.tapsMutex.Unlock()
+		delete(n.taps, t)
+	}
+}
+
+// removeTaps removes the taps of an endpoint.
+func (n *Node) removeTaps(e Endpoint) {
+	n.tapsMutex.Lock()
+	defer n.tapsMutex.Unlock()
+
+	for t := range n.taps {
+		if t.endpoint == e {
+			delete(n.taps, t)
+		}
+	}
+}
+
+func (n *Node) callTaps(ch *Channel, dir TapDirection, buf []byte) {
+	n.tapsMutex.RLock()
+	defer n.tapsMutex.RUnlock()
+
+	for t := range n.taps {
+		if t.endpoint == ch.Endpoint {
+			t.f(ch, dir, buf)
+		}
+	}
+}
+
+// channelTap passes the bytes read from and written to a channel to the taps,
+// and counts them.
+type channelTap struct {
+	ch *Channel
+}
+
+func (t *channelTap) Read(buf []byte) (int, error) {
+	var start time.Time
+	if t.ch.latency != nil {
+		start = time.Now()
+	}
+
+	n, err := t.ch.rwc.Read(buf)
+
+	if t.ch.latency != nil {
+		t.ch.latency.onRead(time.Since(start))
+	}
+	if n > 0 {
+		t.ch.trafficMutex.Lock()
+		t.ch.trafficStats.BytesIn += uint64(n)
+		t.ch.trafficMutex.Unlock()
+
+		t.ch.n.callTaps(t.ch, TapIn, buf[:n])
+	}
+	return n, err
+}
+
+func (t *channelTap) Write(buf []byte) (int, error) {
+	n, err := t.ch.rwc.Write(buf)
+	if n > 0 {
+		t.ch.trafficMutex.Lock()
+		t.ch.trafficStats.BytesOut += uint64(n)
+		t.ch.trafficMutex.Unlock()
+
+		t.ch.n.callTaps(t.ch, TapOut, buf[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,227 @@
+// Package terrain implements conversions of altitudes between the frames
+// used by Mavlink, i.e. above mean sea level (AMSL), relative to the home
+// position and above terrain, that are a recurring source of dangerous
+// mistakes in ground station code.
+//
+// The package provides conversions of single altitudes and of mission items,
+// a store of the terrain heights reported by vehicles with TERRAIN_REPORT,
+// and a tracker, that collects the home position and the terrain heights of
+// a vehicle through a node.
+//
+// The node used by the tracker must use a dialect that contains the common
+// messages.
+package terrain
+
+import (
+	"fmt"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+// ErrHomeUnknown is returned when a relative altitude is converted and the
+// altitude of the home position is unknown.
+var ErrHomeUnknown = fmt.Errorf("home altitude is unknown")
+
+// ErrTerrainUnknown is returned when an altitude above terrain is converted
+// and the terrain height at the position is unknown.
+var ErrTerrainUnknown = fmt.Errorf("terrain height is unknown")
+
+// Frame is an altitude frame.
+type Frame int
+
+const (
+	// FrameAMSL is the altitude above mean sea level.
+	FrameAMSL Frame = iota
+
+	// FrameRelative is the altitude relative to the home position.
+	FrameRelative
+
+	// FrameTerrain is the altitude above terrain.
+	FrameTerrain
+)
+
+var frameLabels = map[Frame]string{
+	FrameAMSL:     "AMSL",
+	FrameRelative: "Relative",
+	FrameTerrain:  "Terrain",
+}
+
+// String implements the fmt.Stringer interface.
+func (f Frame) String() string {
+	if l, ok := frameLabels[f]; ok {
+		return l
+	}
+	return fmt.Sprintf("Frame(%d)", int(f))
+}
+
+// FrameOf returns the altitude frame of a global MAV_FRAME. It returns
+// false if the MAV_FRAME is not global.
+func FrameOf(f common.MAV_FRAME) (Frame, bool) {
+	switch f {
+	case common.MAV_FRAME_GLOBAL, common.MAV_FRAME_GLOBAL_INT:
+		return FrameAMSL, true
+
+	case common.MAV_FRAME_GLOBAL_RELATIVE_ALT, common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT:
+		return FrameRelative, true
+
+	case common.MAV_FRAME_GLOBAL_TERRAIN_ALT, common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT:
+		return FrameTerrain, true
+	}
+	return 0, false
+}
+
+// MAVFrame returns the global MAV_FRAME that corresponds to the altitude
+// frame, in the variant with integer coordinates (scaled by 1e7) or with
+// float coordinates.
+func (f Frame) MAVFrame(intCoords bool) common.MAV_FRAME {
+	switch f {
+	case FrameRelative:
+		if intCoords {
+			return common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT
+		}
+		return common.MAV_FRAME_GLOBAL_RELATIVE_ALT
+
+	case FrameTerrain:
+		if intCoords {
+			return common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT
+		}
+		return common.MAV_FRAME_GLOBAL_TERRAIN_ALT
+	}
+
+	if intCoords {
+		return common.MAV_FRAME_GLOBAL_INT
+	}
+	return common.MAV_FRAME_GLOBAL
+}
+
+// Reference provides the altitudes that are needed to convert altitudes
+// between frames. It is implemented by Tracker.
+type Reference interface {
+	// HomeAltitude returns the altitude of the home position above mean
+	// sea level, in meters, or false if it is unknown.
+	HomeAltitude() (float64, bool)
+
+	// TerrainHeight returns the height of the terrain above mean sea level
+	// at a position, in meters, or false if it is unknown.
+	TerrainHeight(lat float64, lon float64) (float64, bool)
+}
+
+// toAMSL converts an altitude into an altitude above mean sea level.
+func toAMSL(ref Reference, alt float64, from Frame, lat float64, lon float64) (float64, error) {
+	switch from {
+	case FrameAMSL:
+		return alt, nil
+
+	case FrameRelative:
+		home, ok := ref.HomeAltitude()
+		if !ok {
+			return 0, ErrHomeUnknown
+		}
+		return alt + home, nil
+
+	case FrameTerrain:
+		h, ok := ref.TerrainHeight(lat, lon)
+		if !ok {
+			return 0, ErrTerrainUnknown
+		}
+		return alt + h, nil
+	}
+
+	return 0, fmt.Errorf("unsupported frame: %v", from)
+}
+
+// Convert converts an altitude, in meters, from a frame to another. The
+// position, in degrees, is used to find the terrain height.
+// It returns ErrHomeUnknown or ErrTerrainUnknown when the needed altitudes
+// are not provided by the reference; in this case, the altitude must not be
+// used.
+func Convert(ref Reference, alt float64, from Frame, to Frame, lat float64, lon float64) (float64, error) {
+	if from == to {
+		return alt, nil
+	}
+
+	amsl, err := toAMSL(ref, alt, from, lat, lon)
+	if err != nil {
+		return 0, err
+	}
+
+	switch to {
+	case FrameAMSL:
+		return amsl, nil
+
+	case FrameRelative:
+		home, ok := ref.HomeAltitude()
+		if !ok {
+			return 0, ErrHomeUnknown
+		}
+		return amsl - home, nil
+
+	case FrameTerrain:
+		h, ok := ref.TerrainHeight(lat, lon)
+		if !ok {
+			return 0, ErrTerrainUnknown
+		}
+		return amsl - h, nil
+	}
+
+	return 0, fmt.Errorf("unsupported frame: %v", to)
+}
+
+// ConvertItem converts the altitude of a mission item into another frame,
+// and updates its MAV_FRAME, keeping the variant with integer coordinates.
+// Items that are not expressed in a global frame are not modified.
+// The item is not modified when the conversion fails.
+func ConvertItem(ref Reference, item *common.MessageMissionItemInt, to Frame) error {
+	from, ok := FrameOf(item.Frame)
+	if !ok {
+		return nil
+	}
+
+	alt, err := Convert(ref, float64(item.Z), from, to,
+		float64(item.X)/1e7, float64(item.Y)/1e7)
+	if err != nil {
+		return err
+	}
+
+	intCoords := item.Frame == common.MAV_FRAME_GLOBAL_INT ||
+		item.Frame == common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT ||
+		item.Frame == common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT
+
+	item.Z = float32(alt)
+	item.Frame = to.MAVFrame(intCoords)
+	return nil
+}
+
+// ItemError is returned by ConvertMission when an item cannot be converted.
+type ItemError struct {
+	// the position of the item in the mission.
+	Seq int
+
+	// the cause, i.e. ErrHomeUnknown or ErrTerrainUnknown.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %s", e.Seq, e.Err)
+}
+
+// ConvertMission converts the altitudes of all the items of a mission into
+// another frame. Either all the items are converted or none is; in the
+// latter case, an *ItemError is returned.
+func ConvertMission(ref Reference, items []*common.MessageMissionItemInt, to Frame) error {
+	converted := make([]common.MessageMissionItemInt, len(items))
+
+	for i, item := range items {
+		converted[i] = *item
+		err := ConvertItem(ref, &converted[i], to)
+		if err != nil {
+			return &ItemError{Seq: i, Err: err}
+		}
+	}
+
+	for i, item := range items {
+		*item = converted[i]
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package terrain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+type testReference struct {
+	home      float64
+	homeValid bool
+	terrain   Terrain
+}
+
+func (r *testReference) HomeAltitude() (float64, bool) {
+	return r.home, r.homeValid
+}
+
+func (r *testReference) TerrainHeight(lat float64, lon float64) (float64, bool) {
+	return r.terrain.Height(lat, lon)
+}
+
+func newTestReference() *testReference {
+	r := &testReference{home: 100, homeValid: true}
+	r.terrain.Add(&common.MessageTerrainReport{
+		Lat:           450000000,
+		Lon:           90000000,
+		Spacing:       100,
+		TerrainHeight: 130,
+	})
+	return r
+}
+
+func TestFrameOf(t *testing.T) {
+	for _, ca := range []struct {
+		mav       common.MAV_FRAME
+		frame     Frame
+		intCoords bool
+	}{
+		{common.MAV_FRAME_GLOBAL, FrameAMSL, false},
+		{common.MAV_FRAME_GLOBAL_INT, FrameAMSL, true},
+		{common.MAV_FRAME_GLOBAL_RELATIVE_ALT, FrameRelative, false},
+		{common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT, FrameRelative, true},
+		{common.MAV_FRAME_GLOBAL_TERRAIN_ALT, FrameTerrain, false},
+		{common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT, FrameTerrain, true},
+	} {
+		f, ok := FrameOf(ca.mav)
+		require.True(t, ok)
+		require.Equal(t, ca.frame, f)
+		require.Equal(t, ca.mav, f.MAVFrame(ca.intCoords))
+	}
+
+	_, ok := FrameOf(common.MAV_FRAME_LOCAL_NED)
+	require.False(t, ok)
+
+	require.Equal(t, "Terrain", FrameTerrain.String())
+	require.Equal(t, "Frame(5)", Frame(5).String())
+}
+
+func TestConvert(t *testing.T) {
+	ref := newTestReference()
+
+	for _, ca := range []struct {
+		from Frame
+		to   Frame
+		in   float64
+		out  float64
+	}{
+		{FrameAMSL, FrameAMSL, 150, 150},
+		{FrameAMSL, FrameRelative, 150, 50},
+		{FrameAMSL, FrameTerrain, 150, 20},
+		{FrameRelative, FrameAMSL, 50, 150},
+		{FrameRelative, FrameTerrain, 50, 20},
+		{FrameTerrain, FrameAMSL, 20, 150},
+		{FrameTerrain, FrameRelative, 20, 50},
+	} {
+		out, err := Convert(ref, ca.in, ca.from, ca.to, 45.0003, 9)
+		require.NoError(t, err)
+		require.InDelta(t, ca.out, out, 1e-9)
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	ref := newTestReference()
+
+	// outside of the grid spacing of the report
+	_, err := Convert(ref, 20, FrameTerrain, FrameAMSL, 45.01, 9)
+	require.Equal(t, ErrTerrainUnknown, err)
+
+	_, err = Convert(ref, 150, FrameAMSL, FrameTerrain, 45.01, 9)
+	require.Equal(t, ErrTerrainUnknown, err)
+
+	ref.homeValid = false
+
+	_, err = Convert(ref, 50, FrameRelative, FrameAMSL, 45, 9)
+	require.Equal(t, ErrHomeUnknown, err)
+
+	_, err = Convert(ref, 20, FrameTerrain, FrameRelative, 45, 9)
+	require.Equal(t, ErrHomeUnknown, err)
+
+	// conversions that do not need the home altitude are still possible
+	out, err := Convert(ref, 20, FrameTerrain, FrameAMSL, 45, 9)
+	require.NoError(t, err)
+	require.Equal(t, float64(150), out)
+}
+
+func TestConvertMission(t *testing.T) {
+	ref := newTestReference()
+
+	items := []*common.MessageMissionItemInt{
+		{
+			Seq:     0,
+			Frame:   common.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+			Command: common.MAV_CMD_NAV_TAKEOFF,
+			X:       450000000,
+			Y:       90000000,
+			Z:       30,
+		},
+		{
+			Seq:     1,
+			Frame:   common.MAV_FRAME_MISSION,
+			Command: common.MAV_CMD_DO_CHANGE_SPEED,
+			Param2:  5,
+		},
+		{
+			Seq:     2,
+			Frame:   common.MAV_FRAME_GLOBAL,
+			Command: common.MAV_CMD_NAV_WAYPOINT,
+			X:       450003000,
+			Y:       90000000,
+			Z:       160,
+		},
+	}
+
+	err := ConvertMission(ref, items, FrameTerrain)
+	require.NoError(t, err)
+
+	require.Equal(t, common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT, items[0].Frame)
+	require.Equal(t, float32(0), items[0].Z)
+	require.Equal(t, common.MAV_FRAME_MISSION, items[1].Frame)
+	require.Equal(t, common.MAV_FRAME_GLOBAL_TERRAIN_ALT, items[2].Frame)
+	require.Equal(t, float32(30), items[2].Z)
+
+	// an item outside of the known terrain prevents the conversion of all
+	// the items
+	items[2].X = 451000000
+	err = ConvertMission(ref, items, FrameRelative)
+	require.Equal(t, &ItemError{Seq: 2, Err: ErrTerrainUnknown}, err)
+	require.Equal(t, common.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT, items[0].Frame)
+	require.Equal(t, float32(0), items[0].Z)
+}
+
+func TestTerrain(t *testing.T) {
+	var tr Terrain
+
+	tr.Add(&common.MessageTerrainReport{Lat: 450000000, Lon: 90000000, Spacing: 0, TerrainHeight: 10})
+	_, ok := tr.Height(45, 9)
+	require.False(t, ok)
+
+	tr.Add(&common.MessageTerrainReport{Lat: 450000000, Lon: 90000000, Spacing: 100, TerrainHeight: 10})
+	tr.Add(&common.MessageTerrainReport{Lat: 450009000, Lon: 90000000, Spacing: 100, TerrainHeight: 20})
+
+	// the nearest report is used
+	h, ok := tr.Height(45.0003, 9)
+	require.True(t, ok)
+	require.Equal(t, float64(10), h)
+
+	h, ok = tr.Height(45.0006, 9)
+	require.True(t, ok)
+	require.Equal(t, float64(20), h)
+
+	// reports of the same position are replaced
+	tr.Add(&common.MessageTerrainReport{Lat: 450000000, Lon: 90000000, Spacing: 100, TerrainHeight: 15})
+	h, _ = tr.Height(45, 9)
+	require.Equal(t, float64(15), h)
+	require.Equal(t, 2, len(tr.points))
+}
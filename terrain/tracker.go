@@ -0,0 +1,282 @@
+package terrain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	earthRadius = 6371000
+
+	// maximum number of terrain heights that are kept by a Terrain.
+	maxTerrainPoints = 4096
+
+	// interval between TERRAIN_CHECK messages sent by Tracker.Check.
+	checkRetryInterval = 1 * time.Second
+)
+
+// distance returns the distance between two positions, in meters.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	// haversine formula
+	rlat1 := lat1 * math.Pi / 180
+	rlat2 := lat2 * math.Pi / 180
+	dlat := rlat2 - rlat1
+	dlon := (lon2 - lon1) * math.Pi / 180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+type terrainPoint struct {
+	lat     float64
+	lon     float64
+	height  float64
+	spacing float64
+}
+
+// Terrain stores the terrain heights reported by a vehicle with
+// TERRAIN_REPORT. The height at a position is the one of the nearest report
+// whose distance is within the grid spacing of the report.
+// It can be used by multiple routines.
+type Terrain struct {
+	mutex  sync.Mutex
+	points []terrainPoint
+}
+
+// Add adds the terrain height of a TERRAIN_REPORT. Reports whose spacing
+// is zero, that mean that the terrain is unavailable, are ignored.
+// The oldest heights are discarded when the store is full.
+func (t *Terrain) Add(r *common.MessageTerrainReport) {
+	if r.Spacing == 0 {
+		return
+	}
+
+	p := terrainPoint{
+		lat:     float64(r.Lat) / 1e7,
+		lon:     float64(r.Lon) / 1e7,
+		height:  float64(r.TerrainHeight),
+		spacing: float64(r.Spacing),
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// replace the report of the same position
+	for i, e := range t.points {
+		if e.lat == p.lat && e.lon == p.lon {
+			t.points = append(t.points[:i], t.points[i+1:]...)
+			break
+		}
+	}
+
+	if len(t.points) >= maxTerrainPoints {
+		t.points = t.points[1:]
+	}
+	t.points = append(t.points, p)
+}
+
+// Height returns the terrain height above mean sea level at a position,
+// in meters, or false if it is unknown.
+func (t *Terrain) Height(lat float64, lon float64) (float64, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	best := -1
+	bestDist := math.Inf(1)
+
+	for i, p := range t.points {
+		d := distance(lat, lon, p.lat, p.lon)
+		if d <= p.spacing && d < bestDist {
+			best = i
+			bestDist = d
+		}
+	}
+
+	if best < 0 {
+		return 0, false
+	}
+	return t.points[best].height, true
+}
+
+// TrackerConf allows to configure a Tracker.
+type TrackerConf struct {
+	// the node used to communicate with the vehicle.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the autopilot of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+}
+
+// Tracker collects the altitude of the home position (HOME_POSITION) and
+// the terrain heights (TERRAIN_REPORT) of a vehicle, and implements
+// Reference.
+type Tracker struct {
+	conf          TrackerConf
+	removeHandler func()
+	terrain       Terrain
+
+	mutex     sync.Mutex
+	home      float64
+	homeValid bool
+	reported  chan struct{}
+}
+
+// NewTracker allocates a Tracker. See TrackerConf for the options.
+func NewTracker(conf TrackerConf) (*Tracker, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHomePosition{},
+		&common.MessageTerrainReport{},
+		&common.MessageTerrainCheck{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	t := &Tracker{
+		conf:     conf,
+		reported: make(chan struct{}),
+	}
+
+	t.removeHandler = conf.Node.AddFrameHandler(t.onEventFrame)
+
+	return t, nil
+}
+
+// Close stops the tracker. It must be called before closing the node.
+func (t *Tracker) Close() {
+	t.removeHandler()
+}
+
+func (t *Tracker) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != t.conf.SystemId || evt.ComponentId() != t.conf.ComponentId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageHomePosition{}).GetId():
+		var m common.MessageHomePosition
+		if msg.Convert(&m, evt.Message()) == nil {
+			t.SetHomeAltitude(float64(m.Altitude) / 1000)
+		}
+
+	case (&common.MessageTerrainReport{}).GetId():
+		var m common.MessageTerrainReport
+		if msg.Convert(&m, evt.Message()) == nil {
+			t.terrain.Add(&m)
+
+			// wake up routines that are waiting for a report
+			t.mutex.Lock()
+			close(t.reported)
+			t.reported = make(chan struct{})
+			t.mutex.Unlock()
+		}
+	}
+}
+
+// SetHomeAltitude sets the altitude of the home position above mean sea
+// level, in meters, i.e. when HOME_POSITION is not sent by the vehicle.
+// It is replaced by the next HOME_POSITION.
+func (t *Tracker) SetHomeAltitude(alt float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.home = alt
+	t.homeValid = true
+}
+
+// HomeAltitude implements Reference.
+func (t *Tracker) HomeAltitude() (float64, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.home, t.homeValid
+}
+
+// TerrainHeight implements Reference.
+func (t *Tracker) TerrainHeight(lat float64, lon float64) (float64, bool) {
+	return t.terrain.Height(lat, lon)
+}
+
+// Check returns the terrain height at a position. If it is unknown, it is
+// requested to the vehicle with TERRAIN_CHECK, until a TERRAIN_REPORT that
+// covers the position is received or the context is canceled.
+func (t *Tracker) Check(ctx context.Context, lat float64, lon float64) (float64, error) {
+	ticker := time.NewTicker(checkRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		t.mutex.Lock()
+		reported := t.reported
+		t.mutex.Unlock()
+
+		if h, ok := t.terrain.Height(lat, lon); ok {
+			return h, nil
+		}
+
+		t.conf.Node.WriteMessageAll(&common.MessageTerrainCheck{
+			Lat: int32(math.Round(lat * 1e7)),
+			Lon: int32(math.Round(lon * 1e7)),
+		})
+
+	wait:
+		for {
+			select {
+			case <-reported:
+				t.mutex.Lock()
+				reported = t.reported
+				t.mutex.Unlock()
+
+				if h, ok := t.terrain.Height(lat, lon); ok {
+					return h, nil
+				}
+
+			case <-ticker.C:
+				break wait
+
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+	}
+}
+
+// CheckMission requests the terrain heights at the positions of the items
+// of a mission that are expressed in a global frame, with Check, in order to
+// convert their altitudes from or into FrameTerrain.
+func (t *Tracker) CheckMission(ctx context.Context, items []*common.MessageMissionItemInt) error {
+	for _, item := range items {
+		if _, ok := FrameOf(item.Frame); !ok {
+			continue
+		}
+
+		_, err := t.Check(ctx, float64(item.X)/1e7, float64(item.Y)/1e7)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package terrain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+func TestNewTrackerErrors(t *testing.T) {
+	_, err := NewTracker(TrackerConf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+
+	go func() {
+		for range vehicle.Events() {
+		}
+	}()
+
+	_, err = NewTracker(TrackerConf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+}
+
+func TestTracker(t *testing.T) {
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+
+	checks := make(chan *common.MessageTerrainCheck, 10)
+
+	// the vehicle answers TERRAIN_CHECK with TERRAIN_REPORT, after the
+	// first request, that is lost
+	go func() {
+		n := 0
+		for evt := range vehicle.Events() {
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if m, ok := fr.Message().(*common.MessageTerrainCheck); ok {
+					checks <- m
+					n++
+					if n >= 2 {
+						vehicle.WriteMessageAll(&common.MessageTerrainReport{
+							Lat:           m.Lat,
+							Lon:           m.Lon,
+							Spacing:       100,
+							TerrainHeight: 230,
+						})
+					}
+				}
+			}
+		}
+	}()
+
+	tr, err := NewTracker(TrackerConf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	_, ok := tr.HomeAltitude()
+	require.False(t, ok)
+
+	vehicle.WriteMessageAll(&common.MessageHomePosition{Altitude: 200500})
+
+	for i := 0; ; i++ {
+		if _, ok := tr.HomeAltitude(); ok {
+			break
+		}
+		require.True(t, i < 50)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	home, _ := tr.HomeAltitude()
+	require.Equal(t, 200.5, home)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	h, err := tr.Check(ctx, 45, 9)
+	require.NoError(t, err)
+	require.Equal(t, float64(230), h)
+	require.Equal(t, 2, len(checks))
+	require.Equal(t, &common.MessageTerrainCheck{Lat: 450000000, Lon: 90000000}, <-checks)
+
+	// known heights are not requested again
+	h, err = tr.Check(ctx, 45.0001, 9)
+	require.NoError(t, err)
+	require.Equal(t, float64(230), h)
+	require.Equal(t, 1, len(checks))
+
+	alt, err := Convert(tr, 50, FrameTerrain, FrameRelative, 45, 9)
+	require.NoError(t, err)
+	require.Equal(t, 79.5, alt)
+}
+
+func TestTrackerCheckCanceled(t *testing.T) {
+	gcs, vehicle := testnode.Pair(t)
+	defer gcs.Close()
+	defer vehicle.Close()
+	testnode.DiscardEvents(gcs)
+
+	go func() {
+		for range vehicle.Events() {
+		}
+	}()
+
+	tr, err := NewTracker(TrackerConf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = tr.Check(ctx, 45, 9)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,235 @@
+package tlog
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/transceiver"
+)
+
+// Source is a .tlog file read by a MergeReader.
+type Source struct {
+	// the path of the file
+	Path string
+
+	// (optional) the label that annotates the frames of the file, i.e. the
+	// name of the link or of the vehicle.
+	// It defaults to Path.
+	Label string
+}
+
+// MergeConf allows to configure a MergeReader.
+type MergeConf struct {
+	// the files to merge.
+	Sources []Source
+
+	// (optional) the dialect which contains the messages that will be decoded.
+	// If not provided, messages are decoded in the MessageRaw struct.
+	Dialect *dialect.Dialect
+}
+
+// Entry is a frame read by a MergeReader.
+type Entry struct {
+	// the time at which the frame was recorded
+	Time time.Time
+
+	// the label of the source of the frame
+	Source string
+
+	// the frame
+	Frame frame.Frame
+}
+
+// source is an open Source, with its next entry.
+type source struct {
+	conf  Source
+	index int
+	f     *os.File
+	tr    *Reader
+	rec   *bytes.Reader
+	trans *transceiver.Transceiver
+	de    *dialect.DecEncoder
+
+	next *Entry
+}
+
+func newTransceiver(rec io.Reader, de *dialect.DecEncoder) *transceiver.Transceiver {
+	trans, _ := transceiver.New(transceiver.TransceiverConf{
+		Reader:      rec,
+		Writer:      ioutil.Discard,
+		DialectDE:   de,
+		OutVersion:  transceiver.V2,
+		OutSystemId: 1,
+	})
+	return trans
+}
+
+// advance reads the next valid frame of the source, or sets next to nil at
+// the end of the file. Invalid frames are skipped.
+func (s *source) advance() error {
+	for {
+		ts, byts, err := s.tr.ReadRecord()
+		if err != nil {
+			// a truncated record is the end of a recording that has been
+			// interrupted
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				s.next = nil
+				return nil
+			}
+			return fmt.Errorf("%s: %s", s.conf.Label, err)
+		}
+
+		s.rec.Reset(byts)
+		fr, err := s.trans.Read()
+		if err != nil {
+			// discard the remaining bytes of the record
+			s.trans = newTransceiver(s.rec, s.de)
+			continue
+		}
+
+		s.next = &Entry{
+			Time:   time.Unix(0, int64(ts)*int64(time.Microsecond)),
+			Source: s.conf.Label,
+			Frame:  fr,
+		}
+		return nil
+	}
+}
+
+// sourceHeap contains the sources that have a next entry, ordered by the
+// time of the entry. Entries with the same time are ordered by source.
+type sourceHeap []*source
+
+func (h sourceHeap) Len() int {
+	return len(h)
+}
+
+func (h sourceHeap) Less(i, j int) bool {
+	if !h[i].next.Time.Equal(h[j].next.Time) {
+		return h[i].next.Time.Before(h[j].next.Time)
+	}
+	return h[i].index < h[j].index
+}
+
+func (h sourceHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *sourceHeap) Push(x interface{}) {
+	*h = append(*h, x.(*source))
+}
+
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// MergeReader reads several .tlog files and returns their frames in a single
+// stream, ordered by the time at which they were recorded. Frames recorded at
+// the same time are returned in the order of the sources. Frames that can't
+// be decoded are skipped.
+type MergeReader struct {
+	sources []*source
+	heap    sourceHeap
+}
+
+// NewMergeReader allocates a MergeReader. See MergeConf for the options.
+func NewMergeReader(conf MergeConf) (*MergeReader, error) {
+	if len(conf.Sources) == 0 {
+		return nil, fmt.Errorf("Sources not provided")
+	}
+
+	var de *dialect.DecEncoder
+	if conf.Dialect != nil {
+		var err error
+		de, err = dialect.NewDecEncoder(conf.Dialect)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := &MergeReader{}
+
+	for i, sconf := range conf.Sources {
+		if sconf.Path == "" {
+			r.Close()
+			return nil, fmt.Errorf("source %d: Path not provided", i)
+		}
+
+		if sconf.Label == "" {
+			sconf.Label = sconf.Path
+		}
+
+		f, err := os.Open(sconf.Path)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		rec := bytes.NewReader(nil)
+		s := &source{
+			conf:  sconf,
+			index: i,
+			f:     f,
+			tr:    NewReader(f),
+			rec:   rec,
+			trans: newTransceiver(rec, de),
+			de:    de,
+		}
+		r.sources = append(r.sources, s)
+
+		err = s.advance()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		if s.next != nil {
+			r.heap = append(r.heap, s)
+		}
+	}
+
+	heap.Init(&r.heap)
+
+	return r, nil
+}
+
+// Close closes the files.
+func (r *MergeReader) Close() error {
+	for _, s := range r.sources {
+		s.f.Close()
+	}
+	return nil
+}
+
+// Read returns the next frame. At the end of all files, it returns io.EOF.
+func (r *MergeReader) Read() (*Entry, error) {
+	if len(r.heap) == 0 {
+		return nil, io.EOF
+	}
+
+	s := r.heap[0]
+	e := s.next
+
+	err := s.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.next != nil {
+		heap.Fix(&r.heap, 0)
+	} else {
+		heap.Pop(&r.heap)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,124 @@
+package tlog
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/transceiver"
+)
+
+type testRecordSpec struct {
+	ts uint64
+	m  msg.Message
+}
+
+func writeTestFile(t *testing.T, path string, systemId byte, recs []testRecordSpec, trailer []byte) {
+	de, err := dialect.NewDecEncoder(common.Dialect)
+	require.NoError(t, err)
+
+	var frameBuf bytes.Buffer
+	trans, err := transceiver.New(transceiver.TransceiverConf{
+		Reader:      bytes.NewReader(nil),
+		Writer:      &frameBuf,
+		DialectDE:   de,
+		OutVersion:  transceiver.V2,
+		OutSystemId: systemId,
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		frameBuf.Reset()
+		err := trans.WriteMessage(rec.m)
+		require.NoError(t, err)
+		buf.Write(testRecord(rec.ts, frameBuf.Bytes()))
+	}
+	buf.Write(trailer)
+
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+	require.NoError(t, err)
+}
+
+func TestMergeReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomavlib-tlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path1 := filepath.Join(dir, "link1.tlog")
+	writeTestFile(t, path1, 1, []testRecordSpec{
+		{1000, &common.MessageHeartbeat{Type: 1}},
+		{3000, &common.MessageHeartbeat{Type: 3}},
+		{3000, &common.MessageHeartbeat{Type: 4}},
+	}, nil)
+
+	// a frame with a wrong checksum, and a record truncated by an
+	// interrupted recording
+	corrupted := testRecord(2500, []byte{0xFD, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	path2 := filepath.Join(dir, "link2.tlog")
+	writeTestFile(t, path2, 2, []testRecordSpec{
+		{2000, &common.MessageHeartbeat{Type: 2}},
+		{3000, &common.MessageHeartbeat{Type: 5}},
+	}, append(corrupted, testRecord(4000, []byte{0xFD, 0x09, 0x00})...))
+
+	r, err := NewMergeReader(MergeConf{
+		Sources: []Source{
+			{Path: path1, Label: "link1"},
+			{Path: path2},
+		},
+		Dialect: common.Dialect,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	type result struct {
+		time     time.Time
+		source   string
+		systemId byte
+		typ      common.MAV_TYPE
+	}
+	var results []result
+
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		results = append(results, result{
+			e.Time,
+			e.Source,
+			e.Frame.GetSystemId(),
+			e.Frame.GetMessage().(*common.MessageHeartbeat).Type,
+		})
+	}
+
+	require.Equal(t, []result{
+		{time.Unix(0, 1000000), "link1", 1, 1},
+		{time.Unix(0, 2000000), path2, 2, 2},
+		{time.Unix(0, 3000000), "link1", 1, 3},
+		{time.Unix(0, 3000000), "link1", 1, 4},
+		{time.Unix(0, 3000000), path2, 2, 5},
+	}, results)
+}
+
+func TestMergeReaderErrors(t *testing.T) {
+	_, err := NewMergeReader(MergeConf{})
+	require.EqualError(t, err, "Sources not provided")
+
+	_, err = NewMergeReader(MergeConf{Sources: []Source{{}}})
+	require.EqualError(t, err, "source 0: Path not provided")
+
+	_, err = NewMergeReader(MergeConf{Sources: []Source{{Path: "/nonexistent/file.tlog"}}})
+	require.Error(t, err)
+}
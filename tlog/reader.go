@@ -0,0 +1,77 @@
+// Package tlog implements readers of .tlog files, i.e. files recorded by
+// MAVProxy, QGroundControl or gomavlib.EndpointFileWriter, in which each frame
+// is preceded by its timestamp (microseconds since the Unix epoch, big endian).
+//
+// MergeReader merges several files (i.e. one per endpoint or per vehicle)
+// into a single time-ordered stream of frames, annotated with their source,
+// in order to reconstruct multi-link incidents during post-flight analysis.
+package tlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/aler9/gomavlib/frame"
+)
+
+const (
+	// frames cannot go beyond len(header) + 255 + len(check) + len(sig)
+	bufferSize = 512
+)
+
+// Reader reads the records of a .tlog file.
+type Reader struct {
+	br  *bufio.Reader
+	buf []byte
+}
+
+// NewReader allocates a Reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		br:  bufio.NewReaderSize(r, bufferSize),
+		buf: make([]byte, bufferSize),
+	}
+}
+
+// ReadRecord reads a record, and returns its timestamp, in microseconds since
+// the Unix epoch, and the encoded frame that follows it. The frame is valid
+// until the next call. At the end of the file, it returns io.EOF.
+func (r *Reader) ReadRecord() (uint64, []byte, error) {
+	var tsBuf [8]byte
+	_, err := io.ReadFull(r.br, tsBuf[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	ts := binary.BigEndian.Uint64(tsBuf[:])
+
+	magic, err := r.br.Peek(3)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// compute the frame size from its header
+	var size int
+	switch magic[0] {
+	case frame.V1MagicByte:
+		size = 6 + int(magic[1]) + 2
+
+	case frame.V2MagicByte:
+		size = 10 + int(magic[1]) + 2
+		if (magic[2] & frame.V2FlagSigned) != 0 {
+			size += 13
+		}
+
+	default:
+		return 0, nil, fmt.Errorf("invalid magic byte: %x", magic[0])
+	}
+
+	fr := r.buf[:size]
+	_, err = io.ReadFull(r.br, fr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return ts, fr, nil
+}
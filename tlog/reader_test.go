@@ -0,0 +1,53 @@
+package tlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRecord(ts uint64, fr []byte) []byte {
+	var tsBuf [8]byte
+	binary.BigEndian.PutUint64(tsBuf[:], ts)
+	return append(tsBuf[:], fr...)
+}
+
+func TestReader(t *testing.T) {
+	v1 := []byte{0xFE, 0x01, 0x00, 0x01, 0x02, 0x03, 0xAA, 0x01, 0x02}
+	v2 := []byte{0xFD, 0x01, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x00, 0x00, 0xAA, 0x01, 0x02}
+
+	var buf bytes.Buffer
+	buf.Write(testRecord(1000, v1))
+	buf.Write(testRecord(2000, v2))
+
+	r := NewReader(&buf)
+
+	ts, fr, err := r.ReadRecord()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), ts)
+	require.Equal(t, v1, fr)
+
+	ts, fr, err = r.ReadRecord()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2000), ts)
+	require.Equal(t, v2, fr)
+
+	_, _, err = r.ReadRecord()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReaderInvalidMagicByte(t *testing.T) {
+	r := NewReader(bytes.NewReader(testRecord(1000, []byte{0x01, 0x02, 0x03})))
+	_, _, err := r.ReadRecord()
+	require.EqualError(t, err, "invalid magic byte: 1")
+}
+
+func TestReaderTruncated(t *testing.T) {
+	v1 := []byte{0xFE, 0x01, 0x00, 0x01, 0x02, 0x03, 0xAA, 0x01, 0x02}
+	r := NewReader(bytes.NewReader(testRecord(1000, v1)[:12]))
+	_, _, err := r.ReadRecord()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
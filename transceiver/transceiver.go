@@ -63,15 +63,36 @@ type TransceiverConf struct {
 	// (optional) the secret key used to sign outgoing frames.
 	// This feature requires v2 frames.
 	OutKey *frame.V2Key
+	// (optional) disables the empty-byte truncation of outgoing v2 messages.
+	// Some receivers do not handle truncated messages correctly.
+	OutTruncationDisable bool
+	// (optional) the minimum length of outgoing v2 messages after empty-byte
+	// truncation. It defaults to 1.
+	OutTruncationMinLength int
+	// (optional) validates outgoing messages before encoding them. Messages
+	// with strings that don't fit into their fields or enums with unknown
+	// values are not written, and a *msg.ValidationError is returned.
+	OutValidate bool
+	// (optional) the sequence id of the first outgoing frame.
+	OutSequenceId byte
+	// (optional) the timestamp of the last signature emitted with OutKey.
+	// Outgoing signature timestamps are always greater than it, even if the
+	// system clock goes backwards.
+	OutSignatureTimestamp uint64
+
+	// (optional) a function that is called with the time spent decoding the
+	// message of each incoming frame, i.e. to collect latency statistics.
+	OnDecode func(time.Duration)
 }
 
 // Transceiver is a low-level Mavlink encoder and decoder that works with a Reader and a Writer.
 type Transceiver struct {
-	conf                 TransceiverConf
-	readBuffer           *bufio.Reader
-	writeBuffer          []byte
-	curWriteSequenceId   byte
-	curReadSignatureTime uint64
+	conf                  TransceiverConf
+	readBuffer            *bufio.Reader
+	writeBuffer           []byte
+	curWriteSequenceId    byte
+	curWriteSignatureTime uint64
+	curReadSignatureTime  uint64
 }
 
 // New allocates a Transceiver, a low level frame encoder and decoder.
@@ -96,14 +117,38 @@ func New(conf TransceiverConf) (*Transceiver, error) {
 	if conf.OutKey != nil && conf.OutVersion != V2 {
 		return nil, fmt.Errorf("OutKey requires V2 frames")
 	}
+	if conf.OutTruncationMinLength < 0 {
+		return nil, fmt.Errorf("OutTruncationMinLength must be >= 0")
+	}
+	if conf.OutTruncationMinLength < 1 {
+		conf.OutTruncationMinLength = 1
+	}
+	if conf.OutTruncationDisable {
+		// messages are at most 255 bytes long
+		conf.OutTruncationMinLength = 255
+	}
 
 	return &Transceiver{
-		conf:        conf,
-		readBuffer:  bufio.NewReaderSize(conf.Reader, bufferSize),
-		writeBuffer: make([]byte, 0, bufferSize),
+		conf:                  conf,
+		readBuffer:            bufio.NewReaderSize(conf.Reader, bufferSize),
+		writeBuffer:           make([]byte, 0, bufferSize),
+		curWriteSequenceId:    conf.OutSequenceId,
+		curWriteSignatureTime: conf.OutSignatureTimestamp,
 	}, nil
 }
 
+// OutSequenceId returns the sequence id of the next outgoing frame.
+// It must not be called in parallel with write methods.
+func (p *Transceiver) OutSequenceId() byte {
+	return p.curWriteSequenceId
+}
+
+// OutSignatureTimestamp returns the timestamp of the last outgoing signature.
+// It must not be called in parallel with write methods.
+func (p *Transceiver) OutSignatureTimestamp() uint64 {
+	return p.curWriteSignatureTime
+}
+
 // Read reads a Frame from the reader. It must not be called
 // by multiple routines in parallel.
 func (p *Transceiver) Read() (frame.Frame, error) {
@@ -156,14 +201,30 @@ func (p *Transceiver) Read() (frame.Frame, error) {
 
 	// decode message if in dialect and validate checksum
 	if p.conf.DialectDE != nil {
-		if mp, ok := p.conf.DialectDE.MessageDEs[f.GetMessage().GetId()]; ok {
-			if sum := f.GenChecksum(p.conf.DialectDE.MessageDEs[f.GetMessage().GetId()].CRCExtra()); sum != f.GetChecksum() {
+		mp, ok, err := p.conf.DialectDE.MessageDE(f.GetMessage().GetId())
+		if err != nil {
+			return nil, newTransceiverError(err.Error())
+		}
+
+		if ok {
+			if sum := f.GenChecksum(mp.CRCExtra()); sum != f.GetChecksum() {
 				return nil, newTransceiverError("wrong checksum (expected %.4x, got %.4x, id=%d)",
 					sum, f.GetChecksum(), f.GetMessage().GetId())
 			}
 
 			_, isV2 := f.(*frame.V2Frame)
+
+			var start time.Time
+			if p.conf.OnDecode != nil {
+				start = time.Now()
+			}
+
 			msg, err := mp.Decode(f.GetMessage().(*msg.MessageRaw).Content, isV2)
+
+			if p.conf.OnDecode != nil {
+				p.conf.OnDecode(time.Since(start))
+			}
+
 			if err != nil {
 				return nil, newTransceiverError(err.Error())
 			}
@@ -183,8 +244,24 @@ func (p *Transceiver) Read() (frame.Frame, error) {
 // WriteMessage writes a Message into the writer.
 // It must not be called by multiple routines in parallel.
 func (p *Transceiver) WriteMessage(message msg.Message) error {
+	return p.WriteMessageVersion(message, p.conf.OutVersion)
+}
+
+// WriteMessageVersion writes a Message into the writer, with the given version
+// in place of OutVersion.
+// It must not be called by multiple routines in parallel.
+func (p *Transceiver) WriteMessageVersion(message msg.Message, version Version) error {
+	if p.conf.OutKey != nil && version != V2 {
+		return fmt.Errorf("OutKey requires V2 frames")
+	}
+
+	// check the id before consuming a sequence id
+	if message != nil && version == V1 && msg.IsV2Only(message) {
+		return frame.ErrV2OnlyMessage
+	}
+
 	var f frame.Frame
-	if p.conf.OutVersion == V1 {
+	if version == V1 {
 		f = &frame.V1Frame{Message: message}
 	} else {
 		f = &frame.V2Frame{Message: message}
@@ -197,6 +274,19 @@ func (p *Transceiver) writeFrameAndFill(f frame.Frame) error {
 		return fmt.Errorf("message is nil")
 	}
 
+	// validate the message before consuming a sequence id
+	if p.conf.OutValidate && p.conf.DialectDE != nil {
+		if _, ok := f.GetMessage().(*msg.MessageRaw); !ok {
+			mp, ok, err := p.conf.DialectDE.MessageDE(f.GetMessage().GetId())
+			if err == nil && ok {
+				err = mp.Validate(f.GetMessage())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	// do not touch the original frame, but work with a separate object
 	// in such way that the frame can be encoded by other parsers in parallel
 	safeFrame := f.Clone()
@@ -230,13 +320,16 @@ func (p *Transceiver) writeFrameAndFill(f frame.Frame) error {
 			return fmt.Errorf("message cannot be encoded since dialect is nil")
 		}
 
-		mp, ok := p.conf.DialectDE.MessageDEs[safeFrame.GetMessage().GetId()]
+		mp, ok, err := p.conf.DialectDE.MessageDE(safeFrame.GetMessage().GetId())
+		if err != nil {
+			return err
+		}
 		if !ok {
 			return fmt.Errorf("message cannot be encoded since it is not in the dialect")
 		}
 
 		_, isV2 := safeFrame.(*frame.V2Frame)
-		byt, err := mp.Encode(safeFrame.GetMessage(), isV2)
+		byt, err := mp.EncodeWithMinLength(safeFrame.GetMessage(), isV2, p.conf.OutTruncationMinLength)
 		if err != nil {
 			return err
 		}
@@ -252,17 +345,23 @@ func (p *Transceiver) writeFrameAndFill(f frame.Frame) error {
 		// fill checksum
 		switch ff := safeFrame.(type) {
 		case *frame.V1Frame:
-			ff.Checksum = ff.GenChecksum(p.conf.DialectDE.MessageDEs[ff.GetMessage().GetId()].CRCExtra())
+			ff.Checksum = ff.GenChecksum(mp.CRCExtra())
 		case *frame.V2Frame:
-			ff.Checksum = ff.GenChecksum(p.conf.DialectDE.MessageDEs[ff.GetMessage().GetId()].CRCExtra())
+			ff.Checksum = ff.GenChecksum(mp.CRCExtra())
 		}
 	}
 
 	// fill SignatureLinkId, SignatureTimestamp, Signature if v2
 	if ff, ok := safeFrame.(*frame.V2Frame); ok && p.conf.OutKey != nil {
 		ff.SignatureLinkId = p.conf.OutSignatureLinkId
-		// Timestamp in 10 microsecond units since 1st January 2015 GMT time
-		ff.SignatureTimestamp = uint64(time.Since(signatureReferenceDate)) / 10000
+		// Timestamp in 10 microsecond units since 1st January 2015 GMT time.
+		// Timestamps must increase, otherwise frames are discarded by receivers
+		ts := uint64(time.Since(signatureReferenceDate)) / 10000
+		if ts <= p.curWriteSignatureTime {
+			ts = p.curWriteSignatureTime + 1
+		}
+		p.curWriteSignatureTime = ts
+		ff.SignatureTimestamp = ts
 		ff.Signature = ff.GenSignature(p.conf.OutKey)
 	}
 
@@ -285,13 +384,16 @@ func (p *Transceiver) WriteFrame(f frame.Frame) error {
 			return fmt.Errorf("message cannot be encoded since dialect is nil")
 		}
 
-		mp, ok := p.conf.DialectDE.MessageDEs[m.GetId()]
+		mp, ok, err := p.conf.DialectDE.MessageDE(m.GetId())
+		if err != nil {
+			return err
+		}
 		if !ok {
 			return fmt.Errorf("message cannot be encoded since it is not in the dialect")
 		}
 
 		_, isV2 := f.(*frame.V2Frame)
-		byt, err := mp.Encode(m, isV2)
+		byt, err := mp.EncodeWithMinLength(m, isV2, p.conf.OutTruncationMinLength)
 		if err != nil {
 			return err
 		}
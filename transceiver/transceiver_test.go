@@ -400,3 +400,166 @@ func TestTransceiverWriteFrameIsConst(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, f, original)
 }
+
+func TestTransceiverWriteMessageTruncation(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		disable   bool
+		minLength int
+		length    int
+	}{
+		{"default", false, 0, 1},
+		{"min length", false, 3, 3},
+		{"disabled", true, 0, 5},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			transceiver, err := New(TransceiverConf{
+				Reader:                 bytes.NewBuffer(nil),
+				Writer:                 buf,
+				DialectDE:              testDialectDE,
+				OutVersion:             V2,
+				OutSystemId:            1,
+				OutTruncationDisable:   c.disable,
+				OutTruncationMinLength: c.minLength,
+			})
+			require.NoError(t, err)
+
+			err = transceiver.WriteMessage(&MessageTest5{TestUint: 1})
+			require.NoError(t, err)
+			require.Equal(t, byte(c.length), buf.Bytes()[1])
+
+			transceiver, err = New(TransceiverConf{
+				Reader:      buf,
+				Writer:      bytes.NewBuffer(nil),
+				DialectDE:   testDialectDE,
+				OutVersion:  V2,
+				OutSystemId: 1,
+			})
+			require.NoError(t, err)
+
+			f, err := transceiver.Read()
+			require.NoError(t, err)
+			require.Equal(t, &MessageTest5{TestUint: 1}, f.GetMessage())
+		})
+	}
+}
+
+func TestTransceiverWriteMessageInitialState(t *testing.T) {
+	key := frame.NewV2Key(bytes.Repeat([]byte("\x4F"), 32))
+
+	// a timestamp in the future, as if the clock had gone backwards
+	future := uint64(time.Since(signatureReferenceDate))/10000 + 1000000
+
+	buf := bytes.NewBuffer(nil)
+	transceiver, err := New(TransceiverConf{
+		Reader:                bytes.NewBuffer(nil),
+		Writer:                buf,
+		DialectDE:             testDialectDE,
+		OutVersion:            V2,
+		OutSystemId:           1,
+		OutKey:                key,
+		OutSequenceId:         255,
+		OutSignatureTimestamp: future,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = transceiver.WriteMessage(&MessageTest5{TestUint: 1})
+		require.NoError(t, err)
+	}
+	require.Equal(t, byte(1), transceiver.OutSequenceId())
+	require.Equal(t, future+2, transceiver.OutSignatureTimestamp())
+
+	transceiver, err = New(TransceiverConf{
+		Reader:      buf,
+		Writer:      bytes.NewBuffer(nil),
+		DialectDE:   testDialectDE,
+		InKey:       key,
+		OutVersion:  V2,
+		OutSystemId: 1,
+	})
+	require.NoError(t, err)
+
+	for i, seq := range []byte{255, 0} {
+		f, err := transceiver.Read()
+		require.NoError(t, err)
+		require.Equal(t, seq, f.(*frame.V2Frame).SequenceId)
+		require.Equal(t, future+uint64(i)+1, f.(*frame.V2Frame).SignatureTimestamp)
+	}
+}
+
+func TestTransceiverWriteMessageValidate(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	transceiver, err := New(TransceiverConf{
+		Reader:      bytes.NewBuffer(nil),
+		Writer:      buf,
+		DialectDE:   testDialectDE,
+		OutVersion:  V2,
+		OutSystemId: 1,
+		OutValidate: true,
+	})
+	require.NoError(t, err)
+
+	err = transceiver.WriteMessage(&MessageHeartbeat{Type: 300})
+	require.EqualError(t, err, "message HEARTBEAT, field type: value 300 overflows uint8_t")
+	_, ok := err.(*msg.ValidationError)
+	require.Equal(t, true, ok)
+	require.Equal(t, 0, buf.Len())
+	require.Equal(t, byte(0), transceiver.OutSequenceId())
+
+	err = transceiver.WriteMessage(&MessageHeartbeat{Type: 1})
+	require.NoError(t, err)
+	require.NotEqual(t, 0, buf.Len())
+}
+
+func TestTransceiverWriteMessageV2Only(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	transceiver, err := New(TransceiverConf{
+		Reader:      bytes.NewBuffer(nil),
+		Writer:      buf,
+		DialectDE:   testDialectDE,
+		OutVersion:  V1,
+		OutSystemId: 1,
+	})
+	require.NoError(t, err)
+
+	err = transceiver.WriteMessage(&MessageTest6{TestUint: 1})
+	require.Equal(t, frame.ErrV2OnlyMessage, err)
+	require.Equal(t, 0, buf.Len())
+	require.Equal(t, byte(0), transceiver.OutSequenceId())
+
+	err = transceiver.WriteMessageVersion(&MessageTest6{TestUint: 1}, V2)
+	require.NoError(t, err)
+	require.NotEqual(t, 0, buf.Len())
+}
+
+func TestTransceiverOnDecode(t *testing.T) {
+	// first case with a dialect
+	c := casesTransceiver[0]
+	for _, ca := range casesTransceiver {
+		if ca.dialectDE != nil {
+			c = ca
+			break
+		}
+	}
+
+	var durations []time.Duration
+
+	transceiver, err := New(TransceiverConf{
+		Reader:      bytes.NewReader(c.raw),
+		Writer:      bytes.NewBuffer(nil),
+		DialectDE:   c.dialectDE,
+		OutVersion:  V2,
+		OutSystemId: 1,
+		InKey:       c.key,
+		OnDecode: func(d time.Duration) {
+			durations = append(durations, d)
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = transceiver.Read()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(durations))
+}
@@ -0,0 +1,249 @@
+// Package translate implements a translator that rewrites messages between
+// variants of a private dialect, i.e. when a field has been renamed, a field
+// has been moved into the extensions or a message has changed id.
+// It allows routers to connect systems that use different firmware versions.
+//
+// Frames are usually routed by a node without a dialect, that does not decode
+// messages; the translator decodes the messages that match its rules and
+// encodes them again with the corresponding messages of the other variant.
+package translate
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+)
+
+// Rule describes how a message of the source variant is translated into a
+// message of the destination variant.
+type Rule struct {
+	// a message of the source variant.
+	From msg.Message
+
+	// the corresponding message of the destination variant.
+	// It can have a different id.
+	To msg.Message
+
+	// (optional) the fields of To that are filled with fields of From with
+	// a different name, in format To field name -> From field name.
+	// Fields with the same name are copied automatically, while fields that
+	// are not present in From are left empty.
+	Fields map[string]string
+
+	// (optional) a function that is called after copying the fields, that
+	// allows to perform arbitrary changes on the translated message.
+	Func func(to msg.Message, from msg.Message)
+}
+
+type rule struct {
+	Rule
+	fromType reflect.Type
+	toType   reflect.Type
+	fromDE   *msg.DecEncoder
+	toDE     *msg.DecEncoder
+
+	// index of the field of From that fills each field of To, or -1
+	sources []int
+}
+
+// Translator translates messages and frames from a dialect variant to another.
+// Translation in the opposite direction requires another Translator.
+type Translator struct {
+	rules map[uint32]*rule
+}
+
+// New allocates a Translator.
+func New(rules []Rule) (*Translator, error) {
+	t := &Translator{
+		rules: make(map[uint32]*rule),
+	}
+
+	for _, r := range rules {
+		if r.From == nil || r.To == nil {
+			return nil, fmt.Errorf("From and To must be provided")
+		}
+
+		if _, ok := t.rules[r.From.GetId()]; ok {
+			return nil, fmt.Errorf("duplicate rule for message with id %d", r.From.GetId())
+		}
+
+		fromDE, err := msg.NewDecEncoder(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("message %T: %s", r.From, err)
+		}
+
+		toDE, err := msg.NewDecEncoder(r.To)
+		if err != nil {
+			return nil, fmt.Errorf("message %T: %s", r.To, err)
+		}
+
+		ru := &rule{
+			Rule:     r,
+			fromType: reflect.TypeOf(r.From).Elem(),
+			toType:   reflect.TypeOf(r.To).Elem(),
+			fromDE:   fromDE,
+			toDE:     toDE,
+		}
+
+		for name := range r.Fields {
+			if _, ok := ru.toType.FieldByName(name); !ok {
+				return nil, fmt.Errorf("message %T does not contain field %s", r.To, name)
+			}
+		}
+
+		for i := 0; i < ru.toType.NumField(); i++ {
+			tf := ru.toType.Field(i)
+
+			name := tf.Name
+			if n, ok := r.Fields[name]; ok {
+				name = n
+			}
+
+			ff, ok := ru.fromType.FieldByName(name)
+			if !ok {
+				if _, explicit := r.Fields[tf.Name]; explicit {
+					return nil, fmt.Errorf("message %T does not contain field %s", r.From, name)
+				}
+				ru.sources = append(ru.sources, -1)
+				continue
+			}
+
+			if !typesCompatible(tf.Type, ff.Type) {
+				return nil, fmt.Errorf("fields %s and %s have incompatible types (%s vs %s)",
+					tf.Name, ff.Name, tf.Type, ff.Type)
+			}
+
+			ru.sources = append(ru.sources, ff.Index[0])
+		}
+
+		t.rules[r.From.GetId()] = ru
+	}
+
+	return t, nil
+}
+
+func typesCompatible(dst reflect.Type, src reflect.Type) bool {
+	if dst.Kind() == reflect.Array {
+		return src.Kind() == reflect.Array && src.Len() == dst.Len() &&
+			typesCompatible(dst.Elem(), src.Elem())
+	}
+
+	// enums are converted into enums of another dialect
+	return dst.Kind() == src.Kind() && src.ConvertibleTo(dst)
+}
+
+func copyValue(dst reflect.Value, src reflect.Value) {
+	if dst.Kind() == reflect.Array {
+		for i := 0; i < dst.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+		return
+	}
+	dst.Set(src.Convert(dst.Type()))
+}
+
+func (r *rule) translate(from msg.Message) msg.Message {
+	fv := reflect.ValueOf(from).Elem()
+	tv := reflect.New(r.toType)
+	te := tv.Elem()
+
+	for i, src := range r.sources {
+		if src >= 0 {
+			copyValue(te.Field(i), fv.Field(src))
+		}
+	}
+
+	to := tv.Interface().(msg.Message)
+	if r.Func != nil {
+		r.Func(to, from)
+	}
+	return to
+}
+
+// TranslateMessage translates a message of the source variant.
+// If there's no rule for the message, the message itself is returned.
+func (t *Translator) TranslateMessage(m msg.Message) (msg.Message, error) {
+	r, ok := t.rules[m.GetId()]
+	if !ok {
+		return m, nil
+	}
+
+	if reflect.TypeOf(m).Elem() != r.fromType {
+		return nil, fmt.Errorf("message has type %T, while the rule requires %T", m, r.From)
+	}
+
+	return r.translate(m), nil
+}
+
+// TranslateFrame translates a frame whose message belongs to the source
+// variant. The message can be decoded or raw (MessageRaw); in the latter case
+// the checksum is validated with the source message.
+// If there's no rule for the message, the frame itself is returned.
+// Otherwise, a new frame is returned, that contains the encoded translated
+// message and a new checksum. Since the signature of a frame would become
+// invalid, translated frames are not signed.
+func (t *Translator) TranslateFrame(f frame.Frame) (frame.Frame, error) {
+	r, ok := t.rules[f.GetMessage().GetId()]
+	if !ok {
+		return f, nil
+	}
+
+	_, isV2 := f.(*frame.V2Frame)
+
+	from := f.GetMessage()
+	if raw, ok := from.(*msg.MessageRaw); ok {
+		if sum := f.GenChecksum(r.fromDE.CRCExtra()); sum != f.GetChecksum() {
+			return nil, fmt.Errorf("wrong checksum (expected %.4x, got %.4x, id=%d)",
+				sum, f.GetChecksum(), raw.Id)
+		}
+
+		var err error
+		from, err = r.fromDE.Decode(raw.Content, isV2)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	to, err := t.TranslateMessage(from)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := r.toDE.Encode(to, isV2)
+	if err != nil {
+		return nil, err
+	}
+	raw := &msg.MessageRaw{Id: to.GetId(), Content: content}
+
+	switch ff := f.(type) {
+	case *frame.V1Frame:
+		if msg.IsV2Only(to) {
+			return nil, fmt.Errorf("message %T can't be sent with a V1 frame", to)
+		}
+
+		nf := &frame.V1Frame{
+			SequenceId:  ff.SequenceId,
+			SystemId:    ff.SystemId,
+			ComponentId: ff.ComponentId,
+			Message:     raw,
+		}
+		nf.Checksum = nf.GenChecksum(r.toDE.CRCExtra())
+		return nf, nil
+
+	case *frame.V2Frame:
+		nf := &frame.V2Frame{
+			IncompatibilityFlag: ff.IncompatibilityFlag &^ frame.V2FlagSigned,
+			CompatibilityFlag:   ff.CompatibilityFlag,
+			SequenceId:          ff.SequenceId,
+			SystemId:            ff.SystemId,
+			ComponentId:         ff.ComponentId,
+			Message:             raw,
+		}
+		nf.Checksum = nf.GenChecksum(r.toDE.CRCExtra())
+		return nf, nil
+	}
+
+	return nil, fmt.Errorf("unsupported frame")
+}
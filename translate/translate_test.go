@@ -0,0 +1,147 @@
+package translate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib/dialect"
+	"github.com/aler9/gomavlib/frame"
+	"github.com/aler9/gomavlib/msg"
+	"github.com/aler9/gomavlib/transceiver"
+)
+
+type MessageBatteryOld struct {
+	Voltage     uint16
+	Temperature int16
+}
+
+func (*MessageBatteryOld) GetId() uint32 {
+	return 180
+}
+
+// the voltage has been renamed and the current has been added as extension
+type MessageBatteryNew struct {
+	VoltageMv   uint16
+	Temperature int16
+	Current     uint16 `mavext:"true"`
+}
+
+func (*MessageBatteryNew) GetId() uint32 {
+	return 181
+}
+
+func testTranslator(t *testing.T) *Translator {
+	tr, err := New([]Rule{{
+		From:   &MessageBatteryOld{},
+		To:     &MessageBatteryNew{},
+		Fields: map[string]string{"VoltageMv": "Voltage"},
+		Func: func(to msg.Message, from msg.Message) {
+			to.(*MessageBatteryNew).Current = 0xFFFF
+		},
+	}})
+	require.NoError(t, err)
+	return tr
+}
+
+func TestNewError(t *testing.T) {
+	_, err := New([]Rule{{From: &MessageBatteryOld{}}})
+	require.EqualError(t, err, "From and To must be provided")
+
+	_, err = New([]Rule{{
+		From:   &MessageBatteryOld{},
+		To:     &MessageBatteryNew{},
+		Fields: map[string]string{"VoltageMv": "Missing"},
+	}})
+	require.EqualError(t, err, "message *translate.MessageBatteryOld does not contain field Missing")
+
+	_, err = New([]Rule{
+		{From: &MessageBatteryOld{}, To: &MessageBatteryNew{}},
+		{From: &MessageBatteryOld{}, To: &MessageBatteryNew{}},
+	})
+	require.EqualError(t, err, "duplicate rule for message with id 180")
+}
+
+func TestTranslateMessage(t *testing.T) {
+	tr := testTranslator(t)
+
+	m, err := tr.TranslateMessage(&MessageBatteryOld{Voltage: 12000, Temperature: -5})
+	require.NoError(t, err)
+	require.Equal(t, &MessageBatteryNew{VoltageMv: 12000, Temperature: -5, Current: 0xFFFF}, m)
+
+	// messages without rules are not translated
+	other := &msg.MessageRaw{Id: 1, Content: []byte{1}}
+	m, err = tr.TranslateMessage(other)
+	require.NoError(t, err)
+	require.True(t, m == other)
+}
+
+func TestTranslateFrame(t *testing.T) {
+	tr := testTranslator(t)
+
+	for _, ver := range []transceiver.Version{transceiver.V1, transceiver.V2} {
+		// encode with the old variant
+		oldDE, err := dialect.NewDecEncoder(&dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageBatteryOld{}}})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		tx, err := transceiver.New(transceiver.TransceiverConf{
+			Reader:      bytes.NewReader(nil),
+			Writer:      &buf,
+			DialectDE:   oldDE,
+			OutVersion:  ver,
+			OutSystemId: 1,
+		})
+		require.NoError(t, err)
+		err = tx.WriteMessage(&MessageBatteryOld{Voltage: 12000, Temperature: -5})
+		require.NoError(t, err)
+
+		// route without decoding
+		rx, err := transceiver.New(transceiver.TransceiverConf{
+			Reader:      &buf,
+			Writer:      &buf,
+			OutVersion:  ver,
+			OutSystemId: 2,
+		})
+		require.NoError(t, err)
+		f, err := rx.Read()
+		require.NoError(t, err)
+
+		f, err = tr.TranslateFrame(f)
+		require.NoError(t, err)
+		err = rx.WriteFrame(f)
+		require.NoError(t, err)
+
+		// decode with the new variant
+		newDE, err := dialect.NewDecEncoder(&dialect.Dialect{Version: 3, Messages: []msg.Message{&MessageBatteryNew{}}})
+		require.NoError(t, err)
+		rx2, err := transceiver.New(transceiver.TransceiverConf{
+			Reader:      &buf,
+			Writer:      &buf,
+			DialectDE:   newDE,
+			OutVersion:  ver,
+			OutSystemId: 3,
+		})
+		require.NoError(t, err)
+		f, err = rx2.Read()
+		require.NoError(t, err)
+
+		expected := &MessageBatteryNew{VoltageMv: 12000, Temperature: -5}
+		if ver == transceiver.V2 {
+			expected.Current = 0xFFFF
+		}
+		require.Equal(t, expected, f.GetMessage())
+		require.Equal(t, byte(1), f.GetSystemId())
+	}
+}
+
+func TestTranslateFrameWrongChecksum(t *testing.T) {
+	tr := testTranslator(t)
+
+	_, err := tr.TranslateFrame(&frame.V2Frame{
+		Message:  &msg.MessageRaw{Id: 180, Content: []byte{1, 2, 3, 4}},
+		Checksum: 0x1234,
+	})
+	require.Error(t, err)
+}
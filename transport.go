@@ -0,0 +1,326 @@
+package gomavlib
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Transport is the interface implemented by transports, that allow to
+// add support for new kinds of links (i.e. LoRa modems, proprietary radios)
+// without modifying the node. Transports are registered with
+// RegisterTransport, usually in the init() function of the package that
+// provides them, and are used with EndpointTransport.
+//
+// A transport must also implement TransportDialer, TransportListener or
+// both.
+type Transport interface {
+	// Label returns a short name of the transport, that is used to build
+	// labels of channels.
+	Label() string
+}
+
+// TransportDialer is implemented by transports that establish connections
+// with a remote system, like client endpoints.
+type TransportDialer interface {
+	Transport
+
+	// Dial establishes a connection with the given address, whose format
+	// depends on the transport. It returns a TransportConn.
+	// When the connection returns an error, it is closed and Dial is called
+	// again, according to EndpointTransport.Reconnect.
+	Dial(address string) (TransportConn, error)
+}
+
+// TransportListener is implemented by transports that accept connections
+// from remote systems, like server endpoints.
+type TransportListener interface {
+	Transport
+
+	// Listen starts accepting connections on the given address, whose format
+	// depends on the transport.
+	Listen(address string) (TransportAcceptor, error)
+}
+
+// TransportAcceptor accepts connections of a TransportListener.
+type TransportAcceptor interface {
+	// Accept waits for the next connection, and returns a label that
+	// identifies the remote system and a TransportConn. Each connection
+	// is a channel of the endpoint.
+	// It must return an error after Close() is called.
+	Accept() (string, TransportConn, error)
+
+	// Close stops accepting connections.
+	Close() error
+}
+
+// TransportConn is a connection of a transport.
+//
+// A connection must also implement one of the following:
+//   - io.ReadWriter, if the transport is stream-oriented, i.e. a serial
+//     port, where frames can be split among reads and writes
+//   - FrameReadWriter, if the transport is frame-oriented, i.e. a radio that
+//     carries packets, where each packet contains exactly one frame
+type TransportConn interface {
+	io.Closer
+}
+
+// FrameReadWriter is implemented by connections of frame-oriented
+// transports.
+type FrameReadWriter interface {
+	// ReadFrame reads the next packet, that contains an encoded frame.
+	ReadFrame() ([]byte, error)
+
+	// WriteFrame writes a packet that contains an encoded frame.
+	WriteFrame(buf []byte) error
+}
+
+var transports = struct {
+	mutex  sync.RWMutex
+	byName map[string]Transport
+}{
+	byName: make(map[string]Transport),
+}
+
+// RegisterTransport registers a transport with a name, that can be used
+// by EndpointTransport. It panics if the transport does not implement
+// TransportDialer or TransportListener, or if the name is already taken.
+func RegisterTransport(name string, t Transport) {
+	if name == "" {
+		panic("transport name is empty")
+	}
+
+	_, isDialer := t.(TransportDialer)
+	_, isListener := t.(TransportListener)
+	if !isDialer && !isListener {
+		panic(fmt.Errorf("transport %s does not implement TransportDialer or TransportListener", name))
+	}
+
+	transports.mutex.Lock()
+	defer transports.mutex.Unlock()
+
+	if _, ok := transports.byName[name]; ok {
+		panic(fmt.Errorf("transport %s is already registered", name))
+	}
+	transports.byName[name] = t
+}
+
+// Transports returns the names of the registered transports, sorted.
+func Transports() []string {
+	transports.mutex.RLock()
+	defer transports.mutex.RUnlock()
+
+	ret := make([]string, 0, len(transports.byName))
+	for name := range transports.byName {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func lookupTransport(name string) (Transport, bool) {
+	transports.mutex.RLock()
+	defer transports.mutex.RUnlock()
+	t, ok := transports.byName[name]
+	return t, ok
+}
+
+// frameConn adapts a connection of a frame-oriented transport to the
+// io.ReadWriteCloser used by channels, that writes a frame with each Write().
+type frameConn struct {
+	conn TransportConn
+	frw  FrameReadWriter
+	buf  []byte
+}
+
+func (c *frameConn) Read(p []byte) (int, error) {
+	// frames are returned in parts if p is too small
+	for len(c.buf) == 0 {
+		buf, err := c.frw.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = buf
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *frameConn) Write(p []byte) (int, error) {
+	err := c.frw.WriteFrame(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *frameConn) Close() error {
+	return c.conn.Close()
+}
+
+type streamConn struct {
+	io.ReadWriter
+	conn TransportConn
+}
+
+func (c *streamConn) Close() error {
+	return c.conn.Close()
+}
+
+// transportRWC converts a connection of a transport into an
+// io.ReadWriteCloser.
+func transportRWC(conn TransportConn) (io.ReadWriteCloser, error) {
+	switch tconn := conn.(type) {
+	case FrameReadWriter:
+		return &frameConn{conn: conn, frw: tconn}, nil
+
+	case io.ReadWriteCloser:
+		return tconn, nil
+
+	case io.ReadWriter:
+		return &streamConn{ReadWriter: tconn, conn: conn}, nil
+	}
+
+	conn.Close()
+	return nil, fmt.Errorf("connection %T does not implement io.ReadWriter or FrameReadWriter", conn)
+}
+
+// EndpointTransport sets up a endpoint that works with a transport.
+// Built-in network endpoints work with transports too, and the transports
+// "tcp" and "udp" are registered with their default options.
+type EndpointTransport struct {
+	// the name of a transport registered with RegisterTransport.
+	Transport string
+
+	// (optional) the transport, that is used in place of a registered one.
+	// It allows to pass options that can't be encoded into Address, i.e.
+	// TLS certificates, and it is meant to be filled by the configurations
+	// provided by transport packages.
+	Instance Transport
+
+	// the address, whose format depends on the transport, i.e. the path of
+	// a modem.
+	Address string
+
+	// (optional) accepts connections from remote systems, instead of
+	// establishing a connection. The transport must implement
+	// TransportListener.
+	Listen bool
+
+	// (optional) how the endpoint reconnects when the connection can't be
+	// established. It defaults to a retry every 2 seconds, forever.
+	// It is not used when Listen is true.
+	Reconnect ReconnectPolicy
+}
+
+func (conf EndpointTransport) init() (Endpoint, error) {
+	t := conf.Instance
+	if t == nil {
+		var ok bool
+		t, ok = lookupTransport(conf.Transport)
+		if !ok {
+			return nil, fmt.Errorf("transport %s is not registered", conf.Transport)
+		}
+	}
+
+	return newEndpointTransport(conf, t, conf.Address, conf.Listen, conf.Reconnect)
+}
+
+// newEndpointTransport allocates a endpoint that works with a transport.
+// conf is the configuration returned by Conf().
+func newEndpointTransport(conf interface{}, t Transport, address string, listen bool,
+	reconnect ReconnectPolicy) (Endpoint, error) {
+	if listen {
+		tl, ok := t.(TransportListener)
+		if !ok {
+			return nil, fmt.Errorf("transport %s does not support listening", t.Label())
+		}
+
+		acceptor, err := tl.Listen(address)
+		if err != nil {
+			return nil, err
+		}
+
+		return &endpointTransportListener{
+			conf:      conf,
+			label:     t.Label(),
+			acceptor:  acceptor,
+			terminate: make(chan struct{}),
+		}, nil
+	}
+
+	td, ok := t.(TransportDialer)
+	if !ok {
+		return nil, fmt.Errorf("transport %s does not support dialing", t.Label())
+	}
+
+	label := t.Label()
+	if address != "" {
+		label += ":" + address
+	}
+
+	return newEndpointClient(conf, label, reconnect, func() (io.ReadWriteCloser, error) {
+		conn, err := td.Dial(address)
+		if err != nil {
+			return nil, err
+		}
+		return transportRWC(conn)
+	})
+}
+
+// funcTransport is a transport that establishes connections with a function,
+// that is used by endpoints that are not listed among the registered
+// transports.
+type funcTransport struct {
+	label string
+	dial  func() (io.ReadWriteCloser, error)
+}
+
+func (t *funcTransport) Label() string {
+	return t.label
+}
+
+func (t *funcTransport) Dial(string) (TransportConn, error) {
+	return t.dial()
+}
+
+type endpointTransportListener struct {
+	conf      interface{}
+	label     string
+	acceptor  TransportAcceptor
+	terminate chan struct{}
+}
+
+func (t *endpointTransportListener) isEndpoint() {}
+
+func (t *endpointTransportListener) Conf() interface{} {
+	return t.conf
+}
+
+func (t *endpointTransportListener) Close() error {
+	close(t.terminate)
+	t.acceptor.Close()
+	return nil
+}
+
+func (t *endpointTransportListener) Accept() (string, io.ReadWriteCloser, error) {
+	for {
+		label, conn, err := t.acceptor.Accept()
+
+		// wait termination, do not report errors
+		if err != nil {
+			<-t.terminate
+			return "", nil, errorTerminated
+		}
+
+		rwc, err := transportRWC(conn)
+		if err != nil {
+			continue
+		}
+
+		return t.label + ":" + label, rwc, nil
+	}
+}
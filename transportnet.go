@@ -0,0 +1,124 @@
+package gomavlib
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aler9/gomavlib/udplistener"
+)
+
+func init() {
+	RegisterTransport("tcp", &netTransport{})
+	RegisterTransport("udp", &netTransport{udp: true})
+}
+
+// netTransport is the transport of TCP, TLS and UDP endpoints.
+type netTransport struct {
+	udp               bool
+	control           func(string, string, syscall.RawConn) error
+	tlsConfig         *tls.Config
+	localAddr         net.Addr
+	replyToLastSender bool
+	filter            func(net.IP) bool
+	idleTimeout       time.Duration
+	maxClients        int
+}
+
+func (t *netTransport) Label() string {
+	switch {
+	case t.udp:
+		return "udp"
+	case t.tlsConfig != nil:
+		return "tls"
+	}
+	return "tcp"
+}
+
+func (t *netTransport) network() string {
+	if t.udp {
+		return "udp4"
+	}
+	return "tcp4"
+}
+
+func (t *netTransport) Dial(address string) (TransportConn, error) {
+	if t.replyToLastSender {
+		return dialUdpReply(address, t.control, t.localAddr)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   netConnectTimeout,
+		Control:   t.control,
+		LocalAddr: t.localAddr,
+	}
+
+	if t.tlsConfig != nil {
+		// the handshake is performed within the connection timeout
+		rawConn, err := tls.DialWithDialer(dialer, t.network(), address, t.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &netTimedConn{conn: rawConn}, nil
+	}
+
+	rawConn, err := dialer.Dial(t.network(), address)
+	if err != nil {
+		return nil, err
+	}
+	return &netTimedConn{conn: rawConn}, nil
+}
+
+func (t *netTransport) Listen(address string) (TransportAcceptor, error) {
+	lc := &net.ListenConfig{
+		Control: t.control,
+	}
+
+	var listener net.Listener
+	var err error
+	if t.udp {
+		listener, err = udplistener.NewFromConf(t.network(), address, udplistener.Conf{
+			ListenConfig: lc,
+			Filter:       t.filter,
+			MaxConns:     t.maxClients,
+		})
+	} else {
+		listener, err = lc.Listen(context.Background(), t.network(), address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake is performed by the first read of each connection
+	if t.tlsConfig != nil {
+		listener = tls.NewListener(listener, t.tlsConfig)
+	}
+
+	return &netAcceptor{
+		listener:    listener,
+		idleTimeout: t.idleTimeout,
+	}, nil
+}
+
+type netAcceptor struct {
+	listener    net.Listener
+	idleTimeout time.Duration
+}
+
+func (a *netAcceptor) Accept() (string, TransportConn, error) {
+	rawConn, err := a.listener.Accept()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return rawConn.RemoteAddr().String(), &netTimedConn{
+		conn:        rawConn,
+		readTimeout: a.idleTimeout,
+	}, nil
+}
+
+func (a *netAcceptor) Close() error {
+	return a.listener.Close()
+}
@@ -2,6 +2,7 @@
 package udplistener
 
 import (
+	"context"
 	"net"
 	"sync"
 	"time"
@@ -149,9 +150,29 @@ func (c *udpListenerConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+// Conf allows to configure a UDPListener.
+type Conf struct {
+	// (optional) the ListenConfig used to create the socket, that allows to
+	// set socket options.
+	ListenConfig *net.ListenConfig
+
+	// (optional) a function that accepts or discards datagrams by their
+	// source IP, before they reach any connection.
+	// If nil, all datagrams are accepted.
+	Filter func(net.IP) bool
+
+	// (optional) the maximum number of simultaneous connections. Datagrams
+	// that would open a connection beyond this limit are discarded, until
+	// an existing connection is closed.
+	// If zero, connections are not limited.
+	MaxConns int
+}
+
 // UDPListener is a UDP listener.
 type UDPListener struct {
 	packetConn net.PacketConn
+	filter     func(net.IP) bool
+	maxConns   int
 	conns      map[udpListenerConnIndex]*udpListenerConn
 	readMutex  sync.Mutex
 	writeMutex sync.Mutex
@@ -163,13 +184,25 @@ type UDPListener struct {
 
 // New allocates a UDPListener.
 func New(network, address string) (net.Listener, error) {
-	packetConn, err := net.ListenPacket(network, address)
+	return NewFromConf(network, address, Conf{})
+}
+
+// NewFromConf allocates a UDPListener. See Conf for the options.
+func NewFromConf(network, address string, conf Conf) (net.Listener, error) {
+	lc := conf.ListenConfig
+	if lc == nil {
+		lc = &net.ListenConfig{}
+	}
+
+	packetConn, err := lc.ListenPacket(context.Background(), network, address)
 	if err != nil {
 		return nil, err
 	}
 
 	l := &UDPListener{
 		packetConn: packetConn,
+		filter:     conf.Filter,
+		maxConns:   conf.MaxConns,
 		conns:      make(map[udpListenerConnIndex]*udpListenerConn),
 		acceptc:    make(chan net.Conn),
 		readDone:   make(chan struct{}),
@@ -220,6 +253,11 @@ func (l *UDPListener) reader() {
 
 		// use ip and port as connection index
 		uaddr := addr.(*net.UDPAddr)
+
+		if l.filter != nil && !l.filter(uaddr.IP) {
+			continue
+		}
+
 		connIndex := udpListenerConnIndex{}
 		connIndex.Port = uaddr.Port
 		copy(connIndex.IP[:], uaddr.IP)
@@ -233,6 +271,9 @@ func (l *UDPListener) reader() {
 			if !preExisting && l.closed == true {
 				// listener is closed, ignore new connection
 
+			} else if !preExisting && l.maxConns > 0 && len(l.conns) >= l.maxConns {
+				// too many connections, ignore new connection
+
 			} else {
 				if !preExisting {
 					conn = newConn(l, connIndex, uaddr)
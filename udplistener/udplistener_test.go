@@ -121,3 +121,100 @@ func TestUdpListenerDoubleClose(t *testing.T) {
 	l.Close()
 	l.Close()
 }
+
+func TestUdpListenerFilter(t *testing.T) {
+	l, err := NewFromConf("udp4", "127.0.0.1:18456", Conf{
+		Filter: func(ip net.IP) bool {
+			return !ip.Equal(net.IPv4(127, 0, 0, 2))
+		},
+	})
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Addr, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 1024))
+			accepted <- conn.RemoteAddr()
+		}
+	}()
+
+	rejected, err := net.DialUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)},
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 18456})
+	require.NoError(t, err)
+	defer rejected.Close()
+
+	_, err = rejected.Write([]byte("rejected"))
+	require.NoError(t, err)
+
+	allowed, err := net.Dial("udp4", "127.0.0.1:18456")
+	require.NoError(t, err)
+	defer allowed.Close()
+
+	_, err = allowed.Write([]byte("allowed"))
+	require.NoError(t, err)
+
+	addr := <-accepted
+	require.Equal(t, allowed.LocalAddr().String(), addr.String())
+
+	select {
+	case addr := <-accepted:
+		t.Errorf("unexpected connection from %v", addr)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestUdpListenerMaxConns(t *testing.T) {
+	l, err := NewFromConf("udp4", "127.0.0.1:18457", Conf{MaxConns: 1})
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 1024))
+			accepted <- conn
+		}
+	}()
+
+	client1, err := net.Dial("udp4", "127.0.0.1:18457")
+	require.NoError(t, err)
+	defer client1.Close()
+
+	_, err = client1.Write([]byte("first"))
+	require.NoError(t, err)
+
+	conn1 := <-accepted
+	require.Equal(t, client1.LocalAddr().String(), conn1.RemoteAddr().String())
+
+	client2, err := net.Dial("udp4", "127.0.0.1:18457")
+	require.NoError(t, err)
+	defer client2.Close()
+
+	_, err = client2.Write([]byte("rejected"))
+	require.NoError(t, err)
+
+	select {
+	case conn := <-accepted:
+		t.Errorf("unexpected connection from %v", conn.RemoteAddr())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// closing a connection frees a slot
+	conn1.Close()
+
+	_, err = client2.Write([]byte("accepted"))
+	require.NoError(t, err)
+
+	conn2 := <-accepted
+	require.Equal(t, client2.LocalAddr().String(), conn2.RemoteAddr().String())
+	conn2.Close()
+}
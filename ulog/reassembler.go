@@ -0,0 +1,155 @@
+package ulog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	// size of the ULog file header
+	headerSize = 16
+
+	// size of the header of ULog messages (msg_size and msg_type)
+	messageHeaderSize = 3
+
+	// type of the message that signals a data loss
+	messageTypeDropout = 'O'
+
+	// value of FirstMessageOffset when no message starts in the packet
+	noMessageStart = 255
+)
+
+// magic bytes at the beginning of every ULog file
+var headerMagic = []byte{'U', 'L', 'o', 'g', 0x01, 0x12, 0x35}
+
+// reassembler rebuilds a ULog file from the data of LOGGING_DATA and
+// LOGGING_DATA_ACKED packets. Only complete messages are written, in order to
+// produce a valid file even when packets are lost.
+type reassembler struct {
+	w io.Writer
+
+	headerDone bool
+	hasSeq     bool
+	lastSeq    uint16
+	lastTime   time.Time
+
+	// whether buf is aligned with the beginning of a message
+	synced bool
+	buf    []byte
+
+	// duration of the data loss that must be signaled
+	dropout time.Duration
+
+	written uint64
+	lost    uint64
+}
+
+func newReassembler(w io.Writer) *reassembler {
+	return &reassembler{
+		w: w,
+	}
+}
+
+func (r *reassembler) push(seq uint16, firstMessageOffset uint8,
+	data []byte, now time.Time) error {
+	if r.hasSeq {
+		diff := seq - r.lastSeq
+
+		// discard duplicates, i.e. retransmissions of acked packets, and
+		// packets received out of order
+		if diff == 0 || diff >= 0x8000 {
+			return nil
+		}
+
+		if diff > 1 {
+			r.lost += uint64(diff - 1)
+			r.buf = r.buf[:0]
+			r.synced = false
+			r.dropout += now.Sub(r.lastTime)
+		}
+	}
+
+	if !r.headerDone {
+		// the file header is mandatory, therefore data is discarded until the
+		// beginning of the file is received
+		if len(data) < headerSize || !bytes.HasPrefix(data, headerMagic) {
+			return nil
+		}
+
+		err := r.write(data[:headerSize])
+		if err != nil {
+			return err
+		}
+
+		r.headerDone = true
+		r.synced = true
+		r.dropout = 0
+		data = data[headerSize:]
+	}
+
+	r.hasSeq = true
+	r.lastSeq = seq
+	r.lastTime = now
+
+	if !r.synced {
+		if firstMessageOffset == noMessageStart || int(firstMessageOffset) > len(data) {
+			return nil
+		}
+		data = data[firstMessageOffset:]
+		r.synced = true
+
+		err := r.writeDropout()
+		if err != nil {
+			return err
+		}
+	}
+
+	r.buf = append(r.buf, data...)
+	return r.flush()
+}
+
+// flush writes the complete messages contained in the buffer.
+func (r *reassembler) flush() error {
+	n := 0
+	for len(r.buf)-n >= messageHeaderSize {
+		size := messageHeaderSize + int(binary.LittleEndian.Uint16(r.buf[n:]))
+		if len(r.buf)-n < size {
+			break
+		}
+		n += size
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	err := r.write(r.buf[:n])
+	if err != nil {
+		return err
+	}
+
+	r.buf = append(r.buf[:0], r.buf[n:]...)
+	return nil
+}
+
+func (r *reassembler) writeDropout() error {
+	ms := r.dropout / time.Millisecond
+	if ms > 0xFFFF {
+		ms = 0xFFFF
+	}
+	r.dropout = 0
+
+	buf := make([]byte, messageHeaderSize+2)
+	binary.LittleEndian.PutUint16(buf, 2)
+	buf[2] = messageTypeDropout
+	binary.LittleEndian.PutUint16(buf[3:], uint16(ms))
+	return r.write(buf)
+}
+
+func (r *reassembler) write(buf []byte) error {
+	n, err := r.w.Write(buf)
+	r.written += uint64(n)
+	return err
+}
@@ -0,0 +1,255 @@
+// Package ulog implements a receiver of the PX4 ULog streaming protocol, that
+// allows to capture the log of a vehicle in real time.
+//
+// The receiver requests the stream with MAV_CMD_LOGGING_START, acknowledges
+// LOGGING_DATA_ACKED packets with LOGGING_ACK and reassembles the data of
+// LOGGING_DATA and LOGGING_DATA_ACKED packets into a valid .ulg file, that is
+// written to an io.Writer. Data that follows lost packets is discarded until
+// the beginning of the next ULog message, and the loss is signaled in the
+// file with a dropout message.
+//
+// The node to which the receiver is attached must use a dialect that contains
+// the common messages.
+package ulog
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// LOGGING_DATA can be sent at high rates, therefore the queue is bigger
+	// than the one of other helpers
+	packetQueueSize = 256
+
+	// Param1 of MAV_CMD_LOGGING_START
+	formatULog = 0
+)
+
+// Conf allows to configure a Receiver.
+type Conf struct {
+	// the node from which the log is received.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the vehicle. It defaults to 1.
+	ComponentId byte
+
+	// the writer in which the ULog file is written.
+	Writer io.Writer
+}
+
+type packet struct {
+	evt                *gomavlib.EventFrame
+	acked              bool
+	sequence           uint16
+	firstMessageOffset uint8
+	data               []byte
+}
+
+// Receiver receives a ULog stream from a vehicle.
+type Receiver struct {
+	conf          Conf
+	removeHandler func()
+
+	// accessed by run() only
+	reassembler *reassembler
+
+	mutex   sync.Mutex
+	err     error
+	written uint64
+	lost    uint64
+
+	packets   chan packet
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// New allocates a Receiver. See Conf for the options.
+func New(conf Conf) (*Receiver, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Writer == nil {
+		return nil, fmt.Errorf("Writer not provided")
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageCommandLong{},
+		&common.MessageLoggingData{},
+		&common.MessageLoggingDataAcked{},
+		&common.MessageLoggingAck{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	r := &Receiver{
+		conf:        conf,
+		reassembler: newReassembler(conf.Writer),
+		packets:     make(chan packet, packetQueueSize),
+		terminate:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	r.removeHandler = conf.Node.AddFrameHandler(r.onEventFrame)
+
+	go r.run()
+
+	return r, nil
+}
+
+// Close stops the receiver. It returns the first error returned by the writer.
+// It must be called before closing the node.
+func (r *Receiver) Close() error {
+	r.removeHandler()
+	close(r.terminate)
+	<-r.done
+	return r.Err()
+}
+
+// Start asks the vehicle to start streaming the log.
+func (r *Receiver) Start() {
+	r.command(common.MAV_CMD_LOGGING_START, formatULog)
+}
+
+// Stop asks the vehicle to stop streaming the log.
+func (r *Receiver) Stop() {
+	r.command(common.MAV_CMD_LOGGING_STOP, 0)
+}
+
+// Err returns the first error returned by the writer. After an error, data is
+// not written anymore.
+func (r *Receiver) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// BytesWritten returns the number of bytes written to the writer.
+func (r *Receiver) BytesWritten() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.written
+}
+
+// PacketsLost returns the number of packets that have been lost.
+func (r *Receiver) PacketsLost() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lost
+}
+
+func (r *Receiver) command(cmd common.MAV_CMD, param1 float32) {
+	r.conf.Node.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem:    r.conf.SystemId,
+		TargetComponent: r.conf.ComponentId,
+		Command:         cmd,
+		Param1:          param1,
+	})
+}
+
+func (r *Receiver) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != r.conf.SystemId || evt.ComponentId() != r.conf.ComponentId {
+		return
+	}
+
+	var p packet
+
+	switch evt.Message().GetId() {
+	case (&common.MessageLoggingData{}).GetId():
+		var m common.MessageLoggingData
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		if !r.isTarget(m.TargetSystem) || int(m.Length) > len(m.Data) {
+			return
+		}
+		p = packet{evt, false, m.Sequence, m.FirstMessageOffset,
+			append([]byte(nil), m.Data[:m.Length]...)}
+
+	case (&common.MessageLoggingDataAcked{}).GetId():
+		var m common.MessageLoggingDataAcked
+		if msg.Convert(&m, evt.Message()) != nil {
+			return
+		}
+		if !r.isTarget(m.TargetSystem) || int(m.Length) > len(m.Data) {
+			return
+		}
+		p = packet{evt, true, m.Sequence, m.FirstMessageOffset,
+			append([]byte(nil), m.Data[:m.Length]...)}
+
+	default:
+		return
+	}
+
+	// frame handlers must not block; packets are dropped when the queue is
+	// full, and their loss is handled like the one of packets lost in transit
+	select {
+	case r.packets <- p:
+	default:
+	}
+}
+
+func (r *Receiver) isTarget(systemId uint8) bool {
+	return systemId == 0 || systemId == r.conf.Node.Conf().OutSystemId
+}
+
+func (r *Receiver) run() {
+	defer close(r.done)
+
+	for {
+		select {
+		case p := <-r.packets:
+			r.handlePacket(p)
+
+		case <-r.terminate:
+			return
+		}
+	}
+}
+
+func (r *Receiver) handlePacket(p packet) {
+	if p.acked {
+		r.conf.Node.WriteMessageTo(p.evt.Channel, &common.MessageLoggingAck{
+			TargetSystem:    p.evt.SystemId(),
+			TargetComponent: p.evt.ComponentId(),
+			Sequence:        p.sequence,
+		})
+	}
+
+	if r.Err() != nil {
+		return
+	}
+
+	err := r.reassembler.push(p.sequence, p.firstMessageOffset, p.data, time.Now())
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.written = r.reassembler.written
+	r.lost = r.reassembler.lost
+	if err != nil {
+		r.err = err
+	}
+}
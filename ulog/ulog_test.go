@@ -0,0 +1,184 @@
+package ulog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/dialects/common"
+)
+
+var testHeader = []byte{'U', 'L', 'o', 'g', 0x01, 0x12, 0x35, 0x01,
+	0, 0, 0, 0, 0, 0, 0, 0}
+
+func testMessage(typ byte, payload ...byte) []byte {
+	buf := make([]byte, messageHeaderSize)
+	binary.LittleEndian.PutUint16(buf, uint16(len(payload)))
+	buf[2] = typ
+	return append(buf, payload...)
+}
+
+func TestReassembler(t *testing.T) {
+	var out bytes.Buffer
+	r := newReassembler(&out)
+	now := time.Now()
+
+	msg1 := testMessage('I', 1, 2, 3, 4)
+	msg2 := testMessage('D', 5, 6, 7, 8, 9, 10)
+	msg3 := testMessage('D', 11, 12)
+
+	// data before the header is discarded
+	err := r.push(100, 0, msg1, now)
+	require.NoError(t, err)
+	require.Equal(t, 0, out.Len())
+
+	// header and a message split between two packets
+	err = r.push(0, 16, append(append([]byte(nil), testHeader...), msg1[:2]...), now)
+	require.NoError(t, err)
+	require.Equal(t, testHeader, out.Bytes())
+
+	err = r.push(1, 5, append(append([]byte(nil), msg1[2:]...), msg2[:3]...), now)
+	require.NoError(t, err)
+	require.Equal(t, len(testHeader)+len(msg1), out.Len())
+
+	// duplicate
+	err = r.push(1, 5, append(append([]byte(nil), msg1[2:]...), msg2[:3]...), now)
+	require.NoError(t, err)
+	require.Equal(t, len(testHeader)+len(msg1), out.Len())
+
+	// packet 2 is lost, the rest of msg2 is discarded
+	err = r.push(3, 4, append(append([]byte(nil), msg2[5:]...), msg3...), now.Add(200*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), r.lost)
+
+	var expected []byte
+	expected = append(expected, testHeader...)
+	expected = append(expected, msg1...)
+	expected = append(expected, testMessage(messageTypeDropout, 200, 0)...)
+	expected = append(expected, msg3...)
+	require.Equal(t, expected, out.Bytes())
+	require.Equal(t, uint64(len(expected)), r.written)
+
+	// sequence wraps around
+	r.lastSeq = 0xFFFF
+	err = r.push(0, 0, msg1, now)
+	require.NoError(t, err)
+	require.Equal(t, append(expected, msg1...), out.Bytes())
+}
+
+func TestReceiver(t *testing.T) {
+	gcs, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 255,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpServer{Address: "127.0.0.1:5690"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer gcs.Close()
+
+	go func() {
+		for range gcs.Events() {
+		}
+	}()
+
+	var out bytes.Buffer
+	r, err := New(Conf{
+		Node:     gcs,
+		SystemId: 1,
+		Writer:   &out,
+	})
+	require.NoError(t, err)
+
+	vehicle, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Dialect:     common.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemId: 1,
+		Endpoints: []gomavlib.EndpointConf{
+			gomavlib.EndpointUdpClient{Address: "127.0.0.1:5690"},
+		},
+		HeartbeatDisable: true,
+	})
+	require.NoError(t, err)
+	defer vehicle.Close()
+
+	// the vehicle must send a datagram before the GCS can reach it
+	for len(gcs.Channels()) == 0 {
+		vehicle.WriteMessageAll(&common.MessageHeartbeat{})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	r.Start()
+
+	var cmd *common.MessageCommandLong
+	timeout := time.After(1 * time.Second)
+	for cmd == nil {
+		select {
+		case evt := <-vehicle.Events():
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if m, ok := fr.Message().(*common.MessageCommandLong); ok {
+					cmd = m
+				}
+			}
+		case <-timeout:
+			t.Fatal("command not received")
+		}
+	}
+	require.Equal(t, common.MAV_CMD_LOGGING_START, cmd.Command)
+	require.Equal(t, uint8(1), cmd.TargetSystem)
+
+	msg1 := testMessage('I', 1, 2, 3, 4)
+	msg2 := testMessage('D', 5, 6)
+
+	header := &common.MessageLoggingDataAcked{
+		TargetSystem: 255,
+		Sequence:     0,
+		Length:       uint8(len(testHeader) + len(msg1)),
+	}
+	copy(header.Data[:], append(append([]byte(nil), testHeader...), msg1...))
+	vehicle.WriteMessageAll(header)
+
+	var ack *common.MessageLoggingAck
+	timeout = time.After(1 * time.Second)
+	for ack == nil {
+		select {
+		case evt := <-vehicle.Events():
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if m, ok := fr.Message().(*common.MessageLoggingAck); ok {
+					ack = m
+				}
+			}
+		case <-timeout:
+			t.Fatal("ack not received")
+		}
+	}
+	require.Equal(t, uint16(0), ack.Sequence)
+	require.Equal(t, uint8(1), ack.TargetSystem)
+
+	data := &common.MessageLoggingData{
+		TargetSystem: 255,
+		Sequence:     1,
+		Length:       uint8(len(msg2)),
+	}
+	copy(data.Data[:], msg2)
+	vehicle.WriteMessageAll(data)
+
+	for i := 0; i < 50 && r.BytesWritten() < uint64(len(testHeader)+len(msg1)+len(msg2)); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	require.NoError(t, r.Close())
+
+	var expected []byte
+	expected = append(expected, testHeader...)
+	expected = append(expected, msg1...)
+	expected = append(expected, msg2...)
+	require.Equal(t, expected, out.Bytes())
+	require.Equal(t, uint64(0), r.PacketsLost())
+}
@@ -12,14 +12,26 @@ var errorTerminated = fmt.Errorf("terminated")
 // netTimedConn forces a net.Conn to use timeouts
 type netTimedConn struct {
 	conn net.Conn
+
+	// the read timeout. It defaults to netReadTimeout.
+	readTimeout time.Duration
 }
 
 func (c *netTimedConn) Close() error {
 	return c.conn.Close()
 }
 
+func (c *netTimedConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
 func (c *netTimedConn) Read(buf []byte) (int, error) {
-	err := c.conn.SetReadDeadline(time.Now().Add(netReadTimeout))
+	timeout := c.readTimeout
+	if timeout == 0 {
+		timeout = netReadTimeout
+	}
+
+	err := c.conn.SetReadDeadline(time.Now().Add(timeout))
 	if err != nil {
 		return 0, err
 	}
@@ -39,3 +51,34 @@ func randomByte() byte {
 	rand.Read(buf[:])
 	return buf[0]
 }
+
+// sourceFilter parses a list of allowed sources, in IP (192.168.1.5) or
+// CIDR (192.168.1.0/24) notation, and returns a function that checks whether
+// an IP is allowed. It returns nil if the list is empty.
+func sourceFilter(sources []string) (func(net.IP) bool, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	var nets []*net.IPNet
+	for _, s := range sources {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowed source: %s", s)
+			}
+			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		nets = append(nets, ipnet)
+	}
+
+	return func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,512 @@
+// Package vehicle implements high-level controls of a vehicle running
+// ArduPilot or PX4: arming, disarming, mode changes, takeoff, landing and
+// return to launch.
+//
+// Each control is sent with the COMMAND_LONG invocation that the autopilot of
+// the vehicle expects, and is completed when the command is acknowledged and
+// the heartbeat of the vehicle confirms the change (i.e. the armed flag or
+// the custom mode). The autopilot is advertised by the heartbeat, or can be
+// provided in the configuration.
+//
+// The node used by the package must use a dialect that contains the common
+// messages.
+package vehicle
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/msg"
+)
+
+const (
+	// magic values of param2 of MAV_CMD_COMPONENT_ARM_DISARM, that are shared
+	// by ArduPilot and PX4.
+	forceDisarmMagic = 21196
+)
+
+// ErrNotConfirmed is returned when a command is accepted, but the heartbeat
+// of the vehicle does not confirm the change.
+var ErrNotConfirmed = fmt.Errorf("change not confirmed by the heartbeat")
+
+// ErrNoHeartbeat is returned when the heartbeat of the vehicle, that is
+// needed to find out its autopilot, is not received.
+var ErrNoHeartbeat = fmt.Errorf("heartbeat not received")
+
+// ErrHomeUnknown is returned by Takeoff when the altitude of the home
+// position of a PX4 vehicle, that is needed to compute the takeoff
+// altitude, is unknown.
+var ErrHomeUnknown = fmt.Errorf("home altitude is unknown")
+
+// PX4 main modes and sub modes of the auto mode.
+// https://github.com/PX4/PX4-Autopilot/blob/main/src/modules/commander/px4_custom_mode.h
+const (
+	px4MainModeAuto   = 4
+	px4SubModeTakeoff = 2
+	px4SubModeRTL     = 5
+	px4SubModeLand    = 6
+)
+
+// ArduPilot modes, whose numbers depend on the vehicle type.
+const (
+	arducopterModeRTL  = 6
+	arducopterModeLand = 9
+	arduplaneModeRTL   = 11
+	ardupilotRoverRTL  = 11
+)
+
+// PX4CustomMode returns the custom mode of a PX4 vehicle with the given main
+// mode and sub mode, as advertised by its heartbeat.
+func PX4CustomMode(mainMode uint8, subMode uint8) uint32 {
+	return uint32(mainMode)<<16 | uint32(subMode)<<24
+}
+
+type vehicleClass int
+
+const (
+	classOther vehicleClass = iota
+	classCopter
+	classPlane
+	classRover
+)
+
+func classOf(t common.MAV_TYPE) vehicleClass {
+	switch t {
+	case common.MAV_TYPE_QUADROTOR, common.MAV_TYPE_COAXIAL, common.MAV_TYPE_HELICOPTER,
+		common.MAV_TYPE_HEXAROTOR, common.MAV_TYPE_OCTOROTOR, common.MAV_TYPE_TRICOPTER,
+		common.MAV_TYPE_DODECAROTOR, common.MAV_TYPE_DECAROTOR:
+		return classCopter
+
+	case common.MAV_TYPE_FIXED_WING, common.MAV_TYPE_VTOL_DUOROTOR,
+		common.MAV_TYPE_VTOL_QUADROTOR, common.MAV_TYPE_VTOL_TILTROTOR:
+		return classPlane
+
+	case common.MAV_TYPE_GROUND_ROVER, common.MAV_TYPE_SURFACE_BOAT:
+		return classRover
+	}
+	return classOther
+}
+
+// Conf allows to configure a Vehicle.
+type Conf struct {
+	// the node used to communicate with the vehicle.
+	Node *gomavlib.Node
+
+	// the system id of the vehicle.
+	SystemId byte
+
+	// (optional) the component id of the autopilot of the vehicle.
+	// It defaults to 1.
+	ComponentId byte
+
+	// (optional) the autopilot of the vehicle, MAV_AUTOPILOT_ARDUPILOTMEGA or
+	// MAV_AUTOPILOT_PX4. It defaults to the one advertised by the heartbeat of
+	// the vehicle.
+	Autopilot common.MAV_AUTOPILOT
+
+	// (optional) the retry policy of commands.
+	Retry command.RetryPolicy
+
+	// (optional) the maximum time between the acknowledgement of a command
+	// and the heartbeat that confirms the change. It is also the maximum
+	// time waited for the first heartbeat.
+	// It defaults to 5 seconds.
+	ConfirmTimeout time.Duration
+}
+
+// Vehicle controls a vehicle.
+type Vehicle struct {
+	conf          Conf
+	sender        *command.Sender
+	removeHandler func()
+
+	mutex         sync.Mutex
+	heartbeat     *common.MessageHeartbeat
+	heartbeatTime time.Time
+	heartbeatRecv chan struct{}
+	homeAltitude  float64
+	homeValid     bool
+
+	terminate chan struct{}
+}
+
+// New allocates a Vehicle. See Conf for the options.
+func New(conf Conf) (*Vehicle, error) {
+	if conf.Node == nil {
+		return nil, fmt.Errorf("Node not provided")
+	}
+
+	if conf.SystemId == 0 {
+		return nil, fmt.Errorf("SystemId not provided")
+	}
+
+	if conf.Autopilot != 0 && conf.Autopilot != common.MAV_AUTOPILOT_ARDUPILOTMEGA &&
+		conf.Autopilot != common.MAV_AUTOPILOT_PX4 {
+		return nil, fmt.Errorf("autopilot %s is not supported", conf.Autopilot)
+	}
+
+	if conf.Node.Conf().Dialect == nil {
+		return nil, fmt.Errorf("node must use a dialect")
+	}
+
+	err := conf.Node.Conf().Dialect.CheckMessages(
+		&common.MessageHeartbeat{},
+		&common.MessageGlobalPositionInt{},
+		&common.MessageHomePosition{},
+		&common.MessageCommandLong{},
+		&common.MessageCommandAck{})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ComponentId == 0 {
+		conf.ComponentId = 1
+	}
+
+	if conf.ConfirmTimeout == 0 {
+		conf.ConfirmTimeout = 5 * time.Second
+	}
+
+	sender, err := command.New(command.Conf{
+		Node:        conf.Node,
+		SystemId:    conf.SystemId,
+		ComponentId: conf.ComponentId,
+		Retry:       conf.Retry,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Vehicle{
+		conf:          conf,
+		sender:        sender,
+		heartbeatRecv: make(chan struct{}),
+		terminate:     make(chan struct{}),
+	}
+
+	v.removeHandler = conf.Node.AddFrameHandler(v.onEventFrame)
+
+	return v, nil
+}
+
+// Close stops the vehicle controls. Pending controls return an error.
+// It must be called before closing the node.
+func (v *Vehicle) Close() {
+	v.removeHandler()
+	close(v.terminate)
+	v.sender.Close()
+}
+
+func (v *Vehicle) onEventFrame(evt *gomavlib.EventFrame) {
+	if evt.SystemId() != v.conf.SystemId || evt.ComponentId() != v.conf.ComponentId {
+		return
+	}
+
+	switch evt.Message().GetId() {
+	case (&common.MessageHeartbeat{}).GetId():
+		var hb common.MessageHeartbeat
+		if msg.Convert(&hb, evt.Message()) != nil {
+			return
+		}
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		v.heartbeat = &hb
+		v.heartbeatTime = evt.ReceiveTime
+
+		// wake up routines that are waiting for a heartbeat
+		close(v.heartbeatRecv)
+		v.heartbeatRecv = make(chan struct{})
+
+	case (&common.MessageHomePosition{}).GetId():
+		var hp common.MessageHomePosition
+		if msg.Convert(&hp, evt.Message()) != nil {
+			return
+		}
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		v.homeAltitude = float64(hp.Altitude) / 1000
+		v.homeValid = true
+
+	case (&common.MessageGlobalPositionInt{}).GetId():
+		var gp common.MessageGlobalPositionInt
+		if msg.Convert(&gp, evt.Message()) != nil {
+			return
+		}
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		// the home altitude is the difference between the altitude above
+		// mean sea level and the altitude relative to home
+		v.homeAltitude = float64(gp.Alt-gp.RelativeAlt) / 1000
+		v.homeValid = true
+	}
+}
+
+// Heartbeat returns the last heartbeat of the vehicle, or false if no
+// heartbeats have been received.
+func (v *Vehicle) Heartbeat() (common.MessageHeartbeat, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.heartbeat == nil {
+		return common.MessageHeartbeat{}, false
+	}
+	return *v.heartbeat, true
+}
+
+// Armed returns whether the vehicle is armed, according to its last
+// heartbeat.
+func (v *Vehicle) Armed() bool {
+	hb, ok := v.Heartbeat()
+	return ok && (hb.BaseMode&common.MAV_MODE_FLAG_SAFETY_ARMED) != 0
+}
+
+// waitHeartbeat waits for a heartbeat that is received after the given time
+// and satisfies a condition.
+func (v *Vehicle) waitHeartbeat(ctx context.Context, after time.Time,
+	cond func(hb *common.MessageHeartbeat) bool, timeoutErr error) (*common.MessageHeartbeat, error) {
+	timer := time.NewTimer(v.conf.ConfirmTimeout)
+	defer timer.Stop()
+
+	for {
+		v.mutex.Lock()
+		hb := v.heartbeat
+		hbTime := v.heartbeatTime
+		recv := v.heartbeatRecv
+		v.mutex.Unlock()
+
+		if hb != nil && !hbTime.Before(after) && cond(hb) {
+			return hb, nil
+		}
+
+		select {
+		case <-recv:
+		case <-timer.C:
+			return nil, timeoutErr
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-v.terminate:
+			return nil, fmt.Errorf("terminated")
+		}
+	}
+}
+
+// vehicleType returns the autopilot and the type of the vehicle, waiting for
+// its heartbeat if necessary.
+func (v *Vehicle) vehicleType(ctx context.Context) (common.MAV_AUTOPILOT, common.MAV_TYPE, error) {
+	hb, err := v.waitHeartbeat(ctx, time.Time{}, func(*common.MessageHeartbeat) bool {
+		return true
+	}, ErrNoHeartbeat)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	autopilot := v.conf.Autopilot
+	if autopilot == 0 {
+		autopilot = hb.Autopilot
+	}
+
+	if autopilot != common.MAV_AUTOPILOT_ARDUPILOTMEGA && autopilot != common.MAV_AUTOPILOT_PX4 {
+		return 0, 0, fmt.Errorf("autopilot %s is not supported", autopilot)
+	}
+
+	return autopilot, hb.Type, nil
+}
+
+// run sends a command and, if cond is not nil, waits for a heartbeat that
+// confirms the change.
+func (v *Vehicle) run(ctx context.Context, cmd *common.MessageCommandLong,
+	cond func(hb *common.MessageHeartbeat) bool) error {
+	_, err := v.sender.SendLong(ctx, cmd, nil)
+	if err != nil {
+		return err
+	}
+
+	if cond == nil {
+		return nil
+	}
+
+	_, err = v.waitHeartbeat(ctx, time.Now(), cond, ErrNotConfirmed)
+	return err
+}
+
+func isArmed(hb *common.MessageHeartbeat) bool {
+	return (hb.BaseMode & common.MAV_MODE_FLAG_SAFETY_ARMED) != 0
+}
+
+func hasMode(mode uint32) func(hb *common.MessageHeartbeat) bool {
+	return func(hb *common.MessageHeartbeat) bool {
+		return hb.CustomMode == mode
+	}
+}
+
+// Arm arms the vehicle, with MAV_CMD_COMPONENT_ARM_DISARM, and waits until
+// the heartbeat of the vehicle reports the armed state.
+func (v *Vehicle) Arm(ctx context.Context) error {
+	return v.run(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_COMPONENT_ARM_DISARM,
+		Param1:  1,
+	}, isArmed)
+}
+
+// Disarm disarms the vehicle, with MAV_CMD_COMPONENT_ARM_DISARM, and waits
+// until the heartbeat of the vehicle reports the disarmed state. If force is
+// true, the vehicle is disarmed even if it is flying, and falls.
+func (v *Vehicle) Disarm(ctx context.Context, force bool) error {
+	var param2 float32
+	if force {
+		param2 = forceDisarmMagic
+	}
+
+	return v.run(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_COMPONENT_ARM_DISARM,
+		Param1:  0,
+		Param2:  param2,
+	}, func(hb *common.MessageHeartbeat) bool {
+		return !isArmed(hb)
+	})
+}
+
+// SetMode sets the mode of the vehicle, with MAV_CMD_DO_SET_MODE, and waits
+// until the heartbeat of the vehicle reports the mode. The custom mode is
+// the one advertised by the heartbeat: the mode number for ArduPilot (that
+// depends on the vehicle type), the main mode and the sub mode for PX4 (see
+// PX4CustomMode).
+func (v *Vehicle) SetMode(ctx context.Context, customMode uint32) error {
+	autopilot, _, err := v.vehicleType(ctx)
+	if err != nil {
+		return err
+	}
+
+	cmd := &common.MessageCommandLong{
+		Command: common.MAV_CMD_DO_SET_MODE,
+		Param1:  float32(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED),
+	}
+
+	if autopilot == common.MAV_AUTOPILOT_PX4 {
+		// PX4 expects the main mode and the sub mode in separate parameters
+		cmd.Param2 = float32((customMode >> 16) & 0xFF)
+		cmd.Param3 = float32((customMode >> 24) & 0xFF)
+	} else {
+		cmd.Param2 = float32(customMode)
+	}
+
+	return v.run(ctx, cmd, hasMode(customMode))
+}
+
+// Takeoff makes the vehicle take off, with MAV_CMD_NAV_TAKEOFF, up to the
+// given altitude relative to the home position, in meters, and waits until
+// the heartbeat of the vehicle confirms the takeoff. The vehicle must be
+// armed; ArduPilot vehicles must also be in guided mode.
+// PX4 expects an altitude above mean sea level, that is computed from the
+// altitude of the home position (HOME_POSITION or GLOBAL_POSITION_INT).
+func (v *Vehicle) Takeoff(ctx context.Context, alt float64) error {
+	autopilot, _, err := v.vehicleType(ctx)
+	if err != nil {
+		return err
+	}
+
+	nan := float32(math.NaN())
+	cmd := &common.MessageCommandLong{
+		Command: common.MAV_CMD_NAV_TAKEOFF,
+		Param4:  nan,
+		Param5:  nan,
+		Param6:  nan,
+	}
+
+	var cond func(hb *common.MessageHeartbeat) bool
+
+	if autopilot == common.MAV_AUTOPILOT_PX4 {
+		v.mutex.Lock()
+		home, ok := v.homeAltitude, v.homeValid
+		v.mutex.Unlock()
+
+		if !ok {
+			return ErrHomeUnknown
+		}
+
+		cmd.Param7 = float32(home + alt)
+		cond = hasMode(PX4CustomMode(px4MainModeAuto, px4SubModeTakeoff))
+	} else {
+		cmd.Param7 = float32(alt)
+		cond = func(hb *common.MessageHeartbeat) bool {
+			return isArmed(hb) && hb.SystemStatus == common.MAV_STATE_ACTIVE
+		}
+	}
+
+	return v.run(ctx, cmd, cond)
+}
+
+// Land makes the vehicle land at its current position, with
+// MAV_CMD_NAV_LAND, and waits until the heartbeat of the vehicle reports the
+// land mode. The mode is not checked for ArduPilot vehicles that are not
+// copters, whose landing is not a mode.
+func (v *Vehicle) Land(ctx context.Context) error {
+	autopilot, typ, err := v.vehicleType(ctx)
+	if err != nil {
+		return err
+	}
+
+	nan := float32(math.NaN())
+	cmd := &common.MessageCommandLong{
+		Command: common.MAV_CMD_NAV_LAND,
+		Param4:  nan,
+		Param5:  nan,
+		Param6:  nan,
+		Param7:  nan,
+	}
+
+	var cond func(hb *common.MessageHeartbeat) bool
+
+	switch {
+	case autopilot == common.MAV_AUTOPILOT_PX4:
+		cond = hasMode(PX4CustomMode(px4MainModeAuto, px4SubModeLand))
+
+	case classOf(typ) == classCopter:
+		cond = hasMode(arducopterModeLand)
+	}
+
+	return v.run(ctx, cmd, cond)
+}
+
+// ReturnToLaunch makes the vehicle return to the launch position, with
+// MAV_CMD_NAV_RETURN_TO_LAUNCH, and waits until the heartbeat of the vehicle
+// reports the return mode. The mode is not checked for ArduPilot vehicles
+// that are not copters, planes or rovers.
+func (v *Vehicle) ReturnToLaunch(ctx context.Context) error {
+	autopilot, typ, err := v.vehicleType(ctx)
+	if err != nil {
+		return err
+	}
+
+	var cond func(hb *common.MessageHeartbeat) bool
+
+	switch {
+	case autopilot == common.MAV_AUTOPILOT_PX4:
+		cond = hasMode(PX4CustomMode(px4MainModeAuto, px4SubModeRTL))
+
+	case classOf(typ) == classCopter:
+		cond = hasMode(arducopterModeRTL)
+
+	case classOf(typ) == classPlane:
+		cond = hasMode(arduplaneModeRTL)
+
+	case classOf(typ) == classRover:
+		cond = hasMode(ardupilotRoverRTL)
+	}
+
+	return v.run(ctx, &common.MessageCommandLong{
+		Command: common.MAV_CMD_NAV_RETURN_TO_LAUNCH,
+	}, cond)
+}
@@ -0,0 +1,348 @@
+package vehicle
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/command"
+	"github.com/aler9/gomavlib/dialects/common"
+	"github.com/aler9/gomavlib/internal/testnode"
+)
+
+// testVehicle emulates a vehicle that answers commands and advertises its
+// state with heartbeats.
+type testVehicle struct {
+	node      *gomavlib.Node
+	autopilot common.MAV_AUTOPILOT
+	typ       common.MAV_TYPE
+
+	mutex      sync.Mutex
+	armed      bool
+	status     common.MAV_STATE
+	customMode uint32
+	commands   []common.MessageCommandLong
+	result     common.MAV_RESULT
+	ignore     bool
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+func newTestVehicle(t *testing.T, autopilot common.MAV_AUTOPILOT,
+	typ common.MAV_TYPE) (*gomavlib.Node, *testVehicle) {
+	gcs, node := testnode.Pair(t)
+	testnode.DiscardEvents(gcs)
+
+	v := &testVehicle{
+		node:      node,
+		autopilot: autopilot,
+		typ:       typ,
+		status:    common.MAV_STATE_STANDBY,
+		result:    common.MAV_RESULT_ACCEPTED,
+		terminate: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go v.run()
+
+	return gcs, v
+}
+
+func (v *testVehicle) close() {
+	close(v.terminate)
+	<-v.done
+	v.node.Close()
+}
+
+func (v *testVehicle) run() {
+	defer close(v.done)
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.writeState()
+
+		case evt := <-v.node.Events():
+			if fr, ok := evt.(*gomavlib.EventFrame); ok {
+				if cmd, ok := fr.Message().(*common.MessageCommandLong); ok {
+					v.onCommand(cmd)
+				}
+			}
+
+		case <-v.terminate:
+			return
+		}
+	}
+}
+
+func (v *testVehicle) writeState() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	baseMode := common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED
+	if v.armed {
+		baseMode |= common.MAV_MODE_FLAG_SAFETY_ARMED
+	}
+
+	v.node.WriteMessageAll(&common.MessageHeartbeat{
+		Type:           v.typ,
+		Autopilot:      v.autopilot,
+		BaseMode:       baseMode,
+		CustomMode:     v.customMode,
+		SystemStatus:   v.status,
+		MavlinkVersion: 3,
+	})
+
+	v.node.WriteMessageAll(&common.MessageGlobalPositionInt{
+		Alt:         110000,
+		RelativeAlt: 10000,
+	})
+}
+
+func (v *testVehicle) onCommand(cmd *common.MessageCommandLong) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.commands = append(v.commands, *cmd)
+
+	v.node.WriteMessageAll(&common.MessageCommandAck{
+		Command:         cmd.Command,
+		Result:          v.result,
+		TargetSystem:    255,
+		TargetComponent: 1,
+	})
+
+	if v.result != common.MAV_RESULT_ACCEPTED || v.ignore {
+		return
+	}
+
+	px4 := v.autopilot == common.MAV_AUTOPILOT_PX4
+
+	switch cmd.Command {
+	case common.MAV_CMD_COMPONENT_ARM_DISARM:
+		v.armed = cmd.Param1 == 1
+
+	case common.MAV_CMD_DO_SET_MODE:
+		if px4 {
+			v.customMode = PX4CustomMode(uint8(cmd.Param2), uint8(cmd.Param3))
+		} else {
+			v.customMode = uint32(cmd.Param2)
+		}
+
+	case common.MAV_CMD_NAV_TAKEOFF:
+		if px4 {
+			v.customMode = PX4CustomMode(4, 2)
+		}
+		v.status = common.MAV_STATE_ACTIVE
+
+	case common.MAV_CMD_NAV_LAND:
+		if px4 {
+			v.customMode = PX4CustomMode(4, 6)
+		} else {
+			v.customMode = 9
+		}
+
+	case common.MAV_CMD_NAV_RETURN_TO_LAUNCH:
+		if px4 {
+			v.customMode = PX4CustomMode(4, 5)
+		} else {
+			v.customMode = 6
+		}
+	}
+}
+
+func (v *testVehicle) lastCommand() common.MessageCommandLong {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.commands[len(v.commands)-1]
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Conf{SystemId: 1})
+	require.EqualError(t, err, "Node not provided")
+
+	gcs, tv := newTestVehicle(t, common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR)
+	defer gcs.Close()
+	defer tv.close()
+
+	_, err = New(Conf{Node: gcs})
+	require.EqualError(t, err, "SystemId not provided")
+
+	_, err = New(Conf{Node: gcs, SystemId: 1, Autopilot: common.MAV_AUTOPILOT_GENERIC_MISSION_FULL})
+	require.EqualError(t, err, "autopilot MAV_AUTOPILOT_GENERIC_MISSION_FULL is not supported")
+}
+
+func TestArduPilot(t *testing.T) {
+	gcs, tv := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR)
+	defer gcs.Close()
+	defer tv.close()
+
+	v, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer v.Close()
+
+	ctx := context.Background()
+
+	err = v.SetMode(ctx, 4)
+	require.NoError(t, err)
+	cmd := tv.lastCommand()
+	require.Equal(t, common.MAV_CMD_DO_SET_MODE, cmd.Command)
+	require.Equal(t, float32(1), cmd.Param1)
+	require.Equal(t, float32(4), cmd.Param2)
+
+	err = v.Arm(ctx)
+	require.NoError(t, err)
+	require.Equal(t, true, v.Armed())
+	cmd = tv.lastCommand()
+	require.Equal(t, common.MAV_CMD_COMPONENT_ARM_DISARM, cmd.Command)
+	require.Equal(t, float32(1), cmd.Param1)
+
+	err = v.Takeoff(ctx, 15)
+	require.NoError(t, err)
+	cmd = tv.lastCommand()
+	require.Equal(t, common.MAV_CMD_NAV_TAKEOFF, cmd.Command)
+	require.Equal(t, float32(15), cmd.Param7)
+
+	err = v.ReturnToLaunch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, common.MAV_CMD_NAV_RETURN_TO_LAUNCH, tv.lastCommand().Command)
+	hb, ok := v.Heartbeat()
+	require.Equal(t, true, ok)
+	require.Equal(t, uint32(6), hb.CustomMode)
+
+	err = v.Land(ctx)
+	require.NoError(t, err)
+	require.Equal(t, common.MAV_CMD_NAV_LAND, tv.lastCommand().Command)
+	hb, _ = v.Heartbeat()
+	require.Equal(t, uint32(9), hb.CustomMode)
+
+	err = v.Disarm(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, false, v.Armed())
+	cmd = tv.lastCommand()
+	require.Equal(t, float32(0), cmd.Param1)
+	require.Equal(t, float32(21196), cmd.Param2)
+}
+
+func TestPX4(t *testing.T) {
+	gcs, tv := newTestVehicle(t, common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR)
+	defer gcs.Close()
+	defer tv.close()
+
+	v, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer v.Close()
+
+	ctx := context.Background()
+
+	// position control
+	err = v.SetMode(ctx, PX4CustomMode(3, 0))
+	require.NoError(t, err)
+	cmd := tv.lastCommand()
+	require.Equal(t, float32(1), cmd.Param1)
+	require.Equal(t, float32(3), cmd.Param2)
+	require.Equal(t, float32(0), cmd.Param3)
+
+	// auto loiter
+	err = v.SetMode(ctx, PX4CustomMode(4, 3))
+	require.NoError(t, err)
+	cmd = tv.lastCommand()
+	require.Equal(t, float32(4), cmd.Param2)
+	require.Equal(t, float32(3), cmd.Param3)
+
+	err = v.Arm(ctx)
+	require.NoError(t, err)
+
+	err = v.Takeoff(ctx, 15)
+	require.NoError(t, err)
+	cmd = tv.lastCommand()
+	require.Equal(t, common.MAV_CMD_NAV_TAKEOFF, cmd.Command)
+	require.Equal(t, float32(115), cmd.Param7)
+	require.Equal(t, true, math.IsNaN(float64(cmd.Param5)))
+
+	err = v.ReturnToLaunch(ctx)
+	require.NoError(t, err)
+	hb, _ := v.Heartbeat()
+	require.Equal(t, PX4CustomMode(4, 5), hb.CustomMode)
+
+	err = v.Land(ctx)
+	require.NoError(t, err)
+	hb, _ = v.Heartbeat()
+	require.Equal(t, PX4CustomMode(4, 6), hb.CustomMode)
+
+	err = v.Disarm(ctx, false)
+	require.NoError(t, err)
+	cmd = tv.lastCommand()
+	require.Equal(t, float32(0), cmd.Param2)
+}
+
+func TestNotConfirmed(t *testing.T) {
+	gcs, tv := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR)
+	defer gcs.Close()
+	defer tv.close()
+
+	tv.mutex.Lock()
+	tv.ignore = true
+	tv.mutex.Unlock()
+
+	v, err := New(Conf{
+		Node:           gcs,
+		SystemId:       1,
+		ConfirmTimeout: 300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer v.Close()
+
+	err = v.Arm(context.Background())
+	require.Equal(t, ErrNotConfirmed, err)
+}
+
+func TestDenied(t *testing.T) {
+	gcs, tv := newTestVehicle(t, common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR)
+	defer gcs.Close()
+	defer tv.close()
+
+	tv.mutex.Lock()
+	tv.result = common.MAV_RESULT_DENIED
+	tv.mutex.Unlock()
+
+	v, err := New(Conf{Node: gcs, SystemId: 1})
+	require.NoError(t, err)
+	defer v.Close()
+
+	err = v.Arm(context.Background())
+	rerr, ok := err.(*command.ResultError)
+	require.Equal(t, true, ok)
+	require.Equal(t, common.MAV_RESULT_DENIED, rerr.Ack.Result)
+}
+
+func TestNoHeartbeat(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	gcs, err := gomavlib.NewNode(testnode.Conf(255, gomavlib.EndpointCustom{ReadWriteCloser: c1}))
+	require.NoError(t, err)
+	defer gcs.Close()
+	testnode.DiscardEvents(gcs)
+
+	v, err := New(Conf{
+		Node:           gcs,
+		SystemId:       1,
+		ConfirmTimeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer v.Close()
+
+	err = v.SetMode(context.Background(), 4)
+	require.Equal(t, ErrNoHeartbeat, err)
+}
@@ -9,12 +9,23 @@ const (
 
 	// V2 is Mavlink 2.0
 	V2 Version = 2
+
+	// VAuto replies to each remote system with the version it uses.
+	// Frames are sent with Mavlink 1.0 until a Mavlink 2.0 frame is received
+	// from the channel, then Mavlink 2.0 is used. Messages addressed to a
+	// specific system (through the TargetSystem field) use the version of
+	// that system, if known. Messages with id > 255 are always sent
+	// with Mavlink 2.0, since they can't fit into Mavlink 1.0 frames.
+	VAuto Version = 3
 )
 
 // String implements fmt.Stringer.
 func (v Version) String() string {
-	if v == V1 {
+	switch v {
+	case V1:
 		return "V1"
+	case VAuto:
+		return "auto"
 	}
 	return "V2"
 }
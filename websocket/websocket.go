@@ -0,0 +1,401 @@
+// Package websocket provides a minimal WebSocket (RFC 6455) implementation,
+// that exchanges binary messages through a net.Conn.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxMessageSize is the maximum size of incoming messages.
+	MaxMessageSize = 64 * 1024
+
+	keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name string, value string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, tok := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(tok), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is a WebSocket connection. Each Write() is sent as a single binary
+// message, and each Read() returns the content of a single message, unless
+// the buffer is too small to contain it.
+// It implements net.Conn.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isClient bool
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+
+	// accessed by Read() only
+	pending []byte
+}
+
+// SameOrigin returns whether the Origin header of a request is missing, as
+// with clients that are not browsers, or matches the host of the request.
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade performs the server side of the handshake and returns a Conn.
+// checkOrigin is called to decide whether the origin of the request is
+// allowed; if it is nil, SameOrigin is used, in order to prevent web pages of
+// other sites from connecting through the browsers of users.
+// In case of errors, an HTTP error is written to w.
+func Upgrade(w http.ResponseWriter, r *http.Request, checkOrigin func(r *http.Request) bool) (*Conn, error) {
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("invalid method: %s", r.Method)
+	}
+
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return nil, fmt.Errorf("Sec-WebSocket-Key not provided")
+	}
+
+	if !checkOrigin(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, fmt.Errorf("origin not allowed: %s", r.Header.Get("Origin"))
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, fmt.Errorf("connection can't be hijacked")
+	}
+
+	nconn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = nconn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"))
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:   nconn,
+		reader: rw.Reader,
+	}, nil
+}
+
+// Client performs the client side of the handshake on an existing
+// connection and returns a Conn. u is the URL of the server.
+func Client(nconn net.Conn, u *url.URL, timeout time.Duration) (*Conn, error) {
+	var rkey [16]byte
+	rand.Read(rkey[:])
+	key := base64.StdEncoding.EncodeToString(rkey[:])
+
+	nconn.SetDeadline(time.Now().Add(timeout))
+	defer nconn.SetDeadline(time.Time{})
+
+	path := u.RequestURI()
+	_, err := nconn.Write([]byte("GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(nconn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	if res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("invalid Sec-WebSocket-Accept")
+	}
+
+	return &Conn{
+		conn:     nconn,
+		reader:   reader,
+		isClient: true,
+	}, nil
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(buf []byte) (int, error) {
+	for len(c.pending) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = msg
+	}
+
+	n := copy(buf, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := (header[0] & 0x80) != 0
+	op := header[0] & 0x0F
+	masked := (header[1] & 0x80) != 0
+
+	// clients must mask frames, servers must not
+	if masked == c.isClient {
+		return false, 0, nil, fmt.Errorf("invalid masking")
+	}
+
+	size := uint64(header[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		_, err := io.ReadFull(c.reader, ext[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+
+	case 127:
+		var ext [8]byte
+		_, err := io.ReadFull(c.reader, ext[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if size > MaxMessageSize {
+		return false, 0, nil, fmt.Errorf("message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err := io.ReadFull(c.reader, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, op, payload, nil
+}
+
+func (c *Conn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			err := c.writeFrame(opPong, payload)
+			if err != nil {
+				return nil, err
+			}
+			continue
+
+		case opPong:
+			continue
+
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+
+		case opText, opBinary:
+			if started {
+				return nil, fmt.Errorf("unexpected data frame")
+			}
+			started = true
+
+		case opContinuation:
+			if !started {
+				return nil, fmt.Errorf("unexpected continuation frame")
+			}
+
+		default:
+			return nil, fmt.Errorf("invalid opcode: %d", op)
+		}
+
+		if len(msg)+len(payload) > MaxMessageSize {
+			return nil, fmt.Errorf("message too big")
+		}
+		msg = append(msg, payload...)
+
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(buf []byte) (int, error) {
+	err := c.writeFrame(opBinary, buf)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+
+	var maskBit byte
+	if c.isClient {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	if c.isClient {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close implements net.Conn. A close message is sent before closing
+// the connection.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
+		c.writeFrame(opClose, nil)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// LocalAddr implements net.Conn.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example of RFC 6455
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func testPair(t *testing.T) (*Conn, *Conn, func()) {
+	serverConns := make(chan *Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, nil)
+		if err == nil {
+			serverConns <- conn
+		}
+	}))
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	nconn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+
+	client, err := Client(nconn, u, 1*time.Second)
+	require.NoError(t, err)
+
+	server := <-serverConns
+
+	return client, server, func() {
+		client.Close()
+		server.Close()
+		ts.Close()
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	client, server, closeAll := testPair(t)
+	defer closeAll()
+
+	for _, size := range []int{10, 200, 70000 - 10000} {
+		payload := bytes.Repeat([]byte{0x42}, size)
+
+		_, err := client.Write(payload)
+		require.NoError(t, err)
+
+		buf := make([]byte, size)
+		n, err := server.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, payload, buf[:n])
+
+		_, err = server.Write(payload)
+		require.NoError(t, err)
+
+		n, err = client.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, payload, buf[:n])
+	}
+}
+
+func TestReadSmallBuffer(t *testing.T) {
+	client, server, closeAll := testPair(t)
+	defer closeAll()
+
+	_, err := client.Write([]byte{1, 2, 3, 4, 5})
+	require.NoError(t, err)
+
+	buf := make([]byte, 3)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+
+	n, err = server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{4, 5}, buf[:n])
+}
+
+func TestPingFragments(t *testing.T) {
+	client, server, closeAll := testPair(t)
+	defer closeAll()
+
+	// a ping, followed by a message split into two fragments
+	err := client.writeFrame(opPing, []byte("ping"))
+	require.NoError(t, err)
+	client.writeMutex.Lock()
+	_, err = client.conn.Write([]byte{opBinary, 0x80 | 2, 0, 0, 0, 0, 1, 2})
+	require.NoError(t, err)
+	_, err = client.conn.Write([]byte{0x80 | opContinuation, 0x80 | 1, 0, 0, 0, 0, 3})
+	client.writeMutex.Unlock()
+	require.NoError(t, err)
+
+	buf := make([]byte, 10)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+
+	// the pong is received by the client, that discards it
+	_, err = server.Write([]byte{4})
+	require.NoError(t, err)
+	n, err = client.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, buf[:n])
+}
+
+func TestUpgradeError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// the server responds with an error, that is reported by the client
+	u, _ := url.Parse(ts.URL)
+	nconn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+	defer nconn.Close()
+	nconn.Write([]byte("GET / HTTP/1.1\r\nHost: " + u.Host + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 8\r\n\r\n"))
+	res, err = http.ReadResponse(bufio.NewReader(nconn), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUpgradeRequired, res.StatusCode)
+}
+
+func TestUpgradeOrigin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, nil)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	for _, ca := range []struct {
+		name   string
+		origin string
+		status int
+	}{
+		{"no origin", "", http.StatusSwitchingProtocols},
+		{"same origin", "http://" + u.Host, http.StatusSwitchingProtocols},
+		{"cross origin", "http://example.com", http.StatusForbidden},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			nconn, err := net.Dial("tcp", u.Host)
+			require.NoError(t, err)
+			defer nconn.Close()
+
+			req := "GET / HTTP/1.1\r\nHost: " + u.Host + "\r\n" +
+				"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+			if ca.origin != "" {
+				req += "Origin: " + ca.origin + "\r\n"
+			}
+			nconn.Write([]byte(req + "\r\n"))
+
+			res, err := http.ReadResponse(bufio.NewReader(nconn), nil)
+			require.NoError(t, err)
+			require.Equal(t, ca.status, res.StatusCode)
+		})
+	}
+}
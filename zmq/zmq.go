@@ -0,0 +1,529 @@
+// Package zmq provides a minimal ZeroMQ (ZMTP 3.0) implementation, with the
+// NULL security mechanism, that exchanges single-part messages through a
+// net.Conn with the semantics of PUB, SUB, REQ and REP sockets.
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxMessageSize is the maximum size of incoming messages.
+	MaxMessageSize = 64 * 1024
+
+	greetingSize = 64
+
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+)
+
+// socket types.
+const (
+	TypePub = "PUB"
+	TypeSub = "SUB"
+	TypeReq = "REQ"
+	TypeRep = "REP"
+)
+
+// the socket types that can be connected to each socket type.
+var compatibleTypes = map[string][]string{
+	TypePub: {"SUB", "XSUB"},
+	TypeSub: {"PUB", "XPUB"},
+	TypeReq: {"REP", "ROUTER"},
+	TypeRep: {"REQ", "DEALER"},
+}
+
+// ErrState is returned by Write when the message can't be sent in the current
+// state of the socket, i.e. a REQ socket that is waiting for a reply, or a
+// REP socket that has not received any request.
+var ErrState = fmt.Errorf("operation cannot be accomplished in current state")
+
+func greeting() []byte {
+	g := make([]byte, greetingSize)
+	g[0] = 0xFF
+	g[9] = 0x7F
+	g[10] = 3 // version 3.0
+	g[11] = 0
+	copy(g[12:32], "NULL")
+	return g
+}
+
+// Conn is a ZeroMQ connection. Each Write() is sent as a single-part
+// message, and each Read() returns the content of a single-part message,
+// unless the buffer is too small to contain it. Multi-part messages are
+// discarded.
+// It implements net.Conn.
+type Conn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	socketType string
+	peerType   string
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+
+	// REQ: whether a request has been sent and the reply has not been received.
+	// REP: the envelope of the request that has not been answered, or nil.
+	stateMutex     sync.Mutex
+	waitingReply   bool
+	pendingRequest [][]byte
+
+	// PUB: the topics to which the peer is subscribed
+	subsMutex sync.Mutex
+	subs      [][]byte
+
+	// accessed by Read() only
+	pending []byte
+}
+
+// Handshake performs the greeting and the handshake of a connection, with
+// the given socket type, and returns a Conn. The socket type of the peer
+// must be compatible.
+func Handshake(nconn net.Conn, socketType string, timeout time.Duration) (*Conn, error) {
+	compatible, ok := compatibleTypes[socketType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported socket type: %s", socketType)
+	}
+
+	nconn.SetDeadline(time.Now().Add(timeout))
+	defer nconn.SetDeadline(time.Time{})
+
+	c := &Conn{
+		conn:       nconn,
+		reader:     bufio.NewReader(nconn),
+		socketType: socketType,
+	}
+
+	_, err := nconn.Write(greeting())
+	if err != nil {
+		return nil, err
+	}
+
+	var peer [greetingSize]byte
+	_, err = io.ReadFull(c.reader, peer[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if peer[0] != 0xFF || (peer[9]&0x01) == 0 {
+		return nil, fmt.Errorf("invalid greeting")
+	}
+	if peer[10] < 3 {
+		return nil, fmt.Errorf("unsupported version: %d", peer[10])
+	}
+	if mech := string(bytes.TrimRight(peer[12:32], "\x00")); mech != "NULL" {
+		return nil, fmt.Errorf("unsupported security mechanism: %s", mech)
+	}
+
+	err = c.writeFrame(flagCommand, readyCommand(socketType))
+	if err != nil {
+		return nil, err
+	}
+
+	flags, body, err := c.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	name, data, ok := parseCommand(flags, body)
+	if !ok || name != "READY" {
+		return nil, fmt.Errorf("READY command not received")
+	}
+
+	props, ok := parseProperties(data)
+	if !ok {
+		return nil, fmt.Errorf("invalid READY command")
+	}
+
+	c.peerType = props["Socket-Type"]
+	if !contains(compatible, c.peerType) {
+		return nil, fmt.Errorf("socket type %s is not compatible with %s", c.peerType, socketType)
+	}
+
+	// subscribe to all messages
+	if socketType == TypeSub {
+		err = c.writeMessage([][]byte{{0x01}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func readyCommand(socketType string) []byte {
+	var body []byte
+	body = append(body, 5)
+	body = append(body, "READY"...)
+	body = append(body, byte(len("Socket-Type")))
+	body = append(body, "Socket-Type"...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(socketType)))
+	body = append(body, size[:]...)
+	body = append(body, socketType...)
+	return body
+}
+
+func parseCommand(flags byte, body []byte) (string, []byte, bool) {
+	if (flags&flagCommand) == 0 || len(body) < 1 || len(body) < 1+int(body[0]) {
+		return "", nil, false
+	}
+	return string(body[1 : 1+body[0]]), body[1+body[0]:], true
+}
+
+func parseProperties(data []byte) (map[string]string, bool) {
+	props := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+4 {
+			return nil, false
+		}
+		name := string(data[1 : 1+nameLen])
+		data = data[1+nameLen:]
+
+		valueLen := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(valueLen) {
+			return nil, false
+		}
+		props[name] = string(data[:valueLen])
+		data = data[valueLen:]
+	}
+	return props, true
+}
+
+// PeerType returns the socket type of the peer.
+func (c *Conn) PeerType() string {
+	return c.peerType
+}
+
+func (c *Conn) readFrame() (byte, []byte, error) {
+	flags, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var size uint64
+	if (flags & flagLong) != 0 {
+		var ext [8]byte
+		_, err := io.ReadFull(c.reader, ext[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	} else {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+
+	if size > MaxMessageSize {
+		return 0, nil, fmt.Errorf("message too big")
+	}
+
+	body := make([]byte, size)
+	_, err = io.ReadFull(c.reader, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return flags, body, nil
+}
+
+// readMessage reads a multi-part message, and handles commands.
+func (c *Conn) readMessage() ([][]byte, error) {
+	var parts [][]byte
+	size := 0
+
+	for {
+		flags, body, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if (flags & flagCommand) != 0 {
+			if len(parts) != 0 {
+				return nil, fmt.Errorf("unexpected command")
+			}
+			err := c.onCommand(flags, body)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		size += len(body)
+		if size > MaxMessageSize {
+			return nil, fmt.Errorf("message too big")
+		}
+		parts = append(parts, body)
+
+		if (flags & flagMore) == 0 {
+			return parts, nil
+		}
+	}
+}
+
+func (c *Conn) onCommand(flags byte, body []byte) error {
+	name, data, ok := parseCommand(flags, body)
+	if !ok {
+		return fmt.Errorf("invalid command")
+	}
+
+	switch name {
+	case "PING":
+		// the context follows the TTL
+		if len(data) < 2 {
+			return fmt.Errorf("invalid PING command")
+		}
+		pong := append([]byte{4}, "PONG"...)
+		return c.writeFrame(flagCommand, append(pong, data[2:]...))
+
+	case "SUBSCRIBE":
+		c.subscribe(data)
+
+	case "CANCEL":
+		c.unsubscribe(data)
+	}
+
+	// other commands are ignored
+	return nil
+}
+
+func (c *Conn) subscribe(topic []byte) {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	c.subs = append(c.subs, append([]byte(nil), topic...))
+}
+
+func (c *Conn) unsubscribe(topic []byte) {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	for i, s := range c.subs {
+		if bytes.Equal(s, topic) {
+			c.subs = append(c.subs[:i], c.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Conn) subscribed(msg []byte) bool {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	for _, s := range c.subs {
+		if bytes.HasPrefix(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// readBody reads the next message addressed to the user. In case of PUB
+// sockets, it processes subscriptions and never returns.
+func (c *Conn) readBody() ([]byte, error) {
+	for {
+		parts, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch c.socketType {
+		case TypePub:
+			// subscriptions in the ZMTP 3.0 format
+			if len(parts) == 1 && len(parts[0]) >= 1 {
+				switch parts[0][0] {
+				case 0x01:
+					c.subscribe(parts[0][1:])
+				case 0x00:
+					c.unsubscribe(parts[0][1:])
+				}
+			}
+
+		case TypeSub:
+			if len(parts) == 1 {
+				return parts[0], nil
+			}
+
+		case TypeReq:
+			// the reply is preceded by an empty delimiter
+			if len(parts) != 2 || len(parts[0]) != 0 {
+				continue
+			}
+
+			c.stateMutex.Lock()
+			waiting := c.waitingReply
+			c.waitingReply = false
+			c.stateMutex.Unlock()
+
+			if waiting {
+				return parts[1], nil
+			}
+
+		case TypeRep:
+			// the request is preceded by an envelope that ends with an
+			// empty delimiter
+			i := 0
+			for i < len(parts) && len(parts[i]) != 0 {
+				i++
+			}
+			if i != len(parts)-2 {
+				continue
+			}
+
+			c.stateMutex.Lock()
+			c.pendingRequest = parts[:i+1]
+			c.stateMutex.Unlock()
+
+			return parts[i+1], nil
+		}
+	}
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(buf []byte) (int, error) {
+	for len(c.pending) == 0 {
+		body, err := c.readBody()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = body
+	}
+
+	n := copy(buf, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write implements net.Conn.
+// In case of PUB sockets, the message is sent only if the peer is subscribed
+// to it. In case of REQ sockets, it returns ErrState if the reply to the
+// previous request has not been received. In case of REP sockets, the
+// message is sent as reply to the last request, and it returns ErrState if
+// there are no requests to reply to. SUB sockets can't write messages.
+func (c *Conn) Write(buf []byte) (int, error) {
+	var parts [][]byte
+
+	switch c.socketType {
+	case TypePub:
+		if !c.subscribed(buf) {
+			return len(buf), nil
+		}
+		parts = [][]byte{buf}
+
+	case TypeSub:
+		return 0, ErrState
+
+	case TypeReq:
+		c.stateMutex.Lock()
+		if c.waitingReply {
+			c.stateMutex.Unlock()
+			return 0, ErrState
+		}
+		c.waitingReply = true
+		c.stateMutex.Unlock()
+
+		parts = [][]byte{{}, buf}
+
+	case TypeRep:
+		c.stateMutex.Lock()
+		envelope := c.pendingRequest
+		c.pendingRequest = nil
+		c.stateMutex.Unlock()
+
+		if envelope == nil {
+			return 0, ErrState
+		}
+		parts = append(envelope, buf)
+	}
+
+	err := c.writeMessage(parts)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (c *Conn) writeMessage(parts [][]byte) error {
+	for i, p := range parts {
+		var flags byte
+		if i != (len(parts) - 1) {
+			flags |= flagMore
+		}
+		err := c.writeFrame(flags, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) writeFrame(flags byte, body []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	frame := make([]byte, 0, 9+len(body))
+
+	if len(body) > 255 {
+		frame = append(frame, flags|flagLong, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[1:], uint64(len(body)))
+	} else {
+		frame = append(frame, flags, byte(len(body)))
+	}
+	frame = append(frame, body...)
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close implements net.Conn.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// LocalAddr implements net.Conn.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
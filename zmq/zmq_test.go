@@ -0,0 +1,204 @@
+package zmq
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPair(t *testing.T, serverType string, clientType string) (*Conn, *Conn) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	serverRes := make(chan result)
+
+	go func() {
+		nconn, err := l.Accept()
+		if err != nil {
+			serverRes <- result{nil, err}
+			return
+		}
+		conn, err := Handshake(nconn, serverType, 1*time.Second)
+		if err != nil {
+			nconn.Close()
+		}
+		serverRes <- result{conn, err}
+	}()
+
+	nconn, err := net.Dial("tcp4", l.Addr().String())
+	require.NoError(t, err)
+
+	client, err := Handshake(nconn, clientType, 1*time.Second)
+	require.NoError(t, err)
+
+	res := <-serverRes
+	require.NoError(t, res.err)
+
+	return res.conn, client
+}
+
+func TestPubSub(t *testing.T) {
+	pub, sub := testPair(t, TypePub, TypeSub)
+	defer pub.Close()
+	defer sub.Close()
+
+	require.Equal(t, TypeSub, pub.PeerType())
+	require.Equal(t, TypePub, sub.PeerType())
+
+	// process subscriptions
+	go pub.Read(make([]byte, 1024))
+
+	buf := make([]byte, 1024)
+	msg := []byte("testing")
+
+	received := make(chan []byte)
+	go func() {
+		n, err := sub.Read(buf)
+		if err != nil {
+			close(received)
+			return
+		}
+		received <- buf[:n]
+	}()
+
+	// messages are published once the subscription has been received
+	for {
+		_, err := pub.Write(msg)
+		require.NoError(t, err)
+
+		select {
+		case recv := <-received:
+			require.Equal(t, msg, recv)
+			_, err := sub.Write(msg)
+			require.Equal(t, ErrState, err)
+			return
+
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestReqRep(t *testing.T) {
+	rep, req := testPair(t, TypeRep, TypeReq)
+	defer rep.Close()
+	defer req.Close()
+
+	buf := make([]byte, 1024)
+
+	// a REP socket can't reply without requests
+	_, err := rep.Write([]byte("reply"))
+	require.Equal(t, ErrState, err)
+
+	_, err = req.Write([]byte("request"))
+	require.NoError(t, err)
+
+	// a REQ socket can't send a request before receiving the reply
+	_, err = req.Write([]byte("request2"))
+	require.Equal(t, ErrState, err)
+
+	n, err := rep.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("request"), buf[:n])
+
+	_, err = rep.Write([]byte("reply"))
+	require.NoError(t, err)
+
+	n, err = req.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("reply"), buf[:n])
+
+	_, err = req.Write([]byte("request3"))
+	require.NoError(t, err)
+}
+
+func TestIncompatibleTypes(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		nconn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nconn.Close()
+		Handshake(nconn, TypePub, 1*time.Second)
+	}()
+
+	nconn, err := net.Dial("tcp4", l.Addr().String())
+	require.NoError(t, err)
+	defer nconn.Close()
+
+	_, err = Handshake(nconn, TypeReq, 1*time.Second)
+	require.EqualError(t, err, "socket type PUB is not compatible with REQ")
+}
+
+// the peer is emulated with raw bytes, in order to check the wire format.
+func TestWireFormat(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// greeting
+		greet := make([]byte, greetingSize)
+		_, err := io.ReadFull(server, greet)
+		require.NoError(t, err)
+		require.Equal(t, greeting(), greet)
+
+		_, err = server.Write(greeting())
+		require.NoError(t, err)
+
+		// READY
+		ready := make([]byte, 2+25)
+		_, err = io.ReadFull(server, ready)
+		require.NoError(t, err)
+		require.Equal(t, append([]byte{0x04, 25, 5, 'R', 'E', 'A', 'D', 'Y',
+			11, 'S', 'o', 'c', 'k', 'e', 't', '-', 'T', 'y', 'p', 'e',
+			0, 0, 0, 3}, "SUB"...), ready)
+
+		_, err = server.Write(append([]byte{0x04, 25, 5, 'R', 'E', 'A', 'D', 'Y',
+			11, 'S', 'o', 'c', 'k', 'e', 't', '-', 'T', 'y', 'p', 'e',
+			0, 0, 0, 3}, "PUB"...))
+		require.NoError(t, err)
+
+		// subscription to all messages
+		sub := make([]byte, 3)
+		_, err = io.ReadFull(server, sub)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x00, 0x01, 0x01}, sub)
+
+		// PING, with a TTL and a context
+		_, err = server.Write([]byte{0x04, 8, 4, 'P', 'I', 'N', 'G', 0, 0, 'x'})
+		require.NoError(t, err)
+
+		pong := make([]byte, 8)
+		_, err = io.ReadFull(server, pong)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x04, 6, 4, 'P', 'O', 'N', 'G', 'x'}, pong)
+
+		// a multi-part message, that is discarded, and a single-part message
+		_, err = server.Write([]byte{0x01, 1, 'a', 0x00, 1, 'b', 0x00, 1, 'c'})
+		require.NoError(t, err)
+	}()
+
+	conn, err := Handshake(client, TypeSub, 1*time.Second)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), buf[:n])
+
+	<-done
+}